@@ -2,10 +2,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/SIM-MBKM/filestorage/route"
 	"github.com/SIM-MBKM/filestorage/storage" // Import storage package directly
 	"github.com/gin-gonic/gin"
 )
@@ -105,7 +107,7 @@ func main() {
 	r.GET("/s3/info/:fileId", func(c *gin.Context) {
 		fileId := c.Param("fileId")
 
-		result, err := fs.AwsGetFileById(fileId, "")
+		result, err := fs.AwsGetFileInfo(fileId, "")
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
@@ -120,7 +122,7 @@ func main() {
 		// get from query
 		fileId := c.Query("fileId")
 
-		result, err := fs.GcsGetFileById(fileId, "", "")
+		result, err := fs.GcsGetFileInfo(fileId, "", "")
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
@@ -200,7 +202,9 @@ func main() {
 		c.JSON(200, result)
 	})
 
-	// Start the example server
+	// Start the example server, shutting down gracefully on SIGTERM
 	fmt.Println("Starting example server on :8000")
-	r.Run(":8000")
+	if err := route.Serve(context.Background(), ":8000", r, fs, 0); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
 }
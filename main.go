@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 
@@ -33,10 +34,38 @@ func main() {
 	// Initialize file storage manager
 	fs := storage.NewFileStorageManager(config, tokenManager)
 
+	// Record uploads so identical content is deduplicated, in a journal file
+	// surviving restarts when FILE_STORAGE_METADATA_PATH is set
+	var metadataStore storage.MetadataStore = storage.NewMemoryMetadataStore()
+	if config.MetadataPath != "" {
+		metadataStore, err = storage.NewFileMetadataStore(config.MetadataPath)
+		if err != nil {
+			log.Fatalf("Failed to open metadata store: %v", err)
+		}
+	}
+	fs.SetMetadataStore(metadataStore)
+
+	// Audit downloads, signed URLs and deletes when FILE_STORAGE_AUDIT_LOG is set
+	if config.AuditLogPath != "" {
+		auditLog, err := storage.NewFileAuditLog(config.AuditLogPath)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		fs.SetAuditLog(auditLog)
+	}
+
+	// Load the base path and exposed endpoints
+	routeConfig, err := route.LoadRouteConfig()
+	if err != nil {
+		log.Fatalf("Failed to load route config: %v", err)
+	}
+
 	// Set up router with all routes and middleware
-	r := route.SetupRouter(fs, secretKey, expireSeconds)
+	r := route.SetupRouter(fs, secretKey, expireSeconds, route.WithRouteConfig(routeConfig))
 
-	// Start the server
-	fmt.Println("Starting server on :8000")
-	r.Run(":" + port)
+	// Start the server, finishing in-flight uploads on SIGTERM before exiting
+	fmt.Println("Starting server on :" + port)
+	if err := route.Serve(context.Background(), ":"+port, r, fs, routeConfig.ShutdownTimeout); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
 }
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context keys handlers and authentication middleware can set to enrich the access log
+const (
+	ClientIDKey = "client_id"
+	FileIDKey   = "file_id"
+	ProviderKey = "provider"
+)
+
+// AccessLog writes one structured record per request to logger, or as JSON
+// to standard output when logger is nil. The client, file and provider are
+// taken from the context keys when set, falling back to the request.
+func AccessLog(logger *slog.Logger) gin.HandlerFunc {
+	if logger == nil {
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		} else if status >= 400 {
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Int("size", c.Writer.Size()),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+		}
+
+		for _, attr := range []struct{ key, value string }{
+			{ClientIDKey, contextValue(c, ClientIDKey, c.GetHeader("X-Client-ID"))},
+			{FileIDKey, contextValue(c, FileIDKey, RequestFileID(c))},
+			{ProviderKey, contextValue(c, ProviderKey, RequestProvider(c))},
+		} {
+			if attr.value != "" {
+				attrs = append(attrs, slog.String(attr.key, attr.value))
+			}
+		}
+
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// contextValue returns the string set under key, or fallback
+func contextValue(c *gin.Context, key, fallback string) string {
+	if value := c.GetString(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// RequestFileID returns the file ID passed in the path or query, if any
+func RequestFileID(c *gin.Context) string {
+	for _, name := range []string{"id", "fileId"} {
+		if value := strings.TrimPrefix(c.Param(name), "/"); value != "" {
+			return value
+		}
+	}
+	if value := c.Query("fileId"); value != "" {
+		return value
+	}
+	return c.Query("file_id")
+}
+
+// RequestProvider returns the provider named in the path or query or implied by the path, if any
+func RequestProvider(c *gin.Context) string {
+	if value := c.Param("provider"); value != "" {
+		return value
+	}
+	if value := c.Query("provider"); value != "" {
+		return value
+	}
+
+	route := c.FullPath()
+	switch {
+	case strings.Contains(route, "/s3/"):
+		return "s3"
+	case strings.Contains(route, "/gcs/"):
+		return "gcs"
+	}
+	return ""
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminKey rejects requests whose X-Admin-Key header does not match key,
+// guarding endpoints that expose the internals of the service
+func AdminKey(key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given := c.GetHeader("X-Admin-Key")
+		if key == "" || subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorBody(c, CodeAdminKeyRequired, "admin key required"))
+			return
+		}
+
+		c.Next()
+	}
+}
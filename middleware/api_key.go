@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyAuthenticator resolves API key secrets, e.g. a storage.FileStorageManager
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(secret string) (*storage.APIKey, error)
+}
+
+// APIKey rejects requests without a valid X-API-Key header. The key's ID and
+// scopes are set in the context under SubjectKey and ScopesKey, and its name
+// is logged as the client ID.
+func APIKey(keys APIKeyAuthenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := c.GetHeader("X-API-Key")
+		if secret == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorBody(c, CodeAPIKeyRequired, "API key required"))
+			return
+		}
+
+		key, err := keys.AuthenticateAPIKey(secret)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorBody(c, CodeAPIKeyInvalid, err.Error()))
+			return
+		}
+
+		c.Set(SubjectKey, key.ID)
+		c.Set(ClientIDKey, key.Name)
+		c.Set(ScopesKey, key.Scopes)
+		c.Next()
+	}
+}
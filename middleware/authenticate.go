@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Authentication methods accepted by Authenticate
+const (
+	MethodAccessKey = "access-key" // mod-service Access-Key header signed with APP_KEY
+	MethodAPIKey    = "api-key"    // X-API-Key header issued by the service
+	MethodJWT       = "jwt"        // Bearer JWT verified against a JWKS
+)
+
+// Authenticator is an authentication method that can be stacked with others
+type Authenticator struct {
+	Name    string                    // e.g. MethodJWT, reported when a request sends no credentials
+	Present func(c *gin.Context) bool // Reports whether a request carries credentials for the method
+	Handler gin.HandlerFunc           // Authenticates the request, aborting it when the credentials are invalid
+}
+
+// Authenticate authenticates requests with the first method whose
+// credentials they carry, so clients can use any of the accepted methods.
+// Requests without credentials for any of them are rejected.
+func Authenticate(methods ...Authenticator) gin.HandlerFunc {
+	names := make([]string, len(methods))
+	for i, method := range methods {
+		names[i] = method.Name
+	}
+	required := "authentication required: " + strings.Join(names, ", ")
+
+	return func(c *gin.Context) {
+		for _, method := range methods {
+			if method.Present(c) {
+				method.Handler(c)
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorBody(c, CodeAuthenticationRequired, required))
+	}
+}
+
+// HeaderPresent returns a Present function reporting whether a request sends header
+func HeaderPresent(header string) func(c *gin.Context) bool {
+	return func(c *gin.Context) bool {
+		return c.GetHeader(header) != ""
+	}
+}
+
+// APIKeyMethod accepts the X-API-Key header, see APIKey
+func APIKeyMethod(keys APIKeyAuthenticator) Authenticator {
+	return Authenticator{Name: MethodAPIKey, Present: HeaderPresent("X-API-Key"), Handler: APIKey(keys)}
+}
+
+// JWTMethod accepts a bearer JWT in the Authorization header, see JWT
+func JWTMethod(config JWTConfig) Authenticator {
+	return Authenticator{
+		Name: MethodJWT,
+		Present: func(c *gin.Context) bool {
+			return strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ")
+		},
+		Handler: JWT(config),
+	}
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead is the allowance for multipart boundaries and form fields
+// on top of the file size itself
+const multipartOverhead = 1 << 20
+
+// MaxUploadSize rejects requests whose body exceeds the file size limit
+// before the body is read. The limit is read for every request, so changes
+// to it apply right away. A limit of 0 disables the check.
+func MaxUploadSize(limit func() int64) gin.HandlerFunc {
+	return maxBodySize(limit, 1)
+}
+
+// MaxBatchUploadSize is MaxUploadSize for requests carrying up to files files
+// of limit bytes each, e.g. files[] uploads. Handlers check each file against
+// the limit themselves.
+func MaxBatchUploadSize(limit func() int64, files int) gin.HandlerFunc {
+	return maxBodySize(limit, files)
+}
+
+// maxBodySize rejects requests whose body exceeds files files of limit bytes
+func maxBodySize(maxFileSize func() int64, files int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := maxFileSize()
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+
+		maxBody := limit*int64(files) + multipartOverhead
+		if c.Request.ContentLength > maxBody {
+			message := fmt.Sprintf("file exceeds maximum upload size of %d bytes", limit)
+			if files > 1 {
+				message = fmt.Sprintf("request exceeds maximum upload size of %d files of %d bytes", files, limit)
+			}
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, ErrorBody(c, CodeFileTooLarge, message))
+			return
+		}
+
+		// Guard against missing or lying Content-Length headers
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBody)
+
+		c.Next()
+	}
+}
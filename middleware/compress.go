@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compressMinSize is the smallest response worth compressing
+const compressMinSize = 1024
+
+// Pools of encoders, which allocate large buffers
+var (
+	gzipWriters  = sync.Pool{New: func() interface{} { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}
+	flateWriters = sync.Pool{New: func() interface{} { w, _ := flate.NewWriter(nil, flate.DefaultCompression); return w }}
+)
+
+// Compress compresses JSON responses of at least 1 KiB with gzip or deflate,
+// as accepted by the client. File downloads and other content are sent
+// unchanged, since they are usually compressed already.
+func Compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := acceptedEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		defer w.finish()
+
+		c.Next()
+	}
+}
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip, or returns "" when neither is accepted
+func acceptedEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		if found {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(name)] = true
+	}
+
+	switch {
+	case accepted["gzip"] || accepted["*"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter buffers the start of a response to decide whether to
+// compress it, which only happens for JSON of at least compressMinSize bytes
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+
+	decided bool
+	pending []byte
+	encoder io.WriteCloser
+}
+
+// Write buffers or compresses JSON and passes anything else through
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		if !w.compressible() {
+			w.decided = true
+			return w.ResponseWriter.Write(data)
+		}
+		if w.pending == nil {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+
+		w.pending = append(w.pending, data...)
+		if len(w.pending) < compressMinSize {
+			return len(data), nil
+		}
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString implements gin.ResponseWriter
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what is buffered, compressing it if it was compressible
+func (w *compressWriter) Flush() {
+	if !w.decided && len(w.pending) > 0 {
+		w.start(true)
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Hijack implements http.Hijacker, leaving the connection uncompressed
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.decided = true
+	return w.ResponseWriter.Hijack()
+}
+
+// compressible reports whether the response is JSON that is not encoded yet
+func (w *compressWriter) compressible() bool {
+	status := w.Status()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// start writes the pending bytes, compressed or as they are
+func (w *compressWriter) start(compress bool) error {
+	w.decided = true
+	pending := w.pending
+	w.pending = nil
+
+	if compress {
+		header := w.Header()
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+
+		switch w.encoding {
+		case "gzip":
+			encoder := gzipWriters.Get().(*gzip.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		default:
+			encoder := flateWriters.Get().(*flate.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		}
+
+		_, err := w.encoder.Write(pending)
+		return err
+	}
+
+	_, err := w.ResponseWriter.Write(pending)
+	return err
+}
+
+// finish sends a response too small to compress or completes the compressed stream
+func (w *compressWriter) finish() {
+	if !w.decided {
+		if len(w.pending) > 0 {
+			w.start(false)
+		}
+		return
+	}
+
+	if w.encoder == nil {
+		return
+	}
+	w.encoder.Close()
+	switch encoder := w.encoder.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(encoder)
+	case *flate.Writer:
+		flateWriters.Put(encoder)
+	}
+	w.encoder = nil
+}
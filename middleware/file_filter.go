@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// FileTypeFilter rejects multipart uploads whose "file" or "files[]" fields are
+// not permitted by the filter, before the handler passes them on to a storage provider
+func FileTypeFilter(filter *storage.FileFilter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if filter == nil {
+			c.Next()
+			return
+		}
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, ErrorBody(c, CodeFileTooLarge, storage.ErrFileTooLarge.Error()))
+				return
+			}
+
+			// Let the handler report missing or malformed files
+			c.Next()
+			return
+		}
+
+		for _, file := range append(form.File["file"], form.File["files[]"]...) {
+			if err := filter.Check(file.Filename, file.Header.Get("Content-Type")); err != nil {
+				c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, ErrorBody(c, CodeFileTypeNotAllowed, err.Error()))
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,334 @@
+package middleware
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Languages error messages are available in
+const (
+	LanguageEnglish    = "en"
+	LanguageIndonesian = "id"
+)
+
+// LanguageKey is the context key of the language negotiated by Localize
+const LanguageKey = "language"
+
+// Error codes of responses, stable across languages and releases so clients
+// can act on them while showing the localized message
+const (
+	CodeAuthenticationRequired = "authentication_required"
+	CodeAPIKeyRequired         = "api_key_required"
+	CodeAPIKeyInvalid          = "api_key_invalid"
+	CodeBearerTokenRequired    = "bearer_token_required"
+	CodeTokenInvalid           = "token_invalid"
+	CodeAdminKeyRequired       = "admin_key_required"
+	CodeMissingScope           = "missing_scope"
+	CodeRateLimited            = "rate_limited"
+	CodeFileTooLarge           = "file_too_large"
+	CodeFileTypeNotAllowed     = "file_type_not_allowed"
+	CodeFileTypeMismatch       = "file_type_mismatch"
+	CodeFileInfected           = "file_infected"
+	CodeInvalidImage           = "invalid_image"
+	CodeInvalidArchive         = "invalid_archive"
+	CodeArchiveTooLarge        = "archive_too_large"
+	CodeTooManyFiles           = "too_many_files"
+	CodeFileNotFound           = "file_not_found"
+	CodeFileExists             = "file_exists"
+	CodeFolderNotFound         = "folder_not_found"
+	CodeFolderExists           = "folder_exists"
+	CodeFolderNotEmpty         = "folder_not_empty"
+	CodeLinkNotFound           = "link_not_found"
+	CodeLinkExpired            = "link_expired"
+	CodeLinkExhausted          = "link_exhausted"
+	CodeLinkAccessDenied       = "link_access_denied"
+	CodeDownloadTokenInvalid   = "download_token_invalid"
+	CodeNotOwner               = "not_owner"
+	CodeUploadIncomplete       = "upload_incomplete"
+	CodeUploadExpired          = "upload_expired"
+	CodeServiceBusy            = "service_busy"
+	CodeNotConfigured          = "not_configured"
+	CodeInvalidRequest         = "invalid_request"
+	CodeValidationFailed       = "validation_failed"
+	CodeNotFound               = "not_found"
+	CodeProviderFailed         = "provider_failed"
+	CodeInternalError          = "internal_error"
+)
+
+// Codes of field-level validation errors
+const (
+	CodeRequired             = "required"
+	CodeInvalidValue         = "invalid_value"
+	CodeTooSmall             = "too_small"
+	CodeTooLarge             = "too_large"
+	CodeInvalidEmail         = "invalid_email"
+	CodeInvalidType          = "invalid_type"
+	CodeInvalidJSON          = "invalid_json"
+	CodeInvalidBase64        = "invalid_base64"
+	CodeUnsupportedExtension = "unsupported_extension"
+	CodeUnknownField         = "unknown_field"
+)
+
+// messages holds the user-facing message of each error code by language
+var messages = map[string]map[string]string{
+	CodeAuthenticationRequired: {
+		LanguageEnglish:    "Please sign in to continue.",
+		LanguageIndonesian: "Silakan masuk untuk melanjutkan.",
+	},
+	CodeAPIKeyRequired: {
+		LanguageEnglish:    "An API key is required.",
+		LanguageIndonesian: "Kunci API diperlukan.",
+	},
+	CodeAPIKeyInvalid: {
+		LanguageEnglish:    "The API key is invalid or has been revoked.",
+		LanguageIndonesian: "Kunci API tidak valid atau telah dicabut.",
+	},
+	CodeBearerTokenRequired: {
+		LanguageEnglish:    "Please sign in to continue.",
+		LanguageIndonesian: "Silakan masuk untuk melanjutkan.",
+	},
+	CodeTokenInvalid: {
+		LanguageEnglish:    "Your session is invalid or has expired. Please sign in again.",
+		LanguageIndonesian: "Sesi Anda tidak valid atau telah berakhir. Silakan masuk kembali.",
+	},
+	CodeAdminKeyRequired: {
+		LanguageEnglish:    "This action requires administrator access.",
+		LanguageIndonesian: "Tindakan ini memerlukan akses administrator.",
+	},
+	CodeMissingScope: {
+		LanguageEnglish:    "You do not have permission to perform this action.",
+		LanguageIndonesian: "Anda tidak memiliki izin untuk melakukan tindakan ini.",
+	},
+	CodeRateLimited: {
+		LanguageEnglish:    "Too many requests. Please try again in a moment.",
+		LanguageIndonesian: "Terlalu banyak permintaan. Silakan coba lagi sebentar lagi.",
+	},
+	CodeFileTooLarge: {
+		LanguageEnglish:    "The file is larger than the maximum upload size.",
+		LanguageIndonesian: "Ukuran file melebihi batas maksimum unggahan.",
+	},
+	CodeFileTypeNotAllowed: {
+		LanguageEnglish:    "This file type is not allowed.",
+		LanguageIndonesian: "Jenis file ini tidak diizinkan.",
+	},
+	CodeFileTypeMismatch: {
+		LanguageEnglish:    "The file content does not match its extension.",
+		LanguageIndonesian: "Isi file tidak sesuai dengan ekstensinya.",
+	},
+	CodeFileInfected: {
+		LanguageEnglish:    "The file was rejected because it contains malware.",
+		LanguageIndonesian: "File ditolak karena mengandung malware.",
+	},
+	CodeInvalidImage: {
+		LanguageEnglish:    "The image is damaged or in an unsupported format.",
+		LanguageIndonesian: "Gambar rusak atau formatnya tidak didukung.",
+	},
+	CodeInvalidArchive: {
+		LanguageEnglish:    "The archive is damaged or contains unsafe entries.",
+		LanguageIndonesian: "Arsip rusak atau berisi entri yang tidak aman.",
+	},
+	CodeArchiveTooLarge: {
+		LanguageEnglish:    "The archive contains too many or too large files.",
+		LanguageIndonesian: "Arsip berisi terlalu banyak file atau file yang terlalu besar.",
+	},
+	CodeTooManyFiles: {
+		LanguageEnglish:    "Too many files were selected at once.",
+		LanguageIndonesian: "Terlalu banyak file dipilih sekaligus.",
+	},
+	CodeFileNotFound: {
+		LanguageEnglish:    "The file was not found. It may have been deleted.",
+		LanguageIndonesian: "File tidak ditemukan. File mungkin telah dihapus.",
+	},
+	CodeFileExists: {
+		LanguageEnglish:    "Another file has been stored under this name.",
+		LanguageIndonesian: "File lain telah disimpan dengan nama ini.",
+	},
+	CodeFolderNotFound: {
+		LanguageEnglish:    "The folder was not found. It may have been deleted.",
+		LanguageIndonesian: "Folder tidak ditemukan. Folder mungkin telah dihapus.",
+	},
+	CodeFolderExists: {
+		LanguageEnglish:    "Another folder here already has this name.",
+		LanguageIndonesian: "Folder lain di sini sudah menggunakan nama ini.",
+	},
+	CodeFolderNotEmpty: {
+		LanguageEnglish:    "The folder is not empty. Move or delete its content first.",
+		LanguageIndonesian: "Folder tidak kosong. Pindahkan atau hapus isinya terlebih dahulu.",
+	},
+	CodeLinkNotFound: {
+		LanguageEnglish:    "The link was not found.",
+		LanguageIndonesian: "Tautan tidak ditemukan.",
+	},
+	CodeLinkExpired: {
+		LanguageEnglish:    "The link has expired.",
+		LanguageIndonesian: "Tautan telah kedaluwarsa.",
+	},
+	CodeLinkExhausted: {
+		LanguageEnglish:    "The link has reached its download limit.",
+		LanguageIndonesian: "Tautan telah mencapai batas unduhan.",
+	},
+	CodeLinkAccessDenied: {
+		LanguageEnglish:    "Access to the link was denied. Check the password or ask the owner to share it with you.",
+		LanguageIndonesian: "Akses ke tautan ditolak. Periksa kata sandi atau minta pemilik membagikannya kepada Anda.",
+	},
+	CodeDownloadTokenInvalid: {
+		LanguageEnglish:    "The download link is invalid or has expired.",
+		LanguageIndonesian: "Tautan unduhan tidak valid atau telah kedaluwarsa.",
+	},
+	CodeNotOwner: {
+		LanguageEnglish:    "You do not have access to this file.",
+		LanguageIndonesian: "Anda tidak memiliki akses ke file ini.",
+	},
+	CodeUploadIncomplete: {
+		LanguageEnglish:    "The upload did not finish. Please upload the file again.",
+		LanguageIndonesian: "Unggahan belum selesai. Silakan unggah ulang file.",
+	},
+	CodeUploadExpired: {
+		LanguageEnglish:    "The upload took too long and has expired. Please upload the file again.",
+		LanguageIndonesian: "Unggahan terlalu lama dan sudah kedaluwarsa. Silakan unggah ulang file.",
+	},
+	CodeServiceBusy: {
+		LanguageEnglish:    "The service is busy. Please try again in a moment.",
+		LanguageIndonesian: "Layanan sedang sibuk. Silakan coba lagi sebentar lagi.",
+	},
+	CodeNotConfigured: {
+		LanguageEnglish:    "This feature is not available.",
+		LanguageIndonesian: "Fitur ini tidak tersedia.",
+	},
+	CodeInvalidRequest: {
+		LanguageEnglish:    "The request is invalid.",
+		LanguageIndonesian: "Permintaan tidak valid.",
+	},
+	CodeValidationFailed: {
+		LanguageEnglish:    "Some fields are invalid.",
+		LanguageIndonesian: "Beberapa isian tidak valid.",
+	},
+	CodeNotFound: {
+		LanguageEnglish:    "The requested resource was not found.",
+		LanguageIndonesian: "Data yang diminta tidak ditemukan.",
+	},
+	CodeProviderFailed: {
+		LanguageEnglish:    "The storage provider could not complete the request. Please try again later.",
+		LanguageIndonesian: "Penyedia penyimpanan tidak dapat memproses permintaan. Silakan coba lagi nanti.",
+	},
+	CodeInternalError: {
+		LanguageEnglish:    "Something went wrong. Please try again later.",
+		LanguageIndonesian: "Terjadi kesalahan. Silakan coba lagi nanti.",
+	},
+	CodeRequired: {
+		LanguageEnglish:    "This field is required.",
+		LanguageIndonesian: "Isian ini wajib diisi.",
+	},
+	CodeInvalidValue: {
+		LanguageEnglish:    "This value is not allowed.",
+		LanguageIndonesian: "Nilai ini tidak diizinkan.",
+	},
+	CodeTooSmall: {
+		LanguageEnglish:    "This value is too small.",
+		LanguageIndonesian: "Nilai ini terlalu kecil.",
+	},
+	CodeTooLarge: {
+		LanguageEnglish:    "This value is too large.",
+		LanguageIndonesian: "Nilai ini terlalu besar.",
+	},
+	CodeInvalidEmail: {
+		LanguageEnglish:    "Enter a valid email address.",
+		LanguageIndonesian: "Masukkan alamat email yang valid.",
+	},
+	CodeInvalidType: {
+		LanguageEnglish:    "This value has the wrong type.",
+		LanguageIndonesian: "Jenis nilai ini salah.",
+	},
+	CodeInvalidJSON: {
+		LanguageEnglish:    "The request body is not valid JSON.",
+		LanguageIndonesian: "Isi permintaan bukan JSON yang valid.",
+	},
+	CodeInvalidBase64: {
+		LanguageEnglish:    "The file content is not valid base64.",
+		LanguageIndonesian: "Isi file bukan base64 yang valid.",
+	},
+	CodeUnsupportedExtension: {
+		LanguageEnglish:    "This file extension is not allowed.",
+		LanguageIndonesian: "Ekstensi file ini tidak diizinkan.",
+	},
+	CodeUnknownField: {
+		LanguageEnglish:    "This field is not known.",
+		LanguageIndonesian: "Isian ini tidak dikenal.",
+	},
+}
+
+// Localize negotiates the language of error messages from the
+// Accept-Language header, using fallback when the client accepts neither
+// English nor Indonesian, and sets it in the context under LanguageKey
+func Localize(fallback string) gin.HandlerFunc {
+	if fallback == "" {
+		fallback = LanguageEnglish
+	}
+
+	return func(c *gin.Context) {
+		c.Set(LanguageKey, negotiateLanguage(c.GetHeader("Accept-Language"), fallback))
+		c.Next()
+	}
+}
+
+// Language returns the language negotiated for a request, negotiating it
+// with English as the fallback when Localize is not installed
+func Language(c *gin.Context) string {
+	if language := c.GetString(LanguageKey); language != "" {
+		return language
+	}
+	return negotiateLanguage(c.GetHeader("Accept-Language"), LanguageEnglish)
+}
+
+// Message returns the message of an error code in the request's language,
+// falling back to English and then to detail when the code has no message
+func Message(c *gin.Context, code, detail string) string {
+	byLanguage := messages[code]
+	if message := byLanguage[Language(c)]; message != "" {
+		return message
+	}
+	if message := byLanguage[LanguageEnglish]; message != "" {
+		return message
+	}
+	return detail
+}
+
+// ErrorBody returns an error response with the localized message under
+// "error", the stable code under "code" and the technical detail, such as
+// the failing file ID, under "detail"
+func ErrorBody(c *gin.Context, code, detail string) gin.H {
+	return gin.H{"error": Message(c, code, detail), "code": code, "detail": detail}
+}
+
+// negotiateLanguage picks the supported language the client prefers most,
+// e.g. "id" for "id-ID,id;q=0.9,en-US;q=0.8"
+func negotiateLanguage(header, fallback string) string {
+	best, bestQuality := fallback, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if primary == "in" {
+			primary = LanguageIndonesian // Deprecated code still sent by older Android versions
+		}
+		if primary != LanguageEnglish && primary != LanguageIndonesian {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		if quality > bestQuality {
+			best, bestQuality = primary, quality
+		}
+	}
+
+	return best
+}
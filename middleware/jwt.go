@@ -0,0 +1,432 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // Hashes of the supported signing algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context keys set by authentication middleware for authorization and handlers
+const (
+	SubjectKey = "subject" // string
+	ScopesKey  = "scopes"  // []string
+	RolesKey   = "roles"   // []string
+	ClaimsKey  = "claims"  // map[string]interface{} of a verified JWT
+)
+
+const (
+	// jwksTimeout bounds a fetch of the key set, so a hanging identity
+	// provider cannot hold up the requests waiting for its keys
+	jwksTimeout = 10 * time.Second
+
+	// jwksRetryInterval is how long the verifier waits after fetching the key
+	// set before fetching it again for an unknown key ID or after a failure,
+	// so forged key IDs cannot flood the identity provider
+	jwksRetryInterval = time.Minute
+
+	// maxJWKSSize bounds the key set read from the identity provider
+	maxJWKSSize = 1 << 20
+)
+
+// JWTConfig configures the validation of bearer JWTs
+type JWTConfig struct {
+	JWKSURL  string        // URL of the identity provider's JSON Web Key Set
+	Issuer   string        // Required iss claim, "" to accept any
+	Audience string        // Required aud claim, "" to accept any
+	Leeway   time.Duration // Clock skew tolerated when checking exp and nbf
+	CacheTTL time.Duration // How long fetched keys are used, an hour when 0
+	Client   *http.Client  // Client fetching the key set, one with a 10 second timeout when nil
+}
+
+// JWT rejects requests without a valid bearer JWT signed by a key from the
+// configured JWKS. The token's subject, scopes (scope or scp claim), roles
+// and claims are set in the context under SubjectKey, ScopesKey, RolesKey
+// and ClaimsKey; the subject is also logged as the client ID.
+func JWT(config JWTConfig) gin.HandlerFunc {
+	verifier := NewJWTVerifier(config)
+
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.Header("WWW-Authenticate", `Bearer`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorBody(c, CodeBearerTokenRequired, "bearer token required"))
+			return
+		}
+
+		claims, err := verifier.Verify(token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorBody(c, CodeTokenInvalid, err.Error()))
+			return
+		}
+
+		subject, _ := claims["sub"].(string)
+		c.Set(SubjectKey, subject)
+		c.Set(ClientIDKey, subject)
+		c.Set(ScopesKey, claimScopes(claims))
+		c.Set(RolesKey, claimStrings(claims["roles"]))
+		c.Set(ClaimsKey, claims)
+		c.Next()
+	}
+}
+
+// JWTVerifier verifies JWTs against the keys of a JWKS endpoint, refetching
+// them when they expire or a token names an unknown key
+type JWTVerifier struct {
+	config JWTConfig
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time     // Last successful fetch of the key set
+	attempted time.Time     // Last fetch of the key set, successful or not
+	fetchErr  error         // Error of the last fetch, nil when it succeeded
+	fetching  chan struct{} // Closed once the fetch in progress ends, nil when none is
+}
+
+// NewJWTVerifier creates a verifier for config
+func NewJWTVerifier(config JWTConfig) *JWTVerifier {
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = time.Hour
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: jwksTimeout}
+	}
+	return &JWTVerifier{config: config}
+}
+
+// Verify checks the signature, expiry, issuer and audience of a compact JWT
+// and returns its claims. Tokens without a subject are rejected, since the
+// subject is whom files are owned by.
+func (v *JWTVerifier) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// checkClaims validates the registered claims
+func (v *JWTVerifier) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.config.Leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.config.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not yet valid")
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return errors.New("token has no subject")
+	}
+
+	if v.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+			return errors.New("token issuer not accepted")
+		}
+	}
+
+	if v.config.Audience != "" {
+		accepted := false
+		for _, aud := range claimStrings(claims["aud"]) {
+			if aud == v.config.Audience {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return errors.New("token audience not accepted")
+		}
+	}
+
+	return nil
+}
+
+// key returns the public key with a key ID, fetching the key set when the
+// cached one is stale or lacks the key. The key set is fetched at most once
+// every jwksRetryInterval for unknown keys and after failures, and only by
+// one request at a time; the others wait for its result without holding the
+// lock, so verifying tokens with known keys never waits for a fetch.
+func (v *JWTVerifier) key(kid string) (crypto.PublicKey, error) {
+	for {
+		v.mu.Lock()
+		key, found := v.keys[kid]
+		if found && time.Since(v.fetched) <= v.config.CacheTTL {
+			v.mu.Unlock()
+			return key, nil
+		}
+
+		if fetching := v.fetching; fetching != nil {
+			v.mu.Unlock()
+			<-fetching
+			continue
+		}
+
+		if time.Since(v.attempted) < jwksRetryInterval {
+			err := v.fetchErr
+			v.mu.Unlock()
+			switch {
+			case found:
+				// Keep using a known key while the identity provider is unreachable
+				return key, nil
+			case err != nil:
+				return nil, fmt.Errorf("fetching signing keys: %v", err)
+			}
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+
+		fetching := make(chan struct{})
+		v.fetching, v.attempted = fetching, time.Now()
+		v.mu.Unlock()
+
+		keys, err := fetchJWKS(v.config.Client, v.config.JWKSURL)
+
+		v.mu.Lock()
+		if err == nil {
+			v.keys, v.fetched = keys, time.Now()
+		}
+		v.fetchErr, v.fetching = err, nil
+		close(fetching)
+		v.mu.Unlock()
+	}
+}
+
+// jsonWebKey is a public key of a JWKS
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchJWKS downloads a key set and returns its signing keys by key ID,
+// skipping keys of unsupported types
+func fetchJWKS(client *http.Client, url string) (map[string]crypto.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// publicKey decodes an RSA or EC public key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifySignature checks a JWS signature made with an RS, PS or ES algorithm
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("signing key does not match algorithm")
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, hash, digest, signature, nil)
+		}
+		if err != nil {
+			return errors.New("invalid token signature")
+		}
+		return nil
+
+	case strings.HasPrefix(alg, "ES"):
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("signing key does not match algorithm")
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported signing algorithm %q", alg)
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeBigInt decodes a base64url unsigned integer of a JWK
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// claimScopes reads the space separated scope claim, or the scp list used by some providers
+func claimScopes(claims map[string]interface{}) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	return claimStrings(claims["scp"])
+}
+
+// claimStrings reads a claim holding a string or a list of strings
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
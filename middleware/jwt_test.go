@@ -0,0 +1,246 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testIssuer signs tokens with the keys it serves as a JWKS
+type testIssuer struct {
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	server  *httptest.Server
+	fetches atomic.Int32
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	issuer.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encodeBigInt(rsaKey.N), "e": encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encodeBigInt(ecKey.X), "y": encodeBigInt(ecKey.Y)},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": encodeBigInt(rsaKey.N), "e": encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+		}})
+	}))
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+// sign returns a compact JWT with a header and claims, signed with the key
+// matching alg
+func (i *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest[:])
+	case "PS256":
+		signature, err = rsa.SignPSS(rand.Reader, i.rsaKey, crypto.SHA256, digest[:], nil)
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, i.ecKey, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		signature = []byte("signature")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// validClaims returns claims accepted by the verifier of TestJWTVerify,
+// changed by the given claims; nil values remove a claim
+func validClaims(changes map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": "student-1",
+		"iss": "https://id.example.com",
+		"aud": "filestorage",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"nbf": time.Now().Add(-time.Minute).Unix(),
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+func TestJWTVerify(t *testing.T) {
+	issuer := newTestIssuer(t)
+	verifier := NewJWTVerifier(JWTConfig{
+		JWKSURL:  issuer.server.URL,
+		Issuer:   "https://id.example.com",
+		Audience: "filestorage",
+		Leeway:   30 * time.Second,
+	})
+
+	tests := []struct {
+		name    string
+		alg     string
+		kid     string
+		claims  map[string]interface{}
+		tamper  bool
+		wantErr string
+	}{
+		{name: "RS256", alg: "RS256", kid: "rsa", claims: validClaims(nil)},
+		{name: "PS256", alg: "PS256", kid: "rsa", claims: validClaims(nil)},
+		{name: "ES256", alg: "ES256", kid: "ec", claims: validClaims(nil)},
+		{name: "audience in list", alg: "RS256", kid: "rsa", claims: validClaims(map[string]interface{}{"aud": []string{"other", "filestorage"}})},
+		{name: "expired within leeway", alg: "RS256", kid: "rsa", claims: validClaims(map[string]interface{}{"exp": time.Now().Add(-10 * time.Second).Unix()})},
+		{name: "not before within leeway", alg: "RS256", kid: "rsa", claims: validClaims(map[string]interface{}{"nbf": time.Now().Add(10 * time.Second).Unix()})},
+
+		{name: "alg none", alg: "none", kid: "rsa", claims: validClaims(nil), wantErr: "unsupported signing algorithm"},
+		{name: "alg HS256", alg: "HS256", kid: "rsa", claims: validClaims(nil), wantErr: "unsupported signing algorithm"},
+		{name: "alg of another key type", alg: "ES256", kid: "rsa", claims: validClaims(nil), wantErr: "does not match algorithm"},
+		{name: "tampered claims", alg: "RS256", kid: "rsa", claims: validClaims(nil), tamper: true, wantErr: "invalid token signature"},
+		{name: "expired", alg: "RS256", kid: "rsa", claims: validClaims(map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}), wantErr: "token expired"},
+		{name: "no expiry", alg: "RS256", kid: "rsa", claims: validClaims(map[string]interface{}{"exp": nil}), wantErr: "no expiry"},
+		{name: "not yet valid", alg: "RS256", kid: "rsa", claims: validClaims(map[string]interface{}{"nbf": time.Now().Add(time.Minute).Unix()}), wantErr: "not yet valid"},
+		{name: "wrong audience", alg: "RS256", kid: "rsa", claims: validClaims(map[string]interface{}{"aud": "other"}), wantErr: "audience not accepted"},
+		{name: "no audience", alg: "RS256", kid: "rsa", claims: validClaims(map[string]interface{}{"aud": nil}), wantErr: "audience not accepted"},
+		{name: "wrong issuer", alg: "RS256", kid: "rsa", claims: validClaims(map[string]interface{}{"iss": "https://evil.example.com"}), wantErr: "issuer not accepted"},
+		{name: "no subject", alg: "RS256", kid: "rsa", claims: validClaims(map[string]interface{}{"sub": nil}), wantErr: "no subject"},
+		{name: "empty subject", alg: "RS256", kid: "rsa", claims: validClaims(map[string]interface{}{"sub": ""}), wantErr: "no subject"},
+		{name: "unknown kid", alg: "RS256", kid: "other", claims: validClaims(nil), wantErr: "unknown signing key"},
+		{name: "encryption key", alg: "RS256", kid: "enc", claims: validClaims(nil), wantErr: "unknown signing key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := issuer.sign(t, tt.alg, tt.kid, tt.claims)
+			if tt.tamper {
+				parts := strings.Split(token, ".")
+				payload, _ := json.Marshal(validClaims(map[string]interface{}{"sub": "admin"}))
+				token = parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+			}
+
+			claims, err := verifier.Verify(token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify() error = %v", err)
+				}
+				if claims["sub"] != "student-1" {
+					t.Errorf("sub = %v, want student-1", claims["sub"])
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTVerifyMalformed(t *testing.T) {
+	verifier := NewJWTVerifier(JWTConfig{JWKSURL: "http://127.0.0.1:0"})
+
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!.e30.c", "e30.e30.!"} {
+		if _, err := verifier.Verify(token); err == nil {
+			t.Errorf("Verify(%q) accepted a malformed token", token)
+		}
+	}
+}
+
+func TestJWTVerifierRefetchesUnknownKeysOncePerInterval(t *testing.T) {
+	issuer := newTestIssuer(t)
+	verifier := NewJWTVerifier(JWTConfig{JWKSURL: issuer.server.URL})
+
+	if _, err := verifier.Verify(issuer.sign(t, "RS256", "rsa", validClaims(nil))); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := verifier.Verify(issuer.sign(t, "RS256", "forged", validClaims(nil))); err == nil {
+			t.Fatal("Verify() accepted an unknown key")
+		}
+	}
+
+	if fetches := issuer.fetches.Load(); fetches != 1 {
+		t.Errorf("key set fetched %d times, want 1", fetches)
+	}
+}
+
+func TestJWTVerifierKeepsKnownKeysWhileProviderFails(t *testing.T) {
+	issuer := newTestIssuer(t)
+	verifier := NewJWTVerifier(JWTConfig{JWKSURL: issuer.server.URL, CacheTTL: time.Nanosecond})
+
+	token := issuer.sign(t, "RS256", "rsa", validClaims(nil))
+	if _, err := verifier.Verify(token); err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.server.Close()
+	verifier.attempted = time.Time{} // Let the stale key set be refetched now
+	if _, err := verifier.Verify(token); err != nil {
+		t.Errorf("Verify() error = %v while the identity provider is down", err)
+	}
+}
+
+func TestJWTVerifierFetchesOnceForConcurrentRequests(t *testing.T) {
+	issuer := newTestIssuer(t)
+	handler := issuer.server.Config.Handler
+	issuer.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		handler.ServeHTTP(w, r)
+	})
+	verifier := NewJWTVerifier(JWTConfig{JWKSURL: issuer.server.URL})
+	token := issuer.sign(t, "RS256", "rsa", validClaims(nil))
+
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := verifier.Verify(token)
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	if fetches := issuer.fetches.Load(); fetches != 1 {
+		t.Errorf("key set fetched %d times, want 1", fetches)
+	}
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoadShedConfig sets when LoadShed rejects requests. The zero value does
+// not reject any.
+type LoadShedConfig struct {
+	MaxInFlight   int           // Requests served at the same time, beyond which more are rejected
+	MinInFlight   int           // Lowest the bound goes while latency is over target, 1 when 0
+	TargetLatency time.Duration // Average latency above which the bound is lowered, 0 keeps it at MaxInFlight
+}
+
+// Enabled reports whether the configuration rejects requests
+func (c LoadShedConfig) Enabled() bool {
+	return c.MaxInFlight > 0
+}
+
+// loadShedder tracks the requests in flight and adapts their bound to the
+// latency of the requests served
+type loadShedder struct {
+	config LoadShedConfig
+
+	mu        sync.Mutex
+	inFlight  int
+	limit     float64
+	latency   time.Duration // Moving average of the latency of served requests
+	decreased time.Time     // When the bound was last lowered
+}
+
+// LoadShed rejects requests with 503 and a Retry-After header while
+// MaxInFlight of them are being served, so that during peaks the excess
+// fails right away instead of every request timing out. With a target
+// latency the bound adapts: it is cut by a quarter, at most once per target
+// latency, while the average latency is over target, and grows back by one
+// per bound's worth of requests served once it is not.
+func LoadShed(config LoadShedConfig) gin.HandlerFunc {
+	if config.MinInFlight <= 0 {
+		config.MinInFlight = 1
+	}
+	shedder := &loadShedder{config: config, limit: float64(config.MaxInFlight)}
+
+	return func(c *gin.Context) {
+		if !config.Enabled() {
+			c.Next()
+			return
+		}
+
+		retryAfter, ok := shedder.admit()
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, ErrorBody(c, CodeServiceBusy, "service is overloaded"))
+			return
+		}
+
+		start := time.Now()
+		defer func() { shedder.done(time.Since(start)) }()
+		c.Next()
+	}
+}
+
+// admit takes a place for a request, returning when to retry when there is none
+func (s *loadShedder) admit() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inFlight >= int(s.limit) {
+		return max(s.latency, time.Second), false
+	}
+	s.inFlight++
+	return 0, true
+}
+
+// done gives back the place of a request served in elapsed and adapts the
+// bound to the latency
+func (s *loadShedder) done(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inFlight--
+	if s.latency == 0 {
+		s.latency = elapsed
+	} else {
+		s.latency = (s.latency*4 + elapsed) / 5
+	}
+
+	target := s.config.TargetLatency
+	switch {
+	case target <= 0:
+	case s.latency > target:
+		if now := time.Now(); now.Sub(s.decreased) >= target {
+			s.limit = max(s.limit*0.75, float64(s.config.MinInFlight))
+			s.decreased = now
+		}
+	default:
+		s.limit = min(s.limit+1/s.limit, float64(s.config.MaxInFlight))
+	}
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limit allows a client Requests requests per period Per, in bursts of up to
+// Requests. The zero value does not limit requests.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses a limit such as "100/1m" or "5/s"
+func ParseLimit(s string) (Limit, error) {
+	count, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, want e.g. 100/1m", s)
+	}
+
+	requests, err := strconv.Atoi(count)
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, want e.g. 100/1m", s)
+	}
+
+	// Allow "5/s" besides "5/1s"
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, want e.g. 100/1m", s)
+	}
+
+	return Limit{Requests: requests, Per: per}, nil
+}
+
+// enabled reports whether the limit restricts requests
+func (l Limit) enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// RateLimitConfig sets the limits RateLimit applies
+type RateLimitConfig struct {
+	Default Limit            // Shared by the endpoints without their own limit
+	Routes  map[string]Limit // Per-endpoint limits keyed by path below the base path, e.g. "/upload"
+}
+
+// RateLimiter takes tokens from per-key token buckets
+type RateLimiter interface {
+	// Allow takes a token from the bucket for key, reporting how long to
+	// wait for the next token when it is empty
+	Allow(key string, limit Limit) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// RateLimit limits requests per client, identified by the client ID set by
+// authentication or else by IP address. Rejected requests get 429 with a
+// Retry-After header. Requests are let through when the limiter fails.
+func RateLimit(limiter RateLimiter, config RateLimitConfig, basePath string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := strings.TrimPrefix(c.FullPath(), strings.TrimSuffix(basePath, "/"))
+		limit, ok := config.Routes[route]
+		if !ok {
+			route, limit = "*", config.Default
+		}
+		if !limit.enabled() {
+			c.Next()
+			return
+		}
+
+		client := "ip:" + c.ClientIP()
+		if id := c.GetString(ClientIDKey); id != "" {
+			client = "client:" + id
+		}
+
+		allowed, retryAfter, err := limiter.Allow(route+" "+client, limit)
+		if err != nil || allowed {
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorBody(c, CodeRateLimited, "rate limit exceeded"))
+	}
+}
+
+// bucket is a token bucket of MemoryRateLimiter
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // When the bucket refills completely, after which it can be dropped
+}
+
+// MemoryRateLimiter implements RateLimiter with token buckets in memory, so
+// each instance of the service limits requests separately
+type MemoryRateLimiter struct {
+	buckets map[string]*bucket
+	mu      sync.Mutex
+}
+
+// NewMemoryRateLimiter creates a new memory rate limiter
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	limiter := &MemoryRateLimiter{
+		buckets: make(map[string]*bucket),
+	}
+
+	// Start janitor to drop full buckets
+	go limiter.janitor()
+
+	return limiter
+}
+
+// Allow takes a token from the bucket for key
+func (m *MemoryRateLimiter) Allow(key string, limit Limit) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Per.Seconds() // Tokens per second
+
+	b, found := m.buckets[key]
+	if !found {
+		b = &bucket{tokens: capacity, updated: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+	}
+
+	b.tokens--
+	b.full = now.Add(time.Duration((capacity - b.tokens) / rate * float64(time.Second)))
+	return true, 0, nil
+}
+
+// janitor drops buckets that have refilled, which behave like new ones
+func (m *MemoryRateLimiter) janitor() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+
+		now := time.Now()
+		m.mu.Lock()
+		for key, b := range m.buckets {
+			if now.After(b.full) {
+				delete(m.buckets, key)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseLimit(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{value: "100/1m", want: Limit{Requests: 100, Per: time.Minute}},
+		{value: "5/s", want: Limit{Requests: 5, Per: time.Second}},
+		{value: " 10/500ms ", want: Limit{Requests: 10, Per: 500 * time.Millisecond}},
+		{value: "100", wantErr: true},
+		{value: "0/1m", wantErr: true},
+		{value: "-1/1m", wantErr: true},
+		{value: "100/", wantErr: true},
+		{value: "100/0s", wantErr: true},
+		{value: "100/week", wantErr: true},
+	} {
+		got, err := ParseLimit(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLimit(%q) = %v, %v, want %v, error: %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// testRateLimiter checks a token bucket of capacity 3 refilled once per
+// second: a burst of 3 is allowed, the 4th request waits for the next
+// token, and a bucket refilled by advance allows requests again
+func testRateLimiter(t *testing.T, limiter RateLimiter, advance func(time.Duration)) {
+	t.Helper()
+	limit := Limit{Requests: 3, Per: 3 * time.Second}
+
+	for i := 0; i < 3; i++ {
+		if allowed, _, err := limiter.Allow("student-1", limit); !allowed || err != nil {
+			t.Fatalf("request %d of the burst: allowed = %v, error = %v", i+1, allowed, err)
+		}
+	}
+	allowed, retryAfter, err := limiter.Allow("student-1", limit)
+	if allowed || err != nil {
+		t.Fatalf("request past the burst: allowed = %v, error = %v", allowed, err)
+	}
+	if retryAfter <= 900*time.Millisecond || retryAfter > time.Second {
+		t.Errorf("retry after %v, want about 1s", retryAfter)
+	}
+
+	if allowed, _, _ := limiter.Allow("student-2", limit); !allowed {
+		t.Error("the bucket of another key is empty")
+	}
+
+	advance(time.Second)
+	if allowed, _, _ := limiter.Allow("student-1", limit); !allowed {
+		t.Error("request after a token was refilled is rejected")
+	}
+	if allowed, _, _ := limiter.Allow("student-1", limit); allowed {
+		t.Error("more than one token refilled after a second")
+	}
+
+	advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if allowed, _, _ := limiter.Allow("student-1", limit); !allowed {
+			t.Fatalf("request %d after the bucket refilled is rejected", i+1)
+		}
+	}
+	if allowed, _, _ := limiter.Allow("student-1", limit); allowed {
+		t.Error("bucket refilled past its capacity")
+	}
+}
+
+// rewind moves the buckets of a memory rate limiter back in time, as if d
+// had passed
+func rewind(m *MemoryRateLimiter, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range m.buckets {
+		b.updated = b.updated.Add(-d)
+	}
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	testRateLimiter(t, limiter, func(d time.Duration) { rewind(limiter, d) })
+}
+
+// stubRateLimiter answers Allow with fixed results
+type stubRateLimiter struct {
+	allowed bool
+	err     error
+}
+
+func (s stubRateLimiter) Allow(string, Limit) (bool, time.Duration, error) {
+	return s.allowed, 1500 * time.Millisecond, s.err
+}
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, tt := range []struct {
+		name       string
+		limiter    RateLimiter
+		wantStatus int
+	}{
+		{"allowed", stubRateLimiter{allowed: true}, http.StatusOK},
+		{"limited", stubRateLimiter{}, http.StatusTooManyRequests},
+		{"limiter failing lets requests through", stubRateLimiter{err: errors.New("unavailable")}, http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(RateLimit(tt.limiter, RateLimitConfig{Default: Limit{Requests: 1, Per: time.Second}}, "/api"))
+			router.GET("/api/files", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/files", nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "2" {
+				t.Errorf("Retry-After = %q, want 2", w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// RoleScopes maps roles, such as those of a JWT, to the scopes they grant,
+// e.g. {"student": {storage.ScopeFilesRead, storage.ScopeFilesWrite}}
+type RoleScopes map[string][]string
+
+// RequireScope rejects requests whose credentials were not granted scope or
+// storage.ScopeAdmin. Requests authenticated without scopes, such as with
+// the mod-service access key, are let through.
+func RequireScope(scope string) gin.HandlerFunc {
+	return Authorize(scope, nil)
+}
+
+// Authorize rejects requests whose credentials were not granted scope or
+// storage.ScopeAdmin, see Granted
+func Authorize(scope string, roles RoleScopes) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Granted(c, scope, roles) {
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorBody(c, CodeMissingScope, "missing scope "+scope))
+			return
+		}
+		c.Next()
+	}
+}
+
+// Granted reports whether the credentials of a request were granted scope
+// or storage.ScopeAdmin, either directly under ScopesKey or through one of
+// the roles under RolesKey. Requests authenticated without scopes or roles,
+// such as with the mod-service access key, are granted every scope.
+func Granted(c *gin.Context, scope string, roles RoleScopes) bool {
+	scopes, hasScopes := c.Get(ScopesKey)
+	roleNames, hasRoles := c.Get(RolesKey)
+	if !hasScopes && !hasRoles {
+		return true
+	}
+
+	granted, _ := scopes.([]string)
+	if grants(granted, scope) {
+		return true
+	}
+
+	names, _ := roleNames.([]string)
+	for _, name := range names {
+		if grants(roles[name], scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// grants reports whether scopes include scope or storage.ScopeAdmin
+func grants(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == storage.ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
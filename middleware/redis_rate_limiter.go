@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript takes a token from the bucket in KEYS[1], holding up to
+// ARGV[1] tokens refilled at ARGV[2] tokens per millisecond. It returns
+// whether a token was taken and else the milliseconds until the next one.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(bucket[1]) or capacity
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - updated) * rate)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate) + 1000)
+return {allowed, wait}
+`)
+
+// redisKeyPrefix namespaces the rate limit buckets in Redis
+const redisKeyPrefix = "filestorage:ratelimit:"
+
+// redisTimeout bounds each command, including dialing and reading a reply
+// that arrives in parts, so a stalled Redis does not hold up requests
+const redisTimeout = time.Second
+
+// redisRetryInterval is how long requests are limited in memory after Redis failed
+const redisRetryInterval = 10 * time.Second
+
+// RedisRateLimiter implements RateLimiter with token buckets in Redis, so
+// all instances of the service share the limits. While Redis cannot be
+// reached, requests are limited per instance in memory instead.
+type RedisRateLimiter struct {
+	client   *redis.Client
+	fallback *MemoryRateLimiter
+
+	mu      sync.Mutex
+	retryAt time.Time // Until when Redis is skipped after failing
+}
+
+// NewRedisRateLimiter creates a rate limiter for a Redis URL such as
+// "redis://:password@redis:6379/0"
+func NewRedisRateLimiter(redisURL string) (*RedisRateLimiter, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	options.DialTimeout = redisTimeout
+	options.ReadTimeout = redisTimeout
+	options.WriteTimeout = redisTimeout
+	options.MaxRetries = -1 // Fall back to memory rather than retry
+
+	return &RedisRateLimiter{
+		client:   redis.NewClient(options),
+		fallback: NewMemoryRateLimiter(),
+	}, nil
+}
+
+// Allow takes a token from the bucket for key
+func (r *RedisRateLimiter) Allow(key string, limit Limit) (bool, time.Duration, error) {
+	r.mu.Lock()
+	skip := time.Now().Before(r.retryAt)
+	r.mu.Unlock()
+	if skip {
+		return r.fallback.Allow(key, limit)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	rate := float64(limit.Requests) / float64(limit.Per.Milliseconds())
+	result, err := tokenBucketScript.Run(ctx, r.client, []string{redisKeyPrefix + key}, limit.Requests, rate).Int64Slice()
+	if err == nil && len(result) == 2 {
+		return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("unexpected reply %v", result)
+	}
+
+	r.mu.Lock()
+	r.retryAt = time.Now().Add(redisRetryInterval)
+	r.mu.Unlock()
+
+	slog.Warn("redis rate limiter failed, limiting requests in memory", "error", err, "retry_in", redisRetryInterval.String())
+	return r.fallback.Allow(key, limit)
+}
+
+// Close closes the connections to Redis
+func (r *RedisRateLimiter) Close() error {
+	return r.client.Close()
+}
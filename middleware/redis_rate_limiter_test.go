@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedisRateLimiter(t *testing.T, address string) *RedisRateLimiter {
+	t.Helper()
+
+	limiter, err := NewRedisRateLimiter("redis://" + address + "/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+	return limiter
+}
+
+// redisFailing reports whether the limiter skips Redis after it failed
+func redisFailing(r *RedisRateLimiter) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Now().Before(r.retryAt)
+}
+
+func TestRedisRateLimiter(t *testing.T) {
+	server := miniredis.RunT(t)
+	now := time.Now()
+	server.SetTime(now)
+	limiter := newTestRedisRateLimiter(t, server.Addr())
+
+	testRateLimiter(t, limiter, func(d time.Duration) {
+		now = now.Add(d)
+		server.SetTime(now)
+	})
+	if redisFailing(limiter) {
+		t.Error("requests were limited in memory")
+	}
+	if len(server.Keys()) != 2 {
+		t.Errorf("redis holds keys %v, want a bucket per key", server.Keys())
+	}
+}
+
+func TestRedisRateLimiterSharesBuckets(t *testing.T) {
+	server := miniredis.RunT(t)
+	first, second := newTestRedisRateLimiter(t, server.Addr()), newTestRedisRateLimiter(t, server.Addr())
+	limit := Limit{Requests: 2, Per: time.Minute}
+
+	first.Allow("student-1", limit)
+	second.Allow("student-1", limit)
+	if allowed, _, _ := first.Allow("student-1", limit); allowed {
+		t.Error("instances do not share the bucket")
+	}
+}
+
+func TestRedisRateLimiterFallsBackToMemory(t *testing.T) {
+	server := miniredis.RunT(t)
+	limiter := newTestRedisRateLimiter(t, server.Addr())
+	limit := Limit{Requests: 2, Per: time.Minute}
+
+	if allowed, _, err := limiter.Allow("student-1", limit); !allowed || err != nil {
+		t.Fatalf("allowed = %v, error = %v", allowed, err)
+	}
+
+	// While Redis is down, requests are still limited, per instance
+	server.Close()
+	for i := 0; i < 2; i++ {
+		if allowed, _, err := limiter.Allow("student-1", limit); !allowed || err != nil {
+			t.Fatalf("request %d while redis is down: allowed = %v, error = %v", i+1, allowed, err)
+		}
+	}
+	if allowed, _, err := limiter.Allow("student-1", limit); allowed || err != nil {
+		t.Errorf("request past the limit while redis is down: allowed = %v, error = %v", allowed, err)
+	}
+	if !redisFailing(limiter) {
+		t.Fatal("redis is not skipped after failing")
+	}
+
+	// Redis is used again once the retry interval passed
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	limiter.mu.Lock()
+	limiter.retryAt = time.Time{}
+	limiter.mu.Unlock()
+	if allowed, _, err := limiter.Allow("student-1", limit); !allowed || err != nil {
+		t.Errorf("request after redis recovered: allowed = %v, error = %v", allowed, err)
+	}
+	if redisFailing(limiter) || len(server.Keys()) != 1 {
+		t.Error("redis is not used after it recovered")
+	}
+}
+
+func TestRedisRateLimiterTimesOutOnStalledReplies(t *testing.T) {
+	// The server accepts connections and sends the start of a reply, but
+	// never completes it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go func() {
+				buf := make([]byte, 4096)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						return
+					}
+					conn.Write([]byte("*2\r\n:1\r\n"))
+				}
+			}()
+		}
+	}()
+
+	limiter := newTestRedisRateLimiter(t, listener.Addr().String())
+	start := time.Now()
+	allowed, _, err := limiter.Allow("student-1", Limit{Requests: 2, Per: time.Minute})
+	if elapsed := time.Since(start); elapsed > 3*redisTimeout {
+		t.Errorf("Allow() took %v with a stalled reply", elapsed)
+	}
+	if !allowed || err != nil {
+		t.Errorf("allowed = %v, error = %v, want the request limited in memory", allowed, err)
+	}
+	if !redisFailing(limiter) {
+		t.Error("redis is not skipped after a stalled reply")
+	}
+}
+
+func TestNewRedisRateLimiterRejectsInvalidURLs(t *testing.T) {
+	for _, redisURL := range []string{"", "redis", "http://redis:6379", "redis://redis:6379/db"} {
+		if _, err := NewRedisRateLimiter(redisURL); err == nil {
+			t.Errorf("NewRedisRateLimiter(%q) accepted an invalid URL", redisURL)
+		}
+	}
+}
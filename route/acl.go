@@ -0,0 +1,179 @@
+// route/acl.go
+package route
+
+import (
+	"fmt"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// requestPrincipal returns the signed in user whose access to files is
+// checked. Services authenticated otherwise and users granted the admin
+// scope access every file, for which it returns nil.
+func requestPrincipal(c *gin.Context, options *routeOptions) *storage.Principal {
+	if _, user := c.Get(middleware.ClaimsKey); !user {
+		return nil
+	}
+	if middleware.Granted(c, storage.ScopeAdmin, options.config.RoleScopes) {
+		return nil
+	}
+
+	return &storage.Principal{
+		Subject: c.GetString(middleware.SubjectKey),
+		Roles:   c.GetStringSlice(middleware.RolesKey),
+	}
+}
+
+// checkFileAccess returns storage.ErrNotOwner unless the signed in user may
+// access every file with a permission
+func checkFileAccess(c *gin.Context, fs *storage.FileStorageManager, options *routeOptions, permission string, fileIDs ...string) error {
+	principal := requestPrincipal(c, options)
+	for _, fileID := range fileIDs {
+		if err := fs.CheckAccess(fileID, principal, permission); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rejectFileAccess answers an error unless the signed in user may access
+// every file with a permission, reporting whether it did
+func rejectFileAccess(c *gin.Context, fs *storage.FileStorageManager, options *routeOptions, permission string, fileIDs ...string) bool {
+	if err := checkFileAccess(c, fs, options, permission, fileIDs...); err != nil {
+		respondError(c, errorStatus(err), err)
+		return true
+	}
+	return false
+}
+
+// fileAccess rejects requests by signed in users for a file named in the
+// path or query that they may not access with a permission
+func fileAccess(fs *storage.FileStorageManager, options *routeOptions, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rejectFileAccess(c, fs, options, permission, middleware.RequestFileID(c)) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// rejectPrefixAccess answers an error for signed in users accessing every
+// file below a prefix, which may hold files of others, reporting whether it
+// did. Only services and administrators export or open up whole prefixes.
+func rejectPrefixAccess(c *gin.Context, options *routeOptions) bool {
+	if err := checkPrefixAccess(c, options); err != nil {
+		respondError(c, errorStatus(err), err)
+		return true
+	}
+	return false
+}
+
+// checkPrefixAccess returns storage.ErrNotOwner for signed in users, who may
+// not access every file below a prefix
+func checkPrefixAccess(c *gin.Context, options *routeOptions) error {
+	if requestPrincipal(c, options) == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: users access files one at a time", storage.ErrNotOwner)
+}
+
+// rejectOwnerAccess answers an error unless the signed in user owns a file,
+// which managing the links handed out to it requires, reporting whether it did
+func rejectOwnerAccess(c *gin.Context, fs *storage.FileStorageManager, options *routeOptions, fileID string) bool {
+	if err := fs.CheckOwner(fileID, requestPrincipal(c, options)); err != nil {
+		respondError(c, errorStatus(err), err)
+		return true
+	}
+	return false
+}
+
+// rejectJobAccess answers an error unless the signed in user queued a job,
+// reporting whether it did
+func rejectJobAccess(c *gin.Context, options *routeOptions, job *storage.Job) bool {
+	principal := requestPrincipal(c, options)
+	if principal == nil || job.Owner == principal.Subject {
+		return false
+	}
+
+	err := fmt.Errorf("%w: job %s was queued by another user", storage.ErrNotOwner, job.ID)
+	respondError(c, errorStatus(err), err)
+	return true
+}
+
+// rejectUploadAccess answers an error unless the signed in user started a
+// chunked upload, reporting whether it did
+func rejectUploadAccess(c *gin.Context, options *routeOptions, upload *storage.ChunkedUpload) bool {
+	principal := requestPrincipal(c, options)
+	if principal == nil || upload.Owner == principal.Subject {
+		return false
+	}
+
+	err := fmt.Errorf("%w: upload %s was started by another user", storage.ErrNotOwner, upload.UploadID)
+	respondError(c, errorStatus(err), err)
+	return true
+}
+
+// rejectSubjectAccess answers an error unless the signed in user is subject,
+// reporting whether it did
+func rejectSubjectAccess(c *gin.Context, options *routeOptions, subject string) bool {
+	principal := requestPrincipal(c, options)
+	if principal == nil || principal.Subject == subject {
+		return false
+	}
+
+	err := fmt.Errorf("%w: users only act on their own behalf", storage.ErrNotOwner)
+	respondError(c, errorStatus(err), err)
+	return true
+}
+
+// registerAccess serves the owner and ACL of recorded files. Files owned by
+// a signed in user are only accessed by the owner and those granted access.
+func registerAccess(reads, writes *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	// The owner of a file and the access granted to others
+	reads.GET("/files/:id/acl", fileAccess(fs, options, storage.PermissionRead), func(c *gin.Context) {
+		record, err := fs.GetFileRecord(c.Param("id"))
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		respondAccess(c, record)
+	})
+
+	// Replace the access granted to others, e.g. read access for the
+	// supervisor of a student, and hand the file to another owner when owner
+	// is given. Only the owner, a service or an administrator may.
+	writes.PUT("/files/:id/acl", func(c *gin.Context) {
+		var request struct {
+			Owner string          `json:"owner"`
+			ACL   []storage.Grant `json:"acl"`
+		}
+
+		if err := bindJSON(c, &request); err != nil {
+			respondError(c, bindErrorStatus(err), err)
+			return
+		}
+		c.Set(middleware.FileIDKey, c.Param("id"))
+
+		record, err := fs.SetFileAccess(c.Param("id"), requestPrincipal(c, options), request.Owner, request.ACL)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		respondAccess(c, record)
+	})
+}
+
+// respondAccess answers with the owner and ACL of a file record
+func respondAccess(c *gin.Context, record *storage.FileRecord) {
+	acl := record.ACL
+	if acl == nil {
+		acl = []storage.Grant{}
+	}
+
+	c.JSON(200, gin.H{"file_id": record.FileID, "owner": record.Owner, "acl": acl})
+}
@@ -0,0 +1,105 @@
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// newSignedInServer serves the routes to a user signed in as subject with
+// scopes, with a file of student-1 and one of student-2 recorded
+func newSignedInServer(t *testing.T, subject string, scopes ...string) *gin.Engine {
+	t.Helper()
+
+	store := storage.NewMemoryMetadataStore()
+	for _, owner := range []string{"student-1", "student-2"} {
+		store.SaveFile(&storage.FileRecord{FileID: owner + ".pdf", Provider: storage.ProviderAWS, FileName: owner + ".pdf", Owner: owner})
+	}
+	fs := storage.NewFileStorageManager(&storage.Config{}, nil)
+	fs.SetMetadataStore(store)
+
+	signIn := func(c *gin.Context) {
+		c.Set(middleware.ClaimsKey, map[string]interface{}{"sub": subject})
+		c.Set(middleware.SubjectKey, subject)
+		c.Set(middleware.ScopesKey, scopes)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	Register(&r.RouterGroup, fs, WithMiddleware(signIn))
+	return r
+}
+
+func TestExportEstimateOfPrefix(t *testing.T) {
+	w := httptest.NewRecorder()
+	newSignedInServer(t, "student-1", storage.ScopeFilesRead).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export/estimate?prefix=theses", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403: %s", w.Code, w.Body)
+	}
+}
+
+func TestSearchOwner(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		scopes []string
+		query  string
+		status int
+		files  int
+	}{
+		{"user", []string{storage.ScopeFilesRead}, "", http.StatusOK, 1},
+		{"user searching another owner", []string{storage.ScopeFilesRead}, "?owner=student-2", http.StatusForbidden, 0},
+		{"admin", []string{storage.ScopeFilesRead, storage.ScopeAdmin}, "", http.StatusOK, 2},
+		{"admin searching an owner", []string{storage.ScopeFilesRead, storage.ScopeAdmin}, "?owner=student-2", http.StatusOK, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newSignedInServer(t, "student-1", tt.scopes...).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/search"+tt.query, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var result storage.SearchResult
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Files) != tt.files {
+				t.Errorf("found %d files, want %d", len(result.Files), tt.files)
+			}
+		})
+	}
+}
+
+func TestV2UploadToPrefix(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("prefix", "theses")
+	part, _ := form.CreateFormFile("file", "notes.txt")
+	part.Write([]byte("notes"))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v2/"+storage.ProviderAWS+"/files", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	newSignedInServer(t, "student-1", storage.ScopeFilesWrite).ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403: %s", w.Code, w.Body)
+	}
+
+	var response v2Envelope
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error == nil || response.Error.Code != "forbidden" {
+		t.Errorf("response = %s, want a v2 forbidden error", w.Body)
+	}
+}
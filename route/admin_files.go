@@ -0,0 +1,137 @@
+// route/admin_files.go
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// maxFindLimit is the most objects /admin/files returns at once
+const maxFindLimit = 1000
+
+// registerAdminFiles serves file management for operations on an admin
+// group, so files can be found, inspected and repaired without access to
+// the bucket consoles. Files are addressed by provider and ID, with "/" in
+// IDs encoded as %2F.
+func registerAdminFiles(admin *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	// Find files across the enabled providers, or the one given as
+	// ?provider=, by a case-insensitive part of their ID in ?q=
+	admin.GET("/files", func(c *gin.Context) {
+		var providers []string
+		if provider := c.Query("provider"); provider != "" {
+			if rejectStoredProvider(c, options, provider) {
+				return
+			}
+			providers = []string{provider}
+		} else {
+			for _, provider := range []string{storage.ProviderAWS, storage.ProviderGCS} {
+				if options.providerEnabled(provider) {
+					providers = append(providers, provider)
+				}
+			}
+		}
+
+		limit := storage.DefaultFindLimit
+		if value := c.Query("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxFindLimit {
+				respondError(c, http.StatusBadRequest, validationError{{Field: "limit", Code: middleware.CodeInvalidValue}})
+				return
+			}
+		}
+
+		objects, err := fs.FindObjects(providers, c.Query("prefix"), c.Query("q"), limit)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, gin.H{"files": objects})
+	})
+
+	// Everything known about a file: the stored object, its metadata record
+	// and its share links
+	admin.GET("/files/:provider/:id/audit", func(c *gin.Context) {
+		provider, fileID := c.Param("provider"), c.Param("id")
+		if rejectStoredProvider(c, options, provider) {
+			return
+		}
+		c.Set(middleware.FileIDKey, fileID)
+
+		report, err := fs.InspectFile(provider, fileID, c.Query("bucket"), "")
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, report)
+	})
+
+	// Delete a file with its derived objects and share links, even when
+	// deletes are disabled for everyone else
+	admin.DELETE("/files/:provider/:id", audited(fs, storage.AuditDelete), func(c *gin.Context) {
+		provider, fileID := c.Param("provider"), c.Param("id")
+		if rejectStoredProvider(c, options, provider) {
+			return
+		}
+		c.Set(middleware.FileIDKey, fileID)
+
+		result, err := fs.ForceDelete(provider, fileID, c.Query("bucket"), "")
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, result)
+	})
+
+	// Scan a file for malware again, recording the result
+	admin.POST("/files/:provider/:id/rescan", func(c *gin.Context) {
+		provider, fileID := c.Param("provider"), c.Param("id")
+		if rejectStoredProvider(c, options, provider) {
+			return
+		}
+		c.Set(middleware.FileIDKey, fileID)
+
+		result, err := fs.RescanFile(provider, fileID, c.Query("bucket"), "")
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, result)
+	})
+
+	// Render the thumbnails of an image again in every served size
+	admin.POST("/files/:provider/:id/thumbnails", func(c *gin.Context) {
+		provider, fileID := c.Param("provider"), c.Param("id")
+		if rejectStoredProvider(c, options, provider) {
+			return
+		}
+		c.Set(middleware.FileIDKey, fileID)
+
+		thumbnails, err := fs.RegenerateThumbnails(provider, fileID, c.Query("bucket"), "")
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, gin.H{"thumbnails": thumbnails})
+	})
+}
+
+// rejectStoredProvider answers 404 unless provider is an enabled S3 or GCS
+// provider, reporting whether it did
+func rejectStoredProvider(c *gin.Context, options *routeOptions, provider string) bool {
+	if provider != storage.ProviderAWS && provider != storage.ProviderGCS {
+		c.Set(middleware.ProviderKey, provider)
+		respondError(c, http.StatusNotFound, fmt.Errorf("unknown provider %q", provider))
+		return true
+	}
+	return rejectProvider(c, options, provider)
+}
@@ -0,0 +1,79 @@
+// route/api_keys.go
+package route
+
+import (
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerAPIKeys serves the management of API keys on an admin group. Key
+// secrets are only returned when a key is issued or rotated.
+func registerAPIKeys(admin *gin.RouterGroup, fs *storage.FileStorageManager) {
+	// Issue an API key for a service, e.g. with scopes ["files:write"] for an
+	// upload-only client or ["files:read"] for a read-only one
+	admin.POST("/api-keys", func(c *gin.Context) {
+		var request struct {
+			Name   string   `json:"name" binding:"required"`
+			Scopes []string `json:"scopes" binding:"required"`
+		}
+
+		if err := bindJSON(c, &request); err != nil {
+			respondError(c, bindErrorStatus(err), err)
+			return
+		}
+
+		key, secret, err := fs.IssueAPIKey(request.Name, request.Scopes)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, gin.H{"api_key": key, "secret": secret})
+	})
+
+	// List the issued API keys
+	admin.GET("/api-keys", func(c *gin.Context) {
+		keys, err := fs.ListAPIKeys()
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, gin.H{"api_keys": keys})
+	})
+
+	// Replace the secret of an API key, keeping the previous one valid for
+	// grace_seconds (a day by default)
+	admin.POST("/api-keys/:id/rotate", func(c *gin.Context) {
+		var request struct {
+			GraceSeconds int64 `json:"grace_seconds" binding:"min=0"`
+		}
+
+		if c.Request.ContentLength != 0 {
+			if err := bindJSON(c, &request); err != nil {
+				respondError(c, bindErrorStatus(err), err)
+				return
+			}
+		}
+
+		key, secret, err := fs.RotateAPIKey(c.Param("id"), time.Duration(request.GraceSeconds)*time.Second)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, gin.H{"api_key": key, "secret": secret})
+	})
+
+	// Revoke an API key and all its secrets
+	admin.DELETE("/api-keys/:id", func(c *gin.Context) {
+		if err := fs.RevokeAPIKey(c.Param("id")); err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, gin.H{"status": storage.StatusSuccess})
+	})
+}
@@ -0,0 +1,21 @@
+// route/attributes.go
+package route
+
+import (
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerAttributes serves the schema of the custom attributes uploads
+// carry, so clients can build their upload forms from it
+func registerAttributes(reads *gin.RouterGroup, fs *storage.FileStorageManager) {
+	// The declared attributes, empty when any attributes are recorded as given
+	reads.GET("/attribute-schema", func(c *gin.Context) {
+		schema := fs.AttributeSchema()
+		if schema == nil {
+			schema = storage.AttributeSchema{}
+		}
+
+		c.JSON(200, gin.H{"fields": schema})
+	})
+}
@@ -0,0 +1,120 @@
+// route/audit.go
+package route
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// auditFileIDsKey is the context key handlers acting on several files set
+// their IDs under, so each is audited
+const auditFileIDsKey = "audit_file_ids"
+
+// audited records an action on the file of the request in the audit log of
+// fs once the handler has answered, whatever the outcome. The file and
+// provider are taken from the context keys when set, falling back to the
+// request like the access log.
+func audited(fs *storage.FileStorageManager, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		event := auditEvent(c, action)
+		event.Provider = c.GetString(middleware.ProviderKey)
+		if event.Provider == "" {
+			event.Provider = middleware.RequestProvider(c)
+		}
+
+		fileIDs := c.GetStringSlice(auditFileIDsKey)
+		if len(fileIDs) == 0 {
+			fileID := c.GetString(middleware.FileIDKey)
+			if fileID == "" {
+				fileID = middleware.RequestFileID(c)
+			}
+			fileIDs = []string{fileID}
+		}
+
+		for _, fileID := range fileIDs {
+			event.FileID = fileID
+			recordAudit(fs, event)
+		}
+	}
+}
+
+// auditEvent returns the audit event of an action taken by the client of a
+// request, without the file it was taken on
+func auditEvent(c *gin.Context, action string) storage.AuditEvent {
+	event := storage.AuditEvent{
+		Action:    action,
+		Subject:   c.GetString(middleware.SubjectKey),
+		ClientID:  c.GetString(middleware.ClientIDKey),
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Via:       c.FullPath(),
+		Status:    c.Writer.Status(),
+	}
+	if event.ClientID == "" {
+		event.ClientID = c.GetHeader("X-Client-ID")
+	}
+	return event
+}
+
+// recordAudit adds an event to the audit log of fs, logging failures
+// instead of failing the request
+func recordAudit(fs *storage.FileStorageManager, event storage.AuditEvent) {
+	if err := fs.RecordAudit(event); err != nil {
+		log.Printf("filestorage: recording the %s of %s in the audit log failed: %v", event.Action, event.FileID, err)
+	}
+}
+
+// setFileContext names the file a request accesses for the access and audit
+// logs, when the request only names a link to it
+func setFileContext(c *gin.Context, provider, fileID string) {
+	c.Set(middleware.ProviderKey, provider)
+	c.Set(middleware.FileIDKey, fileID)
+}
+
+// registerAudit serves the audit log on an admin group, for data protection
+// reviews of who accessed which files
+func registerAudit(admin *gin.RouterGroup, fs *storage.FileStorageManager) {
+	// Audit events, newest first, filtered by ?file_id=, ?subject=, ?action=
+	// (download, signed_url or delete) and a time range of ?from= and ?to=,
+	// at most ?limit= of them
+	admin.GET("/audit", func(c *gin.Context) {
+		query := storage.AuditQuery{
+			FileID:  c.Query("file_id"),
+			Subject: c.Query("subject"),
+			Action:  c.Query("action"),
+		}
+
+		var invalid validationError
+		var err error
+		if query.From, err = parseSearchTime(c.Query("from")); err != nil {
+			invalid = append(invalid, fieldError{Field: "from", Code: middleware.CodeInvalidValue})
+		}
+		if query.To, err = parseSearchTime(c.Query("to")); err != nil {
+			invalid = append(invalid, fieldError{Field: "to", Code: middleware.CodeInvalidValue})
+		}
+		if value := c.Query("limit"); value != "" {
+			if query.Limit, err = strconv.Atoi(value); err != nil {
+				invalid = append(invalid, fieldError{Field: "limit", Code: middleware.CodeInvalidValue})
+			}
+		}
+		if len(invalid) > 0 {
+			respondError(c, http.StatusBadRequest, invalid)
+			return
+		}
+
+		events, err := fs.AuditEvents(query)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, gin.H{"events": events})
+	})
+}
@@ -0,0 +1,273 @@
+// route/config.go
+package route
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+)
+
+// DefaultBasePath is where SetupRouter mounts the endpoints
+const DefaultBasePath = "/file-service/api/v1"
+
+// RouteConfig selects where the endpoints are mounted and which are exposed.
+// The zero value exposes every endpoint at the group passed to Register.
+type RouteConfig struct {
+	BasePath      string // Path below the router group, e.g. DefaultBasePath
+	V2BasePath    string // Path of the v2 endpoints below the router group, BasePath + "/v2" when ""
+	DisableS3     bool   // Removes the /s3 endpoints and rejects provider=s3
+	DisableGCS    bool   // Removes the /gcs endpoints and rejects provider=gcs
+	DisableDelete bool   // Removes the endpoints deleting files
+	ServeDocs     bool   // Serves the OpenAPI spec at /openapi.json and Swagger UI at /docs
+	ServePprof    bool   // Serves net/http/pprof profiles at /admin/debug/pprof
+	ServeGraphQL  bool   // Serves the GraphQL endpoint at /graphql
+	AdminKey      string // X-Admin-Key authenticating the /admin endpoints, "" to rely on WithAdminMiddleware
+	Language      string // Language of error messages for clients accepting neither English nor Indonesian, "en" when ""
+
+	// Auth lists the authentication methods protected route groups accept,
+	// any one of them sufficing: middleware.MethodAccessKey (added by
+	// SetupRouter), MethodAPIKey and MethodJWT
+	Auth []string
+	// Public lists the route groups served without authentication,
+	// DefaultPublicGroups when nil
+	Public []string
+	// JWT verifies bearer tokens when Auth lists middleware.MethodJWT
+	JWT middleware.JWTConfig
+
+	// RateLimits limits the requests of each client ID or IP address
+	RateLimits middleware.RateLimitConfig
+	// RedisURL shares the rate limits between instances through Redis,
+	// e.g. "redis://:password@redis:6379/0", "" to limit each in memory
+	RedisURL string
+	// LoadShed rejects uploads with 503 while the instance is serving as
+	// many as it can in time, rather than letting all of them time out
+	LoadShed middleware.LoadShedConfig
+
+	// Scopes overrides the scope required by route groups such as GroupDelete,
+	// "" opening a group to every authenticated caller
+	Scopes map[string]string
+	// RoleScopes grants scopes to the roles of JWTs, e.g. files:read and
+	// files:write to "student" so students can upload but never delete
+	RoleScopes middleware.RoleScopes
+
+	// ShutdownTimeout is how long Serve waits for in-flight requests and
+	// background jobs when shutting down, DefaultShutdownTimeout when 0
+	ShutdownTimeout time.Duration
+}
+
+// DefaultRouteConfig returns the configuration SetupRouter uses when none is given
+func DefaultRouteConfig() RouteConfig {
+	return RouteConfig{BasePath: DefaultBasePath, V2BasePath: DefaultV2BasePath, Auth: []string{middleware.MethodAccessKey}}
+}
+
+// LoadRouteConfig loads the route configuration from environment variables,
+// falling back to DefaultRouteConfig
+func LoadRouteConfig() (RouteConfig, error) {
+	config := DefaultRouteConfig()
+
+	if basePath, ok := os.LookupEnv("FILE_STORAGE_ROUTE_BASE_PATH"); ok {
+		config.BasePath = basePath
+	}
+	if basePath, ok := os.LookupEnv("FILE_STORAGE_ROUTE_V2_BASE_PATH"); ok {
+		config.V2BasePath = basePath
+	}
+	config.AdminKey = os.Getenv("FILE_STORAGE_ADMIN_KEY")
+
+	config.Language = os.Getenv("FILE_STORAGE_LANGUAGE")
+	switch config.Language {
+	case "", middleware.LanguageEnglish, middleware.LanguageIndonesian:
+	default:
+		return config, fmt.Errorf("invalid FILE_STORAGE_LANGUAGE: %q", config.Language)
+	}
+
+	if methods := getEnvList("FILE_STORAGE_AUTH_METHODS"); methods != nil {
+		for _, method := range methods {
+			switch method {
+			case middleware.MethodAccessKey, middleware.MethodAPIKey, middleware.MethodJWT:
+			default:
+				return config, fmt.Errorf("invalid FILE_STORAGE_AUTH_METHODS entry: %q", method)
+			}
+		}
+		config.Auth = methods
+	}
+
+	if groups := getEnvList("FILE_STORAGE_PUBLIC_ROUTES"); groups != nil {
+		for _, group := range groups {
+			if _, known := DefaultGroupScopes[group]; !known || group == GroupAdmin {
+				return config, fmt.Errorf("invalid FILE_STORAGE_PUBLIC_ROUTES entry: %q", group)
+			}
+		}
+		config.Public = groups
+	}
+
+	config.JWT = middleware.JWTConfig{
+		JWKSURL:  os.Getenv("FILE_STORAGE_JWKS_URL"),
+		Issuer:   os.Getenv("FILE_STORAGE_JWT_ISSUER"),
+		Audience: os.Getenv("FILE_STORAGE_JWT_AUDIENCE"),
+	}
+	if config.JWT.JWKSURL == "" && slices.Contains(config.Auth, middleware.MethodJWT) {
+		return config, fmt.Errorf("FILE_STORAGE_JWKS_URL is required for jwt authentication")
+	}
+
+	scopes, err := getEnvScopes()
+	if err != nil {
+		return config, err
+	}
+	config.Scopes = scopes
+
+	if limit := os.Getenv("FILE_STORAGE_RATE_LIMIT"); limit != "" {
+		if config.RateLimits.Default, err = middleware.ParseLimit(limit); err != nil {
+			return config, fmt.Errorf("invalid FILE_STORAGE_RATE_LIMIT: %v", err)
+		}
+	}
+
+	routeLimits, err := getEnvLimits()
+	if err != nil {
+		return config, err
+	}
+	config.RateLimits.Routes = routeLimits
+	config.RedisURL = os.Getenv("FILE_STORAGE_REDIS_URL")
+
+	if value := os.Getenv("FILE_STORAGE_LOAD_SHED_MAX_IN_FLIGHT"); value != "" {
+		if config.LoadShed.MaxInFlight, err = strconv.Atoi(value); err != nil {
+			return config, fmt.Errorf("invalid FILE_STORAGE_LOAD_SHED_MAX_IN_FLIGHT: %v", err)
+		}
+	}
+	if value := os.Getenv("FILE_STORAGE_LOAD_SHED_MIN_IN_FLIGHT"); value != "" {
+		if config.LoadShed.MinInFlight, err = strconv.Atoi(value); err != nil {
+			return config, fmt.Errorf("invalid FILE_STORAGE_LOAD_SHED_MIN_IN_FLIGHT: %v", err)
+		}
+	}
+	if latency := os.Getenv("FILE_STORAGE_LOAD_SHED_TARGET_LATENCY"); latency != "" {
+		if config.LoadShed.TargetLatency, err = time.ParseDuration(latency); err != nil {
+			return config, fmt.Errorf("invalid FILE_STORAGE_LOAD_SHED_TARGET_LATENCY: %v", err)
+		}
+	}
+
+	if timeout := os.Getenv("FILE_STORAGE_SHUTDOWN_TIMEOUT"); timeout != "" {
+		if config.ShutdownTimeout, err = time.ParseDuration(timeout); err != nil {
+			return config, fmt.Errorf("invalid FILE_STORAGE_SHUTDOWN_TIMEOUT: %v", err)
+		}
+	}
+
+	roleScopes, err := getEnvRoleScopes()
+	if err != nil {
+		return config, err
+	}
+	config.RoleScopes = roleScopes
+
+	for key, field := range map[string]*bool{
+		"FILE_STORAGE_DISABLE_S3_ROUTES":     &config.DisableS3,
+		"FILE_STORAGE_DISABLE_GCS_ROUTES":    &config.DisableGCS,
+		"FILE_STORAGE_DISABLE_DELETE_ROUTES": &config.DisableDelete,
+		"FILE_STORAGE_SERVE_API_DOCS":        &config.ServeDocs,
+		"FILE_STORAGE_SERVE_PPROF":           &config.ServePprof,
+		"FILE_STORAGE_SERVE_GRAPHQL":         &config.ServeGraphQL,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		*field = b
+	}
+
+	return config, nil
+}
+
+// getEnvScopes reads the scopes required by route groups from
+// FILE_STORAGE_ROUTE_SCOPES, e.g. "delete=admin,share=files:write"
+func getEnvScopes() (map[string]string, error) {
+	value := os.Getenv("FILE_STORAGE_ROUTE_SCOPES")
+	if value == "" {
+		return nil, nil
+	}
+
+	scopes := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		group, scope, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if _, known := DefaultGroupScopes[group]; !ok || !known {
+			return nil, fmt.Errorf("invalid FILE_STORAGE_ROUTE_SCOPES entry: %q", pair)
+		}
+		scopes[group] = strings.TrimSpace(scope)
+	}
+
+	return scopes, nil
+}
+
+// getEnvLimits reads per-endpoint rate limits from
+// FILE_STORAGE_ROUTE_RATE_LIMITS, e.g. "/upload=10/1m,/download-zip=2/1m"
+func getEnvLimits() (map[string]middleware.Limit, error) {
+	value := os.Getenv("FILE_STORAGE_ROUTE_RATE_LIMITS")
+	if value == "" {
+		return nil, nil
+	}
+
+	limits := make(map[string]middleware.Limit)
+	for _, pair := range strings.Split(value, ",") {
+		route, limit, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || route == "" {
+			return nil, fmt.Errorf("invalid FILE_STORAGE_ROUTE_RATE_LIMITS entry: %q", pair)
+		}
+
+		parsed, err := middleware.ParseLimit(limit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FILE_STORAGE_ROUTE_RATE_LIMITS entry: %v", err)
+		}
+		limits[route] = parsed
+	}
+
+	return limits, nil
+}
+
+// getEnvRoleScopes reads the scopes granted to roles from
+// FILE_STORAGE_ROLE_SCOPES, e.g. "student=files:read|files:write,lecturer=files:read|files:write|files:delete"
+func getEnvRoleScopes() (middleware.RoleScopes, error) {
+	value := os.Getenv("FILE_STORAGE_ROLE_SCOPES")
+	if value == "" {
+		return nil, nil
+	}
+
+	roles := make(middleware.RoleScopes)
+	for _, pair := range strings.Split(value, ",") {
+		role, scopes, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || role == "" {
+			return nil, fmt.Errorf("invalid FILE_STORAGE_ROLE_SCOPES entry: %q", pair)
+		}
+
+		for _, scope := range strings.Split(scopes, "|") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				roles[role] = append(roles[role], scope)
+			}
+		}
+	}
+
+	return roles, nil
+}
+
+// getEnvList reads a comma separated environment variable, returning nil
+// when it is unset and an empty list when it is set but empty
+func getEnvList(key string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
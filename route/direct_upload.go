@@ -0,0 +1,108 @@
+// route/direct_upload.go
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerDirectUploads serves the orchestration of browser uploads that go
+// straight to the provider: /uploads/presign reserves a file ID and returns
+// a presigned URL, /uploads/confirm finalizes the file once it was sent.
+func registerDirectUploads(writes *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	writes.POST("/uploads/presign", func(c *gin.Context) {
+		var request struct {
+			Provider    string `json:"provider" binding:"required,oneof=s3 gcs"`
+			Filename    string `json:"filename" binding:"required"` // Including the extension, e.g. "report.pdf"
+			ContentType string `json:"content_type"`
+			Size        int64  `json:"size" binding:"required,min=1"`
+			Owner       string `json:"owner"` // Required unless the caller signed in with a JWT
+			Prefix      string `json:"prefix"`
+			ExpiresIn   int    `json:"expires_in" binding:"min=0,max=3600"` // Seconds, 15 minutes by default
+
+			Attributes storage.Attributes `json:"attributes"` // Custom attributes, see GET /attribute-schema
+		}
+
+		if err := bindJSON(c, &request); err != nil {
+			respondError(c, bindErrorStatus(err), err)
+			return
+		}
+		if rejectProvider(c, options, request.Provider) {
+			return
+		}
+
+		owner, err := uploadOwner(c, request.Owner)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+		if owner == "" {
+			respondError(c, http.StatusBadRequest, validationError{{Field: "owner", Code: middleware.CodeRequired}})
+			return
+		}
+
+		upload, err := fs.PresignUpload(request.Provider, storage.DirectUploadRequest{
+			Filename:    request.Filename,
+			ContentType: request.ContentType,
+			Size:        request.Size,
+			Owner:       owner,
+			Prefix:      request.Prefix,
+			MaxSize:     fs.MaxUploadSizeFor("/uploads/presign"),
+			Expiry:      time.Duration(request.ExpiresIn) * time.Second,
+			Attributes:  request.Attributes,
+		})
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.Set(middleware.FileIDKey, upload.FileID)
+		c.JSON(200, upload)
+	})
+
+	writes.POST("/uploads/confirm", func(c *gin.Context) {
+		var request struct {
+			FileID string `json:"file_id" binding:"required"`
+			Owner  string `json:"owner"`
+		}
+
+		if err := bindJSON(c, &request); err != nil {
+			respondError(c, bindErrorStatus(err), err)
+			return
+		}
+		c.Set(middleware.FileIDKey, request.FileID)
+
+		owner, err := uploadOwner(c, request.Owner)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		result, err := fs.ConfirmUpload(request.FileID, owner)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, result)
+	})
+}
+
+// uploadOwner returns the owner of a direct upload. Users signed in with a
+// JWT own their uploads; services authenticated otherwise name the owner.
+func uploadOwner(c *gin.Context, requested string) (string, error) {
+	if _, user := c.Get(middleware.ClaimsKey); !user {
+		return requested, nil
+	}
+
+	subject := c.GetString(middleware.SubjectKey)
+	if requested != "" && requested != subject {
+		return "", fmt.Errorf("%w: uploads are owned by the signed in user", storage.ErrNotOwner)
+	}
+	return subject, nil
+}
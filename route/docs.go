@@ -0,0 +1,86 @@
+// route/docs.go
+package route
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec describes every endpoint registered by Register, relative to its base path
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// swaggerUI renders the spec served next to it with Swagger UI from a CDN
+const swaggerUI = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>File Storage Service API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+
+// registerDocs serves the OpenAPI spec at /openapi.json and Swagger UI at /docs
+func registerDocs(rg *gin.RouterGroup, v2BasePath string, config RouteConfig) {
+	spec, err := openAPIFor(rg.BasePath(), v2BasePath, config)
+	if err != nil {
+		panic("route: invalid embedded OpenAPI spec: " + err.Error())
+	}
+
+	rg.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", spec)
+	})
+
+	rg.GET("/docs", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUI))
+	})
+}
+
+// openAPIFor adapts the embedded spec to the base paths and leaves out the
+// endpoints the configuration disables. The v2 paths carry their own servers.
+func openAPIFor(basePath, v2BasePath string, config RouteConfig) ([]byte, error) {
+	var spec map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return nil, err
+	}
+
+	spec["servers"] = []map[string]string{{"url": basePath}}
+
+	paths, _ := spec["paths"].(map[string]interface{})
+	for path, item := range paths {
+		if (config.DisableS3 && strings.HasPrefix(path, "/s3/")) || (config.DisableGCS && strings.HasPrefix(path, "/gcs/")) ||
+			(!config.ServePprof && strings.HasPrefix(path, "/admin/debug/pprof/")) || (!config.ServeGraphQL && path == "/graphql") {
+			delete(paths, path)
+			continue
+		}
+
+		operations, _ := item.(map[string]interface{})
+		if _, ok := operations["servers"]; ok {
+			operations["servers"] = []map[string]string{{"url": v2BasePath}}
+		}
+		if config.DisableDelete && (strings.HasPrefix(path, "/s3/delete") || strings.HasPrefix(path, "/gcs/delete") || path == "/{provider}/files/{id}") {
+			delete(operations, "delete")
+		}
+		if config.DisableDelete && (path == "/files/delete" || path == "/{provider}/files/{id}/restore") {
+			delete(operations, "post")
+		}
+		if len(operations) == 0 {
+			delete(paths, path)
+		}
+	}
+
+	return json.Marshal(spec)
+}
@@ -0,0 +1,46 @@
+// route/expiry.go
+package route
+
+import (
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerExpiry serves the expiry of recorded files, after which the
+// background sweep deletes or archives them
+func registerExpiry(writes *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	// Expire a file at a time, e.g. a draft upload a month from now. An
+	// expires_at of null removes the expiry.
+	writes.PUT("/files/:id/expiry", fileAccess(fs, options, storage.PermissionWrite), func(c *gin.Context) {
+		var request struct {
+			ExpiresAt *time.Time `json:"expires_at"`
+			Action    string     `json:"action"`
+		}
+
+		if err := bindJSON(c, &request); err != nil {
+			respondError(c, bindErrorStatus(err), err)
+			return
+		}
+		c.Set(middleware.FileIDKey, c.Param("id"))
+
+		var expiresAt time.Time
+		if request.ExpiresAt != nil {
+			expiresAt = *request.ExpiresAt
+		}
+
+		record, err := fs.SetFileExpiry(c.Param("id"), expiresAt, request.Action)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		response := gin.H{"file_id": record.FileID, "expires_at": nil, "expiry_action": record.ExpiryAction}
+		if !record.ExpiresAt.IsZero() {
+			response["expires_at"] = record.ExpiresAt
+		}
+		c.JSON(200, response)
+	})
+}
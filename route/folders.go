@@ -0,0 +1,163 @@
+// route/folders.go
+package route
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerFolders serves the virtual folder tree of a file manager. Folders
+// only exist in the folder store: creating, renaming or moving folders and
+// moving files between them never touches the stored objects.
+func registerFolders(reads, writes, deletes *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	// The top-level folders and the files outside any folder, paged by
+	// ?offset= and ?limit=. Signed in users only list their own.
+	reads.GET("/folders", func(c *gin.Context) {
+		listFolder(c, fs, options, "")
+	})
+
+	// A folder with its path, its folders and a page of its files
+	reads.GET("/folders/:id", func(c *gin.Context) {
+		listFolder(c, fs, options, c.Param("id"))
+	})
+
+	// Create a folder, at the top level unless parent_id is given. Signed in
+	// users create folders in their own folders only.
+	writes.POST("/folders", func(c *gin.Context) {
+		var request struct {
+			Name     string `json:"name" binding:"required"`
+			ParentID string `json:"parent_id"`
+		}
+
+		if err := bindJSON(c, &request); err != nil {
+			respondError(c, bindErrorStatus(err), err)
+			return
+		}
+
+		owner, err := uploadOwner(c, "")
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		folder, err := fs.CreateFolder(request.Name, request.ParentID, requestPrincipal(c, options), owner)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, folder)
+	})
+
+	// Rename a folder. Signed in users only change their own folders, here
+	// and below.
+	writes.POST("/folders/:id/rename", func(c *gin.Context) {
+		var request struct {
+			Name string `json:"name" binding:"required"`
+		}
+
+		if err := bindJSON(c, &request); err != nil {
+			respondError(c, bindErrorStatus(err), err)
+			return
+		}
+
+		folder, err := fs.RenameFolder(c.Param("id"), requestPrincipal(c, options), request.Name)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, folder)
+	})
+
+	// Move a folder with its content into another folder, or to the top
+	// level when parent_id is empty
+	writes.POST("/folders/:id/move", func(c *gin.Context) {
+		var request struct {
+			ParentID string `json:"parent_id"`
+		}
+
+		if err := bindJSON(c, &request); err != nil {
+			respondError(c, bindErrorStatus(err), err)
+			return
+		}
+
+		folder, err := fs.MoveFolder(c.Param("id"), requestPrincipal(c, options), request.ParentID)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, folder)
+	})
+
+	// Delete an empty folder
+	deletes.DELETE("/folders/:id", func(c *gin.Context) {
+		if err := fs.DeleteFolder(c.Param("id"), requestPrincipal(c, options)); err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+
+	// Move a file into a folder, or out of any folder when folder_id is empty
+	writes.POST("/files/:id/move", fileAccess(fs, options, storage.PermissionWrite), func(c *gin.Context) {
+		var request struct {
+			FolderID string `json:"folder_id"`
+		}
+
+		if err := bindJSON(c, &request); err != nil {
+			respondError(c, bindErrorStatus(err), err)
+			return
+		}
+		c.Set(middleware.FileIDKey, c.Param("id"))
+
+		record, err := fs.MoveFile(c.Param("id"), requestPrincipal(c, options), request.FolderID)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, record)
+	})
+}
+
+// listFolder answers with the content of a folder, "" for the top level
+func listFolder(c *gin.Context, fs *storage.FileStorageManager, options *routeOptions, id string) {
+	var offset, limit int
+	var invalid validationError
+	for _, param := range []struct {
+		field string
+		value *int
+	}{{"offset", &offset}, {"limit", &limit}} {
+		if value := c.Query(param.field); value != "" {
+			var err error
+			if *param.value, err = strconv.Atoi(value); err != nil {
+				invalid = append(invalid, fieldError{Field: param.field, Code: middleware.CodeInvalidValue})
+			}
+		}
+	}
+	if len(invalid) > 0 {
+		respondError(c, http.StatusBadRequest, invalid)
+		return
+	}
+
+	owner, err := searchOwner(c, options, "")
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	listing, err := fs.ListFolder(id, owner, offset, limit)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	c.JSON(200, listing)
+}
@@ -0,0 +1,821 @@
+// route/graphql.go
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// maxGraphQLBody is the largest operation sent as JSON. Files are sent as
+// multipart requests, which are limited by the upload size instead.
+const maxGraphQLBody = 1 << 20
+
+// graphQLRequest is an operation sent as JSON, or as the "operations" field
+// of a multipart request
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLContextKey is the key of the gin context in the context resolvers
+// are given
+type graphQLContextKey struct{}
+
+// graphQLError is a failed operation, carrying the stable error code the
+// REST endpoints answer with under "extensions"
+type graphQLError struct {
+	message string // Localized message
+	code    string // Stable error code, e.g. middleware.CodeFileNotFound
+	detail  string
+}
+
+func (e *graphQLError) Error() string {
+	return e.message
+}
+
+// Extensions implements gqlerrors.ExtendedError
+func (e *graphQLError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code, "detail": e.detail}
+}
+
+// graphQLFile is a file as answered by the GraphQL endpoint, from its info
+// or its record. Fields are resolved by their case-insensitive name.
+type graphQLFile struct {
+	ID          string
+	Provider    string
+	Bucket      string
+	Name        string
+	Extension   string
+	MimeType    string
+	Size        int64
+	MD5         string
+	SHA256      string
+	PublicLink  string
+	PreviewLink string
+	Visibility  string
+	Owner       string
+	ScanStatus  string
+	Tags        []string
+	FolderID    string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// complete fills the fields the file is missing from its record
+func (file *graphQLFile) complete(record *storage.FileRecord) {
+	for _, field := range []struct {
+		value    *string
+		recorded string
+	}{
+		{&file.Provider, record.Provider},
+		{&file.Bucket, record.Bucket},
+		{&file.Name, record.FileName},
+		{&file.Extension, record.FileExt},
+		{&file.MimeType, record.MimeType},
+		{&file.MD5, record.MD5},
+		{&file.SHA256, record.SHA256},
+		{&file.PublicLink, record.PublicLink},
+		{&file.PreviewLink, record.PreviewLink},
+		{&file.Visibility, record.Visibility},
+		{&file.Owner, record.Owner},
+		{&file.ScanStatus, record.ScanStatus},
+		{&file.FolderID, record.Folder},
+	} {
+		if *field.value == "" {
+			*field.value = field.recorded
+		}
+	}
+
+	if file.Size == 0 {
+		file.Size = record.FileSize
+	}
+	if len(record.Tags) > 0 {
+		file.Tags = record.Tags
+	}
+	if file.CreatedAt.IsZero() {
+		file.CreatedAt = record.CreatedAt
+	}
+	if file.ExpiresAt.IsZero() {
+		file.ExpiresAt = record.ExpiresAt
+	}
+}
+
+// graphQLFilePage is a page of files found by a search
+type graphQLFilePage struct {
+	Files  []*graphQLFile
+	Total  int
+	Offset int
+	Limit  int
+}
+
+// graphQLUpload is the Upload scalar of the GraphQL multipart request
+// specification. Its values are the *multipart.FileHeader of the file fields
+// the "map" field places in the variables; it cannot be written inline.
+var graphQLUpload = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Upload",
+	Description: "A file sent in a multipart request as in the GraphQL multipart request specification",
+	Serialize: func(value interface{}) interface{} {
+		return nil
+	},
+	ParseValue: func(value interface{}) interface{} {
+		if file, ok := value.(*multipart.FileHeader); ok {
+			return file
+		}
+		return nil
+	},
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		return nil
+	},
+})
+
+// graphQLVisibility is the visibility of a file
+var graphQLVisibility = graphql.NewEnum(graphql.EnumConfig{
+	Name: "Visibility",
+	Values: graphql.EnumValueConfigMap{
+		"PUBLIC":  &graphql.EnumValueConfig{Value: storage.VisibilityPublic, Description: "Readable by anyone at its stable public URL"},
+		"PRIVATE": &graphql.EnumValueConfig{Value: storage.VisibilityPrivate, Description: "Only reachable through signed URLs, share links or the proxy"},
+	},
+})
+
+// nullable resolves a field holding its zero value to null, so unset
+// strings and times are not answered as "" and year 1
+func nullable(p graphql.ResolveParams) (interface{}, error) {
+	value, err := graphql.DefaultResolveFn(p)
+	if err != nil || value == nil || reflect.ValueOf(value).IsZero() {
+		return nil, err
+	}
+	return value, nil
+}
+
+// registerGraphQL serves the GraphQL endpoint on rg, for clients querying
+// and changing files in one endpoint. Every operation requires the scope of
+// the route group serving the same over REST and the same access to files.
+func registerGraphQL(rg *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	schema, err := newGraphQLSchema(&graphQLResolver{fs: fs, options: options})
+	if err != nil {
+		panic(fmt.Sprintf("filestorage: invalid GraphQL schema: %v", err))
+	}
+
+	// Run a query or mutation sent as JSON, or as a multipart request
+	// uploading files. Failed operations are answered with 200 and their
+	// errors, requests that are no GraphQL operation with 400.
+	rg.POST("/graphql", func(c *gin.Context) {
+		request, err := readGraphQLRequest(c, fs.MaxUploadSizeFor("/graphql"))
+		if err != nil {
+			c.AbortWithStatusJSON(bindErrorStatus(err), gin.H{"errors": []gin.H{{"message": err.Error()}}})
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  request.Query,
+			VariableValues: request.Variables,
+			OperationName:  request.OperationName,
+			Context:        context.WithValue(c.Request.Context(), graphQLContextKey{}, c),
+		})
+		c.JSON(http.StatusOK, result)
+	})
+}
+
+// readGraphQLRequest reads an operation sent as JSON or, following the
+// GraphQL multipart request specification, as an "operations" field whose
+// Upload variables are set to the file fields listed in the "map" field
+func readGraphQLRequest(c *gin.Context, limit int64) (*graphQLRequest, error) {
+	var request graphQLRequest
+	if c.ContentType() != "multipart/form-data" {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxGraphQLBody)
+		if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+			return nil, fmt.Errorf("invalid GraphQL request: %w", err)
+		}
+		return &request, nil
+	}
+
+	if limit > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+1<<20)
+	}
+	if _, err := c.MultipartForm(); err != nil {
+		return nil, fmt.Errorf("invalid multipart request: %w", err)
+	}
+	if err := json.Unmarshal([]byte(c.PostForm("operations")), &request); err != nil {
+		return nil, fmt.Errorf("invalid operations field: %w", err)
+	}
+
+	var files map[string][]string
+	if err := json.Unmarshal([]byte(c.PostForm("map")), &files); err != nil {
+		return nil, fmt.Errorf("invalid map field: %w", err)
+	}
+	for field, paths := range files {
+		file, err := c.FormFile(field)
+		if err != nil {
+			return nil, fmt.Errorf("file field %q: %w", field, err)
+		}
+		for _, path := range paths {
+			if err := setGraphQLVariable(request.Variables, path, file); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &request, nil
+}
+
+// setGraphQLVariable replaces the null a path of the multipart "map" field
+// names, e.g. "variables.file" or "variables.files.0", with a file
+func setGraphQLVariable(variables map[string]interface{}, path string, file *multipart.FileHeader) error {
+	segments := strings.Split(path, ".")
+	if len(segments) < 2 || segments[0] != "variables" {
+		return fmt.Errorf("invalid map path %q", path)
+	}
+
+	var parent interface{} = variables
+	for i, segment := range segments[1:] {
+		last := i == len(segments)-2
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[segment]; !ok {
+				return fmt.Errorf("invalid map path %q", path)
+			}
+			if last {
+				node[segment] = file
+				return nil
+			}
+			parent = node[segment]
+
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return fmt.Errorf("invalid map path %q", path)
+			}
+			if last {
+				node[index] = file
+				return nil
+			}
+			parent = node[index]
+
+		default:
+			return fmt.Errorf("invalid map path %q", path)
+		}
+	}
+	return nil
+}
+
+// graphQLResolver resolves the operations of the GraphQL endpoint with the
+// storage operations the REST endpoints use
+type graphQLResolver struct {
+	fs      *storage.FileStorageManager
+	options *routeOptions
+}
+
+// newGraphQLSchema returns the schema of the GraphQL endpoint. Deleting
+// files is left out when RouteConfig.DisableDelete is set.
+func newGraphQLSchema(r *graphQLResolver) (graphql.Schema, error) {
+	optionalString := &graphql.Field{Type: graphql.String, Resolve: nullable}
+	optionalTime := &graphql.Field{Type: graphql.DateTime, Resolve: nullable}
+
+	file := graphql.NewObject(graphql.ObjectConfig{
+		Name: "File",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"provider":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"bucket":      optionalString,
+			"name":        optionalString,
+			"extension":   optionalString,
+			"mimeType":    optionalString,
+			"size":        &graphql.Field{Type: graphql.Float, Description: "Size in bytes, a Float as files may exceed 2 GiB"},
+			"md5":         optionalString,
+			"sha256":      optionalString,
+			"publicLink":  optionalString,
+			"previewLink": optionalString,
+			"visibility":  &graphql.Field{Type: graphQLVisibility, Resolve: nullable},
+			"owner":       optionalString,
+			"scanStatus":  optionalString,
+			"tags":        &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"folderId":    optionalString,
+			"createdAt":   optionalTime,
+			"expiresAt":   optionalTime,
+		},
+	})
+
+	filePage := graphql.NewObject(graphql.ObjectConfig{
+		Name: "FilePage",
+		Fields: graphql.Fields{
+			"files":  &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(file)))},
+			"total":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Description: "Files matching the search on all pages"},
+			"offset": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"limit":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	link := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Link",
+		Fields: graphql.Fields{
+			"url":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"expiresAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		},
+	})
+
+	shareLink := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ShareLink",
+		Fields: graphql.Fields{
+			"token":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"provider":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"fileId":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"maxDownloads":  &graphql.Field{Type: graphql.Int, Resolve: nullable},
+			"downloads":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"allowedEmails": &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"expiresAt":     optionalTime,
+			"createdAt":     &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		},
+	})
+
+	fileArgs := graphql.FieldConfigArgument{
+		"provider": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String), Description: `"s3" or "gcs"`},
+		"id":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+	}
+	withFileArgs := func(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+		for name, arg := range fileArgs {
+			args[name] = arg
+		}
+		return args
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"file": &graphql.Field{
+				Type:        file,
+				Description: "A file, null when it does not exist",
+				Args:        fileArgs,
+				Resolve:     r.file,
+			},
+			"files": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(file))),
+				Description: "Several files, leaving out those that do not exist",
+				Args: graphql.FieldConfigArgument{
+					"provider": fileArgs["provider"],
+					"ids":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.ID)))},
+				},
+				Resolve: r.files,
+			},
+			"searchFiles": &graphql.Field{
+				Type:        graphql.NewNonNull(filePage),
+				Description: "Recorded files found by name, type, tag, owner, folder and upload time. Signed in users only find their own files.",
+				Args: graphql.FieldConfigArgument{
+					"name":     &graphql.ArgumentConfig{Type: graphql.String},
+					"mimeType": &graphql.ArgumentConfig{Type: graphql.String, Description: `e.g. "application/pdf" or "image/*"`},
+					"tag":      &graphql.ArgumentConfig{Type: graphql.String},
+					"owner":    &graphql.ArgumentConfig{Type: graphql.String},
+					"provider": &graphql.ArgumentConfig{Type: graphql.String},
+					"folderId": &graphql.ArgumentConfig{Type: graphql.String},
+					"from":     &graphql.ArgumentConfig{Type: graphql.DateTime},
+					"to":       &graphql.ArgumentConfig{Type: graphql.DateTime},
+					"sort":     &graphql.ArgumentConfig{Type: graphql.String, Description: `createdAt (default), name or size, "-" prefixed for descending`},
+					"offset":   &graphql.ArgumentConfig{Type: graphql.Int},
+					"limit":    &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.searchFiles,
+			},
+			"link": &graphql.Field{
+				Type:        graphql.NewNonNull(link),
+				Description: "A temporary link to a file",
+				Args: withFileArgs(graphql.FieldConfigArgument{
+					"expiresIn": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 3600, Description: "Seconds the link is valid, at most a week"},
+					"filename":  &graphql.ArgumentConfig{Type: graphql.String, Description: "Saves the file under this name"},
+				}),
+				Resolve: r.link,
+			},
+			"shareLinks": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(shareLink))),
+				Description: "Share links, optionally only those to a file. Signed in users only see the links to files they may read.",
+				Args: graphql.FieldConfigArgument{
+					"fileId": &graphql.ArgumentConfig{Type: graphql.ID},
+				},
+				Resolve: r.shareLinks,
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"uploadFile": &graphql.Field{
+				Type:        graphql.NewNonNull(file),
+				Description: "Upload a file sent as in the GraphQL multipart request specification",
+				Args: graphql.FieldConfigArgument{
+					"provider":  fileArgs["provider"],
+					"file":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphQLUpload)},
+					"prefix":    &graphql.ArgumentConfig{Type: graphql.String},
+					"owner":     &graphql.ArgumentConfig{Type: graphql.String, Description: "Owner named by services, users signed in with a JWT own their uploads"},
+					"expiresAt": &graphql.ArgumentConfig{Type: graphql.DateTime},
+				},
+				Resolve: r.uploadFile,
+			},
+			"setVisibility": &graphql.Field{
+				Type:        graphql.NewNonNull(file),
+				Description: "Make a file public at its stable URL or private behind signed URLs",
+				Args: withFileArgs(graphql.FieldConfigArgument{
+					"visibility": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphQLVisibility)},
+				}),
+				Resolve: r.setVisibility,
+			},
+		},
+	})
+	if !r.options.config.DisableDelete {
+		mutation.AddFieldConfig("deleteFile", &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.ID),
+			Description: "Delete a file, answering its ID",
+			Args:        fileArgs,
+			Resolve:     r.deleteFile,
+		})
+	}
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// request returns the gin context of an operation, failing unless the
+// request was granted the scope of a route group
+func (r *graphQLResolver) request(p graphql.ResolveParams, group string) (*gin.Context, error) {
+	c := p.Context.Value(graphQLContextKey{}).(*gin.Context)
+	if scope := r.options.groupScope(group); scope != "" && !middleware.Granted(c, scope, r.options.config.RoleScopes) {
+		detail := "missing scope " + scope
+		return nil, &graphQLError{message: middleware.Message(c, middleware.CodeMissingScope, detail), code: middleware.CodeMissingScope, detail: detail}
+	}
+	return c, nil
+}
+
+// checkProvider fails for an unknown or disabled provider
+func (r *graphQLResolver) checkProvider(c *gin.Context, provider string) error {
+	if (provider == storage.ProviderAWS || provider == storage.ProviderGCS) && r.options.providerEnabled(provider) {
+		return nil
+	}
+	return graphQLFailure(c, http.StatusNotFound, fmt.Errorf("unknown provider %q", provider))
+}
+
+// checkAccess fails unless the signed in user may access a file with a
+// permission
+func (r *graphQLResolver) checkAccess(c *gin.Context, permission string, fileIDs ...string) error {
+	if err := checkFileAccess(c, r.fs, r.options, permission, fileIDs...); err != nil {
+		return graphQLFailure(c, v2Status(err), err)
+	}
+	return nil
+}
+
+// fileOf returns a file from its info, completed by its record when the
+// metadata store has one
+func (r *graphQLResolver) fileOf(provider string, info *storage.FileInfo) *graphQLFile {
+	file := &graphQLFile{
+		ID:          info.FileID,
+		Provider:    provider,
+		Bucket:      info.Bucket,
+		Name:        info.FileName,
+		Extension:   info.FileExt,
+		MimeType:    info.FileMimeType,
+		Size:        info.FileSize,
+		MD5:         info.MD5,
+		SHA256:      info.SHA256,
+		PublicLink:  info.PublicLink,
+		PreviewLink: info.PreviewLink,
+		Visibility:  info.Visibility,
+		Owner:       info.Owner,
+		ScanStatus:  info.ScanStatus,
+		CreatedAt:   info.Timestamp,
+		ExpiresAt:   info.ExpiresAt,
+	}
+	if info.Tag != "" {
+		file.Tags = []string{info.Tag}
+	}
+
+	if record, err := r.fs.GetFileRecord(info.FileID); err == nil {
+		file.complete(record)
+	}
+	return file
+}
+
+// audit records an action on a file in the audit log
+func (r *graphQLResolver) audit(c *gin.Context, action, provider, fileID string) {
+	event := auditEvent(c, action)
+	event.Provider, event.FileID = provider, fileID
+	recordAudit(r.fs, event)
+}
+
+func (r *graphQLResolver) file(p graphql.ResolveParams) (interface{}, error) {
+	c, err := r.request(p, GroupRead)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, fileID := p.Args["provider"].(string), p.Args["id"].(string)
+	if err := r.checkProvider(c, provider); err != nil {
+		return nil, err
+	}
+	if err := r.checkAccess(c, storage.PermissionRead, fileID); err != nil {
+		return nil, err
+	}
+
+	info, exists, err := r.fs.Exists(provider, fileID, "", "")
+	if err != nil {
+		return nil, graphQLFailure(c, v2Status(err), err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	return r.fileOf(provider, info), nil
+}
+
+func (r *graphQLResolver) files(p graphql.ResolveParams) (interface{}, error) {
+	c, err := r.request(p, GroupRead)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := p.Args["provider"].(string)
+	if err := r.checkProvider(c, provider); err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, id := range p.Args["ids"].([]interface{}) {
+		ids = append(ids, id.(string))
+	}
+	if len(ids) == 0 {
+		return []*graphQLFile{}, nil
+	}
+	if err := r.checkAccess(c, storage.PermissionRead, ids...); err != nil {
+		return nil, err
+	}
+
+	result, err := r.fs.GetFileInfos(provider, ids, "", "")
+	if err := graphQLResult(c, result, err); err != nil {
+		return nil, err
+	}
+
+	files := []*graphQLFile{}
+	for _, found := range result.Results {
+		switch {
+		case found.Status == storage.StatusSuccess:
+			files = append(files, r.fileOf(provider, found.Info))
+		case found.Message != storage.ErrFileNotFound.Error():
+			return nil, graphQLFailure(c, http.StatusBadGateway, fmt.Errorf("%s: %s", found.FileID, found.Message))
+		}
+	}
+	return files, nil
+}
+
+func (r *graphQLResolver) searchFiles(p graphql.ResolveParams) (interface{}, error) {
+	c, err := r.request(p, GroupRead)
+	if err != nil {
+		return nil, err
+	}
+
+	query := storage.FileQuery{}
+	for name, value := range map[string]*string{
+		"name":     &query.Name,
+		"mimeType": &query.MimeType,
+		"tag":      &query.Tag,
+		"provider": &query.Provider,
+		"folderId": &query.Folder,
+		"sort":     &query.Sort,
+	} {
+		*value, _ = p.Args[name].(string)
+	}
+	query.From, _ = p.Args["from"].(time.Time)
+	query.To, _ = p.Args["to"].(time.Time)
+	query.Offset, _ = p.Args["offset"].(int)
+	query.Limit, _ = p.Args["limit"].(int)
+	query.Sort = strings.Replace(query.Sort, "createdAt", storage.SortCreatedAt, 1)
+
+	if query.Provider != "" {
+		if err := r.checkProvider(c, query.Provider); err != nil {
+			return nil, err
+		}
+	}
+
+	owner, _ := p.Args["owner"].(string)
+	if query.Owner, err = searchOwner(c, r.options, owner); err != nil {
+		return nil, graphQLFailure(c, errorStatus(err), err)
+	}
+
+	result, err := r.fs.SearchFiles(query)
+	if err != nil {
+		return nil, graphQLFailure(c, errorStatus(err), err)
+	}
+
+	page := &graphQLFilePage{Files: []*graphQLFile{}, Total: result.Total, Offset: result.Offset, Limit: result.Limit}
+	for _, record := range result.Files {
+		file := &graphQLFile{ID: record.FileID}
+		file.complete(record)
+		page.Files = append(page.Files, file)
+	}
+	return page, nil
+}
+
+func (r *graphQLResolver) link(p graphql.ResolveParams) (interface{}, error) {
+	c, err := r.request(p, GroupRead)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, fileID := p.Args["provider"].(string), p.Args["id"].(string)
+	if err := r.checkProvider(c, provider); err != nil {
+		return nil, err
+	}
+	if err := r.checkAccess(c, storage.PermissionRead, fileID); err != nil {
+		return nil, err
+	}
+
+	expiresIn, _ := p.Args["expiresIn"].(int)
+	if expiresIn <= 0 || expiresIn > 604800 {
+		return nil, graphQLFailure(c, http.StatusBadRequest, validationError{{Field: "expiresIn", Code: middleware.CodeInvalidValue}})
+	}
+
+	if _, exists, err := r.fs.Exists(provider, fileID, "", ""); err != nil || !exists {
+		if err == nil {
+			err = fmt.Errorf("%w: %s", storage.ErrFileNotFound, fileID)
+		}
+		return nil, graphQLFailure(c, v2Status(err), err)
+	}
+
+	var opts storage.LinkOptions
+	if filename, _ := p.Args["filename"].(string); filename != "" {
+		opts.ContentDisposition = storage.AttachmentDisposition(filename)
+	}
+
+	expiry := time.Now().Add(time.Duration(expiresIn) * time.Second)
+	var result *storage.FileResponse
+	if provider == storage.ProviderAWS {
+		result, err = r.fs.AwsGetTemporaryPublicLinkWithOptions(fileID, expiry, "", opts)
+	} else {
+		result, err = r.fs.GcsGetTemporaryPublicLinkWithOptions(fileID, expiry, "", "", opts)
+	}
+	if err := graphQLResult(c, result, err); err != nil {
+		return nil, err
+	}
+
+	r.audit(c, storage.AuditSignedURL, provider, fileID)
+	return v2Link{URL: result.URL, ExpiresAt: result.ExpiredAt}, nil
+}
+
+func (r *graphQLResolver) shareLinks(p graphql.ResolveParams) (interface{}, error) {
+	c, err := r.request(p, GroupShare)
+	if err != nil {
+		return nil, err
+	}
+
+	fileID, _ := p.Args["fileId"].(string)
+	links, err := r.fs.ListShareLinks(fileID)
+	if err != nil {
+		return nil, graphQLFailure(c, errorStatus(err), err)
+	}
+
+	readable := make([]*storage.ShareLink, 0, len(links))
+	for _, link := range links {
+		if checkFileAccess(c, r.fs, r.options, storage.PermissionRead, link.FileID) == nil {
+			readable = append(readable, link)
+		}
+	}
+	return readable, nil
+}
+
+func (r *graphQLResolver) uploadFile(p graphql.ResolveParams) (interface{}, error) {
+	c, err := r.request(p, GroupWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := p.Args["provider"].(string)
+	if err := r.checkProvider(c, provider); err != nil {
+		return nil, err
+	}
+
+	file, _ := p.Args["file"].(*multipart.FileHeader)
+	if file == nil {
+		return nil, graphQLFailure(c, http.StatusBadRequest, validationError{{Field: "file", Code: middleware.CodeRequired}})
+	}
+	if limit := r.fs.MaxUploadSizeFor("/graphql"); limit > 0 && file.Size > limit {
+		err := fmt.Errorf("%w: maximum upload size is %d bytes", storage.ErrFileTooLarge, limit)
+		return nil, graphQLFailure(c, http.StatusRequestEntityTooLarge, err)
+	}
+
+	opts := storage.UploadOptions{Context: c.Request.Context()}
+	opts.Prefix, _ = p.Args["prefix"].(string)
+	opts.ExpiresAt, _ = p.Args["expiresAt"].(time.Time)
+	owner, _ := p.Args["owner"].(string)
+	if opts.Owner, err = uploadOwner(c, owner); err != nil {
+		return nil, graphQLFailure(c, errorStatus(err), err)
+	}
+
+	var result *storage.FileResponse
+	if provider == storage.ProviderAWS {
+		result, err = r.fs.AwsUploadWithOptions(file, opts)
+	} else {
+		result, err = r.fs.GcsUploadWithOptions(file, opts)
+	}
+	if err := graphQLResult(c, result, err); err != nil {
+		return nil, err
+	}
+
+	info := result.Info
+	if info == nil {
+		info = &storage.FileInfo{FileID: result.FileID}
+	}
+	return r.fileOf(provider, info), nil
+}
+
+func (r *graphQLResolver) setVisibility(p graphql.ResolveParams) (interface{}, error) {
+	c, err := r.request(p, GroupWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, fileID := p.Args["provider"].(string), p.Args["id"].(string)
+	if err := r.checkProvider(c, provider); err != nil {
+		return nil, err
+	}
+	if err := r.checkAccess(c, storage.PermissionWrite, fileID); err != nil {
+		return nil, err
+	}
+
+	result, err := r.fs.SetVisibility(provider, fileID, "", "", p.Args["visibility"].(string))
+	if err := graphQLResult(c, result, err); err != nil {
+		return nil, err
+	}
+
+	info := result.Info
+	if info == nil {
+		info = &storage.FileInfo{FileID: fileID}
+	}
+	return r.fileOf(provider, info), nil
+}
+
+func (r *graphQLResolver) deleteFile(p graphql.ResolveParams) (interface{}, error) {
+	c, err := r.request(p, GroupDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, fileID := p.Args["provider"].(string), p.Args["id"].(string)
+	if err := r.checkProvider(c, provider); err != nil {
+		return nil, err
+	}
+	if err := r.checkAccess(c, storage.PermissionWrite, fileID); err != nil {
+		return nil, err
+	}
+
+	if _, exists, err := r.fs.Exists(provider, fileID, "", ""); err != nil || !exists {
+		if err == nil {
+			err = fmt.Errorf("%w: %s", storage.ErrFileNotFound, fileID)
+		}
+		return nil, graphQLFailure(c, v2Status(err), err)
+	}
+
+	var result *storage.FileResponse
+	if provider == storage.ProviderAWS {
+		result, err = r.fs.AwsDelete(fileID, "")
+	} else {
+		result, err = r.fs.GcsDelete(fileID, "", "")
+	}
+	if err := graphQLResult(c, result, err); err != nil {
+		return nil, err
+	}
+
+	r.audit(c, storage.AuditDelete, provider, fileID)
+	return fileID, nil
+}
+
+// graphQLResult returns the error of a failed storage operation, nil when
+// it succeeded. Responses with status "ERR" carry provider failures.
+func graphQLResult(c *gin.Context, result *storage.FileResponse, err error) error {
+	if err != nil {
+		return graphQLFailure(c, v2Status(err), err)
+	}
+	if result == nil || result.Status == storage.StatusError {
+		message := "provider failure"
+		if result != nil && result.Message != "" {
+			message = result.Message
+		}
+		return graphQLFailure(c, http.StatusBadGateway, errors.New(message))
+	}
+	return nil
+}
+
+// graphQLFailure returns the error an operation fails with, carrying the
+// localized message and code the REST endpoints answer err with at status
+func graphQLFailure(c *gin.Context, status int, err error) error {
+	code := errorCode(err, status)
+	return &graphQLError{message: middleware.Message(c, code, err.Error()), code: code, detail: err.Error()}
+}
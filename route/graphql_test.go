@@ -0,0 +1,219 @@
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSetGraphQLVariable(t *testing.T) {
+	file := &multipart.FileHeader{Filename: "report.pdf"}
+
+	for _, tt := range []struct {
+		name      string
+		variables string
+		path      string
+		wantErr   bool
+	}{
+		{name: "variable", variables: `{"file": null}`, path: "variables.file"},
+		{name: "list item", variables: `{"files": [null, null]}`, path: "variables.files.1"},
+		{name: "input field", variables: `{"input": {"file": null}}`, path: "variables.input.file"},
+		{name: "unknown variable", variables: `{"file": null}`, path: "variables.other", wantErr: true},
+		{name: "no variables", variables: `null`, path: "variables.file", wantErr: true},
+		{name: "index out of range", variables: `{"files": [null]}`, path: "variables.files.1", wantErr: true},
+		{name: "negative index", variables: `{"files": [null]}`, path: "variables.files.-1", wantErr: true},
+		{name: "index of an object", variables: `{"input": {"file": null}}`, path: "variables.input.0", wantErr: true},
+		{name: "field of a scalar", variables: `{"file": "x"}`, path: "variables.file.name", wantErr: true},
+		{name: "outside the variables", variables: `{"file": null}`, path: "query", wantErr: true},
+		{name: "only the variables", variables: `{"file": null}`, path: "variables", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var variables map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.variables), &variables); err != nil {
+				t.Fatal(err)
+			}
+
+			err := setGraphQLVariable(variables, tt.path, file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setGraphQLVariable() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			set, _ := json.Marshal(variables)
+			if !bytes.Contains(set, []byte(`"Filename":"report.pdf"`)) {
+				t.Errorf("variables = %s, want the file set", set)
+			}
+		})
+	}
+}
+
+// graphQLResponse is the answer of the GraphQL endpoint
+type graphQLResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// newGraphQLServer serves the GraphQL endpoint to a user signed in as
+// student-1 with scopes, backed by a memory metadata store
+func newGraphQLServer(t *testing.T, config RouteConfig, scopes ...string) (*gin.Engine, *storage.MemoryMetadataStore) {
+	t.Helper()
+
+	store := storage.NewMemoryMetadataStore()
+	fs := storage.NewFileStorageManager(&storage.Config{MaxUploadSize: 8}, nil)
+	fs.SetMetadataStore(store)
+
+	config.ServeGraphQL = true
+	signIn := func(c *gin.Context) {
+		c.Set(middleware.ClaimsKey, map[string]interface{}{"sub": "student-1"})
+		c.Set(middleware.SubjectKey, "student-1")
+		c.Set(middleware.ScopesKey, scopes)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	Register(&r.RouterGroup, fs, WithRouteConfig(config), WithMiddleware(signIn))
+	return r, store
+}
+
+func postGraphQL(t *testing.T, r *gin.Engine, req *http.Request) (int, graphQLResponse) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response graphQLResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, response
+}
+
+func graphQLQuery(t *testing.T, r *gin.Engine, query string, variables map[string]interface{}) (int, graphQLResponse) {
+	t.Helper()
+
+	body, _ := json.Marshal(graphQLRequest{Query: query, Variables: variables})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return postGraphQL(t, r, req)
+}
+
+// graphQLUploadRequest returns a multipart request uploading content as
+// the file variable, placed by the map field
+func graphQLUploadRequest(t *testing.T, provider, fileMap string, content []byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("operations", `{"query": "mutation ($file: Upload!) { uploadFile(provider: \"`+provider+`\", file: $file) { id } }", "variables": {"file": null}}`)
+	form.WriteField("map", fileMap)
+	part, err := form.CreateFormFile("0", "report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestGraphQLSearchesOwnFiles(t *testing.T) {
+	r, store := newGraphQLServer(t, RouteConfig{}, storage.ScopeFilesRead)
+	created := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	store.SaveFile(&storage.FileRecord{FileID: "own.pdf", Provider: storage.ProviderAWS, FileName: "own", FileExt: "pdf", FileSize: 5 << 30, Owner: "student-1", Tags: []string{"thesis"}, CreatedAt: created})
+	store.SaveFile(&storage.FileRecord{FileID: "other.pdf", Provider: storage.ProviderAWS, FileName: "other", Owner: "student-2", CreatedAt: created})
+
+	status, response := graphQLQuery(t, r, `{ searchFiles { total files { id provider name size tags folderId createdAt expiresAt } } }`, nil)
+	if status != http.StatusOK || len(response.Errors) > 0 {
+		t.Fatalf("status %d, errors %+v", status, response.Errors)
+	}
+
+	page := response.Data["searchFiles"].(map[string]interface{})
+	files := page["files"].([]interface{})
+	if page["total"] != 1.0 || len(files) != 1 {
+		t.Fatalf("searchFiles = %+v, want own.pdf only", page)
+	}
+	want := map[string]interface{}{
+		"id":        "own.pdf",
+		"provider":  storage.ProviderAWS,
+		"name":      "own",
+		"size":      float64(5 << 30),
+		"tags":      []interface{}{"thesis"},
+		"folderId":  nil,
+		"createdAt": "2026-03-01T08:00:00Z",
+		"expiresAt": nil,
+	}
+	got, _ := json.Marshal(files[0])
+	wanted, _ := json.Marshal(want)
+	if !bytes.Equal(got, wanted) {
+		t.Errorf("file = %s, want %s", got, wanted)
+	}
+
+	_, response = graphQLQuery(t, r, `query ($owner: String) { searchFiles(owner: $owner) { total } }`, map[string]interface{}{"owner": "student-2"})
+	if len(response.Errors) != 1 || response.Errors[0].Extensions["code"] != middleware.CodeNotOwner {
+		t.Errorf("errors = %+v, want %s", response.Errors, middleware.CodeNotOwner)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	r, _ := newGraphQLServer(t, RouteConfig{DisableGCS: true}, storage.ScopeFilesRead)
+
+	for _, tt := range []struct {
+		name  string
+		query string
+		code  string
+	}{
+		{"missing scope", `mutation { deleteFile(provider: "s3", id: "report.pdf") }`, middleware.CodeMissingScope},
+		{"unknown provider", `{ file(provider: "ftp", id: "report.pdf") { id } }`, middleware.CodeNotFound},
+		{"disabled provider", `{ files(provider: "gcs", ids: ["report.pdf"]) { id } }`, middleware.CodeNotFound},
+		{"link valid too long", `{ link(provider: "s3", id: "report.pdf", expiresIn: 604801) { url } }`, middleware.CodeValidationFailed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response := graphQLQuery(t, r, tt.query, nil)
+			if status != http.StatusOK {
+				t.Errorf("status = %d, want 200", status)
+			}
+			if len(response.Errors) != 1 || response.Errors[0].Extensions["code"] != tt.code {
+				t.Errorf("errors = %+v, want %s", response.Errors, tt.code)
+			}
+		})
+	}
+}
+
+func TestGraphQLLeavesOutDisabledDeletes(t *testing.T) {
+	r, _ := newGraphQLServer(t, RouteConfig{DisableDelete: true})
+
+	_, response := graphQLQuery(t, r, `mutation { deleteFile(provider: "s3", id: "report.pdf") }`, nil)
+	if len(response.Errors) != 1 || response.Errors[0].Extensions != nil {
+		t.Errorf("errors = %+v, want an unknown field", response.Errors)
+	}
+}
+
+func TestGraphQLUploads(t *testing.T) {
+	r, _ := newGraphQLServer(t, RouteConfig{}, storage.ScopeFilesWrite)
+
+	status, response := postGraphQL(t, r, graphQLUploadRequest(t, storage.ProviderAWS, `{"0": ["variables.file"]}`, []byte("more than 8 bytes")))
+	if status != http.StatusOK || len(response.Errors) != 1 || response.Errors[0].Extensions["code"] != middleware.CodeFileTooLarge {
+		t.Errorf("status %d, errors %+v, want %s", status, response.Errors, middleware.CodeFileTooLarge)
+	}
+
+	for _, fileMap := range []string{`{"0": ["variables.other"]}`, `{"1": ["variables.file"]}`, `not json`} {
+		if status, _ := postGraphQL(t, r, graphQLUploadRequest(t, storage.ProviderAWS, fileMap, []byte("pdf"))); status != http.StatusBadRequest {
+			t.Errorf("map %s: status = %d, want 400", fileMap, status)
+		}
+	}
+}
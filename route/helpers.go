@@ -0,0 +1,536 @@
+// route/helpers.go
+package route
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// formFile reads the multipart "file" field, writing an error response and
+// returning false when it is missing or larger than limit
+func formFile(c *gin.Context, limit int64) (*multipart.FileHeader, bool) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("file exceeds maximum upload size of %d bytes", limit))
+			return nil, false
+		}
+		respondError(c, http.StatusBadRequest, formFileError(err))
+		return nil, false
+	}
+
+	if limit > 0 && file.Size > limit {
+		respondError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("file exceeds maximum upload size of %d bytes", limit))
+		return nil, false
+	}
+
+	return file, true
+}
+
+// maxBatchFiles is the most "files[]" fields an upload request may carry,
+// each up to the maximum upload size
+const maxBatchFiles = 20
+
+// handleUpload uploads the multipart "file" field with upload, or every
+// "files[]" field through UploadMany when the request carries several files.
+// The size limit applies to each file. With ?async=true the upload is queued
+// as a background job instead.
+func handleUpload(c *gin.Context, fs *storage.FileStorageManager, limit int64, upload storage.UploadFunc) {
+	if form, err := c.MultipartForm(); err == nil && len(form.File["files[]"]) > 0 {
+		files := form.File["files[]"]
+		if len(files) > maxBatchFiles {
+			respondError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("at most %d files may be uploaded at once", maxBatchFiles))
+			return
+		}
+		for _, file := range files {
+			if limit > 0 && file.Size > limit {
+				respondError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("%s exceeds maximum upload size of %d bytes", file.Filename, limit))
+				return
+			}
+		}
+
+		if isAsync(c) {
+			job, err := fs.SubmitUploadMany(files, upload)
+			respondJob(c, fs, job, err)
+			return
+		}
+
+		c.JSON(200, fs.UploadMany(files, upload))
+		return
+	}
+
+	file, ok := formFile(c, limit)
+	if !ok {
+		return
+	}
+
+	if isAsync(c) {
+		job, err := fs.SubmitUpload(file, upload)
+		respondJob(c, fs, job, err)
+		return
+	}
+
+	result, err := upload(file)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	c.JSON(200, result)
+}
+
+// objectHeaders reads the optional cache_control, content_disposition and
+// content_language form fields stored with uploaded objects
+func objectHeaders(c *gin.Context) storage.ObjectHeaders {
+	return storage.ObjectHeaders{
+		CacheControl:       c.PostForm("cache_control"),
+		ContentDisposition: c.PostForm("content_disposition"),
+		ContentLanguage:    c.PostForm("content_language"),
+	}
+}
+
+// uploadFormOptions reads the upload options of the optional form fields:
+// the object headers, the owner, which users signed in with a JWT are,
+// expires_at, an RFC 3339 time the file is deleted at, and custom
+// attributes as attributes[name] fields. The upload is cancelled when the
+// client disconnects, unless it is queued with ?async=true and outlives the
+// request.
+func uploadFormOptions(c *gin.Context) (storage.UploadOptions, error) {
+	opts := storage.UploadOptions{Headers: objectHeaders(c), Attributes: c.PostFormMap("attributes"), Context: uploadContext(c)}
+
+	owner, err := uploadOwner(c, c.PostForm("owner"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Owner = owner
+
+	if value := c.PostForm("expires_at"); value != "" {
+		if opts.ExpiresAt, err = time.Parse(time.RFC3339, value); err != nil {
+			return opts, validationError{{Field: "expires_at", Code: middleware.CodeInvalidValue}}
+		}
+	}
+
+	return opts, nil
+}
+
+// serveFile streams an opened file, answering Range requests with partial
+// content. Files of unknown size are sent whole. Content is copied from the
+// provider to the client through a fixed-size buffer and flushed as it
+// arrives, so memory use does not grow with the size of the file.
+func serveFile(c *gin.Context, file servedFile, err error) {
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	defer file.Close()
+
+	if notModified(c, file.ETag(), file.ModTime()) {
+		return
+	}
+
+	if file.ContentType() != "" {
+		c.Header("Content-Type", file.ContentType())
+	}
+
+	w := flushWriter{ResponseWriter: c.Writer}
+	if file.Size() < 0 {
+		c.Status(http.StatusOK)
+		storage.Copy(w, file)
+		return
+	}
+
+	http.ServeContent(w, c.Request, file.Name(), file.ModTime(), file)
+}
+
+// servedFile is a file streamed by serveFile, such as a *storage.ObjectFile
+type servedFile interface {
+	io.ReadSeekCloser
+	Name() string
+	ContentType() string
+	ETag() string
+	Size() int64 // -1 when not known
+	ModTime() time.Time
+}
+
+// flushWriter passes every write of a streamed file on to the client right
+// away, rather than holding it back in the buffer of the response
+type flushWriter struct {
+	gin.ResponseWriter
+}
+
+// Write implements io.Writer, flushing what was written
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.Flush()
+	return n, err
+}
+
+// notModified sets the ETag and Last-Modified validators of a file and answers
+// 304 Not Modified when the client's cached copy is still current.
+// If-Modified-Since is only consulted when no If-None-Match was sent.
+func notModified(c *gin.Context, etag string, modTime time.Time) bool {
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	if !modTime.IsZero() {
+		c.Header("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return false
+	}
+
+	match := false
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		match = etag != "" && etagMatches(inm, etag)
+	} else if ims := c.GetHeader("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		if since, err := http.ParseTime(ims); err == nil {
+			match = !modTime.Truncate(time.Second).After(since)
+		}
+	}
+
+	if match {
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+	}
+	return match
+}
+
+// etagMatches compares an If-None-Match list with an entity tag, ignoring weakness
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentWriter sets the download headers on the first write, so an error
+// before any content can still be answered with an error status
+type attachmentWriter struct {
+	c           *gin.Context
+	contentType string
+	filename    string
+	started     bool
+}
+
+// Write implements io.Writer
+func (w *attachmentWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.c.Header("Content-Type", w.contentType)
+		w.c.Header("Content-Disposition", storage.AttachmentDisposition(w.filename))
+		w.c.Status(http.StatusOK)
+	}
+	return w.c.Writer.Write(p)
+}
+
+// exportFilter reads the include and exclude patterns of an export request
+func exportFilter(c *gin.Context) storage.ExportFilter {
+	return storage.ExportFilter{
+		Include: c.QueryArray("include"),
+		Exclude: c.QueryArray("exclude"),
+	}
+}
+
+// linkRestrictions reads the restrictions of a temporary link request:
+// ?restrict_ip=true binds the link to the requesting client's IP
+func linkRestrictions(c *gin.Context) storage.LinkOptions {
+	var opts storage.LinkOptions
+	if c.Query("restrict_ip") == "true" {
+		opts.ClientIP = c.ClientIP()
+	}
+	return opts
+}
+
+// sharePassword reads the password of a share link request from the
+// X-Share-Password header, falling back to ?password= for plain browser links
+func sharePassword(c *gin.Context) string {
+	if password := c.GetHeader("X-Share-Password"); password != "" {
+		return password
+	}
+	return c.Query("password")
+}
+
+// shareCode reads the one-time code verifying the email of a share link
+// recipient from the X-Share-Code header, falling back to ?code= for plain
+// browser links
+func shareCode(c *gin.Context) string {
+	if code := c.GetHeader("X-Share-Code"); code != "" {
+		return code
+	}
+	return c.Query("code")
+}
+
+// resumesDownload reports whether a Range request starts past the first
+// byte, continuing a download rather than starting one
+func resumesDownload(c *gin.Context) bool {
+	spec, ranged := strings.CutPrefix(c.GetHeader("Range"), "bytes=")
+	if !ranged {
+		return false
+	}
+	first, _, _ := strings.Cut(spec, ",")
+	start, _, _ := strings.Cut(strings.TrimSpace(first), "-")
+	offset, err := strconv.ParseInt(start, 10, 64)
+	return err != nil || offset > 0
+}
+
+// verifiedEmail returns the email of the signed in user when their identity
+// provider verified it, "" otherwise
+func verifiedEmail(c *gin.Context) string {
+	value, _ := c.Get(middleware.ClaimsKey)
+	claims, ok := value.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	email, _ := claims["email"].(string)
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return ""
+	}
+	return email
+}
+
+// absoluteURL returns the URL of a path on this service as the client reached
+// it, honoring X-Forwarded-Proto set by a TLS-terminating proxy
+func absoluteURL(c *gin.Context, path string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host + path
+}
+
+// isAsync reports whether the client asked for the request to be processed as a background job
+func isAsync(c *gin.Context) bool {
+	return c.Query("async") == "true"
+}
+
+// uploadContext returns the context an upload runs in: the request context,
+// cancelled when the client disconnects, unless the upload is queued with
+// ?async=true and outlives the request
+func uploadContext(c *gin.Context) context.Context {
+	if isAsync(c) {
+		return context.Background()
+	}
+	return c.Request.Context()
+}
+
+// includeData reports whether the client asked for the deprecated base64
+// content of a file along with its info
+func includeData(c *gin.Context) bool {
+	return c.Query("include_data") == "true"
+}
+
+// respondJob answers a queued request with the job to poll at /jobs/:id.
+// Jobs queued by signed in users are kept from others.
+func respondJob(c *gin.Context, fs *storage.FileStorageManager, job *storage.Job, err error) {
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	if _, user := c.Get(middleware.ClaimsKey); user {
+		job.Owner = c.GetString(middleware.SubjectKey)
+		fs.SetJobOwner(job.ID, job.Owner)
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// errorStatus maps storage errors to HTTP status codes
+func errorStatus(err error) int {
+	var fields validationError
+	switch {
+	case errors.As(err, &fields):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, storage.ErrFileTypeNotAllowed), errors.Is(err, storage.ErrMimeTypeMismatch):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, storage.ErrArchiveLimitExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, storage.ErrInvalidImage), errors.Is(err, storage.ErrFileInfected), errors.Is(err, storage.ErrInvalidArchive):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, storage.ErrScanFailed), errors.Is(err, storage.ErrQueueFull), errors.Is(err, storage.ErrShuttingDown),
+		errors.Is(err, storage.ErrProviderBusy), errors.Is(err, storage.ErrMemoryBudgetExceeded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, storage.ErrCDNNotConfigured), errors.Is(err, storage.ErrScannerNotConfigured),
+		errors.Is(err, storage.ErrStatsNotSupported), errors.Is(err, storage.ErrSearchNotSupported), errors.Is(err, storage.ErrTagsNotSupported),
+		errors.Is(err, storage.ErrFoldersNotSupported), errors.Is(err, storage.ErrAuditNotConfigured), errors.Is(err, storage.ErrAccessNotSupported),
+		errors.Is(err, storage.ErrExpiryNotSupported), errors.Is(err, storage.ErrReconcileNotSupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, storage.ErrInvalidObjectHeader), errors.Is(err, storage.ErrInvalidPattern),
+		errors.Is(err, storage.ErrInvalidRestriction), errors.Is(err, storage.ErrUnsupportedRestriction),
+		errors.Is(err, storage.ErrInvalidVisibility), errors.Is(err, storage.ErrInvalidThumbnailSize),
+		errors.Is(err, storage.ErrTooManyFiles), errors.Is(err, storage.ErrInvalidScope), errors.Is(err, storage.ErrInvalidChunk),
+		errors.Is(err, storage.ErrInvalidQuery), errors.Is(err, storage.ErrInvalidTag), errors.Is(err, storage.ErrInvalidFolder), errors.Is(err, storage.ErrInvalidPrefix),
+		errors.Is(err, storage.ErrInvalidGrant), errors.Is(err, storage.ErrInvalidExpiry), errors.Is(err, storage.ErrInvalidInventoryFormat),
+		errors.Is(err, storage.ErrInvalidMonth), errors.Is(err, storage.ErrInvalidAttributes), errors.Is(err, storage.ErrInvalidSchedule):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrJobNotFound), errors.Is(err, storage.ErrFileNotFound), errors.Is(err, storage.ErrShareLinkNotFound),
+		errors.Is(err, storage.ErrShortLinkNotFound), errors.Is(err, storage.ErrAPIKeyNotFound), errors.Is(err, storage.ErrUploadNotFound),
+		errors.Is(err, storage.ErrFolderNotFound), errors.Is(err, storage.ErrReportNotFound),
+		errors.Is(err, storage.ErrVersionNotFound), errors.Is(err, storage.ErrScheduledJobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrShareLinkExpired), errors.Is(err, storage.ErrShareLinkExhausted), errors.Is(err, storage.ErrShortLinkExpired),
+		errors.Is(err, storage.ErrAPIKeyRevoked), errors.Is(err, storage.ErrUploadExpired):
+		return http.StatusGone
+	case errors.Is(err, storage.ErrShareLinkForbidden), errors.Is(err, storage.ErrDownloadTokenInvalid), errors.Is(err, storage.ErrNotOwner):
+		return http.StatusForbidden
+	case errors.Is(err, storage.ErrIncompleteUpload), errors.Is(err, storage.ErrOffsetMismatch), errors.Is(err, storage.ErrFileExists),
+		errors.Is(err, storage.ErrFolderExists), errors.Is(err, storage.ErrFolderNotEmpty), errors.Is(err, storage.ErrScheduledJobRunning):
+		return http.StatusConflict
+	default:
+		return 500
+	}
+}
+
+// errorCodes maps storage errors to the stable codes of error responses
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{storage.ErrFileTooLarge, middleware.CodeFileTooLarge},
+	{storage.ErrFileTypeNotAllowed, middleware.CodeFileTypeNotAllowed},
+	{storage.ErrMimeTypeMismatch, middleware.CodeFileTypeMismatch},
+	{storage.ErrArchiveLimitExceeded, middleware.CodeArchiveTooLarge},
+	{storage.ErrInvalidImage, middleware.CodeInvalidImage},
+	{storage.ErrFileInfected, middleware.CodeFileInfected},
+	{storage.ErrInvalidArchive, middleware.CodeInvalidArchive},
+	{storage.ErrScanFailed, middleware.CodeServiceBusy},
+	{storage.ErrQueueFull, middleware.CodeServiceBusy},
+	{storage.ErrShuttingDown, middleware.CodeServiceBusy},
+	{storage.ErrProviderBusy, middleware.CodeServiceBusy},
+	{storage.ErrMemoryBudgetExceeded, middleware.CodeServiceBusy},
+	{storage.ErrCDNNotConfigured, middleware.CodeNotConfigured},
+	{storage.ErrScannerNotConfigured, middleware.CodeNotConfigured},
+	{storage.ErrStatsNotSupported, middleware.CodeNotConfigured},
+	{storage.ErrSearchNotSupported, middleware.CodeNotConfigured},
+	{storage.ErrTagsNotSupported, middleware.CodeNotConfigured},
+	{storage.ErrFoldersNotSupported, middleware.CodeNotConfigured},
+	{storage.ErrAuditNotConfigured, middleware.CodeNotConfigured},
+	{storage.ErrAccessNotSupported, middleware.CodeNotConfigured},
+	{storage.ErrExpiryNotSupported, middleware.CodeNotConfigured},
+	{storage.ErrReconcileNotSupported, middleware.CodeNotConfigured},
+	{storage.ErrTooManyFiles, middleware.CodeTooManyFiles},
+	{storage.ErrFileNotFound, middleware.CodeFileNotFound},
+	{storage.ErrFileExists, middleware.CodeFileExists},
+	{storage.ErrFolderNotFound, middleware.CodeFolderNotFound},
+	{storage.ErrFolderExists, middleware.CodeFolderExists},
+	{storage.ErrFolderNotEmpty, middleware.CodeFolderNotEmpty},
+	{storage.ErrShareLinkNotFound, middleware.CodeLinkNotFound},
+	{storage.ErrShortLinkNotFound, middleware.CodeLinkNotFound},
+	{storage.ErrShareLinkExpired, middleware.CodeLinkExpired},
+	{storage.ErrShortLinkExpired, middleware.CodeLinkExpired},
+	{storage.ErrShareLinkExhausted, middleware.CodeLinkExhausted},
+	{storage.ErrShareLinkForbidden, middleware.CodeLinkAccessDenied},
+	{storage.ErrDownloadTokenInvalid, middleware.CodeDownloadTokenInvalid},
+	{storage.ErrAPIKeyRevoked, middleware.CodeAPIKeyInvalid},
+	{storage.ErrNotOwner, middleware.CodeNotOwner},
+	{storage.ErrIncompleteUpload, middleware.CodeUploadIncomplete},
+	{storage.ErrUploadExpired, middleware.CodeUploadExpired},
+}
+
+// errorCode returns the code of an error response, falling back to a
+// generic code for the status when err is not a known storage error
+func errorCode(err error, status int) string {
+	var fields validationError
+	var attributes storage.AttributeError
+	if errors.As(err, &fields) || errors.As(err, &attributes) {
+		return middleware.CodeValidationFailed
+	}
+
+	for _, known := range errorCodes {
+		if errors.Is(err, known.err) {
+			return known.code
+		}
+	}
+
+	switch {
+	case status == http.StatusNotFound:
+		return middleware.CodeNotFound
+	case status == http.StatusRequestEntityTooLarge:
+		return middleware.CodeFileTooLarge
+	case status == http.StatusBadGateway:
+		return middleware.CodeProviderFailed
+	case status == http.StatusServiceUnavailable:
+		return middleware.CodeServiceBusy
+	case status >= 400 && status < 500:
+		return middleware.CodeInvalidRequest
+	default:
+		return middleware.CodeInternalError
+	}
+}
+
+// respondError answers a failed request with the localized message and
+// code of err, keeping err's text as the detail. Validation errors list
+// the invalid fields under "fields".
+func respondError(c *gin.Context, status int, err error) {
+	setRetryAfter(c, err)
+	body := middleware.ErrorBody(c, errorCode(err, status), err.Error())
+
+	var fields validationError
+	var attributes storage.AttributeError
+	switch {
+	case errors.As(err, &fields):
+		body["fields"] = fields.localized(c)
+	case errors.As(err, &attributes):
+		body["fields"] = attributeFields(attributes).localized(c)
+	}
+
+	c.JSON(status, body)
+}
+
+// overloadRetryAfter is the Retry-After in seconds of requests refused
+// while the service is overloaded
+const overloadRetryAfter = "5"
+
+// setRetryAfter tells clients refused because the memory budget or a
+// provider is exhausted when to try again
+func setRetryAfter(c *gin.Context, err error) {
+	if errors.Is(err, storage.ErrMemoryBudgetExceeded) || errors.Is(err, storage.ErrProviderBusy) {
+		c.Header("Retry-After", overloadRetryAfter)
+	}
+}
+
+// bindErrorStatus maps request binding errors to HTTP status codes
+func bindErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return 400
+}
+
+// uploadLimit returns the upload size limit of a route as read by
+// middleware.MaxUploadSize, which reads it for every request
+func uploadLimit(fs *storage.FileStorageManager, route string) func() int64 {
+	return func() int64 { return fs.MaxUploadSizeFor(route) }
+}
+
+// base64Limit converts a file size limit into the equivalent base64 payload size
+func base64Limit(limit int64) int64 {
+	if limit <= 0 {
+		return 0
+	}
+	return int64(base64.StdEncoding.EncodedLen(int(limit)))
+}
@@ -0,0 +1,214 @@
+package route
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// batchUploadRequest returns a request uploading a files[] field of size
+// bytes for each of sizes
+func batchUploadRequest(t *testing.T, sizes ...int) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for i, size := range sizes {
+		part, err := form.CreateFormFile("files[]", fmt.Sprintf("notes-%d.txt", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(bytes.Repeat([]byte("a"), size))
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+// staticToken is a token manager always handing out the same token
+type staticToken struct{}
+
+func (staticToken) GenerateToken() (string, error) { return "token", nil }
+func (staticToken) GetToken() (string, error)      { return "token", nil }
+func (staticToken) HasToken() bool                 { return true }
+
+func TestBatchUploadSizeLimit(t *testing.T) {
+	const limit = 2 << 20
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status": %q}`, storage.StatusSuccess)
+	}))
+	t.Cleanup(backend.Close)
+
+	fs := storage.NewFileStorageManager(&storage.Config{HostURI: backend.URL, MaxUploadSize: limit}, staticToken{})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	Register(&r.RouterGroup, fs)
+
+	t.Run("files under the limit", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, batchUploadRequest(t, 3<<19, 3<<19, 3<<19))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+
+		var response storage.FileResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Status != storage.StatusSuccess || len(response.Results) != 3 {
+			t.Errorf("response = %+v, want every file uploaded", response)
+		}
+	})
+
+	t.Run("file over the limit", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, batchUploadRequest(t, 1<<10, limit+1))
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want 413", w.Code)
+		}
+	})
+
+	t.Run("too many files", func(t *testing.T) {
+		sizes := make([]int, maxBatchFiles+1)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, batchUploadRequest(t, sizes...))
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want 413", w.Code)
+		}
+	})
+}
+
+func TestUploadLimitChanges(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status": %q, "file_id": "notes.txt"}`, storage.StatusSuccess)
+	}))
+	t.Cleanup(backend.Close)
+
+	fs := storage.NewFileStorageManager(&storage.Config{HostURI: backend.URL, MaxUploadSize: 1 << 10}, staticToken{})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	Register(&r.RouterGroup, fs)
+
+	body, _ := json.Marshal(map[string]string{
+		"filename":       "notes",
+		"extension":      "txt",
+		"mime_type":      "text/plain",
+		"base64_content": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("a"), 2<<20)),
+	})
+	upload := func() int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upload-base64", bytes.NewReader(body)))
+		return w.Code
+	}
+
+	if status := upload(); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", status)
+	}
+	fs.SetMaxUploadSize(4 << 20)
+	if status := upload(); status != http.StatusOK {
+		t.Errorf("status = %d after raising the limit, want 200", status)
+	}
+}
+
+func TestResumesDownload(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"bytes=0-", false},
+		{"bytes=0-1023", false},
+		{"bytes=0-1023, 4096-", false},
+		{"bytes=1024-", true},
+		{"bytes=-512", true},
+		{"items=1024-", false},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/shared/token", nil)
+		c.Request.Header.Set("Range", tt.header)
+
+		if got := resumesDownload(c); got != tt.want {
+			t.Errorf("resumesDownload(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// zeroFile is a served file of size zero bytes, produced as it is read
+type zeroFile struct {
+	size   int64
+	offset int64
+}
+
+func (f *zeroFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), f.size-f.offset))
+	clear(p[:n])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *zeroFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *zeroFile) Close() error        { return nil }
+func (f *zeroFile) Name() string        { return "lecture.mp4" }
+func (f *zeroFile) ContentType() string { return "video/mp4" }
+func (f *zeroFile) ETag() string        { return `"lecture"` }
+func (f *zeroFile) Size() int64         { return f.size }
+func (f *zeroFile) ModTime() time.Time  { return time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC) }
+
+// discardWriter is a response writer dropping the body, so only the memory
+// serveFile itself uses is measured
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+func (w *discardWriter) Flush()                      {}
+
+// BenchmarkServeFile proxies files of growing size to the client. The bytes
+// allocated per download stay the same whatever the size of the file, since
+// it is copied through a fixed-size buffer and never held in full.
+func BenchmarkServeFile(b *testing.B) {
+	gin.SetMode(gin.TestMode)
+
+	for _, size := range []int64{1 << 20, 64 << 20, 1 << 30} {
+		b.Run(fmt.Sprintf("%dMiB", size>>20), func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				w := &discardWriter{header: http.Header{}}
+				c, _ := gin.CreateTestContext(w)
+				c.Request = httptest.NewRequest(http.MethodGet, "/download", nil)
+
+				serveFile(c, &zeroFile{size: size}, nil)
+			}
+		})
+	}
+}
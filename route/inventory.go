@@ -0,0 +1,79 @@
+// route/inventory.go
+package route
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerInventory serves inventories of the objects in a bucket, with
+// their size, modification time, storage class and checksum, on an admin
+// group for audits and cost reviews
+func registerInventory(admin *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	// Stream the inventory of ?prefix= in the bucket of ?provider=, the
+	// configured one unless ?bucket= is given, as ?format=csv (default) or
+	// json, one object per line
+	admin.GET("/inventory", func(c *gin.Context) {
+		provider, prefix, format, ok := inventoryRequest(c, options)
+		if !ok {
+			return
+		}
+
+		filename := "inventory." + format
+		if prefix != "" {
+			filename = path.Base(prefix) + "-" + filename
+		}
+
+		w := &attachmentWriter{c: c, contentType: storage.InventoryContentType(format), filename: filename}
+		if _, err := fs.WriteInventory(w, provider, prefix, format, c.Query("bucket"), ""); err != nil {
+			if !w.started {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			// The inventory is already being sent, so it can only be cut short
+			c.Error(err)
+			return
+		}
+		if !w.started {
+			// Empty JSON inventories write nothing
+			w.Write(nil)
+		}
+	})
+
+	// Store the inventory in the bucket below storage.InventoryPrefix in the
+	// background; the job's result holds its key
+	admin.POST("/inventory", func(c *gin.Context) {
+		provider, prefix, format, ok := inventoryRequest(c, options)
+		if !ok {
+			return
+		}
+
+		bucket := c.Query("bucket")
+		job, err := fs.SubmitJob(func() (*storage.FileResponse, error) {
+			return fs.StoreInventory(provider, prefix, format, bucket, "")
+		})
+		respondJob(c, fs, job, err)
+	})
+}
+
+// inventoryRequest reads the provider, prefix and format of an inventory
+// request, answering an error and reporting false when they are invalid
+func inventoryRequest(c *gin.Context, options *routeOptions) (provider, prefix, format string, ok bool) {
+	provider, prefix = c.Query("provider"), strings.Trim(c.Query("prefix"), "/")
+	if rejectStoredProvider(c, options, provider) {
+		return "", "", "", false
+	}
+
+	format = c.DefaultQuery("format", storage.InventoryCSV)
+	if format != storage.InventoryCSV && format != storage.InventoryJSON {
+		respondError(c, http.StatusBadRequest, validationError{{Field: "format", Code: middleware.CodeInvalidValue}})
+		return "", "", "", false
+	}
+
+	return provider, prefix, format, true
+}
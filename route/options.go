@@ -0,0 +1,155 @@
+// route/options.go
+package route
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	securityMiddleware "github.com/SIM-MBKM/mod-service/src/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// RouteOption configures the endpoints mounted by Register
+type RouteOption func(*routeOptions)
+
+// routeOptions holds the settings applied by RouteOptions
+type routeOptions struct {
+	config         RouteConfig
+	authenticators []middleware.Authenticator
+	middleware     []gin.HandlerFunc
+	admin          []gin.HandlerFunc
+	limiter        middleware.RateLimiter
+	rateLimit      []gin.HandlerFunc
+	loadShed       []gin.HandlerFunc
+
+	accessLogger *slog.Logger
+}
+
+// newRouteOptions applies opts to the default settings
+func newRouteOptions(opts []RouteOption) *routeOptions {
+	options := &routeOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithRouteConfig sets the base path and the exposed endpoints
+func WithRouteConfig(config RouteConfig) RouteOption {
+	return func(o *routeOptions) {
+		o.config = config
+	}
+}
+
+// WithAccessKey accepts the mod-service access key on the protected
+// endpoints. SetupRouter adds it when RouteConfig.Auth lists it.
+func WithAccessKey(secretKey string, expireSeconds int64) RouteOption {
+	return WithAuthenticator(middleware.Authenticator{
+		Name:    middleware.MethodAccessKey,
+		Present: middleware.HeaderPresent("Access-Key"),
+		Handler: securityMiddleware.AccessKeyMiddleware(secretKey, expireSeconds),
+	})
+}
+
+// WithAuthenticator accepts an authentication method on the protected
+// endpoints, besides those listed in RouteConfig.Auth. A request is
+// authenticated by the first method whose credentials it carries.
+func WithAuthenticator(methods ...middleware.Authenticator) RouteOption {
+	return func(o *routeOptions) {
+		o.authenticators = append(o.authenticators, methods...)
+	}
+}
+
+// WithMiddleware runs handlers before every protected endpoint, after the
+// configured authentication methods, e.g. the authentication of the
+// application the endpoints are mounted in
+func WithMiddleware(handlers ...gin.HandlerFunc) RouteOption {
+	return func(o *routeOptions) {
+		o.middleware = append(o.middleware, handlers...)
+	}
+}
+
+// WithAdminMiddleware runs handlers before every /admin endpoint, after the
+// middleware of the protected endpoints, e.g. to require an administrator role
+func WithAdminMiddleware(handlers ...gin.HandlerFunc) RouteOption {
+	return func(o *routeOptions) {
+		o.admin = append(o.admin, handlers...)
+	}
+}
+
+// WithRateLimiter sets the limiter enforcing RouteConfig.RateLimits instead
+// of one chosen by RouteConfig.RedisURL
+func WithRateLimiter(limiter middleware.RateLimiter) RouteOption {
+	return func(o *routeOptions) {
+		o.limiter = limiter
+	}
+}
+
+// WithAccessLogger sets the logger SetupRouter writes access logs to, instead
+// of JSON on standard output. Register leaves access logging to the application.
+func WithAccessLogger(logger *slog.Logger) RouteOption {
+	return func(o *routeOptions) {
+		o.accessLogger = logger
+	}
+}
+
+// adminMiddleware returns the handlers guarding the /admin endpoints, or nil
+// when none is configured and the endpoints must not be served. The admin key
+// authenticates requests by itself, so the first API key can be issued with it.
+func (o *routeOptions) adminMiddleware() []gin.HandlerFunc {
+	if o.config.AdminKey != "" {
+		return append([]gin.HandlerFunc{middleware.AdminKey(o.config.AdminKey)}, o.admin...)
+	}
+	if len(o.admin) == 0 {
+		return nil
+	}
+	return append(o.protect(GroupAdmin), o.admin...)
+}
+
+// newRateLimiter returns a Redis rate limiter for redisURL, or a memory one
+// when it is "" or invalid
+func newRateLimiter(redisURL string) middleware.RateLimiter {
+	if redisURL != "" {
+		limiter, err := middleware.NewRedisRateLimiter(redisURL)
+		if err == nil {
+			return limiter
+		}
+		slog.Warn("invalid redis URL, limiting requests in memory", "error", err)
+	}
+	return middleware.NewMemoryRateLimiter()
+}
+
+// providerEnabled reports whether endpoints may use a storage provider
+func (o *routeOptions) providerEnabled(provider string) bool {
+	switch provider {
+	case storage.ProviderAWS:
+		return !o.config.DisableS3
+	case storage.ProviderGCS:
+		return !o.config.DisableGCS
+	}
+	return true
+}
+
+// enabled returns group when on, or else a detached engine, so routes of
+// disabled endpoints can be declared like the others but are never served
+func enabled(group *gin.RouterGroup, on bool) gin.IRoutes {
+	if on {
+		return group
+	}
+	return gin.New()
+}
+
+// rejectProvider answers a request for a disabled provider as if the endpoint
+// did not exist, reporting whether it did. The provider is noted for the access log.
+func rejectProvider(c *gin.Context, options *routeOptions, provider string) bool {
+	c.Set(middleware.ProviderKey, provider)
+	if options.providerEnabled(provider) {
+		return false
+	}
+
+	respondError(c, http.StatusNotFound, fmt.Errorf("provider %q not enabled", provider))
+	return true
+}
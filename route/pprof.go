@@ -0,0 +1,31 @@
+// route/pprof.go
+package route
+
+import (
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerPprof serves the net/http/pprof profiles at /debug/pprof on an
+// admin group. pprof.Index only resolves profiles below its own
+// /debug/pprof/ path, so named profiles are dispatched here.
+func registerPprof(admin *gin.RouterGroup) {
+	admin.Any("/debug/pprof/*name", func(c *gin.Context) {
+		switch name := strings.TrimPrefix(c.Param("name"), "/"); name {
+		case "":
+			pprof.Index(c.Writer, c.Request)
+		case "cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "trace":
+			pprof.Trace(c.Writer, c.Request)
+		default:
+			pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+		}
+	})
+}
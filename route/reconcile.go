@@ -0,0 +1,30 @@
+// route/reconcile.go
+package route
+
+import (
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerReconcile serves reconciliations of the configured buckets with
+// the metadata store on an admin group, reporting objects without a record
+// and records whose object is gone
+func registerReconcile(admin *gin.RouterGroup, fs *storage.FileStorageManager) {
+	// The report of the last reconciliation, scheduled or requested, with a
+	// report of null when none has run since the service started
+	admin.GET("/reconcile", func(c *gin.Context) {
+		c.JSON(200, gin.H{"report": fs.LastReconcileReport()})
+	})
+
+	// Reconcile now, deleting orphaned objects and removing dangling records
+	// with ?clean=true. Large buckets take a while to list.
+	admin.POST("/reconcile", func(c *gin.Context) {
+		report, err := fs.Reconcile(c.Query("clean") == "true")
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, gin.H{"report": report})
+	})
+}
@@ -2,91 +2,912 @@
 package route
 
 import (
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/SIM-MBKM/filestorage/middleware"
 	"github.com/SIM-MBKM/filestorage/storage"
-	securityMiddleware "github.com/SIM-MBKM/mod-service/src/middleware"
 	"github.com/gin-gonic/gin"
 )
 
 // SetupRouter configures all routes and returns the router
-func SetupRouter(fs *storage.FileStorageManager, secretKey string, expireSeconds int64) *gin.Engine {
-	// Set up Gin router
-	r := gin.Default()
+func SetupRouter(fs *storage.FileStorageManager, secretKey string, expireSeconds int64, opts ...RouteOption) *gin.Engine {
+	// Later options replace the default route configuration
+	options := append([]RouteOption{WithRouteConfig(DefaultRouteConfig())}, opts...)
+
+	// The shared access key is accepted unless the configuration leaves it out
+	settings := newRouteOptions(options)
+	if slices.Contains(settings.config.Auth, middleware.MethodAccessKey) {
+		options = append([]RouteOption{WithAccessKey(secretKey, expireSeconds)}, options...)
+	}
+
+	// Set up Gin router with structured access logs instead of gin's text log
+	r := gin.New()
+	r.Use(middleware.AccessLog(settings.accessLogger), gin.Recovery())
+
+	// Match routes on the escaped path, so file IDs containing "/" can be
+	// passed as a single :id parameter encoded as %2F
+	r.UseRawPath = true
 
 	// Add CORS middleware
 	r.Use(middleware.CORS())
 
-	// Add security middleware
-	r.Use(securityMiddleware.AccessKeyMiddleware(secretKey, expireSeconds))
+	// Compress JSON responses, leaving file downloads as they are
+	r.Use(middleware.Compress())
+
+	Register(&r.RouterGroup, fs, options...)
+
+	return r
+}
+
+// Register mounts the file service endpoints on rg, so they can be served by
+// an existing application instead of SetupRouter's engine. The engine should
+// set UseRawPath for file IDs containing "/" to be passed as a single :id.
+func Register(rg *gin.RouterGroup, fs *storage.FileStorageManager, opts ...RouteOption) {
+	options := newRouteOptions(opts)
+	v2BasePath := options.config.V2BasePath
+	if v2BasePath == "" {
+		v2BasePath = path.Join(options.config.BasePath, "v2")
+	}
+	localize := middleware.Localize(options.config.Language)
+
+	// Requests for files are counted towards the tenant they belong to
+	tenants := countTenantRequests(fs)
+	v2 := rg.Group(v2BasePath, localize, tenants)
+	rg = rg.Group(options.config.BasePath, localize, tenants)
+	basePath := rg.BasePath()
+
+	// Accept the configured authentication methods besides those passed as options
+	for _, method := range options.config.Auth {
+		switch method {
+		case middleware.MethodAPIKey:
+			options.authenticators = append(options.authenticators, middleware.APIKeyMethod(fs))
+		case middleware.MethodJWT:
+			options.authenticators = append(options.authenticators, middleware.JWTMethod(options.config.JWT))
+		}
+	}
+
+	// Limit the requests of each client when limits are configured
+	if limits := options.config.RateLimits; limits.Default.Requests > 0 || len(limits.Routes) > 0 {
+		if options.limiter == nil {
+			options.limiter = newRateLimiter(options.config.RedisURL)
+		}
+		options.rateLimit = []gin.HandlerFunc{middleware.RateLimit(options.limiter, limits, basePath)}
+	}
+
+	// Shed uploads beyond what the service can serve in time, sharing the
+	// bound between the v1 and v2 endpoints
+	if options.config.LoadShed.Enabled() {
+		options.loadShed = []gin.HandlerFunc{middleware.LoadShed(options.config.LoadShed)}
+	}
+
+	// Share links, short links and download tokens are opened by recipients
+	// without credentials unless the links group is protected
+	links := rg.Group("", options.protect(GroupLinks)...)
+	shared := links.Group("/shared")
+	{
+		// Download a file through a share link, counting the download unless a
+		// Range request resumes it. Links restricted to emails take a one-time
+		// code sent to the recipient, or are opened through
+		// /share-links/:token/download.
+		shared.GET("/:token", audited(fs, storage.AuditDownload), func(c *gin.Context) {
+			if link, err := fs.GetShareLink(c.Param("token")); err == nil {
+				setFileContext(c, link.Provider, link.FileID)
+			}
+
+			file, err := fs.OpenShareLink(c.Param("token"), storage.ShareAccess{
+				Password: sharePassword(c),
+				Code:     shareCode(c),
+				Resume:   resumesDownload(c),
+			})
+			serveFile(c, file, err)
+		})
+	}
+
+	// Short links redirect anyone holding them to a freshly signed URL
+	links.GET("/l/:code", audited(fs, storage.AuditSignedURL), func(c *gin.Context) {
+		if link, err := fs.GetShortLink(c.Param("code")); err == nil {
+			setFileContext(c, link.Provider, link.FileID)
+		}
 
-	// Create a route group for file service
-	fileService := r.Group("/file-service/api/v1")
+		urlStr, err := fs.ResolveShortLink(c.Param("code"))
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, urlStr)
+	})
+
+	// Download tokens are checked on every request, so revoking one takes effect immediately
+	links.GET("/dl/:token", audited(fs, storage.AuditDownload), func(c *gin.Context) {
+		if token, err := fs.GetDownloadToken(c.Param("token")); err == nil {
+			setFileContext(c, token.Provider, token.FileID)
+		}
+
+		file, err := fs.OpenDownloadToken(c.Param("token"))
+		serveFile(c, file, err)
+	})
+
+	// Probes for Kubernetes and uptime monitoring, public by default
+	health := rg.Group("", options.protect(GroupHealth)...)
+	health.GET("/healthz", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": storage.CheckOK})
+	})
+
+	health.GET("/readyz", func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		report := fs.CheckReadiness(ctx)
+		if report.Status != storage.CheckOK {
+			c.JSON(http.StatusServiceUnavailable, report)
+			return
+		}
+
+		c.JSON(200, report)
+	})
+
+	// Operation metrics in the Prometheus text format
+	health.GET("/metrics", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(200)
+		fs.Metrics().WriteTo(c.Writer)
+	})
+
+	// The API documentation is public by default, like the links it describes
+	if options.config.ServeDocs {
+		registerDocs(rg.Group("", options.protect(GroupDocs)...), v2.BasePath(), options.config)
+	}
+
+	// The v2 endpoints answer with proper status codes in an envelope
+	registerV2(v2, fs, options)
+
+	// Clients preferring GraphQL query and change files in one endpoint
+	if options.config.ServeGraphQL {
+		registerGraphQL(rg.Group("", options.protect(GroupGraphQL)...), fs, options)
+	}
+
+	// Endpoints exposing the internals of the service are only served behind
+	// an admin key or admin middleware
+	if adminMiddleware := options.adminMiddleware(); len(adminMiddleware) > 0 {
+		admin := rg.Group("/admin", adminMiddleware...)
+		registerAPIKeys(admin, fs)
+		registerAdminFiles(admin, fs, options)
+		registerStats(admin, fs)
+		registerAudit(admin, fs)
+		registerReconcile(admin, fs)
+		registerSchedule(admin, fs)
+		registerInventory(admin, fs, options)
+		registerTenants(admin, fs)
+		if options.config.ServePprof {
+			registerPprof(admin)
+		}
+	}
+
+	// Callers are authenticated unless a group is public, and limited to the
+	// operations their scopes or roles grant
+	reads := rg.Group("", options.protect(GroupRead)...)
+	writes := rg.Group("", options.protect(GroupWrite)...)
+	shares := rg.Group("", options.protect(GroupShare)...)
+	deletes := rg.Group("", options.protect(GroupDelete)...)
+
+	s3Reads := enabled(reads, !options.config.DisableS3)
+	s3Writes := enabled(writes, !options.config.DisableS3)
+	s3Deletes := enabled(deletes, !options.config.DisableS3 && !options.config.DisableDelete)
+	gcsReads := enabled(reads, !options.config.DisableGCS)
+	gcsWrites := enabled(writes, !options.config.DisableGCS)
+	gcsDeletes := enabled(deletes, !options.config.DisableGCS && !options.config.DisableDelete)
+	batchDeletes := enabled(deletes, !options.config.DisableDelete)
 	{
 		// Simple upload endpoint
-		fileService.POST("/upload", func(c *gin.Context) {
-			file, err := c.FormFile("file")
+		writes.POST("/upload", middleware.MaxBatchUploadSize(uploadLimit(fs, "/upload"), maxBatchFiles), middleware.FileTypeFilter(fs.FileFilter()), func(c *gin.Context) {
+			// Upload file
+			handleUpload(c, fs, fs.MaxUploadSizeFor("/upload"), func(file *multipart.FileHeader) (*storage.FileResponse, error) {
+				return fs.UploadContext(uploadContext(c), file)
+			})
+		})
+
+		// Example 1: Upload to Google Cloud Storage
+		gcsWrites.POST("/gcs/upload", middleware.MaxBatchUploadSize(uploadLimit(fs, "/gcs/upload"), maxBatchFiles), middleware.FileTypeFilter(fs.FileFilter()), func(c *gin.Context) {
+			// Upload to GCS
+			opts, err := uploadFormOptions(c)
 			if err != nil {
-				c.JSON(400, gin.H{"error": err.Error()})
+				respondError(c, errorStatus(err), err)
 				return
 			}
+			handleUpload(c, fs, fs.MaxUploadSizeFor("/gcs/upload"), func(file *multipart.FileHeader) (*storage.FileResponse, error) {
+				return fs.GcsUploadWithOptions(file, opts)
+			})
+		})
 
-			// Upload file
-			result, err := fs.Upload(file)
+		// Example 2: Upload to AWS S3
+		s3Writes.POST("/s3/upload", middleware.MaxBatchUploadSize(uploadLimit(fs, "/s3/upload"), maxBatchFiles), middleware.FileTypeFilter(fs.FileFilter()), func(c *gin.Context) {
+			// Upload to S3
+			opts, err := uploadFormOptions(c)
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			opts.Prefix = "examples"
+			handleUpload(c, fs, fs.MaxUploadSizeFor("/s3/upload"), func(file *multipart.FileHeader) (*storage.FileResponse, error) {
+				return fs.AwsUploadWithOptions(file, opts)
+			})
+		})
+
+		// Extract a zip archive into a prefix in GCS
+		gcsWrites.POST("/gcs/upload-archive", middleware.MaxUploadSize(uploadLimit(fs, "/gcs/upload-archive")), middleware.FileTypeFilter(fs.FileFilter()), func(c *gin.Context) {
+			file, ok := formFile(c, fs.MaxUploadSizeFor("/gcs/upload-archive"))
+			if !ok {
+				return
+			}
+
+			// Entries are owned by the uploader; only services and
+			// administrators extract into a prefix
+			opts, err := uploadFormOptions(c)
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			if opts.Prefix = c.PostForm("prefix"); opts.Prefix != "" && rejectPrefixAccess(c, options) {
+				return
+			}
+
+			if isAsync(c) {
+				job, err := fs.SubmitUpload(file, func(file *multipart.FileHeader) (*storage.FileResponse, error) {
+					return fs.GcsUploadArchiveWithOptions(file, opts)
+				})
+				respondJob(c, fs, job, err)
+				return
+			}
+
+			result, err := fs.GcsUploadArchiveWithOptions(file, opts)
 			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				respondError(c, errorStatus(err), err)
 				return
 			}
 
 			c.JSON(200, result)
 		})
 
-		// Example 1: Upload to Google Cloud Storage
-		fileService.POST("/gcs/upload", func(c *gin.Context) {
-			file, err := c.FormFile("file")
+		// Extract a zip archive into a prefix in S3
+		s3Writes.POST("/s3/upload-archive", middleware.MaxUploadSize(uploadLimit(fs, "/s3/upload-archive")), middleware.FileTypeFilter(fs.FileFilter()), func(c *gin.Context) {
+			file, ok := formFile(c, fs.MaxUploadSizeFor("/s3/upload-archive"))
+			if !ok {
+				return
+			}
+
+			// Entries are owned by the uploader; only services and
+			// administrators extract into a prefix
+			opts, err := uploadFormOptions(c)
 			if err != nil {
-				c.JSON(400, gin.H{"error": err.Error()})
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			if opts.Prefix = c.PostForm("prefix"); opts.Prefix != "" && rejectPrefixAccess(c, options) {
 				return
 			}
 
-			// Upload to GCS
-			result, err := fs.GcsUpload(file, "", "", "")
+			if isAsync(c) {
+				job, err := fs.SubmitUpload(file, func(file *multipart.FileHeader) (*storage.FileResponse, error) {
+					return fs.AwsUploadArchiveWithOptions(file, opts)
+				})
+				respondJob(c, fs, job, err)
+				return
+			}
+
+			result, err := fs.AwsUploadArchiveWithOptions(file, opts)
 			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				respondError(c, errorStatus(err), err)
 				return
 			}
 
 			c.JSON(200, result)
 		})
 
-		// Example 2: Upload to AWS S3
-		fileService.POST("/s3/upload", func(c *gin.Context) {
-			file, err := c.FormFile("file")
+		// Status of an upload queued with ?async=true, only shown to the
+		// signed in user who queued it
+		reads.GET("/jobs/:id", func(c *gin.Context) {
+			job, err := fs.GetJob(c.Param("id"))
 			if err != nil {
-				c.JSON(400, gin.H{"error": err.Error()})
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			if rejectJobAccess(c, options, job) {
 				return
 			}
 
-			// Upload to S3
-			result, err := fs.AwsUpload(file, "examples", "")
+			c.JSON(200, job)
+		})
+
+		// Status and progress of a queued job as Server-Sent Events, sent as
+		// "job" events until the job has finished
+		reads.GET("/jobs/:id/events", func(c *gin.Context) {
+			job, err := fs.GetJob(c.Param("id"))
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			if rejectJobAccess(c, options, job) {
+				return
+			}
+
+			updates, err := fs.WatchJob(c.Request.Context(), c.Param("id"))
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.Header("Cache-Control", "no-cache")
+			c.Header("X-Accel-Buffering", "no") // Keep nginx from buffering the stream
+
+			// Comments keep proxies from closing the connection while a job is slow
+			keepAlive := time.NewTicker(15 * time.Second)
+			defer keepAlive.Stop()
+
+			c.Stream(func(w io.Writer) bool {
+				select {
+				case job, ok := <-updates:
+					if !ok {
+						return false
+					}
+					c.SSEvent("job", job)
+					return true
+				case <-keepAlive.C:
+					_, err := io.WriteString(w, ": keep-alive\n\n")
+					return err == nil
+				}
+			})
+		})
+
+		// Download several files as a zip archive assembled on the fly
+		reads.POST("/download-zip", audited(fs, storage.AuditDownload), func(c *gin.Context) {
+			var request struct {
+				Provider string   `json:"provider" binding:"required,oneof=s3 gcs"`
+				FileIDs  []string `json:"file_ids" binding:"required,min=1"`
+				Filename string   `json:"filename"`
+			}
+
+			if err := bindJSON(c, &request); err != nil {
+				respondError(c, bindErrorStatus(err), err)
+				return
+			}
+			if rejectProvider(c, options, request.Provider) {
+				return
+			}
+			c.Set(auditFileIDsKey, request.FileIDs)
+			if rejectFileAccess(c, fs, options, storage.PermissionRead, request.FileIDs...) {
+				return
+			}
+
+			if request.Filename == "" {
+				request.Filename = "files.zip"
+			}
+
+			w := &attachmentWriter{c: c, contentType: "application/zip", filename: request.Filename}
+			if err := fs.WriteZip(w, request.Provider, request.FileIDs, "", ""); err != nil {
+				if !w.started {
+					respondError(c, errorStatus(err), err)
+					return
+				}
+				// The archive is already being sent, so it can only be cut short
+				c.Error(err)
+			}
+		})
+
+		// Export a prefix as a tar.gz archive streamed on the fly
+		reads.GET("/export", audited(fs, storage.AuditDownload), func(c *gin.Context) {
+			provider, prefix := c.Query("provider"), strings.Trim(c.Query("prefix"), "/")
+			if rejectProvider(c, options, provider) {
+				return
+			}
+			if prefix == "" {
+				respondError(c, http.StatusBadRequest, errors.New("prefix is required"))
+				return
+			}
+			c.Set(middleware.FileIDKey, prefix+"/")
+			if rejectPrefixAccess(c, options) {
+				return
+			}
+
+			w := &attachmentWriter{c: c, contentType: "application/gzip", filename: path.Base(prefix) + ".tar.gz"}
+			if err := fs.WriteTarGz(w, provider, prefix, exportFilter(c), "", ""); err != nil {
+				if !w.started {
+					respondError(c, errorStatus(err), err)
+					return
+				}
+				// The archive is already being sent, so it can only be cut short
+				c.Error(err)
+			}
+		})
+
+		// Number and size of the files an export would contain
+		reads.GET("/export/estimate", func(c *gin.Context) {
+			provider, prefix := c.Query("provider"), strings.Trim(c.Query("prefix"), "/")
+			if rejectProvider(c, options, provider) {
+				return
+			}
+			if prefix == "" {
+				respondError(c, http.StatusBadRequest, errors.New("prefix is required"))
+				return
+			}
+			if rejectPrefixAccess(c, options) {
+				return
+			}
+
+			result, err := fs.EstimateExport(provider, prefix, exportFilter(c), "", "")
 			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				respondError(c, errorStatus(err), err)
 				return
 			}
 
 			c.JSON(200, result)
 		})
 
+		// Create a share link served through /shared/:token
+		shares.POST("/share-links", audited(fs, storage.AuditSignedURL), func(c *gin.Context) {
+			var request struct {
+				Provider      string    `json:"provider" binding:"required,oneof=s3 gcs"`
+				FileID        string    `json:"file_id" binding:"required"`
+				MaxDownloads  int       `json:"max_downloads" binding:"min=0"`
+				ExpiresAt     time.Time `json:"expires_at"`
+				Password      string    `json:"password"`
+				AllowedEmails []string  `json:"allowed_emails" binding:"dive,email"`
+			}
+
+			if err := bindJSON(c, &request); err != nil {
+				respondError(c, bindErrorStatus(err), err)
+				return
+			}
+			if rejectProvider(c, options, request.Provider) {
+				return
+			}
+			c.Set(middleware.FileIDKey, request.FileID)
+			if rejectFileAccess(c, fs, options, storage.PermissionRead, request.FileID) {
+				return
+			}
+
+			link, err := fs.CreateShareLink(request.Provider, request.FileID, "", "", storage.ShareLinkOptions{
+				MaxDownloads:  request.MaxDownloads,
+				ExpiresAt:     request.ExpiresAt,
+				Password:      request.Password,
+				AllowedEmails: request.AllowedEmails,
+			})
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.JSON(200, link)
+		})
+
+		// List share links, optionally only those to ?file_id=. Signed in
+		// users only see the links to files they may read.
+		shares.GET("/share-links", func(c *gin.Context) {
+			links, err := fs.ListShareLinks(c.Query("file_id"))
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			readable := make([]*storage.ShareLink, 0, len(links))
+			for _, link := range links {
+				if checkFileAccess(c, fs, options, storage.PermissionRead, link.FileID) == nil {
+					readable = append(readable, link)
+				}
+			}
+
+			c.JSON(200, readable)
+		})
+
+		// Get a share link and its download count. Signed in users only see
+		// the links to files they own, and only revoke those.
+		shares.GET("/share-links/:token", func(c *gin.Context) {
+			link, err := fs.GetShareLink(c.Param("token"))
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			if rejectOwnerAccess(c, fs, options, link.FileID) {
+				return
+			}
+
+			c.JSON(200, link)
+		})
+
+		// Download through a share link as the signed in user, whose email
+		// counts when their identity provider verified it, or as the
+		// recipient of a one-time code
+		shares.GET("/share-links/:token/download", audited(fs, storage.AuditDownload), func(c *gin.Context) {
+			if link, err := fs.GetShareLink(c.Param("token")); err == nil {
+				setFileContext(c, link.Provider, link.FileID)
+			}
+
+			file, err := fs.OpenShareLink(c.Param("token"), storage.ShareAccess{
+				Password: sharePassword(c),
+				Email:    verifiedEmail(c),
+				Code:     shareCode(c),
+				Resume:   resumesDownload(c),
+			})
+			serveFile(c, file, err)
+		})
+
+		// Issue a one-time code for a recipient of a share link restricted to
+		// emails, for the calling service to send to that address
+		shares.POST("/share-links/:token/codes", func(c *gin.Context) {
+			var request struct {
+				Email string `json:"email" binding:"required,email"`
+			}
+
+			if err := bindJSON(c, &request); err != nil {
+				respondError(c, bindErrorStatus(err), err)
+				return
+			}
+
+			link, err := fs.GetShareLink(c.Param("token"))
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			if rejectOwnerAccess(c, fs, options, link.FileID) {
+				return
+			}
+
+			code, expiresAt, err := fs.IssueShareCode(c.Param("token"), request.Email)
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.JSON(200, gin.H{"code": code, "email": request.Email, "expires_at": expiresAt})
+		})
+
+		// Revoke a share link
+		shares.DELETE("/share-links/:token", func(c *gin.Context) {
+			link, err := fs.GetShareLink(c.Param("token"))
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			if rejectOwnerAccess(c, fs, options, link.FileID) {
+				return
+			}
+
+			if err := fs.RevokeShareLink(c.Param("token")); err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.JSON(200, gin.H{"status": storage.StatusSuccess})
+		})
+
+		// Create a short /l/:code link to a file, for places where signed URLs
+		// are too long to paste
+		shares.POST("/short-links", audited(fs, storage.AuditSignedURL), func(c *gin.Context) {
+			var request struct {
+				Provider  string    `json:"provider" binding:"required,oneof=s3 gcs"`
+				FileID    string    `json:"file_id" binding:"required"`
+				Filename  string    `json:"filename"` // Saves the file under this name
+				Download  bool      `json:"download"` // Saves the file under its original name
+				ExpiresAt time.Time `json:"expires_at"`
+			}
+
+			if err := bindJSON(c, &request); err != nil {
+				respondError(c, bindErrorStatus(err), err)
+				return
+			}
+			if rejectProvider(c, options, request.Provider) {
+				return
+			}
+			c.Set(middleware.FileIDKey, request.FileID)
+			if rejectFileAccess(c, fs, options, storage.PermissionRead, request.FileID) {
+				return
+			}
+
+			var opts storage.LinkOptions
+			if request.Filename != "" {
+				opts.ContentDisposition = storage.AttachmentDisposition(request.Filename)
+			} else if request.Download {
+				opts.ContentDisposition = storage.AttachmentDisposition(fs.DownloadName(request.FileID))
+			}
+
+			link, err := fs.CreateShortLink(request.Provider, request.FileID, "", "", request.ExpiresAt, opts)
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.JSON(200, gin.H{"link": link, "url": absoluteURL(c, path.Join(basePath, "l", link.Code))})
+		})
+
+		// Revoke a short link. Signed in users only revoke the links to files
+		// they own.
+		shares.DELETE("/short-links/:code", func(c *gin.Context) {
+			link, err := fs.GetShortLink(c.Param("code"))
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			if rejectOwnerAccess(c, fs, options, link.FileID) {
+				return
+			}
+
+			if err := fs.RevokeShortLink(c.Param("code")); err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.JSON(200, gin.H{"status": storage.StatusSuccess})
+		})
+
+		// Issue a revocable /dl/:token download token for a file
+		shares.POST("/download-tokens", audited(fs, storage.AuditSignedURL), func(c *gin.Context) {
+			var request struct {
+				Provider  string `json:"provider" binding:"required,oneof=s3 gcs"`
+				FileID    string `json:"file_id" binding:"required"`
+				Subject   string `json:"subject"`
+				ExpiresIn int    `json:"expires_in" binding:"min=0"` // Seconds, 1 hour by default
+			}
+
+			if err := bindJSON(c, &request); err != nil {
+				respondError(c, bindErrorStatus(err), err)
+				return
+			}
+			if rejectProvider(c, options, request.Provider) {
+				return
+			}
+			c.Set(middleware.FileIDKey, request.FileID)
+			if rejectFileAccess(c, fs, options, storage.PermissionRead, request.FileID) {
+				return
+			}
+
+			ttl := time.Duration(request.ExpiresIn) * time.Second
+			token, err := fs.IssueDownloadToken(request.Provider, request.FileID, "", "", request.Subject, ttl)
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.JSON(200, gin.H{"token": token, "url": absoluteURL(c, path.Join(basePath, "dl", token.Token))})
+		})
+
+		// Revoke a download token. Signed in users only revoke the tokens for
+		// files they own.
+		shares.DELETE("/download-tokens/:token", func(c *gin.Context) {
+			token, err := fs.GetDownloadToken(c.Param("token"))
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			if rejectOwnerAccess(c, fs, options, token.FileID) {
+				return
+			}
+
+			if err := fs.RevokeDownloadToken(c.Param("token")); err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.JSON(200, gin.H{"status": storage.StatusSuccess})
+		})
+
+		// Revoke every download token issued to a subject so far. The tokens
+		// may be for files of anyone, so signed in users only revoke their own.
+		shares.DELETE("/subjects/:subject/download-tokens", func(c *gin.Context) {
+			if rejectSubjectAccess(c, options, c.Param("subject")) {
+				return
+			}
+
+			if err := fs.RevokeSubjectDownloadTokens(c.Param("subject")); err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.JSON(200, gin.H{"status": storage.StatusSuccess})
+		})
+
+		// Issue CDN signed cookies letting the caller's browser load every file
+		// below a prefix without a signed URL per file
+		shares.POST("/cdn-cookies", func(c *gin.Context) {
+			var request struct {
+				Provider  string `json:"provider" binding:"required,oneof=s3 gcs"`
+				Prefix    string `json:"prefix" binding:"required"`
+				ExpiresIn int    `json:"expires_in" binding:"min=0,max=86400"` // Seconds, 1 hour by default
+			}
+
+			if err := bindJSON(c, &request); err != nil {
+				respondError(c, bindErrorStatus(err), err)
+				return
+			}
+			if rejectProvider(c, options, request.Provider) {
+				return
+			}
+			if rejectPrefixAccess(c, options) {
+				return
+			}
+
+			expiresIn := time.Hour
+			if request.ExpiresIn > 0 {
+				expiresIn = time.Duration(request.ExpiresIn) * time.Second
+			}
+			expiresAt := time.Now().Add(expiresIn)
+
+			cookies, err := fs.CDNCookies(request.Provider, request.Prefix, expiresAt)
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			for _, cookie := range cookies {
+				http.SetCookie(c.Writer, cookie)
+			}
+
+			c.JSON(200, gin.H{"status": storage.StatusSuccess, "prefix": request.Prefix, "expires_at": expiresAt})
+		})
+
+		// Make a file public at its stable URL or private behind signed URLs
+		writes.POST("/files/:id/visibility", fileAccess(fs, options, storage.PermissionWrite), func(c *gin.Context) {
+			var request struct {
+				Provider   string `json:"provider" binding:"required,oneof=s3 gcs"`
+				Visibility string `json:"visibility" binding:"required,oneof=public private"`
+			}
+
+			if err := bindJSON(c, &request); err != nil {
+				respondError(c, bindErrorStatus(err), err)
+				return
+			}
+			if rejectProvider(c, options, request.Provider) {
+				return
+			}
+
+			result, err := fs.SetVisibility(request.Provider, c.Param("id"), "", "", request.Visibility)
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.JSON(200, result)
+		})
+
+		// Look up the info of several files in one request
+		reads.POST("/files/info", func(c *gin.Context) {
+			var request struct {
+				Provider string   `json:"provider" binding:"required,oneof=s3 gcs"`
+				FileIDs  []string `json:"file_ids" binding:"required,min=1"`
+			}
+
+			if err := bindJSON(c, &request); err != nil {
+				respondError(c, bindErrorStatus(err), err)
+				return
+			}
+			if rejectProvider(c, options, request.Provider) {
+				return
+			}
+			if rejectFileAccess(c, fs, options, storage.PermissionRead, request.FileIDs...) {
+				return
+			}
+
+			result, err := fs.GetFileInfos(request.Provider, request.FileIDs, "", "")
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.JSON(200, result)
+		})
+
+		// Delete several files in one request, e.g. for bulk cleanup. IDs may
+		// mix providers with an "s3:" or "gcs:" prefix; unprefixed IDs use the
+		// provider recorded for them, else the provider given.
+		batchDeletes.POST("/files/delete", audited(fs, storage.AuditDelete), func(c *gin.Context) {
+			var request struct {
+				Provider string   `json:"provider" binding:"omitempty,oneof=s3 gcs"`
+				FileIDs  []string `json:"file_ids" binding:"required,min=1,dive,required"`
+			}
+
+			if err := bindJSON(c, &request); err != nil {
+				respondError(c, bindErrorStatus(err), err)
+				return
+			}
+			c.Set(auditFileIDsKey, request.FileIDs)
+
+			// Access is checked on the bare IDs the files are recorded under
+			bareIDs := make([]string, len(request.FileIDs))
+			for i, fileID := range request.FileIDs {
+				_, bareIDs[i] = fs.FileProvider(fileID, request.Provider)
+			}
+			if rejectFileAccess(c, fs, options, storage.PermissionWrite, bareIDs...) {
+				return
+			}
+
+			var providers []string
+			for _, provider := range []string{storage.ProviderAWS, storage.ProviderGCS} {
+				if options.providerEnabled(provider) {
+					providers = append(providers, provider)
+				}
+			}
+
+			result, err := fs.DeleteFiles(request.FileIDs, request.Provider, providers)
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+
+			c.JSON(200, result)
+		})
+
+		// Check that a file exists without transferring it, answering with its
+		// size, type and validators as headers. Use ?provider=gcs for GCS files.
+		reads.HEAD("/files/:id", fileAccess(fs, options, storage.PermissionRead), func(c *gin.Context) {
+			provider := c.DefaultQuery("provider", storage.ProviderAWS)
+			if !options.providerEnabled(provider) {
+				c.Status(http.StatusNotFound)
+				return
+			}
+
+			info, exists, err := fs.Exists(provider, c.Param("id"), "", "")
+			if err != nil {
+				setRetryAfter(c, err)
+				c.Status(errorStatus(err))
+				return
+			}
+			if !exists {
+				c.Status(http.StatusNotFound)
+				return
+			}
+
+			if info.FileMimeType != "" {
+				c.Header("Content-Type", info.FileMimeType)
+			}
+			if info.FileSize >= 0 {
+				c.Header("Content-Length", strconv.FormatInt(info.FileSize, 10))
+			}
+			if notModified(c, info.Tag, info.Timestamp) {
+				return
+			}
+			c.Status(http.StatusOK)
+		})
+
+		// Serve a thumbnail of an S3 image, or a GCS one with ?provider=gcs,
+		// generating it on first request
+		reads.GET("/files/:id/thumbnail", fileAccess(fs, options, storage.PermissionRead), func(c *gin.Context) {
+			size, err := strconv.Atoi(c.DefaultQuery("size", "200"))
+			if err != nil {
+				respondError(c, http.StatusBadRequest, errors.New("invalid size"))
+				return
+			}
+
+			provider := c.DefaultQuery("provider", storage.ProviderAWS)
+			if rejectProvider(c, options, provider) {
+				return
+			}
+
+			file, err := fs.OpenThumbnail(provider, c.Param("id"), "", "", size)
+			if err == nil {
+				// Thumbnails never change for a given file and size
+				c.Header("Cache-Control", "private, max-age=86400")
+			}
+			serveFile(c, file, err)
+		})
+
 		// Example 3: Get temporary link for GCS file
-		fileService.GET("/gcs/link", func(c *gin.Context) {
+		gcsReads.GET("/gcs/link", audited(fs, storage.AuditSignedURL), fileAccess(fs, options, storage.PermissionRead), func(c *gin.Context) {
 			fileId := c.Query("fileId")
 
 			// Create temporary link that expires in 1 hour
 			expiry := time.Now().Add(1 * time.Hour)
-			result, err := fs.GcsGetTemporaryPublicLink(fileId, expiry, "", "")
+			result, err := fs.GcsGetTemporaryPublicLinkWithOptions(fileId, expiry, "", "", linkRestrictions(c))
 			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				respondError(c, errorStatus(err), err)
 				return
 			}
 
@@ -94,38 +915,70 @@ func SetupRouter(fs *storage.FileStorageManager, secretKey string, expireSeconds
 		})
 
 		// Example 4: Get file info from S3
-		fileService.GET("/s3/info/:fileId", func(c *gin.Context) {
+		s3Reads.GET("/s3/info/:fileId", audited(fs, storage.AuditDownload), fileAccess(fs, options, storage.PermissionRead), func(c *gin.Context) {
 			fileId := c.Param("fileId")
 
-			result, err := fs.AwsGetFileById(fileId, "")
+			result, err := fs.AwsGetFileInfo(fileId, "")
 			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				respondError(c, http.StatusInternalServerError, err)
 				return
 			}
 
+			// Answer repeated requests for an unchanged file
+			if result.Info != nil && notModified(c, "W/"+result.Info.Tag, result.Info.Timestamp) {
+				return
+			}
+
+			// The deprecated base64 content is only read for clients asking for it
+			if includeData(c) {
+				if result, err = fs.AwsGetFileById(fileId, ""); err != nil {
+					respondError(c, http.StatusInternalServerError, err)
+					return
+				}
+			}
+
 			c.JSON(200, result)
 		})
 
 		// GCS file info
-		fileService.GET("/gcs/info", func(c *gin.Context) {
+		gcsReads.GET("/gcs/info", audited(fs, storage.AuditDownload), fileAccess(fs, options, storage.PermissionRead), func(c *gin.Context) {
 			fileId := c.Query("fileId")
 
-			result, err := fs.GcsGetFileById(fileId, "", "")
+			result, err := fs.GcsGetFileInfo(fileId, "", "")
 			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				respondError(c, http.StatusInternalServerError, err)
 				return
 			}
 
+			// Answer repeated requests for an unchanged file
+			if result.Info != nil && notModified(c, "W/"+result.Info.Tag, result.Info.Timestamp) {
+				return
+			}
+
+			// The deprecated base64 content is only read for clients asking for it
+			if includeData(c) {
+				if result, err = fs.GcsGetFileById(fileId, "", ""); err != nil {
+					respondError(c, http.StatusInternalServerError, err)
+					return
+				}
+			}
+
 			c.JSON(200, result)
 		})
 
+		// Download a GCS file, supporting Range requests
+		gcsReads.GET("/gcs/download", audited(fs, storage.AuditDownload), fileAccess(fs, options, storage.PermissionRead), func(c *gin.Context) {
+			file, err := fs.GcsOpenFileContext(c.Request.Context(), c.Query("fileId"), "", "")
+			serveFile(c, file, err)
+		})
+
 		// Example 5: Delete file from GCS
-		fileService.DELETE("/gcs/delete", func(c *gin.Context) {
+		gcsDeletes.DELETE("/gcs/delete", audited(fs, storage.AuditDelete), fileAccess(fs, options, storage.PermissionWrite), func(c *gin.Context) {
 			fileId := c.Query("fileId")
 
 			result, err := fs.GcsDelete(fileId, "", "")
 			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				respondError(c, http.StatusInternalServerError, err)
 				return
 			}
 
@@ -133,7 +986,7 @@ func SetupRouter(fs *storage.FileStorageManager, secretKey string, expireSeconds
 		})
 
 		// Example 6: Upload base64 file
-		fileService.POST("/upload-base64", func(c *gin.Context) {
+		writes.POST("/upload-base64", middleware.MaxUploadSize(func() int64 { return base64Limit(fs.MaxUploadSizeFor("/upload-base64")) }), func(c *gin.Context) {
 			var request struct {
 				Filename      string `json:"filename"`
 				Extension     string `json:"extension"`
@@ -141,19 +994,26 @@ func SetupRouter(fs *storage.FileStorageManager, secretKey string, expireSeconds
 				Base64Content string `json:"base64_content"`
 			}
 
-			if err := c.ShouldBindJSON(&request); err != nil {
-				c.JSON(400, gin.H{"error": err.Error()})
+			if err := bindJSON(c, &request); err != nil {
+				respondError(c, bindErrorStatus(err), err)
 				return
 			}
 
-			result, err := fs.UploadBase64File(
+			err := validateBase64Upload(fs.FileFilter(), request.Filename, request.Extension, request.MimeType, request.Base64Content)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, err)
+				return
+			}
+
+			result, err := fs.UploadBase64FileContext(
+				c.Request.Context(),
 				request.Filename,
 				request.Extension,
 				request.MimeType,
 				request.Base64Content,
 			)
 			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				respondError(c, errorStatus(err), err)
 				return
 			}
 
@@ -161,27 +1021,42 @@ func SetupRouter(fs *storage.FileStorageManager, secretKey string, expireSeconds
 		})
 
 		// Example 7: Get temporary link for S3 file
-		fileService.GET("/s3/link/:fileId", func(c *gin.Context) {
+		s3Reads.GET("/s3/link/:fileId", audited(fs, storage.AuditSignedURL), fileAccess(fs, options, storage.PermissionRead), func(c *gin.Context) {
 			fileId := c.Param("fileId")
 
+			// Save the file under its original name unless another one is given
+			opts := linkRestrictions(c)
+			opts.ContentType = c.Query("content_type")
+			if filename := c.Query("filename"); filename != "" {
+				opts.ContentDisposition = storage.AttachmentDisposition(filename)
+			} else if c.Query("download") == "true" {
+				opts.ContentDisposition = storage.AttachmentDisposition(fs.DownloadName(fileId))
+			}
+
 			// Create temporary link that expires in 30 minutes
 			expiry := time.Now().Add(30 * time.Minute)
-			result, err := fs.AwsGetTemporaryPublicLink(fileId, expiry, "")
+			result, err := fs.AwsGetTemporaryPublicLinkWithOptions(fileId, expiry, "", opts)
 			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				respondError(c, errorStatus(err), err)
 				return
 			}
 
 			c.JSON(200, result)
 		})
 
+		// Download an S3 file, supporting Range requests
+		s3Reads.GET("/s3/download/*fileId", audited(fs, storage.AuditDownload), fileAccess(fs, options, storage.PermissionRead), func(c *gin.Context) {
+			file, err := fs.AwsOpenFileContext(c.Request.Context(), strings.TrimPrefix(c.Param("fileId"), "/"), "")
+			serveFile(c, file, err)
+		})
+
 		// Example 8: Delete file from S3
-		fileService.DELETE("/s3/delete/:fileId", func(c *gin.Context) {
+		s3Deletes.DELETE("/s3/delete/:fileId", audited(fs, storage.AuditDelete), fileAccess(fs, options, storage.PermissionWrite), func(c *gin.Context) {
 			fileId := c.Param("fileId")
 
 			result, err := fs.AwsDelete(fileId, "")
 			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				respondError(c, http.StatusInternalServerError, err)
 				return
 			}
 
@@ -189,5 +1064,30 @@ func SetupRouter(fs *storage.FileStorageManager, secretKey string, expireSeconds
 		})
 	}
 
-	return r
+	// Files are found through the metadata store instead of the buckets
+	registerSearch(reads, fs, options)
+
+	// Tags are attached to files and listed from the metadata store
+	registerTags(reads, writes, fs, options)
+
+	// Virtual folders organize recorded files without renaming objects
+	registerFolders(reads, writes, deletes, fs, options)
+
+	// Files owned by signed in users are only accessed by those granted access
+	registerAccess(reads, writes, fs, options)
+
+	// Temporary exports and drafts are deleted or archived once they expire
+	registerExpiry(writes, fs, options)
+
+	// Revisions of replaced files are listed, downloaded and restored
+	registerVersions(reads, writes, fs, options)
+
+	// Uploads carry the custom attributes of the attribute schema
+	registerAttributes(reads, fs)
+
+	// Browsers upload large files straight to the provider with presigned URLs
+	registerDirectUploads(writes, fs, options)
+
+	// Resumable uploads with the tus protocol, e.g. from Uppy
+	registerTus(writes, fs, options)
 }
@@ -0,0 +1,49 @@
+// route/schedule.go
+package route
+
+import (
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerSchedule serves the maintenance jobs the service runs on a
+// schedule, such as purging the trash, on an admin group
+func registerSchedule(admin *gin.RouterGroup, fs *storage.FileStorageManager) {
+	// Every scheduled job with its interval, whether it is enabled and the
+	// outcome of its last run
+	admin.GET("/schedule", func(c *gin.Context) {
+		c.JSON(200, gin.H{"jobs": fs.ScheduledJobs()})
+	})
+
+	// Run a job now, answering once it finished. A failed run is reported
+	// with a status of "failed" rather than an error status.
+	admin.POST("/schedule/:name/run", func(c *gin.Context) {
+		run, err := fs.RunScheduledJob(c.Param("name"))
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, gin.H{"run": run})
+	})
+
+	// Start or stop running a job on its schedule until the service restarts
+	admin.PUT("/schedule/:name", func(c *gin.Context) {
+		var request struct {
+			Enabled *bool `json:"enabled" binding:"required"`
+		}
+
+		if err := bindJSON(c, &request); err != nil {
+			respondError(c, bindErrorStatus(err), err)
+			return
+		}
+
+		job, err := fs.SetScheduledJobEnabled(c.Param("name"), *request.Enabled)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, gin.H{"job": job})
+	})
+}
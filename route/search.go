@@ -0,0 +1,103 @@
+// route/search.go
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerSearch serves the search of recorded files, so files can be found
+// by name, type, tag, owner and upload time without listing buckets
+func registerSearch(reads *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	// Find files by ?name=, ?mime= (e.g. "application/pdf" or "image/*"),
+	// ?tag=, ?owner=, ?provider= and an upload time range of ?from= and ?to=,
+	// as RFC 3339 times or dates. Results are ordered by ?sort= (created_at,
+	// name or size, "-" prefixed for descending) and paged by ?offset= and
+	// ?limit=. Signed in users only find their own files, admins any owner's.
+	reads.GET("/files/search", func(c *gin.Context) {
+		query := storage.FileQuery{
+			Name:     c.Query("name"),
+			MimeType: c.Query("mime"),
+			Tag:      c.Query("tag"),
+			Provider: c.Query("provider"),
+			Sort:     c.Query("sort"),
+		}
+
+		if query.Provider != "" && rejectStoredProvider(c, options, query.Provider) {
+			return
+		}
+
+		owner, err := searchOwner(c, options, c.Query("owner"))
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+		query.Owner = owner
+
+		var invalid validationError
+		for _, param := range []struct {
+			field string
+			value *time.Time
+		}{{"from", &query.From}, {"to", &query.To}} {
+			if *param.value, err = parseSearchTime(c.Query(param.field)); err != nil {
+				invalid = append(invalid, fieldError{Field: param.field, Code: middleware.CodeInvalidValue})
+			}
+		}
+		for _, param := range []struct {
+			field string
+			value *int
+		}{{"offset", &query.Offset}, {"limit", &query.Limit}} {
+			if value := c.Query(param.field); value != "" {
+				if *param.value, err = strconv.Atoi(value); err != nil {
+					invalid = append(invalid, fieldError{Field: param.field, Code: middleware.CodeInvalidValue})
+				}
+			}
+		}
+		if len(invalid) > 0 {
+			respondError(c, http.StatusBadRequest, invalid)
+			return
+		}
+
+		result, err := fs.SearchFiles(query)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, result)
+	})
+}
+
+// searchOwner returns the owner whose files a search finds. Signed in users
+// search their own files, admins and callers with an API key or none any
+// owner's.
+func searchOwner(c *gin.Context, options *routeOptions, requested string) (string, error) {
+	principal := requestPrincipal(c, options)
+	if principal == nil {
+		return requested, nil
+	}
+
+	subject := principal.Subject
+	if requested != "" && requested != subject {
+		return "", fmt.Errorf("%w: users only search their own files", storage.ErrNotOwner)
+	}
+	return subject, nil
+}
+
+// parseSearchTime parses a search time given as an RFC 3339 time or a date,
+// which is midnight UTC
+func parseSearchTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
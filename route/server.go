@@ -0,0 +1,64 @@
+// route/server.go
+package route
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/storage"
+)
+
+// DefaultShutdownTimeout is how long Serve waits for in-flight requests and
+// background jobs when shutting down
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Serve serves handler on addr until ctx is done or the process receives
+// SIGTERM or SIGINT. It then stops accepting connections and waits up to
+// shutdownTimeout, DefaultShutdownTimeout when 0, for in-flight requests such
+// as uploads and for the background jobs of fs to finish, and closes fs.
+// Requests still running after the timeout are aborted. A second signal
+// exits immediately.
+func Serve(ctx context.Context, addr string, handler http.Handler, fs *storage.FileStorageManager, shutdownTimeout time.Duration) error {
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = DefaultShutdownTimeout
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	server := &http.Server{Addr: addr, Handler: handler}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Restore the default signal handling, so a second signal kills the process
+	stop()
+	slog.Info("shutting down, waiting for in-flight requests", "timeout", shutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		server.Close()
+	}
+
+	if fs != nil {
+		err = errors.Join(err, fs.Shutdown(shutdownCtx), fs.Close())
+	}
+
+	return err
+}
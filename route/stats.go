@@ -0,0 +1,40 @@
+// route/stats.go
+package route
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// maxStatsDays is the most days /admin/stats counts uploads for
+const maxStatsDays = 366
+
+// registerStats serves usage statistics for capacity planning on an admin
+// group. They are computed from the metadata store and cached for
+// storage.UsageStatsTTL, so new uploads show up after a few minutes.
+func registerStats(admin *gin.RouterGroup, fs *storage.FileStorageManager) {
+	// Uploads per day over the last ?days= days, 30 by default, and bytes
+	// stored per provider, bucket, tenant and top owner
+	admin.GET("/stats", func(c *gin.Context) {
+		days := storage.DefaultStatsDays
+		if value := c.Query("days"); value != "" {
+			var err error
+			if days, err = strconv.Atoi(value); err != nil || days < 1 || days > maxStatsDays {
+				respondError(c, http.StatusBadRequest, validationError{{Field: "days", Code: middleware.CodeInvalidValue}})
+				return
+			}
+		}
+
+		stats, err := fs.UsageStats(days)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, stats)
+	})
+}
@@ -0,0 +1,94 @@
+// route/tags.go
+package route
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerTags serves the tagging of recorded files and the listing of the
+// files carrying a tag. Tags are kept in the metadata store and mirrored to
+// the objects where the provider allows.
+func registerTags(reads, writes *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	// Attach tags to a file, keeping the tags it already carries
+	writes.POST("/files/:id/tags", fileAccess(fs, options, storage.PermissionWrite), func(c *gin.Context) {
+		var request struct {
+			Provider string   `json:"provider" binding:"required,oneof=s3 gcs"`
+			Tags     []string `json:"tags" binding:"required,min=1,dive,required"`
+		}
+
+		if err := bindJSON(c, &request); err != nil {
+			respondError(c, bindErrorStatus(err), err)
+			return
+		}
+		if rejectProvider(c, options, request.Provider) {
+			return
+		}
+		c.Set(middleware.FileIDKey, c.Param("id"))
+
+		record, err := fs.AddTags(request.Provider, c.Param("id"), "", "", request.Tags)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, record)
+	})
+
+	// Detach a tag from a file. Use ?provider=gcs for GCS files.
+	writes.DELETE("/files/:id/tags/:tag", fileAccess(fs, options, storage.PermissionWrite), func(c *gin.Context) {
+		provider := c.DefaultQuery("provider", storage.ProviderAWS)
+		if rejectStoredProvider(c, options, provider) {
+			return
+		}
+		c.Set(middleware.FileIDKey, c.Param("id"))
+
+		record, err := fs.RemoveTags(provider, c.Param("id"), "", "", []string{c.Param("tag")})
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, record)
+	})
+
+	// The files carrying a tag, oldest first, paged by ?offset= and ?limit=.
+	// Signed in users only list their own files.
+	reads.GET("/tags/:tag/files", func(c *gin.Context) {
+		var offset, limit int
+		var invalid validationError
+		for _, param := range []struct {
+			field string
+			value *int
+		}{{"offset", &offset}, {"limit", &limit}} {
+			if value := c.Query(param.field); value != "" {
+				var err error
+				if *param.value, err = strconv.Atoi(value); err != nil {
+					invalid = append(invalid, fieldError{Field: param.field, Code: middleware.CodeInvalidValue})
+				}
+			}
+		}
+		if len(invalid) > 0 {
+			respondError(c, http.StatusBadRequest, invalid)
+			return
+		}
+
+		owner, err := searchOwner(c, options, "")
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		result, err := fs.SearchFiles(storage.FileQuery{Tag: c.Param("tag"), Owner: owner, Offset: offset, Limit: limit})
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, result)
+	})
+}
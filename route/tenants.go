@@ -0,0 +1,63 @@
+// route/tenants.go
+package route
+
+import (
+	"strings"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// countTenantRequests counts every request naming a file, or a prefix to
+// upload to or export, towards the tenant the file belongs to
+func countTenantRequests(fs *storage.FileStorageManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		fileID := c.GetString(middleware.FileIDKey)
+		if fileID == "" {
+			fileID = middleware.RequestFileID(c)
+		}
+		if fileID == "" {
+			prefix := c.Query("prefix")
+			if form := c.Request.MultipartForm; prefix == "" && form != nil && len(form.Value["prefix"]) > 0 {
+				prefix = form.Value["prefix"][0]
+			}
+			if prefix = strings.Trim(prefix, "/"); prefix == "" {
+				return
+			}
+			fileID = prefix + "/"
+		}
+
+		fs.CountTenantRequest(fileID)
+	}
+}
+
+// registerTenants serves the storage used and the requests made per tenant,
+// the first segment of file IDs such as a faculty, on an admin group for
+// charging costs back to units
+func registerTenants(admin *gin.RouterGroup, fs *storage.FileStorageManager) {
+	// The report of ?month= (YYYY-MM), the current month by default, as
+	// JSON or as CSV with ?format=csv. Past months are reported from when
+	// the service started.
+	admin.GET("/tenants", func(c *gin.Context) {
+		report, err := fs.TenantReport(c.Query("month"))
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		if c.Query("format") == "csv" {
+			c.Header("Content-Disposition", storage.AttachmentDisposition("tenants-"+report.Month+".csv"))
+			c.Header("Content-Type", "text/csv")
+			c.Status(200)
+			if err := storage.WriteTenantReport(c.Writer, report); err != nil {
+				c.Error(err)
+			}
+			return
+		}
+
+		c.JSON(200, report)
+	})
+}
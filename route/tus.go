@@ -0,0 +1,239 @@
+// route/tus.go
+package route
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// tusVersion is the tus protocol version served
+	tusVersion = "1.0.0"
+
+	// tusExtensions are the tus extensions supported besides the core protocol
+	tusExtensions = "creation,expiration,termination"
+)
+
+// errInvalidMetadata is returned for a malformed Upload-Metadata header
+var errInvalidMetadata = errors.New("invalid Upload-Metadata header")
+
+// registerTus serves the tus 1.0 resumable upload protocol on /tus/ on top
+// of chunked uploads, so clients such as Uppy can resume interrupted
+// uploads. Uploads are created with their length and a filename in the
+// Upload-Metadata header, plus optionally filetype, provider ("s3" or "gcs",
+// the first enabled by default), owner and prefix. Uploads belong to the
+// signed in user, who alone may resume or terminate them; only services and
+// administrators name a prefix. The final PATCH completes the file, whose ID
+// is returned in the X-File-ID header.
+func registerTus(writes *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	tus := writes.Group("/tus", tusResumable)
+	location := tus.BasePath() + "/"
+
+	tus.OPTIONS("/", func(c *gin.Context) {
+		c.Header("Tus-Version", tusVersion)
+		c.Header("Tus-Extension", tusExtensions)
+		if limit := fs.MaxUploadSizeFor("/tus"); limit > 0 {
+			c.Header("Tus-Max-Size", strconv.FormatInt(limit, 10))
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	tus.POST("/", func(c *gin.Context) {
+		if c.GetHeader("Upload-Defer-Length") != "" {
+			respondError(c, http.StatusBadRequest, errors.New("deferred upload length is not supported"))
+			return
+		}
+		length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+		if err != nil || length < 1 {
+			respondError(c, http.StatusBadRequest, errors.New("invalid Upload-Length header"))
+			return
+		}
+		if limit := fs.MaxUploadSizeFor("/tus"); limit > 0 && length > limit {
+			respondError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("%w of %d bytes", storage.ErrFileTooLarge, limit))
+			return
+		}
+
+		metadata, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		filename := firstNonEmpty(metadata["filename"], metadata["name"])
+		if filename == "" {
+			respondError(c, http.StatusBadRequest, validationError{{Field: "filename", Code: middleware.CodeRequired}})
+			return
+		}
+
+		provider := metadata["provider"]
+		if provider == "" {
+			provider = storage.ProviderAWS
+			if options.config.DisableS3 {
+				provider = storage.ProviderGCS
+			}
+		}
+		if provider != storage.ProviderAWS && provider != storage.ProviderGCS {
+			respondError(c, http.StatusBadRequest, validationError{{Field: "provider", Code: middleware.CodeInvalidValue}})
+			return
+		}
+		if rejectProvider(c, options, provider) {
+			return
+		}
+
+		owner, err := uploadOwner(c, metadata["owner"])
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+		if metadata["prefix"] != "" && rejectPrefixAccess(c, options) {
+			return
+		}
+
+		mimeType := firstNonEmpty(metadata["filetype"], metadata["type"])
+		upload, err := fs.InitChunkedUpload(provider, filename, mimeType, length, metadata["prefix"], owner, "", "")
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.Set(middleware.FileIDKey, upload.FileID)
+		c.Header("Location", location+upload.UploadID)
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		c.Header("X-File-ID", upload.FileID)
+		c.Status(http.StatusCreated)
+	})
+
+	tus.HEAD("/:id", func(c *gin.Context) {
+		upload, err := fs.GetChunkedUpload(c.Param("id"))
+		if err != nil {
+			c.Status(errorStatus(err))
+			return
+		}
+		if rejectUploadAccess(c, options, upload) {
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset(), 10))
+		c.Header("Upload-Length", strconv.FormatInt(upload.ExpectedSize, 10))
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		c.Status(http.StatusOK)
+	})
+
+	tus.PATCH("/:id", func(c *gin.Context) {
+		if c.ContentType() != "application/offset+octet-stream" {
+			respondError(c, http.StatusUnsupportedMediaType, errors.New("PATCH requests must be sent as application/offset+octet-stream"))
+			return
+		}
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			respondError(c, http.StatusBadRequest, errors.New("invalid Upload-Offset header"))
+			return
+		}
+
+		uploadID := c.Param("id")
+		upload, err := fs.GetChunkedUpload(uploadID)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+		if rejectUploadAccess(c, options, upload) {
+			return
+		}
+		c.Set(middleware.FileIDKey, upload.FileID)
+
+		offset, err = fs.AppendChunkedUpload(uploadID, offset, c.Request.Body)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		if offset == upload.ExpectedSize {
+			result, err := fs.CompleteChunkedUpload(uploadID)
+			if err != nil {
+				respondError(c, errorStatus(err), err)
+				return
+			}
+			if result.Status == storage.StatusError {
+				respondError(c, http.StatusBadGateway, errors.New(result.Message))
+				return
+			}
+			c.Header("X-File-ID", result.FileID)
+		} else {
+			c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		}
+
+		c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		c.Status(http.StatusNoContent)
+	})
+
+	tus.DELETE("/:id", func(c *gin.Context) {
+		upload, err := fs.GetChunkedUpload(c.Param("id"))
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+		if rejectUploadAccess(c, options, upload) {
+			return
+		}
+
+		if err := fs.AbortChunkedUpload(upload.UploadID); err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// tusResumable answers every tus request with the protocol version and
+// rejects requests for other versions, except OPTIONS which discovers them
+func tusResumable(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		respondError(c, http.StatusPreconditionFailed, fmt.Errorf("unsupported tus version %q", c.GetHeader("Tus-Resumable")))
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// parseTusMetadata decodes an Upload-Metadata header, a comma separated
+// list of keys each followed by a space and its base64 encoded value
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errInvalidMetadata
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not base64", errInvalidMetadata, key)
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// firstNonEmpty returns the first of values that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package route
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// newTusServer serves the tus endpoints to a user signed in as subject with
+// scopes, with an upload session of student-2 in progress
+func newTusServer(t *testing.T, subject string, scopes ...string) *gin.Engine {
+	t.Helper()
+
+	sessions := storage.NewMemorySessionStore()
+	sessions.SaveSession(&storage.ChunkedUpload{
+		UploadID:     "upload-1",
+		Provider:     storage.ProviderAWS,
+		FileID:       "report.pdf",
+		ExpectedSize: 10,
+		Owner:        "student-2",
+		Chunks:       map[int]*storage.Chunk{},
+		ExpiresAt:    time.Now().Add(time.Hour),
+	})
+	fs := storage.NewFileStorageManager(&storage.Config{}, nil)
+	fs.SetUploadSessionStore(sessions)
+
+	signIn := func(c *gin.Context) {
+		c.Set(middleware.ClaimsKey, map[string]interface{}{"sub": subject})
+		c.Set(middleware.SubjectKey, subject)
+		c.Set(middleware.ScopesKey, scopes)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	Register(&r.RouterGroup, fs, WithMiddleware(signIn))
+	return r
+}
+
+func tusRequest(method, path string, header map[string]string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(""))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	return req
+}
+
+// tusMetadata encodes an Upload-Metadata header
+func tusMetadata(pairs ...string) string {
+	encoded := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		encoded = append(encoded, pairs[i]+" "+base64.StdEncoding.EncodeToString([]byte(pairs[i+1])))
+	}
+	return strings.Join(encoded, ",")
+}
+
+func TestTusCreationChecksOwnerAndPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		scopes   []string
+		metadata string
+		status   int
+	}{
+		{"other owner", []string{storage.ScopeFilesWrite}, tusMetadata("filename", "report.pdf", "owner", "student-2"), http.StatusForbidden},
+		{"user prefix", []string{storage.ScopeFilesWrite}, tusMetadata("filename", "report.pdf", "prefix", "theses"), http.StatusForbidden},
+		{"invalid prefix", []string{storage.ScopeFilesWrite, storage.ScopeAdmin}, tusMetadata("filename", "report.pdf", "prefix", "../theses"), http.StatusBadRequest},
+		{"reserved prefix", []string{storage.ScopeFilesWrite, storage.ScopeAdmin}, tusMetadata("filename", "report.pdf", "prefix", ".trash"), http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTusServer(t, "student-1", tt.scopes...)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, tusRequest(http.MethodPost, "/tus/", map[string]string{"Upload-Length": "10", "Upload-Metadata": tt.metadata}))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestTusSessionsOfOtherUsers(t *testing.T) {
+	r := newTusServer(t, "student-1", storage.ScopeFilesWrite)
+
+	for _, req := range []*http.Request{
+		tusRequest(http.MethodHead, "/tus/upload-1", nil),
+		tusRequest(http.MethodPatch, "/tus/upload-1", map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}),
+		tusRequest(http.MethodDelete, "/tus/upload-1", nil),
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want 403", req.Method, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	newTusServer(t, "student-2", storage.ScopeFilesWrite).ServeHTTP(w, tusRequest(http.MethodHead, "/tus/upload-1", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Length") != "10" {
+		t.Errorf("owner HEAD: status = %d, Upload-Length %q", w.Code, w.Header().Get("Upload-Length"))
+	}
+}
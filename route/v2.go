@@ -0,0 +1,369 @@
+// route/v2.go
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultV2BasePath is where SetupRouter mounts the v2 endpoints
+const DefaultV2BasePath = "/file-service/api/v2"
+
+// v2Envelope wraps every v2 response: data on success, error otherwise
+type v2Envelope struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error *v2Error    `json:"error,omitempty"`
+}
+
+// v2Error describes a failed v2 request
+type v2Error struct {
+	Code    string `json:"code"`   // Snake case HTTP status text, e.g. "not_found"
+	Reason  string `json:"reason"` // Stable error code, e.g. middleware.CodeFileNotFound
+	Message string `json:"message"`
+	Detail  string `json:"detail"`
+
+	// Fields lists the invalid fields of a request failing validation
+	Fields []fieldError `json:"fields,omitempty"`
+}
+
+// v2Link is a temporary link to a file
+type v2Link struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// registerV2 mounts the v2 endpoints on rg. Unlike v1, failures are never
+// answered with 200 and status "ERR": missing files get 404, invalid input
+// 400 and provider failures 502, and every response uses v2Envelope.
+func registerV2(rg *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	reads := rg.Group("/:provider", options.protect(GroupRead)...)
+	writes := rg.Group("/:provider", options.protect(GroupWrite)...)
+	deletes := enabled(rg.Group("/:provider", options.protect(GroupDelete)...), !options.config.DisableDelete)
+
+	// Upload the multipart "file" field, below the optional "prefix" field only
+	// services and administrators give
+	writes.POST("/files", func(c *gin.Context) {
+		provider := c.Param("provider")
+		if rejectV2Provider(c, options, provider) {
+			return
+		}
+
+		limit := fs.MaxUploadSizeFor("/v2/files")
+		if limit > 0 {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+1<<20)
+		}
+
+		file, err := c.FormFile("file")
+		if err != nil {
+			respondV2Error(c, bindErrorStatus(err), formFileError(err))
+			return
+		}
+		if limit > 0 && file.Size > limit {
+			respondV2Error(c, http.StatusRequestEntityTooLarge, fmt.Errorf("%w: maximum upload size is %d bytes", storage.ErrFileTooLarge, limit))
+			return
+		}
+
+		opts, err := uploadFormOptions(c)
+		if err != nil {
+			respondV2Error(c, errorStatus(err), err)
+			return
+		}
+		if opts.Prefix = c.PostForm("prefix"); opts.Prefix != "" && rejectV2PrefixAccess(c, options) {
+			return
+		}
+
+		var result *storage.FileResponse
+		if provider == storage.ProviderAWS {
+			result, err = fs.AwsUploadWithOptions(file, opts)
+		} else {
+			result, err = fs.GcsUploadWithOptions(file, opts)
+		}
+		if respondV2Failure(c, result, err) {
+			return
+		}
+
+		info := result.Info
+		if info == nil {
+			info = &storage.FileInfo{FileID: result.FileID}
+		}
+		c.JSON(http.StatusCreated, v2Envelope{Data: info})
+	})
+
+	// Look up several files given as repeated ?id= parameters. Missing files
+	// are left out of the result; a provider failure fails the request.
+	reads.GET("/files", func(c *gin.Context) {
+		provider := c.Param("provider")
+		if rejectV2Provider(c, options, provider) {
+			return
+		}
+
+		ids := c.QueryArray("id")
+		if len(ids) == 0 {
+			respondV2Error(c, http.StatusBadRequest, errors.New("at least one id is required"))
+			return
+		}
+		if rejectV2Access(c, fs, options, storage.PermissionRead, ids...) {
+			return
+		}
+
+		result, err := fs.GetFileInfos(provider, ids, "", "")
+		if respondV2Failure(c, result, err) {
+			return
+		}
+
+		files := []*storage.FileInfo{}
+		for _, r := range result.Results {
+			switch {
+			case r.Status == storage.StatusSuccess:
+				files = append(files, r.Info)
+			case r.Message != storage.ErrFileNotFound.Error():
+				respondV2Error(c, http.StatusBadGateway, fmt.Errorf("%s: %s", r.FileID, r.Message))
+				return
+			}
+		}
+		c.JSON(http.StatusOK, v2Envelope{Data: files})
+	})
+
+	// File info, 404 when the file does not exist
+	reads.GET("/files/:id", func(c *gin.Context) {
+		provider := c.Param("provider")
+		if rejectV2Provider(c, options, provider) {
+			return
+		}
+		if rejectV2Access(c, fs, options, storage.PermissionRead, c.Param("id")) {
+			return
+		}
+
+		info, exists, err := fs.Exists(provider, c.Param("id"), "", "")
+		if err != nil {
+			respondV2Error(c, v2Status(err), err)
+			return
+		}
+		if !exists {
+			respondV2Error(c, http.StatusNotFound, fmt.Errorf("%w: %s", storage.ErrFileNotFound, c.Param("id")))
+			return
+		}
+
+		c.JSON(http.StatusOK, v2Envelope{Data: info})
+	})
+
+	// Download the file content, supporting Range requests
+	reads.GET("/files/:id/content", audited(fs, storage.AuditDownload), func(c *gin.Context) {
+		provider := c.Param("provider")
+		if rejectV2Provider(c, options, provider) {
+			return
+		}
+		if rejectV2Access(c, fs, options, storage.PermissionRead, c.Param("id")) {
+			return
+		}
+
+		file, err := fs.OpenFileContext(c.Request.Context(), provider, c.Param("id"), "", "")
+		if err != nil {
+			respondV2Error(c, v2Status(err), err)
+			return
+		}
+		serveFile(c, file, nil)
+	})
+
+	// Create a temporary link, valid for expires_in seconds (an hour by default)
+	reads.POST("/files/:id/links", audited(fs, storage.AuditSignedURL), func(c *gin.Context) {
+		provider := c.Param("provider")
+		if rejectV2Provider(c, options, provider) {
+			return
+		}
+		if rejectV2Access(c, fs, options, storage.PermissionRead, c.Param("id")) {
+			return
+		}
+
+		var request struct {
+			ExpiresIn  int64  `json:"expires_in" binding:"min=0,max=604800"`
+			Filename   string `json:"filename"` // Saves the file under this name
+			RestrictIP bool   `json:"restrict_ip"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := bindJSON(c, &request); err != nil {
+				respondV2Error(c, bindErrorStatus(err), err)
+				return
+			}
+		}
+
+		fileID := c.Param("id")
+		if _, exists, err := fs.Exists(provider, fileID, "", ""); err != nil || !exists {
+			if err == nil {
+				err = fmt.Errorf("%w: %s", storage.ErrFileNotFound, fileID)
+			}
+			respondV2Error(c, v2Status(err), err)
+			return
+		}
+
+		var opts storage.LinkOptions
+		if request.Filename != "" {
+			opts.ContentDisposition = storage.AttachmentDisposition(request.Filename)
+		}
+		if request.RestrictIP {
+			opts.ClientIP = c.ClientIP()
+		}
+
+		expiry := time.Hour
+		if request.ExpiresIn > 0 {
+			expiry = time.Duration(request.ExpiresIn) * time.Second
+		}
+
+		var result *storage.FileResponse
+		var err error
+		if provider == storage.ProviderAWS {
+			result, err = fs.AwsGetTemporaryPublicLinkWithOptions(fileID, time.Now().Add(expiry), "", opts)
+		} else {
+			result, err = fs.GcsGetTemporaryPublicLinkWithOptions(fileID, time.Now().Add(expiry), "", "", opts)
+		}
+		if respondV2Failure(c, result, err) {
+			return
+		}
+
+		c.JSON(http.StatusCreated, v2Envelope{Data: v2Link{URL: result.URL, ExpiresAt: result.ExpiredAt}})
+	})
+
+	// Delete a file, 404 when it does not exist
+	deletes.DELETE("/files/:id", audited(fs, storage.AuditDelete), func(c *gin.Context) {
+		provider := c.Param("provider")
+		if rejectV2Provider(c, options, provider) {
+			return
+		}
+		if rejectV2Access(c, fs, options, storage.PermissionWrite, c.Param("id")) {
+			return
+		}
+
+		fileID := c.Param("id")
+		if _, exists, err := fs.Exists(provider, fileID, "", ""); err != nil || !exists {
+			if err == nil {
+				err = fmt.Errorf("%w: %s", storage.ErrFileNotFound, fileID)
+			}
+			respondV2Error(c, v2Status(err), err)
+			return
+		}
+
+		var result *storage.FileResponse
+		var err error
+		if provider == storage.ProviderAWS {
+			result, err = fs.AwsDelete(fileID, "")
+		} else {
+			result, err = fs.GcsDelete(fileID, "", "")
+		}
+		if respondV2Failure(c, result, err) {
+			return
+		}
+
+		c.JSON(http.StatusOK, v2Envelope{Data: gin.H{"file_id": fileID}})
+	})
+
+	// Bring back a file deleted while soft delete is enabled, 404 once it
+	// has been purged from the trash and 409 when its ID has been taken since.
+	// The record in the trash keeps the owner and ACL of the deleted file.
+	deletes.POST("/files/:id/restore", func(c *gin.Context) {
+		provider := c.Param("provider")
+		if rejectV2Provider(c, options, provider) {
+			return
+		}
+		if rejectV2Access(c, fs, options, storage.PermissionWrite, c.Param("id"), storage.TrashKey(c.Param("id"))) {
+			return
+		}
+
+		fileID := c.Param("id")
+		result, err := fs.RestoreFile(provider, fileID, "", "")
+		if respondV2Failure(c, result, err) {
+			return
+		}
+
+		c.JSON(http.StatusOK, v2Envelope{Data: gin.H{"file_id": fileID}})
+	})
+}
+
+// rejectV2Provider answers 404 for an unknown or disabled provider, reporting
+// whether it did. The provider is noted for the access log.
+func rejectV2Provider(c *gin.Context, options *routeOptions, provider string) bool {
+	c.Set(middleware.ProviderKey, provider)
+	if (provider == storage.ProviderAWS || provider == storage.ProviderGCS) && options.providerEnabled(provider) {
+		return false
+	}
+
+	respondV2Error(c, http.StatusNotFound, fmt.Errorf("unknown provider %q", provider))
+	return true
+}
+
+// rejectV2Access answers 403 unless the signed in user may access every
+// file with a permission, reporting whether it did
+func rejectV2Access(c *gin.Context, fs *storage.FileStorageManager, options *routeOptions, permission string, fileIDs ...string) bool {
+	if err := checkFileAccess(c, fs, options, permission, fileIDs...); err != nil {
+		respondV2Error(c, v2Status(err), err)
+		return true
+	}
+	return false
+}
+
+// rejectV2PrefixAccess answers 403 for signed in users accessing every file
+// below a prefix, reporting whether it did
+func rejectV2PrefixAccess(c *gin.Context, options *routeOptions) bool {
+	if err := checkPrefixAccess(c, options); err != nil {
+		respondV2Error(c, v2Status(err), err)
+		return true
+	}
+	return false
+}
+
+// respondV2Failure answers a failed storage operation, reporting whether it
+// failed. Responses with status "ERR" carry provider failures.
+func respondV2Failure(c *gin.Context, result *storage.FileResponse, err error) bool {
+	if err != nil {
+		respondV2Error(c, v2Status(err), err)
+		return true
+	}
+	if result == nil || result.Status == storage.StatusError {
+		message := "provider failure"
+		if result != nil && result.Message != "" {
+			message = result.Message
+		}
+		respondV2Error(c, http.StatusBadGateway, errors.New(message))
+		return true
+	}
+	return false
+}
+
+// respondV2Error answers with an error envelope carrying the localized
+// message of err and, for validation errors, the invalid fields
+func respondV2Error(c *gin.Context, status int, err error) {
+	setRetryAfter(c, err)
+	reason := errorCode(err, status)
+	v2Err := &v2Error{
+		Code:    strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_")),
+		Reason:  reason,
+		Message: middleware.Message(c, reason, err.Error()),
+		Detail:  err.Error(),
+	}
+
+	var fields validationError
+	var attributes storage.AttributeError
+	switch {
+	case errors.As(err, &fields):
+		v2Err.Fields = fields.localized(c)
+	case errors.As(err, &attributes):
+		v2Err.Fields = attributeFields(attributes).localized(c)
+	}
+
+	c.AbortWithStatusJSON(status, v2Envelope{Error: v2Err})
+}
+
+// v2Status maps storage errors to HTTP status codes like errorStatus.
+// Errors it does not know come from the providers, so they get 502.
+func v2Status(err error) int {
+	if status := errorStatus(err); status != http.StatusInternalServerError {
+		return status
+	}
+	return http.StatusBadGateway
+}
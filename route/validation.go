@@ -0,0 +1,206 @@
+// route/validation.go
+package route
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// fieldError reports why a single field of a request is invalid
+type fieldError struct {
+	Field   string `json:"field"`   // JSON name of the field, e.g. "allowed_emails[1]", "" for the whole body
+	Code    string `json:"code"`    // Stable code, e.g. middleware.CodeRequired
+	Message string `json:"message"` // Localized message, set when responding
+}
+
+// validationError lists the invalid fields of a request
+type validationError []fieldError
+
+// Error implements error
+func (v validationError) Error() string {
+	parts := make([]string, len(v))
+	for i, field := range v {
+		parts[i] = field.Code
+		if field.Field != "" {
+			parts[i] = field.Field + ": " + field.Code
+		}
+	}
+	return "invalid request: " + strings.Join(parts, ", ")
+}
+
+// localized returns the fields with their messages in the request's language
+func (v validationError) localized(c *gin.Context) []fieldError {
+	fields := make([]fieldError, len(v))
+	for i, field := range v {
+		field.Message = middleware.Message(c, field.Code, field.Code)
+		fields[i] = field
+	}
+	return fields
+}
+
+// bindJSON decodes and validates a JSON request body like c.ShouldBindJSON,
+// returning a validationError naming each invalid field instead of the
+// validator's error string. Oversized bodies are returned as they are.
+func bindJSON(c *gin.Context, obj interface{}) error {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return nil
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	var validationErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return err
+	case errors.As(err, &validationErrs):
+		fields := make(validationError, len(validationErrs))
+		for i, fe := range validationErrs {
+			fields[i] = fieldError{Field: jsonFieldPath(reflect.TypeOf(obj), fe.StructNamespace()), Code: validationCode(fe.Tag())}
+		}
+		return fields
+	case errors.As(err, &typeErr):
+		return validationError{{Field: typeErr.Field, Code: middleware.CodeInvalidType}}
+	default:
+		return validationError{{Code: middleware.CodeInvalidJSON}}
+	}
+}
+
+// formFileError converts a missing multipart "file" field into a validationError
+func formFileError(err error) error {
+	if errors.Is(err, http.ErrMissingFile) {
+		return validationError{{Field: "file", Code: middleware.CodeRequired}}
+	}
+	return err
+}
+
+// attributeFields converts the custom attributes of an upload that do not
+// match the attribute schema into attributes[name] fields
+func attributeFields(attributes storage.AttributeError) validationError {
+	fields := make(validationError, len(attributes))
+	for i, attribute := range attributes {
+		fields[i] = fieldError{Field: "attributes[" + attribute.Name + "]", Code: attributeCode(attribute.Reason)}
+	}
+	return fields
+}
+
+// attributeCode maps the reasons attributes are invalid to field error codes
+func attributeCode(reason string) string {
+	switch reason {
+	case storage.AttributeMissing:
+		return middleware.CodeRequired
+	case storage.AttributeBadType:
+		return middleware.CodeInvalidType
+	case storage.AttributeUnknown:
+		return middleware.CodeUnknownField
+	default:
+		return middleware.CodeInvalidValue
+	}
+}
+
+// validationCode maps validator tags to field error codes
+func validationCode(tag string) string {
+	switch tag {
+	case "required":
+		return middleware.CodeRequired
+	case "min", "gt", "gte":
+		return middleware.CodeTooSmall
+	case "max", "lt", "lte":
+		return middleware.CodeTooLarge
+	case "email":
+		return middleware.CodeInvalidEmail
+	default:
+		return middleware.CodeInvalidValue
+	}
+}
+
+// jsonFieldPath converts a validator namespace such as
+// "Request.AllowedEmails[1]" into the JSON path "allowed_emails[1]".
+// Namespaces of anonymous structs start at the field.
+func jsonFieldPath(t reflect.Type, namespace string) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() != "" {
+		namespace = strings.TrimPrefix(namespace, t.Name()+".")
+	}
+
+	var path []string
+	for _, segment := range strings.Split(namespace, ".") {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			t = t.Elem()
+		}
+
+		name, index, indexed := strings.Cut(segment, "[")
+		field, ok := t.FieldByName(name)
+		if t.Kind() != reflect.Struct || !ok {
+			path = append(path, segment)
+			continue
+		}
+
+		if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag != "" && tag != "-" {
+			name = tag
+		}
+		if indexed {
+			name += "[" + index
+		}
+		path = append(path, name)
+		t = field.Type
+	}
+
+	return strings.Join(path, ".")
+}
+
+// validateBase64Upload checks an /upload-base64 request field by field,
+// including the file filter and the base64 encoding, so every invalid
+// field is reported at once
+func validateBase64Upload(filter *storage.FileFilter, filename, extension, mimeType, content string) error {
+	var fields validationError
+
+	if strings.TrimSpace(filename) == "" {
+		fields = append(fields, fieldError{Field: "filename", Code: middleware.CodeRequired})
+	}
+
+	switch {
+	case extension == "":
+		fields = append(fields, fieldError{Field: "extension", Code: middleware.CodeRequired})
+	case filter.CheckExtension(extension) != nil:
+		fields = append(fields, fieldError{Field: "extension", Code: middleware.CodeUnsupportedExtension})
+	}
+
+	switch {
+	case mimeType == "":
+		fields = append(fields, fieldError{Field: "mime_type", Code: middleware.CodeRequired})
+	case filter.CheckMimeType(mimeType) != nil:
+		fields = append(fields, fieldError{Field: "mime_type", Code: middleware.CodeFileTypeNotAllowed})
+	}
+
+	switch {
+	case content == "":
+		fields = append(fields, fieldError{Field: "base64_content", Code: middleware.CodeRequired})
+	case !isBase64(content):
+		fields = append(fields, fieldError{Field: "base64_content", Code: middleware.CodeInvalidBase64})
+	}
+
+	if len(fields) > 0 {
+		return fields
+	}
+	return nil
+}
+
+// isBase64 reports whether content is valid standard base64, decoding it
+// without keeping the decoded bytes
+func isBase64(content string) bool {
+	_, err := io.Copy(io.Discard, base64.NewDecoder(base64.StdEncoding, strings.NewReader(content)))
+	return err == nil
+}
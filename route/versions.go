@@ -0,0 +1,95 @@
+// route/versions.go
+package route
+
+import (
+	"github.com/SIM-MBKM/filestorage/middleware"
+	"github.com/SIM-MBKM/filestorage/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerVersions serves the version history of files, such as the
+// revisions of a submission. Previous versions are kept when a file is
+// replaced while versioning is enabled. Use ?provider=gcs for GCS files.
+func registerVersions(reads, writes *gin.RouterGroup, fs *storage.FileStorageManager, options *routeOptions) {
+	// Replace a file with the multipart "file" field, keeping the previous
+	// version. The owner, ACL, folder and tags of the file stay.
+	writes.POST("/files/:id/versions", middleware.MaxUploadSize(uploadLimit(fs, "/files/:id/versions")), middleware.FileTypeFilter(fs.FileFilter()), fileAccess(fs, options, storage.PermissionWrite), func(c *gin.Context) {
+		provider, ok := versionProvider(c, options)
+		if !ok {
+			return
+		}
+
+		opts, err := uploadFormOptions(c)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+		file, ok := formFile(c, fs.MaxUploadSizeFor("/files/:id/versions"))
+		if !ok {
+			return
+		}
+
+		result, err := fs.ReplaceFile(provider, c.Param("id"), file, opts)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, result)
+	})
+
+	// The previous versions of a file, newest first
+	reads.GET("/files/:id/versions", fileAccess(fs, options, storage.PermissionRead), func(c *gin.Context) {
+		provider, ok := versionProvider(c, options)
+		if !ok {
+			return
+		}
+
+		versions, err := fs.ListVersions(provider, c.Param("id"), "", "")
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, gin.H{"versions": versions})
+	})
+
+	// Download a previous version
+	reads.GET("/files/:id/versions/:version/download", audited(fs, storage.AuditDownload), fileAccess(fs, options, storage.PermissionRead), func(c *gin.Context) {
+		provider, ok := versionProvider(c, options)
+		if !ok {
+			return
+		}
+
+		file, err := fs.OpenVersion(provider, c.Param("id"), c.Param("version"), "", "")
+		serveFile(c, file, err)
+	})
+
+	// Make a previous version the current one, keeping the replaced version
+	writes.POST("/files/:id/versions/:version/restore", fileAccess(fs, options, storage.PermissionWrite), func(c *gin.Context) {
+		provider, ok := versionProvider(c, options)
+		if !ok {
+			return
+		}
+
+		result, err := fs.RestoreVersion(provider, c.Param("id"), c.Param("version"), "", "")
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+
+		c.JSON(200, result)
+	})
+}
+
+// versionProvider reads the provider of a version request from ?provider=,
+// S3 by default, noting the file for the access log and the audit log
+func versionProvider(c *gin.Context, options *routeOptions) (string, bool) {
+	provider := c.DefaultQuery("provider", storage.ProviderAWS)
+	if rejectStoredProvider(c, options, provider) {
+		return "", false
+	}
+
+	setFileContext(c, provider, c.Param("id"))
+	return provider, true
+}
@@ -0,0 +1,174 @@
+// pkg/storage/acl.go
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Permissions granted on files
+const (
+	PermissionRead  = "read"  // Download, look up and link to the file
+	PermissionWrite = "write" // Change or delete the file, which includes reading it
+)
+
+// MaxGrants is the most grants the ACL of a file holds
+const MaxGrants = 100
+
+// Grant gives a user, or every user with a role, a permission on a file,
+// e.g. read access for the supervisor of the student owning a submission
+type Grant struct {
+	User       string `json:"user,omitempty"` // Subject granted the permission
+	Role       string `json:"role,omitempty"` // Role granted the permission, when User is ""
+	Permission string `json:"permission"`     // PermissionRead or PermissionWrite
+}
+
+// Principal is the signed in user a file is accessed for
+type Principal struct {
+	Subject string
+	Roles   []string
+}
+
+// Permits reports whether a principal may access a recorded file with a
+// permission. Owners may do anything with their files; others need a grant
+// for their subject or one of their roles. Files without an owner, such as
+// those uploaded by services, are open to everyone.
+func (r *FileRecord) Permits(principal *Principal, permission string) bool {
+	if r.Owner == "" || r.Owner == principal.Subject {
+		return true
+	}
+
+	for _, grant := range r.ACL {
+		if grant.Permission != permission && grant.Permission != PermissionWrite {
+			continue
+		}
+		if grant.User != "" && grant.User == principal.Subject {
+			return true
+		}
+		if grant.Role != "" && containsString(principal.Roles, grant.Role) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAccess returns ErrNotOwner unless a principal may access a file with
+// a permission, see FileRecord.Permits. A nil principal, a service or an
+// administrator, may access every file. Files without a record, which are
+// all files when no metadata store is set, have no owner to protect them.
+func (f *FileStorageManager) CheckAccess(fileID string, principal *Principal, permission string) error {
+	if principal == nil || f.metadataStore == nil {
+		return nil
+	}
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !record.Permits(principal, permission) {
+		return fmt.Errorf("%w: no %s access to %s", ErrNotOwner, permission, fileID)
+	}
+	return nil
+}
+
+// CheckOwner returns ErrNotOwner unless a principal owns a file, which
+// managing the links handed out to it requires. Like CheckAccess, a nil
+// principal may manage every file, and files without a record or an owner
+// are open to everyone.
+func (f *FileStorageManager) CheckOwner(fileID string, principal *Principal) error {
+	if principal == nil || f.metadataStore == nil {
+		return nil
+	}
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if record.Owner != "" && record.Owner != principal.Subject {
+		return fmt.Errorf("%w: only the owner manages the links to %s", ErrNotOwner, fileID)
+	}
+	return nil
+}
+
+// GetFileRecord returns the record of a file
+func (f *FileStorageManager) GetFileRecord(fileID string) (*FileRecord, error) {
+	if f.metadataStore == nil {
+		return nil, ErrAccessNotSupported
+	}
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if errors.Is(err, ErrRecordNotFound) || (err == nil && record.UploadStatus == UploadPending) {
+		return nil, fmt.Errorf("%w: no record of %s", ErrFileNotFound, fileID)
+	}
+	return record, err
+}
+
+// SetFileAccess replaces the ACL of a recorded file and, unless owner is "",
+// hands it to another owner. Only the owner changes who may access a file;
+// a nil principal, a service or an administrator, may change any file's.
+func (f *FileStorageManager) SetFileAccess(fileID string, principal *Principal, owner string, grants []Grant) (*FileRecord, error) {
+	grants, err := normalizeGrants(grants)
+	if err != nil {
+		return nil, err
+	}
+
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	record, err := f.GetFileRecord(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if principal != nil && record.Owner != principal.Subject {
+		return nil, fmt.Errorf("%w: only the owner changes who may access %s", ErrNotOwner, fileID)
+	}
+
+	if owner != "" {
+		record.Owner = owner
+	}
+	record.ACL = grants
+	if err := f.saveRecord(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// normalizeGrants trims grants and drops duplicates, rejecting grants
+// naming neither or both of a user and a role, or an unknown permission
+func normalizeGrants(grants []Grant) ([]Grant, error) {
+	if len(grants) > MaxGrants {
+		return nil, fmt.Errorf("%w: a file has at most %d grants", ErrInvalidGrant, MaxGrants)
+	}
+
+	normalized := []Grant{}
+	for _, grant := range grants {
+		grant.User = strings.TrimSpace(grant.User)
+		grant.Role = strings.TrimSpace(grant.Role)
+		if (grant.User == "") == (grant.Role == "") {
+			return nil, fmt.Errorf("%w: a grant names either a user or a role", ErrInvalidGrant)
+		}
+		if grant.Permission != PermissionRead && grant.Permission != PermissionWrite {
+			return nil, fmt.Errorf("%w: unknown permission %q", ErrInvalidGrant, grant.Permission)
+		}
+
+		duplicate := false
+		for _, existing := range normalized {
+			duplicate = duplicate || existing == grant
+		}
+		if !duplicate {
+			normalized = append(normalized, grant)
+		}
+	}
+	return normalized, nil
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFileRecordPermits(t *testing.T) {
+	record := &FileRecord{
+		FileID: "report.pdf",
+		Owner:  "student-1",
+		ACL: []Grant{
+			{User: "supervisor-1", Permission: PermissionRead},
+			{User: "coordinator-1", Permission: PermissionWrite},
+			{Role: "lecturer", Permission: PermissionRead},
+			{Role: "admin-staff", Permission: PermissionWrite},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		record     *FileRecord
+		principal  Principal
+		permission string
+		want       bool
+	}{
+		{"owner reads", record, Principal{Subject: "student-1"}, PermissionRead, true},
+		{"owner writes", record, Principal{Subject: "student-1"}, PermissionWrite, true},
+		{"user granted read reads", record, Principal{Subject: "supervisor-1"}, PermissionRead, true},
+		{"user granted read does not write", record, Principal{Subject: "supervisor-1"}, PermissionWrite, false},
+		{"user granted write reads", record, Principal{Subject: "coordinator-1"}, PermissionRead, true},
+		{"user granted write writes", record, Principal{Subject: "coordinator-1"}, PermissionWrite, true},
+		{"role granted read reads", record, Principal{Subject: "lecturer-7", Roles: []string{"student", "lecturer"}}, PermissionRead, true},
+		{"role granted read does not write", record, Principal{Subject: "lecturer-7", Roles: []string{"lecturer"}}, PermissionWrite, false},
+		{"role granted write writes", record, Principal{Subject: "staff-3", Roles: []string{"admin-staff"}}, PermissionWrite, true},
+		{"other user does not read", record, Principal{Subject: "student-2"}, PermissionRead, false},
+		{"other user with other roles does not read", record, Principal{Subject: "student-2", Roles: []string{"student"}}, PermissionRead, false},
+		{"role named like a granted user does not read", record, Principal{Subject: "student-2", Roles: []string{"supervisor-1"}}, PermissionRead, false},
+		{"subject named like a granted role does not read", record, Principal{Subject: "lecturer"}, PermissionRead, false},
+		{"empty subject is not the owner", record, Principal{}, PermissionRead, false},
+		{"unknown permission is not granted", record, Principal{Subject: "supervisor-1"}, "delete", false},
+		{"file without owner is open", &FileRecord{FileID: "public.pdf"}, Principal{Subject: "student-2"}, PermissionWrite, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.record.Permits(&tt.principal, tt.permission); got != tt.want {
+				t.Errorf("Permits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAccessAndOwner(t *testing.T) {
+	store := NewMemoryMetadataStore()
+	store.SaveFile(&FileRecord{FileID: "owned.pdf", Owner: "student-1", ACL: []Grant{{User: "supervisor-1", Permission: PermissionRead}}})
+	store.SaveFile(&FileRecord{FileID: "service.pdf"})
+	f := &FileStorageManager{metadataStore: store}
+
+	owner, grantee, other := &Principal{Subject: "student-1"}, &Principal{Subject: "supervisor-1"}, &Principal{Subject: "student-2"}
+
+	tests := []struct {
+		name      string
+		check     func() error
+		wantOwner bool // Whether ErrNotOwner is expected
+	}{
+		{"owner reads", func() error { return f.CheckAccess("owned.pdf", owner, PermissionRead) }, false},
+		{"grantee reads", func() error { return f.CheckAccess("owned.pdf", grantee, PermissionRead) }, false},
+		{"grantee does not write", func() error { return f.CheckAccess("owned.pdf", grantee, PermissionWrite) }, true},
+		{"other user does not read", func() error { return f.CheckAccess("owned.pdf", other, PermissionRead) }, true},
+		{"service reads", func() error { return f.CheckAccess("owned.pdf", nil, PermissionWrite) }, false},
+		{"unrecorded file is open", func() error { return f.CheckAccess("unknown.pdf", other, PermissionWrite) }, false},
+		{"owner manages links", func() error { return f.CheckOwner("owned.pdf", owner) }, false},
+		{"grantee does not manage links", func() error { return f.CheckOwner("owned.pdf", grantee) }, true},
+		{"service manages links", func() error { return f.CheckOwner("owned.pdf", nil) }, false},
+		{"links to files without owner are open", func() error { return f.CheckOwner("service.pdf", other) }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check()
+			if tt.wantOwner != errors.Is(err, ErrNotOwner) || (!tt.wantOwner && err != nil) {
+				t.Errorf("error = %v, want ErrNotOwner: %v", err, tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestFolderOwnerChecks(t *testing.T) {
+	f := &FileStorageManager{folderStore: NewMemoryFolderStore()}
+	alice, bob := &Principal{Subject: "alice"}, &Principal{Subject: "bob"}
+
+	theses, err := f.CreateFolder("Theses", "", alice, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, err := f.CreateFolder("Reports", "", bob, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		err  error
+	}{
+		{"create in a folder of another user", func() error { _, err := f.CreateFolder("Drafts", theses.ID, bob, "bob"); return err }()},
+		{"rename a folder of another user", func() error { _, err := f.RenameFolder(theses.ID, bob, "Mine"); return err }()},
+		{"move a folder of another user", func() error { _, err := f.MoveFolder(theses.ID, bob, reports.ID); return err }()},
+		{"move into a folder of another user", func() error { _, err := f.MoveFolder(reports.ID, bob, theses.ID); return err }()},
+		{"delete a folder of another user", f.DeleteFolder(theses.ID, bob)},
+	} {
+		if !errors.Is(tt.err, ErrNotOwner) {
+			t.Errorf("%s: error = %v, want ErrNotOwner", tt.name, tt.err)
+		}
+	}
+
+	if _, err := f.RenameFolder(theses.ID, alice, "Thesis"); err != nil {
+		t.Errorf("owner renaming: %v", err)
+	}
+	if _, err := f.MoveFolder(reports.ID, nil, theses.ID); err != nil {
+		t.Errorf("service moving: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+// pkg/storage/admin.go
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultFindLimit is the number of objects FindObjects returns when no limit is given
+const DefaultFindLimit = 100
+
+// errStopWalk ends the walk of a prefix early
+var errStopWalk = errors.New("stop walking")
+
+// StoredObject is an object found in a provider bucket, with its metadata
+// record if one is kept
+type StoredObject struct {
+	Provider    string      `json:"provider"`
+	Bucket      string      `json:"bucket"`
+	FileID      string      `json:"file_id"`
+	Size        int64       `json:"size"` // -1 when the size of encoded content is not known
+	ContentType string      `json:"content_type,omitempty"`
+	ModTime     time.Time   `json:"mod_time"`
+	Record      *FileRecord `json:"record,omitempty"`
+}
+
+// FileReport is what is known about a stored file, for administrators
+type FileReport struct {
+	Provider   string        `json:"provider"`
+	FileID     string        `json:"file_id"`
+	Object     *StoredObject `json:"object,omitempty"` // nil when the object is gone but its record is left
+	Record     *FileRecord   `json:"record,omitempty"`
+	ShareLinks []*ShareLink  `json:"share_links"`
+}
+
+// FindObjects lists the objects below prefix in the default bucket of each
+// provider whose key contains query, ignoring case, returning at most limit
+// objects (DefaultFindLimit when 0). Buckets are listed in key order, so
+// searching a large bucket without a prefix is slow.
+func (f *FileStorageManager) FindObjects(providers []string, prefix, query string, limit int) ([]*StoredObject, error) {
+	if limit <= 0 {
+		limit = DefaultFindLimit
+	}
+	query = strings.ToLower(query)
+
+	objects := []*StoredObject{}
+	for _, provider := range providers {
+		bucketname := f.defaultBucket(provider)
+
+		err := f.walkPrefix(provider, prefix, bucketname, "", func(object ObjectInfo) error {
+			if query != "" && !strings.Contains(strings.ToLower(object.Key), query) {
+				return nil
+			}
+
+			objects = append(objects, &StoredObject{
+				Provider:    provider,
+				Bucket:      bucketname,
+				FileID:      object.Key,
+				Size:        object.Size,
+				ContentType: object.ContentType,
+				ModTime:     object.ModTime,
+				Record:      f.storedRecord(provider, object.Key),
+			})
+			if len(objects) >= limit {
+				return errStopWalk
+			}
+			return nil
+		})
+		if errors.Is(err, errStopWalk) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", provider, err)
+		}
+	}
+
+	return objects, nil
+}
+
+// InspectFile reports the stored object, metadata record and share links of
+// a file, returning ErrFileNotFound when neither the object nor a record exist
+func (f *FileStorageManager) InspectFile(provider, fileID, bucketname, projectID string) (*FileReport, error) {
+	if bucketname == "" {
+		bucketname = f.defaultBucket(provider)
+	}
+
+	report := &FileReport{
+		Provider:   provider,
+		FileID:     fileID,
+		Record:     f.storedRecord(provider, fileID),
+		ShareLinks: []*ShareLink{},
+	}
+
+	file, err := f.OpenFile(provider, fileID, bucketname, projectID)
+	switch {
+	case err == nil:
+		report.Object = &StoredObject{
+			Provider:    provider,
+			Bucket:      bucketname,
+			FileID:      fileID,
+			Size:        file.Size(),
+			ContentType: file.ContentType(),
+			ModTime:     file.ModTime(),
+		}
+		file.Close()
+	case !errors.Is(err, ErrFileNotFound) || report.Record == nil:
+		return nil, err
+	}
+
+	links, err := f.ListShareLinks(fileID)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		if link.Provider == provider {
+			report.ShareLinks = append(report.ShareLinks, link)
+		}
+	}
+
+	return report, nil
+}
+
+// ForceDelete deletes a file with its thumbnails, previews and metadata
+// record and revokes its share links, for administrators removing a file
+// regardless of the endpoints enabled. The file skips the trash.
+func (f *FileStorageManager) ForceDelete(provider, fileID, bucketname, projectID string) (*FileResponse, error) {
+	response, err := f.deleteFile(provider, fileID, bucketname, projectID, false)
+	if err != nil || response.Status != StatusSuccess {
+		return response, err
+	}
+
+	links, err := f.ListShareLinks(fileID)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		if link.Provider == provider {
+			f.RevokeShareLink(link.Token)
+		}
+	}
+
+	return response, nil
+}
+
+// RescanFile scans a stored file for malware again, e.g. after the scanner's
+// signatures were updated, and records the result in its metadata record.
+// Infected files are reported, not deleted.
+func (f *FileStorageManager) RescanFile(provider, fileID, bucketname, projectID string) (*ScanResult, error) {
+	if f.scanner == nil {
+		return nil, ErrScannerNotConfigured
+	}
+
+	file, err := f.OpenFile(provider, fileID, bucketname, projectID)
+	if err != nil {
+		return nil, err
+	}
+	file.observe = nil // Scanning is not a download
+	defer file.Close()
+
+	result, err := f.scanner.Scan(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+
+	if record := f.storedRecord(provider, fileID); record != nil {
+		record.ScanStatus = result.Status
+		record.ScanSignature = result.Signature
+		if err := f.saveRecord(record); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// DeleteFile deletes a file stored with an S3 or GCS provider, moving it to
+// the trash while soft delete is enabled
+func (f *FileStorageManager) DeleteFile(provider, fileID, bucketname, projectID string) (*FileResponse, error) {
+	return f.deleteFile(provider, fileID, bucketname, projectID, f.softDelete(fileID))
+}
+
+// deleteFile deletes a file stored with an S3 or GCS provider, or moves it
+// to the trash
+func (f *FileStorageManager) deleteFile(provider, fileID, bucketname, projectID string, trash bool) (*FileResponse, error) {
+	switch provider {
+	case ProviderAWS:
+		return f.awsDelete(fileID, bucketname, trash)
+	case ProviderGCS:
+		return f.gcsDelete(fileID, bucketname, projectID, trash)
+	}
+
+	return nil, fmt.Errorf("unknown provider %q", provider)
+}
+
+// softDelete reports whether deleting a file moves it to the trash. Files
+// already in the trash are deleted for good.
+func (f *FileStorageManager) softDelete(fileID string) bool {
+	return f.trashRetention > 0 && !inTrash(fileID)
+}
+
+// storedRecord returns the metadata record of a file stored with provider,
+// or nil if none is kept
+func (f *FileStorageManager) storedRecord(provider, fileID string) *FileRecord {
+	if f.metadataStore == nil {
+		return nil
+	}
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if err != nil || record.Provider != provider {
+		return nil
+	}
+	return record
+}
+
+// defaultBucket returns the configured bucket of a provider
+func (f *FileStorageManager) defaultBucket(provider string) string {
+	if provider == ProviderGCS {
+		return f.config.GCSBucket
+	}
+	return f.config.AWSBucket
+}
@@ -0,0 +1,587 @@
+// pkg/storage/afero_fs.go
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// AferoFs is an afero.Fs over the objects below a prefix in an S3 or GCS
+// bucket, so code built on afero can keep its files in the bucket. Files
+// opened for writing are buffered in memory and stored when synced or
+// closed, like uploads: filtered, scanned, encoded and recorded in the
+// metadata store. Folders are derived from "/" in object keys as in
+// BucketFS, so creating one does nothing and they vanish once empty.
+type AferoFs struct {
+	bucket *BucketFS
+}
+
+var _ afero.Fs = (*AferoFs)(nil)
+
+// AferoFs returns an afero.Fs over the objects below prefix in an S3 or GCS
+// bucket. Keys in the prefixes the service keeps its own objects in, e.g.
+// the trash, cannot be written.
+func (f *FileStorageManager) AferoFs(provider, prefix, bucketname, projectID string) *AferoFs {
+	return &AferoFs{bucket: f.FS(provider, prefix, bucketname, projectID)}
+}
+
+// Name implements afero.Fs
+func (a *AferoFs) Name() string {
+	return "AferoFs"
+}
+
+// Open implements afero.Fs, opening a file or folder for reading
+func (a *AferoFs) Open(name string) (afero.File, error) {
+	file, err := a.bucket.Open(a.path(name))
+	if err != nil {
+		return nil, err
+	}
+	return &aferoFile{File: file, name: name}, nil
+}
+
+// OpenFile implements afero.Fs. Files opened for writing are read into
+// memory first unless they are truncated.
+func (a *AferoFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		return a.Open(name)
+	}
+
+	key, err := a.writableKey("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := a.bucket.Stat(a.path(name))
+	exists := err == nil
+	switch {
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	case exists && info.IsDir():
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	file := &aferoWriter{fs: a, name: name, key: key, flag: flag, dirty: !exists || flag&os.O_TRUNC != 0}
+	if exists && flag&os.O_TRUNC == 0 {
+		if file.data, err = afero.ReadFile(a, name); err != nil {
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+// Create implements afero.Fs
+func (a *AferoFs) Create(name string) (afero.File, error) {
+	return a.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Mkdir implements afero.Fs. Folders exist as long as files are stored
+// below them, so it only fails when a file has the name.
+func (a *AferoFs) Mkdir(name string, perm os.FileMode) error {
+	if _, err := a.writableKey("mkdir", name); err != nil {
+		return err
+	}
+	if info, err := a.bucket.Stat(a.path(name)); err == nil && !info.IsDir() {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	return nil
+}
+
+// MkdirAll implements afero.Fs like Mkdir
+func (a *AferoFs) MkdirAll(name string, perm os.FileMode) error {
+	return a.Mkdir(name, perm)
+}
+
+// Remove implements afero.Fs. Removing a file moves it to the trash while
+// soft delete is enabled.
+func (a *AferoFs) Remove(name string) error {
+	key, err := a.writableKey("remove", name)
+	if err != nil {
+		return err
+	}
+
+	info, err := a.bucket.Stat(a.path(name))
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+
+	return a.delete("remove", name, key)
+}
+
+// RemoveAll implements afero.Fs, removing a file or the files below a folder
+func (a *AferoFs) RemoveAll(name string) error {
+	keys, err := a.keys("removeall", name)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := a.delete("removeall", name, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename implements afero.Fs, moving a file or the files below a folder
+// along with their metadata records. A file stored under the new name is
+// replaced.
+func (a *AferoFs) Rename(oldname, newname string) error {
+	oldKey, err := a.writableKey("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newKey, err := a.writableKey("rename", newname)
+	if err != nil {
+		return err
+	}
+
+	keys, err := a.keys("rename", oldname)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+
+	manager := a.bucket.manager
+	for _, key := range keys {
+		to := newKey + strings.TrimPrefix(key, oldKey)
+		if err := manager.moveObject(a.bucket.provider, key, to, a.bucketname(), a.bucket.projectID); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+		}
+		manager.moveRecord(key, to)
+	}
+	return nil
+}
+
+// Stat implements afero.Fs
+func (a *AferoFs) Stat(name string) (os.FileInfo, error) {
+	return a.bucket.Stat(a.path(name))
+}
+
+// Chmod implements afero.Fs, failing since objects have no file mode
+func (a *AferoFs) Chmod(name string, mode os.FileMode) error {
+	return &fs.PathError{Op: "chmod", Path: name, Err: errors.ErrUnsupported}
+}
+
+// Chown implements afero.Fs, failing since objects have no file owner
+func (a *AferoFs) Chown(name string, uid, gid int) error {
+	return &fs.PathError{Op: "chown", Path: name, Err: errors.ErrUnsupported}
+}
+
+// Chtimes implements afero.Fs, failing since providers set modification times
+func (a *AferoFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return &fs.PathError{Op: "chtimes", Path: name, Err: errors.ErrUnsupported}
+}
+
+// path maps an afero name, which may be absolute or use the OS separator,
+// to a path in the bucket file system
+func (a *AferoFs) path(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// writableKey returns the object key of a name that may be written
+func (a *AferoFs) writableKey(op, name string) (string, error) {
+	p := a.path(name)
+	key := a.bucket.key(p)
+	if p == "." || reservedKey(key) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return key, nil
+}
+
+// keys returns the key of the file with a name, or the keys of the files
+// below the folder with the name, leaving out the objects of the service
+func (a *AferoFs) keys(op, name string) ([]string, error) {
+	p := a.path(name)
+	key := a.bucket.key(p)
+
+	if p != "." {
+		info, err := a.bucket.Stat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return []string{key}, nil
+		}
+	}
+
+	var keys []string
+	err := a.bucket.manager.walkPrefix(a.bucket.provider, key, a.bucketname(), a.bucket.projectID, func(object ObjectInfo) error {
+		if !reservedKey(object.Key) {
+			keys = append(keys, object.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return keys, nil
+}
+
+// delete deletes the file stored under a key
+func (a *AferoFs) delete(op, name, key string) error {
+	response, err := a.bucket.manager.DeleteFile(a.bucket.provider, key, a.bucketname(), a.bucket.projectID)
+	if err == nil && response.Status != StatusSuccess {
+		err = errors.New(response.Message)
+	}
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// bucketname returns the bucket of the file system, resolving the default
+func (a *AferoFs) bucketname() string {
+	if a.bucket.bucketname == "" {
+		return a.bucket.manager.defaultBucket(a.bucket.provider)
+	}
+	return a.bucket.bucketname
+}
+
+// aferoFile is a file or folder opened for reading through an AferoFs
+type aferoFile struct {
+	fs.File // A bucketFile or bucketDir
+	name    string
+}
+
+// Name implements afero.File, returning the name the file was opened with
+func (f *aferoFile) Name() string {
+	return f.name
+}
+
+// Seek implements afero.File
+func (f *aferoFile) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := f.File.(io.Seeker)
+	if !ok {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: errors.New("is a directory")}
+	}
+	return seeker.Seek(offset, whence)
+}
+
+// ReadAt implements afero.File by seeking, so it must not be called
+// concurrently with other reads of the file
+func (f *aferoFile) ReadAt(p []byte, off int64) (int, error) {
+	current, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Seek(current, io.SeekStart)
+
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f.File, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Readdir implements afero.File
+func (f *aferoFile) Readdir(count int) ([]os.FileInfo, error) {
+	dir, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errors.New("not a directory")}
+	}
+
+	entries, err := dir.ReadDir(count)
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, _ := entry.Info()
+		infos = append(infos, info)
+	}
+	return infos, err
+}
+
+// Readdirnames implements afero.File
+func (f *aferoFile) Readdirnames(n int) ([]string, error) {
+	infos, err := f.Readdir(n)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, err
+}
+
+// Write implements afero.File, failing since the file is read-only
+func (f *aferoFile) Write([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+}
+
+// WriteAt implements afero.File, failing since the file is read-only
+func (f *aferoFile) WriteAt([]byte, int64) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+}
+
+// WriteString implements afero.File, failing since the file is read-only
+func (f *aferoFile) WriteString(string) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+}
+
+// Truncate implements afero.File, failing since the file is read-only
+func (f *aferoFile) Truncate(int64) error {
+	return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrPermission}
+}
+
+// Sync implements afero.File
+func (f *aferoFile) Sync() error {
+	return nil
+}
+
+// aferoWriter is a file opened for writing through an AferoFs, buffered in
+// memory until it is stored by Sync or Close
+type aferoWriter struct {
+	fs     *AferoFs
+	name   string
+	key    string
+	flag   int
+	data   []byte
+	offset int64
+	dirty  bool // Whether data differs from the stored file
+	closed bool
+}
+
+// Name implements afero.File
+func (w *aferoWriter) Name() string {
+	return w.name
+}
+
+// Read implements afero.File for files opened with os.O_RDWR
+func (w *aferoWriter) Read(p []byte) (int, error) {
+	n, err := w.ReadAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// ReadAt implements afero.File for files opened with os.O_RDWR
+func (w *aferoWriter) ReadAt(p []byte, off int64) (int, error) {
+	if err := w.check("read"); err != nil {
+		return 0, err
+	}
+	if w.flag&os.O_RDWR == 0 {
+		return 0, &fs.PathError{Op: "read", Path: w.name, Err: fs.ErrPermission}
+	}
+	if off >= int64(len(w.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, w.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Seek implements afero.File
+func (w *aferoWriter) Seek(offset int64, whence int) (int64, error) {
+	if err := w.check("seek"); err != nil {
+		return 0, err
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += w.offset
+	case io.SeekEnd:
+		offset += int64(len(w.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: w.name, Err: fs.ErrInvalid}
+	}
+
+	w.offset = offset
+	return offset, nil
+}
+
+// Write implements afero.File, appending to the file when it was opened
+// with os.O_APPEND
+func (w *aferoWriter) Write(p []byte) (int, error) {
+	if w.flag&os.O_APPEND != 0 {
+		w.offset = int64(len(w.data))
+	}
+	n, err := w.writeAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// WriteAt implements afero.File
+func (w *aferoWriter) WriteAt(p []byte, off int64) (int, error) {
+	if w.flag&os.O_APPEND != 0 {
+		return 0, &fs.PathError{Op: "writeat", Path: w.name, Err: errors.New("invalid use of WriteAt on file opened with O_APPEND")}
+	}
+	return w.writeAt(p, off)
+}
+
+// WriteString implements afero.File
+func (w *aferoWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// writeAt writes into the buffered content, which may not grow beyond the
+// maximum upload size
+func (w *aferoWriter) writeAt(p []byte, off int64) (int, error) {
+	if err := w.check("write"); err != nil {
+		return 0, err
+	}
+
+	end := off + int64(len(p))
+	if err := w.fs.bucket.manager.checkUploadSize(end); err != nil {
+		return 0, &fs.PathError{Op: "write", Path: w.name, Err: err}
+	}
+	if end > int64(len(w.data)) {
+		w.data = append(w.data, make([]byte, end-int64(len(w.data)))...)
+	}
+
+	copy(w.data[off:], p)
+	w.dirty = true
+	return len(p), nil
+}
+
+// Truncate implements afero.File
+func (w *aferoWriter) Truncate(size int64) error {
+	if err := w.check("truncate"); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: w.name, Err: fs.ErrInvalid}
+	}
+	if err := w.fs.bucket.manager.checkUploadSize(size); err != nil {
+		return &fs.PathError{Op: "truncate", Path: w.name, Err: err}
+	}
+
+	if size > int64(len(w.data)) {
+		w.data = append(w.data, make([]byte, size-int64(len(w.data)))...)
+	}
+	w.data = w.data[:size]
+	w.dirty = true
+	return nil
+}
+
+// Stat implements afero.File, describing the buffered content
+func (w *aferoWriter) Stat() (os.FileInfo, error) {
+	return &objectInfo{name: path.Base(w.key), size: int64(len(w.data)), modTime: time.Now()}, nil
+}
+
+// Readdir implements afero.File, failing since the file is not a folder
+func (w *aferoWriter) Readdir(int) ([]os.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: w.name, Err: errors.New("not a directory")}
+}
+
+// Readdirnames implements afero.File, failing since the file is not a folder
+func (w *aferoWriter) Readdirnames(int) ([]string, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: w.name, Err: errors.New("not a directory")}
+}
+
+// Sync implements afero.File, storing the content written so far
+func (w *aferoWriter) Sync() error {
+	if err := w.check("sync"); err != nil {
+		return err
+	}
+	if !w.dirty {
+		return nil
+	}
+
+	bucket := w.fs.bucket
+	if err := bucket.manager.putFile(bucket.provider, w.key, w.fs.bucketname(), bucket.projectID, w.data); err != nil {
+		return &fs.PathError{Op: "sync", Path: w.name, Err: err}
+	}
+	w.dirty = false
+	return nil
+}
+
+// Close implements afero.File, storing the content unless it was synced
+func (w *aferoWriter) Close() error {
+	if err := w.Sync(); err != nil {
+		return err
+	}
+	w.closed = true
+	w.data = nil
+	return nil
+}
+
+// check fails once the file is closed
+func (w *aferoWriter) check(op string) error {
+	if w.closed {
+		return &fs.PathError{Op: op, Path: w.name, Err: fs.ErrClosed}
+	}
+	return nil
+}
+
+// putFile stores content under a key like an upload replacing the file
+// there, keeping its previous version while versioning is enabled
+func (f *FileStorageManager) putFile(provider, key, bucketname, projectID string, data []byte) error {
+	ctx := context.Background()
+
+	if err := f.checkUploadSize(int64(len(data))); err != nil {
+		return err
+	}
+
+	release, err := f.acquireProvider(ctx, provider)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	payload, err := f.preparePayload(path.Base(key), mime.TypeByExtension(path.Ext(key)), data, provider)
+	if err != nil {
+		return err
+	}
+	defer payload.release()
+
+	if err := f.keepVersion(provider, key, bucketname, projectID); err != nil {
+		return err
+	}
+
+	var response *FileResponse
+	switch provider {
+	case ProviderAWS:
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return err
+		}
+		response = f.storeAwsPayload(ctx, s3Client, bucketname, key, payload)
+
+	case ProviderGCS:
+		gcsClient, err := f.GetGcsClient(projectID)
+		if err != nil {
+			return err
+		}
+		defer gcsClient.Close()
+
+		response = f.storeGcsPayload(ctx, gcsClient.Bucket(bucketname), bucketname, projectID, key, payload)
+
+	default:
+		return fmt.Errorf("unknown provider %q", provider)
+	}
+
+	if response.Status != StatusSuccess {
+		return errors.New(response.Message)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestAferoFsPaths(t *testing.T) {
+	f := &FileStorageManager{}
+	a := f.AferoFs(ProviderAWS, "/submissions/", "", "")
+
+	for _, tt := range []struct {
+		name     string
+		wantKey  string
+		writable bool
+	}{
+		{"report.pdf", "submissions/report.pdf", true},
+		{"/theses/2024/report.pdf", "submissions/theses/2024/report.pdf", true},
+		{"theses/../report.pdf", "submissions/report.pdf", true},
+		{"../../etc/passwd", "submissions/etc/passwd", true},
+		{"/", "", false},
+		{".", "", false},
+	} {
+		key, err := a.writableKey("open", tt.name)
+		if tt.writable != (err == nil) || key != tt.wantKey {
+			t.Errorf("writableKey(%q) = %q, %v, want %q, writable: %v", tt.name, key, err, tt.wantKey, tt.writable)
+		}
+	}
+
+	root := f.AferoFs(ProviderAWS, "", "", "")
+	for _, name := range []string{TrashKey("report.pdf"), ArchiveKey("report.pdf"), VersionKey("report.pdf", "1"), "/.trash/../.trash/report.pdf"} {
+		if _, err := root.writableKey("open", name); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("writableKey(%q) error = %v, want fs.ErrPermission", name, err)
+		}
+	}
+}
+
+func TestAferoWriter(t *testing.T) {
+	f := &FileStorageManager{}
+	f.SetMaxUploadSize(16)
+	a := f.AferoFs(ProviderAWS, "", "", "")
+	w := &aferoWriter{fs: a, name: "notes.txt", key: "notes.txt", flag: os.O_RDWR}
+
+	w.WriteString("hello world")
+	w.WriteAt([]byte("W"), 6)
+	w.Seek(-5, io.SeekEnd)
+	w.Write([]byte("there"))
+	if got := string(w.data); got != "hello there" {
+		t.Errorf("content = %q, want %q", got, "hello there")
+	}
+
+	w.Seek(0, io.SeekStart)
+	buf := make([]byte, 5)
+	if n, err := w.Read(buf); n != 5 || err != nil || string(buf) != "hello" {
+		t.Errorf("Read() = %d, %v, %q", n, err, buf)
+	}
+
+	w.Truncate(5)
+	if info, _ := w.Stat(); info.Size() != 5 {
+		t.Errorf("size after Truncate() = %d, want 5", info.Size())
+	}
+	if _, err := w.WriteAt(make([]byte, 12), 5); err == nil {
+		t.Error("write beyond the maximum upload size accepted")
+	}
+
+	appender := &aferoWriter{fs: a, name: "log.txt", key: "log.txt", flag: os.O_WRONLY | os.O_APPEND, data: []byte("a")}
+	appender.Seek(0, io.SeekStart)
+	appender.WriteString("b")
+	if got := string(appender.data); got != "ab" {
+		t.Errorf("appended content = %q, want %q", got, "ab")
+	}
+	if _, err := appender.WriteAt([]byte("c"), 0); err == nil {
+		t.Error("WriteAt() accepted on a file opened with O_APPEND")
+	}
+	if _, err := appender.Read(buf); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Read() of a write-only file error = %v, want fs.ErrPermission", err)
+	}
+}
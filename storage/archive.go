@@ -0,0 +1,350 @@
+// pkg/storage/archive.go
+
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"path"
+	"strings"
+)
+
+// ArchiveLimits bounds the content extracted from an uploaded archive
+type ArchiveLimits struct {
+	MaxEntries   int   // Maximum number of files
+	MaxEntrySize int64 // Maximum uncompressed size of a single file in bytes
+	MaxTotalSize int64 // Maximum uncompressed size of all files in bytes
+}
+
+// DefaultArchiveLimits are the limits used when none are configured
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxEntries:   1000,
+	MaxEntrySize: 100 << 20,
+	MaxTotalSize: 1 << 30,
+}
+
+// archiveEntry is a file in an uploaded archive that passed validation
+type archiveEntry struct {
+	file *zip.File
+	key  string
+}
+
+// NewArchiveLimitsFromConfig creates archive limits from the configuration,
+// falling back to DefaultArchiveLimits for unset values
+func NewArchiveLimitsFromConfig(config *Config) ArchiveLimits {
+	limits := DefaultArchiveLimits
+	if config.ArchiveMaxEntries > 0 {
+		limits.MaxEntries = config.ArchiveMaxEntries
+	}
+	if config.ArchiveMaxEntrySize > 0 {
+		limits.MaxEntrySize = config.ArchiveMaxEntrySize
+	}
+	if config.ArchiveMaxTotalSize > 0 {
+		limits.MaxTotalSize = config.ArchiveMaxTotalSize
+	}
+	return limits
+}
+
+// SetArchiveLimits sets the limits applied when expanding uploaded archives
+func (f *FileStorageManager) SetArchiveLimits(limits ArchiveLimits) {
+	f.archiveLimits = limits
+}
+
+// AwsUploadArchive extracts an uploaded zip archive into prefix in an S3
+// bucket and returns the manifest of stored files
+func (f *FileStorageManager) AwsUploadArchive(file *multipart.FileHeader, prefix string, bucketname string) (*FileResponse, error) {
+	return f.AwsUploadArchiveWithOptions(file, UploadOptions{Prefix: prefix, Bucket: bucketname})
+}
+
+// AwsUploadArchiveWithOptions extracts an uploaded zip archive into
+// opts.Prefix in an S3 bucket, storing every entry with the options, e.g.
+// its owner, and returns the manifest of stored files. Entries keep their
+// directory inside the archive, sanitized like preserved filenames, and are
+// named like other uploads, never replacing a stored object.
+func (f *FileStorageManager) AwsUploadArchiveWithOptions(file *multipart.FileHeader, opts UploadOptions) (*FileResponse, error) {
+	ctx := opts.context()
+
+	// The archive is held in memory until every entry is extracted
+	release, err := f.reserveMemory(ctx, file.Size)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	entries, err := f.openArchive(file, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use default bucket if not specified
+	bucketname := opts.Bucket
+	if bucketname == "" {
+		bucketname = f.config.AWSBucket
+	}
+
+	// Get AWS S3 client
+	s3Client, err := f.GetAwsClient()
+	if err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+
+	exists := func(key string) (bool, error) {
+		return awsObjectExists(ctx, s3Client, bucketname, key)
+	}
+	return f.extractArchive(entries, ProviderAWS, &opts, exists, func(key string, payload *uploadPayload) *FileResponse {
+		return f.storeAwsPayload(ctx, s3Client, bucketname, key, payload)
+	})
+}
+
+// GcsUploadArchive extracts an uploaded zip archive into prefix in a GCS
+// bucket and returns the manifest of stored files
+func (f *FileStorageManager) GcsUploadArchive(file *multipart.FileHeader, prefix string, bucketname string, projectID string) (*FileResponse, error) {
+	return f.GcsUploadArchiveWithOptions(file, UploadOptions{Prefix: prefix, Bucket: bucketname, ProjectID: projectID})
+}
+
+// GcsUploadArchiveWithOptions extracts an uploaded zip archive into
+// opts.Prefix in a GCS bucket like AwsUploadArchiveWithOptions
+func (f *FileStorageManager) GcsUploadArchiveWithOptions(file *multipart.FileHeader, opts UploadOptions) (*FileResponse, error) {
+	ctx := opts.context()
+
+	// The archive is held in memory until every entry is extracted
+	release, err := f.reserveMemory(ctx, file.Size)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	entries, err := f.openArchive(file, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use default bucket if not specified
+	bucketname := opts.Bucket
+	if bucketname == "" {
+		bucketname = f.config.GCSBucket
+	}
+
+	// Get GCS client
+	gcsClient, err := f.GetGcsClient(opts.ProjectID)
+	if err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+	defer gcsClient.Close()
+
+	// Get bucket handle
+	bucket := gcsClient.Bucket(bucketname)
+
+	// Check if bucket exists
+	err = f.checkGcsBucketExists(ctx, bucket)
+	if err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: "Bucket not found",
+		}, nil
+	}
+
+	exists := func(key string) (bool, error) {
+		return gcsObjectExists(ctx, bucket, key)
+	}
+	return f.extractArchive(entries, ProviderGCS, &opts, exists, func(key string, payload *uploadPayload) *FileResponse {
+		return f.storeGcsPayload(ctx, bucket, bucketname, opts.ProjectID, key, payload)
+	})
+}
+
+// openArchive validates an uploaded zip archive and the options its entries
+// are stored with, checking entry names for path traversal and declared
+// sizes against the archive limits before anything is extracted
+func (f *FileStorageManager) openArchive(file *multipart.FileHeader, opts *UploadOptions) ([]archiveEntry, error) {
+	if err := f.checkUploadSize(file.Size); err != nil {
+		return nil, err
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	attributes, err := f.attributeSchema.Validate(opts.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	opts.Attributes = attributes
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	data, err := readAll(src, file.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	if sniffMimeType(data) != "application/zip" {
+		return nil, fmt.Errorf("%w: not a zip file", ErrInvalidArchive)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	var entries []archiveEntry
+	var totalSize uint64
+
+	for _, zf := range reader.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+
+		key, err := archiveEntryKey(opts.Prefix, zf.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(entries) >= f.archiveLimits.MaxEntries {
+			return nil, fmt.Errorf("%w: more than %d files", ErrArchiveLimitExceeded, f.archiveLimits.MaxEntries)
+		}
+		if zf.UncompressedSize64 > uint64(f.archiveLimits.MaxEntrySize) {
+			return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrArchiveLimitExceeded, zf.Name, f.archiveLimits.MaxEntrySize)
+		}
+		totalSize += zf.UncompressedSize64
+		if totalSize > uint64(f.archiveLimits.MaxTotalSize) {
+			return nil, fmt.Errorf("%w: content exceeds %d bytes", ErrArchiveLimitExceeded, f.archiveLimits.MaxTotalSize)
+		}
+
+		if err := f.fileFilter.Check(zf.Name, ""); err != nil {
+			return nil, fmt.Errorf("%s: %w", zf.Name, err)
+		}
+
+		entries = append(entries, archiveEntry{file: zf, key: key})
+	}
+
+	return entries, nil
+}
+
+// extractArchive decompresses the archive entries one at a time and stores
+// them with the options by store, under keys generated in the entry's
+// directory that exists does not report as taken. Declared sizes are not
+// trusted, so the limits are enforced again while reading. Extraction stops
+// at the first failing entry, or once the context of the options is done;
+// entries stored before it are kept and listed in the response.
+func (f *FileStorageManager) extractArchive(entries []archiveEntry, provider string, opts *UploadOptions, exists func(key string) (bool, error), store func(key string, payload *uploadPayload) *FileResponse) (*FileResponse, error) {
+	manifest := []*FileInfo{}
+	var totalSize int64
+
+	for _, entry := range entries {
+		if err := opts.context().Err(); err != nil {
+			return &FileResponse{
+				Status:  StatusError,
+				Message: entry.file.Name + ": " + err.Error(),
+				Files:   manifest,
+			}, nil
+		}
+
+		data, err := readArchiveEntry(entry.file, f.archiveLimits.MaxEntrySize)
+		if err != nil {
+			return nil, err
+		}
+
+		totalSize += int64(len(data))
+		if totalSize > f.archiveLimits.MaxTotalSize {
+			return nil, fmt.Errorf("%w: content exceeds %d bytes", ErrArchiveLimitExceeded, f.archiveLimits.MaxTotalSize)
+		}
+
+		payload, err := f.preparePayload(entry.file.Name, mime.TypeByExtension(path.Ext(entry.file.Name)), data, provider)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.file.Name, err)
+		}
+
+		payload.options = *opts
+		payload.options.Namer = nil
+
+		directory := path.Dir(entry.key)
+		if directory == "." {
+			directory = ""
+		}
+		key, err := f.newObjectKey(directory, payload, exists)
+		if err != nil {
+			return &FileResponse{
+				Status:  StatusError,
+				Message: entry.file.Name + ": " + err.Error(),
+				Files:   manifest,
+			}, nil
+		}
+
+		response := store(key, payload)
+		if response.Status != StatusSuccess {
+			return &FileResponse{
+				Status:  StatusError,
+				Message: entry.file.Name + ": " + response.Message,
+				Files:   manifest,
+			}, nil
+		}
+
+		manifest = append(manifest, response.Info)
+	}
+
+	return &FileResponse{
+		Status:  StatusSuccess,
+		Message: fmt.Sprintf("EXTRACT %d files", len(manifest)),
+		Files:   manifest,
+	}, nil
+}
+
+// readArchiveEntry decompresses an archive entry, failing once it grows beyond maxSize
+func readArchiveEntry(zf *zip.File, maxSize int64) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer rc.Close()
+
+	data, err := readAll(io.LimitReader(rc, maxSize+1), min(int64(zf.UncompressedSize64), maxSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrArchiveLimitExceeded, zf.Name, maxSize)
+	}
+
+	return data, nil
+}
+
+// archiveEntryKey maps an archive entry name to an object key below prefix,
+// rejecting absolute paths, parent directory references (zip slip) and keys
+// in the prefixes the service keeps its own objects in
+func archiveEntryKey(prefix, name string) (string, error) {
+	if strings.Contains(name, "\\") || path.IsAbs(name) {
+		return "", fmt.Errorf("%w: unsafe path %q", ErrInvalidArchive, name)
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("%w: unsafe path %q", ErrInvalidArchive, name)
+		}
+		if segment = sanitizeFilename(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	if len(segments) == 0 {
+		return "", fmt.Errorf("%w: invalid name %q", ErrInvalidArchive, name)
+	}
+
+	key := joinObjectKey(strings.Trim(prefix, "/"), path.Join(segments...))
+	if reservedKey(key) {
+		return "", fmt.Errorf("%w: reserved path %q", ErrInvalidArchive, name)
+	}
+	return key, nil
+}
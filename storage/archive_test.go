@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestArchiveEntryKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{name: "file", entry: "report.pdf", want: "report.pdf"},
+		{name: "nested file", entry: "theses/2024/report.pdf", want: "theses/2024/report.pdf"},
+		{name: "below prefix", prefix: "/uploads/", entry: "report.pdf", want: "uploads/report.pdf"},
+		{name: "empty and dot segments dropped", entry: "./theses//./report.pdf", want: "theses/report.pdf"},
+		{name: "unsafe characters replaced", entry: "my thesis/final draft?.pdf", want: "my-thesis/final-draft-.pdf"},
+		{name: "leading dots trimmed", entry: ".trash/report.pdf", want: "trash/report.pdf"},
+		{name: "hidden file", entry: "theses/.hidden", want: "theses/hidden"},
+
+		{name: "parent segment", entry: "../report.pdf", wantErr: true},
+		{name: "nested parent segment", entry: "theses/../../report.pdf", wantErr: true},
+		{name: "trailing parent segment", entry: "theses/..", wantErr: true},
+		{name: "backslash", entry: "theses\\report.pdf", wantErr: true},
+		{name: "backslash parent segment", entry: "..\\report.pdf", wantErr: true},
+		{name: "absolute path", entry: "/etc/passwd", wantErr: true},
+		{name: "empty name", entry: "", wantErr: true},
+		{name: "only separators", entry: "//", wantErr: true},
+		{name: "only dots", entry: "./.../.", wantErr: true},
+		{name: "into trash", prefix: strings.TrimSuffix(TrashPrefix, "/"), entry: "report.pdf", wantErr: true},
+		{name: "into archive", prefix: ArchivePrefix, entry: "report.pdf", wantErr: true},
+		{name: "into versions", prefix: VersionPrefix, entry: "report.pdf", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := archiveEntryKey(tt.prefix, tt.entry)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidArchive) {
+					t.Errorf("archiveEntryKey(%q, %q) = %q, %v, want ErrInvalidArchive", tt.prefix, tt.entry, key, err)
+				}
+				return
+			}
+			if err != nil || key != tt.want {
+				t.Errorf("archiveEntryKey(%q, %q) = %q, %v, want %q", tt.prefix, tt.entry, key, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// pkg/storage/attributes.go
+
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of custom file attributes
+const (
+	AttributeString = "string" // Any text
+	AttributeInt    = "int"    // A base 10 integer, e.g. "3"
+	AttributeBool   = "bool"   // "true" or "false"
+	AttributeDate   = "date"   // A date as YYYY-MM-DD, e.g. "2024-08-17"
+	AttributeEnum   = "enum"   // One of the values of the field
+)
+
+// Reasons an attribute does not match the attribute schema
+const (
+	AttributeMissing  = "missing"   // A required attribute was not given
+	AttributeBadType  = "bad_type"  // The value is not of the type of the field
+	AttributeBadValue = "bad_value" // The value is not one of the enum values
+	AttributeUnknown  = "unknown"   // The schema has no field of the name
+)
+
+// MaxAttributeLength is the longest value a custom attribute may have
+const MaxAttributeLength = 1024
+
+// attributeName matches the names of custom attributes, e.g. "activity_id"
+var attributeName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// Attributes are the custom attributes of a file by name, e.g.
+// {"activity_id": "42", "semester": "odd"}
+type Attributes map[string]string
+
+// AttributeField declares a custom attribute of uploaded files
+type AttributeField struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"` // AttributeString, AttributeInt, AttributeBool, AttributeDate or AttributeEnum
+	Required bool     `json:"required"`
+	Values   []string `json:"values,omitempty"` // Allowed values of an AttributeEnum
+}
+
+// AttributeSchema declares the custom attributes uploads carry, e.g. the
+// activity a submission belongs to, so reports can rely on the same fields
+// being recorded for every file. Attributes the schema does not declare are
+// rejected; without a schema any attributes are recorded as given.
+type AttributeSchema []AttributeField
+
+// InvalidAttribute is an attribute that does not match the attribute schema
+type InvalidAttribute struct {
+	Name   string
+	Reason string // AttributeMissing, AttributeBadType, AttributeBadValue or AttributeUnknown
+}
+
+// AttributeError lists the attributes of an upload that do not match the
+// attribute schema. It matches ErrInvalidAttributes with errors.Is.
+type AttributeError []InvalidAttribute
+
+// Error implements error
+func (e AttributeError) Error() string {
+	parts := make([]string, len(e))
+	for i, attribute := range e {
+		parts[i] = attribute.Name + ": " + attribute.Reason
+	}
+	return ErrInvalidAttributes.Error() + ": " + strings.Join(parts, ", ")
+}
+
+// Unwrap returns ErrInvalidAttributes
+func (e AttributeError) Unwrap() error {
+	return ErrInvalidAttributes
+}
+
+// ParseAttributeSchema parses comma separated name[:type][:required]
+// fields, where type is string (default), int, bool, date or enum=a|b,
+// e.g. "activity_id:string:required,semester:enum=odd|even,credits:int"
+func ParseAttributeSchema(value string) (AttributeSchema, error) {
+	var schema AttributeSchema
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		field := AttributeField{Name: strings.TrimSpace(parts[0]), Type: AttributeString}
+		for _, part := range parts[1:] {
+			part = strings.TrimSpace(part)
+			switch {
+			case part == "required":
+				field.Required = true
+			case strings.HasPrefix(part, AttributeEnum+"="):
+				field.Type = AttributeEnum
+				for _, value := range strings.Split(strings.TrimPrefix(part, AttributeEnum+"="), "|") {
+					if value = strings.TrimSpace(value); value != "" {
+						field.Values = append(field.Values, value)
+					}
+				}
+			default:
+				field.Type = part
+			}
+		}
+		schema = append(schema, field)
+	}
+
+	return schema, schema.check()
+}
+
+// SetAttributeSchema replaces the schema custom attributes of uploads are
+// validated against, nil records any attributes as given
+func (f *FileStorageManager) SetAttributeSchema(schema AttributeSchema) error {
+	if err := schema.check(); err != nil {
+		return err
+	}
+
+	f.attributeSchema = schema
+	return nil
+}
+
+// AttributeSchema returns the schema custom attributes are validated against
+func (f *FileStorageManager) AttributeSchema() AttributeSchema {
+	return f.attributeSchema
+}
+
+// Validate checks attributes against the schema and returns them with their
+// values normalized, e.g. integers without leading zeros. An empty value is
+// taken as not given. Every mismatching attribute is reported at once.
+func (s AttributeSchema) Validate(attributes Attributes) (Attributes, error) {
+	var invalid AttributeError
+	normalized := Attributes{}
+
+	for name, value := range attributes {
+		if value == "" {
+			continue
+		}
+		if !attributeName.MatchString(name) || (s != nil && s.field(name) == nil) {
+			invalid = append(invalid, InvalidAttribute{Name: name, Reason: AttributeUnknown})
+			continue
+		}
+		if len(value) > MaxAttributeLength || strings.ContainsAny(value, "\r\n\x00") {
+			invalid = append(invalid, InvalidAttribute{Name: name, Reason: AttributeBadValue})
+			continue
+		}
+		normalized[name] = value
+	}
+
+	for _, field := range s {
+		value, given := normalized[field.Name]
+		if !given {
+			if field.Required {
+				invalid = append(invalid, InvalidAttribute{Name: field.Name, Reason: AttributeMissing})
+			}
+			continue
+		}
+
+		value, reason := field.normalize(value)
+		if reason != "" {
+			invalid = append(invalid, InvalidAttribute{Name: field.Name, Reason: reason})
+			continue
+		}
+		normalized[field.Name] = value
+	}
+
+	if len(invalid) > 0 {
+		sort.Slice(invalid, func(i, j int) bool { return invalid[i].Name < invalid[j].Name })
+		return nil, invalid
+	}
+	if len(normalized) == 0 {
+		return nil, nil
+	}
+	return normalized, nil
+}
+
+// field returns the field of a name, or nil when the schema has none
+func (s AttributeSchema) field(name string) *AttributeField {
+	for i := range s {
+		if s[i].Name == name {
+			return &s[i]
+		}
+	}
+	return nil
+}
+
+// normalize converts a value of the field to its canonical form, or returns
+// why it does not match the field
+func (a *AttributeField) normalize(value string) (string, string) {
+	switch a.Type {
+	case AttributeInt:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return "", AttributeBadType
+		}
+		return strconv.FormatInt(n, 10), ""
+
+	case AttributeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return "", AttributeBadType
+		}
+		return strconv.FormatBool(b), ""
+
+	case AttributeDate:
+		if _, err := time.Parse("2006-01-02", strings.TrimSpace(value)); err != nil {
+			return "", AttributeBadType
+		}
+		return strings.TrimSpace(value), ""
+
+	case AttributeEnum:
+		for _, allowed := range a.Values {
+			if value == allowed {
+				return value, ""
+			}
+		}
+		return "", AttributeBadValue
+	}
+
+	return value, ""
+}
+
+// check rejects schemas with invalid or repeated names, unknown types or
+// enums without values
+func (s AttributeSchema) check() error {
+	seen := map[string]bool{}
+	for _, field := range s {
+		if !attributeName.MatchString(field.Name) {
+			return fmt.Errorf("%w: %q is not a lowercase name", ErrInvalidAttributeSchema, field.Name)
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("%w: %s is declared twice", ErrInvalidAttributeSchema, field.Name)
+		}
+		seen[field.Name] = true
+
+		switch field.Type {
+		case AttributeString, AttributeInt, AttributeBool, AttributeDate:
+		case AttributeEnum:
+			if len(field.Values) == 0 {
+				return fmt.Errorf("%w: enum %s has no values", ErrInvalidAttributeSchema, field.Name)
+			}
+		default:
+			return fmt.Errorf("%w: %s has unknown type %q", ErrInvalidAttributeSchema, field.Name, field.Type)
+		}
+	}
+	return nil
+}
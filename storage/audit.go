@@ -0,0 +1,113 @@
+// pkg/storage/audit.go
+
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Audited actions
+const (
+	AuditDownload  = "download"   // File content served, directly or through a link
+	AuditSignedURL = "signed_url" // Signed URL, share link, short link or download token handed out
+	AuditDelete    = "delete"     // File deleted or moved to the trash
+)
+
+const (
+	// DefaultAuditLimit is the number of events a query returns when no limit is given
+	DefaultAuditLimit = 100
+
+	// MaxAuditLimit is the most events a query returns at once
+	MaxAuditLimit = 1000
+)
+
+// AuditEvent records who accessed a file, when and from where. Events are
+// recorded whatever the outcome, which Status tells.
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"` // AuditDownload, AuditSignedURL or AuditDelete
+	Provider  string    `json:"provider,omitempty"`
+	FileID    string    `json:"file_id,omitempty"`    // A prefix ending in "/" for exports of several files
+	Subject   string    `json:"subject,omitempty"`    // Signed in user or API key ID, "" when anonymous
+	ClientID  string    `json:"client_id,omitempty"`  // Calling service, if it identified itself
+	ClientIP  string    `json:"client_ip,omitempty"`  // Address the request came from
+	UserAgent string    `json:"user_agent,omitempty"` // Browser or client library of the request
+	Via       string    `json:"via,omitempty"`        // Route the file was accessed through, e.g. "/shared/:token"
+	Status    int       `json:"status"`               // HTTP status the request was answered with
+}
+
+// AuditQuery filters the events of the audit log. Empty fields do not filter.
+type AuditQuery struct {
+	FileID  string
+	Subject string
+	Action  string
+	From    time.Time // At or after
+	To      time.Time // Before
+	Limit   int       // DefaultAuditLimit when 0
+}
+
+// AuditLog is an append-only log of file access events
+type AuditLog interface {
+	// AppendAuditEvent adds an event to the end of the log
+	AppendAuditEvent(event *AuditEvent) error
+	// QueryAuditEvents returns the events matching a normalized query, newest first
+	QueryAuditEvents(query AuditQuery) ([]*AuditEvent, error)
+}
+
+// SetAuditLog sets the log downloads, signed URLs and deletes are recorded
+// in. No events are recorded until one is set.
+func (f *FileStorageManager) SetAuditLog(log AuditLog) {
+	f.auditLog = log
+}
+
+// RecordAudit appends an event to the audit log, stamping it with the
+// current time unless it has one. It does nothing without an audit log.
+func (f *FileStorageManager) RecordAudit(event AuditEvent) error {
+	if f.auditLog == nil {
+		return nil
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	return f.auditLog.AppendAuditEvent(&event)
+}
+
+// AuditEvents returns the audit events matching a query, newest first
+func (f *FileStorageManager) AuditEvents(query AuditQuery) ([]*AuditEvent, error) {
+	if f.auditLog == nil {
+		return nil, ErrAuditNotConfigured
+	}
+
+	if query.Limit == 0 {
+		query.Limit = DefaultAuditLimit
+	}
+	if query.Limit < 0 || query.Limit > MaxAuditLimit {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxAuditLimit)
+	}
+	switch query.Action {
+	case "", AuditDownload, AuditSignedURL, AuditDelete:
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidQuery, query.Action)
+	}
+
+	return f.auditLog.QueryAuditEvents(query)
+}
+
+// Matches reports whether an event matches the filters of the query
+func (q *AuditQuery) Matches(event *AuditEvent) bool {
+	switch {
+	case q.FileID != "" && event.FileID != q.FileID:
+		return false
+	case q.Subject != "" && event.Subject != q.Subject:
+		return false
+	case q.Action != "" && event.Action != q.Action:
+		return false
+	case !q.From.IsZero() && event.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && !event.Time.Before(q.To):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+// pkg/storage/aws_client.go
+
+package storage
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// awsProfile is the region and credentials an S3 client is made for
+type awsProfile struct {
+	region string
+	key    string
+	secret string
+}
+
+// awsClients caches S3 clients by region, so operations share a session and
+// its connections instead of setting one up, and handshaking TLS, each time.
+// A client is made again when the credentials of its region change.
+type awsClients struct {
+	mu      sync.Mutex
+	profile awsProfile            // Current region and credentials
+	clients map[string]*awsClient // By region
+}
+
+// newAwsClients returns a client cache for the configured region and credentials
+func newAwsClients(config *Config) *awsClients {
+	return &awsClients{
+		profile: awsProfile{region: config.AWSRegion, key: config.AWSKey, secret: config.AWSSecret},
+		clients: make(map[string]*awsClient),
+	}
+}
+
+// awsClient is an S3 client with the profile it was made for
+type awsClient struct {
+	profile awsProfile
+	client  *s3.S3
+}
+
+// SetAwsCredentials replaces the region and credentials of S3, e.g. after a
+// key rotation. Clients made for the previous credentials are dropped. The
+// config the manager was made with is left as it is.
+func (f *FileStorageManager) SetAwsCredentials(region, key, secret string) {
+	f.awsClients.mu.Lock()
+	defer f.awsClients.mu.Unlock()
+
+	f.awsClients.profile = awsProfile{region: region, key: key, secret: secret}
+	f.awsClients.clients = make(map[string]*awsClient)
+}
+
+// awsRegion returns the current region of S3
+func (f *FileStorageManager) awsRegion() string {
+	f.awsClients.mu.Lock()
+	defer f.awsClients.mu.Unlock()
+
+	return f.awsClients.profile.region
+}
+
+// close closes the idle connections of the cached clients and drops them
+func (c *awsClients) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, cached := range c.clients {
+		cached.client.Config.HTTPClient.CloseIdleConnections()
+	}
+	c.clients = make(map[string]*awsClient)
+}
+
+// GetAwsClient returns an AWS S3 client. The client of the configured region
+// is reused until its credentials change.
+func (f *FileStorageManager) GetAwsClient() (*s3.S3, error) {
+	f.awsClients.mu.Lock()
+	defer f.awsClients.mu.Unlock()
+
+	profile := f.awsClients.profile
+	if cached, ok := f.awsClients.clients[profile.region]; ok && cached.profile == profile {
+		return cached.client, nil
+	}
+
+	// The SDK only loads a custom CA bundle, e.g. from AWS_CA_BUNDLE, into an
+	// *http.Transport, so the session is set up on the one the metered
+	// transport wraps and switched to the metered transport after
+	transport := f.metrics.newTransport(ProviderAWS)
+	httpClient := &http.Client{Transport: transport.base}
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(profile.region),
+		Credentials: credentials.NewStaticCredentials(profile.key, profile.secret, ""),
+		HTTPClient:  httpClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+	httpClient.Transport = f.wrapTransport(ProviderAWS, transport)
+
+	client := s3.New(sess)
+	f.awsClients.clients[profile.region] = &awsClient{profile: profile, client: client}
+	return client, nil
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSetAwsCredentials(t *testing.T) {
+	config := &Config{AWSRegion: "us-east-1", AWSKey: "old-key", AWSSecret: "old-secret"}
+	f := NewFileStorageManager(config, nil)
+
+	before, err := f.GetAwsClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			f.SetAwsCredentials("ap-southeast-3", "new-key", "new-secret")
+		}()
+		go func() {
+			defer wg.Done()
+			f.GetAwsClient()
+			f.awsPublicURL("bucket", "report.pdf")
+		}()
+	}
+	wg.Wait()
+
+	after, err := f.GetAwsClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == before {
+		t.Error("client of the previous credentials reused")
+	}
+	if got := *after.Config.Region; got != "ap-southeast-3" {
+		t.Errorf("client region = %q, want ap-southeast-3", got)
+	}
+	if url := f.awsPublicURL("bucket", "report.pdf"); !strings.Contains(url, ".s3.ap-southeast-3.") {
+		t.Errorf("public URL = %q, want the new region", url)
+	}
+	if config.AWSRegion != "us-east-1" || config.AWSKey != "old-key" {
+		t.Errorf("config changed to %+v", config)
+	}
+}
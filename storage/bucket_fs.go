@@ -0,0 +1,316 @@
+// pkg/storage/bucket_fs.go
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"google.golang.org/api/iterator"
+)
+
+// BucketFS is a read-only io/fs view of a bucket below a prefix. Folders are
+// derived from "/" in object keys, so empty folders do not exist.
+type BucketFS struct {
+	manager    *FileStorageManager
+	provider   string
+	prefix     string
+	bucketname string
+	projectID  string
+}
+
+// FS returns a read-only fs.FS over the objects below prefix in an S3 or GCS
+// bucket, e.g. for template.ParseFS or fs.WalkDir
+func (f *FileStorageManager) FS(provider, prefix, bucketname, projectID string) *BucketFS {
+	return &BucketFS{
+		manager:    f,
+		provider:   provider,
+		prefix:     strings.Trim(prefix, "/"),
+		bucketname: bucketname,
+		projectID:  projectID,
+	}
+}
+
+// Open implements fs.FS
+func (b *BucketFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name != "." {
+		file, err := b.manager.OpenFile(b.provider, b.key(name), b.bucketname, b.projectID)
+		if err == nil {
+			return &bucketFile{ObjectFile: file, name: path.Base(name)}, nil
+		}
+		if !errors.Is(err, ErrFileNotFound) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	entries, err := b.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &bucketDir{name: name, entries: entries}, nil
+}
+
+// Stat implements fs.StatFS
+func (b *BucketFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := b.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return file.Stat()
+}
+
+// ReadDir implements fs.ReadDirFS
+func (b *BucketFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entries, err := b.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return entries, nil
+}
+
+// key returns the object key of a path in the file system
+func (b *BucketFS) key(name string) string {
+	if name == "." {
+		return b.prefix
+	}
+	return joinObjectKey(b.prefix, name)
+}
+
+// readDir lists the files and folders directly below a folder, sorted by name
+func (b *BucketFS) readDir(name string) ([]fs.DirEntry, error) {
+	dirPrefix := b.key(name)
+	if dirPrefix != "" {
+		dirPrefix += "/"
+	}
+
+	objects, folders, err := b.manager.listFolder(b.provider, dirPrefix, b.bucketname, b.projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, 0, len(objects)+len(folders))
+	for _, object := range objects {
+		entries = append(entries, fs.FileInfoToDirEntry(&objectInfo{
+			name:    path.Base(object.Key),
+			size:    object.Size,
+			modTime: object.ModTime,
+		}))
+	}
+	for _, folder := range folders {
+		entries = append(entries, fs.FileInfoToDirEntry(&objectInfo{
+			name: path.Base(folder),
+			dir:  true,
+		}))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// listFolder lists the objects and subfolders directly below prefix
+func (f *FileStorageManager) listFolder(provider, prefix, bucketname, projectID string) ([]ObjectInfo, []string, error) {
+	switch provider {
+	case ProviderAWS:
+		return f.awsListFolder(prefix, bucketname)
+	case ProviderGCS:
+		return f.gcsListFolder(prefix, bucketname, projectID)
+	}
+
+	return nil, nil, fmt.Errorf("unknown provider %q", provider)
+}
+
+// awsListFolder lists an S3 prefix up to the next "/"
+func (f *FileStorageManager) awsListFolder(prefix, bucketname string) ([]ObjectInfo, []string, error) {
+	// Use default bucket if not specified
+	if bucketname == "" {
+		bucketname = f.config.AWSBucket
+	}
+
+	s3Client, err := f.GetAwsClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var objects []ObjectInfo
+	var folders []string
+	err = s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucketname),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, item := range page.Contents {
+			key := aws.StringValue(item.Key)
+			if key == prefix {
+				continue
+			}
+			objects = append(objects, ObjectInfo{
+				Key:     key,
+				Size:    aws.Int64Value(item.Size),
+				ModTime: aws.TimeValue(item.LastModified),
+			})
+		}
+		for _, common := range page.CommonPrefixes {
+			folders = append(folders, strings.TrimSuffix(aws.StringValue(common.Prefix), "/"))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return objects, folders, nil
+}
+
+// gcsListFolder lists a GCS prefix up to the next "/"
+func (f *FileStorageManager) gcsListFolder(prefix, bucketname, projectID string) ([]ObjectInfo, []string, error) {
+	ctx := context.Background()
+
+	// Use default bucket if not specified
+	if bucketname == "" {
+		bucketname = f.config.GCSBucket
+	}
+
+	gcsClient, err := f.GetGcsClient(projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer gcsClient.Close()
+
+	var objects []ObjectInfo
+	var folders []string
+	it := gcsClient.Bucket(bucketname).Objects(ctx, &storage.Query{Prefix: prefix, Delimiter: "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case attrs.Prefix != "":
+			folders = append(folders, strings.TrimSuffix(attrs.Prefix, "/"))
+		case attrs.Name != prefix:
+			objects = append(objects, ObjectInfo{
+				Key:         attrs.Name,
+				Size:        attrs.Size,
+				ContentType: attrs.ContentType,
+				ModTime:     attrs.Updated,
+			})
+		}
+	}
+
+	return objects, folders, nil
+}
+
+// bucketFile is an object opened through a BucketFS
+type bucketFile struct {
+	*ObjectFile
+	name string
+}
+
+// Stat implements fs.File
+func (b *bucketFile) Stat() (fs.FileInfo, error) {
+	return &objectInfo{name: b.name, size: b.Size(), modTime: b.ModTime()}, nil
+}
+
+// bucketDir is a folder opened through a BucketFS
+type bucketDir struct {
+	name    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+// Stat implements fs.File
+func (d *bucketDir) Stat() (fs.FileInfo, error) {
+	return &objectInfo{name: path.Base(d.name), dir: true}, nil
+}
+
+// Read implements fs.File, failing since folders have no content
+func (d *bucketDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+// Close implements fs.File
+func (d *bucketDir) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *bucketDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
+
+// objectInfo describes an object or folder as an fs.FileInfo
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i *objectInfo) Name() string       { return i.name }
+func (i *objectInfo) Size() int64        { return i.size }
+func (i *objectInfo) ModTime() time.Time { return i.modTime }
+func (i *objectInfo) IsDir() bool        { return i.dir }
+func (i *objectInfo) Sys() interface{}   { return nil }
+
+// Mode implements fs.FileInfo, reporting read-only permissions
+func (i *objectInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// HTTPFileSystem returns an http.FileSystem over the objects below prefix in
+// an S3 or GCS bucket, e.g. for gin's StaticFS. Files are served with Range
+// and If-Modified-Since support.
+func (f *FileStorageManager) HTTPFileSystem(provider, prefix, bucketname, projectID string) http.FileSystem {
+	return http.FS(f.FS(provider, prefix, bucketname, projectID))
+}
@@ -0,0 +1,70 @@
+// pkg/storage/buffer_pool.go
+
+package storage
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+const (
+	// TransferBufferSize is the size of the buffers transfers are copied through
+	TransferBufferSize = 64 << 10
+
+	// maxPooledBuffer is the largest buffer returned to the pool; larger ones
+	// are left to the garbage collector rather than kept alive
+	maxPooledBuffer = 8 << 20
+)
+
+// transferBuffers pools the buffers transfers are copied through, so
+// concurrent uploads and downloads do not each allocate their own
+var transferBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, TransferBufferSize)
+		return &buf
+	},
+}
+
+// byteBuffers pools the buffers content is encoded into before it is
+// stored, such as compressed uploads and exported reports
+var byteBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// Copy copies src to dst like io.Copy, through a pooled transfer buffer
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := transferBuffers.Get().(*[]byte)
+	defer transferBuffers.Put(buf)
+
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// readAll reads r to the end like io.ReadAll. The content is read into a
+// slice of size bytes, the expected size of r if known, so it is not grown
+// and copied over and over while reading large files. A size of 0 or less
+// reads as io.ReadAll does.
+func readAll(r io.Reader, size int64) ([]byte, error) {
+	if size <= 0 {
+		return io.ReadAll(r)
+	}
+
+	// Room to see EOF without growing the buffer
+	buf := bytes.NewBuffer(make([]byte, 0, size+bytes.MinRead))
+	_, err := buf.ReadFrom(r)
+	return buf.Bytes(), err
+}
+
+// getBuffer returns an empty buffer from the pool
+func getBuffer() *bytes.Buffer {
+	return byteBuffers.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns a buffer to the pool once its content is no longer used
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	byteBuffers.Put(buf)
+}
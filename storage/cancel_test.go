@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// stallingBackend answers HEAD requests for report.txt, and stalls the other
+// requests once their body was read and, for GET, the first KiB of the
+// object was sent, until the client aborts them
+type stallingBackend struct {
+	started chan struct{} // Receives once a request stalls
+	aborted chan struct{} // Receives once a stalled request was aborted
+}
+
+func newStallingBackend() *stallingBackend {
+	return &stallingBackend{started: make(chan struct{}, 1), aborted: make(chan struct{}, 1)}
+}
+
+func (b *stallingBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+
+	switch r.Method {
+	case http.MethodHead:
+		if r.URL.Path != "/bucket/report.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", "1048576")
+		w.Header().Set("Content-Type", "text/plain")
+		return
+	case http.MethodGet:
+		w.Header().Set("Content-Length", "1048576")
+		w.Write(bytes.Repeat([]byte("a"), 1024))
+		w.(http.Flusher).Flush()
+	}
+
+	b.started <- struct{}{}
+	<-r.Context().Done()
+	b.aborted <- struct{}{}
+}
+
+// awaitAbort fails the test unless the backend saw a stalled request aborted
+func (b *stallingBackend) awaitAbort(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-b.aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("provider request not aborted")
+	}
+}
+
+// checkGoroutines fails the test unless the number of goroutines falls back
+// to baseline once the idle connections are closed
+func checkGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+		if runtime.NumGoroutine() <= baseline {
+			return
+		}
+		if time.Now().After(deadline) {
+			stacks := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines left, %d before:\n%s", runtime.NumGoroutine(), baseline, stacks[:runtime.Stack(stacks, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// formFile returns the header of a multipart file field holding content
+func formFile(t *testing.T, filename string, content []byte) *multipart.FileHeader {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+
+	parsed, err := multipart.NewReader(&body, form.Boundary()).ReadForm(int64(body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { parsed.RemoveAll() })
+	return parsed.File["file"][0]
+}
+
+// cancelWhenStarted cancels the transfer once the backend stalled it
+func cancelWhenStarted(t *testing.T, backend *stallingBackend, cancel context.CancelFunc) {
+	t.Helper()
+
+	select {
+	case <-backend.started:
+		cancel()
+	case <-time.After(5 * time.Second):
+		t.Error("transfer not started")
+		cancel()
+	}
+}
+
+func TestCancelledS3Upload(t *testing.T) {
+	backend := newStallingBackend()
+	f := newS3Manager(newS3Client(t, backend))
+	defer f.Close()
+	file := formFile(t, "new.txt", bytes.Repeat([]byte("notes "), 1<<16))
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancelWhenStarted(t, backend, cancel)
+
+	result, err := f.AwsUploadWithOptions(file, UploadOptions{Context: ctx})
+	if err == nil && result.Status == StatusSuccess {
+		t.Fatal("upload succeeded after it was cancelled")
+	}
+	backend.awaitAbort(t)
+	checkGoroutines(t, baseline)
+}
+
+func TestCancelledS3Download(t *testing.T) {
+	backend := newStallingBackend()
+	f := newS3Manager(newS3Client(t, backend))
+	defer f.Close()
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	file, err := f.AwsOpenFileContext(ctx, "report.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go cancelWhenStarted(t, backend, cancel)
+
+	if _, err := io.Copy(io.Discard, file); err == nil {
+		t.Error("read the cancelled download to the end")
+	}
+	file.Close()
+	backend.awaitAbort(t)
+	checkGoroutines(t, baseline)
+}
+
+func TestCancelledRestUpload(t *testing.T) {
+	backend := newStallingBackend()
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	f := NewFileStorageManager(&Config{HostURI: server.URL}, staticTokens{})
+	defer f.Close()
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancelWhenStarted(t, backend, cancel)
+
+	content := base64.StdEncoding.EncodeToString([]byte("notes"))
+	if _, err := f.UploadBase64FileContext(ctx, "notes", "txt", "text/plain", content); !errors.Is(err, context.Canceled) {
+		t.Errorf("UploadBase64FileContext() error = %v, want context.Canceled", err)
+	}
+	backend.awaitAbort(t)
+
+	f.restClient.CloseIdleConnections()
+	checkGoroutines(t, baseline)
+}
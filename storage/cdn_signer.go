@@ -0,0 +1,224 @@
+// pkg/storage/cdn_signer.go
+
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudfront/sign"
+)
+
+// CDNSigner signs CloudFront URLs for S3 objects served through a
+// distribution. Unlike S3 presigned URLs, CloudFront policies can restrict a
+// link to the client's IP address.
+type CDNSigner struct {
+	domain string
+	keyID  string
+	key    *rsa.PrivateKey
+}
+
+// NewCDNSigner creates a signer for a CloudFront distribution domain such as
+// "d111111abcdef8.cloudfront.net", using the key pair's ID and private key
+func NewCDNSigner(domain, keyPairID string, key *rsa.PrivateKey) *CDNSigner {
+	return &CDNSigner{
+		domain: strings.TrimSuffix(strings.TrimPrefix(domain, "https://"), "/"),
+		keyID:  keyPairID,
+		key:    key,
+	}
+}
+
+// NewCDNSignerFromConfig creates a CDN signer from the configuration,
+// returning nil when no CloudFront distribution is configured
+func NewCDNSignerFromConfig(config *Config) (*CDNSigner, error) {
+	if config.CloudFrontDomain == "" {
+		return nil, nil
+	}
+
+	key, err := sign.LoadPEMPrivKeyFile(config.CloudFrontPrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("load CloudFront private key: %v", err)
+	}
+
+	return NewCDNSigner(config.CloudFrontDomain, config.CloudFrontKeyPairID, key), nil
+}
+
+// SignURL signs the URL of an object key until expiry. A non-empty clientCIDR
+// restricts the link to requests from that range; query holds parameters
+// forwarded to the origin, which are covered by the signature.
+func (s *CDNSigner) SignURL(key string, expiry time.Time, clientCIDR string, query url.Values) (string, error) {
+	resource := "https://" + s.domain + (&url.URL{Path: "/" + strings.TrimPrefix(key, "/")}).EscapedPath()
+	if len(query) > 0 {
+		resource += "?" + query.Encode()
+	}
+
+	policy := &sign.Policy{
+		Statements: []sign.Statement{{
+			Resource: resource,
+			Condition: sign.Condition{
+				DateLessThan: sign.NewAWSEpochTime(expiry),
+			},
+		}},
+	}
+	if clientCIDR != "" {
+		policy.Statements[0].Condition.IPAddress = &sign.IPAddress{SourceIP: clientCIDR}
+	}
+
+	return sign.NewURLSigner(s.keyID, s.key).SignWithPolicy(resource, policy)
+}
+
+// SignCookies returns CloudFront signed cookies granting access to every
+// object below prefix until expiry. cookieDomain must cover the distribution
+// domain, e.g. ".example.com" for "cdn.example.com".
+func (s *CDNSigner) SignCookies(prefix string, expiry time.Time, cookieDomain string) ([]*http.Cookie, error) {
+	path := cookiePath(prefix)
+
+	policy := &sign.Policy{
+		Statements: []sign.Statement{{
+			Resource: "https://" + s.domain + path + "*",
+			Condition: sign.Condition{
+				DateLessThan: sign.NewAWSEpochTime(expiry),
+			},
+		}},
+	}
+
+	return sign.NewCookieSigner(s.keyID, s.key).SignWithPolicy(policy, func(o *sign.CookieOptions) {
+		o.Path = path
+		o.Domain = cookieDomain
+		o.Secure = true
+	})
+}
+
+// CloudCDNSigner signs Cloud CDN cookies for GCS objects served through a
+// backend bucket with signed requests enabled
+type CloudCDNSigner struct {
+	domain  string
+	keyName string
+	key     []byte
+}
+
+// NewCloudCDNSigner creates a signer for a Cloud CDN domain using the name and
+// value of one of the backend bucket's signing keys
+func NewCloudCDNSigner(domain, keyName string, key []byte) *CloudCDNSigner {
+	return &CloudCDNSigner{
+		domain:  strings.TrimSuffix(strings.TrimPrefix(domain, "https://"), "/"),
+		keyName: keyName,
+		key:     key,
+	}
+}
+
+// NewCloudCDNSignerFromConfig creates a Cloud CDN signer from the
+// configuration, returning nil when no Cloud CDN domain is configured
+func NewCloudCDNSignerFromConfig(config *Config) (*CloudCDNSigner, error) {
+	if config.CloudCDNDomain == "" {
+		return nil, nil
+	}
+	if config.CloudCDNKeyName == "" || len(config.CloudCDNKey) == 0 {
+		return nil, fmt.Errorf("Cloud CDN signing key not configured")
+	}
+
+	return NewCloudCDNSigner(config.CloudCDNDomain, config.CloudCDNKeyName, config.CloudCDNKey), nil
+}
+
+// SignCookie returns a Cloud CDN signed cookie granting access to every
+// object below prefix until expiry. cookieDomain must cover the CDN domain.
+func (s *CloudCDNSigner) SignCookie(prefix string, expiry time.Time, cookieDomain string) *http.Cookie {
+	path := cookiePath(prefix)
+	urlPrefix := base64.URLEncoding.EncodeToString([]byte("https://" + s.domain + path))
+
+	policy := fmt.Sprintf("URLPrefix=%s:Expires=%d:KeyName=%s", urlPrefix, expiry.Unix(), s.keyName)
+	mac := hmac.New(sha1.New, s.key)
+	mac.Write([]byte(policy))
+	signature := base64.URLEncoding.EncodeToString(mac.Sum(nil))
+
+	return &http.Cookie{
+		Name:     "Cloud-CDN-Cookie",
+		Value:    policy + ":Signature=" + signature,
+		Path:     path,
+		Domain:   cookieDomain,
+		Expires:  expiry,
+		Secure:   true,
+		HttpOnly: true,
+	}
+}
+
+// cookiePath returns the URL path of a key prefix with a trailing slash
+func cookiePath(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return "/"
+	}
+	return (&url.URL{Path: "/" + prefix + "/"}).EscapedPath()
+}
+
+// CDNCookies returns signed cookies letting a browser load every object below
+// prefix through the configured CDN until expiry: CloudFront for S3, Cloud CDN
+// for GCS
+func (f *FileStorageManager) CDNCookies(provider, prefix string, expiry time.Time) ([]*http.Cookie, error) {
+	if !expiry.After(time.Now()) {
+		return nil, fmt.Errorf("cookie expiry must be in the future")
+	}
+
+	switch provider {
+	case ProviderAWS:
+		if f.cdnSignerErr != nil {
+			return nil, f.cdnSignerErr
+		}
+		if f.cdnSigner == nil {
+			return nil, fmt.Errorf("%w: CloudFront", ErrCDNNotConfigured)
+		}
+		return f.cdnSigner.SignCookies(prefix, expiry, f.config.CDNCookieDomain)
+
+	case ProviderGCS:
+		if f.cloudCDNSignerErr != nil {
+			return nil, f.cloudCDNSignerErr
+		}
+		if f.cloudCDNSigner == nil {
+			return nil, fmt.Errorf("%w: Cloud CDN", ErrCDNNotConfigured)
+		}
+		return []*http.Cookie{f.cloudCDNSigner.SignCookie(prefix, expiry, f.config.CDNCookieDomain)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown provider %q", provider)
+}
+
+// clientCIDR normalizes an IP address or CIDR range for a signed URL policy
+func clientCIDR(value string) (string, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", fmt.Errorf("%w: client IP %q", ErrInvalidRestriction, value)
+	}
+	return network.String(), nil
+}
+
+// cdnURL signs a CloudFront URL for an S3 object restricted by opts
+func (f *FileStorageManager) cdnURL(awsFileID string, expiry time.Time, opts LinkOptions) (string, error) {
+	if f.cdnSignerErr != nil {
+		return "", f.cdnSignerErr
+	}
+	if f.cdnSigner == nil {
+		return "", fmt.Errorf("%w: client IP requires a CloudFront distribution", ErrUnsupportedRestriction)
+	}
+
+	cidr, err := clientCIDR(opts.ClientIP)
+	if err != nil {
+		return "", err
+	}
+
+	return f.cdnSigner.SignURL(awsFileID, expiry, cidr, opts.responseQuery())
+}
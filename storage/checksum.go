@@ -0,0 +1,175 @@
+// pkg/storage/checksum.go
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// md5Hex returns the hex encoded MD5 of data
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sha256Hex returns the hex encoded SHA-256 of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hexToBase64 re-encodes a hex digest as base64, the form expected by S3 checksum headers
+func hexToBase64(digest string) string {
+	raw, err := hex.DecodeString(digest)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// checksumsBase64 returns the hex encoded MD5 and SHA-256 of base64 encoded
+// data without holding the decoded content in memory
+func checksumsBase64(base64file string) (string, string, error) {
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+
+	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(base64file))
+	if _, err := Copy(io.MultiWriter(md5Hash, sha256Hash), decoder); err != nil {
+		return "", "", err
+	}
+
+	return hex.EncodeToString(md5Hash.Sum(nil)), hex.EncodeToString(sha256Hash.Sum(nil)), nil
+}
+
+// VerifyFile re-reads a recorded file from its provider and checks its content
+// against the SHA-256 recorded at upload time
+func (f *FileStorageManager) VerifyFile(fileID string) (*FileResponse, error) {
+	if f.metadataStore == nil {
+		return nil, fmt.Errorf("metadata store not configured")
+	}
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if record.SHA256 == "" {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: "No checksum recorded for " + fileID,
+		}, nil
+	}
+
+	reader, contentEncoding, err := f.openRecordedFile(record)
+	if err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err := f.copyDecoded(hash, reader, contentEncoding); err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != record.SHA256 {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: fmt.Sprintf("Checksum mismatch for %s: expected %s, got %s", fileID, record.SHA256, actual),
+			FileID:  fileID,
+			Info:    record.FileInfo(),
+		}, nil
+	}
+
+	return &FileResponse{
+		Status:  StatusSuccess,
+		Message: "VERIFIED " + fileID,
+		FileID:  fileID,
+		Info:    record.FileInfo(),
+	}, nil
+}
+
+// openRecordedFile opens the stored content of a recorded file, returning it
+// together with its Content-Encoding
+func (f *FileStorageManager) openRecordedFile(record *FileRecord) (io.ReadCloser, string, error) {
+	switch record.Provider {
+	case ProviderAWS:
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return nil, "", err
+		}
+
+		result, err := s3Client.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(record.Bucket),
+			Key:    aws.String(record.FileID),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		return result.Body, aws.StringValue(result.ContentEncoding), nil
+
+	case ProviderGCS:
+		gcsClient, err := f.GetGcsClient("")
+		if err != nil {
+			return nil, "", err
+		}
+
+		reader, err := gcsClient.Bucket(record.Bucket).Object(record.FileID).ReadCompressed(true).NewReader(context.Background())
+		if err != nil {
+			gcsClient.Close()
+			return nil, "", err
+		}
+
+		return &closerFunc{Reader: reader, close: func() error {
+			reader.Close()
+			return gcsClient.Close()
+		}}, reader.Attrs.ContentEncoding, nil
+
+	case ProviderREST:
+		response, err := f.GetFileById(record.FileID)
+		if err != nil {
+			return nil, "", err
+		}
+		if response.Status != StatusSuccess {
+			return nil, "", fmt.Errorf("%s", response.Message)
+		}
+
+		data, err := base64.StdEncoding.DecodeString(response.Data)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return io.NopCloser(bytes.NewReader(data)), "", nil
+	}
+
+	return nil, "", fmt.Errorf("unknown provider %q", record.Provider)
+}
+
+// closerFunc attaches a custom Close to a reader
+type closerFunc struct {
+	io.Reader
+	close func() error
+}
+
+// Close runs the attached close function
+func (c *closerFunc) Close() error {
+	return c.close()
+}
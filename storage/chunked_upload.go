@@ -0,0 +1,675 @@
+// pkg/storage/chunked_upload.go
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxChunks is the highest chunk index accepted, matching the S3 part limit
+	MaxChunks = 10000
+
+	// MinChunkSize is the smallest size S3 accepts for every chunk but the last
+	MinChunkSize = 5 << 20
+
+	// chunkPrefix is the key prefix under which GCS chunks wait to be composed
+	chunkPrefix = "chunks/"
+
+	// maxComposeSources is the number of objects GCS composes in one request
+	maxComposeSources = 32
+)
+
+// ChunkedUpload is an upload session sent in numbered chunks, which can be
+// resumed by re-sending missing or failed chunks until it is completed,
+// aborted or expires
+type ChunkedUpload struct {
+	UploadID     string         `json:"upload_id"`
+	Provider     string         `json:"provider"`
+	Bucket       string         `json:"bucket"`
+	ProjectID    string         `json:"project_id,omitempty"`
+	FileID       string         `json:"file_id"`
+	FileName     string         `json:"file_name"`
+	FileExt      string         `json:"file_ext"`
+	MimeType     string         `json:"mime_type"`
+	ExpectedSize int64          `json:"expected_size,omitempty"` // Size the completed upload must have, 0 if unknown
+	Owner        string         `json:"owner,omitempty"`         // Subject owning the session and the completed file
+	MultipartID  string         `json:"multipart_id,omitempty"`  // S3 multipart upload ID
+	Chunks       map[int]*Chunk `json:"chunks"`
+	CreatedAt    time.Time      `json:"created_at"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+}
+
+// Chunk is a received chunk of a chunked upload
+type Chunk struct {
+	Index int    `json:"index"`
+	Size  int64  `json:"size"`
+	ETag  string `json:"etag"`
+}
+
+// InitChunkedUpload starts a chunked upload session for filename to an S3 or
+// GCS bucket and returns it with the upload ID used for the following calls.
+// The completed file is owned by owner. If size is known, the upload is rejected up front when it exceeds the
+// maximum upload size and cannot be completed with a different size. Sessions
+// not completed within the session TTL are aborted automatically.
+//
+// Chunks are stored as sent and assembled by the provider (S3 multipart or
+// GCS compose), so chunked uploads are neither compressed nor image
+// processed and get no thumbnails, previews or post-upload hooks. They are
+// not available with client-side encryption.
+func (f *FileStorageManager) InitChunkedUpload(provider, filename, mimeType string, size int64, subdirectory, owner, bucketname, projectID string) (*ChunkedUpload, error) {
+	if f.encryptor != nil || f.encryptionErr != nil {
+		return nil, fmt.Errorf("chunked uploads are not supported with client-side encryption")
+	}
+
+	if err := f.checkUploadSize(size); err != nil {
+		return nil, err
+	}
+
+	if err := f.fileFilter.Check(filename, mimeType); err != nil {
+		return nil, err
+	}
+
+	if err := validatePrefix(subdirectory); err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(filename)
+	extension := filepath.Ext(name)
+	name = name[:len(name)-len(extension)]
+	if extension != "" {
+		extension = extension[1:] // Remove the dot
+	}
+
+	ttl := f.sessionTTL
+	if ttl <= 0 {
+		ttl = DefaultUploadSessionTTL
+	}
+
+	now := time.Now()
+	upload := &ChunkedUpload{
+		UploadID:     uuid.New().String(),
+		Provider:     provider,
+		ProjectID:    projectID,
+		FileName:     name,
+		FileExt:      extension,
+		MimeType:     normalizeMimeType(mimeType),
+		ExpectedSize: size,
+		Owner:        owner,
+		Chunks:       map[int]*Chunk{},
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(ttl),
+	}
+	if upload.MimeType == "" {
+		upload.MimeType = "application/octet-stream"
+	}
+
+	keyPayload := &uploadPayload{filename: name, extension: extension}
+
+	switch provider {
+	case ProviderAWS:
+		if bucketname == "" {
+			bucketname = f.config.AWSBucket
+		}
+
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return nil, err
+		}
+
+		fileID, err := f.newObjectKey(subdirectory, keyPayload, func(key string) (bool, error) {
+			return awsObjectExists(aws.BackgroundContext(), s3Client, bucketname, key)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := s3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(bucketname),
+			Key:         aws.String(fileID),
+			ContentType: aws.String(upload.MimeType),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		upload.FileID = fileID
+		upload.MultipartID = aws.StringValue(result.UploadId)
+
+	case ProviderGCS:
+		if bucketname == "" {
+			bucketname = f.config.GCSBucket
+		}
+
+		gcsClient, err := f.GetGcsClient(projectID)
+		if err != nil {
+			return nil, err
+		}
+		defer gcsClient.Close()
+
+		ctx := context.Background()
+		bucket := gcsClient.Bucket(bucketname)
+
+		fileID, err := f.newObjectKey(subdirectory, keyPayload, func(key string) (bool, error) {
+			return gcsObjectExists(ctx, bucket, key)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		upload.FileID = fileID
+
+	default:
+		return nil, fmt.Errorf("chunked uploads are not supported for provider %q", provider)
+	}
+
+	upload.Bucket = bucketname
+
+	if err := f.sessionStore.SaveSession(upload); err != nil {
+		f.discardChunks(upload)
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// GetChunkedUpload returns a chunked upload in progress, e.g. to find the
+// chunks a resuming client still has to send
+func (f *FileStorageManager) GetChunkedUpload(uploadID string) (*ChunkedUpload, error) {
+	upload, err := f.sessionStore.GetSession(uploadID)
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, uploadID)
+		}
+		return nil, err
+	}
+
+	if upload.expired(time.Now()) {
+		return nil, fmt.Errorf("%w: %s", ErrUploadExpired, uploadID)
+	}
+
+	return upload, nil
+}
+
+// UploadChunk stores chunk index (1 to MaxChunks) of a chunked upload.
+// Sending a chunk again replaces it, so failed chunks can simply be retried.
+// Every chunk but the last must be at least MinChunkSize bytes.
+func (f *FileStorageManager) UploadChunk(uploadID string, index int, chunk io.Reader) (*Chunk, error) {
+	if index < 1 || index > MaxChunks {
+		return nil, fmt.Errorf("%w: index %d outside 1-%d", ErrInvalidChunk, index, MaxChunks)
+	}
+
+	upload, err := f.GetChunkedUpload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.storeChunk(upload, index, data)
+}
+
+// AppendChunkedUpload continues a chunked upload with data sent from offset,
+// which must be the upload's Offset, and returns the new offset. The data is
+// stored in chunks of MinChunkSize as it arrives, so when reading it fails
+// midway the chunks stored so far are kept and the upload can be resumed from
+// the returned offset. S3 uploads drop a shorter trailing chunk that does not
+// reach the expected size, rejecting it if the data was read to the end.
+func (f *FileStorageManager) AppendChunkedUpload(uploadID string, offset int64, data io.Reader) (int64, error) {
+	upload, err := f.GetChunkedUpload(uploadID)
+	if err != nil {
+		return 0, err
+	}
+
+	current, index := upload.received()
+	if offset != current {
+		return current, fmt.Errorf("%w: upload is at offset %d, not %d", ErrOffsetMismatch, current, offset)
+	}
+
+	buf := make([]byte, MinChunkSize)
+	for {
+		n, readErr := readChunk(data, buf)
+		if n == 0 {
+			if readErr == io.EOF {
+				readErr = nil
+			}
+			return offset, readErr
+		}
+
+		last := upload.ExpectedSize > 0 && offset+int64(n) >= upload.ExpectedSize
+		if n < len(buf) && !last && upload.Provider == ProviderAWS {
+			if readErr == io.EOF {
+				return offset, fmt.Errorf("%w: chunks before the last must be %d bytes", ErrInvalidChunk, MinChunkSize)
+			}
+			return offset, readErr
+		}
+
+		chunk, err := f.storeChunk(upload, index, buf[:n])
+		if err != nil {
+			return offset, err
+		}
+		upload.Chunks[index] = chunk
+		offset += chunk.Size
+		index++
+
+		if readErr == io.EOF {
+			return offset, nil
+		}
+		if readErr != nil {
+			return offset, readErr
+		}
+	}
+}
+
+// storeChunk sends chunk index of an upload to the provider and records it
+func (f *FileStorageManager) storeChunk(upload *ChunkedUpload, index int, data []byte) (*Chunk, error) {
+	if index > MaxChunks {
+		return nil, fmt.Errorf("%w: index %d outside 1-%d", ErrInvalidChunk, index, MaxChunks)
+	}
+
+	// Other chunks may be replaced concurrently, so this is a best effort check
+	// which CompleteChunkedUpload repeats
+	size := int64(len(data))
+	for i, c := range upload.Chunks {
+		if i != index {
+			size += c.Size
+		}
+	}
+	if err := f.checkUploadSize(size); err != nil {
+		return nil, err
+	}
+	if upload.ExpectedSize > 0 && size > upload.ExpectedSize {
+		return nil, fmt.Errorf("%w: chunks exceed the expected %d bytes", ErrInvalidChunk, upload.ExpectedSize)
+	}
+
+	// The first chunk carries the magic bytes the content type is detected from
+	if index == 1 {
+		if _, err := f.detectMimeType(upload.fullName(), upload.MimeType, sniffMimeType(data)); err != nil {
+			return nil, err
+		}
+	}
+
+	received := &Chunk{
+		Index: index,
+		Size:  int64(len(data)),
+	}
+
+	switch upload.Provider {
+	case ProviderAWS:
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return nil, err
+		}
+
+		req, result := s3Client.UploadPartRequest(&s3.UploadPartInput{
+			Bucket:        aws.String(upload.Bucket),
+			Key:           aws.String(upload.FileID),
+			UploadId:      aws.String(upload.MultipartID),
+			PartNumber:    aws.Int64(int64(index)),
+			Body:          f.throttle.ReadSeeker(bytes.NewReader(data)),
+			ContentLength: aws.Int64(int64(len(data))),
+			ContentMD5:    aws.String(hexToBase64(md5Hex(data))),
+		})
+		req.HTTPRequest.Header.Set("X-Amz-Content-Sha256", sha256Hex(data))
+		if err := req.Send(); err != nil {
+			return nil, err
+		}
+		received.ETag = aws.StringValue(result.ETag)
+
+	case ProviderGCS:
+		gcsClient, err := f.GetGcsClient(upload.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		defer gcsClient.Close()
+
+		wc := gcsClient.Bucket(upload.Bucket).Object(chunkKey(upload.UploadID, index)).NewWriter(context.Background())
+		wc.MD5, _ = hex.DecodeString(md5Hex(data))
+		if _, err := Copy(wc, f.throttle.Reader(bytes.NewReader(data))); err != nil {
+			wc.Close()
+			return nil, err
+		}
+		if err := wc.Close(); err != nil {
+			return nil, err
+		}
+		received.ETag = wc.Attrs().Etag
+	}
+
+	if err := f.sessionStore.AddChunk(upload.UploadID, received); err != nil {
+		return nil, err
+	}
+
+	return received, nil
+}
+
+// CompleteChunkedUpload finalizes a chunked upload, assembling its chunks into
+// the final object. Chunks must have been received for every index from 1 to
+// the highest one sent, adding up to the expected size if one was given. The
+// assembled object is read back once to detect its content type, compute its
+// checksums and scan it for malware.
+func (f *FileStorageManager) CompleteChunkedUpload(uploadID string) (*FileResponse, error) {
+	upload, err := f.GetChunkedUpload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, size, err := upload.orderedChunks()
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkUploadSize(size); err != nil {
+		return nil, err
+	}
+	if upload.ExpectedSize > 0 && size != upload.ExpectedSize {
+		return nil, fmt.Errorf("%w: received %d of %d bytes", ErrIncompleteUpload, size, upload.ExpectedSize)
+	}
+
+	var open func() (io.ReadCloser, error)
+	var remove func()
+	var publicURL string
+
+	switch upload.Provider {
+	case ProviderAWS:
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return &FileResponse{
+				Status:  StatusError,
+				Message: err.Error(),
+			}, nil
+		}
+
+		parts := make([]*s3.CompletedPart, len(chunks))
+		for i, chunk := range chunks {
+			parts[i] = &s3.CompletedPart{
+				ETag:       aws.String(chunk.ETag),
+				PartNumber: aws.Int64(int64(chunk.Index)),
+			}
+		}
+
+		_, err = s3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(upload.Bucket),
+			Key:             aws.String(upload.FileID),
+			UploadId:        aws.String(upload.MultipartID),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+		if err != nil {
+			return &FileResponse{
+				Status:  StatusError,
+				Message: err.Error(),
+			}, nil
+		}
+
+		open = func() (io.ReadCloser, error) {
+			result, err := s3Client.GetObject(&s3.GetObjectInput{
+				Bucket: aws.String(upload.Bucket),
+				Key:    aws.String(upload.FileID),
+			})
+			if err != nil {
+				return nil, err
+			}
+			return result.Body, nil
+		}
+		remove = func() {
+			s3Client.DeleteObject(&s3.DeleteObjectInput{
+				Bucket: aws.String(upload.Bucket),
+				Key:    aws.String(upload.FileID),
+			})
+		}
+		publicURL = f.awsPublicURL(upload.Bucket, upload.FileID)
+
+	case ProviderGCS:
+		ctx := context.Background()
+
+		gcsClient, err := f.GetGcsClient(upload.ProjectID)
+		if err != nil {
+			return &FileResponse{
+				Status:  StatusError,
+				Message: err.Error(),
+			}, nil
+		}
+		defer gcsClient.Close()
+
+		bucket := gcsClient.Bucket(upload.Bucket)
+		if err := composeChunks(ctx, bucket, upload, chunks); err != nil {
+			return &FileResponse{
+				Status:  StatusError,
+				Message: err.Error(),
+			}, nil
+		}
+		deleteChunks(ctx, bucket, uploadID, chunks)
+
+		obj := bucket.Object(upload.FileID)
+		open = func() (io.ReadCloser, error) {
+			return obj.NewReader(ctx)
+		}
+		remove = func() {
+			obj.Delete(ctx)
+		}
+		publicURL = gcsPublicURL(upload.Bucket, upload.FileID)
+	}
+
+	// The chunks are gone from the provider, so the upload cannot be resumed anymore
+	f.sessionStore.DeleteSession(uploadID)
+
+	mimeType, md5sum, sha256sum, scan, err := f.inspectAssembled(upload.fullName(), upload.MimeType, open)
+	if err != nil {
+		remove()
+		return nil, err
+	}
+
+	fileInfo := &FileInfo{
+		FileExt:      upload.FileExt,
+		FileID:       upload.FileID,
+		FileMimeType: mimeType,
+		FileName:     upload.FileName,
+		FileSize:     size,
+		PublicLink:   publicURL,
+		Timestamp:    time.Now(),
+		Bucket:       upload.Bucket,
+		MD5:          md5sum,
+		SHA256:       sha256sum,
+		Owner:        upload.Owner,
+	}
+
+	scan.applyTo(fileInfo)
+	f.recordUpload(upload.Provider, upload.Bucket, fileInfo, "")
+
+	return &FileResponse{
+		Status:  StatusSuccess,
+		Message: "INSERT " + upload.FileID,
+		FileID:  upload.FileID,
+		Info:    fileInfo,
+	}, nil
+}
+
+// AbortChunkedUpload cancels a chunked upload and removes the chunks received so far
+func (f *FileStorageManager) AbortChunkedUpload(uploadID string) error {
+	upload, err := f.sessionStore.GetSession(uploadID)
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			return fmt.Errorf("%w: %s", ErrUploadNotFound, uploadID)
+		}
+		return err
+	}
+
+	if err := f.discardChunks(upload); err != nil {
+		return err
+	}
+
+	return f.sessionStore.DeleteSession(uploadID)
+}
+
+// inspectAssembled reads an upload stored by the provider once, detecting its
+// content type and computing its checksums while the malware scanner reads it
+func (f *FileStorageManager) inspectAssembled(name, claimedType string, open func() (io.ReadCloser, error)) (mimeType, md5sum, sha256sum string, scan *ScanResult, err error) {
+	reader, err := open()
+	if err != nil {
+		return "", "", "", nil, err
+	}
+	defer reader.Close()
+
+	buffered := bufio.NewReaderSize(f.throttle.Reader(reader), sniffLength)
+	head, err := buffered.Peek(sniffLength)
+	if err != nil && err != io.EOF {
+		return "", "", "", nil, err
+	}
+
+	mimeType, err = f.detectMimeType(name, claimedType, sniffMimeType(head))
+	if err != nil {
+		return "", "", "", nil, err
+	}
+
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	tee := io.TeeReader(buffered, io.MultiWriter(md5Hash, sha256Hash))
+
+	scan, err = f.scanUpload(tee)
+	if err != nil {
+		return "", "", "", nil, err
+	}
+
+	// The scanner may stop reading early, or not read at all
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+		return "", "", "", nil, err
+	}
+
+	return mimeType, hex.EncodeToString(md5Hash.Sum(nil)), hex.EncodeToString(sha256Hash.Sum(nil)), scan, nil
+}
+
+// orderedChunks returns the chunks sorted by index and their total size,
+// failing if any index up to the highest one is missing
+func (u *ChunkedUpload) orderedChunks() ([]*Chunk, int64, error) {
+	if len(u.Chunks) == 0 {
+		return nil, 0, fmt.Errorf("%w: no chunks received", ErrIncompleteUpload)
+	}
+
+	chunks := make([]*Chunk, 0, len(u.Chunks))
+	var size int64
+	for _, chunk := range u.Chunks {
+		chunks = append(chunks, chunk)
+		size += chunk.Size
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Index < chunks[j].Index })
+
+	for i, chunk := range chunks {
+		if chunk.Index != i+1 {
+			return nil, 0, fmt.Errorf("%w: chunk %d missing", ErrIncompleteUpload, i+1)
+		}
+	}
+
+	return chunks, size, nil
+}
+
+// Offset returns the number of bytes received in order, which is where an
+// upload sent with AppendChunkedUpload continues
+func (u *ChunkedUpload) Offset() int64 {
+	offset, _ := u.received()
+	return offset
+}
+
+// received returns the size of the chunks received from index 1 up to the
+// first one missing, and the index of that chunk
+func (u *ChunkedUpload) received() (int64, int) {
+	var offset int64
+	index := 1
+	for ; u.Chunks[index] != nil; index++ {
+		offset += u.Chunks[index].Size
+	}
+	return offset, index
+}
+
+// expired reports whether the upload session is past its expiry
+func (u *ChunkedUpload) expired(now time.Time) bool {
+	return !u.ExpiresAt.IsZero() && now.After(u.ExpiresAt)
+}
+
+// fullName returns the original filename including the extension
+func (u *ChunkedUpload) fullName() string {
+	if u.FileExt == "" {
+		return u.FileName
+	}
+	return u.FileName + "." + u.FileExt
+}
+
+// copy returns a snapshot of the upload that is safe to use without the lock
+func (u *ChunkedUpload) copy() *ChunkedUpload {
+	snapshot := *u
+	snapshot.Chunks = make(map[int]*Chunk, len(u.Chunks))
+	for index, chunk := range u.Chunks {
+		c := *chunk
+		snapshot.Chunks[index] = &c
+	}
+	return &snapshot
+}
+
+// readChunk fills buf from r, returning fewer bytes only with the error that
+// stopped reading, io.EOF at the end of r
+func readChunk(r io.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// chunkKey returns the object key of a GCS chunk waiting to be composed
+func chunkKey(uploadID string, index int) string {
+	return chunkPrefix + uploadID + "/" + strconv.Itoa(index)
+}
+
+// composeChunks composes the chunks into the final object. GCS composes at
+// most maxComposeSources objects at once, so larger uploads are appended to
+// the object in several rounds.
+func composeChunks(ctx context.Context, bucket *storage.BucketHandle, upload *ChunkedUpload, chunks []*Chunk) error {
+	target := bucket.Object(upload.FileID)
+
+	var sources []*storage.ObjectHandle
+	for i := 0; i < len(chunks); {
+		if i > 0 {
+			sources = []*storage.ObjectHandle{target}
+		}
+		for ; i < len(chunks) && len(sources) < maxComposeSources; i++ {
+			sources = append(sources, bucket.Object(chunkKey(upload.UploadID, chunks[i].Index)))
+		}
+
+		composer := target.ComposerFrom(sources...)
+		composer.ContentType = upload.MimeType
+		if _, err := composer.Run(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteChunks removes the chunk objects of a GCS upload
+func deleteChunks(ctx context.Context, bucket *storage.BucketHandle, uploadID string, chunks []*Chunk) {
+	for _, chunk := range chunks {
+		bucket.Object(chunkKey(uploadID, chunk.Index)).Delete(ctx)
+	}
+}
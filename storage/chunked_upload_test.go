@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChunkedUploadOwner(t *testing.T) {
+	f, _, store := newFakeS3Manager(t, map[string]int64{})
+
+	upload, err := f.InitChunkedUpload(ProviderAWS, "notes.txt", "text/plain", 5, "", "student-1", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.AppendChunkedUpload(upload.UploadID, 0, strings.NewReader("notes")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := f.CompleteChunkedUpload(upload.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != StatusSuccess {
+		t.Fatalf("CompleteChunkedUpload() = %+v", result)
+	}
+
+	record, err := store.GetFile(upload.FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Owner != "student-1" {
+		t.Errorf("record owner = %q, want student-1", record.Owner)
+	}
+}
+
+func TestChunkedUploadPrefix(t *testing.T) {
+	f, fake, _ := newFakeS3Manager(t, map[string]int64{})
+
+	for _, prefix := range []string{"../theses", ".trash"} {
+		if _, err := f.InitChunkedUpload(ProviderAWS, "notes.txt", "text/plain", 5, prefix, "student-1", "", ""); !errors.Is(err, ErrInvalidPrefix) {
+			t.Errorf("prefix %q: error = %v, want ErrInvalidPrefix", prefix, err)
+		}
+	}
+	if len(fake.requests) > 0 {
+		t.Errorf("requests = %q, want none", fake.requests)
+	}
+}
@@ -0,0 +1,177 @@
+// pkg/storage/compression.go
+
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// minCompressSize is the smallest upload worth compressing
+const minCompressSize = 1024
+
+// DefaultCompressibleTypes lists the content types compressed when no list is configured
+var DefaultCompressibleTypes = []string{
+	"text/*",
+	"application/json",
+	"application/xml",
+	"application/javascript",
+	"application/csv",
+	"application/x-ndjson",
+	"application/x-yaml",
+	"image/svg+xml",
+}
+
+// Compressor compresses upload content for a Content-Encoding
+type Compressor interface {
+	// Encoding returns the Content-Encoding value, e.g. "gzip"
+	Encoding() string
+	Compress(data []byte) ([]byte, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCompressor implements Compressor with gzip
+type GzipCompressor struct {
+	Level int
+}
+
+// NewGzipCompressor creates a gzip compressor with the default compression level
+func NewGzipCompressor() *GzipCompressor {
+	return &GzipCompressor{
+		Level: gzip.DefaultCompression,
+	}
+}
+
+// Encoding returns "gzip"
+func (g *GzipCompressor) Encoding() string {
+	return "gzip"
+}
+
+// Compress gzips data
+func (g *GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := gzip.NewWriterLevel(&buf, g.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// NewReader returns a gzip decompressing reader
+func (g *GzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// NewCompressorFromConfig creates the configured compressor, returning nil when compression is disabled
+func NewCompressorFromConfig(config *Config) (Compressor, error) {
+	switch config.Compression {
+	case "":
+		return nil, nil
+	case "gzip":
+		return NewGzipCompressor(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported compression %q", config.Compression)
+}
+
+// SetCompressor enables transparent compression of compressible S3 and GCS
+// uploads, nil disables it. Additional encodings such as zstd can be plugged
+// in by implementing Compressor.
+func (f *FileStorageManager) SetCompressor(compressor Compressor) {
+	f.compressor = compressor
+	f.compressionErr = nil
+}
+
+// compressPayload compresses the payload content when compression is enabled
+// for its content type and actually makes it smaller
+func (f *FileStorageManager) compressPayload(payload *uploadPayload) error {
+	if f.compressionErr != nil {
+		return f.compressionErr
+	}
+	if f.compressor == nil || len(payload.data) < minCompressSize {
+		return nil
+	}
+
+	compressibleTypes := f.config.CompressibleTypes
+	if compressibleTypes == nil {
+		compressibleTypes = DefaultCompressibleTypes
+	}
+	if !matchMimeType(compressibleTypes, payload.mimeType) {
+		return nil
+	}
+
+	data, err := f.compressor.Compress(payload.data)
+	if err != nil {
+		return err
+	}
+	if len(data) >= len(payload.data) {
+		return nil
+	}
+
+	payload.data = data
+	payload.contentEncoding = f.compressor.Encoding()
+	return nil
+}
+
+// decompressReader wraps r with decompression for a stored Content-Encoding
+func (f *FileStorageManager) decompressReader(r io.Reader, contentEncoding string) (io.Reader, error) {
+	if contentEncoding == "" || contentEncoding == "identity" {
+		return r, nil
+	}
+
+	if f.compressor != nil && f.compressor.Encoding() == contentEncoding {
+		return f.compressor.NewReader(r)
+	}
+	if contentEncoding == "gzip" {
+		return gzip.NewReader(r)
+	}
+
+	// Unknown encodings are passed through untouched
+	return r, nil
+}
+
+// decodeReader undoes encryption and compression of a download stream
+func (f *FileStorageManager) decodeReader(r io.Reader, contentEncoding string) (io.Reader, error) {
+	reader, err := f.decryptReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return f.decompressReader(reader, contentEncoding)
+}
+
+// decodeData undoes encryption and compression of downloaded content
+func (f *FileStorageManager) decodeData(data []byte, contentEncoding string) ([]byte, error) {
+	data, err := f.decryptData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentEncoding == "" || contentEncoding == "identity" {
+		return data, nil
+	}
+
+	reader, err := f.decompressReader(bytes.NewReader(data), contentEncoding)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// copyDecoded copies a download stream to dst, undoing encryption and compression
+func (f *FileStorageManager) copyDecoded(dst io.Writer, src io.Reader, contentEncoding string) (int64, error) {
+	reader, err := f.decodeReader(src, contentEncoding)
+	if err != nil {
+		return 0, err
+	}
+	return Copy(dst, reader)
+}
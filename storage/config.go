@@ -3,7 +3,12 @@
 package storage
 
 import (
+	"encoding/base64"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -32,5 +37,438 @@ func LoadConfig() (*Config, error) {
 		GCSBucket:    os.Getenv("GOOGLE_BUCKET"),
 	}
 
+	// Upload limits
+	maxUploadSize, err := getEnvInt64("FILE_STORAGE_MAX_UPLOAD_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	config.MaxUploadSize = maxUploadSize
+
+	routeMaxUploadSizes, err := getEnvSizeMap("FILE_STORAGE_ROUTE_MAX_UPLOAD_SIZES")
+	if err != nil {
+		return nil, err
+	}
+	config.RouteMaxUploadSizes = routeMaxUploadSizes
+
+	uploadMemoryLimit, err := getEnvInt64("FILE_STORAGE_UPLOAD_MEMORY_LIMIT")
+	if err != nil {
+		return nil, err
+	}
+	config.UploadMemoryLimit = uploadMemoryLimit
+	config.UploadStagingDir = os.Getenv("FILE_STORAGE_UPLOAD_STAGING_DIR")
+
+	// File type filters
+	config.AllowedMimeTypes = getEnvList("FILE_STORAGE_ALLOWED_MIME_TYPES")
+	config.DeniedMimeTypes = getEnvList("FILE_STORAGE_DENIED_MIME_TYPES")
+	config.AllowedExtensions = getEnvList("FILE_STORAGE_ALLOWED_EXTENSIONS")
+	config.DeniedExtensions = getEnvList("FILE_STORAGE_DENIED_EXTENSIONS")
+
+	rejectMimeMismatch, err := getEnvBool("FILE_STORAGE_REJECT_MIME_MISMATCH")
+	if err != nil {
+		return nil, err
+	}
+	config.RejectMimeMismatch = rejectMimeMismatch
+
+	preserveFilenames, err := getEnvBool("FILE_STORAGE_PRESERVE_FILENAMES")
+	if err != nil {
+		return nil, err
+	}
+	config.PreserveFilenames = preserveFilenames
+
+	disableDeduplication, err := getEnvBool("FILE_STORAGE_DISABLE_DEDUPLICATION")
+	if err != nil {
+		return nil, err
+	}
+	config.DisableDeduplication = disableDeduplication
+
+	fileVersioning, err := getEnvBool("FILE_STORAGE_VERSIONING")
+	if err != nil {
+		return nil, err
+	}
+	config.FileVersioning = fileVersioning
+
+	// Client-side encryption
+	if encodedKey := os.Getenv("FILE_STORAGE_ENCRYPTION_KEY"); encodedKey != "" {
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(key) != dataKeySize {
+			return nil, fmt.Errorf("invalid FILE_STORAGE_ENCRYPTION_KEY: must be %d base64 encoded bytes", dataKeySize)
+		}
+		config.EncryptionKey = key
+	}
+	config.EncryptionKMSKeyID = os.Getenv("FILE_STORAGE_ENCRYPTION_KMS_KEY_ID")
+
+	// Transparent compression
+	config.Compression = os.Getenv("FILE_STORAGE_COMPRESSION")
+	config.CompressibleTypes = getEnvList("FILE_STORAGE_COMPRESSIBLE_TYPES")
+
+	// Thumbnails
+	thumbnailSizes, err := getEnvIntList("FILE_STORAGE_THUMBNAIL_SIZES")
+	if err != nil {
+		return nil, err
+	}
+	config.ThumbnailSizes = thumbnailSizes
+
+	// Image processing
+	imageMaxWidth, err := getEnvInt64("FILE_STORAGE_IMAGE_MAX_WIDTH")
+	if err != nil {
+		return nil, err
+	}
+	config.ImageMaxWidth = int(imageMaxWidth)
+
+	imageMaxHeight, err := getEnvInt64("FILE_STORAGE_IMAGE_MAX_HEIGHT")
+	if err != nil {
+		return nil, err
+	}
+	config.ImageMaxHeight = int(imageMaxHeight)
+
+	imageQuality, err := getEnvInt64("FILE_STORAGE_IMAGE_QUALITY")
+	if err != nil {
+		return nil, err
+	}
+	config.ImageQuality = int(imageQuality)
+
+	config.ImageFormat = os.Getenv("FILE_STORAGE_IMAGE_FORMAT")
+
+	stripImageMetadata, err := getEnvBool("FILE_STORAGE_STRIP_IMAGE_METADATA")
+	if err != nil {
+		return nil, err
+	}
+	config.StripImageMetadata = stripImageMetadata
+
+	// Document previews
+	previewSize, err := getEnvInt64("FILE_STORAGE_PREVIEW_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	config.PreviewSize = int(previewSize)
+
+	// Video transcoding
+	videoTranscoding, err := getEnvBool("FILE_STORAGE_VIDEO_TRANSCODING")
+	if err != nil {
+		return nil, err
+	}
+	config.VideoTranscoding = videoTranscoding
+
+	videoHLS, err := getEnvBool("FILE_STORAGE_VIDEO_HLS")
+	if err != nil {
+		return nil, err
+	}
+	config.VideoHLS = videoHLS
+
+	// Archive extraction
+	archiveMaxEntries, err := getEnvInt64("FILE_STORAGE_ARCHIVE_MAX_ENTRIES")
+	if err != nil {
+		return nil, err
+	}
+	config.ArchiveMaxEntries = int(archiveMaxEntries)
+
+	archiveMaxEntrySize, err := getEnvInt64("FILE_STORAGE_ARCHIVE_MAX_ENTRY_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	config.ArchiveMaxEntrySize = archiveMaxEntrySize
+
+	archiveMaxTotalSize, err := getEnvInt64("FILE_STORAGE_ARCHIVE_MAX_TOTAL_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	config.ArchiveMaxTotalSize = archiveMaxTotalSize
+
+	// Multi-file uploads
+	uploadConcurrency, err := getEnvInt64("FILE_STORAGE_UPLOAD_CONCURRENCY")
+	if err != nil {
+		return nil, err
+	}
+	config.UploadConcurrency = int(uploadConcurrency)
+
+	// Batch deletes and lookups
+	batchConcurrency, err := getEnvInt64("FILE_STORAGE_BATCH_CONCURRENCY")
+	if err != nil {
+		return nil, err
+	}
+	config.BatchConcurrency = int(batchConcurrency)
+
+	// Parallel downloads
+	downloadConcurrency, err := getEnvInt64("FILE_STORAGE_DOWNLOAD_CONCURRENCY")
+	if err != nil {
+		return nil, err
+	}
+	config.DownloadConcurrency = int(downloadConcurrency)
+
+	downloadPartSize, err := getEnvInt64("FILE_STORAGE_DOWNLOAD_PART_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	config.DownloadPartSize = downloadPartSize
+
+	// Composite GCS uploads
+	gcsCompositeThreshold, err := getEnvInt64("FILE_STORAGE_GCS_COMPOSITE_THRESHOLD")
+	if err != nil {
+		return nil, err
+	}
+	config.GCSCompositeThreshold = gcsCompositeThreshold
+
+	gcsCompositeConcurrency, err := getEnvInt64("FILE_STORAGE_GCS_COMPOSITE_CONCURRENCY")
+	if err != nil {
+		return nil, err
+	}
+	config.GCSCompositeConcurrency = int(gcsCompositeConcurrency)
+
+	gcsCompositePartSize, err := getEnvInt64("FILE_STORAGE_GCS_COMPOSITE_PART_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	config.GCSCompositePartSize = gcsCompositePartSize
+
+	// Read-ahead of downloads and archives
+	readAheadWindow, err := getEnvInt64("FILE_STORAGE_READ_AHEAD_WINDOW")
+	if err != nil {
+		return nil, err
+	}
+	config.ReadAheadWindow = readAheadWindow
+
+	// Bandwidth throttling
+	bandwidthLimit, err := getEnvInt64("FILE_STORAGE_BANDWIDTH_LIMIT")
+	if err != nil {
+		return nil, err
+	}
+	config.BandwidthLimit = bandwidthLimit
+
+	transferBandwidthLimit, err := getEnvInt64("FILE_STORAGE_TRANSFER_BANDWIDTH_LIMIT")
+	if err != nil {
+		return nil, err
+	}
+	config.TransferBandwidthLimit = transferBandwidthLimit
+
+	// Operations in flight per provider
+	providerConcurrency, err := ParseProviderLimits(os.Getenv("FILE_STORAGE_PROVIDER_CONCURRENCY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FILE_STORAGE_PROVIDER_CONCURRENCY: %v", err)
+	}
+	config.ProviderConcurrency = providerConcurrency
+
+	providerQueueTimeout, err := getEnvDuration("FILE_STORAGE_PROVIDER_QUEUE_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	config.ProviderQueueTimeout = providerQueueTimeout
+
+	// Memory budget of buffered uploads
+	memoryBudget, err := getEnvInt64("FILE_STORAGE_MEMORY_BUDGET")
+	if err != nil {
+		return nil, err
+	}
+	config.MemoryBudget = memoryBudget
+
+	memoryQueueTimeout, err := getEnvDuration("FILE_STORAGE_MEMORY_QUEUE_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	config.MemoryQueueTimeout = memoryQueueTimeout
+
+	// Hedged reads per provider
+	hedgeReads, err := ParseHedgeQuantiles(os.Getenv("FILE_STORAGE_HEDGE_READS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FILE_STORAGE_HEDGE_READS: %v", err)
+	}
+	config.HedgeReads = hedgeReads
+
+	// Chunked upload sessions
+	uploadSessionTTL, err := getEnvDuration("FILE_STORAGE_UPLOAD_SESSION_TTL")
+	if err != nil {
+		return nil, err
+	}
+	config.UploadSessionTTL = uploadSessionTTL
+
+	// Soft delete
+	trashRetention, err := getEnvDuration("FILE_STORAGE_TRASH_RETENTION")
+	if err != nil {
+		return nil, err
+	}
+	config.TrashRetention = trashRetention
+
+	// Reconciliation of the buckets with the metadata store
+	reconcileInterval, err := getEnvDuration("FILE_STORAGE_RECONCILE_INTERVAL")
+	if err != nil {
+		return nil, err
+	}
+	config.ReconcileInterval = reconcileInterval
+
+	reconcileClean, err := getEnvBool("FILE_STORAGE_RECONCILE_CLEAN")
+	if err != nil {
+		return nil, err
+	}
+	config.ReconcileClean = reconcileClean
+
+	// Monthly tenant reports
+	config.TenantReportProvider = os.Getenv("FILE_STORAGE_TENANT_REPORT_PROVIDER")
+
+	// Scheduled maintenance jobs
+	jobIntervals, err := ParseJobSchedule(os.Getenv("FILE_STORAGE_JOB_INTERVALS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FILE_STORAGE_JOB_INTERVALS: %v", err)
+	}
+	config.JobIntervals = jobIntervals
+	config.DisabledJobs = getEnvList("FILE_STORAGE_DISABLED_JOBS")
+
+	// File expiry by prefix
+	expiryPolicies, err := ParseExpiryPolicies(os.Getenv("FILE_STORAGE_EXPIRY_POLICIES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FILE_STORAGE_EXPIRY_POLICIES: %v", err)
+	}
+	config.ExpiryPolicies = expiryPolicies
+
+	// Custom file attributes
+	attributeSchema, err := ParseAttributeSchema(os.Getenv("FILE_STORAGE_ATTRIBUTE_SCHEMA"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FILE_STORAGE_ATTRIBUTE_SCHEMA: %v", err)
+	}
+	config.AttributeSchema = attributeSchema
+
+	// Embedded metadata store
+	config.MetadataPath = os.Getenv("FILE_STORAGE_METADATA_PATH")
+
+	// Access audit log
+	config.AuditLogPath = os.Getenv("FILE_STORAGE_AUDIT_LOG")
+
+	// Background jobs
+	jobWorkers, err := getEnvInt64("FILE_STORAGE_JOB_WORKERS")
+	if err != nil {
+		return nil, err
+	}
+	config.JobWorkers = int(jobWorkers)
+
+	jobQueueSize, err := getEnvInt64("FILE_STORAGE_JOB_QUEUE_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	config.JobQueueSize = int(jobQueueSize)
+
+	// CloudFront signed URLs
+	config.CloudFrontDomain = os.Getenv("FILE_STORAGE_CLOUDFRONT_DOMAIN")
+	config.CloudFrontKeyPairID = os.Getenv("FILE_STORAGE_CLOUDFRONT_KEY_PAIR_ID")
+	config.CloudFrontPrivateKeyPath = os.Getenv("FILE_STORAGE_CLOUDFRONT_PRIVATE_KEY_PATH")
+
+	// Cloud CDN signed cookies
+	config.CloudCDNDomain = os.Getenv("FILE_STORAGE_CLOUD_CDN_DOMAIN")
+	config.CloudCDNKeyName = os.Getenv("FILE_STORAGE_CLOUD_CDN_KEY_NAME")
+	if encodedKey := os.Getenv("FILE_STORAGE_CLOUD_CDN_KEY"); encodedKey != "" {
+		key, err := base64.URLEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FILE_STORAGE_CLOUD_CDN_KEY: must be base64url encoded")
+		}
+		config.CloudCDNKey = key
+	}
+	config.CDNCookieDomain = os.Getenv("FILE_STORAGE_CDN_COOKIE_DOMAIN")
+
+	// Malware scanning
+	config.ClamAVAddress = os.Getenv("FILE_STORAGE_CLAMAV_ADDRESS")
+	config.ScanAction = os.Getenv("FILE_STORAGE_SCAN_ACTION")
+	if config.ScanAction != "" && config.ScanAction != ScanActionReject && config.ScanAction != ScanActionFlag {
+		return nil, fmt.Errorf("invalid FILE_STORAGE_SCAN_ACTION: %q", config.ScanAction)
+	}
+
 	return config, nil
 }
+
+// getEnvInt64 reads an integer environment variable, returning 0 when it is unset
+func getEnvInt64(key string) (int64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+
+	return n, nil
+}
+
+// getEnvDuration reads a duration environment variable such as "12h", returning 0 when it is unset
+func getEnvDuration(key string) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+
+	return d, nil
+}
+
+// getEnvBool reads a boolean environment variable, returning false when it is unset
+func getEnvBool(key string) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %v", key, err)
+	}
+
+	return b, nil
+}
+
+// getEnvList reads a comma separated environment variable, returning nil when it is unset
+func getEnvList(key string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// getEnvIntList reads a comma separated list of positive integers, returning nil when it is unset
+func getEnvIntList(key string) ([]int, error) {
+	var list []int
+	for _, item := range getEnvList(key) {
+		n, err := strconv.Atoi(item)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s entry: %q", key, item)
+		}
+		list = append(list, n)
+	}
+
+	return list, nil
+}
+
+// getEnvSizeMap reads a comma separated list of key=size pairs,
+// e.g. "/gcs/upload=10485760,/s3/upload=5242880"
+func getEnvSizeMap(key string) (map[string]int64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	sizes := make(map[string]int64)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s entry: %q", key, pair)
+		}
+
+		n, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry: %q", key, pair)
+		}
+
+		sizes[strings.TrimSpace(parts[0])] = n
+	}
+
+	return sizes, nil
+}
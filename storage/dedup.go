@@ -0,0 +1,191 @@
+// pkg/storage/dedup.go
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// reuseDuplicate looks up an already stored file with the same content, owner
+// and stored form as an upload to a provider bucket, and adds a reference to
+// it for the upload. It returns nil when deduplication is disabled, no
+// metadata store is configured, the upload names its key or replaces a file,
+// or no match exists. Uploads without an owner only match files without one,
+// which are open to everyone anyway, so uploaders are never handed a file
+// they may not access.
+//
+// Deduplicated uploads share a single object, which is only deleted once
+// every upload referencing it was deleted, see dropReference.
+func (f *FileStorageManager) reuseDuplicate(provider, bucket string, payload *uploadPayload) *FileRecord {
+	opts := payload.options
+	if f.metadataStore == nil || !f.deduplicate || payload.sha256 == "" || opts.Namer != nil || opts.replace {
+		return nil
+	}
+
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	records, err := f.metadataStore.FindByHash(provider, bucket, payload.sha256)
+	if err != nil {
+		return nil
+	}
+
+	variant := storedVariant(payload)
+	for _, record := range records {
+		if inTrash(record.FileID) || inArchive(record.FileID) || inVersions(record.FileID) || f.deleting[record.FileID] ||
+			record.Owner != opts.Owner || record.Variant != variant || record.UploadStatus == UploadPending {
+			continue
+		}
+
+		record.References = max(record.References, 1) + 1
+		if f.saveRecord(record) != nil {
+			return nil
+		}
+		return record
+	}
+
+	return nil
+}
+
+// dropReference removes a reference to a deduplicated file on its deletion.
+// It reports whether other uploads still reference the file, whose object
+// is then kept. Otherwise the file is not handed out to uploads of the same
+// content until done is called, once its object and record were deleted.
+func (f *FileStorageManager) dropReference(fileID string) (shared bool, done func(), err error) {
+	if f.metadataStore == nil {
+		return false, func() {}, nil
+	}
+
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	if record, err := f.metadataStore.GetFile(fileID); err == nil && record.References > 1 {
+		record.References--
+		if err := f.saveRecord(record); err != nil {
+			return false, nil, err
+		}
+		return true, nil, nil
+	}
+
+	f.deleting[fileID] = true
+	return false, func() {
+		f.recordLock.Lock()
+		defer f.recordLock.Unlock()
+
+		delete(f.deleting, fileID)
+	}, nil
+}
+
+// storedVariant fingerprints how the content of an upload is stored: its key
+// prefix and the upload options and encodings that change the stored object
+// or its record. Only uploads of the same variant share an object.
+func storedVariant(payload *uploadPayload) string {
+	opts := payload.options
+	encoded, _ := json.Marshal(struct {
+		Prefix          string
+		MimeType        string
+		ContentEncoding string
+		Encrypted       bool
+		Metadata        map[string]string
+		ACL             string
+		StorageClass    string
+		Headers         ObjectHeaders
+		ExpiresAt       time.Time
+		Attributes      Attributes
+	}{
+		Prefix:          strings.Trim(opts.Prefix, "/"),
+		MimeType:        payload.mimeType,
+		ContentEncoding: payload.contentEncoding,
+		Encrypted:       payload.encrypted,
+		Metadata:        opts.Metadata,
+		ACL:             opts.ACL,
+		StorageClass:    opts.StorageClass,
+		Headers:         opts.Headers,
+		ExpiresAt:       opts.ExpiresAt,
+		Attributes:      opts.Attributes,
+	})
+
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// duplicateResponse builds the upload response returned for a deduplicated file
+func duplicateResponse(record *FileRecord) *FileResponse {
+	return &FileResponse{
+		Status:  StatusSuccess,
+		Message: "EXISTS " + record.FileID,
+		FileID:  record.FileID,
+		Info:    record.FileInfo(),
+	}
+}
+
+// referenceResponse builds the delete response for a file whose reference
+// was dropped while other uploads still share it
+func referenceResponse(fileID string, err error) *FileResponse {
+	if err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}
+	}
+	return &FileResponse{
+		Status:  StatusSuccess,
+		Message: "DELETE " + fileID,
+	}
+}
+
+// recordUpload stores the metadata of a successful upload, with the stored
+// variant of its content when it may be deduplicated. Failures are not
+// reported to the caller since the object itself has been stored; the file
+// just will not be found for deduplication or verification.
+func (f *FileStorageManager) recordUpload(provider, bucket string, info *FileInfo, variant string) {
+	if f.metadataStore == nil || info == nil {
+		return
+	}
+
+	createdAt := info.Timestamp
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	record := &FileRecord{
+		FileID:        info.FileID,
+		Provider:      provider,
+		Bucket:        bucket,
+		FileName:      info.FileName,
+		FileExt:       info.FileExt,
+		MimeType:      info.FileMimeType,
+		FileSize:      info.FileSize,
+		MD5:           info.MD5,
+		SHA256:        info.SHA256,
+		PublicLink:    info.PublicLink,
+		Thumbnails:    info.Thumbnails,
+		ScanStatus:    info.ScanStatus,
+		ScanSignature: info.ScanSignature,
+		PreviewLink:   info.PreviewLink,
+		Owner:         info.Owner,
+		ExpiresAt:     info.ExpiresAt,
+		Attributes:    info.Attributes,
+		Variant:       variant,
+		CreatedAt:     createdAt,
+	}
+
+	// A file replaced under its ID stays accessible to the same users
+	if previous, err := f.metadataStore.GetFile(info.FileID); err == nil {
+		previous.keepAccessOn(record)
+	}
+	f.saveRecord(record)
+}
+
+// forgetFile removes the metadata of a deleted file
+func (f *FileStorageManager) forgetFile(fileID string) {
+	if f.metadataStore == nil {
+		return
+	}
+
+	f.deleteRecord(fileID)
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// staticTokens is a token manager always handing out the same token
+type staticTokens struct{}
+
+func (staticTokens) GenerateToken() (string, error) { return "token", nil }
+func (staticTokens) GetToken() (string, error)      { return "token", nil }
+func (staticTokens) HasToken() bool                 { return true }
+
+func TestDeduplicatesRestUploads(t *testing.T) {
+	var posts, deletes atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deletes.Add(1)
+			fmt.Fprintf(w, `{"status": %q}`, StatusSuccess)
+			return
+		}
+		id := fmt.Sprintf("file-%d", posts.Add(1))
+		fmt.Fprintf(w, `{"status": %q, "file_id": %q, "info": {"file_id": %q}}`, StatusSuccess, id, id)
+	}))
+	t.Cleanup(backend.Close)
+
+	f := NewFileStorageManager(&Config{HostURI: backend.URL}, staticTokens{})
+	store := NewMemoryMetadataStore()
+	f.SetMetadataStore(store)
+
+	content := base64.StdEncoding.EncodeToString([]byte("the same notes"))
+	first, err := f.UploadBase64File("notes", "txt", "text/plain", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := f.UploadBase64File("notes", "txt", "text/plain", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if posts.Load() != 1 || second.FileID != first.FileID {
+		t.Fatalf("stored %d files, second upload got %q, want %q", posts.Load(), second.FileID, first.FileID)
+	}
+
+	if _, err := f.Delete(first.FileID); err != nil {
+		t.Fatal(err)
+	}
+	if deletes.Load() != 0 {
+		t.Error("file deleted while another upload references it")
+	}
+	if _, err := f.Delete(first.FileID); err != nil {
+		t.Fatal(err)
+	}
+	if deletes.Load() != 1 {
+		t.Error("file kept after its last reference was deleted")
+	}
+	if _, err := store.GetFile(first.FileID); err == nil {
+		t.Error("record kept after its last reference was deleted")
+	}
+}
+
+func TestFilesBeingDeletedAreNotReused(t *testing.T) {
+	f := NewFileStorageManager(&Config{}, nil)
+	store := NewMemoryMetadataStore()
+	f.SetMetadataStore(store)
+
+	payload := &uploadPayload{sha256: "content", mimeType: "application/pdf", options: UploadOptions{Owner: "student-1"}}
+	store.SaveFile(&FileRecord{FileID: "report.pdf", Provider: ProviderAWS, Bucket: "bucket", SHA256: "content", Owner: "student-1", Variant: storedVariant(payload)})
+
+	shared, done, err := f.dropReference("report.pdf")
+	if err != nil || shared {
+		t.Fatalf("dropReference() = %v, %v, want the last reference dropped", shared, err)
+	}
+	if record := f.reuseDuplicate(ProviderAWS, "bucket", payload); record != nil {
+		t.Fatalf("reused %s while it is being deleted", record.FileID)
+	}
+
+	done()
+	if record := f.reuseDuplicate(ProviderAWS, "bucket", payload); record == nil || record.References != 2 {
+		t.Errorf("reuseDuplicate() = %+v after a failed delete, want the file reused", record)
+	}
+}
@@ -0,0 +1,96 @@
+// pkg/storage/delete_many.go
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxBatchDeleteFiles is the number of files DeleteFiles accepts at once
+const MaxBatchDeleteFiles = 100
+
+// DeleteFiles deletes several files stored with S3 or GCS concurrently, so
+// files of different providers can be removed in one batch. The provider of
+// each file is taken from an "s3:" or "gcs:" prefix of its ID, else from its
+// metadata record, else defaultProvider; files of providers not listed in
+// providers are not deleted. Every file gets a result in the order given,
+// failed ones with StatusError. Deletes move files to the trash while soft
+// delete is enabled.
+func (f *FileStorageManager) DeleteFiles(fileIDs []string, defaultProvider string, providers []string) (*FileResponse, error) {
+	if len(fileIDs) > MaxBatchDeleteFiles {
+		return nil, fmt.Errorf("%w: at most %d files can be deleted at once", ErrTooManyFiles, MaxBatchDeleteFiles)
+	}
+
+	results := make([]*FileResult, len(fileIDs))
+	failed := f.batchPool().Run(len(fileIDs), func(i int) error {
+		results[i] = f.deleteResult(fileIDs[i], defaultProvider, providers)
+		return resultErr(results[i])
+	})
+
+	return &FileResponse{
+		Status:  StatusSuccess,
+		Message: fmt.Sprintf("DELETE %d of %d files", len(fileIDs)-len(failed), len(fileIDs)),
+		Results: results,
+	}, nil
+}
+
+// deleteResult deletes a single file of a batch
+func (f *FileStorageManager) deleteResult(fileID, defaultProvider string, providers []string) *FileResult {
+	provider, fileID := f.FileProvider(fileID, defaultProvider)
+	result := &FileResult{
+		FileID:   fileID,
+		Provider: provider,
+		Status:   StatusError,
+	}
+
+	switch {
+	case provider == "":
+		result.Message = `provider not known, prefix the ID with "s3:" or "gcs:"`
+		return result
+	case !containsString(providers, provider):
+		result.Message = fmt.Sprintf("unknown provider %q", provider)
+		return result
+	}
+
+	response, err := f.DeleteFile(provider, fileID, "", "")
+	switch {
+	case err != nil:
+		result.Message = err.Error()
+	case response.Status != StatusSuccess:
+		result.Message = response.Message
+	default:
+		result.Status = StatusSuccess
+	}
+
+	return result
+}
+
+// FileProvider splits an "s3:" or "gcs:" prefix off a file ID, returning the
+// provider and the bare ID. IDs without a prefix get the provider of their
+// metadata record, or defaultProvider when none is kept.
+func (f *FileStorageManager) FileProvider(fileID, defaultProvider string) (string, string) {
+	for _, provider := range []string{ProviderAWS, ProviderGCS} {
+		if id := strings.TrimPrefix(fileID, provider+":"); id != fileID {
+			return provider, id
+		}
+	}
+
+	if f.metadataStore != nil {
+		if record, err := f.metadataStore.GetFile(fileID); err == nil && record.Provider != "" {
+			return record.Provider, fileID
+		}
+	}
+
+	return defaultProvider, fileID
+}
+
+// containsString reports whether value is one of values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
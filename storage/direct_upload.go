@@ -0,0 +1,367 @@
+// pkg/storage/direct_upload.go
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// UploadPending marks the record of a direct upload that was not confirmed yet
+const UploadPending = "pending"
+
+// DefaultDirectUploadExpiry is how long a presigned upload URL is valid by default
+const DefaultDirectUploadExpiry = 15 * time.Minute
+
+// DirectUploadGracePeriod is how long after its URL expires a direct upload
+// may still be confirmed, for browsers that finished sending it just in time.
+// Reservations not confirmed by then are purged with the object, if sent.
+const DirectUploadGracePeriod = time.Hour
+
+// maxDirectUploadExpiry is the longest a presigned upload URL can be valid,
+// which bounds reservations recorded without a deadline
+const maxDirectUploadExpiry = 7 * 24 * time.Hour
+
+// DirectUploadRequest describes a file a browser uploads straight to S3 or GCS
+type DirectUploadRequest struct {
+	Filename    string        // Original filename including the extension, e.g. "report.pdf"
+	ContentType string        // Content type the browser sends, e.g. "application/pdf"
+	Size        int64         // Exact size of the file in bytes
+	Owner       string        // Subject owning the file, e.g. the student's user ID
+	Prefix      string        // Key prefix, e.g. "submissions/2024"
+	MaxSize     int64         // Limit on top of the maximum upload size, e.g. a route limit, 0 for none
+	Expiry      time.Duration // How long the URL is valid, DefaultDirectUploadExpiry when 0
+	Attributes  Attributes    // Custom attributes recorded with the file, validated against the attribute schema
+}
+
+// DirectUpload is a reserved file ID with the presigned URL completing it
+type DirectUpload struct {
+	FileID    string            `json:"file_id"`
+	Method    string            `json:"method"`  // HTTP method of the upload, always PUT
+	URL       string            `json:"url"`     // Presigned URL to send the file content to
+	Headers   map[string]string `json:"headers"` // Headers the upload must send as given
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// PresignUpload validates a browser upload against the file filter and
+// upload limits, reserves its file ID in the metadata store and returns a
+// presigned PUT URL for it. The browser then sends the file to the provider
+// without passing through this service, and ConfirmUpload finalizes the
+// record. Direct uploads are neither compressed nor image processed and are
+// not available with client-side encryption.
+func (f *FileStorageManager) PresignUpload(provider string, request DirectUploadRequest) (*DirectUpload, error) {
+	if f.encryptor != nil || f.encryptionErr != nil {
+		return nil, fmt.Errorf("direct uploads are not supported with client-side encryption")
+	}
+	if f.metadataStore == nil {
+		return nil, fmt.Errorf("direct uploads need a metadata store")
+	}
+
+	if request.Size <= 0 {
+		return nil, fmt.Errorf("direct uploads need the file size")
+	}
+	if err := f.checkUploadSize(request.Size); err != nil {
+		return nil, err
+	}
+	if request.MaxSize > 0 && request.Size > request.MaxSize {
+		return nil, fmt.Errorf("%w of %d bytes", ErrFileTooLarge, request.MaxSize)
+	}
+
+	if err := validatePrefix(request.Prefix); err != nil {
+		return nil, err
+	}
+
+	attributes, err := f.attributeSchema.Validate(request.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := normalizeMimeType(request.ContentType)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if err := f.fileFilter.Check(request.Filename, contentType); err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(request.Filename)
+	extension := filepath.Ext(name)
+	name = name[:len(name)-len(extension)]
+	if extension != "" {
+		extension = extension[1:] // Remove the dot
+	}
+
+	expiry := request.Expiry
+	if expiry <= 0 {
+		expiry = DefaultDirectUploadExpiry
+	}
+
+	upload := &DirectUpload{
+		Method:    http.MethodPut,
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: time.Now().Add(expiry),
+	}
+	keyPayload := &uploadPayload{filename: name, extension: extension}
+
+	var bucketname string
+	switch provider {
+	case ProviderAWS:
+		bucketname = f.config.AWSBucket
+
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return nil, err
+		}
+
+		upload.FileID, err = f.newObjectKey(request.Prefix, keyPayload, func(key string) (bool, error) {
+			return awsObjectExists(aws.BackgroundContext(), s3Client, bucketname, key)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		req, _ := s3Client.PutObjectRequest(&s3.PutObjectInput{
+			Bucket:      aws.String(bucketname),
+			Key:         aws.String(upload.FileID),
+			ContentType: aws.String(contentType),
+		})
+		upload.URL, err = req.Presign(expiry)
+		if err != nil {
+			return nil, err
+		}
+
+	case ProviderGCS:
+		bucketname = f.config.GCSBucket
+
+		gcsClient, err := f.GetGcsClient("")
+		if err != nil {
+			return nil, err
+		}
+		defer gcsClient.Close()
+
+		ctx := context.Background()
+		bucket := gcsClient.Bucket(bucketname)
+
+		upload.FileID, err = f.newObjectKey(request.Prefix, keyPayload, func(key string) (bool, error) {
+			return gcsObjectExists(ctx, bucket, key)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		keyData, err := f.gcsSigningKey()
+		if err != nil {
+			return nil, err
+		}
+
+		upload.URL, err = storage.SignedURL(bucketname, upload.FileID, &storage.SignedURLOptions{
+			Method:         http.MethodPut,
+			Expires:        upload.ExpiresAt,
+			ContentType:    contentType,
+			GoogleAccessID: keyData.ClientEmail,
+			PrivateKey:     []byte(keyData.PrivateKey),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("direct uploads are not supported for provider %q", provider)
+	}
+
+	err = f.saveRecord(&FileRecord{
+		FileID:       upload.FileID,
+		Provider:     provider,
+		Bucket:       bucketname,
+		FileName:     name,
+		FileExt:      extension,
+		MimeType:     contentType,
+		FileSize:     request.Size,
+		Owner:        request.Owner,
+		Attributes:   attributes,
+		UploadStatus: UploadPending,
+		ExpiresAt:    upload.ExpiresAt.Add(DirectUploadGracePeriod),
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// ConfirmUpload finalizes a direct upload once the browser has sent the
+// file: the object is read back once to check its size, detect its content
+// type, compute its checksums and scan it for malware. Objects that do not
+// match the reservation are deleted, like those confirmed after the URL
+// expired and the grace period passed. When owner is given, only the owner
+// recorded by PresignUpload may confirm. Confirming twice returns the same file.
+func (f *FileStorageManager) ConfirmUpload(fileID, owner string) (response *FileResponse, err error) {
+	if f.metadataStore == nil {
+		return nil, fmt.Errorf("direct uploads need a metadata store")
+	}
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, fileID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if owner != "" && record.Owner != owner {
+		return nil, fmt.Errorf("%w: %s", ErrNotOwner, fileID)
+	}
+	if record.UploadStatus != UploadPending {
+		return &FileResponse{Status: StatusSuccess, FileID: fileID, Info: record.FileInfo()}, nil
+	}
+	if record.abandoned(time.Now()) {
+		f.purgeAbandonedUpload(record)
+		return nil, fmt.Errorf("%w: %s was not confirmed in time", ErrUploadExpired, fileID)
+	}
+
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationUpload, record.Provider, start, record.FileSize, response, err)
+	}()
+
+	file, err := f.OpenFile(record.Provider, fileID, record.Bucket, "")
+	if errors.Is(err, ErrFileNotFound) {
+		return nil, fmt.Errorf("%w: %s has not been uploaded", ErrIncompleteUpload, fileID)
+	}
+	if err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+	size := file.Size()
+	file.Close()
+
+	// The reservation is used up either way, a mismatching object is removed
+	reject := func(err error) (*FileResponse, error) {
+		f.deleteFile(record.Provider, fileID, record.Bucket, "", false)
+		f.deleteRecord(fileID)
+		return nil, err
+	}
+
+	if size != record.FileSize {
+		return reject(fmt.Errorf("%w: uploaded %d bytes, %d reserved", ErrIncompleteUpload, size, record.FileSize))
+	}
+
+	open := func() (io.ReadCloser, error) {
+		file, err := f.OpenFile(record.Provider, fileID, record.Bucket, "")
+		if err != nil {
+			return nil, err
+		}
+		file.observe = nil // Reading the upload back is not a download
+		return file, nil
+	}
+
+	name := record.FileName
+	if record.FileExt != "" {
+		name += "." + record.FileExt
+	}
+
+	mimeType, md5sum, sha256sum, scan, err := f.inspectAssembled(name, record.MimeType, open)
+	if err != nil {
+		return reject(err)
+	}
+
+	publicURL := gcsPublicURL(record.Bucket, fileID)
+	if record.Provider == ProviderAWS {
+		publicURL = f.awsPublicURL(record.Bucket, fileID)
+	}
+
+	fileInfo := &FileInfo{
+		FileExt:      record.FileExt,
+		FileID:       fileID,
+		FileMimeType: mimeType,
+		FileName:     record.FileName,
+		FileSize:     size,
+		PublicLink:   publicURL,
+		Timestamp:    time.Now(),
+		Bucket:       record.Bucket,
+		MD5:          md5sum,
+		SHA256:       sha256sum,
+		Owner:        record.Owner,
+		Attributes:   record.Attributes,
+	}
+
+	scan.applyTo(fileInfo)
+	f.recordUpload(record.Provider, record.Bucket, fileInfo, "")
+
+	return &FileResponse{
+		Status:  StatusSuccess,
+		Message: "INSERT " + fileID,
+		FileID:  fileID,
+		Info:    fileInfo,
+	}, nil
+}
+
+// PurgeAbandonedUploads removes the reservations of direct uploads that were
+// not confirmed by their deadline, along with the objects browsers sent for
+// them, and returns how many were purged
+func (f *FileStorageManager) PurgeAbandonedUploads() (int, error) {
+	lister, ok := f.metadataStore.(FileLister)
+	if !ok {
+		return 0, nil
+	}
+
+	records, err := lister.ListFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, record := range records {
+		if record.UploadStatus != UploadPending || !record.abandoned(now) {
+			continue
+		}
+		if err := f.purgeAbandonedUpload(record); err != nil {
+			return purged, fmt.Errorf("%s: %w", record.FileID, err)
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// purgeAbandonedUpload deletes the object of an unconfirmed direct upload,
+// when the browser sent it, and its reservation
+func (f *FileStorageManager) purgeAbandonedUpload(record *FileRecord) error {
+	_, exists, err := f.Exists(record.Provider, record.FileID, record.Bucket, "")
+	if err != nil {
+		return err
+	}
+	if exists {
+		response, err := f.deleteFile(record.Provider, record.FileID, record.Bucket, "", false)
+		if err == nil && response.Status != StatusSuccess {
+			err = errors.New(response.Message)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return f.deleteRecord(record.FileID)
+}
+
+// abandoned reports whether the reservation of a direct upload has passed
+// its deadline. Reservations recorded without one are kept for as long as
+// any presigned URL could be valid.
+func (r *FileRecord) abandoned(now time.Time) bool {
+	deadline := r.ExpiresAt
+	if deadline.IsZero() {
+		deadline = r.CreatedAt.Add(maxDirectUploadExpiry + DirectUploadGracePeriod)
+	}
+	return now.After(deadline)
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// newFakeS3Manager returns a manager storing S3 objects in a fakeS3 and
+// records in a memory metadata store
+func newFakeS3Manager(t *testing.T, sizes map[string]int64) (*FileStorageManager, *fakeS3, *MemoryMetadataStore) {
+	t.Helper()
+
+	fake, client := newFakeS3(t, sizes)
+	f := newS3Manager(client)
+
+	store := NewMemoryMetadataStore()
+	f.SetMetadataStore(store)
+	return f, fake, store
+}
+
+// newS3Manager returns a manager using client for S3 "bucket"
+func newS3Manager(client *s3.S3) *FileStorageManager {
+	f := NewFileStorageManager(&Config{AWSRegion: "us-east-1", AWSBucket: "bucket"}, nil)
+	f.awsClients.clients = map[string]*awsClient{"us-east-1": {profile: awsProfile{region: "us-east-1"}, client: client}}
+	return f
+}
+
+func TestPurgeAbandonedUploads(t *testing.T) {
+	f, fake, store := newFakeS3Manager(t, map[string]int64{"sent.pdf": 1, "confirmed.pdf": 1})
+	now := time.Now()
+	for _, record := range []*FileRecord{
+		{FileID: "sent.pdf", UploadStatus: UploadPending, ExpiresAt: now.Add(-time.Minute)},
+		{FileID: "never-sent.pdf", UploadStatus: UploadPending, ExpiresAt: now.Add(-time.Minute)},
+		{FileID: "in-progress.pdf", UploadStatus: UploadPending, ExpiresAt: now.Add(time.Minute)},
+		{FileID: "old-reservation.pdf", UploadStatus: UploadPending, CreatedAt: now.Add(-maxDirectUploadExpiry - DirectUploadGracePeriod - time.Minute)},
+		{FileID: "new-reservation.pdf", UploadStatus: UploadPending, CreatedAt: now.Add(-time.Hour)},
+		{FileID: "confirmed.pdf", ExpiresAt: now.Add(-time.Minute)},
+	} {
+		record.Provider, record.Bucket = ProviderAWS, "bucket"
+		store.SaveFile(record)
+	}
+
+	purged, err := f.PurgeAbandonedUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 3 {
+		t.Errorf("purged %d uploads, want 3", purged)
+	}
+
+	for fileID, kept := range map[string]bool{
+		"sent.pdf":            false,
+		"never-sent.pdf":      false,
+		"in-progress.pdf":     true,
+		"old-reservation.pdf": false,
+		"new-reservation.pdf": true,
+		"confirmed.pdf":       true,
+	} {
+		if _, err := store.GetFile(fileID); (err == nil) != kept {
+			t.Errorf("record of %s kept: %v, want %v", fileID, err == nil, kept)
+		}
+	}
+
+	if _, ok := fake.sizes["sent.pdf"]; ok {
+		t.Error("object of the abandoned upload kept")
+	}
+	if _, ok := fake.sizes["confirmed.pdf"]; !ok {
+		t.Error("object of the confirmed upload deleted")
+	}
+	if slices.Contains(fake.requests, "DELETE never-sent.pdf") {
+		t.Error("deleted the object of an upload that was never sent")
+	}
+}
+
+func TestConfirmUploadAfterDeadline(t *testing.T) {
+	f, fake, store := newFakeS3Manager(t, map[string]int64{"late.pdf": 1})
+	store.SaveFile(&FileRecord{FileID: "late.pdf", Provider: ProviderAWS, Bucket: "bucket", FileSize: 1, UploadStatus: UploadPending, ExpiresAt: time.Now().Add(-time.Second)})
+
+	if _, err := f.ConfirmUpload("late.pdf", ""); !errors.Is(err, ErrUploadExpired) {
+		t.Fatalf("ConfirmUpload() error = %v, want ErrUploadExpired", err)
+	}
+	if _, err := store.GetFile("late.pdf"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("reservation kept: %v", err)
+	}
+	if _, ok := fake.sizes["late.pdf"]; ok {
+		t.Error("object of the late upload kept")
+	}
+}
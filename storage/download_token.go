@@ -0,0 +1,156 @@
+// pkg/storage/download_token.go
+
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultDownloadTokenTTL is how long download tokens are valid when no lifetime is given
+	DefaultDownloadTokenTTL = time.Hour
+
+	// MaxDownloadTokenTTL bounds the lifetime of download tokens, and so how
+	// long a subject's revocation has to be remembered
+	MaxDownloadTokenTTL = 7 * 24 * time.Hour
+
+	downloadTokenPrefix  = "download-token:"
+	revokedSubjectPrefix = "download-token-revoked:"
+)
+
+// DownloadToken is an opaque token letting its holder download one file
+// through the proxy. Unlike a signed URL it is checked on every request, so
+// revoking it takes effect immediately.
+type DownloadToken struct {
+	Token     string    `json:"token"`
+	Provider  string    `json:"provider"`
+	Bucket    string    `json:"bucket,omitempty"`
+	ProjectID string    `json:"project_id,omitempty"`
+	FileID    string    `json:"file_id"`
+	Subject   string    `json:"subject,omitempty"` // Whom the token was issued to, e.g. a student ID
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SetDownloadTokenCache sets the cache download tokens are kept in. A shared
+// cache lets every instance of the service check and revoke the same tokens.
+func (f *FileStorageManager) SetDownloadTokenCache(cache Cache) {
+	f.tokenCache = cache
+}
+
+// IssueDownloadToken issues a download token for a file stored with an S3 or
+// GCS provider, valid for ttl (DefaultDownloadTokenTTL when 0). subject names
+// whom it is issued to, so all their tokens can be revoked at once.
+func (f *FileStorageManager) IssueDownloadToken(provider, fileID, bucketname, projectID, subject string, ttl time.Duration) (*DownloadToken, error) {
+	if provider != ProviderAWS && provider != ProviderGCS {
+		return nil, fmt.Errorf("unknown provider %q", provider)
+	}
+	if ttl == 0 {
+		ttl = DefaultDownloadTokenTTL
+	}
+	if ttl < 0 || ttl > MaxDownloadTokenTTL {
+		return nil, fmt.Errorf("download token lifetime must be between 0 and %s", MaxDownloadTokenTTL)
+	}
+
+	// Make sure the file exists before handing out a token for it
+	if _, exists, err := f.Exists(provider, fileID, bucketname, projectID); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, fileID)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	token := &DownloadToken{
+		Token:     base64.RawURLEncoding.EncodeToString(secret),
+		Provider:  provider,
+		Bucket:    bucketname,
+		ProjectID: projectID,
+		FileID:    fileID,
+		Subject:   subject,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	encoded, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+	f.tokenCache.Set(downloadTokenPrefix+token.Token, string(encoded), ttl)
+
+	return token, nil
+}
+
+// GetDownloadToken returns a valid download token, or ErrDownloadTokenInvalid
+// when it is unknown, expired or revoked
+func (f *FileStorageManager) GetDownloadToken(token string) (*DownloadToken, error) {
+	encoded, found := f.tokenCache.Get(downloadTokenPrefix + token)
+	if !found {
+		return nil, ErrDownloadTokenInvalid
+	}
+
+	var downloadToken DownloadToken
+	if err := json.Unmarshal([]byte(encoded), &downloadToken); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDownloadTokenInvalid, err)
+	}
+
+	// The cache may keep entries a little past their expiry
+	if time.Now().After(downloadToken.ExpiresAt) {
+		return nil, ErrDownloadTokenInvalid
+	}
+
+	if downloadToken.Subject != "" {
+		if revoked, found := f.tokenCache.Get(revokedSubjectPrefix + downloadToken.Subject); found {
+			revokedAt, err := strconv.ParseInt(revoked, 10, 64)
+			if err == nil && downloadToken.IssuedAt.UnixNano() <= revokedAt {
+				return nil, fmt.Errorf("%w: revoked", ErrDownloadTokenInvalid)
+			}
+		}
+	}
+
+	return &downloadToken, nil
+}
+
+// OpenDownloadToken opens the file a download token grants access to
+func (f *FileStorageManager) OpenDownloadToken(token string) (*ObjectFile, error) {
+	downloadToken, err := f.GetDownloadToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.OpenFile(downloadToken.Provider, downloadToken.FileID, downloadToken.Bucket, downloadToken.ProjectID)
+}
+
+// RevokeDownloadToken invalidates a download token immediately
+func (f *FileStorageManager) RevokeDownloadToken(token string) error {
+	if _, found := f.tokenCache.Get(downloadTokenPrefix + token); !found {
+		return ErrDownloadTokenInvalid
+	}
+
+	f.tokenCache.Delete(downloadTokenPrefix + token)
+	return nil
+}
+
+// RevokeSubjectDownloadTokens invalidates every download token issued to a
+// subject so far, e.g. when a student is removed from an activity. Tokens
+// issued afterwards are valid again.
+func (f *FileStorageManager) RevokeSubjectDownloadTokens(subject string) error {
+	if subject == "" {
+		return fmt.Errorf("subject is required")
+	}
+
+	// Outstanding tokens expire within MaxDownloadTokenTTL, so the revocation
+	// does not need to be remembered longer
+	revokedAt := strconv.FormatInt(time.Now().UnixNano(), 10)
+	f.tokenCache.Set(revokedSubjectPrefix+subject, revokedAt, MaxDownloadTokenTTL)
+	return nil
+}
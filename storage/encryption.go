@@ -0,0 +1,475 @@
+// pkg/storage/encryption.go
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Encrypted objects start with encryptionMagic followed by the wrapped data key
+// length (uint16), the wrapped data key and a 7 byte nonce prefix. The content
+// follows as AES-256-GCM sealed segments of encryptionSegmentSize plaintext
+// bytes, so downloads can be decrypted as a stream.
+const (
+	encryptionMagic       = "FSENC\x01"
+	encryptionSegmentSize = 64 * 1024
+	dataKeySize           = 32
+	noncePrefixSize       = 7
+)
+
+// KeyWrapper wraps and unwraps the per-file data keys used for envelope encryption
+type KeyWrapper interface {
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrappedKey []byte) ([]byte, error)
+}
+
+// Encryptor implements AES-256-GCM envelope encryption of file content
+type Encryptor struct {
+	keyWrapper KeyWrapper
+}
+
+// NewEncryptor creates an encryptor that protects data keys with the given key wrapper
+func NewEncryptor(keyWrapper KeyWrapper) *Encryptor {
+	return &Encryptor{
+		keyWrapper: keyWrapper,
+	}
+}
+
+// NewEncryptorFromConfig creates an encryptor from the configured master key or
+// KMS key ID, returning nil when encryption is not configured
+func NewEncryptorFromConfig(config *Config) (*Encryptor, error) {
+	switch {
+	case config.EncryptionKMSKeyID != "":
+		sess, err := session.NewSession(&aws.Config{
+			Region:      aws.String(config.AWSRegion),
+			Credentials: credentials.NewStaticCredentials(config.AWSKey, config.AWSSecret, ""),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return NewEncryptor(NewKmsKeyWrapper(kms.New(sess), config.EncryptionKMSKeyID)), nil
+
+	case len(config.EncryptionKey) > 0:
+		keyWrapper, err := NewStaticKeyWrapper(config.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		return NewEncryptor(keyWrapper), nil
+	}
+
+	return nil, nil
+}
+
+// Encrypt seals data with a fresh data key
+func (e *Encryptor) Encrypt(data []byte) ([]byte, error) {
+	gcm, header, noncePrefix, err := e.newDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	segments := (len(data) + encryptionSegmentSize - 1) / encryptionSegmentSize
+	if segments == 0 {
+		segments = 1
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(header)+len(data)+segments*gcm.Overhead()))
+	out.Write(header)
+
+	for i := 0; i < segments; i++ {
+		end := (i + 1) * encryptionSegmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		segment := data[i*encryptionSegmentSize : end]
+		out.Write(gcm.Seal(nil, segmentNonce(noncePrefix, uint32(i), i == segments-1), segment, nil))
+	}
+
+	return out.Bytes(), nil
+}
+
+// EncryptTo seals the size bytes read from src with a fresh data key and
+// writes them to dst in the format of Encrypt, one segment at a time, so
+// content larger than memory can be encrypted. It returns the bytes written.
+func (e *Encryptor) EncryptTo(dst io.Writer, src io.Reader, size int64) (int64, error) {
+	gcm, header, noncePrefix, err := e.newDataKey()
+	if err != nil {
+		return 0, err
+	}
+
+	segments := (size + encryptionSegmentSize - 1) / encryptionSegmentSize
+	if segments == 0 {
+		segments = 1
+	}
+
+	n, err := dst.Write(header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	segment := make([]byte, encryptionSegmentSize)
+	sealed := make([]byte, 0, encryptionSegmentSize+gcm.Overhead())
+	for i := int64(0); i < segments; i++ {
+		length := size - i*encryptionSegmentSize
+		if length > encryptionSegmentSize {
+			length = encryptionSegmentSize
+		}
+		if _, err := io.ReadFull(src, segment[:length]); err != nil {
+			return written, err
+		}
+
+		sealed = gcm.Seal(sealed[:0], segmentNonce(noncePrefix, uint32(i), i == segments-1), segment[:length], nil)
+		n, err := dst.Write(sealed)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// newDataKey creates a fresh data key, returning its cipher, the header
+// sealed content starts with and the nonce prefix of its segments
+func (e *Encryptor) newDataKey() (cipher.AEAD, []byte, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, nil, err
+	}
+
+	wrappedKey, err := e.keyWrapper.WrapKey(dataKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(wrappedKey) > 0xFFFF {
+		return nil, nil, nil, fmt.Errorf("wrapped data key too large")
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	noncePrefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(noncePrefix); err != nil {
+		return nil, nil, nil, err
+	}
+
+	header := bytes.NewBuffer(make([]byte, 0, len(encryptionMagic)+2+len(wrappedKey)+noncePrefixSize))
+	header.WriteString(encryptionMagic)
+	binary.Write(header, binary.BigEndian, uint16(len(wrappedKey)))
+	header.Write(wrappedKey)
+	header.Write(noncePrefix)
+
+	return gcm, header.Bytes(), noncePrefix, nil
+}
+
+// Decrypt opens data sealed by Encrypt
+func (e *Encryptor) Decrypt(data []byte) ([]byte, error) {
+	reader, err := e.DecryptReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return readAll(reader, int64(len(data)))
+}
+
+// DecryptReader returns a reader yielding the plaintext of r. Content that does
+// not carry the encryption header is passed through unchanged, so files stored
+// before encryption was enabled remain readable.
+func (e *Encryptor) DecryptReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, encryptionSegmentSize+64)
+
+	magic, err := br.Peek(len(encryptionMagic))
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	if string(magic) != encryptionMagic {
+		return br, nil
+	}
+	br.Discard(len(encryptionMagic))
+
+	var keyLength uint16
+	if err := binary.Read(br, binary.BigEndian, &keyLength); err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	wrappedKey := make([]byte, keyLength)
+	if _, err := io.ReadFull(br, wrappedKey); err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	noncePrefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(br, noncePrefix); err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	dataKey, err := e.keyWrapper.UnwrapKey(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptingReader{
+		source:      br,
+		gcm:         gcm,
+		noncePrefix: noncePrefix,
+		segment:     make([]byte, encryptionSegmentSize+gcm.Overhead()+1),
+	}, nil
+}
+
+// IsEncrypted reports whether data carries the encryption header
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptionMagic))
+}
+
+// decryptingReader opens sealed segments as they are read
+type decryptingReader struct {
+	source      *bufio.Reader
+	gcm         cipher.AEAD
+	noncePrefix []byte
+	segment     []byte
+	counter     uint32
+	plaintext   []byte
+	done        bool
+}
+
+// Read implements io.Reader
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plaintext) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plaintext)
+	d.plaintext = d.plaintext[n:]
+	return n, nil
+}
+
+// next opens the following segment, reading one byte ahead to detect the last one
+func (d *decryptingReader) next() error {
+	sealedSize := encryptionSegmentSize + d.gcm.Overhead()
+
+	n, err := io.ReadFull(d.source, d.segment[:sealedSize])
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := d.source.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+
+	plaintext, err := d.gcm.Open(d.segment[:0], segmentNonce(d.noncePrefix, d.counter, last), d.segment[:n], nil)
+	if err != nil {
+		return ErrDecryptionFailed
+	}
+
+	d.counter++
+	d.plaintext = plaintext
+	d.done = last
+	return nil
+}
+
+// segmentNonce derives the nonce of a segment, binding its position and whether
+// it is the final one so segments can be neither reordered nor truncated
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// newGCM creates an AES-GCM cipher for a 256 bit key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes", dataKeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// StaticKeyWrapper wraps data keys with a master key held in configuration
+type StaticKeyWrapper struct {
+	gcm cipher.AEAD
+}
+
+// NewStaticKeyWrapper creates a key wrapper from a 32 byte master key
+func NewStaticKeyWrapper(masterKey []byte) (*StaticKeyWrapper, error) {
+	gcm, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StaticKeyWrapper{gcm: gcm}, nil
+}
+
+// WrapKey seals a data key with the master key
+func (w *StaticKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, w.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return w.gcm.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+// UnwrapKey opens a data key sealed by WrapKey
+func (w *StaticKeyWrapper) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	if len(wrappedKey) < w.gcm.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+
+	nonce, sealed := wrappedKey[:w.gcm.NonceSize()], wrappedKey[w.gcm.NonceSize():]
+	return w.gcm.Open(nil, nonce, sealed, nil)
+}
+
+// KmsKeyWrapper wraps data keys with an AWS KMS key
+type KmsKeyWrapper struct {
+	client *kms.KMS
+	keyID  string
+}
+
+// NewKmsKeyWrapper creates a key wrapper backed by an AWS KMS key ID or ARN
+func NewKmsKeyWrapper(client *kms.KMS, keyID string) *KmsKeyWrapper {
+	return &KmsKeyWrapper{
+		client: client,
+		keyID:  keyID,
+	}
+}
+
+// WrapKey encrypts a data key with KMS
+func (w *KmsKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	result, err := w.client.Encrypt(&kms.EncryptInput{
+		KeyId:     aws.String(w.keyID),
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.CiphertextBlob, nil
+}
+
+// UnwrapKey decrypts a data key with KMS
+func (w *KmsKeyWrapper) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	result, err := w.client.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(w.keyID),
+		CiphertextBlob: wrappedKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Plaintext, nil
+}
+
+// SetEncryptor enables client-side encryption of uploaded content, nil disables it.
+// Downloads through the manager are decrypted transparently; temporary public
+// links serve the stored, encrypted content.
+func (f *FileStorageManager) SetEncryptor(encryptor *Encryptor) {
+	f.encryptor = encryptor
+	f.encryptionErr = nil
+}
+
+// encryptPayload encrypts the payload content when encryption is enabled
+func (f *FileStorageManager) encryptPayload(payload *uploadPayload) error {
+	if f.encryptionErr != nil {
+		// Fail closed rather than storing plaintext when encryption is misconfigured
+		return f.encryptionErr
+	}
+	if f.encryptor == nil {
+		return nil
+	}
+
+	data, err := f.encryptor.Encrypt(payload.data)
+	if err != nil {
+		return err
+	}
+
+	payload.data = data
+	payload.encrypted = true
+	return nil
+}
+
+// encryptBase64 encrypts base64 encoded content, returning it base64 encoded
+func (f *FileStorageManager) encryptBase64(base64file string) (string, error) {
+	if f.encryptionErr != nil {
+		return "", f.encryptionErr
+	}
+
+	data, err := base64.StdEncoding.DecodeString(base64file)
+	if err != nil {
+		return "", err
+	}
+
+	data, err = f.encryptor.Encrypt(data)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decryptBase64 decrypts base64 encoded content, returning it base64 encoded
+func (f *FileStorageManager) decryptBase64(base64file string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(base64file)
+	if err != nil {
+		return "", err
+	}
+
+	data, err = f.decryptData(data)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decryptData decrypts downloaded content if it is encrypted
+func (f *FileStorageManager) decryptData(data []byte) ([]byte, error) {
+	if f.encryptor == nil || !IsEncrypted(data) {
+		return data, nil
+	}
+	return f.encryptor.Decrypt(data)
+}
+
+// decryptReader wraps a download stream with decryption when encryption is enabled
+func (f *FileStorageManager) decryptReader(r io.Reader) (io.Reader, error) {
+	if f.encryptor == nil {
+		return r, nil
+	}
+	return f.encryptor.DecryptReader(r)
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func newTestEncryptor(t *testing.T) *Encryptor {
+	t.Helper()
+
+	masterKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(masterKey); err != nil {
+		t.Fatal(err)
+	}
+	keyWrapper, err := NewStaticKeyWrapper(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEncryptor(keyWrapper)
+}
+
+func randomBytes(t *testing.T, size int) []byte {
+	t.Helper()
+
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	encryptor := newTestEncryptor(t)
+
+	for _, tt := range []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"below a segment", encryptionSegmentSize - 1},
+		{"one segment", encryptionSegmentSize},
+		{"above a segment", encryptionSegmentSize + 1},
+		{"several segments", 3*encryptionSegmentSize + 17},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := randomBytes(t, tt.size)
+
+			sealed, err := encryptor.Encrypt(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsEncrypted(sealed) {
+				t.Error("IsEncrypted() = false for sealed content")
+			}
+
+			var streamed bytes.Buffer
+			written, err := encryptor.EncryptTo(&streamed, bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written != int64(streamed.Len()) || written != int64(len(sealed)) {
+				t.Errorf("EncryptTo() wrote %d bytes, reported %d, Encrypt() sealed %d", streamed.Len(), written, len(sealed))
+			}
+
+			for name, content := range map[string][]byte{"Encrypt": sealed, "EncryptTo": streamed.Bytes()} {
+				opened, err := encryptor.Decrypt(content)
+				if err != nil {
+					t.Fatalf("Decrypt() of %s content: %v", name, err)
+				}
+				if !bytes.Equal(opened, data) {
+					t.Errorf("Decrypt() of %s content differs from the plaintext", name)
+				}
+			}
+		})
+	}
+}
+
+func TestDecryptReaderPassesPlaintextThrough(t *testing.T) {
+	data := []byte("stored before encryption was enabled")
+
+	reader, err := newTestEncryptor(t).DecryptReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, data) {
+		t.Errorf("DecryptReader() = %q, want %q", opened, data)
+	}
+}
+
+func TestDecryptDetectsTampering(t *testing.T) {
+	encryptor := newTestEncryptor(t)
+	data := randomBytes(t, 3*encryptionSegmentSize+17)
+	sealed, err := encryptor.Encrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headerSize := len(sealed) - len(data) - 4*16 // Four segments with a GCM tag each
+	sealedSegment := encryptionSegmentSize + 16
+	segment := func(i int) []byte {
+		start := headerSize + i*sealedSegment
+		return sealed[start:min(start+sealedSegment, len(sealed))]
+	}
+	flip := func(offset int) []byte {
+		tampered := append([]byte(nil), sealed...)
+		tampered[offset] ^= 1
+		return tampered
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		content []byte
+	}{
+		{"flipped content byte", flip(headerSize + 100)},
+		{"flipped tag byte", flip(len(sealed) - 1)},
+		{"flipped wrapped key", flip(len(encryptionMagic) + 3)},
+		{"flipped nonce prefix", flip(headerSize - 1)},
+		{"truncated segment", sealed[:len(sealed)-1]},
+		{"last segment dropped", join(sealed[:headerSize], segment(0), segment(1), segment(2))},
+		{"segments reordered", join(sealed[:headerSize], segment(1), segment(0), segment(2), segment(3))},
+		{"segment repeated", join(sealed[:headerSize], segment(0), segment(0), segment(1), segment(2), segment(3))},
+		{"bytes appended", join(sealed, []byte{0})},
+		{"header only", sealed[:headerSize]},
+		{"truncated header", sealed[:len(encryptionMagic)+1]},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := encryptor.Decrypt(tt.content); !errors.Is(err, ErrDecryptionFailed) {
+				t.Errorf("Decrypt() error = %v, want ErrDecryptionFailed", err)
+			}
+		})
+	}
+
+	t.Run("other master key", func(t *testing.T) {
+		if _, err := newTestEncryptor(t).Decrypt(sealed); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("Decrypt() error = %v, want ErrDecryptionFailed", err)
+		}
+	})
+}
@@ -0,0 +1,214 @@
+// pkg/storage/errors.go
+
+package storage
+
+import "errors"
+
+var (
+	// ErrFileTooLarge is returned when an upload exceeds the configured maximum size
+	ErrFileTooLarge = errors.New("file exceeds maximum upload size")
+
+	// ErrFileTypeNotAllowed is returned when an upload is rejected by the file type filter
+	ErrFileTypeNotAllowed = errors.New("file type not allowed")
+
+	// ErrMimeTypeMismatch is returned when the claimed content type disagrees with the sniffed one
+	ErrMimeTypeMismatch = errors.New("content type does not match file content")
+
+	// ErrFileNotFound is returned when a stored object does not exist
+	ErrFileNotFound = errors.New("file not found")
+
+	// ErrFileExists is returned when a file is restored over another file stored under its ID
+	ErrFileExists = errors.New("file already exists")
+
+	// ErrFolderNotFound is returned when a virtual folder does not exist
+	ErrFolderNotFound = errors.New("folder not found")
+
+	// ErrFolderExists is returned when a folder is named like another folder in the same parent
+	ErrFolderExists = errors.New("folder already exists")
+
+	// ErrFolderNotEmpty is returned when a folder holding folders or files is deleted
+	ErrFolderNotEmpty = errors.New("folder is not empty")
+
+	// ErrRecordNotFound is returned by metadata stores when no record matches
+	ErrRecordNotFound = errors.New("record not found")
+
+	// ErrInvalidImage is returned when an image upload cannot be decoded for processing
+	ErrInvalidImage = errors.New("invalid image")
+
+	// ErrFileInfected is returned when the malware scanner flags an upload
+	ErrFileInfected = errors.New("file is infected")
+
+	// ErrScanFailed is returned when an upload cannot be scanned for malware
+	ErrScanFailed = errors.New("malware scan failed")
+
+	// ErrInvalidArchive is returned when an uploaded archive cannot be read or contains unsafe paths
+	ErrInvalidArchive = errors.New("invalid archive")
+
+	// ErrArchiveLimitExceeded is returned when an uploaded archive exceeds the extraction limits
+	ErrArchiveLimitExceeded = errors.New("archive exceeds extraction limits")
+
+	// ErrInvalidObjectHeader is returned when an object header value contains line breaks or NUL bytes
+	ErrInvalidObjectHeader = errors.New("invalid object header")
+
+	// ErrUploadNotFound is returned when a chunked upload does not exist or has already finished
+	ErrUploadNotFound = errors.New("upload not found")
+
+	// ErrUploadExpired is returned when a chunked upload session has passed its expiry
+	ErrUploadExpired = errors.New("upload session expired")
+
+	// ErrInvalidChunk is returned when a chunk index is out of range or chunks exceed the expected size
+	ErrInvalidChunk = errors.New("invalid chunk")
+
+	// ErrIncompleteUpload is returned when a chunked upload is completed with chunks missing
+	ErrIncompleteUpload = errors.New("upload is incomplete")
+
+	// ErrOffsetMismatch is returned when an upload is continued from an offset other than the bytes received
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+
+	// ErrQueueFull is returned when a background job cannot be queued because too many are waiting
+	ErrQueueFull = errors.New("job queue is full")
+
+	// ErrShuttingDown is returned when a background job is submitted after Shutdown
+	ErrShuttingDown = errors.New("service is shutting down")
+
+	// ErrProviderBusy is returned when a provider runs as many operations as it may until the queue timeout
+	ErrProviderBusy = errors.New("provider is busy")
+
+	// ErrMemoryBudgetExceeded is returned when an upload cannot be buffered within the memory budget until the queue timeout
+	ErrMemoryBudgetExceeded = errors.New("memory budget exceeded")
+
+	// ErrJobNotFound is returned when a background job does not exist or has been forgotten
+	ErrJobNotFound = errors.New("job not found")
+
+	// ErrScheduledJobNotFound is returned when a scheduled job of the name does not exist
+	ErrScheduledJobNotFound = errors.New("scheduled job not found")
+
+	// ErrScheduledJobRunning is returned when a scheduled job is run while a run of it is in progress
+	ErrScheduledJobRunning = errors.New("scheduled job already running")
+
+	// ErrInvalidSchedule is returned when job intervals do not parse or a job without an interval is enabled
+	ErrInvalidSchedule = errors.New("invalid schedule")
+
+	// ErrInvalidQuery is returned when a file search has an unknown sort or a limit out of range
+	ErrInvalidQuery = errors.New("invalid search query")
+
+	// ErrInvalidTag is returned when a tag is empty, too long or has characters object tags do not allow, or a file has too many
+	ErrInvalidTag = errors.New("invalid tag")
+
+	// ErrInvalidGrant is returned when a file access grant names no user or role, or an unknown permission
+	ErrInvalidGrant = errors.New("invalid access grant")
+
+	// ErrInvalidExpiry is returned when a file expiry is in the past or an expiry policy or action is malformed
+	ErrInvalidExpiry = errors.New("invalid expiry")
+
+	// ErrInvalidAttributes is returned when the custom attributes of an upload do not match the attribute schema
+	ErrInvalidAttributes = errors.New("invalid attributes")
+
+	// ErrInvalidAttributeSchema is returned when an attribute schema is malformed
+	ErrInvalidAttributeSchema = errors.New("invalid attribute schema")
+
+	// ErrInvalidFolder is returned when a folder name is invalid or a folder would be nested in itself or too deep
+	ErrInvalidFolder = errors.New("invalid folder")
+
+	// ErrInvalidPrefix is returned when a key prefix has parent directory references or is reserved for the service's own objects
+	ErrInvalidPrefix = errors.New("invalid key prefix")
+
+	// ErrInvalidPattern is returned when an export include or exclude pattern is malformed
+	ErrInvalidPattern = errors.New("invalid pattern")
+
+	// ErrInvalidInventoryFormat is returned when a bucket inventory is requested in an unknown format
+	ErrInvalidInventoryFormat = errors.New("invalid inventory format")
+
+	// ErrInvalidMonth is returned when a report is requested for a month not given as YYYY-MM
+	ErrInvalidMonth = errors.New("invalid month")
+
+	// ErrReportNotFound is returned when no report was made for the requested period
+	ErrReportNotFound = errors.New("report not found")
+
+	// ErrVersionNotFound is returned when a previous version of a file does not exist
+	ErrVersionNotFound = errors.New("version not found")
+
+	// ErrInvalidRestriction is returned when a signed URL restriction is malformed
+	ErrInvalidRestriction = errors.New("invalid link restriction")
+
+	// ErrUnsupportedRestriction is returned when a provider cannot enforce a signed URL restriction
+	ErrUnsupportedRestriction = errors.New("link restriction not supported by provider")
+
+	// ErrShareLinkNotFound is returned when a share link does not exist or has been revoked
+	ErrShareLinkNotFound = errors.New("share link not found")
+
+	// ErrShareLinkExpired is returned when a share link has passed its deadline
+	ErrShareLinkExpired = errors.New("share link expired")
+
+	// ErrShareLinkExhausted is returned when a share link has no downloads left
+	ErrShareLinkExhausted = errors.New("share link download limit reached")
+
+	// ErrShareLinkForbidden is returned when a share link is opened with a wrong password or by a recipient not allowed
+	ErrShareLinkForbidden = errors.New("share link access denied")
+
+	// ErrInvalidVisibility is returned when a visibility other than public or private is requested
+	ErrInvalidVisibility = errors.New("invalid visibility")
+
+	// ErrInvalidThumbnailSize is returned when a thumbnail size that is not served is requested
+	ErrInvalidThumbnailSize = errors.New("invalid thumbnail size")
+
+	// ErrTooManyFiles is returned when a batch request names more files than allowed
+	ErrTooManyFiles = errors.New("too many files")
+
+	// ErrShortLinkNotFound is returned when a short link does not exist or has been revoked
+	ErrShortLinkNotFound = errors.New("short link not found")
+
+	// ErrShortLinkExpired is returned when a short link has passed its deadline
+	ErrShortLinkExpired = errors.New("short link expired")
+
+	// ErrDownloadTokenInvalid is returned when a download token is unknown, expired or revoked
+	ErrDownloadTokenInvalid = errors.New("download token invalid")
+
+	// ErrAPIKeyNotFound is returned when an API key ID does not exist
+	ErrAPIKeyNotFound = errors.New("API key not found")
+
+	// ErrAPIKeyInvalid is returned when a request presents an unknown or replaced API key secret
+	ErrAPIKeyInvalid = errors.New("invalid API key")
+
+	// ErrAPIKeyRevoked is returned when a request presents or rotates a revoked API key
+	ErrAPIKeyRevoked = errors.New("API key revoked")
+
+	// ErrInvalidScope is returned when an API key is issued with an unknown scope
+	ErrInvalidScope = errors.New("invalid scope")
+
+	// ErrNotOwner is returned when a file is accessed by a subject other than its owner
+	ErrNotOwner = errors.New("file belongs to another owner")
+
+	// ErrScannerNotConfigured is returned when a file is rescanned without a malware scanner
+	ErrScannerNotConfigured = errors.New("malware scanner not configured")
+
+	// ErrStatsNotSupported is returned when usage statistics are requested without a metadata store listing files
+	ErrStatsNotSupported = errors.New("usage statistics need a metadata store listing files")
+
+	// ErrSearchNotSupported is returned when files are searched without a metadata store listing files
+	ErrSearchNotSupported = errors.New("search needs a metadata store listing files")
+
+	// ErrFoldersNotSupported is returned when files are moved into folders without a metadata store
+	ErrFoldersNotSupported = errors.New("folders need a metadata store")
+
+	// ErrTagsNotSupported is returned when files are tagged without a metadata store
+	ErrTagsNotSupported = errors.New("tags need a metadata store")
+
+	// ErrAccessNotSupported is returned when file access is managed without a metadata store
+	ErrAccessNotSupported = errors.New("file access control needs a metadata store")
+
+	// ErrExpiryNotSupported is returned when a file is given an expiry without a metadata store
+	ErrExpiryNotSupported = errors.New("file expiry needs a metadata store")
+
+	// ErrReconcileNotSupported is returned when buckets are reconciled without a metadata store listing files
+	ErrReconcileNotSupported = errors.New("reconciliation needs a metadata store listing files")
+
+	// ErrAuditNotConfigured is returned when audit events are queried without an audit log
+	ErrAuditNotConfigured = errors.New("audit log not configured")
+
+	// ErrCDNNotConfigured is returned when CDN cookies are requested for a provider without a configured CDN
+	ErrCDNNotConfigured = errors.New("CDN not configured")
+
+	// ErrDecryptionFailed is returned when encrypted content cannot be decrypted
+	ErrDecryptionFailed = errors.New("failed to decrypt file content")
+)
@@ -0,0 +1,135 @@
+// pkg/storage/exists.go
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Exists reports whether a file is stored with an S3 or GCS provider and
+// returns its basic metadata, reading only the object's attributes
+func (f *FileStorageManager) Exists(provider, fileID, bucketname, projectID string) (*FileInfo, bool, error) {
+	file, err := f.OpenFile(provider, fileID, bucketname, projectID)
+	if errors.Is(err, ErrFileNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	file.Close()
+
+	info := &FileInfo{
+		FileExt:      strings.TrimPrefix(path.Ext(fileID), "."),
+		FileID:       fileID,
+		FileMimeType: file.ContentType(),
+		FileSize:     file.Size(),
+		Tag:          file.ETag(),
+		Timestamp:    file.ModTime(),
+	}
+
+	// Prefer the recorded original name and content size
+	if f.metadataStore != nil {
+		if record, err := f.metadataStore.GetFile(fileID); err == nil && record.Provider == provider {
+			info.FileName = record.FileName
+			info.FileExt = record.FileExt
+			info.FileSize = record.FileSize
+			info.PublicLink = record.PublicLink
+			info.Visibility = record.Visibility
+		}
+	}
+
+	return info, true, nil
+}
+
+// AwsGetFileInfo returns the metadata of a file stored in S3 without
+// downloading it; AwsOpenFile streams its content
+func (f *FileStorageManager) AwsGetFileInfo(awsFileID string, bucketname string) (*FileResponse, error) {
+	if bucketname == "" {
+		bucketname = f.config.AWSBucket
+	}
+	return f.getFileInfo(ProviderAWS, awsFileID, bucketname, "", f.awsPublicURL(bucketname, awsFileID)), nil
+}
+
+// GcsGetFileInfo returns the metadata of a file stored in Google Cloud
+// Storage without downloading it; GcsOpenFile streams its content
+func (f *FileStorageManager) GcsGetFileInfo(gcsFileID string, bucketname string, projectID string) (*FileResponse, error) {
+	if bucketname == "" {
+		bucketname = f.config.GCSBucket
+	}
+	return f.getFileInfo(ProviderGCS, gcsFileID, bucketname, projectID, gcsPublicURL(bucketname, gcsFileID)), nil
+}
+
+// getFileInfo looks up a single file, linking it to publicURL when no
+// public link was recorded
+func (f *FileStorageManager) getFileInfo(provider, fileID, bucketname, projectID, publicURL string) *FileResponse {
+	info, exists, err := f.Exists(provider, fileID, bucketname, projectID)
+	if err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}
+	}
+	if !exists {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: "Object " + fileID + " not found",
+		}
+	}
+
+	if info.PublicLink == "" {
+		info.PublicLink = publicURL
+	}
+	return &FileResponse{
+		Status: StatusSuccess,
+		Info:   info,
+	}
+}
+
+// MaxBatchInfoFiles is the number of files GetFileInfos accepts at once
+const MaxBatchInfoFiles = 100
+
+// GetFileInfos looks up the metadata of several files stored with an S3 or
+// GCS provider concurrently, without transferring their content. Every file
+// gets a result in the order given; missing files get StatusError.
+func (f *FileStorageManager) GetFileInfos(provider string, fileIDs []string, bucketname, projectID string) (*FileResponse, error) {
+	if len(fileIDs) > MaxBatchInfoFiles {
+		return nil, fmt.Errorf("%w: at most %d files can be looked up at once", ErrTooManyFiles, MaxBatchInfoFiles)
+	}
+
+	results := make([]*FileResult, len(fileIDs))
+	missing := f.batchPool().Run(len(fileIDs), func(i int) error {
+		results[i] = f.infoResult(provider, fileIDs[i], bucketname, projectID)
+		return resultErr(results[i])
+	})
+
+	return &FileResponse{
+		Status:  StatusSuccess,
+		Message: fmt.Sprintf("INFO %d of %d files", len(fileIDs)-len(missing), len(fileIDs)),
+		Results: results,
+	}, nil
+}
+
+// infoResult looks up a single file of a batch
+func (f *FileStorageManager) infoResult(provider, fileID, bucketname, projectID string) *FileResult {
+	result := &FileResult{
+		FileID: fileID,
+		Status: StatusError,
+	}
+
+	info, exists, err := f.Exists(provider, fileID, bucketname, projectID)
+	switch {
+	case err != nil:
+		result.Message = err.Error()
+	case !exists:
+		result.Message = ErrFileNotFound.Error()
+	default:
+		result.Status = StatusSuccess
+		result.FileName = info.FileName
+		result.Info = info
+	}
+
+	return result
+}
@@ -0,0 +1,257 @@
+// pkg/storage/expiry.go
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Actions taken on expired files
+const (
+	ExpiryDelete  = "delete"  // Delete the file, to the trash while soft delete is enabled
+	ExpiryArchive = "archive" // Move the file below ArchivePrefix
+)
+
+const (
+	// ArchivePrefix is the key prefix expired files are archived below,
+	// where a bucket lifecycle rule can move them to colder storage
+	ArchivePrefix = ".archive/"
+
+	// expirySweepInterval is how often expired files are deleted or archived
+	expirySweepInterval = 15 * time.Minute
+)
+
+// ExpiryPolicy expires the files below a prefix some time after they were
+// uploaded, e.g. temporary exports a day after they were made
+type ExpiryPolicy struct {
+	Prefix string        // Key prefix, e.g. "exports/"
+	TTL    time.Duration // Time from the last modification of a file to its expiry
+	Action string        // ExpiryDelete (default) or ExpiryArchive
+}
+
+// ArchiveKey returns the key an expired file is archived under
+func ArchiveKey(fileID string) string {
+	return ArchivePrefix + fileID
+}
+
+// ParseExpiryPolicies parses comma separated prefix=ttl[:action] policies,
+// e.g. "exports/=24h,drafts/=720h:archive"
+func ParseExpiryPolicies(value string) ([]ExpiryPolicy, error) {
+	var policies []ExpiryPolicy
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		prefix, rule, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("%w: %q is not prefix=ttl", ErrInvalidExpiry, entry)
+		}
+		ttl, action, _ := strings.Cut(rule, ":")
+
+		duration, err := time.ParseDuration(strings.TrimSpace(ttl))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidExpiry, entry, err)
+		}
+		policies = append(policies, ExpiryPolicy{Prefix: strings.TrimSpace(prefix), TTL: duration, Action: strings.TrimSpace(action)})
+	}
+
+	return policies, checkExpiryPolicies(policies)
+}
+
+// SetExpiryPolicies replaces the policies expiring files by prefix
+func (f *FileStorageManager) SetExpiryPolicies(policies []ExpiryPolicy) error {
+	if err := checkExpiryPolicies(policies); err != nil {
+		return err
+	}
+
+	f.expiryPolicies = policies
+	return nil
+}
+
+// SetFileExpiry sets when a recorded file expires and whether it is then
+// deleted or archived. A zero expiresAt removes the expiry; files below the
+// prefix of an expiry policy then expire by the policy again.
+func (f *FileStorageManager) SetFileExpiry(fileID string, expiresAt time.Time, action string) (*FileRecord, error) {
+	if f.metadataStore == nil {
+		return nil, ErrExpiryNotSupported
+	}
+
+	action, err := checkExpiryAction(action)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.IsZero() {
+		action = ""
+	} else if !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expiry must be in the future", ErrInvalidExpiry)
+	}
+
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	record, err := f.GetFileRecord(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if inTrash(fileID) || inArchive(fileID) {
+		return nil, fmt.Errorf("%w: %s has been deleted or archived", ErrInvalidExpiry, fileID)
+	}
+
+	record.ExpiresAt = expiresAt
+	record.ExpiryAction = action
+	if err := f.saveRecord(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// ExpireFiles deletes or archives the files whose expiry has passed: the
+// recorded files given an expiry of their own, then the files below the
+// prefix of an expiry policy in the configured buckets. Records of expired
+// files kept in the trash or the archive are marked with ExpiredAt; deletes
+// are audited. It returns how many files expired.
+func (f *FileStorageManager) ExpireFiles() (int, error) {
+	now := time.Now()
+	expired := 0
+
+	if lister, ok := f.metadataStore.(FileLister); ok {
+		records, err := lister.ListFiles()
+		if err != nil {
+			return 0, err
+		}
+
+		for _, record := range records {
+			if record.ExpiresAt.IsZero() || record.ExpiresAt.After(now) || record.UploadStatus == UploadPending ||
+				inTrash(record.FileID) || inArchive(record.FileID) {
+				continue
+			}
+			if err := f.expireFile(record.Provider, record.FileID, record.Bucket, record.ExpiryAction); err != nil {
+				return expired, fmt.Errorf("%s: %w", record.FileID, err)
+			}
+			expired++
+		}
+	}
+
+	for _, policy := range f.expiryPolicies {
+		deadline := now.Add(-policy.TTL)
+
+		for _, provider := range []string{ProviderAWS, ProviderGCS} {
+			bucketname := f.defaultBucket(provider)
+			if bucketname == "" {
+				continue
+			}
+
+			var keys []string
+			err := f.walkPrefix(provider, policy.Prefix, bucketname, "", func(object ObjectInfo) error {
+				if object.ModTime.Before(deadline) && !inTrash(object.Key) && !inArchive(object.Key) {
+					keys = append(keys, object.Key)
+				}
+				return nil
+			})
+			if err != nil {
+				return expired, fmt.Errorf("%s: %w", provider, err)
+			}
+
+			for _, key := range keys {
+				// Files given an expiry of their own keep it
+				if record := f.storedRecord(provider, key); record != nil && !record.ExpiresAt.IsZero() {
+					continue
+				}
+				if err := f.expireFile(provider, key, bucketname, policy.Action); err != nil {
+					return expired, fmt.Errorf("%s: %s: %w", provider, key, err)
+				}
+				expired++
+			}
+		}
+	}
+
+	return expired, nil
+}
+
+// expireFile deletes or archives an expired file and marks its record
+func (f *FileStorageManager) expireFile(provider, fileID, bucketname, action string) error {
+	if bucketname == "" {
+		bucketname = f.defaultBucket(provider)
+	}
+
+	if action == ExpiryArchive {
+		if err := f.moveObject(provider, fileID, ArchiveKey(fileID), bucketname, ""); err != nil {
+			return err
+		}
+		f.moveRecord(fileID, ArchiveKey(fileID))
+		f.markExpired(ArchiveKey(fileID))
+		return nil
+	}
+
+	trash := f.softDelete(fileID)
+	response, err := f.deleteFile(provider, fileID, bucketname, "", trash)
+	if err == nil && response.Status != StatusSuccess {
+		err = errors.New(response.Message)
+	}
+	if err != nil {
+		return err
+	}
+	if trash {
+		f.markExpired(TrashKey(fileID))
+	}
+
+	if err := f.RecordAudit(AuditEvent{Action: AuditDelete, Provider: provider, FileID: fileID, Via: "expiry", Status: http.StatusOK}); err != nil {
+		log.Printf("filestorage: recording the expiry of %s in the audit log failed: %v", fileID, err)
+	}
+	return nil
+}
+
+// markExpired stamps the record of an expired file with the time it expired
+func (f *FileStorageManager) markExpired(fileID string) {
+	if f.metadataStore == nil {
+		return
+	}
+
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if err != nil {
+		return
+	}
+	record.ExpiredAt = time.Now()
+	f.saveRecord(record)
+}
+
+// checkExpiryPolicies rejects policies without a prefix or TTL, or with an
+// unknown action
+func checkExpiryPolicies(policies []ExpiryPolicy) error {
+	for _, policy := range policies {
+		if strings.Trim(policy.Prefix, "/") == "" || policy.TTL <= 0 {
+			return fmt.Errorf("%w: policies need a prefix and a positive TTL", ErrInvalidExpiry)
+		}
+		if _, err := checkExpiryAction(policy.Action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkExpiryAction returns the action taken on expired files, ExpiryDelete
+// unless one is given
+func checkExpiryAction(action string) (string, error) {
+	switch action {
+	case "", ExpiryDelete:
+		return ExpiryDelete, nil
+	case ExpiryArchive:
+		return action, nil
+	}
+	return "", fmt.Errorf("%w: unknown action %q", ErrInvalidExpiry, action)
+}
+
+// inArchive reports whether a file ID is the key of an archived file
+func inArchive(fileID string) bool {
+	return strings.HasPrefix(fileID, ArchivePrefix)
+}
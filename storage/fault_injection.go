@@ -0,0 +1,231 @@
+//go:build faultinject
+
+// pkg/storage/fault_injection.go
+//
+// Fault injection is for integration tests only: build with -tags
+// faultinject to make the requests to the providers slow, fail or break off
+// on purpose, and check retries and error handling without touching real
+// buckets. Production builds have no way to turn it on.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault is the error of requests failed by fault injection
+var ErrInjectedFault = errors.New("injected fault")
+
+// Faults are injected into the requests to a provider. Rates are fractions
+// of the requests between 0 and 1, rolled independently for each request.
+type Faults struct {
+	Latency      time.Duration // Added to every request
+	Jitter       time.Duration // Random latency of up to this much added on top
+	ErrorRate    float64       // Requests failing with Error before reaching the provider
+	Error        error         // Error of failed requests, ErrInjectedFault if nil
+	StatusRate   float64       // Requests answered with Status without reaching the provider
+	Status       int           // Status code of answered requests, 503 if 0
+	PartialRate  float64       // Requests whose body, or response body if they have none, breaks off
+	PartialBytes int64         // Bytes of a broken off body that get through, half of it if 0
+}
+
+// faultState holds the faults injected per provider
+type faultState struct {
+	mu     sync.Mutex
+	faults map[string]*Faults // By provider
+}
+
+// InjectFaults injects faults into the requests to a provider, ProviderREST,
+// ProviderAWS or ProviderGCS, including those of clients already made. nil
+// stops injecting faults.
+func (f *FileStorageManager) InjectFaults(provider string, faults *Faults) {
+	f.faults.mu.Lock()
+	defer f.faults.mu.Unlock()
+
+	if f.faults.faults == nil {
+		f.faults.faults = make(map[string]*Faults)
+	}
+	if faults == nil {
+		delete(f.faults.faults, provider)
+		return
+	}
+	injected := *faults
+	f.faults.faults[provider] = &injected
+}
+
+// ParseFaults parses comma separated name=value settings of Faults, e.g.
+// "latency=200ms,error_rate=0.1,status_rate=0.05,status=500"
+func ParseFaults(value string) (*Faults, error) {
+	faults := &Faults{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		name, setting, _ := strings.Cut(entry, "=")
+		setting = strings.TrimSpace(setting)
+
+		var err error
+		switch strings.TrimSpace(name) {
+		case "latency":
+			faults.Latency, err = time.ParseDuration(setting)
+		case "jitter":
+			faults.Jitter, err = time.ParseDuration(setting)
+		case "error_rate":
+			faults.ErrorRate, err = strconv.ParseFloat(setting, 64)
+		case "status_rate":
+			faults.StatusRate, err = strconv.ParseFloat(setting, 64)
+		case "status":
+			faults.Status, err = strconv.Atoi(setting)
+		case "partial_rate":
+			faults.PartialRate, err = strconv.ParseFloat(setting, 64)
+		case "partial_bytes":
+			faults.PartialBytes, err = strconv.ParseInt(setting, 10, 64)
+		default:
+			err = errors.New("unknown setting")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault %q: %v", entry, err)
+		}
+	}
+	return faults, nil
+}
+
+// injectedFaults returns the faults injected into the requests to a provider
+func (f *FileStorageManager) injectedFaults(provider string) *Faults {
+	f.faults.mu.Lock()
+	defer f.faults.mu.Unlock()
+
+	return f.faults.faults[provider]
+}
+
+// faultTransport injects the faults of a provider into its requests
+type faultTransport struct {
+	provider string
+	base     http.RoundTripper
+	manager  *FileStorageManager
+}
+
+// decorateTransport injects the faults of a provider into the requests of
+// its transport
+func (f *FileStorageManager) decorateTransport(provider string, rt http.RoundTripper) http.RoundTripper {
+	return &faultTransport{provider: provider, base: rt, manager: f}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	faults := t.manager.injectedFaults(t.provider)
+	if faults == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	delay := faults.Latency
+	if faults.Jitter > 0 {
+		delay += rand.N(faults.Jitter)
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			closeBody(req)
+			return nil, req.Context().Err()
+		}
+	}
+
+	if rand.Float64() < faults.ErrorRate {
+		closeBody(req)
+		if faults.Error != nil {
+			return nil, faults.Error
+		}
+		return nil, fmt.Errorf("%w: %s %s", ErrInjectedFault, req.Method, req.URL.Host)
+	}
+
+	if rand.Float64() < faults.StatusRate {
+		closeBody(req)
+		status := faults.Status
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode: status,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+
+	if rand.Float64() >= faults.PartialRate {
+		return t.base.RoundTrip(req)
+	}
+
+	// Break off the upload, or else the download, part way
+	if req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0 {
+		req = req.Clone(req.Context())
+		req.Body = &partialBody{ReadCloser: req.Body, remaining: partialBytes(faults, req.ContentLength)}
+		req.GetBody = nil
+		return t.base.RoundTrip(req)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &partialBody{ReadCloser: resp.Body, remaining: partialBytes(faults, resp.ContentLength)}
+	return resp, nil
+}
+
+// CloseIdleConnections closes the idle connections of the decorated transport
+func (t *faultTransport) CloseIdleConnections() {
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// partialBody is a body failing once some of it has been read
+type partialBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+// Read implements io.Reader
+func (b *partialBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, fmt.Errorf("%w: body broken off", ErrInjectedFault)
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// partialBytes returns the bytes of a body of the given length that get
+// through before it breaks off
+func partialBytes(faults *Faults, length int64) int64 {
+	if faults.PartialBytes > 0 || length <= 0 {
+		return faults.PartialBytes
+	}
+	return length / 2
+}
+
+// closeBody closes the body of a request that is not sent, as RoundTrip must
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !faultinject
+
+// pkg/storage/fault_injection_disabled.go
+
+package storage
+
+import "net/http"
+
+// faultState is empty unless built with the faultinject tag, see
+// fault_injection.go
+type faultState struct{}
+
+// decorateTransport returns the transport of a provider unchanged, as faults
+// are only injected in builds with the faultinject tag
+func (f *FileStorageManager) decorateTransport(provider string, rt http.RoundTripper) http.RoundTripper {
+	return rt
+}
@@ -0,0 +1,90 @@
+// pkg/storage/file_audit_log.go
+
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// FileAuditLog implements an audit log appended to a file as JSON lines,
+// which is only ever opened for appending so recorded events are not
+// rewritten. Rotate or archive the file with external tools.
+type FileAuditLog struct {
+	path string
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewFileAuditLog opens the audit log at path, creating the file if it does
+// not exist
+func NewFileAuditLog(path string) (*FileAuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditLog{path: path, file: file}, nil
+}
+
+// AppendAuditEvent writes an event to the end of the log and flushes it to disk
+func (l *FileAuditLog) AppendAuditEvent(event *AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// QueryAuditEvents reads the log for the events matching a query, newest
+// first. Lines that cannot be parsed, such as one torn by a crash, are skipped.
+func (l *FileAuditLog) QueryAuditEvents(query AuditQuery) ([]*AuditEvent, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Keep the last query.Limit matches in a ring
+	ring := make([]*AuditEvent, query.Limit)
+	matches := 0
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if query.Matches(&event) {
+			ring[matches%query.Limit] = &event
+			matches++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	events := []*AuditEvent{}
+	for i := matches - 1; i >= 0 && i >= matches-query.Limit; i-- {
+		events = append(events, ring[i%query.Limit])
+	}
+	return events, nil
+}
+
+// Close closes the log file
+func (l *FileAuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
@@ -0,0 +1,115 @@
+// pkg/storage/file_filter.go
+
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultDeniedExtensions lists executable and script types rejected when no deny list is configured
+var DefaultDeniedExtensions = []string{
+	"exe", "msi", "com", "scr", "dll", "bat", "cmd", "ps1", "vbs", "sh", "jar", "apk", "app",
+}
+
+// FileFilter holds MIME type and extension allow/deny lists for uploads.
+// Deny lists always win; an empty allow list permits everything not denied.
+// MIME entries may use a wildcard subtype, e.g. "image/*".
+type FileFilter struct {
+	AllowedMimeTypes  []string
+	DeniedMimeTypes   []string
+	AllowedExtensions []string
+	DeniedExtensions  []string
+}
+
+// NewFileFilter creates a file filter from the configuration
+func NewFileFilter(config *Config) *FileFilter {
+	deniedExtensions := config.DeniedExtensions
+	if deniedExtensions == nil {
+		deniedExtensions = DefaultDeniedExtensions
+	}
+
+	return &FileFilter{
+		AllowedMimeTypes:  config.AllowedMimeTypes,
+		DeniedMimeTypes:   config.DeniedMimeTypes,
+		AllowedExtensions: config.AllowedExtensions,
+		DeniedExtensions:  deniedExtensions,
+	}
+}
+
+// Check validates a filename and MIME type against the filter lists
+func (ff *FileFilter) Check(filename, mimetype string) error {
+	if err := ff.CheckExtension(strings.TrimPrefix(filepath.Ext(filename), ".")); err != nil {
+		return err
+	}
+	return ff.CheckMimeType(mimetype)
+}
+
+// CheckExtension validates a file extension, without the leading dot, against the extension lists
+func (ff *FileFilter) CheckExtension(extension string) error {
+	if ff == nil {
+		return nil
+	}
+
+	extension = strings.ToLower(extension)
+	if matchExtension(ff.DeniedExtensions, extension) {
+		return fmt.Errorf("%w: extension %q", ErrFileTypeNotAllowed, extension)
+	}
+
+	if len(ff.AllowedExtensions) > 0 && !matchExtension(ff.AllowedExtensions, extension) {
+		return fmt.Errorf("%w: extension %q", ErrFileTypeNotAllowed, extension)
+	}
+
+	return nil
+}
+
+// CheckMimeType validates a MIME type against the MIME type lists
+func (ff *FileFilter) CheckMimeType(mimetype string) error {
+	if ff == nil {
+		return nil
+	}
+
+	mimetype = normalizeMimeType(mimetype)
+	if matchMimeType(ff.DeniedMimeTypes, mimetype) {
+		return fmt.Errorf("%w: mime type %q", ErrFileTypeNotAllowed, mimetype)
+	}
+
+	if len(ff.AllowedMimeTypes) > 0 && !matchMimeType(ff.AllowedMimeTypes, mimetype) {
+		return fmt.Errorf("%w: mime type %q", ErrFileTypeNotAllowed, mimetype)
+	}
+
+	return nil
+}
+
+// normalizeMimeType lowercases a MIME type and strips any parameters
+func normalizeMimeType(mimetype string) string {
+	if i := strings.Index(mimetype, ";"); i >= 0 {
+		mimetype = mimetype[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(mimetype))
+}
+
+// matchExtension reports whether extension is in list
+func matchExtension(list []string, extension string) bool {
+	for _, entry := range list {
+		if strings.ToLower(strings.TrimPrefix(entry, ".")) == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMimeType reports whether mimetype matches an entry in list, honouring "type/*" wildcards
+func matchMimeType(list []string, mimetype string) bool {
+	for _, entry := range list {
+		entry = normalizeMimeType(entry)
+		if entry == mimetype || entry == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(entry, "/*") && strings.HasPrefix(mimetype, strings.TrimSuffix(entry, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,395 @@
+// pkg/storage/file_metadata_store.go
+
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Operations of the journal kept by FileMetadataStore
+const (
+	journalSave         = "save"
+	journalDelete       = "delete"
+	journalSaveFolder   = "save_folder"
+	journalDeleteFolder = "delete_folder"
+	journalSaveLink     = "save_share_link"
+	journalConsumeLink  = "consume_share_link"
+	journalDeleteLink   = "delete_share_link"
+	journalSaveKey      = "save_api_key"
+)
+
+// journalEntry is a line of the FileMetadataStore journal
+type journalEntry struct {
+	Op       string            `json:"op"`
+	FileID   string            `json:"file_id,omitempty"`
+	Record   *FileRecord       `json:"record,omitempty"`
+	FolderID string            `json:"folder_id,omitempty"`
+	Folder   *Folder           `json:"folder,omitempty"`
+	Token    string            `json:"token,omitempty"`
+	Link     *journalShareLink `json:"share_link,omitempty"`
+	Key      *journalAPIKey    `json:"api_key,omitempty"`
+}
+
+// journalShareLink is a share link in the journal, which unlike its API
+// representation keeps the password hash
+type journalShareLink struct {
+	ShareLink
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// journalAPIKey is an API key in the journal, which unlike its API
+// representation keeps the hashes of its secrets
+type journalAPIKey struct {
+	APIKey
+	Hash         string `json:"hash"`
+	PreviousHash string `json:"previous_hash,omitempty"`
+}
+
+// newJournalAPIKey wraps an API key for the journal
+func newJournalAPIKey(key *APIKey) *journalAPIKey {
+	return &journalAPIKey{APIKey: *key, Hash: key.Hash, PreviousHash: key.PreviousHash}
+}
+
+// apiKey returns the API key kept in the journal
+func (k *journalAPIKey) apiKey() *APIKey {
+	key := k.APIKey
+	key.Hash, key.PreviousHash = k.Hash, k.PreviousHash
+	return &key
+}
+
+// newJournalShareLink wraps a share link for the journal
+func newJournalShareLink(link *ShareLink) *journalShareLink {
+	return &journalShareLink{ShareLink: *link, PasswordHash: link.PasswordHash}
+}
+
+// shareLink returns the share link kept in the journal
+func (l *journalShareLink) shareLink() *ShareLink {
+	link := l.ShareLink
+	link.PasswordHash = l.PasswordHash
+	return &link
+}
+
+// FileMetadataStore implements an embedded metadata store for single-node
+// and development deployments, which keeps its records and folders in
+// memory and appends every change to a journal file, so they survive
+// restarts without a database. It also keeps share links and API keys. The journal is compacted when the store is
+// opened.
+type FileMetadataStore struct {
+	records *MemoryMetadataStore
+	folders *MemoryFolderStore
+	links   *MemoryShareLinkStore
+	keys    *MemoryAPIKeyStore
+	journal *os.File
+	mu      sync.Mutex
+}
+
+// NewFileMetadataStore opens the metadata store journaled to path, creating
+// the file if it does not exist
+func NewFileMetadataStore(path string) (*FileMetadataStore, error) {
+	store := &FileMetadataStore{
+		records: NewMemoryMetadataStore(),
+		folders: NewMemoryFolderStore(),
+		links:   NewMemoryShareLinkStore(),
+		keys:    NewMemoryAPIKeyStore(),
+	}
+	if err := store.replayJournal(path); err != nil {
+		return nil, err
+	}
+	if err := store.compactJournal(path); err != nil {
+		return nil, err
+	}
+
+	journal, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	store.journal = journal
+
+	return store, nil
+}
+
+// SaveFile inserts or replaces a file record
+func (s *FileMetadataStore) SaveFile(record *FileRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(journalEntry{Op: journalSave, Record: record}); err != nil {
+		return err
+	}
+	return s.records.SaveFile(record)
+}
+
+// GetFile retrieves a file record by ID
+func (s *FileMetadataStore) GetFile(fileID string) (*FileRecord, error) {
+	return s.records.GetFile(fileID)
+}
+
+// FindByHash retrieves the file records with a content hash within a provider bucket, oldest first
+func (s *FileMetadataStore) FindByHash(provider, bucket, sha256 string) ([]*FileRecord, error) {
+	return s.records.FindByHash(provider, bucket, sha256)
+}
+
+// DeleteFile removes a file record
+func (s *FileMetadataStore) DeleteFile(fileID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(journalEntry{Op: journalDelete, FileID: fileID}); err != nil {
+		return err
+	}
+	return s.records.DeleteFile(fileID)
+}
+
+// ListFiles returns every file record
+func (s *FileMetadataStore) ListFiles() ([]*FileRecord, error) {
+	return s.records.ListFiles()
+}
+
+// SaveFolder inserts or replaces a folder
+func (s *FileMetadataStore) SaveFolder(folder *Folder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(journalEntry{Op: journalSaveFolder, Folder: folder}); err != nil {
+		return err
+	}
+	return s.folders.SaveFolder(folder)
+}
+
+// GetFolder retrieves a folder by ID
+func (s *FileMetadataStore) GetFolder(id string) (*Folder, error) {
+	return s.folders.GetFolder(id)
+}
+
+// DeleteFolder removes a folder
+func (s *FileMetadataStore) DeleteFolder(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(journalEntry{Op: journalDeleteFolder, FolderID: id}); err != nil {
+		return err
+	}
+	return s.folders.DeleteFolder(id)
+}
+
+// ListFolders returns the folders in a parent folder by name
+func (s *FileMetadataStore) ListFolders(parentID string) ([]*Folder, error) {
+	return s.folders.ListFolders(parentID)
+}
+
+// SaveShareLink inserts or replaces a share link
+func (s *FileMetadataStore) SaveShareLink(link *ShareLink) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(journalEntry{Op: journalSaveLink, Link: newJournalShareLink(link)}); err != nil {
+		return err
+	}
+	return s.links.SaveShareLink(link)
+}
+
+// GetShareLink retrieves a share link by token
+func (s *FileMetadataStore) GetShareLink(token string) (*ShareLink, error) {
+	return s.links.GetShareLink(token)
+}
+
+// ConsumeShareLink counts a download of a share link
+func (s *FileMetadataStore) ConsumeShareLink(token string) (*ShareLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, err := s.links.GetShareLink(token)
+	if err != nil {
+		return nil, err
+	}
+	if link.exhausted() {
+		return nil, ErrShareLinkExhausted
+	}
+
+	if err := s.append(journalEntry{Op: journalConsumeLink, Token: token}); err != nil {
+		return nil, err
+	}
+	return s.links.ConsumeShareLink(token)
+}
+
+// DeleteShareLink removes a share link
+func (s *FileMetadataStore) DeleteShareLink(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(journalEntry{Op: journalDeleteLink, Token: token}); err != nil {
+		return err
+	}
+	return s.links.DeleteShareLink(token)
+}
+
+// ListShareLinks returns the share links to a file, or all share links when fileID is ""
+func (s *FileMetadataStore) ListShareLinks(fileID string) ([]*ShareLink, error) {
+	return s.links.ListShareLinks(fileID)
+}
+
+// SaveAPIKey inserts or replaces an API key. Only the hashes of its secrets
+// are journaled.
+func (s *FileMetadataStore) SaveAPIKey(key *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(journalEntry{Op: journalSaveKey, Key: newJournalAPIKey(key)}); err != nil {
+		return err
+	}
+	return s.keys.SaveAPIKey(key)
+}
+
+// GetAPIKey retrieves an API key by ID
+func (s *FileMetadataStore) GetAPIKey(id string) (*APIKey, error) {
+	return s.keys.GetAPIKey(id)
+}
+
+// ListAPIKeys returns all API keys
+func (s *FileMetadataStore) ListAPIKeys() ([]*APIKey, error) {
+	return s.keys.ListAPIKeys()
+}
+
+// Close closes the journal file
+func (s *FileMetadataStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journal.Close()
+}
+
+// append writes an entry to the journal and flushes it to disk
+func (s *FileMetadataStore) append(entry journalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.journal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.journal.Sync()
+}
+
+// replayJournal loads the records, folders, share links and API keys of a
+// journal. A torn last line, left by a crash while writing it, is ignored.
+func (s *FileMetadataStore) replayJournal(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for number := 1; ; number++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		torn := err == io.EOF
+
+		var entry journalEntry
+		if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+			if torn {
+				return nil
+			}
+			return fmt.Errorf("%s:%d: %w", path, number, jsonErr)
+		}
+
+		switch {
+		case entry.Op == journalSave && entry.Record != nil:
+			s.records.SaveFile(entry.Record)
+		case entry.Op == journalDelete:
+			s.records.DeleteFile(entry.FileID)
+		case entry.Op == journalSaveFolder && entry.Folder != nil:
+			s.folders.SaveFolder(entry.Folder)
+		case entry.Op == journalDeleteFolder:
+			s.folders.DeleteFolder(entry.FolderID)
+		case entry.Op == journalSaveLink && entry.Link != nil:
+			s.links.SaveShareLink(entry.Link.shareLink())
+		case entry.Op == journalConsumeLink:
+			s.links.ConsumeShareLink(entry.Token)
+		case entry.Op == journalDeleteLink:
+			s.links.DeleteShareLink(entry.Token)
+		case entry.Op == journalSaveKey && entry.Key != nil:
+			s.keys.SaveAPIKey(entry.Key.apiKey())
+		}
+
+		if torn {
+			return nil
+		}
+	}
+}
+
+// compactJournal rewrites a journal with a single entry per record, folder,
+// share link and API key, replacing the old one only once the new one is on disk
+func (s *FileMetadataStore) compactJournal(path string) error {
+	list, err := s.records.ListFiles()
+	if err != nil {
+		return err
+	}
+	links, err := s.links.ListShareLinks("")
+	if err != nil {
+		return err
+	}
+	keys, err := s.keys.ListAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	entries := make([]journalEntry, 0, len(list)+len(s.folders.folders)+len(links)+len(keys))
+	for _, record := range list {
+		entries = append(entries, journalEntry{Op: journalSave, Record: record})
+	}
+	for _, folder := range s.folders.folders {
+		folder := folder
+		entries = append(entries, journalEntry{Op: journalSaveFolder, Folder: &folder})
+	}
+	for _, link := range links {
+		entries = append(entries, journalEntry{Op: journalSaveLink, Link: newJournalShareLink(link)})
+	}
+	for _, key := range keys {
+		entries = append(entries, journalEntry{Op: journalSaveKey, Key: newJournalAPIKey(key)})
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
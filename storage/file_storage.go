@@ -6,23 +6,26 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/google/uuid"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 const (
@@ -32,28 +35,47 @@ const (
 
 // FileInfo represents information about a stored file
 type FileInfo struct {
-	FileExt      string    `json:"file_ext"`
-	FileID       string    `json:"file_id"`
-	FileMimeType string    `json:"file_mimetype"`
-	FileName     string    `json:"file_name"`
-	FileSize     int64     `json:"file_size"`
-	PublicLink   string    `json:"public_link"`
-	Tag          string    `json:"tag"`
-	Timestamp    time.Time `json:"timestamp"`
-	Bucket       string    `json:"bucket,omitempty"`
+	FileExt       string      `json:"file_ext"`
+	FileID        string      `json:"file_id"`
+	FileMimeType  string      `json:"file_mimetype"`
+	FileName      string      `json:"file_name"`
+	FileSize      int64       `json:"file_size"`
+	PublicLink    string      `json:"public_link"`
+	Tag           string      `json:"tag"`
+	Timestamp     time.Time   `json:"timestamp"`
+	Bucket        string      `json:"bucket,omitempty"`
+	MD5           string      `json:"md5,omitempty"`
+	SHA256        string      `json:"sha256,omitempty"`
+	Thumbnails    []Thumbnail `json:"thumbnails,omitempty"`
+	ScanStatus    string      `json:"scan_status,omitempty"`
+	ScanSignature string      `json:"scan_signature,omitempty"`
+	PreviewLink   string      `json:"preview_link,omitempty"` // Available once the background render has finished
+	Renditions    []Rendition `json:"renditions,omitempty"`
+	Visibility    string      `json:"visibility,omitempty"`
+	Owner         string      `json:"owner,omitempty"`
+	ExpiresAt     time.Time   `json:"expires_at,omitempty"`
+	Attributes    Attributes  `json:"attributes,omitempty"`
 }
 
 // FileResponse represents a standard response for file operations
 type FileResponse struct {
-	Status     string    `json:"status"`
-	Message    string    `json:"message,omitempty"`
-	Data       string    `json:"data,omitempty"`
-	FileID     string    `json:"file_id,omitempty"`
-	Info       *FileInfo `json:"info,omitempty"`
-	URL        string    `json:"url,omitempty"`
-	ExpiredAt  time.Time `json:"expired_at,omitempty"`
-	StringData string    `json:"string_data,omitempty"`
-	StreamData io.Reader `json:"-"`
+	Status     string        `json:"status"`
+	Message    string        `json:"message,omitempty"`
+	FileID     string        `json:"file_id,omitempty"`
+	Info       *FileInfo     `json:"info,omitempty"`
+	Files      []*FileInfo   `json:"files,omitempty"`
+	Results    []*FileResult `json:"results,omitempty"`
+	URL        string        `json:"url,omitempty"`
+	ExpiredAt  time.Time     `json:"expired_at,omitempty"`
+	StringData string        `json:"string_data,omitempty"`
+	StreamData io.Reader     `json:"-"`
+
+	// Data is the base64 encoded content of a file.
+	//
+	// Deprecated: it holds the whole file in memory several times over; use
+	// AwsGetFileInfo or GcsGetFileInfo for the metadata of a file and
+	// AwsOpenFile or GcsOpenFile to stream its content.
+	Data string `json:"data,omitempty"`
 }
 
 // TokenManager handles token operations
@@ -65,33 +87,261 @@ type TokenManager interface {
 
 // FileStorageManager manages file storage operations
 type FileStorageManager struct {
-	tokenManager TokenManager
-	maxRetry     int
-	config       *Config
+	tokenManager       TokenManager
+	maxRetry           int
+	maxUploadSize      atomic.Int64
+	uploadMemoryLimit  int64
+	stagingDir         string
+	fileFilter         *FileFilter
+	compressor         Compressor
+	compressionErr     error
+	encryptor          *Encryptor
+	encryptionErr      error
+	preserveFilenames  bool
+	versioning         bool
+	metadataStore      MetadataStore
+	deduplicate        bool
+	thumbnails         *ThumbnailGenerator
+	imagePipeline      *ImagePipeline
+	imagePipelineErr   error
+	stripImageMetadata bool
+	scanner            Scanner
+	scanAction         string
+	previews           *PreviewGenerator
+	uploadHooks        []PostUploadHook
+	metadataHooks      []MetadataHooks
+	hookSlots          chan struct{}
+	archiveLimits      ArchiveLimits
+	uploadConcurrency  int
+	batchConcurrency   int
+	downloadWorkers    int
+	downloadPartSize   int64
+	compositeThreshold int64
+	compositeWorkers   int
+	compositePartSize  int64
+	readAheadWindow    int64
+	throttle           *Throttle
+	restClient         *http.Client
+	faults             faultState
+	providerSlots      *providerSlots
+	memoryBudget       *memoryBudget
+	hedging            *hedgeState
+	sessionStore       UploadSessionStore
+	sessionTTL         time.Duration
+	trashRetention     time.Duration
+	expiryPolicies     []ExpiryPolicy
+	attributeSchema    AttributeSchema
+	reconcile          reconcileState
+	usageStats         *usageCache
+	tenantUsage        *tenantCounters
+	recordLock         sync.Mutex
+	deleting           map[string]bool // Files whose object is being deleted, guarded by recordLock
+	jobs               *jobQueue
+	shareStore         ShareLinkStore
+	folderStore        FolderStore
+	auditLog           AuditLog
+	shortStore         ShortLinkStore
+	apiKeyStore        APIKeyStore
+	tokenCache         Cache
+	metrics            *Metrics
+	bucketChecks       *checkResults
+	existsCache        Cache
+	ownCaches          []*MemoryCache
+	awsClients         *awsClients
+	closeOnce          sync.Once
+	scheduler          *scheduler
+	cdnSigner          *CDNSigner
+	cdnSignerErr       error
+	cloudCDNSigner     *CloudCDNSigner
+	cloudCDNSignerErr  error
+	config             *Config
 }
 
 // Config holds configuration for file storage
 type Config struct {
-	HostURI                string
-	AuthorizationServerURI string
-	ClientID               string
-	ClientSecret           string
-	AWSKey                 string
-	AWSSecret              string
-	AWSRegion              string
-	AWSBucket              string
-	GCSKeyPath             string
-	GCSProjectID           string
-	GCSBucket              string
+	HostURI                  string
+	AuthorizationServerURI   string
+	ClientID                 string
+	ClientSecret             string
+	AWSKey                   string
+	AWSSecret                string
+	AWSRegion                string
+	AWSBucket                string
+	GCSKeyPath               string
+	GCSProjectID             string
+	GCSBucket                string
+	MaxUploadSize            int64            // Maximum upload size in bytes, 0 means unlimited
+	RouteMaxUploadSizes      map[string]int64 // Per-route limits the router enforces instead of MaxUploadSize, see MaxUploadSizeFor
+	UploadMemoryLimit        int64            // S3/GCS uploads above this size are staged in a temp file, 0 falls back to DefaultUploadMemoryLimit, -1 never stages
+	UploadStagingDir         string           // Directory uploads are staged in, "" for the default temp directory
+	AttributeSchema          AttributeSchema  // Custom attributes uploads are validated against, nil records any
+	AllowedMimeTypes         []string
+	DeniedMimeTypes          []string
+	AllowedExtensions        []string
+	DeniedExtensions         []string       // nil falls back to DefaultDeniedExtensions
+	RejectMimeMismatch       bool           // Reject uploads whose claimed type disagrees with the sniffed type
+	PreserveFilenames        bool           // Use the sanitized original filename as the object key instead of a UUID
+	DisableDeduplication     bool           // Store every upload even when identical content already exists
+	FileVersioning           bool           // Keep the previous version of files replaced by uploads
+	EncryptionKey            []byte         // 32 byte master key for client-side encryption
+	EncryptionKMSKeyID       string         // AWS KMS key wrapping data keys, takes precedence over EncryptionKey
+	Compression              string         // Content-Encoding applied to compressible S3/GCS uploads, "" or "gzip"
+	CompressibleTypes        []string       // nil falls back to DefaultCompressibleTypes
+	ThumbnailSizes           []int          // Longest side in pixels of the thumbnails generated for S3/GCS image uploads
+	ImageMaxWidth            int            // Image uploads are scaled down to fit, 0 means unconstrained
+	ImageMaxHeight           int            // Image uploads are scaled down to fit, 0 means unconstrained
+	ImageFormat              string         // Content type image uploads are converted to, "" keeps the original format
+	ImageQuality             int            // JPEG quality of processed images, 0 falls back to DefaultJPEGQuality
+	StripImageMetadata       bool           // Remove EXIF/GPS metadata from JPEG and PNG uploads before storing them
+	ClamAVAddress            string         // clamd address scanning every upload, e.g. "tcp://clamd:3310"
+	ScanAction               string         // ScanActionReject (default) or ScanActionFlag for infected uploads
+	PreviewSize              int            // Longest side in pixels of document previews for S3/GCS uploads, 0 disables them
+	VideoTranscoding         bool           // Transcode S3/GCS video uploads to MP4 with ffmpeg in the background
+	VideoHLS                 bool           // Also produce HLS renditions of transcoded videos
+	ArchiveMaxEntries        int            // Maximum files extracted from an uploaded archive, 0 falls back to DefaultArchiveLimits
+	ArchiveMaxEntrySize      int64          // Maximum uncompressed size of an archive entry, 0 falls back to DefaultArchiveLimits
+	ArchiveMaxTotalSize      int64          // Maximum uncompressed size of an archive, 0 falls back to DefaultArchiveLimits
+	UploadConcurrency        int            // Files uploaded at the same time by UploadMany, 0 falls back to DefaultUploadConcurrency
+	BatchConcurrency         int            // Files deleted or looked up at the same time by batch requests, 0 falls back to DefaultBatchConcurrency
+	DownloadConcurrency      int            // Ranges fetched at the same time by DownloadLarge, 0 falls back to DefaultDownloadConcurrency
+	DownloadPartSize         int64          // Size of the ranges fetched by DownloadLarge, 0 falls back to DefaultDownloadPartSize
+	GCSCompositeThreshold    int64          // GCS uploads of at least this size are sent in parallel parts composed into the object, 0 disables it
+	GCSCompositeConcurrency  int            // Parts of a composite GCS upload sent at the same time, 0 falls back to DefaultCompositeConcurrency
+	GCSCompositePartSize     int64          // Size of the parts of a composite GCS upload, 0 falls back to DefaultCompositePartSize
+	ReadAheadWindow          int64          // Bytes of provider streams read ahead of the client by downloads and archives, 0 disables it
+	BandwidthLimit           int64          // Bytes per second shared by all transfers, 0 means unlimited
+	TransferBandwidthLimit   int64          // Bytes per second of a single transfer, 0 means unlimited
+	UploadSessionTTL         time.Duration  // Time a chunked upload may take, 0 falls back to DefaultUploadSessionTTL
+	TrashRetention           time.Duration  // Deleted S3/GCS files are kept in the trash this long before being purged, 0 deletes them right away
+	ReconcileInterval        time.Duration  // How often the buckets are reconciled with the metadata store, 0 only on request
+	ReconcileClean           bool           // Delete orphaned objects and dangling records found by scheduled reconciliations
+	TenantReportProvider     string         // Provider whose bucket monthly tenant reports are exported to, "" to keep them in memory only
+	ProviderConcurrency      ProviderLimits // Operations in flight at the same time per provider, e.g. {"s3": 64}, unset means unlimited
+	ProviderQueueTimeout     time.Duration  // Time operations wait for a provider at its limit, 0 falls back to DefaultProviderQueueTimeout
+	MemoryBudget             int64          // Bytes of uploads buffered in memory at the same time, 0 means unlimited
+	MemoryQueueTimeout       time.Duration  // Time uploads wait for the memory budget to free up, 0 falls back to DefaultMemoryQueueTimeout
+	HedgeReads               HedgeQuantiles // Quantile of recent read latency after which reads are sent again per provider, e.g. {"gcs": 0.95}, unset means never
+	JobIntervals             JobSchedule    // Intervals of scheduled jobs overriding the defaults, 0 runs a job only on request
+	DisabledJobs             []string       // Scheduled jobs only run on request, e.g. JobReconcile
+	ExpiryPolicies           []ExpiryPolicy // Prefixes whose files are deleted or archived some time after upload
+	MetadataPath             string         // Journal file of the embedded metadata store, "" keeps records in memory only
+	AuditLogPath             string         // File downloads, signed URLs and deletes are audited to, "" for no audit log
+	JobWorkers               int            // Background jobs processed at the same time, 0 falls back to DefaultJobWorkers
+	JobQueueSize             int            // Background jobs waiting for a worker, 0 falls back to DefaultJobQueueSize
+	CloudFrontDomain         string         // CloudFront distribution in front of the S3 bucket, needed for IP-restricted links
+	CloudFrontKeyPairID      string         // Public key ID of the distribution's trusted key group
+	CloudFrontPrivateKeyPath string         // PEM private key signing CloudFront URLs
+	CloudCDNDomain           string         // Cloud CDN domain in front of the GCS bucket, needed for Cloud CDN cookies
+	CloudCDNKeyName          string         // Name of the backend bucket signing key
+	CloudCDNKey              []byte         // Value of the backend bucket signing key
+	CDNCookieDomain          string         // Domain of CDN cookies, e.g. ".example.com" when the CDN is cdn.example.com
 }
 
 // NewFileStorageManager creates a new FileStorageManager instance
 func NewFileStorageManager(config *Config, tokenManager TokenManager) *FileStorageManager {
-	return &FileStorageManager{
-		tokenManager: tokenManager,
-		maxRetry:     3,
-		config:       config,
-	}
+	compressor, compressionErr := NewCompressorFromConfig(config)
+	encryptor, encryptionErr := NewEncryptorFromConfig(config)
+	imagePipeline, imagePipelineErr := NewImagePipelineFromConfig(config)
+	cdnSigner, cdnSignerErr := NewCDNSignerFromConfig(config)
+	cloudCDNSigner, cloudCDNSignerErr := NewCloudCDNSignerFromConfig(config)
+
+	// Count token refreshes along with the storage operations
+	metrics := NewMetrics()
+	if tokenManager != nil {
+		tokenManager = &meteredTokenManager{TokenManager: tokenManager, metrics: metrics}
+	}
+
+	var scanner Scanner
+	if config.ClamAVAddress != "" {
+		scanner = NewClamdScanner(config.ClamAVAddress)
+	}
+
+	var previews *PreviewGenerator
+	if config.PreviewSize > 0 {
+		previews = NewPreviewGenerator(config.PreviewSize)
+	}
+
+	var uploadHooks []PostUploadHook
+	if config.VideoTranscoding {
+		uploadHooks = append(uploadHooks, NewVideoTranscodeHook(config.VideoHLS))
+	}
+
+	var thumbnails *ThumbnailGenerator
+	if len(config.ThumbnailSizes) > 0 {
+		thumbnails = NewThumbnailGenerator(config.ThumbnailSizes)
+	}
+
+	manager := &FileStorageManager{
+		compressor:         compressor,
+		compressionErr:     compressionErr,
+		encryptor:          encryptor,
+		encryptionErr:      encryptionErr,
+		tokenManager:       tokenManager,
+		maxRetry:           3,
+		uploadMemoryLimit:  config.UploadMemoryLimit,
+		stagingDir:         config.UploadStagingDir,
+		fileFilter:         NewFileFilter(config),
+		preserveFilenames:  config.PreserveFilenames,
+		versioning:         config.FileVersioning,
+		deduplicate:        !config.DisableDeduplication,
+		deleting:           make(map[string]bool),
+		thumbnails:         thumbnails,
+		imagePipeline:      imagePipeline,
+		imagePipelineErr:   imagePipelineErr,
+		stripImageMetadata: config.StripImageMetadata,
+		scanner:            scanner,
+		scanAction:         config.ScanAction,
+		previews:           previews,
+		uploadHooks:        uploadHooks,
+		hookSlots:          make(chan struct{}, maxConcurrentHooks),
+		archiveLimits:      NewArchiveLimitsFromConfig(config),
+		uploadConcurrency:  config.UploadConcurrency,
+		batchConcurrency:   config.BatchConcurrency,
+		downloadWorkers:    config.DownloadConcurrency,
+		downloadPartSize:   config.DownloadPartSize,
+		compositeThreshold: config.GCSCompositeThreshold,
+		compositeWorkers:   config.GCSCompositeConcurrency,
+		compositePartSize:  config.GCSCompositePartSize,
+		readAheadWindow:    config.ReadAheadWindow,
+		throttle:           NewThrottleFromConfig(config),
+		providerSlots:      newProviderSlots(config),
+		memoryBudget:       newMemoryBudget(config),
+		hedging:            newHedgeState(config),
+		sessionStore:       NewMemorySessionStore(),
+		sessionTTL:         config.UploadSessionTTL,
+		trashRetention:     config.TrashRetention,
+		expiryPolicies:     config.ExpiryPolicies,
+		attributeSchema:    config.AttributeSchema,
+		usageStats:         &usageCache{stats: make(map[int]*UsageStats)},
+		tenantUsage:        &tenantCounters{requests: make(map[string]map[string]int64), reports: make(map[string]*TenantReport)},
+		jobs:               newJobQueue(config),
+		shareStore:         NewMemoryShareLinkStore(),
+		folderStore:        NewMemoryFolderStore(),
+		shortStore:         NewMemoryShortLinkStore(),
+		apiKeyStore:        NewMemoryAPIKeyStore(),
+		metrics:            metrics,
+		bucketChecks:       &checkResults{results: make(map[string]*CheckResult)},
+		awsClients:         newAwsClients(config),
+		scheduler:          &scheduler{jobs: make(map[string]*scheduledJob), stop: make(chan struct{})},
+		cdnSigner:          cdnSigner,
+		cdnSignerErr:       cdnSignerErr,
+		cloudCDNSigner:     cloudCDNSigner,
+		cloudCDNSignerErr:  cloudCDNSignerErr,
+		config:             config,
+	}
+
+	manager.restClient = &http.Client{Transport: manager.transport(ProviderREST)}
+	manager.maxUploadSize.Store(config.MaxUploadSize)
+
+	// The default caches are the manager's to close, unlike those set later
+	tokenCache, existsCache := NewMemoryCache(), NewMemoryCache()
+	manager.tokenCache, manager.existsCache = tokenCache, existsCache
+	manager.ownCaches = []*MemoryCache{tokenCache, existsCache}
+
+	// Run maintenance such as purging the trash in the background
+	manager.startScheduler()
+
+	return manager
 }
 
 // SetMaxRetry sets the maximum number of retry attempts
@@ -99,12 +349,164 @@ func (f *FileStorageManager) SetMaxRetry(maxRetry int) {
 	f.maxRetry = maxRetry
 }
 
+// SetMaxUploadSize sets the maximum upload size in bytes, 0 disables the
+// limit. It applies to the requests received from then on.
+func (f *FileStorageManager) SetMaxUploadSize(maxUploadSize int64) {
+	f.maxUploadSize.Store(maxUploadSize)
+}
+
+// MaxUploadSize returns the global maximum upload size in bytes
+func (f *FileStorageManager) MaxUploadSize() int64 {
+	return f.maxUploadSize.Load()
+}
+
+// MaxUploadSizeFor returns the maximum upload size for a route, falling back
+// to the global limit. A route limit overrides the global one rather than
+// adding to it, so it may be lower or higher, and 0 lifts the limit for the
+// route. Uploads stored through resumable, direct and archive uploads are
+// still checked against the global limit as well.
+func (f *FileStorageManager) MaxUploadSizeFor(route string) int64 {
+	if size, ok := f.config.RouteMaxUploadSizes[route]; ok {
+		return size
+	}
+	return f.maxUploadSize.Load()
+}
+
+// SetFileFilter replaces the upload file type filter, nil disables filtering
+func (f *FileStorageManager) SetFileFilter(filter *FileFilter) {
+	f.fileFilter = filter
+}
+
+// FileFilter returns the upload file type filter
+func (f *FileStorageManager) FileFilter() *FileFilter {
+	return f.fileFilter
+}
+
+// SetPreserveFilenames toggles using the sanitized original filename as the
+// object key for S3 and GCS uploads instead of a UUID
+func (f *FileStorageManager) SetPreserveFilenames(preserve bool) {
+	f.preserveFilenames = preserve
+}
+
+// SetMetadataStore sets the store used to record uploaded files, nil disables
+// recording. A store that also implements ShareLinkStore keeps share links,
+// and one implementing APIKeyStore keeps API keys.
+func (f *FileStorageManager) SetMetadataStore(store MetadataStore) {
+	f.metadataStore = store
+	if links, ok := store.(ShareLinkStore); ok {
+		f.shareStore = links
+	}
+	if keys, ok := store.(APIKeyStore); ok {
+		f.apiKeyStore = keys
+	}
+	if folders, ok := store.(FolderStore); ok {
+		f.folderStore = folders
+	}
+	if audit, ok := store.(AuditLog); ok {
+		f.auditLog = audit
+	}
+}
+
+// MetadataStore returns the configured metadata store, if any
+func (f *FileStorageManager) MetadataStore() MetadataStore {
+	return f.metadataStore
+}
+
+// SetDeduplication toggles returning the existing file ID for uploads whose
+// content is already recorded in the metadata store for the same provider,
+// owner, bucket, prefix and upload options. The object is deleted once
+// every upload it was returned for was deleted.
+func (f *FileStorageManager) SetDeduplication(enabled bool) {
+	f.deduplicate = enabled
+}
+
+// checkUploadSize validates a file size against the global upload limit
+func (f *FileStorageManager) checkUploadSize(size int64) error {
+	if limit := f.maxUploadSize.Load(); limit > 0 && size > limit {
+		return fmt.Errorf("%w of %d bytes", ErrFileTooLarge, limit)
+	}
+	return nil
+}
+
 // UploadBase64File uploads a base64 encoded file
 func (f *FileStorageManager) UploadBase64File(filename, extension, mimetype, base64file string) (*FileResponse, error) {
+	return f.UploadBase64FileContext(context.Background(), filename, extension, mimetype, base64file)
+}
+
+// UploadBase64FileContext uploads a base64 encoded file like
+// UploadBase64File, aborting the request to the REST backend when ctx is done
+func (f *FileStorageManager) UploadBase64FileContext(ctx context.Context, filename, extension, mimetype, base64file string) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationUpload, ProviderREST, start, responseSize(response), response, err)
+	}()
+
+	release, err := f.acquireProvider(ctx, ProviderREST)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	if filename == "" || extension == "" || mimetype == "" || base64file == "" {
 		return nil, fmt.Errorf("invalid arguments")
 	}
 
+	if err := f.checkUploadSize(int64(base64.StdEncoding.DecodedLen(len(base64file)))); err != nil {
+		return nil, err
+	}
+
+	if err := f.fileFilter.Check(filename+"."+extension, mimetype); err != nil {
+		return nil, err
+	}
+
+	// Trust the magic bytes over the type claimed by the client
+	mimetype, err = f.detectMimeType(filename+"."+extension, mimetype, sniffBase64MimeType(base64file))
+	if err != nil {
+		return nil, err
+	}
+
+	scan, err := f.scanUpload(base64.NewDecoder(base64.StdEncoding, strings.NewReader(base64file)))
+	if err != nil {
+		return nil, err
+	}
+
+	if f.imagePipelineErr != nil || f.imagePipeline.Handles(mimetype) {
+		base64file, mimetype, extension, err = f.processImageBase64(base64file, mimetype, extension)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	base64file, err = f.stripBase64Metadata(base64file, mimetype)
+	if err != nil {
+		return nil, err
+	}
+
+	md5sum, sha256sum, err := checksumsBase64(base64file)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.encryptor != nil || f.encryptionErr != nil {
+		base64file, err = f.encryptBase64(base64file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return f.postBase64File(ctx, filename, extension, mimetype, base64file, md5sum, sha256sum, scan)
+}
+
+// postBase64File sends an already validated base64 encoded file to the REST
+// storage backend. The checksums are those of the plaintext content. Requests
+// are not retried once ctx is done.
+func (f *FileStorageManager) postBase64File(ctx context.Context, filename, extension, mimetype, base64file, md5sum, sha256sum string, scan *ScanResult) (*FileResponse, error) {
+	// Return the existing file if the same content was already uploaded
+	payload := &uploadPayload{mimeType: mimetype, sha256: sha256sum, encrypted: f.encryptor != nil}
+	if record := f.reuseDuplicate(ProviderREST, "", payload); record != nil {
+		return duplicateResponse(record), nil
+	}
+
 	reqBody := map[string]string{
 		"file_name":       filename,
 		"file_ext":        extension,
@@ -130,17 +532,23 @@ func (f *FileStorageManager) UploadBase64File(filename, extension, mimetype, bas
 			return nil, err
 		}
 
-		req, err := http.NewRequest("POST", f.config.HostURI+"/d/files", bytes.NewBuffer(jsonData))
+		req, err := http.NewRequestWithContext(ctx, "POST", f.config.HostURI+"/d/files", f.throttle.Reader(bytes.NewReader(jsonData)))
 		if err != nil {
 			return nil, err
 		}
+		req.ContentLength = int64(len(jsonData))
 
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("x-code", token)
 		req.Header.Set("x-client-id", f.config.ClientID)
 
-		client := &http.Client{}
-		resp, err = client.Do(req)
+		resp, err = f.restClient.Do(req)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, ctxErr
+		}
 		if err != nil {
 			attempts++
 			f.tokenManager.GenerateToken()
@@ -158,7 +566,7 @@ func (f *FileStorageManager) UploadBase64File(filename, extension, mimetype, bas
 	}
 
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := readAll(resp.Body, resp.ContentLength)
 	if err != nil {
 		return nil, err
 	}
@@ -169,40 +577,73 @@ func (f *FileStorageManager) UploadBase64File(filename, extension, mimetype, bas
 		return nil, err
 	}
 
+	if fileResponse.Status == StatusSuccess && fileResponse.Info != nil {
+		fileResponse.Info.MD5 = md5sum
+		fileResponse.Info.SHA256 = sha256sum
+		scan.applyTo(fileResponse.Info)
+		f.recordUpload(ProviderREST, "", fileResponse.Info, storedVariant(payload))
+	}
+
 	return &fileResponse, nil
 }
 
 // Upload uploads a file
 func (f *FileStorageManager) Upload(file *multipart.FileHeader) (*FileResponse, error) {
-	src, err := file.Open()
+	return f.UploadContext(context.Background(), file)
+}
+
+// UploadContext uploads a file like Upload, aborting the request to the REST
+// backend and releasing the buffered content when ctx is done
+func (f *FileStorageManager) UploadContext(ctx context.Context, file *multipart.FileHeader) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationUpload, ProviderREST, start, file.Size, response, err)
+	}()
+
+	release, err := f.acquireProvider(ctx, ProviderREST)
 	if err != nil {
 		return nil, err
 	}
-	defer src.Close()
+	defer release()
 
-	data, err := ioutil.ReadAll(src)
+	payload, err := f.readUpload(ctx, file, ProviderREST)
 	if err != nil {
 		return nil, err
 	}
-
-	// Get filename and extension
-	filename := filepath.Base(file.Filename)
-	extension := filepath.Ext(filename)
-	if extension != "" {
-		extension = extension[1:] // Remove the dot
-	}
-
-	// Clean filename
-	filename = filename[:len(filename)-len(extension)-1]
+	defer payload.release()
 
 	// Encode file data as base64
-	base64Data := base64.StdEncoding.EncodeToString(data)
+	base64Data := base64.StdEncoding.EncodeToString(payload.data)
 
-	return f.UploadBase64File(filename, extension, file.Header.Get("Content-Type"), base64Data)
+	return f.postBase64File(ctx, payload.filename, payload.extension, payload.mimeType, base64Data, payload.md5, payload.sha256, payload.scan)
 }
 
 // Delete deletes a file by ID
 func (f *FileStorageManager) Delete(fileID string) (*FileResponse, error) {
+	return f.DeleteContext(context.Background(), fileID)
+}
+
+// DeleteContext deletes a file by ID like Delete, aborting the request to the
+// REST backend when ctx is done
+func (f *FileStorageManager) DeleteContext(ctx context.Context, fileID string) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationDelete, ProviderREST, start, 0, response, err)
+	}()
+
+	release, err := f.acquireProvider(ctx, ProviderREST)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// The file stays while other uploads share it
+	shared, done, err := f.dropReference(fileID)
+	if err != nil || shared {
+		return referenceResponse(fileID, err), nil
+	}
+	defer done()
+
 	attempts := 0
 	var resp *http.Response
 
@@ -216,7 +657,7 @@ func (f *FileStorageManager) Delete(fileID string) (*FileResponse, error) {
 			return nil, err
 		}
 
-		req, err := http.NewRequest("DELETE", f.config.HostURI+"/d/files/"+fileID, nil)
+		req, err := http.NewRequestWithContext(ctx, "DELETE", f.config.HostURI+"/d/files/"+fileID, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -225,8 +666,13 @@ func (f *FileStorageManager) Delete(fileID string) (*FileResponse, error) {
 		req.Header.Set("x-code", token)
 		req.Header.Set("x-client-id", f.config.ClientID)
 
-		client := &http.Client{}
-		resp, err = client.Do(req)
+		resp, err = f.restClient.Do(req)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, ctxErr
+		}
 		if err != nil {
 			attempts++
 			f.tokenManager.GenerateToken()
@@ -244,7 +690,7 @@ func (f *FileStorageManager) Delete(fileID string) (*FileResponse, error) {
 	}
 
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := readAll(resp.Body, resp.ContentLength)
 	if err != nil {
 		return nil, err
 	}
@@ -255,11 +701,32 @@ func (f *FileStorageManager) Delete(fileID string) (*FileResponse, error) {
 		return nil, err
 	}
 
+	if fileResponse.Status == StatusSuccess {
+		f.forgetFile(fileID)
+	}
+
 	return &fileResponse, nil
 }
 
 // GetFileById retrieves file information by ID
 func (f *FileStorageManager) GetFileById(fileID string) (*FileResponse, error) {
+	return f.GetFileByIdContext(context.Background(), fileID)
+}
+
+// GetFileByIdContext retrieves file information by ID like GetFileById,
+// aborting the request to the REST backend when ctx is done
+func (f *FileStorageManager) GetFileByIdContext(ctx context.Context, fileID string) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationDownload, ProviderREST, start, responseSize(response), response, err)
+	}()
+
+	release, err := f.acquireProvider(ctx, ProviderREST)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	attempts := 0
 	var resp *http.Response
 
@@ -273,7 +740,7 @@ func (f *FileStorageManager) GetFileById(fileID string) (*FileResponse, error) {
 			return nil, err
 		}
 
-		req, err := http.NewRequest("GET", f.config.HostURI+"/d/files/"+fileID, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", f.config.HostURI+"/d/files/"+fileID, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -282,8 +749,13 @@ func (f *FileStorageManager) GetFileById(fileID string) (*FileResponse, error) {
 		req.Header.Set("x-code", token)
 		req.Header.Set("x-client-id", f.config.ClientID)
 
-		client := &http.Client{}
-		resp, err = client.Do(req)
+		resp, err = f.restClient.Do(req)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, ctxErr
+		}
 		if err != nil {
 			attempts++
 			f.tokenManager.GenerateToken()
@@ -301,7 +773,7 @@ func (f *FileStorageManager) GetFileById(fileID string) (*FileResponse, error) {
 	}
 
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := readAll(resp.Body, resp.ContentLength)
 	if err != nil {
 		return nil, err
 	}
@@ -312,58 +784,85 @@ func (f *FileStorageManager) GetFileById(fileID string) (*FileResponse, error) {
 		return nil, err
 	}
 
+	if fileResponse.Data != "" && f.encryptor != nil {
+		fileResponse.Data, err = f.decryptBase64(fileResponse.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &fileResponse, nil
 }
 
-// GetAwsClient returns an AWS S3 client
-func (f *FileStorageManager) GetAwsClient() (*s3.S3, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(f.config.AWSRegion),
-		Credentials: credentials.NewStaticCredentials(f.config.AWSKey, f.config.AWSSecret, ""),
+// awsObjectExists reports whether an object key exists in an S3 bucket
+func awsObjectExists(ctx context.Context, s3Client *s3.S3, bucketname, key string) (bool, error) {
+	_, err := s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketname),
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		return nil, err
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+			return false, nil
+		}
+		return false, err
 	}
 
-	return s3.New(sess), nil
+	return true, nil
 }
 
 // AwsUpload uploads a file to AWS S3
 func (f *FileStorageManager) AwsUpload(file *multipart.FileHeader, subdirectory string, bucketname string) (*FileResponse, error) {
-	src, err := file.Open()
+	return f.AwsUploadWithOptions(file, UploadOptions{Prefix: subdirectory, Bucket: bucketname})
+}
+
+// AwsUploadWithHeaders uploads a file to AWS S3, storing headers with the object
+func (f *FileStorageManager) AwsUploadWithHeaders(file *multipart.FileHeader, subdirectory string, bucketname string, headers ObjectHeaders) (*FileResponse, error) {
+	return f.AwsUploadWithOptions(file, UploadOptions{Prefix: subdirectory, Bucket: bucketname, Headers: headers})
+}
+
+// AwsUploadWithOptions uploads a file to AWS S3. A deduplicated upload keeps
+// the key, headers and metadata of the existing object.
+func (f *FileStorageManager) AwsUploadWithOptions(file *multipart.FileHeader, opts UploadOptions) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationUpload, ProviderAWS, start, file.Size, response, err)
+	}()
+
+	ctx := opts.context()
+
+	release, err := f.acquireProvider(ctx, ProviderAWS)
 	if err != nil {
 		return nil, err
 	}
-	defer src.Close()
+	defer release()
 
-	data, err := ioutil.ReadAll(src)
-	if err != nil {
+	if err := opts.validate(); err != nil {
 		return nil, err
 	}
-
-	// Get filename and extension
-	origFilename := filepath.Base(file.Filename)
-	extension := filepath.Ext(origFilename)
-	if extension != "" {
-		extension = extension[1:] // Remove the dot
+	if opts.Attributes, err = f.attributeSchema.Validate(opts.Attributes); err != nil {
+		return nil, err
 	}
 
-	// Generate a unique filename
-	uniqueFilename := uuid.New().String() + "." + extension
-
-	// Add subdirectory if provided
-	var fileID string
-	if subdirectory != "" {
-		fileID = subdirectory + "/" + uniqueFilename
-	} else {
-		fileID = uniqueFilename
+	payload, err := f.readUpload(ctx, file, ProviderAWS)
+	if err != nil {
+		return nil, err
+	}
+	defer payload.release()
+	if err := opts.applyTo(f, payload); err != nil {
+		return nil, err
 	}
 
 	// Use default bucket if not specified
+	bucketname := opts.Bucket
 	if bucketname == "" {
 		bucketname = f.config.AWSBucket
 	}
 
+	// Return the existing file if the same content was already uploaded
+	if record := f.reuseDuplicate(ProviderAWS, bucketname, payload); record != nil {
+		return duplicateResponse(record), nil
+	}
+
 	// Get AWS S3 client
 	s3Client, err := f.GetAwsClient()
 	if err != nil {
@@ -373,15 +872,10 @@ func (f *FileStorageManager) AwsUpload(file *multipart.FileHeader, subdirectory
 		}, nil
 	}
 
-	// Upload to S3
-	_, err = s3Client.PutObject(&s3.PutObjectInput{
-		Bucket:        aws.String(bucketname),
-		Key:           aws.String(fileID),
-		Body:          bytes.NewReader(data),
-		ContentLength: aws.Int64(int64(len(data))),
-		ContentType:   aws.String(file.Header.Get("Content-Type")),
+	// Generate the object key
+	fileID, err := f.objectKey(&opts, payload, func(key string) (bool, error) {
+		return awsObjectExists(ctx, s3Client, bucketname, key)
 	})
-
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -389,21 +883,87 @@ func (f *FileStorageManager) AwsUpload(file *multipart.FileHeader, subdirectory
 		}, nil
 	}
 
+	// Keys chosen by a namer may replace a file, whose version is kept
+	if opts.Namer != nil {
+		if err := f.keepVersion(ProviderAWS, fileID, bucketname, ""); err != nil {
+			return &FileResponse{
+				Status:  StatusError,
+				Message: err.Error(),
+			}, nil
+		}
+	}
+
+	return f.storeAwsPayload(ctx, s3Client, bucketname, fileID, payload), nil
+}
+
+// storeAwsPayload uploads a payload to S3 under fileID and records it. The
+// upload is aborted when ctx is done.
+func (f *FileStorageManager) storeAwsPayload(ctx context.Context, s3Client *s3.S3, bucketname, fileID string, payload *uploadPayload) *FileResponse {
+	// Upload to S3
+	req, _ := s3Client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:          aws.String(bucketname),
+		Key:             aws.String(fileID),
+		Body:            f.throttle.ReadSeeker(payload.body()),
+		ContentLength:   aws.Int64(payload.storedSize()),
+		ContentType:     aws.String(payload.mimeType),
+		ContentEncoding: contentEncoding(payload),
+
+		CacheControl:       optionalString(payload.options.Headers.CacheControl),
+		ContentDisposition: optionalString(payload.options.Headers.ContentDisposition),
+		ContentLanguage:    optionalString(payload.options.Headers.ContentLanguage),
+		Metadata:           awsMetadata(payload.options.Metadata),
+		ACL:                optionalString(payload.options.ACL),
+		StorageClass:       optionalString(payload.options.StorageClass),
+
+		// Let S3 validate the content against the checksums computed locally
+		ContentMD5:     aws.String(hexToBase64(payload.storedMD5())),
+		ChecksumSHA256: aws.String(hexToBase64(payload.storedSHA256())),
+	})
+
+	// The payload hash is already known, so the signer does not read (and
+	// throttle) the body an extra time to compute it
+	req.HTTPRequest.Header.Set("X-Amz-Content-Sha256", payload.storedSHA256())
+	req.SetContext(ctx)
+
+	if err := req.Send(); err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}
+	}
+
 	// Generate public URL
-	publicURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucketname, f.config.AWSRegion, fileID)
+	publicURL := f.awsPublicURL(bucketname, fileID)
 
 	// Create response
 	fileInfo := &FileInfo{
-		FileExt:      extension,
+		FileExt:      payload.extension,
 		FileID:       fileID,
-		FileMimeType: file.Header.Get("Content-Type"),
-		FileName:     origFilename[:len(origFilename)-len(extension)-1],
-		FileSize:     int64(len(data)),
+		FileMimeType: payload.mimeType,
+		FileName:     payload.filename,
+		FileSize:     payload.size,
 		PublicLink:   publicURL,
 		Tag:          "", // ETag not available without GetObjectOutput
 		Timestamp:    time.Now(),
+		MD5:          payload.md5,
+		SHA256:       payload.sha256,
+		Thumbnails:   f.storeAwsThumbnails(s3Client, bucketname, fileID, payload),
+		Owner:        payload.options.Owner,
+		ExpiresAt:    payload.options.ExpiresAt,
+		Attributes:   payload.options.Attributes,
+	}
+
+	payload.scan.applyTo(fileInfo)
+
+	if key := f.queuePreview(fileID, payload, func(key string, data []byte) error {
+		return putAwsObject(s3Client, bucketname, key, "image/jpeg", data)
+	}); key != "" {
+		fileInfo.PreviewLink = f.awsPublicURL(bucketname, key)
 	}
 
+	f.recordUpload(ProviderAWS, bucketname, fileInfo, storedVariant(payload))
+	f.runUploadHooks(ProviderAWS, bucketname, "", fileInfo, payload)
+
 	response := &FileResponse{
 		Status:  StatusSuccess,
 		Message: "INSERT " + fileID,
@@ -411,16 +971,40 @@ func (f *FileStorageManager) AwsUpload(file *multipart.FileHeader, subdirectory
 		Info:    fileInfo,
 	}
 
-	return response, nil
+	return response
 }
 
-// AwsDelete deletes a file from AWS S3
+// AwsDelete deletes a file from AWS S3. While soft delete is enabled the
+// file is moved to the trash instead, from where RestoreFile brings it back.
 func (f *FileStorageManager) AwsDelete(awsFileID string, bucketname string) (*FileResponse, error) {
+	return f.awsDelete(awsFileID, bucketname, f.softDelete(awsFileID))
+}
+
+// awsDelete deletes a file from AWS S3 or moves it to the trash
+func (f *FileStorageManager) awsDelete(awsFileID string, bucketname string, trash bool) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationDelete, ProviderAWS, start, 0, response, err)
+	}()
+
+	release, err := f.acquireProvider(context.Background(), ProviderAWS)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// Use default bucket if not specified
 	if bucketname == "" {
 		bucketname = f.config.AWSBucket
 	}
 
+	// The object stays while other uploads share it
+	shared, done, err := f.dropReference(awsFileID)
+	if err != nil || shared {
+		return referenceResponse(awsFileID, err), nil
+	}
+	defer done()
+
 	// Get AWS S3 client
 	s3Client, err := f.GetAwsClient()
 	if err != nil {
@@ -430,11 +1014,15 @@ func (f *FileStorageManager) AwsDelete(awsFileID string, bucketname string) (*Fi
 		}, nil
 	}
 
-	// Delete from S3
-	_, err = s3Client.DeleteObject(&s3.DeleteObjectInput{
-		Bucket: aws.String(bucketname),
-		Key:    aws.String(awsFileID),
-	})
+	// Delete from S3, or keep the file in the trash
+	if trash {
+		err = awsMoveObject(s3Client, bucketname, awsFileID, TrashKey(awsFileID))
+	} else {
+		_, err = s3Client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(bucketname),
+			Key:    aws.String(awsFileID),
+		})
+	}
 
 	if err != nil {
 		return &FileResponse{
@@ -443,8 +1031,18 @@ func (f *FileStorageManager) AwsDelete(awsFileID string, bucketname string) (*Fi
 		}, nil
 	}
 
+	// Thumbnails and previews stay until a trashed file is purged
+	if trash {
+		f.moveRecord(awsFileID, TrashKey(awsFileID))
+	} else {
+		originalID := strings.TrimPrefix(awsFileID, TrashPrefix)
+		f.deleteAwsThumbnails(s3Client, bucketname, originalID)
+		f.deleteAwsPreview(s3Client, bucketname, originalID)
+		f.forgetFile(awsFileID)
+	}
+
 	// Create response
-	response := &FileResponse{
+	response = &FileResponse{
 		Status:  StatusSuccess,
 		Message: "DELETE " + awsFileID,
 	}
@@ -452,8 +1050,23 @@ func (f *FileStorageManager) AwsDelete(awsFileID string, bucketname string) (*Fi
 	return response, nil
 }
 
-// AwsGetFileById retrieves file information from AWS S3
-func (f *FileStorageManager) AwsGetFileById(awsFileID string, bucketname string) (*FileResponse, error) {
+// AwsGetFileById retrieves file information from AWS S3 along with the base64
+// encoded content.
+//
+// Deprecated: use AwsGetFileInfo for the metadata and AwsOpenFile to stream
+// the content, neither of which reads the file into memory.
+func (f *FileStorageManager) AwsGetFileById(awsFileID string, bucketname string) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationDownload, ProviderAWS, start, responseSize(response), response, err)
+	}()
+
+	release, err := f.acquireProvider(context.Background(), ProviderAWS)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// Use default bucket if not specified
 	if bucketname == "" {
 		bucketname = f.config.AWSBucket
@@ -482,7 +1095,7 @@ func (f *FileStorageManager) AwsGetFileById(awsFileID string, bucketname string)
 	}
 
 	// Read the file data
-	body, err := ioutil.ReadAll(result.Body)
+	body, err := readAll(f.throttle.Reader(result.Body), aws.Int64Value(result.ContentLength))
 	result.Body.Close()
 	if err != nil {
 		return &FileResponse{
@@ -491,6 +1104,14 @@ func (f *FileStorageManager) AwsGetFileById(awsFileID string, bucketname string)
 		}, nil
 	}
 
+	body, err = f.decodeData(body, aws.StringValue(result.ContentEncoding))
+	if err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+
 	// Get file information
 	extension := filepath.Ext(awsFileID)
 	if extension != "" {
@@ -498,21 +1119,21 @@ func (f *FileStorageManager) AwsGetFileById(awsFileID string, bucketname string)
 	}
 
 	// Generate public URL
-	publicURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucketname, f.config.AWSRegion, awsFileID)
+	publicURL := f.awsPublicURL(bucketname, awsFileID)
 
 	// Create response
 	fileInfo := &FileInfo{
 		FileExt:      extension,
 		FileID:       awsFileID,
 		FileMimeType: aws.StringValue(result.ContentType),
-		FileSize:     aws.Int64Value(result.ContentLength),
+		FileSize:     int64(len(body)),
 		PublicLink:   publicURL,
 		Tag:          aws.StringValue(result.ETag),
 		Timestamp:    time.Now(),
 	}
 
 	// Create response
-	response := &FileResponse{
+	response = &FileResponse{
 		Status: StatusSuccess,
 		Data:   base64.StdEncoding.EncodeToString(body),
 		Info:   fileInfo,
@@ -521,6 +1142,8 @@ func (f *FileStorageManager) AwsGetFileById(awsFileID string, bucketname string)
 	return response, nil
 }
 
+// AwsDownloadFile downloads a file from AWS S3 to a local path. An interrupted
+// download is resumed from its ".part" file instead of starting over.
 func (f *FileStorageManager) AwsDownloadFile(awsFileID string, bucketname string, saveAsPath string) (*FileResponse, error) {
 	// Use default bucket if not specified
 	if bucketname == "" {
@@ -536,37 +1159,43 @@ func (f *FileStorageManager) AwsDownloadFile(awsFileID string, bucketname string
 		}, nil
 	}
 
-	// Create the file
-	file, err := os.Create(saveAsPath)
-	if err != nil {
-		return &FileResponse{
-			Status:  StatusError,
-			Message: err.Error(),
-		}, nil
-	}
-	defer file.Close()
-
-	// Download from S3
-	result, err := s3Client.GetObject(&s3.GetObjectInput{
+	// Look up the version to resume against
+	head, err := s3Client.HeadObject(&s3.HeadObjectInput{
 		Bucket: aws.String(bucketname),
 		Key:    aws.String(awsFileID),
 	})
-
 	if err != nil {
-		// Remove file if it was created
-		os.Remove(saveAsPath)
 		return &FileResponse{
 			Status:  StatusError,
 			Message: err.Error(),
 		}, nil
 	}
 
-	// Copy to file
-	_, err = io.Copy(file, result.Body)
-	result.Body.Close()
-	if err != nil {
-		// Remove file if it was created
-		os.Remove(saveAsPath)
+	source := &downloadSource{
+		version:         aws.StringValue(head.ETag),
+		size:            aws.Int64Value(head.ContentLength),
+		contentEncoding: aws.StringValue(head.ContentEncoding),
+		encoded:         f.isEncoded(aws.StringValue(head.ContentEncoding)),
+		open: func(offset int64) (io.ReadCloser, error) {
+			input := &s3.GetObjectInput{
+				Bucket:  aws.String(bucketname),
+				Key:     aws.String(awsFileID),
+				IfMatch: head.ETag,
+			}
+			if offset > 0 {
+				input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+			}
+
+			result, err := s3Client.GetObject(input)
+			if err != nil {
+				return nil, err
+			}
+			return result.Body, nil
+		},
+	}
+
+	// Download from S3, resuming a partial download
+	if err := f.resumableDownload(source, saveAsPath); err != nil {
 		return &FileResponse{
 			Status:  StatusError,
 			Message: err.Error(),
@@ -584,6 +1213,22 @@ func (f *FileStorageManager) AwsDownloadFile(awsFileID string, bucketname string
 
 // AwsGetTemporaryPublicLink generates a temporary public URL for an AWS S3 file
 func (f *FileStorageManager) AwsGetTemporaryPublicLink(awsFileID string, expiry time.Time, bucketname string) (*FileResponse, error) {
+	return f.AwsGetTemporaryPublicLinkWithOptions(awsFileID, expiry, bucketname, LinkOptions{})
+}
+
+// AwsGetTemporaryPublicLinkWithOptions creates a pre-signed S3 URL whose
+// response headers are overridden by opts, e.g. to download the file under
+// its original name
+func (f *FileStorageManager) AwsGetTemporaryPublicLinkWithOptions(awsFileID string, expiry time.Time, bucketname string, opts LinkOptions) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationSign, ProviderAWS, start, 0, response, err)
+	}()
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
 	// Use default bucket if not specified
 	if bucketname == "" {
 		bucketname = f.config.AWSBucket
@@ -594,6 +1239,19 @@ func (f *FileStorageManager) AwsGetTemporaryPublicLink(awsFileID string, expiry
 		expiry = time.Now().Add(30 * time.Minute)
 	}
 
+	// S3 cannot restrict presigned URLs to an IP, so sign a CloudFront URL instead
+	if opts.ClientIP != "" {
+		urlStr, err := f.cdnURL(awsFileID, expiry, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &FileResponse{
+			Status:    StatusSuccess,
+			URL:       urlStr,
+			ExpiredAt: expiry,
+		}, nil
+	}
+
 	// Get AWS S3 client
 	s3Client, err := f.GetAwsClient()
 	if err != nil {
@@ -605,12 +1263,19 @@ func (f *FileStorageManager) AwsGetTemporaryPublicLink(awsFileID string, expiry
 
 	// Create request for pre-signed URL
 	req, _ := s3Client.GetObjectRequest(&s3.GetObjectInput{
-		Bucket: aws.String(bucketname),
-		Key:    aws.String(awsFileID),
+		Bucket:                     aws.String(bucketname),
+		Key:                        aws.String(awsFileID),
+		ResponseContentDisposition: optionalString(opts.ContentDisposition),
+		ResponseContentType:        optionalString(opts.ContentType),
 	})
 
+	// Required headers become part of the signature
+	for name, value := range opts.Headers {
+		req.HTTPRequest.Header.Set(name, value)
+	}
+
 	// Generate pre-signed URL
-	urlStr, err := req.Presign(expiry.Sub(time.Now()))
+	urlStr, _, err := req.PresignRequest(expiry.Sub(time.Now()))
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -619,7 +1284,7 @@ func (f *FileStorageManager) AwsGetTemporaryPublicLink(awsFileID string, expiry
 	}
 
 	// Create response
-	response := &FileResponse{
+	response = &FileResponse{
 		Status:    StatusSuccess,
 		URL:       urlStr,
 		ExpiredAt: expiry,
@@ -642,8 +1307,14 @@ func (f *FileStorageManager) GetGcsClient(projectID string) (*storage.Client, er
 		return nil, fmt.Errorf("credential not found")
 	}
 
-	// Create GCS client
-	client, err := storage.NewClient(ctx, option.WithCredentialsFile(f.config.GCSKeyPath))
+	// Create GCS client, on a transport of its own counted in the metrics
+	transport, err := htransport.NewTransport(ctx, f.transport(ProviderGCS),
+		option.WithCredentialsFile(f.config.GCSKeyPath), option.WithScopes(storage.ScopeFullControl))
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := storage.NewClient(ctx, option.WithHTTPClient(&http.Client{Transport: transport}))
 	if err != nil {
 		return nil, err
 	}
@@ -651,43 +1322,93 @@ func (f *FileStorageManager) GetGcsClient(projectID string) (*storage.Client, er
 	return client, nil
 }
 
+// gcsServiceAccountKey holds the service account credentials signing GCS URLs
+type gcsServiceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+}
+
+// gcsSigningKey loads the service account key file to sign GCS URLs with
+func (f *FileStorageManager) gcsSigningKey() (*gcsServiceAccountKey, error) {
+	jsonKey, err := ioutil.ReadFile(f.config.GCSKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read service account key: %v", err)
+	}
+
+	var keyData gcsServiceAccountKey
+	if err := json.Unmarshal(jsonKey, &keyData); err != nil {
+		return nil, fmt.Errorf("Failed to parse service account key: %v", err)
+	}
+
+	return &keyData, nil
+}
+
+// gcsObjectExists reports whether an object key exists in a GCS bucket
+func gcsObjectExists(ctx context.Context, bucket *storage.BucketHandle, key string) (bool, error) {
+	_, err := bucket.Object(key).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 // GcsUpload uploads a file to Google Cloud Storage
 func (f *FileStorageManager) GcsUpload(file *multipart.FileHeader, subdirectory string, bucketname string, projectID string) (*FileResponse, error) {
-	ctx := context.Background()
+	return f.GcsUploadWithOptions(file, UploadOptions{Prefix: subdirectory, Bucket: bucketname, ProjectID: projectID})
+}
+
+// GcsUploadWithHeaders uploads a file to Google Cloud Storage, storing headers with the object
+func (f *FileStorageManager) GcsUploadWithHeaders(file *multipart.FileHeader, subdirectory string, bucketname string, projectID string, headers ObjectHeaders) (*FileResponse, error) {
+	return f.GcsUploadWithOptions(file, UploadOptions{Prefix: subdirectory, Bucket: bucketname, ProjectID: projectID, Headers: headers})
+}
+
+// GcsUploadWithOptions uploads a file to Google Cloud Storage. A deduplicated
+// upload keeps the key, headers and metadata of the existing object.
+func (f *FileStorageManager) GcsUploadWithOptions(file *multipart.FileHeader, opts UploadOptions) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationUpload, ProviderGCS, start, file.Size, response, err)
+	}()
 
-	src, err := file.Open()
+	ctx := opts.context()
+
+	release, err := f.acquireProvider(ctx, ProviderGCS)
 	if err != nil {
 		return nil, err
 	}
-	defer src.Close()
+	defer release()
 
-	data, err := ioutil.ReadAll(src)
-	if err != nil {
+	if err := opts.validate(); err != nil {
 		return nil, err
 	}
-
-	// Get filename and extension
-	origFilename := filepath.Base(file.Filename)
-	extension := filepath.Ext(origFilename)
-	if extension != "" {
-		extension = extension[1:] // Remove the dot
+	if opts.Attributes, err = f.attributeSchema.Validate(opts.Attributes); err != nil {
+		return nil, err
 	}
 
-	// Generate a unique filename
-	uniqueFilename := uuid.New().String() + "." + extension
-
-	// Add subdirectory if provided
-	var fileID string
-	if subdirectory != "" {
-		fileID = subdirectory + "/" + uniqueFilename
-	} else {
-		fileID = uniqueFilename
+	payload, err := f.readUpload(ctx, file, ProviderGCS)
+	if err != nil {
+		return nil, err
+	}
+	defer payload.release()
+	if err := opts.applyTo(f, payload); err != nil {
+		return nil, err
 	}
 
 	// Use default bucket if not specified
+	bucketname := opts.Bucket
 	if bucketname == "" {
 		bucketname = f.config.GCSBucket
 	}
+	projectID := opts.ProjectID
+
+	// Return the existing file if the same content was already uploaded
+	if record := f.reuseDuplicate(ProviderGCS, bucketname, payload); record != nil {
+		return duplicateResponse(record), nil
+	}
 
 	// Get GCS client
 	gcsClient, err := f.GetGcsClient(projectID)
@@ -703,7 +1424,7 @@ func (f *FileStorageManager) GcsUpload(file *multipart.FileHeader, subdirectory
 	bucket := gcsClient.Bucket(bucketname)
 
 	// Check if bucket exists
-	_, err = bucket.Attrs(ctx)
+	err = f.checkGcsBucketExists(ctx, bucket)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -711,25 +1432,73 @@ func (f *FileStorageManager) GcsUpload(file *multipart.FileHeader, subdirectory
 		}, nil
 	}
 
-	// Create object handle
-	obj := bucket.Object(fileID)
-
-	// Upload data
-	wc := obj.NewWriter(ctx)
-	wc.ContentType = file.Header.Get("Content-Type")
-
-	if _, err := wc.Write(data); err != nil {
+	// Generate the object key
+	fileID, err := f.objectKey(&opts, payload, func(key string) (bool, error) {
+		return gcsObjectExists(ctx, bucket, key)
+	})
+	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
 			Message: err.Error(),
 		}, nil
 	}
 
-	if err := wc.Close(); err != nil {
+	// Keys chosen by a namer may replace a file, whose version is kept
+	if opts.Namer != nil {
+		if err := f.keepVersion(ProviderGCS, fileID, bucketname, projectID); err != nil {
+			return &FileResponse{
+				Status:  StatusError,
+				Message: err.Error(),
+			}, nil
+		}
+	}
+
+	return f.storeGcsPayload(ctx, bucket, bucketname, projectID, fileID, payload), nil
+}
+
+// writeGcsPayload uploads a payload to obj in a single request
+func (f *FileStorageManager) writeGcsPayload(ctx context.Context, obj *storage.ObjectHandle, payload *uploadPayload) error {
+	// Cancelling the writer's context is what aborts an upload broken off
+	// part way and stops the goroutine sending it
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wc := obj.NewWriter(ctx)
+	wc.ContentType = payload.mimeType
+	wc.ContentEncoding = payload.contentEncoding
+	wc.CacheControl = payload.options.Headers.CacheControl
+	wc.ContentDisposition = payload.options.Headers.ContentDisposition
+	wc.ContentLanguage = payload.options.Headers.ContentLanguage
+	wc.Metadata = payload.options.Metadata
+	wc.PredefinedACL = payload.options.ACL
+	wc.StorageClass = payload.options.StorageClass
+
+	// Let GCS reject the upload if the stored content does not match the local MD5
+	wc.MD5, _ = hex.DecodeString(payload.storedMD5())
+
+	if _, err := Copy(wc, f.throttle.Reader(payload.body())); err != nil {
+		return err
+	}
+	return wc.Close()
+}
+
+// storeGcsPayload uploads a payload to GCS under fileID and records it
+func (f *FileStorageManager) storeGcsPayload(ctx context.Context, bucket *storage.BucketHandle, bucketname, projectID, fileID string, payload *uploadPayload) *FileResponse {
+	// Create object handle
+	obj := bucket.Object(fileID)
+
+	// Upload data, very large uploads in parts composed into the object
+	var err error
+	if f.compositeUpload(payload.storedSize()) {
+		err = f.composeGcsPayload(ctx, bucket, obj, payload)
+	} else {
+		err = f.writeGcsPayload(ctx, obj, payload)
+	}
+	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
 			Message: err.Error(),
-		}, nil
+		}
 	}
 
 	// Get object attributes
@@ -738,25 +1507,49 @@ func (f *FileStorageManager) GcsUpload(file *multipart.FileHeader, subdirectory
 		return &FileResponse{
 			Status:  StatusError,
 			Message: err.Error(),
-		}, nil
+		}
 	}
 
 	// Generate public URL
-	publicURL := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketname, fileID)
+	publicURL := gcsPublicURL(bucketname, fileID)
 
 	// Create response
 	fileInfo := &FileInfo{
-		FileExt:      extension,
+		FileExt:      payload.extension,
 		FileID:       fileID,
 		FileMimeType: attrs.ContentType,
-		FileName:     origFilename[:len(origFilename)-len(extension)-1],
-		FileSize:     attrs.Size,
+		FileName:     payload.filename,
+		FileSize:     payload.size,
 		PublicLink:   publicURL,
 		Tag:          attrs.Etag,
 		Timestamp:    attrs.Created,
 		Bucket:       bucketname,
+		MD5:          payload.md5,
+		SHA256:       payload.sha256,
+		Thumbnails:   f.storeGcsThumbnails(ctx, bucket, bucketname, fileID, payload),
+		Owner:        payload.options.Owner,
+		ExpiresAt:    payload.options.ExpiresAt,
+		Attributes:   payload.options.Attributes,
+	}
+
+	payload.scan.applyTo(fileInfo)
+
+	// The client is closed when the upload returns, so the preview opens its own
+	if key := f.queuePreview(fileID, payload, func(key string, data []byte) error {
+		gcsClient, err := f.GetGcsClient(projectID)
+		if err != nil {
+			return err
+		}
+		defer gcsClient.Close()
+
+		return putGcsObject(context.Background(), gcsClient.Bucket(bucketname), key, "image/jpeg", data)
+	}); key != "" {
+		fileInfo.PreviewLink = gcsPublicURL(bucketname, key)
 	}
 
+	f.recordUpload(ProviderGCS, bucketname, fileInfo, storedVariant(payload))
+	f.runUploadHooks(ProviderGCS, bucketname, projectID, fileInfo, payload)
+
 	response := &FileResponse{
 		Status:  StatusSuccess,
 		Message: "INSERT " + fileID,
@@ -764,11 +1557,29 @@ func (f *FileStorageManager) GcsUpload(file *multipart.FileHeader, subdirectory
 		Info:    fileInfo,
 	}
 
-	return response, nil
+	return response
 }
 
-// GcsDelete deletes a file from Google Cloud Storage
+// GcsDelete deletes a file from Google Cloud Storage. While soft delete is
+// enabled the file is moved to the trash instead, from where RestoreFile
+// brings it back.
 func (f *FileStorageManager) GcsDelete(gcsFileID string, bucketname string, projectID string) (*FileResponse, error) {
+	return f.gcsDelete(gcsFileID, bucketname, projectID, f.softDelete(gcsFileID))
+}
+
+// gcsDelete deletes a file from Google Cloud Storage or moves it to the trash
+func (f *FileStorageManager) gcsDelete(gcsFileID string, bucketname string, projectID string, trash bool) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationDelete, ProviderGCS, start, 0, response, err)
+	}()
+
+	release, err := f.acquireProvider(context.Background(), ProviderGCS)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	ctx := context.Background()
 
 	// Use default bucket if not specified
@@ -776,6 +1587,13 @@ func (f *FileStorageManager) GcsDelete(gcsFileID string, bucketname string, proj
 		bucketname = f.config.GCSBucket
 	}
 
+	// The object stays while other uploads share it
+	shared, done, err := f.dropReference(gcsFileID)
+	if err != nil || shared {
+		return referenceResponse(gcsFileID, err), nil
+	}
+	defer done()
+
 	// Get GCS client
 	gcsClient, err := f.GetGcsClient(projectID)
 	if err != nil {
@@ -790,7 +1608,7 @@ func (f *FileStorageManager) GcsDelete(gcsFileID string, bucketname string, proj
 	bucket := gcsClient.Bucket(bucketname)
 
 	// Check if bucket exists
-	_, err = bucket.Attrs(ctx)
+	err = f.checkGcsBucketExists(ctx, bucket)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -810,16 +1628,32 @@ func (f *FileStorageManager) GcsDelete(gcsFileID string, bucketname string, proj
 		}, nil
 	}
 
-	// Delete object
-	if err := obj.Delete(ctx); err != nil {
+	// Delete object, or keep it in the trash
+	if trash {
+		err = gcsMoveObject(ctx, bucket, gcsFileID, TrashKey(gcsFileID))
+	} else {
+		err = obj.Delete(ctx)
+	}
+	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
 			Message: err.Error(),
 		}, nil
 	}
+	f.forgetGcsObject(bucketname, gcsFileID)
+
+	// Thumbnails and previews stay until a trashed file is purged
+	if trash {
+		f.moveRecord(gcsFileID, TrashKey(gcsFileID))
+	} else {
+		originalID := strings.TrimPrefix(gcsFileID, TrashPrefix)
+		f.deleteGcsThumbnails(ctx, bucket, originalID)
+		f.deleteGcsPreview(ctx, bucket, originalID)
+		f.forgetFile(gcsFileID)
+	}
 
 	// Create response
-	response := &FileResponse{
+	response = &FileResponse{
 		Status:  StatusSuccess,
 		Message: "DELETE " + gcsFileID,
 	}
@@ -827,8 +1661,23 @@ func (f *FileStorageManager) GcsDelete(gcsFileID string, bucketname string, proj
 	return response, nil
 }
 
-// GcsGetFileById retrieves file information from Google Cloud Storage
-func (f *FileStorageManager) GcsGetFileById(gcsFileID string, bucketname string, projectID string) (*FileResponse, error) {
+// GcsGetFileById retrieves file information from Google Cloud Storage along
+// with the base64 encoded content.
+//
+// Deprecated: use GcsGetFileInfo for the metadata and GcsOpenFile to stream
+// the content, neither of which reads the file into memory.
+func (f *FileStorageManager) GcsGetFileById(gcsFileID string, bucketname string, projectID string) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationDownload, ProviderGCS, start, responseSize(response), response, err)
+	}()
+
+	release, err := f.acquireProvider(context.Background(), ProviderGCS)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	ctx := context.Background()
 
 	// Use default bucket if not specified
@@ -850,7 +1699,7 @@ func (f *FileStorageManager) GcsGetFileById(gcsFileID string, bucketname string,
 	bucket := gcsClient.Bucket(bucketname)
 
 	// Check if bucket exists
-	_, err = bucket.Attrs(ctx)
+	err = f.checkGcsBucketExists(ctx, bucket)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -871,7 +1720,7 @@ func (f *FileStorageManager) GcsGetFileById(gcsFileID string, bucketname string,
 	}
 
 	// Read the file data
-	reader, err := obj.NewReader(ctx)
+	reader, err := obj.ReadCompressed(true).NewReader(ctx)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -880,7 +1729,15 @@ func (f *FileStorageManager) GcsGetFileById(gcsFileID string, bucketname string,
 	}
 	defer reader.Close()
 
-	data, err := ioutil.ReadAll(reader)
+	data, err := readAll(f.throttle.Reader(reader), reader.Attrs.Size)
+	if err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+
+	data, err = f.decodeData(data, reader.Attrs.ContentEncoding)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -895,21 +1752,22 @@ func (f *FileStorageManager) GcsGetFileById(gcsFileID string, bucketname string,
 	}
 
 	// Generate public URL
-	publicURL := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketname, gcsFileID)
+	publicURL := gcsPublicURL(bucketname, gcsFileID)
 
 	// Create response
 	fileInfo := &FileInfo{
 		FileExt:      extension,
 		FileID:       gcsFileID,
 		FileMimeType: attrs.ContentType,
-		FileSize:     attrs.Size,
+		FileSize:     int64(len(data)),
 		PublicLink:   publicURL,
 		Tag:          attrs.Etag,
 		Timestamp:    attrs.Created,
 		Bucket:       bucketname,
+		MD5:          hex.EncodeToString(attrs.MD5),
 	}
 
-	response := &FileResponse{
+	response = &FileResponse{
 		Status: StatusSuccess,
 		Data:   base64.StdEncoding.EncodeToString(data),
 		Info:   fileInfo,
@@ -918,7 +1776,8 @@ func (f *FileStorageManager) GcsGetFileById(gcsFileID string, bucketname string,
 	return response, nil
 }
 
-// GcsDownloadFile downloads a file from Google Cloud Storage to a local path
+// GcsDownloadFile downloads a file from Google Cloud Storage to a local path.
+// An interrupted download is resumed from its ".part" file instead of starting over.
 func (f *FileStorageManager) GcsDownloadFile(gcsFileID string, saveAsPath string, bucketname string, projectID string) (*FileResponse, error) {
 	ctx := context.Background()
 
@@ -941,7 +1800,7 @@ func (f *FileStorageManager) GcsDownloadFile(gcsFileID string, saveAsPath string
 	bucket := gcsClient.Bucket(bucketname)
 
 	// Check if bucket exists
-	_, err = bucket.Attrs(ctx)
+	err = f.checkGcsBucketExists(ctx, bucket)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -953,7 +1812,7 @@ func (f *FileStorageManager) GcsDownloadFile(gcsFileID string, saveAsPath string
 	obj := bucket.Object(gcsFileID)
 
 	// Check if object exists
-	_, err = obj.Attrs(ctx)
+	attrs, err := obj.Attrs(ctx)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -961,31 +1820,24 @@ func (f *FileStorageManager) GcsDownloadFile(gcsFileID string, saveAsPath string
 		}, nil
 	}
 
-	// Create the file
-	file, err := os.Create(saveAsPath)
-	if err != nil {
-		return &FileResponse{
-			Status:  StatusError,
-			Message: err.Error(),
-		}, nil
-	}
-	defer file.Close()
+	// Pin the generation, so a resumed download cannot mix two versions
+	obj = obj.Generation(attrs.Generation)
 
-	// Get reader
-	reader, err := obj.NewReader(ctx)
-	if err != nil {
-		os.Remove(saveAsPath)
-		return &FileResponse{
-			Status:  StatusError,
-			Message: err.Error(),
-		}, nil
+	source := &downloadSource{
+		version:         strconv.FormatInt(attrs.Generation, 10),
+		size:            attrs.Size,
+		contentEncoding: attrs.ContentEncoding,
+		encoded:         f.isEncoded(attrs.ContentEncoding),
+		open: func(offset int64) (io.ReadCloser, error) {
+			if f.isEncoded(attrs.ContentEncoding) {
+				return obj.ReadCompressed(true).NewReader(ctx)
+			}
+			return obj.NewRangeReader(ctx, offset, -1)
+		},
 	}
-	defer reader.Close()
 
-	// Copy to file
-	_, err = io.Copy(file, reader)
-	if err != nil {
-		os.Remove(saveAsPath)
+	// Download from GCS, resuming a partial download
+	if err := f.resumableDownload(source, saveAsPath); err != nil {
 		return &FileResponse{
 			Status:  StatusError,
 			Message: err.Error(),
@@ -1024,7 +1876,7 @@ func (f *FileStorageManager) GcsGetFileByIdAsString(gcsFileID string, bucketname
 	bucket := gcsClient.Bucket(bucketname)
 
 	// Check if bucket exists
-	_, err = bucket.Attrs(ctx)
+	err = f.checkGcsBucketExists(ctx, bucket)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -1036,7 +1888,7 @@ func (f *FileStorageManager) GcsGetFileByIdAsString(gcsFileID string, bucketname
 	obj := bucket.Object(gcsFileID)
 
 	// Check if object exists
-	_, err = obj.Attrs(ctx)
+	err = f.checkGcsObjectExists(ctx, obj)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -1045,7 +1897,7 @@ func (f *FileStorageManager) GcsGetFileByIdAsString(gcsFileID string, bucketname
 	}
 
 	// Read the file data
-	reader, err := obj.NewReader(ctx)
+	reader, err := obj.ReadCompressed(true).NewReader(ctx)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -1054,7 +1906,15 @@ func (f *FileStorageManager) GcsGetFileByIdAsString(gcsFileID string, bucketname
 	}
 	defer reader.Close()
 
-	data, err := ioutil.ReadAll(reader)
+	data, err := readAll(f.throttle.Reader(reader), reader.Attrs.Size)
+	if err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+
+	data, err = f.decodeData(data, reader.Attrs.ContentEncoding)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -1093,7 +1953,7 @@ func (f *FileStorageManager) GcsGetFileByIdAsStream(gcsFileID string, bucketname
 	bucket := gcsClient.Bucket(bucketname)
 
 	// Check if bucket exists
-	_, err = bucket.Attrs(ctx)
+	err = f.checkGcsBucketExists(ctx, bucket)
 	if err != nil {
 		gcsClient.Close()
 		return &FileResponse{
@@ -1106,7 +1966,7 @@ func (f *FileStorageManager) GcsGetFileByIdAsStream(gcsFileID string, bucketname
 	obj := bucket.Object(gcsFileID)
 
 	// Check if object exists
-	_, err = obj.Attrs(ctx)
+	err = f.checkGcsObjectExists(ctx, obj)
 	if err != nil {
 		gcsClient.Close()
 		return &FileResponse{
@@ -1116,8 +1976,18 @@ func (f *FileStorageManager) GcsGetFileByIdAsStream(gcsFileID string, bucketname
 	}
 
 	// Get reader
-	reader, err := obj.NewReader(ctx)
+	reader, err := obj.ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		gcsClient.Close()
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+
+	stream, err := f.decodeReader(f.throttle.Reader(reader), reader.Attrs.ContentEncoding)
 	if err != nil {
+		reader.Close()
 		gcsClient.Close()
 		return &FileResponse{
 			Status:  StatusError,
@@ -1125,10 +1995,13 @@ func (f *FileStorageManager) GcsGetFileByIdAsStream(gcsFileID string, bucketname
 		}, nil
 	}
 
-	// Create response with stream (caller must close both the reader and client)
+	// Create response with stream (caller must close it, which also closes the client)
 	response := &FileResponse{
-		Status:     StatusSuccess,
-		StreamData: reader,
+		Status: StatusSuccess,
+		StreamData: &closerFunc{Reader: stream, close: func() error {
+			reader.Close()
+			return gcsClient.Close()
+		}},
 	}
 
 	return response, nil
@@ -1138,8 +2011,27 @@ func (f *FileStorageManager) GcsGetFileByIdAsStream(gcsFileID string, bucketname
 // In your storage/file_storage.go file, update the GcsGetTemporaryPublicLink function:
 
 func (f *FileStorageManager) GcsGetTemporaryPublicLink(gcsFileID string, expiry time.Time, bucketname string, projectID string) (*FileResponse, error) {
+	return f.GcsGetTemporaryPublicLinkWithOptions(gcsFileID, expiry, bucketname, projectID, LinkOptions{})
+}
+
+// GcsGetTemporaryPublicLinkWithOptions creates a signed GCS URL whose response
+// headers are overridden by opts and that requires opts.Headers to be sent.
+// GCS cannot restrict signed URLs to a client IP.
+func (f *FileStorageManager) GcsGetTemporaryPublicLinkWithOptions(gcsFileID string, expiry time.Time, bucketname string, projectID string, opts LinkOptions) (response *FileResponse, err error) {
+	start := time.Now()
+	defer func() {
+		f.metrics.observe(OperationSign, ProviderGCS, start, 0, response, err)
+	}()
+
 	ctx := context.Background()
 
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.ClientIP != "" {
+		return nil, fmt.Errorf("%w: client IP on GCS", ErrUnsupportedRestriction)
+	}
+
 	// Use default bucket if not specified
 	if bucketname == "" {
 		bucketname = f.config.GCSBucket
@@ -1164,7 +2056,7 @@ func (f *FileStorageManager) GcsGetTemporaryPublicLink(gcsFileID string, expiry
 	bucket := gcsClient.Bucket(bucketname)
 
 	// Check if bucket exists
-	_, err = bucket.Attrs(ctx)
+	err = f.checkGcsBucketExists(ctx, bucket)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -1176,7 +2068,7 @@ func (f *FileStorageManager) GcsGetTemporaryPublicLink(gcsFileID string, expiry
 	obj := bucket.Object(gcsFileID)
 
 	// Check if object exists
-	_, err = obj.Attrs(ctx)
+	err = f.checkGcsObjectExists(ctx, obj)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -1184,38 +2076,30 @@ func (f *FileStorageManager) GcsGetTemporaryPublicLink(gcsFileID string, expiry
 		}, nil
 	}
 
-	// Load the service account key file to get the credentials
-	jsonKey, err := ioutil.ReadFile(f.config.GCSKeyPath)
+	// Sign with the service account credentials
+	keyData, err := f.gcsSigningKey()
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
-			Message: "Failed to read service account key: " + err.Error(),
+			Message: err.Error(),
 		}, nil
 	}
 
-	// Parse the service account key
-	var keyData struct {
-		ClientEmail string `json:"client_email"`
-		PrivateKey  string `json:"private_key"`
-	}
-
-	if err := json.Unmarshal(jsonKey, &keyData); err != nil {
-		return &FileResponse{
-			Status:  StatusError,
-			Message: "Failed to parse service account key: " + err.Error(),
-		}, nil
+	// Create signed URL options
+	signOpts := &storage.SignedURLOptions{
+		Method:          "GET",
+		Expires:         expiry,
+		GoogleAccessID:  keyData.ClientEmail,        // Use the service account email
+		PrivateKey:      []byte(keyData.PrivateKey), // Use the private key
+		QueryParameters: opts.responseQuery(),
 	}
 
-	// Create signed URL options
-	opts := &storage.SignedURLOptions{
-		Method:         "GET",
-		Expires:        expiry,
-		GoogleAccessID: keyData.ClientEmail,        // Use the service account email
-		PrivateKey:     []byte(keyData.PrivateKey), // Use the private key
+	for name, value := range opts.Headers {
+		signOpts.Headers = append(signOpts.Headers, name+":"+value)
 	}
 
 	// Generate signed URL
-	url, err := storage.SignedURL(bucketname, gcsFileID, opts)
+	url, err := storage.SignedURL(bucketname, gcsFileID, signOpts)
 	if err != nil {
 		return &FileResponse{
 			Status:  StatusError,
@@ -1224,7 +2108,7 @@ func (f *FileStorageManager) GcsGetTemporaryPublicLink(gcsFileID string, expiry
 	}
 
 	// Create response
-	response := &FileResponse{
+	response = &FileResponse{
 		Status:    StatusSuccess,
 		URL:       url,
 		ExpiredAt: expiry,
@@ -0,0 +1,366 @@
+// pkg/storage/folders.go
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// RootFolder selects the files outside any folder in a FileQuery
+	RootFolder = "/"
+
+	// MaxFolderNameLength is the longest folder name in characters
+	MaxFolderNameLength = 255
+
+	// MaxFolderDepth is the most folders nested in each other
+	MaxFolderDepth = 32
+
+	// folderIDLength is the length of generated folder IDs
+	folderIDLength = 12
+)
+
+// Folder is a virtual folder files are organized in. Folders live in the
+// folder store only, so creating, renaming or moving them never touches the
+// stored objects or their keys.
+type Folder struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  string    `json:"parent_id,omitempty"` // "" for top-level folders
+	Owner     string    `json:"owner,omitempty"`     // Subject that created the folder, if known
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FolderListing is the content of a folder, or of the root
+type FolderListing struct {
+	Folder  *Folder       `json:"folder,omitempty"` // nil for the root
+	Path    []*Folder     `json:"path"`             // Ancestors of the folder, top-level first
+	Folders []*Folder     `json:"folders"`
+	Files   *SearchResult `json:"files"`
+}
+
+// FolderStore interface for persisting virtual folders
+type FolderStore interface {
+	// SaveFolder inserts or replaces the folder for folder.ID
+	SaveFolder(folder *Folder) error
+	// GetFolder returns the folder for an ID or ErrFolderNotFound
+	GetFolder(id string) (*Folder, error)
+	// DeleteFolder removes the folder for an ID, if any
+	DeleteFolder(id string) error
+	// ListFolders returns the folders in a parent folder, "" for the top-level folders, by name
+	ListFolders(parentID string) ([]*Folder, error)
+}
+
+// SetFolderStore sets the store virtual folders are kept in
+func (f *FileStorageManager) SetFolderStore(store FolderStore) {
+	f.folderStore = store
+}
+
+// CreateFolder creates a folder in a parent folder, or at the top level when
+// parentID is "". Names are unique among the folders of a parent. Only the
+// owner of the parent folder creates folders in it; a nil principal, a
+// service or an administrator, may create them anywhere.
+func (f *FileStorageManager) CreateFolder(name, parentID string, principal *Principal, owner string) (*Folder, error) {
+	name, err := checkFolderName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	if parentID != "" {
+		path, err := f.folderPath(parentID)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) >= MaxFolderDepth {
+			return nil, fmt.Errorf("%w: folders nest at most %d deep", ErrInvalidFolder, MaxFolderDepth)
+		}
+		if err := path[len(path)-1].checkOwner(principal); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.checkFolderNameFree(parentID, name, ""); err != nil {
+		return nil, err
+	}
+
+	id, err := randomCode(folderIDLength)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	folder := &Folder{ID: id, Name: name, ParentID: parentID, Owner: owner, CreatedAt: now, UpdatedAt: now}
+	if err := f.folderStore.SaveFolder(folder); err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+// GetFolder returns a folder by ID
+func (f *FileStorageManager) GetFolder(id string) (*Folder, error) {
+	return f.folderStore.GetFolder(id)
+}
+
+// RenameFolder renames a folder of a principal
+func (f *FileStorageManager) RenameFolder(id string, principal *Principal, name string) (*Folder, error) {
+	name, err := checkFolderName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	folder, err := f.folderStore.GetFolder(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := folder.checkOwner(principal); err != nil {
+		return nil, err
+	}
+	if err := f.checkFolderNameFree(folder.ParentID, name, id); err != nil {
+		return nil, err
+	}
+
+	folder.Name = name
+	folder.UpdatedAt = time.Now()
+	if err := f.folderStore.SaveFolder(folder); err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+// MoveFolder moves a folder of a principal with its content into another
+// of their folders, or to the top level when parentID is "". A folder cannot
+// be moved into itself or one of its subfolders.
+func (f *FileStorageManager) MoveFolder(id string, principal *Principal, parentID string) (*Folder, error) {
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	folder, err := f.folderStore.GetFolder(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := folder.checkOwner(principal); err != nil {
+		return nil, err
+	}
+
+	if parentID != "" {
+		path, err := f.folderPath(parentID)
+		if err != nil {
+			return nil, err
+		}
+		for _, ancestor := range path {
+			if ancestor.ID == id {
+				return nil, fmt.Errorf("%w: a folder cannot be moved into itself", ErrInvalidFolder)
+			}
+		}
+		if len(path)+f.folderHeight(id) > MaxFolderDepth {
+			return nil, fmt.Errorf("%w: folders nest at most %d deep", ErrInvalidFolder, MaxFolderDepth)
+		}
+		if err := path[len(path)-1].checkOwner(principal); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.checkFolderNameFree(parentID, folder.Name, id); err != nil {
+		return nil, err
+	}
+
+	folder.ParentID = parentID
+	folder.UpdatedAt = time.Now()
+	if err := f.folderStore.SaveFolder(folder); err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+// DeleteFolder deletes an empty folder of a principal. Folders still
+// holding folders or files return ErrFolderNotEmpty; files in the trash do
+// not count, and are restored to the top level.
+func (f *FileStorageManager) DeleteFolder(id string, principal *Principal) error {
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	folder, err := f.folderStore.GetFolder(id)
+	if err != nil {
+		return err
+	}
+	if err := folder.checkOwner(principal); err != nil {
+		return err
+	}
+
+	folders, err := f.folderStore.ListFolders(id)
+	if err != nil {
+		return err
+	}
+	if len(folders) > 0 {
+		return fmt.Errorf("%w: it holds %d folders", ErrFolderNotEmpty, len(folders))
+	}
+
+	if f.metadataStore != nil {
+		files, err := f.SearchFiles(FileQuery{Folder: id, Limit: 1})
+		if err != nil && !errors.Is(err, ErrSearchNotSupported) {
+			return err
+		}
+		if files != nil && files.Total > 0 {
+			return fmt.Errorf("%w: it holds %d files", ErrFolderNotEmpty, files.Total)
+		}
+	}
+
+	return f.folderStore.DeleteFolder(id)
+}
+
+// ListFolder returns a folder with its path, its folders and a page of its
+// files, or the top-level folders and the files outside any folder when id
+// is "". Files are listed from the metadata store like SearchFiles, by name.
+// Unless owner is "", only the folders and files of owner are listed.
+func (f *FileStorageManager) ListFolder(id, owner string, offset, limit int) (*FolderListing, error) {
+	listing := &FolderListing{Path: []*Folder{}, Folders: []*Folder{}}
+
+	query := FileQuery{Folder: RootFolder, Owner: owner, Sort: SortName, Offset: offset, Limit: limit}
+	if id != "" {
+		path, err := f.folderPath(id)
+		if err != nil {
+			return nil, err
+		}
+		listing.Folder, listing.Path = path[len(path)-1], path[:len(path)-1]
+		query.Folder = id
+	}
+
+	folders, err := f.folderStore.ListFolders(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, folder := range folders {
+		if owner == "" || folder.Owner == owner {
+			listing.Folders = append(listing.Folders, folder)
+		}
+	}
+
+	listing.Files, err = f.SearchFiles(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return listing, nil
+}
+
+// MoveFile moves a recorded file into a folder of a principal, or out of any
+// folder when folderID is "". Only the record changes; the object keeps its
+// key.
+func (f *FileStorageManager) MoveFile(fileID string, principal *Principal, folderID string) (*FileRecord, error) {
+	if f.metadataStore == nil {
+		return nil, ErrFoldersNotSupported
+	}
+
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if errors.Is(err, ErrRecordNotFound) || (err == nil && record.UploadStatus == UploadPending) {
+		return nil, fmt.Errorf("%w: no record of %s", ErrFileNotFound, fileID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if folderID != "" {
+		folder, err := f.folderStore.GetFolder(folderID)
+		if err != nil {
+			return nil, err
+		}
+		if err := folder.checkOwner(principal); err != nil {
+			return nil, err
+		}
+	}
+
+	record.Folder = folderID
+	if err := f.saveRecord(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// checkOwner returns ErrNotOwner unless a principal may change a folder or
+// its content. Like files, folders without an owner are open to everyone,
+// and a nil principal, a service or an administrator, may change any.
+func (folder *Folder) checkOwner(principal *Principal) error {
+	if principal == nil || folder.Owner == "" || folder.Owner == principal.Subject {
+		return nil
+	}
+	return fmt.Errorf("%w: folder %s belongs to another user", ErrNotOwner, folder.ID)
+}
+
+// folderPath returns a folder with its ancestors, top-level first
+func (f *FileStorageManager) folderPath(id string) ([]*Folder, error) {
+	var path []*Folder
+	for id != "" {
+		if len(path) > MaxFolderDepth {
+			return nil, fmt.Errorf("%w: folder %s is nested too deep", ErrInvalidFolder, id)
+		}
+
+		folder, err := f.folderStore.GetFolder(id)
+		if err != nil {
+			return nil, err
+		}
+		path = append([]*Folder{folder}, path...)
+		id = folder.ParentID
+	}
+	return path, nil
+}
+
+// folderHeight returns the number of folder levels from a folder down to
+// its most deeply nested subfolder, 1 for folders without subfolders
+func (f *FileStorageManager) folderHeight(id string) int {
+	folders, err := f.folderStore.ListFolders(id)
+	if err != nil {
+		return 1
+	}
+
+	height := 0
+	for _, folder := range folders {
+		if h := f.folderHeight(folder.ID); h > height {
+			height = h
+		}
+	}
+	return height + 1
+}
+
+// checkFolderNameFree returns ErrFolderExists when a folder other than
+// exceptID in a parent folder has a name
+func (f *FileStorageManager) checkFolderNameFree(parentID, name, exceptID string) error {
+	siblings, err := f.folderStore.ListFolders(parentID)
+	if err != nil {
+		return err
+	}
+
+	for _, sibling := range siblings {
+		if sibling.ID != exceptID && strings.EqualFold(sibling.Name, name) {
+			return fmt.Errorf("%w: %q", ErrFolderExists, name)
+		}
+	}
+	return nil
+}
+
+// checkFolderName trims a folder name, rejecting names that are empty, too
+// long or would read as a path
+func checkFolderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "", name == ".", name == "..", strings.ContainsAny(name, "/\\"),
+		utf8.RuneCountInString(name) > MaxFolderNameLength:
+		return "", fmt.Errorf("%w: invalid name %q", ErrInvalidFolder, name)
+	}
+	return name, nil
+}
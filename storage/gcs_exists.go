@@ -0,0 +1,68 @@
+// pkg/storage/gcs_exists.go
+
+package storage
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+const (
+	// BucketExistsTTL is how long a GCS bucket is known to exist before
+	// operations check it again
+	BucketExistsTTL = 5 * time.Minute
+
+	// ObjectExistsTTL is how long a GCS object is known to exist before
+	// signing a link to it or reading it checks it again
+	ObjectExistsTTL = 30 * time.Second
+
+	gcsBucketExistsPrefix = "gcs-bucket:"
+	gcsObjectExistsPrefix = "gcs-object:"
+)
+
+// SetExistsCache sets the cache GCS bucket and object checks are kept in,
+// which saves a round trip to GCS per check while the result is fresh. A
+// shared cache lets every instance of the service reuse the same checks.
+func (f *FileStorageManager) SetExistsCache(cache Cache) {
+	f.existsCache = cache
+}
+
+// checkGcsBucketExists checks that a bucket exists, skipping the check
+// while it is known to from the last BucketExistsTTL. Missing buckets are
+// not remembered.
+func (f *FileStorageManager) checkGcsBucketExists(ctx context.Context, bucket *storage.BucketHandle) error {
+	key := gcsBucketExistsPrefix + bucket.BucketName()
+	if f.existsCache.Has(key) {
+		return nil
+	}
+
+	if _, err := bucket.Attrs(ctx); err != nil {
+		return err
+	}
+	f.existsCache.Set(key, "ok", BucketExistsTTL)
+	return nil
+}
+
+// checkGcsObjectExists checks that an object exists like
+// checkGcsBucketExists, remembering it for ObjectExistsTTL. Only use it where
+// an object deleted in the meantime fails the operation anyway, such as
+// signing a link to it or reading it.
+func (f *FileStorageManager) checkGcsObjectExists(ctx context.Context, obj *storage.ObjectHandle) error {
+	key := gcsObjectExistsPrefix + obj.BucketName() + "/" + obj.ObjectName()
+	if f.existsCache.Has(key) {
+		return nil
+	}
+
+	if _, err := obj.Attrs(ctx); err != nil {
+		return err
+	}
+	f.existsCache.Set(key, "ok", ObjectExistsTTL)
+	return nil
+}
+
+// forgetGcsObject drops the check of an object that was deleted or moved
+func (f *FileStorageManager) forgetGcsObject(bucketname, key string) {
+	f.existsCache.Delete(gcsObjectExistsPrefix + bucketname + "/" + key)
+}
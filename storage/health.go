@@ -0,0 +1,188 @@
+// pkg/storage/health.go
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Statuses of the dependency checks in a HealthReport
+const (
+	CheckOK      = "ok"
+	CheckFailed  = "failed"
+	CheckSkipped = "skipped" // The dependency is not configured
+)
+
+// BucketCheckTTL is how long the result of a bucket reachability check is
+// reused, so frequent probes do not turn into provider requests
+const BucketCheckTTL = 30 * time.Second
+
+// CheckResult is the status of one dependency
+type CheckResult struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// HealthReport is the readiness of the service and each of its dependencies
+type HealthReport struct {
+	Status string                  `json:"status"` // CheckOK when no check failed
+	Checks map[string]*CheckResult `json:"checks"`
+}
+
+// checkResults remembers recent check results
+type checkResults struct {
+	mu      sync.Mutex
+	results map[string]*CheckResult
+}
+
+// CheckReadiness checks that the service can do its work: that a token for
+// the storage API can be acquired, that the configured S3 and GCS buckets are
+// reachable and that the cache works. Dependencies that are not configured
+// are skipped.
+func (f *FileStorageManager) CheckReadiness(ctx context.Context) *HealthReport {
+	checks := map[string]func(context.Context) (string, error){
+		"token": f.checkToken,
+		"s3":    f.cachedCheck("s3", f.checkAwsBucket),
+		"gcs":   f.cachedCheck("gcs", f.checkGcsBucket),
+		"cache": f.checkCache,
+	}
+
+	report := &HealthReport{Status: CheckOK, Checks: make(map[string]*CheckResult, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) (string, error)) {
+			defer wg.Done()
+			result := runCheck(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status == CheckFailed {
+				report.Status = CheckFailed
+			}
+		}(name, check)
+	}
+
+	wg.Wait()
+	return report
+}
+
+// runCheck times a check and records its outcome
+func runCheck(ctx context.Context, check func(context.Context) (string, error)) *CheckResult {
+	start := time.Now()
+	status, err := check(ctx)
+
+	result := &CheckResult{Status: status, CheckedAt: start}
+	if status != CheckSkipped {
+		result.Duration = time.Since(start).Round(time.Millisecond).String()
+	}
+	if err != nil {
+		result.Status = CheckFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// cachedCheck wraps a check so its result is reused for BucketCheckTTL
+func (f *FileStorageManager) cachedCheck(name string, check func(context.Context) (string, error)) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		f.bucketChecks.mu.Lock()
+		cached := f.bucketChecks.results[name]
+		f.bucketChecks.mu.Unlock()
+
+		if cached != nil && time.Since(cached.CheckedAt) < BucketCheckTTL {
+			if cached.Error != "" {
+				return cached.Status, fmt.Errorf("%s", cached.Error)
+			}
+			return cached.Status, nil
+		}
+
+		result := runCheck(ctx, check)
+
+		f.bucketChecks.mu.Lock()
+		f.bucketChecks.results[name] = result
+		f.bucketChecks.mu.Unlock()
+
+		if result.Error != "" {
+			return result.Status, fmt.Errorf("%s", result.Error)
+		}
+		return result.Status, nil
+	}
+}
+
+// checkToken acquires a token for the storage API, reusing a cached one
+func (f *FileStorageManager) checkToken(ctx context.Context) (string, error) {
+	if f.config.HostURI == "" || f.tokenManager == nil {
+		return CheckSkipped, nil
+	}
+
+	token, err := f.tokenManager.GetToken()
+	if err != nil {
+		return CheckFailed, err
+	}
+	if token == "" {
+		return CheckFailed, fmt.Errorf("authorization server returned an empty token")
+	}
+	return CheckOK, nil
+}
+
+// checkAwsBucket checks that the configured S3 bucket exists and is accessible
+func (f *FileStorageManager) checkAwsBucket(ctx context.Context) (string, error) {
+	if f.config.AWSBucket == "" {
+		return CheckSkipped, nil
+	}
+
+	s3Client, err := f.GetAwsClient()
+	if err != nil {
+		return CheckFailed, err
+	}
+
+	_, err = s3Client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(f.config.AWSBucket),
+	})
+	if err != nil {
+		return CheckFailed, err
+	}
+	return CheckOK, nil
+}
+
+// checkGcsBucket checks that the configured GCS bucket exists and is accessible
+func (f *FileStorageManager) checkGcsBucket(ctx context.Context) (string, error) {
+	if f.config.GCSBucket == "" {
+		return CheckSkipped, nil
+	}
+
+	gcsClient, err := f.GetGcsClient("")
+	if err != nil {
+		return CheckFailed, err
+	}
+	defer gcsClient.Close()
+
+	if _, err := gcsClient.Bucket(f.config.GCSBucket).Attrs(ctx); err != nil {
+		return CheckFailed, err
+	}
+	return CheckOK, nil
+}
+
+// checkCache writes and reads back a value in the cache download tokens are kept in
+func (f *FileStorageManager) checkCache(ctx context.Context) (string, error) {
+	key := "health-check:" + time.Now().Format(time.RFC3339Nano)
+	f.tokenCache.Set(key, "ok", time.Minute)
+	defer f.tokenCache.Delete(key)
+
+	if value, found := f.tokenCache.Get(key); !found || value != "ok" {
+		return CheckFailed, fmt.Errorf("cache did not return the value written")
+	}
+	return CheckOK, nil
+}
@@ -0,0 +1,182 @@
+// pkg/storage/image.go
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// DefaultJPEGQuality is the JPEG quality used for generated images
+const DefaultJPEGQuality = 85
+
+// isImageType reports whether a content type is an image format that can be decoded and encoded
+func isImageType(mimetype string) bool {
+	switch normalizeMimeType(mimetype) {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// decodeImage decodes JPEG, PNG or GIF data
+func decodeImage(data []byte) (image.Image, string, error) {
+	return image.Decode(bytes.NewReader(data))
+}
+
+// imageExtension returns the file extension for an image content type
+func imageExtension(mimetype string) string {
+	switch normalizeMimeType(mimetype) {
+	case "image/png":
+		return "png"
+	case "image/gif":
+		return "gif"
+	}
+	return "jpg"
+}
+
+// encodeImage encodes an image as JPEG, PNG or GIF
+func encodeImage(img image.Image, mimetype string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch normalizeMimeType(mimetype) {
+	case "image/jpeg":
+		return encodeJPEG(img, quality)
+	case "image/png":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	case "image/gif":
+		if err := gif.Encode(&buf, img, nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported image format %q", mimetype)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeJPEG encodes an image as JPEG with the given quality, flattening any
+// transparency onto a white background
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	bounds := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, bounds.Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fitImage scales img down so that neither side exceeds maxWidth or maxHeight,
+// preserving the aspect ratio. Images that already fit are returned unchanged;
+// a limit of 0 leaves that dimension unconstrained.
+func fitImage(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return img
+	}
+
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && float64(height)*scale > float64(maxHeight) {
+		scale = float64(maxHeight) / float64(height)
+	}
+	if scale >= 1 {
+		return img
+	}
+
+	newWidth := int(float64(width)*scale + 0.5)
+	newHeight := int(float64(height)*scale + 0.5)
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
+	}
+
+	return resizeImage(img, newWidth, newHeight)
+}
+
+// resizeImage downscales img to width x height by averaging the source pixels
+// covered by each destination pixel
+func resizeImage(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	}
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := (y + 1) * srcHeight / height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := (x + 1) * srcWidth / width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				offset := sy*src.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[offset])
+					g += uint32(src.Pix[offset+1])
+					b += uint32(src.Pix[offset+2])
+					a += uint32(src.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// cropToAspect crops img around its centre to the aspect ratio of width x height
+func cropToAspect(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	cropWidth, cropHeight := srcWidth, srcWidth*height/width
+	if cropHeight > srcHeight {
+		cropWidth, cropHeight = srcHeight*width/height, srcHeight
+	}
+	if cropWidth == srcWidth && cropHeight == srcHeight || cropWidth < 1 || cropHeight < 1 {
+		return img
+	}
+
+	x0 := bounds.Min.X + (srcWidth-cropWidth)/2
+	y0 := bounds.Min.Y + (srcHeight-cropHeight)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, cropWidth, cropHeight))
+	draw.Draw(dst, dst.Bounds(), img, image.Point{X: x0, Y: y0}, draw.Src)
+	return dst
+}
@@ -0,0 +1,168 @@
+// pkg/storage/image_metadata.go
+
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// pngSignature starts every PNG file
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// JPEG markers removed by stripJPEGMetadata: APP1 (EXIF, XMP), APP13 (IPTC) and comments
+var strippedJPEGMarkers = map[byte]bool{
+	0xE1: true,
+	0xED: true,
+	0xFE: true,
+}
+
+// PNG chunks removed by stripPNGMetadata
+var strippedPNGChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// StripImageMetadata removes EXIF, GPS, XMP and text metadata from JPEG and
+// PNG data without re-encoding the image. Other content is returned unchanged.
+//
+// The EXIF orientation tag is removed along with everything else, so viewers
+// that honour it may show stripped photos rotated.
+func StripImageMetadata(data []byte, mimetype string) ([]byte, error) {
+	switch normalizeMimeType(mimetype) {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	}
+	return data, nil
+}
+
+// stripJPEGMetadata drops metadata segments from a JPEG, copying everything
+// from the start of scan marker onwards verbatim
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, fmt.Errorf("%w: missing JPEG start of image marker", ErrInvalidImage)
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	pos := 2
+	for pos < len(data) {
+		if data[pos] != 0xFF || pos+1 >= len(data) {
+			return nil, fmt.Errorf("%w: malformed JPEG segment", ErrInvalidImage)
+		}
+
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte
+			pos++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Standalone markers without a length
+			out.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		case marker == 0xD9 || marker == 0xDA:
+			// End of image or start of scan, the rest is entropy coded data
+			out.Write(data[pos:])
+			return out.Bytes(), nil
+		}
+
+		if pos+4 > len(data) {
+			return nil, fmt.Errorf("%w: truncated JPEG segment", ErrInvalidImage)
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:pos+4]))
+		if end > len(data) {
+			return nil, fmt.Errorf("%w: truncated JPEG segment", ErrInvalidImage)
+		}
+
+		if !strippedJPEGMarkers[marker] {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+
+	return out.Bytes(), nil
+}
+
+// stripPNGMetadata drops EXIF, text and timestamp chunks from a PNG
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, fmt.Errorf("%w: missing PNG signature", ErrInvalidImage)
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString(pngSignature)
+
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, fmt.Errorf("%w: truncated PNG chunk", ErrInvalidImage)
+		}
+
+		// Length, type, data and CRC
+		end := pos + 12 + int(binary.BigEndian.Uint32(data[pos:pos+4]))
+		if end > len(data) || end < pos {
+			return nil, fmt.Errorf("%w: truncated PNG chunk", ErrInvalidImage)
+		}
+
+		chunkType := string(data[pos+4 : pos+8])
+		if !strippedPNGChunks[chunkType] {
+			out.Write(data[pos:end])
+		}
+		pos = end
+
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// SetStripImageMetadata toggles removing EXIF/GPS metadata from image uploads before they are stored
+func (f *FileStorageManager) SetStripImageMetadata(strip bool) {
+	f.stripImageMetadata = strip
+}
+
+// stripPayloadMetadata removes image metadata from an upload payload when enabled
+func (f *FileStorageManager) stripPayloadMetadata(payload *uploadPayload) error {
+	if !f.stripImageMetadata {
+		return nil
+	}
+
+	data, err := StripImageMetadata(payload.data, payload.mimeType)
+	if err != nil {
+		return err
+	}
+
+	payload.data = data
+	return nil
+}
+
+// stripBase64Metadata removes image metadata from base64 encoded content when enabled
+func (f *FileStorageManager) stripBase64Metadata(base64file, mimetype string) (string, error) {
+	if !f.stripImageMetadata || (normalizeMimeType(mimetype) != "image/jpeg" && normalizeMimeType(mimetype) != "image/png") {
+		return base64file, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(base64file)
+	if err != nil {
+		return "", err
+	}
+
+	data, err = StripImageMetadata(data, mimetype)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,241 @@
+// pkg/storage/image_processor.go
+
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+)
+
+// ImageProcessor transforms an image in an ImagePipeline
+type ImageProcessor interface {
+	Process(img *ProcessedImage) error
+}
+
+// ImageProcessorFunc adapts a function to the ImageProcessor interface
+type ImageProcessorFunc func(img *ProcessedImage) error
+
+// Process calls fn(img)
+func (fn ImageProcessorFunc) Process(img *ProcessedImage) error {
+	return fn(img)
+}
+
+// ProcessedImage is the image passed along an ImageProcessor chain
+type ProcessedImage struct {
+	Image    image.Image
+	MimeType string // Output format, one of "image/jpeg", "image/png" or "image/gif"
+	Quality  int    // JPEG quality of the output
+}
+
+// ImageDecoder decodes an image format the standard library cannot read, e.g. HEIC
+type ImageDecoder func(data []byte) (image.Image, error)
+
+// ImagePipeline decodes image uploads, runs them through a chain of processors
+// and re-encodes the result before it is stored
+type ImagePipeline struct {
+	Processors []ImageProcessor
+	Decoders   map[string]ImageDecoder // Additional decoders keyed by MIME type
+	Quality    int                     // Default JPEG quality
+}
+
+// NewImagePipeline creates an image pipeline running the given processors in order
+func NewImagePipeline(processors ...ImageProcessor) *ImagePipeline {
+	return &ImagePipeline{
+		Processors: processors,
+		Decoders:   make(map[string]ImageDecoder),
+		Quality:    DefaultJPEGQuality,
+	}
+}
+
+// NewImagePipelineFromConfig creates the configured image pipeline, returning
+// nil when no image processing is configured
+func NewImagePipelineFromConfig(config *Config) (*ImagePipeline, error) {
+	var processors []ImageProcessor
+
+	if config.ImageMaxWidth > 0 || config.ImageMaxHeight > 0 {
+		processors = append(processors, ResizeProcessor(config.ImageMaxWidth, config.ImageMaxHeight))
+	}
+
+	if config.ImageFormat != "" {
+		if !isImageType(config.ImageFormat) {
+			return nil, fmt.Errorf("unsupported image format %q", config.ImageFormat)
+		}
+		processors = append(processors, ConvertProcessor(config.ImageFormat))
+	}
+
+	if len(processors) == 0 {
+		return nil, nil
+	}
+
+	pipeline := NewImagePipeline(processors...)
+	if config.ImageQuality > 0 {
+		pipeline.Quality = config.ImageQuality
+	}
+
+	return pipeline, nil
+}
+
+// Use appends processors to the chain
+func (p *ImagePipeline) Use(processors ...ImageProcessor) {
+	p.Processors = append(p.Processors, processors...)
+}
+
+// RegisterDecoder adds a decoder for a MIME type, so that e.g. HEIC photos can
+// be converted to JPEG by a ConvertProcessor
+func (p *ImagePipeline) RegisterDecoder(mimetype string, decoder ImageDecoder) {
+	if p.Decoders == nil {
+		p.Decoders = make(map[string]ImageDecoder)
+	}
+	p.Decoders[normalizeMimeType(mimetype)] = decoder
+}
+
+// Handles reports whether the pipeline processes content of the given type
+func (p *ImagePipeline) Handles(mimetype string) bool {
+	if p == nil || len(p.Processors) == 0 {
+		return false
+	}
+	_, ok := p.Decoders[normalizeMimeType(mimetype)]
+	return ok || isImageType(mimetype)
+}
+
+// Process runs image data through the processor chain, returning the encoded
+// result and its content type. Content the pipeline does not handle is
+// returned unchanged.
+func (p *ImagePipeline) Process(data []byte, mimetype string) ([]byte, string, error) {
+	if !p.Handles(mimetype) {
+		return data, mimetype, nil
+	}
+
+	img, err := p.decode(data, mimetype)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	processed := &ProcessedImage{
+		Image:    img,
+		MimeType: normalizeMimeType(mimetype),
+		Quality:  p.Quality,
+	}
+	if !isImageType(processed.MimeType) {
+		processed.MimeType = "image/jpeg"
+	}
+
+	for _, processor := range p.Processors {
+		if err := processor.Process(processed); err != nil {
+			return nil, "", err
+		}
+	}
+
+	out, err := encodeImage(processed.Image, processed.MimeType, processed.Quality)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return out, processed.MimeType, nil
+}
+
+// decode decodes data with a registered decoder or the standard library
+func (p *ImagePipeline) decode(data []byte, mimetype string) (image.Image, error) {
+	if decoder, ok := p.Decoders[normalizeMimeType(mimetype)]; ok {
+		return decoder(data)
+	}
+
+	img, _, err := decodeImage(data)
+	return img, err
+}
+
+// ResizeProcessor scales images down to fit within maxWidth x maxHeight,
+// preserving the aspect ratio. A limit of 0 leaves that dimension unconstrained.
+func ResizeProcessor(maxWidth, maxHeight int) ImageProcessor {
+	return ImageProcessorFunc(func(img *ProcessedImage) error {
+		img.Image = fitImage(img.Image, maxWidth, maxHeight)
+		return nil
+	})
+}
+
+// CropProcessor crops images around their centre to the aspect ratio of width x height
+func CropProcessor(width, height int) ImageProcessor {
+	return ImageProcessorFunc(func(img *ProcessedImage) error {
+		if width <= 0 || height <= 0 {
+			return fmt.Errorf("invalid crop aspect ratio %dx%d", width, height)
+		}
+		img.Image = cropToAspect(img.Image, width, height)
+		return nil
+	})
+}
+
+// ConvertProcessor changes the output format of images, e.g. to "image/jpeg"
+func ConvertProcessor(mimetype string) ImageProcessor {
+	return ImageProcessorFunc(func(img *ProcessedImage) error {
+		mimetype := normalizeMimeType(mimetype)
+		if !isImageType(mimetype) {
+			return fmt.Errorf("unsupported image format %q", mimetype)
+		}
+		img.MimeType = mimetype
+		return nil
+	})
+}
+
+// QualityProcessor sets the JPEG quality of the output
+func QualityProcessor(quality int) ImageProcessor {
+	return ImageProcessorFunc(func(img *ProcessedImage) error {
+		img.Quality = quality
+		return nil
+	})
+}
+
+// SetImagePipeline sets the pipeline applied to image uploads, nil disables it
+func (f *FileStorageManager) SetImagePipeline(pipeline *ImagePipeline) {
+	f.imagePipeline = pipeline
+	f.imagePipelineErr = nil
+}
+
+// ImagePipeline returns the configured image pipeline, if any, e.g. to
+// process images on demand
+func (f *FileStorageManager) ImagePipeline() *ImagePipeline {
+	return f.imagePipeline
+}
+
+// processImagePayload runs an image payload through the image pipeline,
+// updating its content type and extension when the format changes
+func (f *FileStorageManager) processImagePayload(payload *uploadPayload) error {
+	if f.imagePipelineErr != nil {
+		return f.imagePipelineErr
+	}
+	if !f.imagePipeline.Handles(payload.mimeType) {
+		return nil
+	}
+
+	data, mimeType, err := f.imagePipeline.Process(payload.data, payload.mimeType)
+	if err != nil {
+		return err
+	}
+
+	if mimeType != payload.mimeType {
+		payload.extension = imageExtension(mimeType)
+	}
+	payload.data = data
+	payload.mimeType = mimeType
+	return nil
+}
+
+// processImageBase64 runs a base64 encoded image through the image pipeline,
+// returning the re-encoded content with its content type and extension
+func (f *FileStorageManager) processImageBase64(base64file, mimetype, extension string) (string, string, string, error) {
+	data, err := base64.StdEncoding.DecodeString(base64file)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	payload := &uploadPayload{
+		data:      data,
+		extension: extension,
+		mimeType:  mimetype,
+	}
+	if err := f.processImagePayload(payload); err != nil {
+		return "", "", "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(payload.data), payload.mimeType, payload.extension, nil
+}
@@ -0,0 +1,161 @@
+// pkg/storage/inventory.go
+
+package storage
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Inventory formats
+const (
+	InventoryCSV  = "csv"  // Comma separated values with a header row
+	InventoryJSON = "json" // One JSON object per line
+)
+
+// InventoryPrefix is the key prefix stored inventories are kept below
+const InventoryPrefix = ".inventory/"
+
+// InventoryEntry is an object listed in a bucket inventory
+type InventoryEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"mod_time"`
+	StorageClass string    `json:"storage_class"`
+	Checksum     string    `json:"checksum"`
+}
+
+// inventoryHeader names the columns of a CSV inventory
+var inventoryHeader = []string{"key", "size", "mod_time", "storage_class", "checksum"}
+
+// InventoryContentType returns the content type of an inventory format
+func InventoryContentType(format string) string {
+	if format == InventoryJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// WriteInventory walks a prefix of a bucket, the whole bucket when prefix is
+// "", and writes the key, size, modification time, storage class and
+// checksum of every object to w as format, for audits and cost reviews.
+// Objects are written as they are listed, so large buckets are not held in
+// memory. It returns how many objects were written.
+func (f *FileStorageManager) WriteInventory(w io.Writer, provider, prefix, format, bucketname, projectID string) (int, error) {
+	var write func(entry *InventoryEntry) error
+	var flush func() error
+
+	switch format {
+	case InventoryCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(inventoryHeader); err != nil {
+			return 0, err
+		}
+		write = func(entry *InventoryEntry) error {
+			return cw.Write([]string{entry.Key, strconv.FormatInt(entry.Size, 10), entry.ModTime.UTC().Format(time.RFC3339), entry.StorageClass, entry.Checksum})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+
+	case InventoryJSON:
+		encoder := json.NewEncoder(w)
+		write = func(entry *InventoryEntry) error { return encoder.Encode(entry) }
+		flush = func() error { return nil }
+
+	default:
+		return 0, fmt.Errorf("%w: %q, use %s or %s", ErrInvalidInventoryFormat, format, InventoryCSV, InventoryJSON)
+	}
+
+	count := 0
+	err := f.walkPrefix(provider, prefix, bucketname, projectID, func(object ObjectInfo) error {
+		count++
+		return write(&InventoryEntry{
+			Key:          object.Key,
+			Size:         object.Size,
+			ModTime:      object.ModTime,
+			StorageClass: object.StorageClass,
+			Checksum:     object.Checksum,
+		})
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, flush()
+}
+
+// StoreInventory writes the inventory of a prefix into the bucket itself,
+// below InventoryPrefix, and returns its key as the FileID of the response.
+// The inventory is built in memory before it is stored.
+func (f *FileStorageManager) StoreInventory(provider, prefix, format, bucketname, projectID string) (*FileResponse, error) {
+	if bucketname == "" {
+		bucketname = f.defaultBucket(provider)
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	count, err := f.WriteInventory(buf, provider, prefix, format, bucketname, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	if f.encryptor != nil {
+		if data, err = f.encryptor.Encrypt(data); err != nil {
+			return nil, err
+		}
+	}
+
+	key := inventoryKey(prefix, format, time.Now())
+	switch provider {
+	case ProviderAWS:
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return nil, err
+		}
+		err = putAwsObject(s3Client, bucketname, key, InventoryContentType(format), data)
+		if err != nil {
+			return nil, err
+		}
+
+	case ProviderGCS:
+		gcsClient, err := f.GetGcsClient(projectID)
+		if err != nil {
+			return nil, err
+		}
+		defer gcsClient.Close()
+
+		err = putGcsObject(context.Background(), gcsClient.Bucket(bucketname), key, InventoryContentType(format), data)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown provider %q", provider)
+	}
+
+	return &FileResponse{
+		Status:  StatusSuccess,
+		Message: fmt.Sprintf("INVENTORY %d objects", count),
+		FileID:  key,
+	}, nil
+}
+
+// inventoryKey returns the key an inventory of a prefix is stored under,
+// e.g. ".inventory/20240817T020000Z-exports.csv"
+func inventoryKey(prefix, format string, at time.Time) string {
+	name := at.UTC().Format("20060102T150405Z")
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		name += "-" + strings.ReplaceAll(prefix, "/", "-")
+	}
+	return InventoryPrefix + name + "." + format
+}
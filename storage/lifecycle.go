@@ -0,0 +1,26 @@
+// pkg/storage/lifecycle.go
+
+package storage
+
+// Close releases the resources held by the manager: it stops the scheduled
+// jobs, waiting for runs in progress, stops accepting background jobs, stops
+// the janitors of the caches it made and closes the idle connections of its
+// S3 and REST clients. GCS clients are made per operation and closed when it
+// returns. Call Shutdown first to wait for queued background jobs; stores,
+// caches and audit logs set on the manager belong to the caller and are
+// left open. The manager must not be used after Close, which is safe to
+// call more than once.
+func (f *FileStorageManager) Close() error {
+	f.closeOnce.Do(func() {
+		f.stopScheduler()
+		f.jobs.close()
+
+		for _, cache := range f.ownCaches {
+			cache.Close()
+		}
+
+		f.awsClients.close()
+		f.restClient.CloseIdleConnections()
+	})
+	return nil
+}
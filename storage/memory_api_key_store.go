@@ -0,0 +1,58 @@
+// pkg/storage/memory_api_key_store.go
+
+package storage
+
+import (
+	"sync"
+)
+
+// MemoryAPIKeyStore implements a non-persistent in-memory API key store
+type MemoryAPIKeyStore struct {
+	keys map[string]APIKey
+	mu   sync.RWMutex
+}
+
+// NewMemoryAPIKeyStore creates a new memory API key store
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{
+		keys: make(map[string]APIKey),
+	}
+}
+
+// SaveAPIKey inserts or replaces an API key
+func (m *MemoryAPIKeyStore) SaveAPIKey(key *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *key
+	stored.Scopes = append([]string(nil), key.Scopes...)
+	m.keys[key.ID] = stored
+	return nil
+}
+
+// GetAPIKey retrieves an API key by ID
+func (m *MemoryAPIKeyStore) GetAPIKey(id string) (*APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, found := m.keys[id]
+	if !found {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	return &key, nil
+}
+
+// ListAPIKeys returns all API keys
+func (m *MemoryAPIKeyStore) ListAPIKeys() ([]*APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]*APIKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		k := key
+		keys = append(keys, &k)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,43 @@
+// pkg/storage/memory_audit_log.go
+
+package storage
+
+import (
+	"sync"
+)
+
+// MemoryAuditLog implements a non-persistent in-memory audit log, for
+// development and tests
+type MemoryAuditLog struct {
+	events []AuditEvent
+	mu     sync.RWMutex
+}
+
+// NewMemoryAuditLog creates a new memory audit log
+func NewMemoryAuditLog() *MemoryAuditLog {
+	return &MemoryAuditLog{}
+}
+
+// AppendAuditEvent adds an event to the end of the log
+func (m *MemoryAuditLog) AppendAuditEvent(event *AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = append(m.events, *event)
+	return nil
+}
+
+// QueryAuditEvents returns the events matching a query, newest first
+func (m *MemoryAuditLog) QueryAuditEvents(query AuditQuery) ([]*AuditEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := []*AuditEvent{}
+	for i := len(m.events) - 1; i >= 0 && len(events) < query.Limit; i-- {
+		if event := m.events[i]; query.Matches(&event) {
+			events = append(events, &event)
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,125 @@
+// pkg/storage/memory_budget.go
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultMemoryQueueTimeout is how long an upload waits for the memory
+// budget to free up before failing with ErrMemoryBudgetExceeded
+const DefaultMemoryQueueTimeout = 5 * time.Second
+
+// memoryBudget bounds the bytes of uploads buffered in memory at the same
+// time. Uploads staged in temp files are not counted.
+type memoryBudget struct {
+	mu    sync.Mutex
+	limit int64 // 0 means unlimited
+	used  int64
+	wait  time.Duration
+	freed chan struct{} // Closed and replaced whenever memory is given back
+}
+
+// newMemoryBudget creates the configured budget
+func newMemoryBudget(config *Config) *memoryBudget {
+	return &memoryBudget{limit: config.MemoryBudget, wait: config.MemoryQueueTimeout, freed: make(chan struct{})}
+}
+
+// SetMemoryBudget bounds the bytes of uploads buffered in memory at the same
+// time, so a burst of uploads cannot get the service killed for running out
+// of memory; 0 removes the bound. Uploads over the budget wait up to timeout
+// for memory to free up and then fail with ErrMemoryBudgetExceeded. A timeout
+// of 0 falls back to DefaultMemoryQueueTimeout and a negative one fails them
+// right away.
+func (f *FileStorageManager) SetMemoryBudget(budget int64, timeout time.Duration) {
+	f.memoryBudget.mu.Lock()
+	defer f.memoryBudget.mu.Unlock()
+
+	f.memoryBudget.limit = budget
+	f.memoryBudget.wait = timeout
+	f.memoryBudget.notify()
+}
+
+// BufferedBytes returns the bytes of uploads currently buffered in memory
+func (f *FileStorageManager) BufferedBytes() int64 {
+	return f.memoryBudget.usage()
+}
+
+// reserveMemory counts size bytes an upload is about to buffer against the
+// memory budget, waiting for memory to free up when the budget is spent. It
+// returns the function giving the bytes back once they are no longer held.
+// Waiting stops when ctx is done.
+func (f *FileStorageManager) reserveMemory(ctx context.Context, size int64) (func(), error) {
+	b := f.memoryBudget
+
+	b.mu.Lock()
+	limit, wait := b.limit, b.wait
+	b.mu.Unlock()
+
+	if limit > 0 && size > limit {
+		return nil, fmt.Errorf("%w: %d bytes exceed the memory budget of %d", ErrFileTooLarge, size, limit)
+	}
+	if wait == 0 {
+		wait = DefaultMemoryQueueTimeout
+	}
+	deadline := time.Now().Add(wait)
+
+	for {
+		b.mu.Lock()
+		if b.limit <= 0 || b.used+size <= b.limit {
+			b.used += size
+			used := b.used
+			b.mu.Unlock()
+			f.metrics.observeBuffered(used)
+
+			var once sync.Once
+			return func() { once.Do(func() { f.metrics.observeBuffered(b.free(size)) }) }, nil
+		}
+		freed := b.freed
+		b.mu.Unlock()
+
+		remaining := time.Until(deadline)
+		if wait < 0 || remaining <= 0 {
+			f.metrics.observeMemoryRejection()
+			return nil, fmt.Errorf("%w: %d bytes buffered, %d more requested", ErrMemoryBudgetExceeded, b.usage(), size)
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-freed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// free gives back bytes reserved against the budget, returning the bytes
+// still reserved
+func (b *memoryBudget) free(size int64) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.used -= size
+	b.notify()
+	return b.used
+}
+
+// usage returns the bytes reserved against the budget
+func (b *memoryBudget) usage() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.used
+}
+
+// notify wakes the uploads waiting for memory, with the budget locked
+func (b *memoryBudget) notify() {
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
@@ -17,12 +17,15 @@ type Item struct {
 type MemoryCache struct {
 	items map[string]Item
 	mu    sync.RWMutex
+	stop  chan struct{} // Closed by Close to stop the janitor
+	once  sync.Once
 }
 
 // NewMemoryCache creates a new memory cache
 func NewMemoryCache() *MemoryCache {
 	cache := &MemoryCache{
 		items: make(map[string]Item),
+		stop:  make(chan struct{}),
 	}
 
 	// Start janitor to clean expired items
@@ -97,11 +100,22 @@ func (c *MemoryCache) janitor() {
 	defer ticker.Stop()
 
 	for {
-		<-ticker.C
-		c.deleteExpired()
+		select {
+		case <-ticker.C:
+			c.deleteExpired()
+		case <-c.stop:
+			return
+		}
 	}
 }
 
+// Close stops the janitor cleaning up expired items. The cache keeps
+// working, but expired items are only dropped when they are replaced.
+func (c *MemoryCache) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	return nil
+}
+
 // deleteExpired removes expired items
 func (c *MemoryCache) deleteExpired() {
 	now := time.Now().UnixNano()
@@ -0,0 +1,79 @@
+// pkg/storage/memory_folder_store.go
+
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryFolderStore implements a non-persistent in-memory folder store
+type MemoryFolderStore struct {
+	folders map[string]Folder
+	mu      sync.RWMutex
+}
+
+// NewMemoryFolderStore creates a new memory folder store
+func NewMemoryFolderStore() *MemoryFolderStore {
+	return &MemoryFolderStore{
+		folders: make(map[string]Folder),
+	}
+}
+
+// SaveFolder inserts or replaces a folder
+func (m *MemoryFolderStore) SaveFolder(folder *Folder) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.folders[folder.ID] = *folder
+	return nil
+}
+
+// GetFolder retrieves a folder by ID
+func (m *MemoryFolderStore) GetFolder(id string) (*Folder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	folder, found := m.folders[id]
+	if !found {
+		return nil, ErrFolderNotFound
+	}
+
+	return &folder, nil
+}
+
+// DeleteFolder removes a folder
+func (m *MemoryFolderStore) DeleteFolder(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.folders, id)
+	return nil
+}
+
+// ListFolders returns the folders in a parent folder by name
+func (m *MemoryFolderStore) ListFolders(parentID string) ([]*Folder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	folders := []*Folder{}
+	for _, folder := range m.folders {
+		if folder.ParentID == parentID {
+			folder := folder
+			folders = append(folders, &folder)
+		}
+	}
+	sortFolders(folders)
+
+	return folders, nil
+}
+
+// sortFolders orders folders by name, then ID
+func sortFolders(folders []*Folder) {
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].Name != folders[j].Name {
+			return folders[i].Name < folders[j].Name
+		}
+		return folders[i].ID < folders[j].ID
+	})
+}
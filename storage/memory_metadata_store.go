@@ -0,0 +1,95 @@
+// pkg/storage/memory_metadata_store.go
+
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryMetadataStore implements a non-persistent in-memory metadata store,
+// which also keeps share links, API keys and folders
+type MemoryMetadataStore struct {
+	*MemoryShareLinkStore
+	*MemoryAPIKeyStore
+	*MemoryFolderStore
+	files map[string]FileRecord
+	mu    sync.RWMutex
+}
+
+// NewMemoryMetadataStore creates a new memory metadata store
+func NewMemoryMetadataStore() *MemoryMetadataStore {
+	return &MemoryMetadataStore{
+		MemoryShareLinkStore: NewMemoryShareLinkStore(),
+		MemoryAPIKeyStore:    NewMemoryAPIKeyStore(),
+		MemoryFolderStore:    NewMemoryFolderStore(),
+		files:                make(map[string]FileRecord),
+	}
+}
+
+// SaveFile inserts or replaces a file record
+func (m *MemoryMetadataStore) SaveFile(record *FileRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.files[record.FileID] = *record
+	return nil
+}
+
+// GetFile retrieves a file record by ID
+func (m *MemoryMetadataStore) GetFile(fileID string) (*FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, found := m.files[fileID]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	return &record, nil
+}
+
+// FindByHash retrieves the file records with a content hash within a provider bucket, oldest first
+func (m *MemoryMetadataStore) FindByHash(provider, bucket, sha256 string) ([]*FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	records := []*FileRecord{}
+	for _, record := range m.files {
+		if record.Provider == provider && record.Bucket == bucket && record.SHA256 == sha256 {
+			record := record
+			records = append(records, &record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].CreatedAt.Before(records[j].CreatedAt)
+		}
+		return records[i].FileID < records[j].FileID
+	})
+	return records, nil
+}
+
+// ListFiles returns every file record
+func (m *MemoryMetadataStore) ListFiles() ([]*FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	records := make([]*FileRecord, 0, len(m.files))
+	for _, record := range m.files {
+		record := record
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
+
+// DeleteFile removes a file record
+func (m *MemoryMetadataStore) DeleteFile(fileID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.files, fileID)
+	return nil
+}
@@ -0,0 +1,79 @@
+// pkg/storage/memory_session_store.go
+
+package storage
+
+import (
+	"sync"
+)
+
+// MemorySessionStore implements a non-persistent in-memory upload session store
+type MemorySessionStore struct {
+	sessions map[string]*ChunkedUpload
+	mu       sync.RWMutex
+}
+
+// NewMemorySessionStore creates a new memory upload session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*ChunkedUpload),
+	}
+}
+
+// SaveSession inserts or replaces an upload session
+func (m *MemorySessionStore) SaveSession(upload *ChunkedUpload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[upload.UploadID] = upload.copy()
+	return nil
+}
+
+// GetSession retrieves an upload session by ID
+func (m *MemorySessionStore) GetSession(uploadID string) (*ChunkedUpload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	upload, found := m.sessions[uploadID]
+	if !found {
+		return nil, ErrUploadNotFound
+	}
+
+	return upload.copy(), nil
+}
+
+// AddChunk records a received chunk of an upload session
+func (m *MemorySessionStore) AddChunk(uploadID string, chunk *Chunk) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, found := m.sessions[uploadID]
+	if !found {
+		return ErrUploadNotFound
+	}
+
+	c := *chunk
+	upload.Chunks[chunk.Index] = &c
+	return nil
+}
+
+// DeleteSession removes an upload session
+func (m *MemorySessionStore) DeleteSession(uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, uploadID)
+	return nil
+}
+
+// ListSessions returns all upload sessions
+func (m *MemorySessionStore) ListSessions() ([]*ChunkedUpload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sessions := make([]*ChunkedUpload, 0, len(m.sessions))
+	for _, upload := range m.sessions {
+		sessions = append(sessions, upload.copy())
+	}
+
+	return sessions, nil
+}
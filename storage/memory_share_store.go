@@ -0,0 +1,85 @@
+// pkg/storage/memory_share_store.go
+
+package storage
+
+import (
+	"sync"
+)
+
+// MemoryShareLinkStore implements a non-persistent in-memory share link store
+type MemoryShareLinkStore struct {
+	links map[string]ShareLink
+	mu    sync.RWMutex
+}
+
+// NewMemoryShareLinkStore creates a new memory share link store
+func NewMemoryShareLinkStore() *MemoryShareLinkStore {
+	return &MemoryShareLinkStore{
+		links: make(map[string]ShareLink),
+	}
+}
+
+// SaveShareLink inserts or replaces a share link
+func (m *MemoryShareLinkStore) SaveShareLink(link *ShareLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.links[link.Token] = *link
+	return nil
+}
+
+// GetShareLink retrieves a share link by token
+func (m *MemoryShareLinkStore) GetShareLink(token string) (*ShareLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	link, found := m.links[token]
+	if !found {
+		return nil, ErrShareLinkNotFound
+	}
+
+	return &link, nil
+}
+
+// ConsumeShareLink counts a download of a share link
+func (m *MemoryShareLinkStore) ConsumeShareLink(token string) (*ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, found := m.links[token]
+	if !found {
+		return nil, ErrShareLinkNotFound
+	}
+	if link.exhausted() {
+		return nil, ErrShareLinkExhausted
+	}
+
+	link.Downloads++
+	m.links[token] = link
+	return &link, nil
+}
+
+// DeleteShareLink removes a share link
+func (m *MemoryShareLinkStore) DeleteShareLink(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.links, token)
+	return nil
+}
+
+// ListShareLinks returns the share links to a file, or all share links when fileID is ""
+func (m *MemoryShareLinkStore) ListShareLinks(fileID string) ([]*ShareLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	links := make([]*ShareLink, 0)
+	for _, link := range m.links {
+		if fileID == "" || link.FileID == fileID {
+			l := link
+			links = append(links, &l)
+		}
+	}
+
+	return links, nil
+}
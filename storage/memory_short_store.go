@@ -0,0 +1,51 @@
+// pkg/storage/memory_short_store.go
+
+package storage
+
+import (
+	"sync"
+)
+
+// MemoryShortLinkStore implements a non-persistent in-memory short link store
+type MemoryShortLinkStore struct {
+	links map[string]ShortLink
+	mu    sync.RWMutex
+}
+
+// NewMemoryShortLinkStore creates a new memory short link store
+func NewMemoryShortLinkStore() *MemoryShortLinkStore {
+	return &MemoryShortLinkStore{
+		links: make(map[string]ShortLink),
+	}
+}
+
+// SaveShortLink inserts or replaces a short link
+func (m *MemoryShortLinkStore) SaveShortLink(link *ShortLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.links[link.Code] = *link
+	return nil
+}
+
+// GetShortLink retrieves a short link by code
+func (m *MemoryShortLinkStore) GetShortLink(code string) (*ShortLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	link, found := m.links[code]
+	if !found {
+		return nil, ErrShortLinkNotFound
+	}
+
+	return &link, nil
+}
+
+// DeleteShortLink removes a short link
+func (m *MemoryShortLinkStore) DeleteShortLink(code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.links, code)
+	return nil
+}
@@ -0,0 +1,91 @@
+// pkg/storage/metadata.go
+
+package storage
+
+import "time"
+
+// Storage providers recorded in file metadata
+const (
+	ProviderREST = "rest"
+	ProviderAWS  = "s3"
+	ProviderGCS  = "gcs"
+)
+
+// FileRecord represents the metadata recorded for a stored file
+type FileRecord struct {
+	FileID        string      `json:"file_id"`
+	Provider      string      `json:"provider"`
+	Bucket        string      `json:"bucket,omitempty"`
+	FileName      string      `json:"file_name"`
+	FileExt       string      `json:"file_ext"`
+	MimeType      string      `json:"file_mimetype"`
+	FileSize      int64       `json:"file_size"`
+	MD5           string      `json:"md5,omitempty"`
+	SHA256        string      `json:"sha256,omitempty"`
+	PublicLink    string      `json:"public_link,omitempty"`
+	Thumbnails    []Thumbnail `json:"thumbnails,omitempty"`
+	ScanStatus    string      `json:"scan_status,omitempty"`
+	ScanSignature string      `json:"scan_signature,omitempty"`
+	PreviewLink   string      `json:"preview_link,omitempty"`
+	Renditions    []Rendition `json:"renditions,omitempty"`
+	Visibility    string      `json:"visibility,omitempty"`    // VisibilityPublic or VisibilityPrivate once set
+	Owner         string      `json:"owner,omitempty"`         // Subject that uploaded the file, if known
+	ACL           []Grant     `json:"acl,omitempty"`           // Access granted to others than the owner
+	UploadStatus  string      `json:"upload_status,omitempty"` // UploadPending until a direct upload is confirmed
+	Tags          []string    `json:"tags,omitempty"`
+	Folder        string      `json:"folder_id,omitempty"`     // Virtual folder the file is in, "" for none
+	ExpiresAt     time.Time   `json:"expires_at,omitempty"`    // Zero means the file expires by policy, if any; the confirmation deadline of pending uploads
+	ExpiryAction  string      `json:"expiry_action,omitempty"` // ExpiryDelete or ExpiryArchive once ExpiresAt is set
+	ExpiredAt     time.Time   `json:"expired_at,omitempty"`    // When the file was deleted or archived on expiry
+	Attributes    Attributes  `json:"attributes,omitempty"`    // Custom attributes, see AttributeSchema
+	Variant       string      `json:"variant,omitempty"`       // How the content is stored, uploads only share objects of the same variant
+	References    int         `json:"references,omitempty"`    // Uploads sharing the object by deduplication, 0 for one
+	CreatedAt     time.Time   `json:"created_at"`
+}
+
+// MetadataStore interface for persisting file records
+type MetadataStore interface {
+	// SaveFile inserts or replaces the record for record.FileID
+	SaveFile(record *FileRecord) error
+	// GetFile returns the record for a file ID or ErrRecordNotFound
+	GetFile(fileID string) (*FileRecord, error)
+	// FindByHash returns the records with the given SHA-256 in a provider bucket, oldest first
+	FindByHash(provider, bucket, sha256 string) ([]*FileRecord, error)
+	// DeleteFile removes the record for a file ID, if any
+	DeleteFile(fileID string) error
+}
+
+// FileLister is implemented by metadata stores that can list all of their
+// records, which usage statistics are computed from
+type FileLister interface {
+	// ListFiles returns every file record
+	ListFiles() ([]*FileRecord, error)
+}
+
+// FileInfo converts the record into the FileInfo returned by upload and info calls
+func (r *FileRecord) FileInfo() *FileInfo {
+	info := &FileInfo{
+		FileExt:       r.FileExt,
+		FileID:        r.FileID,
+		FileMimeType:  r.MimeType,
+		FileName:      r.FileName,
+		FileSize:      r.FileSize,
+		PublicLink:    r.PublicLink,
+		Timestamp:     r.CreatedAt,
+		MD5:           r.MD5,
+		SHA256:        r.SHA256,
+		Thumbnails:    r.Thumbnails,
+		ScanStatus:    r.ScanStatus,
+		ScanSignature: r.ScanSignature,
+		PreviewLink:   r.PreviewLink,
+		Renditions:    r.Renditions,
+		Visibility:    r.Visibility,
+		Owner:         r.Owner,
+		ExpiresAt:     r.ExpiresAt,
+		Attributes:    r.Attributes,
+	}
+	if r.Provider == ProviderGCS {
+		info.Bucket = r.Bucket
+	}
+	return info
+}
@@ -0,0 +1,86 @@
+// pkg/storage/metadata_hooks.go
+
+package storage
+
+import "fmt"
+
+// MetadataHooks run custom logic around changes to file records, e.g.
+// notifying the LMS of a new submission or syncing a search index, without
+// changing the metadata store. Every hook is optional. Hooks run in the
+// request making the change, so slow work should be handed off, e.g. to
+// SubmitJob. Moving a file, such as to the trash, deletes the record under
+// the old ID and saves it under the new one.
+type MetadataHooks struct {
+	// OnBeforeSave runs before a record is saved and may change it. An
+	// error cancels the save and is returned to the caller.
+	OnBeforeSave func(record *FileRecord) error
+	// OnAfterSave runs once a record was saved
+	OnAfterSave func(record *FileRecord)
+	// OnBeforeDelete runs before a record is removed. An error cancels the
+	// removal and is returned to the caller.
+	OnBeforeDelete func(record *FileRecord) error
+	// OnAfterDelete runs once a record was removed
+	OnAfterDelete func(record *FileRecord)
+}
+
+// AddMetadataHooks registers hooks run around every change to a file
+// record, in the order they were added
+func (f *FileStorageManager) AddMetadataHooks(hooks MetadataHooks) {
+	f.metadataHooks = append(f.metadataHooks, hooks)
+}
+
+// saveRecord saves a record in the metadata store, running the hooks
+func (f *FileStorageManager) saveRecord(record *FileRecord) error {
+	for _, hooks := range f.metadataHooks {
+		if hooks.OnBeforeSave == nil {
+			continue
+		}
+		if err := hooks.OnBeforeSave(record); err != nil {
+			return fmt.Errorf("saving the record of %s: %w", record.FileID, err)
+		}
+	}
+
+	if err := f.metadataStore.SaveFile(record); err != nil {
+		return err
+	}
+
+	for _, hooks := range f.metadataHooks {
+		if hooks.OnAfterSave != nil {
+			hooks.OnAfterSave(record)
+		}
+	}
+	return nil
+}
+
+// deleteRecord removes the record of a file from the metadata store,
+// running the hooks when there is one
+func (f *FileStorageManager) deleteRecord(fileID string) error {
+	if len(f.metadataHooks) == 0 {
+		return f.metadataStore.DeleteFile(fileID)
+	}
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if err != nil {
+		return f.metadataStore.DeleteFile(fileID)
+	}
+
+	for _, hooks := range f.metadataHooks {
+		if hooks.OnBeforeDelete == nil {
+			continue
+		}
+		if err := hooks.OnBeforeDelete(record); err != nil {
+			return fmt.Errorf("removing the record of %s: %w", fileID, err)
+		}
+	}
+
+	if err := f.metadataStore.DeleteFile(fileID); err != nil {
+		return err
+	}
+
+	for _, hooks := range f.metadataHooks {
+		if hooks.OnAfterDelete != nil {
+			hooks.OnAfterDelete(record)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+// pkg/storage/metrics.go
+
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Operations recorded by Metrics
+const (
+	OperationUpload   = "upload"
+	OperationDownload = "download"
+	OperationDelete   = "delete"
+	OperationSign     = "sign"
+)
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the operation latency histogram
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics counts storage operations per provider and token refreshes, and
+// writes them in the Prometheus text exposition format
+type Metrics struct {
+	mu             sync.Mutex
+	buckets        []float64
+	operations     map[operationKey]*operationStats
+	tokenRefreshes map[string]uint64          // By result
+	transports     map[string]*transportStats // By client, see newTransport
+
+	bufferedBytes    int64  // Upload bytes buffered in memory
+	memoryRejections uint64 // Uploads refused because the memory budget was spent
+}
+
+// operationKey identifies the series of an operation on a provider
+type operationKey struct {
+	operation string
+	provider  string
+}
+
+// operationStats accumulates the series of an operation on a provider
+type operationStats struct {
+	results  map[string]uint64 // By result, "ok" or an error class
+	bytes    int64
+	counts   []uint64 // Observations per latency bucket, not cumulative
+	sum      float64
+	observed uint64
+}
+
+// NewMetrics creates an empty metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets:        DefaultLatencyBuckets,
+		operations:     make(map[operationKey]*operationStats),
+		tokenRefreshes: make(map[string]uint64),
+		transports:     make(map[string]*transportStats),
+	}
+}
+
+// Metrics returns the metrics the manager records its operations in
+func (f *FileStorageManager) Metrics() *Metrics {
+	return f.metrics
+}
+
+// observe records an operation that started at start. A response with an
+// error status counts as failed like an error does.
+func (m *Metrics) observe(operation, provider string, start time.Time, bytes int64, response *FileResponse, err error) {
+	result := errorClass(err)
+	if err == nil && response != nil && response.Status == StatusError {
+		result = "provider"
+	}
+
+	elapsed := time.Since(start).Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := operationKey{operation: operation, provider: provider}
+	stats := m.operations[key]
+	if stats == nil {
+		stats = &operationStats{results: make(map[string]uint64), counts: make([]uint64, len(m.buckets)+1)}
+		m.operations[key] = stats
+	}
+
+	stats.results[result]++
+	if result == "ok" && bytes > 0 {
+		stats.bytes += bytes
+	}
+	stats.counts[sort.SearchFloat64s(m.buckets, elapsed)]++
+	stats.sum += elapsed
+	stats.observed++
+}
+
+// observeTokenRefresh records an attempt to acquire a new token
+func (m *Metrics) observeTokenRefresh(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokenRefreshes[errorClass(err)]++
+}
+
+// observeBuffered records the upload bytes buffered in memory
+func (m *Metrics) observeBuffered(bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bufferedBytes = bytes
+}
+
+// observeMemoryRejection counts an upload refused because the memory budget was spent
+func (m *Metrics) observeMemoryRejection() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.memoryRejections++
+}
+
+// errorClass groups errors into a few label values
+func errorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrFileNotFound):
+		return "not_found"
+	case errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrFileTypeNotAllowed), errors.Is(err, ErrMimeTypeMismatch),
+		errors.Is(err, ErrFileInfected), errors.Is(err, ErrInvalidImage), errors.Is(err, ErrInvalidArchive),
+		errors.Is(err, ErrArchiveLimitExceeded), errors.Is(err, ErrInvalidObjectHeader):
+		return "rejected"
+	case errors.Is(err, ErrProviderBusy), errors.Is(err, ErrMemoryBudgetExceeded):
+		return "busy"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "error"
+}
+
+// downloadObserver returns a callback recording a download from provider
+// that starts now, for an ObjectFile to call when it is closed
+func (f *FileStorageManager) downloadObserver(provider string) func(read int64, err error) {
+	start := time.Now()
+	return func(read int64, err error) {
+		f.metrics.observe(OperationDownload, provider, start, read, nil, err)
+	}
+}
+
+// responseSize returns the size of the file a response describes, 0 when unknown
+func responseSize(response *FileResponse) int64 {
+	if response == nil || response.Info == nil {
+		return 0
+	}
+	return response.Info.FileSize
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]operationKey, 0, len(m.operations))
+	for key := range m.operations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].operation != keys[j].operation {
+			return keys[i].operation < keys[j].operation
+		}
+		return keys[i].provider < keys[j].provider
+	})
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	fmt.Fprintln(cw, "# HELP filestorage_operations_total Storage operations by provider and result.")
+	fmt.Fprintln(cw, "# TYPE filestorage_operations_total counter")
+	for _, key := range keys {
+		results := m.operations[key].results
+		for _, result := range sortedKeys(results) {
+			fmt.Fprintf(cw, "filestorage_operations_total{operation=%q,provider=%q,result=%q} %d\n", key.operation, key.provider, result, results[result])
+		}
+	}
+
+	fmt.Fprintln(cw, "# HELP filestorage_operation_bytes_total Bytes transferred by successful storage operations.")
+	fmt.Fprintln(cw, "# TYPE filestorage_operation_bytes_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(cw, "filestorage_operation_bytes_total{operation=%q,provider=%q} %d\n", key.operation, key.provider, m.operations[key].bytes)
+	}
+
+	fmt.Fprintln(cw, "# HELP filestorage_operation_duration_seconds Latency of storage operations.")
+	fmt.Fprintln(cw, "# TYPE filestorage_operation_duration_seconds histogram")
+	for _, key := range keys {
+		stats := m.operations[key]
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += stats.counts[i]
+			fmt.Fprintf(cw, "filestorage_operation_duration_seconds_bucket{operation=%q,provider=%q,le=%q} %d\n", key.operation, key.provider, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(cw, "filestorage_operation_duration_seconds_bucket{operation=%q,provider=%q,le=\"+Inf\"} %d\n", key.operation, key.provider, stats.observed)
+		fmt.Fprintf(cw, "filestorage_operation_duration_seconds_sum{operation=%q,provider=%q} %g\n", key.operation, key.provider, stats.sum)
+		fmt.Fprintf(cw, "filestorage_operation_duration_seconds_count{operation=%q,provider=%q} %d\n", key.operation, key.provider, stats.observed)
+	}
+
+	fmt.Fprintln(cw, "# HELP filestorage_token_refreshes_total Attempts to acquire a storage API token by result.")
+	fmt.Fprintln(cw, "# TYPE filestorage_token_refreshes_total counter")
+	for _, result := range sortedKeys(m.tokenRefreshes) {
+		fmt.Fprintf(cw, "filestorage_token_refreshes_total{result=%q} %d\n", result, m.tokenRefreshes[result])
+	}
+
+	m.writeTransports(cw)
+
+	fmt.Fprintln(cw, "# HELP filestorage_buffered_bytes Upload bytes buffered in memory.")
+	fmt.Fprintln(cw, "# TYPE filestorage_buffered_bytes gauge")
+	fmt.Fprintf(cw, "filestorage_buffered_bytes %d\n", m.bufferedBytes)
+
+	fmt.Fprintln(cw, "# HELP filestorage_memory_rejections_total Uploads refused because the memory budget was spent.")
+	fmt.Fprintln(cw, "# TYPE filestorage_memory_rejections_total counter")
+	fmt.Fprintf(cw, "filestorage_memory_rejections_total %d\n", m.memoryRejections)
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// sortedKeys returns the keys of a counter map in order
+func sortedKeys(counters map[string]uint64) []string {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingWriter counts the bytes written and keeps the first error
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+// Write implements io.Writer
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// meteredTokenManager counts the token refreshes of a token manager
+type meteredTokenManager struct {
+	TokenManager
+	metrics *Metrics
+}
+
+// GenerateToken implements TokenManager
+func (t *meteredTokenManager) GenerateToken() (string, error) {
+	token, err := t.TokenManager.GenerateToken()
+	t.metrics.observeTokenRefresh(err)
+	return token, err
+}
+
+// GetToken implements TokenManager, counting a refresh when no token was cached
+func (t *meteredTokenManager) GetToken() (string, error) {
+	refresh := !t.TokenManager.HasToken()
+	token, err := t.TokenManager.GetToken()
+	if refresh {
+		t.metrics.observeTokenRefresh(err)
+	}
+	return token, err
+}
@@ -0,0 +1,89 @@
+// pkg/storage/mime_sniff.go
+
+package storage
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// sniffLength is the number of bytes inspected by http.DetectContentType
+const sniffLength = 512
+
+// zipBasedTypes are formats stored as zip containers, which sniff as application/zip
+var zipBasedTypes = []string{
+	"application/vnd.openxmlformats-officedocument.",
+	"application/vnd.oasis.opendocument.",
+	"application/epub+zip",
+	"application/java-archive",
+	"application/x-zip-compressed",
+}
+
+// textBasedTypes are non text/* formats that sniff as text/plain
+var textBasedTypes = []string{
+	"application/json",
+	"application/xml",
+	"application/javascript",
+	"application/x-yaml",
+	"application/yaml",
+	"application/csv",
+	"image/svg+xml",
+}
+
+// sniffMimeType detects the content type from the magic bytes at the start of data
+func sniffMimeType(data []byte) string {
+	if len(data) > sniffLength {
+		data = data[:sniffLength]
+	}
+	return normalizeMimeType(http.DetectContentType(data))
+}
+
+// sniffBase64MimeType detects the content type of base64 encoded data
+// without decoding more than the sniffed prefix
+func sniffBase64MimeType(base64file string) string {
+	prefixLength := base64.StdEncoding.EncodedLen(sniffLength)
+	if len(base64file) > prefixLength {
+		base64file = base64file[:prefixLength]
+	}
+
+	data, err := base64.StdEncoding.DecodeString(base64file)
+	if err != nil {
+		return "application/octet-stream"
+	}
+
+	return sniffMimeType(data)
+}
+
+// resolveMimeType picks the content type to store for an upload and reports
+// whether the claimed and sniffed types agree. The sniffed type wins unless it
+// is a generic container that the claimed type is a more specific form of.
+func resolveMimeType(claimedType, sniffedType string) (string, bool) {
+	claimedType = normalizeMimeType(claimedType)
+
+	if claimedType == "" || claimedType == sniffedType {
+		return sniffedType, true
+	}
+
+	switch {
+	case sniffedType == "application/octet-stream":
+		// Unknown signature, nothing to contradict the client with
+		return claimedType, true
+	case sniffedType == "text/plain" && (strings.HasPrefix(claimedType, "text/") || hasTypePrefix(textBasedTypes, claimedType)):
+		return claimedType, true
+	case sniffedType == "application/zip" && hasTypePrefix(zipBasedTypes, claimedType):
+		return claimedType, true
+	}
+
+	return sniffedType, false
+}
+
+// hasTypePrefix reports whether mimetype starts with any entry in list
+func hasTypePrefix(list []string, mimetype string) bool {
+	for _, prefix := range list {
+		if strings.HasPrefix(mimetype, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,493 @@
+//go:build mongodb
+
+// pkg/storage/mongo_metadata_store.go
+//
+// The MongoDB metadata store is only built with -tags mongodb, so services
+// not using it do not link the MongoDB driver.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoTimeout bounds every MongoDB operation of the metadata store
+const mongoTimeout = 10 * time.Second
+
+// mongoFileDocument is how a file record is kept in MongoDB. The fields
+// besides record are copies of its fields for filtering and indexing, like
+// the columns of PostgresMetadataStore.
+type mongoFileDocument struct {
+	FileID       string     `bson:"_id"`
+	Provider     string     `bson:"provider"`
+	Bucket       string     `bson:"bucket"`
+	Owner        string     `bson:"owner"`
+	FileName     string     `bson:"file_name"`
+	MimeType     string     `bson:"mime_type"`
+	FileSize     int64      `bson:"file_size"`
+	SHA256       string     `bson:"sha256"`
+	Tags         []string   `bson:"tags"`
+	UploadStatus string     `bson:"upload_status"`
+	Folder       string     `bson:"folder_id"`
+	CreatedAt    time.Time  `bson:"created_at"`
+	UpdatedAt    time.Time  `bson:"updated_at"`
+	Record       FileRecord `bson:"record"`
+}
+
+// mongoShareLinkDocument is how a share link is kept in MongoDB
+type mongoShareLinkDocument struct {
+	Token         string    `bson:"_id"`
+	Provider      string    `bson:"provider"`
+	Bucket        string    `bson:"bucket"`
+	ProjectID     string    `bson:"project_id"`
+	FileID        string    `bson:"file_id"`
+	MaxDownloads  int       `bson:"max_downloads"`
+	Downloads     int       `bson:"downloads"`
+	ExpiresAt     time.Time `bson:"expires_at"`
+	PasswordHash  string    `bson:"password_hash"`
+	AllowedEmails []string  `bson:"allowed_emails"`
+	CreatedAt     time.Time `bson:"created_at"`
+}
+
+// mongoAPIKeyDocument is how an API key is kept in MongoDB, with the hashes
+// of its secrets
+type mongoAPIKeyDocument struct {
+	ID                 string     `bson:"_id"`
+	Name               string     `bson:"name"`
+	Scopes             []string   `bson:"scopes"`
+	Hash               string     `bson:"hash"`
+	PreviousHash       string     `bson:"previous_hash"`
+	PreviousValidUntil *time.Time `bson:"previous_valid_until"`
+	CreatedAt          time.Time  `bson:"created_at"`
+	RotatedAt          *time.Time `bson:"rotated_at"`
+	RevokedAt          *time.Time `bson:"revoked_at"`
+}
+
+// Collections share links and API keys are kept in, next to the collection
+// of file records
+const (
+	mongoShareLinks = "file_share_links"
+	mongoAPIKeys    = "file_api_keys"
+)
+
+// MongoMetadataStore implements a persistent metadata store in a MongoDB
+// collection, so records survive restarts and are shared by every instance
+// of the service. Share links and API keys are kept in the file_share_links
+// and file_api_keys collections of the same database. It does not keep
+// folders; set a FolderStore for them.
+type MongoMetadataStore struct {
+	collection *mongo.Collection
+	links      *mongo.Collection
+	keys       *mongo.Collection
+}
+
+// NewMongoMetadataStore creates a metadata store on a MongoDB collection,
+// creating its indexes on owner, tags, creation time and content hash if
+// they do not exist:
+//
+//	client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+//	store, err := storage.NewMongoMetadataStore(client.Database("filestorage").Collection("file_records"))
+//	fs.SetMetadataStore(store)
+func NewMongoMetadataStore(collection *mongo.Collection) (*MongoMetadataStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "bucket", Value: 1}, {Key: "sha256", Value: 1}}},
+		{Keys: bson.D{{Key: "owner", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	links := collection.Database().Collection(mongoShareLinks)
+	_, err = links.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "file_id", Value: 1}}})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoMetadataStore{
+		collection: collection,
+		links:      links,
+		keys:       collection.Database().Collection(mongoAPIKeys),
+	}, nil
+}
+
+// SaveFile inserts or replaces a file record
+func (m *MongoMetadataStore) SaveFile(record *FileRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	tags := record.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	document := mongoFileDocument{
+		FileID:       record.FileID,
+		Provider:     record.Provider,
+		Bucket:       record.Bucket,
+		Owner:        record.Owner,
+		FileName:     record.FileName,
+		MimeType:     record.MimeType,
+		FileSize:     record.FileSize,
+		SHA256:       record.SHA256,
+		Tags:         tags,
+		UploadStatus: record.UploadStatus,
+		Folder:       record.Folder,
+		CreatedAt:    record.CreatedAt,
+		UpdatedAt:    time.Now(),
+		Record:       *record,
+	}
+
+	_, err := m.collection.ReplaceOne(ctx, bson.M{"_id": record.FileID}, document, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetFile retrieves a file record by ID
+func (m *MongoMetadataStore) GetFile(fileID string) (*FileRecord, error) {
+	return m.findOne(bson.M{"_id": fileID})
+}
+
+// FindByHash retrieves the file records with a content hash within a provider bucket, oldest first
+func (m *MongoMetadataStore) FindByHash(provider, bucket, sha256 string) ([]*FileRecord, error) {
+	return m.find(bson.M{"provider": provider, "bucket": bucket, "sha256": sha256},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+}
+
+// DeleteFile removes a file record
+func (m *MongoMetadataStore) DeleteFile(fileID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	_, err := m.collection.DeleteOne(ctx, bson.M{"_id": fileID})
+	return err
+}
+
+// ListFiles returns every file record, oldest first
+func (m *MongoMetadataStore) ListFiles() ([]*FileRecord, error) {
+	return m.find(bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+}
+
+// mongoSortFields are the fields search results are ordered by
+var mongoSortFields = map[string]string{
+	SortCreatedAt: "created_at",
+	SortName:      "file_name",
+	SortSize:      "file_size",
+}
+
+// SearchFiles returns a page of the records matching a query
+func (m *MongoMetadataStore) SearchFiles(query FileQuery) (*SearchResult, error) {
+	filter := bson.M{
+		"_id":           bson.M{"$not": primitive.Regex{Pattern: "^(" + regexp.QuoteMeta(TrashPrefix) + "|" + regexp.QuoteMeta(ArchivePrefix) + "|" + regexp.QuoteMeta(VersionPrefix) + ")"}},
+		"upload_status": bson.M{"$ne": UploadPending},
+	}
+	if query.Name != "" {
+		filter["file_name"] = primitive.Regex{Pattern: regexp.QuoteMeta(query.Name), Options: "i"}
+	}
+	if family := strings.TrimSuffix(query.MimeType, "*"); family != query.MimeType {
+		filter["mime_type"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(family), Options: "i"}
+	} else if query.MimeType != "" {
+		filter["mime_type"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query.MimeType) + `\s*(;|$)`, Options: "i"}
+	}
+	if query.Tag != "" {
+		filter["tags"] = query.Tag
+	}
+	if query.Owner != "" {
+		filter["owner"] = query.Owner
+	}
+	if query.Provider != "" {
+		filter["provider"] = query.Provider
+	}
+	if query.Folder == RootFolder {
+		filter["folder_id"] = bson.M{"$in": bson.A{"", nil}}
+	} else if query.Folder != "" {
+		filter["folder_id"] = query.Folder
+	}
+	created := bson.M{}
+	if !query.From.IsZero() {
+		created["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		created["$lt"] = query.To
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	total, err := m.collection.CountDocuments(ctx, filter)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	direction := 1
+	if strings.HasPrefix(query.Sort, "-") {
+		direction = -1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: mongoSortFields[strings.TrimPrefix(query.Sort, "-")], Value: direction}, {Key: "_id", Value: direction}}).
+		SetSkip(int64(query.Offset)).
+		SetLimit(int64(query.Limit))
+
+	files, err := m.find(filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SearchResult{Files: files, Total: int(total), Offset: query.Offset, Limit: query.Limit}, nil
+}
+
+// SaveShareLink inserts or replaces a share link
+func (m *MongoMetadataStore) SaveShareLink(link *ShareLink) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	document := mongoShareLinkDocument{
+		Token:         link.Token,
+		Provider:      link.Provider,
+		Bucket:        link.Bucket,
+		ProjectID:     link.ProjectID,
+		FileID:        link.FileID,
+		MaxDownloads:  link.MaxDownloads,
+		Downloads:     link.Downloads,
+		ExpiresAt:     link.ExpiresAt,
+		PasswordHash:  link.PasswordHash,
+		AllowedEmails: link.AllowedEmails,
+		CreatedAt:     link.CreatedAt,
+	}
+
+	_, err := m.links.ReplaceOne(ctx, bson.M{"_id": link.Token}, document, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetShareLink retrieves a share link by token
+func (m *MongoMetadataStore) GetShareLink(token string) (*ShareLink, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	var document mongoShareLinkDocument
+	err := m.links.FindOne(ctx, bson.M{"_id": token}).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return document.shareLink(), nil
+}
+
+// ConsumeShareLink counts a download of a share link. The count is only
+// raised while it is below the limit, in a single update, so concurrent
+// downloads by any instance never exceed it.
+func (m *MongoMetadataStore) ConsumeShareLink(token string) (*ShareLink, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": token,
+		"$or": bson.A{
+			bson.M{"max_downloads": 0},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$downloads", "$max_downloads"}}},
+		},
+	}
+	var document mongoShareLinkDocument
+	err := m.links.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"downloads": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&document)
+	if err == nil {
+		return document.shareLink(), nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	if _, err := m.GetShareLink(token); err != nil {
+		return nil, err
+	}
+	return nil, ErrShareLinkExhausted
+}
+
+// DeleteShareLink removes a share link
+func (m *MongoMetadataStore) DeleteShareLink(token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	_, err := m.links.DeleteOne(ctx, bson.M{"_id": token})
+	return err
+}
+
+// ListShareLinks returns the share links to a file, or all share links when fileID is ""
+func (m *MongoMetadataStore) ListShareLinks(fileID string) ([]*ShareLink, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	filter := bson.M{}
+	if fileID != "" {
+		filter["file_id"] = fileID
+	}
+
+	cursor, err := m.links.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	links := []*ShareLink{}
+	for cursor.Next(ctx) {
+		var document mongoShareLinkDocument
+		if err := cursor.Decode(&document); err != nil {
+			return nil, err
+		}
+		links = append(links, document.shareLink())
+	}
+
+	return links, cursor.Err()
+}
+
+// shareLink returns the share link kept in a document
+func (d *mongoShareLinkDocument) shareLink() *ShareLink {
+	return &ShareLink{
+		Token:         d.Token,
+		Provider:      d.Provider,
+		Bucket:        d.Bucket,
+		ProjectID:     d.ProjectID,
+		FileID:        d.FileID,
+		MaxDownloads:  d.MaxDownloads,
+		Downloads:     d.Downloads,
+		ExpiresAt:     d.ExpiresAt,
+		PasswordHash:  d.PasswordHash,
+		AllowedEmails: d.AllowedEmails,
+		CreatedAt:     d.CreatedAt,
+	}
+}
+
+// SaveAPIKey inserts or replaces an API key. Only the hashes of its secrets
+// are stored.
+func (m *MongoMetadataStore) SaveAPIKey(key *APIKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	document := mongoAPIKeyDocument{
+		ID:                 key.ID,
+		Name:               key.Name,
+		Scopes:             key.Scopes,
+		Hash:               key.Hash,
+		PreviousHash:       key.PreviousHash,
+		PreviousValidUntil: key.PreviousValidUntil,
+		CreatedAt:          key.CreatedAt,
+		RotatedAt:          key.RotatedAt,
+		RevokedAt:          key.RevokedAt,
+	}
+
+	_, err := m.keys.ReplaceOne(ctx, bson.M{"_id": key.ID}, document, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetAPIKey retrieves an API key by ID
+func (m *MongoMetadataStore) GetAPIKey(id string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	var document mongoAPIKeyDocument
+	err := m.keys.FindOne(ctx, bson.M{"_id": id}).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return document.apiKey(), nil
+}
+
+// ListAPIKeys returns all API keys, oldest first
+func (m *MongoMetadataStore) ListAPIKeys() ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	cursor, err := m.keys.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []*APIKey{}
+	for cursor.Next(ctx) {
+		var document mongoAPIKeyDocument
+		if err := cursor.Decode(&document); err != nil {
+			return nil, err
+		}
+		keys = append(keys, document.apiKey())
+	}
+
+	return keys, cursor.Err()
+}
+
+// apiKey returns the API key kept in a document
+func (d *mongoAPIKeyDocument) apiKey() *APIKey {
+	return &APIKey{
+		ID:                 d.ID,
+		Name:               d.Name,
+		Scopes:             d.Scopes,
+		Hash:               d.Hash,
+		PreviousHash:       d.PreviousHash,
+		PreviousValidUntil: d.PreviousValidUntil,
+		CreatedAt:          d.CreatedAt,
+		RotatedAt:          d.RotatedAt,
+		RevokedAt:          d.RevokedAt,
+	}
+}
+
+// findOne returns the oldest record matching filter or ErrRecordNotFound
+func (m *MongoMetadataStore) findOne(filter bson.M) (*FileRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	var document mongoFileDocument
+	err := m.collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "created_at", Value: 1}})).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &document.Record, nil
+}
+
+// find returns the records matching filter
+func (m *MongoMetadataStore) find(filter bson.M, opts ...*options.FindOptions) ([]*FileRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	cursor, err := m.collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	records := []*FileRecord{}
+	for cursor.Next(ctx) {
+		var document mongoFileDocument
+		if err := cursor.Decode(&document); err != nil {
+			return nil, err
+		}
+		record := document.Record
+		records = append(records, &record)
+	}
+
+	return records, cursor.Err()
+}
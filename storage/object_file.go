@@ -0,0 +1,348 @@
+// pkg/storage/object_file.go
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ObjectFile is a stored file opened for random access, so it can be served
+// with http.ServeContent. Plain objects are read with ranged provider reads
+// starting at the current offset; encrypted or compressed objects are decoded
+// from the start, skipping to the offset.
+type ObjectFile struct {
+	name        string
+	contentType string
+	etag        string
+	size        int64 // -1 when the decoded size is not known
+	modTime     time.Time
+
+	offset  int64
+	body    io.ReadCloser
+	open    func(offset int64) (io.ReadCloser, error)
+	release func() error
+
+	read    int64 // Bytes read, counted as a download on Close
+	readErr error
+	observe func(read int64, err error)
+}
+
+// Name returns the object key
+func (o *ObjectFile) Name() string { return o.name }
+
+// ContentType returns the stored content type
+func (o *ObjectFile) ContentType() string { return o.contentType }
+
+// ETag returns the entity tag of the stored object, quoted for use in HTTP headers
+func (o *ObjectFile) ETag() string { return o.etag }
+
+// Size returns the size of the file content, or -1 if it is not known
+func (o *ObjectFile) Size() int64 { return o.size }
+
+// ModTime returns when the object was last modified
+func (o *ObjectFile) ModTime() time.Time { return o.modTime }
+
+// Read implements io.Reader, opening the object at the current offset on first use
+func (o *ObjectFile) Read(p []byte) (int, error) {
+	if o.size >= 0 && o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		body, err := o.open(o.offset)
+		if err != nil {
+			o.readErr = err
+			return 0, err
+		}
+		o.body = body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	o.read += int64(n)
+	if err != nil && err != io.EOF {
+		o.readErr = err
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker. Seeking is free until the next Read, which
+// reopens the object at the new offset.
+func (o *ObjectFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		if o.size < 0 {
+			if err := o.measure(); err != nil {
+				return 0, fmt.Errorf("seek from end of %s: %w", o.name, err)
+			}
+		}
+		offset += o.size
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("seek %s: negative position", o.name)
+	}
+
+	if offset != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+// measure determines the size of content whose size was not recorded by
+// decoding it once, which is costly but lets it be served with Range support
+func (o *ObjectFile) measure() error {
+	body, err := o.open(0)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	size, err := io.Copy(io.Discard, body)
+	if err != nil {
+		return err
+	}
+
+	o.size = size
+	return nil
+}
+
+// Close releases the open body and provider client. Files that were read
+// are counted as a download; files only opened for their attributes are not.
+func (o *ObjectFile) Close() error {
+	if o.observe != nil && (o.read > 0 || o.readErr != nil) {
+		o.observe(o.read, o.readErr)
+		o.observe = nil
+	}
+	if o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	if o.release != nil {
+		return o.release()
+	}
+	return nil
+}
+
+// AwsOpenFile opens a file stored in S3 for random access. The returned file
+// holds a slot of the provider until it is closed.
+func (f *FileStorageManager) AwsOpenFile(awsFileID string, bucketname string) (*ObjectFile, error) {
+	return f.AwsOpenFileContext(context.Background(), awsFileID, bucketname)
+}
+
+// AwsOpenFileContext opens a file stored in S3 like AwsOpenFile. Reads of the
+// file fail once ctx is done, aborting the transfer in progress.
+func (f *FileStorageManager) AwsOpenFileContext(ctx context.Context, awsFileID string, bucketname string) (*ObjectFile, error) {
+	// Use default bucket if not specified
+	if bucketname == "" {
+		bucketname = f.config.AWSBucket
+	}
+
+	release, err := f.acquireProvider(ctx, ProviderAWS)
+	if err != nil {
+		return nil, err
+	}
+
+	s3Client, err := f.GetAwsClient()
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	head, err := s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketname),
+		Key:    aws.String(awsFileID),
+	})
+	if err != nil {
+		release()
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+			return nil, fmt.Errorf("%w: %s", ErrFileNotFound, awsFileID)
+		}
+		return nil, err
+	}
+
+	get := func(rangeHeader *string) (io.ReadCloser, error) {
+		result, err := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketname),
+			Key:    aws.String(awsFileID),
+			Range:  rangeHeader,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return result.Body, nil
+	}
+
+	file := &ObjectFile{
+		name:        path.Base(awsFileID),
+		contentType: aws.StringValue(head.ContentType),
+		etag:        aws.StringValue(head.ETag),
+		size:        aws.Int64Value(head.ContentLength),
+		modTime:     aws.TimeValue(head.LastModified),
+		release:     func() error { release(); return nil },
+		observe:     f.downloadObserver(ProviderAWS),
+	}
+
+	contentEncoding := aws.StringValue(head.ContentEncoding)
+	if f.isEncoded(contentEncoding) {
+		file.size = f.decodedSize(ProviderAWS, bucketname, awsFileID)
+		file.open = func(offset int64) (io.ReadCloser, error) {
+			body, err := get(nil)
+			if err != nil {
+				return nil, err
+			}
+			return f.decodeFrom(body, contentEncoding, offset)
+		}
+		return file, nil
+	}
+
+	file.open = func(offset int64) (io.ReadCloser, error) {
+		body, err := get(aws.String(fmt.Sprintf("bytes=%d-", offset)))
+		if err != nil {
+			return nil, err
+		}
+		return f.readAhead(&closerFunc{Reader: f.throttle.Reader(body), close: body.Close}), nil
+	}
+
+	return file, nil
+}
+
+// GcsOpenFile opens a file stored in GCS for random access. The returned file
+// holds a client and a slot of the provider until it is closed.
+func (f *FileStorageManager) GcsOpenFile(gcsFileID string, bucketname string, projectID string) (*ObjectFile, error) {
+	return f.GcsOpenFileContext(context.Background(), gcsFileID, bucketname, projectID)
+}
+
+// GcsOpenFileContext opens a file stored in GCS like GcsOpenFile. Reads of
+// the file fail once ctx is done, aborting the transfer in progress.
+func (f *FileStorageManager) GcsOpenFileContext(ctx context.Context, gcsFileID string, bucketname string, projectID string) (*ObjectFile, error) {
+	// Use default bucket if not specified
+	if bucketname == "" {
+		bucketname = f.config.GCSBucket
+	}
+
+	release, err := f.acquireProvider(ctx, ProviderGCS)
+	if err != nil {
+		return nil, err
+	}
+
+	gcsClient, err := f.GetGcsClient(projectID)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	obj := gcsClient.Bucket(bucketname).Object(gcsFileID)
+
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		gcsClient.Close()
+		release()
+		if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrFileNotFound, gcsFileID)
+		}
+		return nil, err
+	}
+
+	file := &ObjectFile{
+		name:        path.Base(gcsFileID),
+		contentType: attrs.ContentType,
+		etag:        `"` + attrs.Etag + `"`,
+		size:        attrs.Size,
+		modTime:     attrs.Updated,
+		release:     func() error { release(); return gcsClient.Close() },
+		observe:     f.downloadObserver(ProviderGCS),
+	}
+
+	if f.isEncoded(attrs.ContentEncoding) {
+		file.size = f.decodedSize(ProviderGCS, bucketname, gcsFileID)
+		file.open = func(offset int64) (io.ReadCloser, error) {
+			reader, err := obj.ReadCompressed(true).NewReader(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return f.decodeFrom(reader, attrs.ContentEncoding, offset)
+		}
+		return file, nil
+	}
+
+	file.open = func(offset int64) (io.ReadCloser, error) {
+		reader, err := obj.NewRangeReader(ctx, offset, -1)
+		if err != nil {
+			return nil, err
+		}
+		return f.readAhead(&closerFunc{Reader: f.throttle.Reader(reader), close: reader.Close}), nil
+	}
+
+	return file, nil
+}
+
+// OpenFile opens a file stored with an S3 or GCS provider for random access
+func (f *FileStorageManager) OpenFile(provider, fileID, bucketname, projectID string) (*ObjectFile, error) {
+	return f.OpenFileContext(context.Background(), provider, fileID, bucketname, projectID)
+}
+
+// OpenFileContext opens a file stored with an S3 or GCS provider like
+// OpenFile, failing its reads once ctx is done, e.g. when the client of a
+// download disconnects
+func (f *FileStorageManager) OpenFileContext(ctx context.Context, provider, fileID, bucketname, projectID string) (*ObjectFile, error) {
+	switch provider {
+	case ProviderAWS:
+		return f.AwsOpenFileContext(ctx, fileID, bucketname)
+	case ProviderGCS:
+		return f.GcsOpenFileContext(ctx, fileID, bucketname, projectID)
+	}
+
+	return nil, fmt.Errorf("unknown provider %q", provider)
+}
+
+// isEncoded reports whether stored content may differ from the file content,
+// so byte offsets cannot be passed on to the provider
+func (f *FileStorageManager) isEncoded(contentEncoding string) bool {
+	return f.encryptor != nil || (contentEncoding != "" && contentEncoding != "identity")
+}
+
+// decodedSize returns the recorded size of an encoded file, or -1 if it was not recorded
+func (f *FileStorageManager) decodedSize(provider, bucketname, fileID string) int64 {
+	if f.metadataStore == nil {
+		return -1
+	}
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if err != nil || record.Provider != provider || record.Bucket != bucketname {
+		return -1
+	}
+
+	return record.FileSize
+}
+
+// decodeFrom decodes a stored object and skips to offset in the file
+// content, reading ahead of the caller when enabled
+func (f *FileStorageManager) decodeFrom(body io.ReadCloser, contentEncoding string, offset int64) (io.ReadCloser, error) {
+	reader, err := f.decodeReader(f.throttle.Reader(body), contentEncoding)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	if _, err := io.CopyN(io.Discard, reader, offset); err != nil && err != io.EOF {
+		body.Close()
+		return nil, err
+	}
+
+	return f.readAhead(&closerFunc{Reader: reader, close: body.Close}), nil
+}
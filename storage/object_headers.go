@@ -0,0 +1,93 @@
+// pkg/storage/object_headers.go
+
+package storage
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// ObjectHeaders are HTTP headers stored with an uploaded object and served
+// with it by S3, GCS and CDNs in front of them
+type ObjectHeaders struct {
+	CacheControl       string // e.g. "public, max-age=31536000, immutable"
+	ContentDisposition string // e.g. AttachmentDisposition("report.pdf")
+	ContentLanguage    string // e.g. "id"
+}
+
+// AttachmentDisposition returns a Content-Disposition making browsers
+// download the object as filename instead of displaying it
+func AttachmentDisposition(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+// InlineDisposition returns a Content-Disposition letting browsers display the
+// object, using filename when it is saved
+func InlineDisposition(filename string) string {
+	return mime.FormatMediaType("inline", map[string]string{"filename": filename})
+}
+
+// validate rejects header values that cannot be sent in an HTTP header
+func (h ObjectHeaders) validate() error {
+	for name, value := range map[string]string{
+		"Cache-Control":       h.CacheControl,
+		"Content-Disposition": h.ContentDisposition,
+		"Content-Language":    h.ContentLanguage,
+	} {
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("%w: %s", ErrInvalidObjectHeader, name)
+		}
+	}
+
+	return nil
+}
+
+// LinkOptions overrides the response headers of a pre-signed download URL
+type LinkOptions struct {
+	ContentDisposition string            // e.g. AttachmentDisposition("report.pdf") to save the file under its original name
+	ContentType        string            // e.g. "application/pdf"
+	ClientIP           string            // IP address or CIDR range the link may be used from, S3 through CloudFront only
+	Headers            map[string]string // Headers requests with the link must send, presigned URLs only
+}
+
+// validate rejects header values that cannot be sent in an HTTP header
+func (o LinkOptions) validate() error {
+	for name, value := range map[string]string{
+		"Content-Disposition": o.ContentDisposition,
+		"Content-Type":        o.ContentType,
+	} {
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("%w: %s", ErrInvalidObjectHeader, name)
+		}
+	}
+
+	for name, value := range o.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n\x00:") || strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("%w: %q", ErrInvalidObjectHeader, name)
+		}
+	}
+
+	if o.ClientIP != "" && len(o.Headers) > 0 {
+		return fmt.Errorf("%w: client IP and required headers cannot be combined", ErrUnsupportedRestriction)
+	}
+
+	return nil
+}
+
+// responseQuery returns the response header overrides as query parameters, nil when there are none
+func (o LinkOptions) responseQuery() url.Values {
+	query := url.Values{}
+	if o.ContentDisposition != "" {
+		query.Set("response-content-disposition", o.ContentDisposition)
+	}
+	if o.ContentType != "" {
+		query.Set("response-content-type", o.ContentType)
+	}
+
+	if len(query) == 0 {
+		return nil
+	}
+	return query
+}
@@ -0,0 +1,122 @@
+// pkg/storage/object_key.go
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxFilenameLength caps the length of a sanitized filename, leaving room for prefixes
+	maxFilenameLength = 200
+
+	// maxKeyCollisions is the number of numbered variants tried before falling back to a UUID suffix
+	maxKeyCollisions = 100
+)
+
+// sanitizeFilename reduces a filename to characters that are safe in object
+// keys and URLs, replacing anything else with a dash
+func sanitizeFilename(name string) string {
+	var b strings.Builder
+	lastDash := false
+
+	for _, r := range name {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)), r == '_', r == '.':
+			b.WriteRune(r)
+			lastDash = false
+		case !lastDash:
+			b.WriteRune('-')
+			lastDash = true
+		}
+	}
+
+	sanitized := strings.Trim(b.String(), "-.")
+	if len(sanitized) > maxFilenameLength {
+		sanitized = strings.TrimRight(sanitized[:maxFilenameLength], "-.")
+	}
+
+	return sanitized
+}
+
+// joinObjectKey prefixes a filename with the subdirectory, if any
+func joinObjectKey(subdirectory, filename string) string {
+	if subdirectory != "" {
+		return subdirectory + "/" + filename
+	}
+	return filename
+}
+
+// validatePrefix rejects key prefixes with empty, "." or ".." segments or
+// in the prefixes the service keeps its own objects in, e.g. the trash
+func validatePrefix(prefix string) error {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return nil
+	}
+
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.Contains(segment, "\\") {
+			return fmt.Errorf("%w: %q", ErrInvalidPrefix, prefix)
+		}
+	}
+	if reservedKey(prefix + "/") {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidPrefix, prefix)
+	}
+	return nil
+}
+
+// reservedKey reports whether a key is in one of the prefixes the service
+// keeps its own objects in, which uploads may not write to
+func reservedKey(key string) bool {
+	return derivedObject(key) || inArchive(key)
+}
+
+// newObjectKey generates the object key for an upload. By default the key is a
+// UUID; with filename preservation enabled the sanitized original filename is
+// used and numbered on collision, as reported by exists.
+func (f *FileStorageManager) newObjectKey(subdirectory string, payload *uploadPayload, exists func(key string) (bool, error)) (string, error) {
+	subdirectory = strings.Trim(subdirectory, "/")
+
+	extension := ""
+	if payload.extension != "" {
+		extension = "." + sanitizeFilename(payload.extension)
+	}
+
+	base := sanitizeFilename(payload.filename)
+	if !f.preserveFilenames || base == "" {
+		return joinObjectKey(subdirectory, uuid.New().String()+extension), nil
+	}
+
+	for i := 0; i < maxKeyCollisions; i++ {
+		filename := base + extension
+		if i > 0 {
+			filename = fmt.Sprintf("%s-%d%s", base, i, extension)
+		}
+
+		key := joinObjectKey(subdirectory, filename)
+		found, err := exists(key)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return key, nil
+		}
+	}
+
+	return joinObjectKey(subdirectory, base+"-"+uuid.New().String()+extension), nil
+}
+
+// awsPublicURL returns the public URL of an S3 object
+func (f *FileStorageManager) awsPublicURL(bucketname, key string) string {
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucketname, f.awsRegion(), key)
+}
+
+// gcsPublicURL returns the public URL of a GCS object
+func gcsPublicURL(bucketname, key string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketname, key)
+}
@@ -0,0 +1,632 @@
+// pkg/storage/postgres_metadata_store.go
+
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// postgresSchema creates the tables file records, folders, share links, API
+// keys and audit events are kept in.
+// The columns of file_records besides record are copies of its fields for
+// filtering and indexing.
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS file_records (
+		file_id    TEXT PRIMARY KEY,
+		provider   TEXT NOT NULL,
+		bucket     TEXT NOT NULL DEFAULT '',
+		owner      TEXT NOT NULL DEFAULT '',
+		file_name  TEXT NOT NULL DEFAULT '',
+		mime_type  TEXT NOT NULL DEFAULT '',
+		file_size  BIGINT NOT NULL DEFAULT 0,
+		sha256     TEXT NOT NULL DEFAULT '',
+		tags       JSONB NOT NULL DEFAULT '[]',
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		record     JSONB NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS file_records_hash_idx ON file_records (provider, bucket, sha256) WHERE sha256 <> ''`,
+	`CREATE INDEX IF NOT EXISTS file_records_owner_idx ON file_records (owner)`,
+	`CREATE INDEX IF NOT EXISTS file_records_created_at_idx ON file_records (created_at)`,
+	`CREATE INDEX IF NOT EXISTS file_records_tags_idx ON file_records USING GIN (tags)`,
+	`CREATE INDEX IF NOT EXISTS file_records_folder_idx ON file_records ((record->>'folder_id'))`,
+	`CREATE TABLE IF NOT EXISTS file_folders (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		parent_id  TEXT NOT NULL DEFAULT '',
+		owner      TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS file_folders_parent_idx ON file_folders (parent_id)`,
+	`CREATE TABLE IF NOT EXISTS file_audit_events (
+		id         BIGSERIAL PRIMARY KEY,
+		time       TIMESTAMPTZ NOT NULL,
+		action     TEXT NOT NULL,
+		provider   TEXT NOT NULL DEFAULT '',
+		file_id    TEXT NOT NULL DEFAULT '',
+		subject    TEXT NOT NULL DEFAULT '',
+		client_id  TEXT NOT NULL DEFAULT '',
+		client_ip  TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		via        TEXT NOT NULL DEFAULT '',
+		status     INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS file_audit_events_file_idx ON file_audit_events (file_id, time)`,
+	`CREATE INDEX IF NOT EXISTS file_audit_events_subject_idx ON file_audit_events (subject, time)`,
+	`CREATE TABLE IF NOT EXISTS file_share_links (
+		token          TEXT PRIMARY KEY,
+		provider       TEXT NOT NULL,
+		bucket         TEXT NOT NULL DEFAULT '',
+		project_id     TEXT NOT NULL DEFAULT '',
+		file_id        TEXT NOT NULL,
+		max_downloads  INTEGER NOT NULL DEFAULT 0,
+		downloads      INTEGER NOT NULL DEFAULT 0,
+		expires_at     TIMESTAMPTZ,
+		password_hash  TEXT NOT NULL DEFAULT '',
+		allowed_emails JSONB NOT NULL DEFAULT '[]',
+		created_at     TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS file_share_links_file_idx ON file_share_links (file_id)`,
+	`CREATE TABLE IF NOT EXISTS file_api_keys (
+		id                   TEXT PRIMARY KEY,
+		name                 TEXT NOT NULL DEFAULT '',
+		scopes               JSONB NOT NULL DEFAULT '[]',
+		hash                 TEXT NOT NULL,
+		previous_hash        TEXT NOT NULL DEFAULT '',
+		previous_valid_until TIMESTAMPTZ,
+		created_at           TIMESTAMPTZ NOT NULL,
+		rotated_at           TIMESTAMPTZ,
+		revoked_at           TIMESTAMPTZ
+	)`,
+}
+
+// PostgresMetadataStore implements a persistent metadata store in a
+// PostgreSQL table, so records survive restarts and are shared by every
+// instance of the service
+type PostgresMetadataStore struct {
+	db *sql.DB
+}
+
+// NewPostgresMetadataStore creates a metadata store on an open PostgreSQL
+// database, creating its table and indexes if they do not exist. The
+// application registers the driver, e.g. by importing
+// github.com/jackc/pgx/v5/stdlib or github.com/lib/pq:
+//
+//	db, err := sql.Open("pgx", os.Getenv("DATABASE_URL"))
+//	store, err := storage.NewPostgresMetadataStore(db)
+//	fs.SetMetadataStore(store)
+func NewPostgresMetadataStore(db *sql.DB) (*PostgresMetadataStore, error) {
+	for _, statement := range postgresSchema {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, fmt.Errorf("creating metadata schema: %w", err)
+		}
+	}
+
+	return &PostgresMetadataStore{db: db}, nil
+}
+
+// SaveFile inserts or replaces a file record
+func (p *PostgresMetadataStore) SaveFile(record *FileRecord) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	tags := record.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	encodedTags, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.db.Exec(`
+		INSERT INTO file_records (file_id, provider, bucket, owner, file_name, mime_type, file_size, sha256, tags, created_at, record)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9::jsonb, $10, $11::jsonb)
+		ON CONFLICT (file_id) DO UPDATE SET
+			provider = EXCLUDED.provider,
+			bucket = EXCLUDED.bucket,
+			owner = EXCLUDED.owner,
+			file_name = EXCLUDED.file_name,
+			mime_type = EXCLUDED.mime_type,
+			file_size = EXCLUDED.file_size,
+			sha256 = EXCLUDED.sha256,
+			tags = EXCLUDED.tags,
+			created_at = EXCLUDED.created_at,
+			updated_at = now(),
+			record = EXCLUDED.record`,
+		record.FileID, record.Provider, record.Bucket, record.Owner, record.FileName, record.MimeType,
+		record.FileSize, record.SHA256, string(encodedTags), record.CreatedAt, string(encoded),
+	)
+	return err
+}
+
+// GetFile retrieves a file record by ID
+func (p *PostgresMetadataStore) GetFile(fileID string) (*FileRecord, error) {
+	return p.queryRecord(`SELECT record FROM file_records WHERE file_id = $1`, fileID)
+}
+
+// FindByHash retrieves the file records with a content hash within a provider bucket, oldest first
+func (p *PostgresMetadataStore) FindByHash(provider, bucket, sha256 string) ([]*FileRecord, error) {
+	return p.queryRecords(`
+		SELECT record FROM file_records
+		WHERE provider = $1 AND bucket = $2 AND sha256 = $3
+		ORDER BY created_at, file_id`,
+		provider, bucket, sha256,
+	)
+}
+
+// DeleteFile removes a file record
+func (p *PostgresMetadataStore) DeleteFile(fileID string) error {
+	_, err := p.db.Exec(`DELETE FROM file_records WHERE file_id = $1`, fileID)
+	return err
+}
+
+// ListFiles returns every file record, oldest first
+func (p *PostgresMetadataStore) ListFiles() ([]*FileRecord, error) {
+	return p.queryRecords(`SELECT record FROM file_records ORDER BY created_at, file_id`)
+}
+
+// postgresSortColumns are the columns search results are ordered by
+var postgresSortColumns = map[string]string{
+	SortCreatedAt: "created_at",
+	SortName:      "file_name",
+	SortSize:      "file_size",
+}
+
+// SearchFiles returns a page of the records matching a query
+func (p *PostgresMetadataStore) SearchFiles(query FileQuery) (*SearchResult, error) {
+	conditions := []string{`file_id NOT LIKE '.trash/%'`, `file_id NOT LIKE '.archive/%'`, `file_id NOT LIKE '.versions/%'`, `COALESCE(record->>'upload_status', '') <> 'pending'`}
+	var args []interface{}
+	// where adds a condition on a parameter, which format refers to as %s
+	where := func(format string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(format, fmt.Sprintf("$%d", len(args))))
+	}
+
+	if query.Name != "" {
+		where(`file_name ILIKE %s ESCAPE '\'`, "%"+escapeLike(query.Name)+"%")
+	}
+	if family := strings.TrimSuffix(query.MimeType, "*"); family != query.MimeType {
+		where(`lower(mime_type) LIKE %s ESCAPE '\'`, escapeLike(family)+"%")
+	} else if query.MimeType != "" {
+		where(`lower(split_part(mime_type, ';', 1)) = %s`, query.MimeType)
+	}
+	if query.Tag != "" {
+		encoded, err := json.Marshal([]string{query.Tag})
+		if err != nil {
+			return nil, err
+		}
+		where(`tags @> %s::jsonb`, string(encoded))
+	}
+	if query.Owner != "" {
+		where(`owner = %s`, query.Owner)
+	}
+	if query.Provider != "" {
+		where(`provider = %s`, query.Provider)
+	}
+	if query.Folder == RootFolder {
+		conditions = append(conditions, `COALESCE(record->>'folder_id', '') = ''`)
+	} else if query.Folder != "" {
+		where(`record->>'folder_id' = %s`, query.Folder)
+	}
+	if !query.From.IsZero() {
+		where(`created_at >= %s`, query.From)
+	}
+	if !query.To.IsZero() {
+		where(`created_at < %s`, query.To)
+	}
+	filter := " WHERE " + strings.Join(conditions, " AND ")
+
+	result := &SearchResult{Offset: query.Offset, Limit: query.Limit}
+	if err := p.db.QueryRow(`SELECT count(*) FROM file_records`+filter, args...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+
+	direction := "ASC"
+	if strings.HasPrefix(query.Sort, "-") {
+		direction = "DESC"
+	}
+	order := fmt.Sprintf(" ORDER BY %s %s, file_id %s", postgresSortColumns[strings.TrimPrefix(query.Sort, "-")], direction, direction)
+	page := fmt.Sprintf(" LIMIT %d OFFSET %d", query.Limit, query.Offset)
+
+	files, err := p.queryRecords(`SELECT record FROM file_records`+filter+order+page, args...)
+	if err != nil {
+		return nil, err
+	}
+	result.Files = files
+
+	return result, nil
+}
+
+// SaveFolder inserts or replaces a folder
+func (p *PostgresMetadataStore) SaveFolder(folder *Folder) error {
+	_, err := p.db.Exec(`
+		INSERT INTO file_folders (id, name, parent_id, owner, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name,
+			parent_id = EXCLUDED.parent_id,
+			owner = EXCLUDED.owner,
+			updated_at = EXCLUDED.updated_at`,
+		folder.ID, folder.Name, folder.ParentID, folder.Owner, folder.CreatedAt, folder.UpdatedAt,
+	)
+	return err
+}
+
+// GetFolder retrieves a folder by ID
+func (p *PostgresMetadataStore) GetFolder(id string) (*Folder, error) {
+	var folder Folder
+	err := p.db.QueryRow(`SELECT id, name, parent_id, owner, created_at, updated_at FROM file_folders WHERE id = $1`, id).
+		Scan(&folder.ID, &folder.Name, &folder.ParentID, &folder.Owner, &folder.CreatedAt, &folder.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// DeleteFolder removes a folder
+func (p *PostgresMetadataStore) DeleteFolder(id string) error {
+	_, err := p.db.Exec(`DELETE FROM file_folders WHERE id = $1`, id)
+	return err
+}
+
+// ListFolders returns the folders in a parent folder by name
+func (p *PostgresMetadataStore) ListFolders(parentID string) ([]*Folder, error) {
+	rows, err := p.db.Query(`
+		SELECT id, name, parent_id, owner, created_at, updated_at FROM file_folders
+		WHERE parent_id = $1
+		ORDER BY name, id`,
+		parentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []*Folder{}
+	for rows.Next() {
+		var folder Folder
+		if err := rows.Scan(&folder.ID, &folder.Name, &folder.ParentID, &folder.Owner, &folder.CreatedAt, &folder.UpdatedAt); err != nil {
+			return nil, err
+		}
+		folders = append(folders, &folder)
+	}
+
+	return folders, rows.Err()
+}
+
+// AppendAuditEvent adds an event to the audit log. The store never updates
+// or deletes events; revoke UPDATE and DELETE on file_audit_events from the
+// service's database role to enforce it.
+func (p *PostgresMetadataStore) AppendAuditEvent(event *AuditEvent) error {
+	_, err := p.db.Exec(`
+		INSERT INTO file_audit_events (time, action, provider, file_id, subject, client_id, client_ip, user_agent, via, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		event.Time, event.Action, event.Provider, event.FileID, event.Subject, event.ClientID,
+		event.ClientIP, event.UserAgent, event.Via, event.Status,
+	)
+	return err
+}
+
+// QueryAuditEvents returns the events matching a query, newest first
+func (p *PostgresMetadataStore) QueryAuditEvents(query AuditQuery) ([]*AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, fmt.Sprintf("$%d", len(args))))
+	}
+
+	if query.FileID != "" {
+		where(`file_id = %s`, query.FileID)
+	}
+	if query.Subject != "" {
+		where(`subject = %s`, query.Subject)
+	}
+	if query.Action != "" {
+		where(`action = %s`, query.Action)
+	}
+	if !query.From.IsZero() {
+		where(`time >= %s`, query.From)
+	}
+	if !query.To.IsZero() {
+		where(`time < %s`, query.To)
+	}
+
+	statement := `SELECT time, action, provider, file_id, subject, client_id, client_ip, user_agent, via, status FROM file_audit_events`
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	statement += fmt.Sprintf(" ORDER BY time DESC, id DESC LIMIT %d", query.Limit)
+
+	rows, err := p.db.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		if err := rows.Scan(&event.Time, &event.Action, &event.Provider, &event.FileID, &event.Subject, &event.ClientID,
+			&event.ClientIP, &event.UserAgent, &event.Via, &event.Status); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+// postgresShareLinkColumns are the columns scanned by scanShareLink
+const postgresShareLinkColumns = `token, provider, bucket, project_id, file_id, max_downloads, downloads, expires_at, password_hash, allowed_emails, created_at`
+
+// SaveShareLink inserts or replaces a share link
+func (p *PostgresMetadataStore) SaveShareLink(link *ShareLink) error {
+	emails := link.AllowedEmails
+	if emails == nil {
+		emails = []string{}
+	}
+	encodedEmails, err := json.Marshal(emails)
+	if err != nil {
+		return err
+	}
+
+	var expiresAt *time.Time
+	if !link.ExpiresAt.IsZero() {
+		expiresAt = &link.ExpiresAt
+	}
+
+	_, err = p.db.Exec(`
+		INSERT INTO file_share_links (`+postgresShareLinkColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10::jsonb, $11)
+		ON CONFLICT (token) DO UPDATE SET
+			provider = EXCLUDED.provider,
+			bucket = EXCLUDED.bucket,
+			project_id = EXCLUDED.project_id,
+			file_id = EXCLUDED.file_id,
+			max_downloads = EXCLUDED.max_downloads,
+			downloads = EXCLUDED.downloads,
+			expires_at = EXCLUDED.expires_at,
+			password_hash = EXCLUDED.password_hash,
+			allowed_emails = EXCLUDED.allowed_emails,
+			created_at = EXCLUDED.created_at`,
+		link.Token, link.Provider, link.Bucket, link.ProjectID, link.FileID, link.MaxDownloads, link.Downloads,
+		expiresAt, link.PasswordHash, string(encodedEmails), link.CreatedAt,
+	)
+	return err
+}
+
+// GetShareLink retrieves a share link by token
+func (p *PostgresMetadataStore) GetShareLink(token string) (*ShareLink, error) {
+	link, err := scanShareLink(p.db.QueryRow(`SELECT `+postgresShareLinkColumns+` FROM file_share_links WHERE token = $1`, token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrShareLinkNotFound
+	}
+	return link, err
+}
+
+// ConsumeShareLink counts a download of a share link. The count is only
+// raised while it is below the limit, in a single statement, so concurrent
+// downloads by any instance never exceed it.
+func (p *PostgresMetadataStore) ConsumeShareLink(token string) (*ShareLink, error) {
+	link, err := scanShareLink(p.db.QueryRow(`
+		UPDATE file_share_links SET downloads = downloads + 1
+		WHERE token = $1 AND (max_downloads = 0 OR downloads < max_downloads)
+		RETURNING `+postgresShareLinkColumns,
+		token,
+	))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return link, err
+	}
+
+	if _, err := p.GetShareLink(token); err != nil {
+		return nil, err
+	}
+	return nil, ErrShareLinkExhausted
+}
+
+// DeleteShareLink removes a share link
+func (p *PostgresMetadataStore) DeleteShareLink(token string) error {
+	_, err := p.db.Exec(`DELETE FROM file_share_links WHERE token = $1`, token)
+	return err
+}
+
+// ListShareLinks returns the share links to a file, or all share links when fileID is ""
+func (p *PostgresMetadataStore) ListShareLinks(fileID string) ([]*ShareLink, error) {
+	statement := `SELECT ` + postgresShareLinkColumns + ` FROM file_share_links`
+	var args []interface{}
+	if fileID != "" {
+		statement += ` WHERE file_id = $1`
+		args = append(args, fileID)
+	}
+
+	rows, err := p.db.Query(statement+` ORDER BY created_at, token`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []*ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+// scanShareLink reads a share link selected with postgresShareLinkColumns
+func scanShareLink(row interface{ Scan(...interface{}) error }) (*ShareLink, error) {
+	var link ShareLink
+	var expiresAt sql.NullTime
+	var encodedEmails []byte
+	err := row.Scan(&link.Token, &link.Provider, &link.Bucket, &link.ProjectID, &link.FileID, &link.MaxDownloads,
+		&link.Downloads, &expiresAt, &link.PasswordHash, &encodedEmails, &link.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		link.ExpiresAt = expiresAt.Time
+	}
+	if err := json.Unmarshal(encodedEmails, &link.AllowedEmails); err != nil {
+		return nil, err
+	}
+	if len(link.AllowedEmails) == 0 {
+		link.AllowedEmails = nil
+	}
+	return &link, nil
+}
+
+// postgresAPIKeyColumns are the columns scanned by scanAPIKey
+const postgresAPIKeyColumns = `id, name, scopes, hash, previous_hash, previous_valid_until, created_at, rotated_at, revoked_at`
+
+// SaveAPIKey inserts or replaces an API key. Only the hashes of its secrets
+// are stored.
+func (p *PostgresMetadataStore) SaveAPIKey(key *APIKey) error {
+	scopes := key.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	encodedScopes, err := json.Marshal(scopes)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.db.Exec(`
+		INSERT INTO file_api_keys (`+postgresAPIKeyColumns+`)
+		VALUES ($1, $2, $3::jsonb, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name,
+			scopes = EXCLUDED.scopes,
+			hash = EXCLUDED.hash,
+			previous_hash = EXCLUDED.previous_hash,
+			previous_valid_until = EXCLUDED.previous_valid_until,
+			created_at = EXCLUDED.created_at,
+			rotated_at = EXCLUDED.rotated_at,
+			revoked_at = EXCLUDED.revoked_at`,
+		key.ID, key.Name, string(encodedScopes), key.Hash, key.PreviousHash, key.PreviousValidUntil,
+		key.CreatedAt, key.RotatedAt, key.RevokedAt,
+	)
+	return err
+}
+
+// GetAPIKey retrieves an API key by ID
+func (p *PostgresMetadataStore) GetAPIKey(id string) (*APIKey, error) {
+	key, err := scanAPIKey(p.db.QueryRow(`SELECT `+postgresAPIKeyColumns+` FROM file_api_keys WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// ListAPIKeys returns all API keys, oldest first
+func (p *PostgresMetadataStore) ListAPIKeys() ([]*APIKey, error) {
+	rows, err := p.db.Query(`SELECT ` + postgresAPIKeyColumns + ` FROM file_api_keys ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// scanAPIKey reads an API key selected with postgresAPIKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var encodedScopes []byte
+	var previousValidUntil, rotatedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Name, &encodedScopes, &key.Hash, &key.PreviousHash, &previousValidUntil,
+		&key.CreatedAt, &rotatedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(encodedScopes, &key.Scopes); err != nil {
+		return nil, err
+	}
+	key.PreviousValidUntil = nullTime(previousValidUntil)
+	key.RotatedAt = nullTime(rotatedAt)
+	key.RevokedAt = nullTime(revokedAt)
+	return &key, nil
+}
+
+// nullTime returns the time of a nullable column, nil when it is NULL
+func nullTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// queryRecord returns the record selected by query or ErrRecordNotFound
+func (p *PostgresMetadataStore) queryRecord(query string, args ...interface{}) (*FileRecord, error) {
+	var encoded []byte
+	err := p.db.QueryRow(query, args...).Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record FileRecord
+	if err := json.Unmarshal(encoded, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// queryRecords returns the records selected by query
+func (p *PostgresMetadataStore) queryRecords(query string, args ...interface{}) ([]*FileRecord, error) {
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*FileRecord{}
+	for rows.Next() {
+		var encoded []byte
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+
+		var record FileRecord
+		if err := json.Unmarshal(encoded, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+
+	return records, rows.Err()
+}
@@ -0,0 +1,238 @@
+// pkg/storage/preview.go
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// previewPrefix is the key prefix under which previews are stored
+	previewPrefix = "previews/"
+
+	// previewTimeout bounds the external commands run to render a preview
+	previewTimeout = 2 * time.Minute
+
+	// maxConcurrentPreviews is the number of previews rendered at the same time
+	maxConcurrentPreviews = 2
+)
+
+// officeDocumentTypes are the content types converted to PDF with LibreOffice before rendering
+var officeDocumentTypes = []string{
+	"application/msword",
+	"application/vnd.ms-excel",
+	"application/vnd.ms-powerpoint",
+	"application/vnd.openxmlformats-officedocument.",
+	"application/vnd.oasis.opendocument.",
+	"application/rtf",
+}
+
+// PreviewRenderer renders a JPEG preview of the first page of a document
+type PreviewRenderer interface {
+	Supports(mimetype string) bool
+	// Render returns a JPEG whose longest side is at most size pixels
+	Render(data []byte, mimetype string, size int) ([]byte, error)
+}
+
+// PreviewGenerator renders previews of uploaded documents in the background
+type PreviewGenerator struct {
+	Renderers []PreviewRenderer
+	Size      int // Longest side of the preview in pixels
+	slots     chan struct{}
+}
+
+// NewPreviewGenerator creates a preview generator rendering images in process
+// and PDF and office documents with pdftoppm and LibreOffice, when installed
+func NewPreviewGenerator(size int) *PreviewGenerator {
+	return &PreviewGenerator{
+		Renderers: []PreviewRenderer{
+			&ImagePreviewRenderer{Quality: DefaultJPEGQuality},
+			NewCommandPreviewRenderer(),
+		},
+		Size:  size,
+		slots: make(chan struct{}, maxConcurrentPreviews),
+	}
+}
+
+// renderer returns the first renderer supporting a content type, if any
+func (g *PreviewGenerator) renderer(mimetype string) PreviewRenderer {
+	for _, renderer := range g.Renderers {
+		if renderer.Supports(mimetype) {
+			return renderer
+		}
+	}
+	return nil
+}
+
+// PreviewKey returns the object key of a preview, e.g. "previews/<id>.jpg".
+// The file ID keeps its extension, so report.pdf and report.docx each get
+// their own preview.
+func PreviewKey(fileID string) string {
+	return previewPrefix + fileID + ".jpg"
+}
+
+// ImagePreviewRenderer renders previews of JPEG, PNG and GIF images
+type ImagePreviewRenderer struct {
+	Quality int
+}
+
+// Supports reports whether mimetype is a decodable image type
+func (r *ImagePreviewRenderer) Supports(mimetype string) bool {
+	return isImageType(mimetype)
+}
+
+// Render scales the image down to size and encodes it as JPEG
+func (r *ImagePreviewRenderer) Render(data []byte, mimetype string, size int) ([]byte, error) {
+	img, _, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	return encodeJPEG(fitImage(img, size, size), r.Quality)
+}
+
+// CommandPreviewRenderer renders the first page of PDF and office documents
+// with the pdftoppm (poppler) and soffice (LibreOffice) command line tools
+type CommandPreviewRenderer struct {
+	PdftoppmPath string // "" disables PDF rendering
+	SofficePath  string // "" disables office document rendering
+}
+
+// NewCommandPreviewRenderer creates a command renderer using the tools found in PATH
+func NewCommandPreviewRenderer() *CommandPreviewRenderer {
+	pdftoppm, _ := exec.LookPath("pdftoppm")
+	soffice, _ := exec.LookPath("soffice")
+
+	return &CommandPreviewRenderer{
+		PdftoppmPath: pdftoppm,
+		SofficePath:  soffice,
+	}
+}
+
+// Supports reports whether the required tools are available for mimetype
+func (r *CommandPreviewRenderer) Supports(mimetype string) bool {
+	if r.PdftoppmPath == "" {
+		return false
+	}
+
+	mimetype = normalizeMimeType(mimetype)
+	if mimetype == "application/pdf" {
+		return true
+	}
+	return r.SofficePath != "" && hasTypePrefix(officeDocumentTypes, mimetype)
+}
+
+// Render converts office documents to PDF and renders the first PDF page as JPEG
+func (r *CommandPreviewRenderer) Render(data []byte, mimetype string, size int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), previewTimeout)
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "filestorage-preview-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "document")
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		return nil, err
+	}
+
+	pdf := input
+	if normalizeMimeType(mimetype) != "application/pdf" {
+		out, err := exec.CommandContext(ctx, r.SofficePath, "--headless", "--convert-to", "pdf", "--outdir", dir, input).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("soffice: %v: %s", err, out)
+		}
+		pdf = input + ".pdf"
+	}
+
+	output := filepath.Join(dir, "preview")
+	out, err := exec.CommandContext(ctx, r.PdftoppmPath,
+		"-jpeg", "-singlefile", "-f", "1", "-l", "1", "-scale-to", strconv.Itoa(size), pdf, output,
+	).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("pdftoppm: %v: %s", err, out)
+	}
+
+	return os.ReadFile(output + ".jpg")
+}
+
+// SetPreviewGenerator enables background preview generation for S3 and GCS uploads, nil disables it
+func (f *FileStorageManager) SetPreviewGenerator(generator *PreviewGenerator) {
+	f.previews = generator
+}
+
+// queuePreview renders and stores the preview of an upload in the background
+// using store, returning the key the preview will be stored under or "" when
+// no preview is generated for the content type
+func (f *FileStorageManager) queuePreview(fileID string, payload *uploadPayload, store func(key string, data []byte) error) string {
+	// Staged uploads are too large to be rendered in memory
+	if f.previews == nil || payload.staged != nil {
+		return ""
+	}
+
+	renderer := f.previews.renderer(payload.mimeType)
+	if renderer == nil {
+		return ""
+	}
+
+	key := PreviewKey(fileID)
+	data, mimetype := payload.plain, payload.mimeType
+
+	go func() {
+		f.previews.slots <- struct{}{}
+		defer func() { <-f.previews.slots }()
+
+		preview, err := renderer.Render(data, mimetype, f.previews.Size)
+		if err != nil {
+			log.Printf("filestorage: preview of %s failed: %v", fileID, err)
+			return
+		}
+
+		// Previews get the same protection as the original
+		if f.encryptor != nil {
+			if preview, err = f.encryptor.Encrypt(preview); err != nil {
+				log.Printf("filestorage: preview of %s failed: %v", fileID, err)
+				return
+			}
+		}
+
+		if err := store(key, preview); err != nil {
+			log.Printf("filestorage: storing preview of %s failed: %v", fileID, err)
+		}
+	}()
+
+	return key
+}
+
+// deleteAwsPreview removes the preview of an S3 object, ignoring a missing one
+func (f *FileStorageManager) deleteAwsPreview(s3Client *s3.S3, bucketname, fileID string) {
+	if f.previews == nil {
+		return
+	}
+
+	s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucketname),
+		Key:    aws.String(PreviewKey(fileID)),
+	})
+}
+
+// deleteGcsPreview removes the preview of a GCS object, ignoring a missing one
+func (f *FileStorageManager) deleteGcsPreview(ctx context.Context, bucket *storage.BucketHandle, fileID string) {
+	if f.previews == nil {
+		return
+	}
+
+	bucket.Object(PreviewKey(fileID)).Delete(ctx)
+}
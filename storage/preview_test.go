@@ -0,0 +1,16 @@
+package storage
+
+import "testing"
+
+func TestPreviewKey(t *testing.T) {
+	for fileID, want := range map[string]string{
+		"report.pdf":        "previews/report.pdf.jpg",
+		"report.docx":       "previews/report.docx.jpg",
+		"theses/thesis.pdf": "previews/theses/thesis.pdf.jpg",
+		"notes":             "previews/notes.jpg",
+	} {
+		if got := PreviewKey(fileID); got != want {
+			t.Errorf("PreviewKey(%q) = %q, want %q", fileID, got, want)
+		}
+	}
+}
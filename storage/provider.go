@@ -10,6 +10,7 @@ type FileStorageServiceProvider struct {
 	fileStorage  *FileStorageManager
 	tokenManager TokenManager
 	memoryCache  *MemoryCache
+	metadata     *MemoryMetadataStore
 	once         sync.Once
 }
 
@@ -25,8 +26,20 @@ func (p *FileStorageServiceProvider) FileStorage() *FileStorageManager {
 	p.once.Do(func() {
 		p.memoryCache = NewMemoryCache()
 		p.tokenManager = NewCacheTokenManager(p.config, p.memoryCache)
+		p.metadata = NewMemoryMetadataStore()
 		p.fileStorage = NewFileStorageManager(p.config, p.tokenManager)
+		p.fileStorage.SetMetadataStore(p.metadata)
 	})
 
 	return p.fileStorage
 }
+
+// Close closes the file storage manager, if it was created, and the cache
+// of its token manager
+func (p *FileStorageServiceProvider) Close() error {
+	if p.fileStorage == nil {
+		return nil
+	}
+	p.memoryCache.Close()
+	return p.fileStorage.Close()
+}
@@ -0,0 +1,134 @@
+// pkg/storage/provider_limits.go
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProviderQueueTimeout is how long an operation waits for a provider
+// running as many operations as it may before failing with ErrProviderBusy
+const DefaultProviderQueueTimeout = time.Second
+
+// ProviderLimits is the number of operations that may be in flight at the
+// same time per provider, e.g. {"s3": 64}. Providers without a limit are not
+// limited.
+type ProviderLimits map[string]int
+
+// ParseProviderLimits parses comma separated provider=limit pairs, e.g.
+// "s3=64,gcs=32"
+func ParseProviderLimits(value string) (ProviderLimits, error) {
+	limits := ProviderLimits{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		provider, limit, found := strings.Cut(entry, "=")
+		provider = strings.TrimSpace(provider)
+		if !found || !containsString([]string{ProviderREST, ProviderAWS, ProviderGCS}, provider) {
+			return nil, fmt.Errorf("unknown provider in %q", entry)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid limit in %q", entry)
+		}
+		limits[provider] = n
+	}
+
+	if len(limits) == 0 {
+		return nil, nil
+	}
+	return limits, nil
+}
+
+// providerSlots limits the operations in flight per provider. Every
+// operation holds one slot of its provider from start to finish; opened
+// files hold theirs until they are closed.
+type providerSlots struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{} // By provider, nil when the provider is not limited
+	wait  time.Duration
+}
+
+// newProviderSlots creates the configured slots
+func newProviderSlots(config *Config) *providerSlots {
+	p := &providerSlots{slots: make(map[string]chan struct{}), wait: config.ProviderQueueTimeout}
+	for provider, limit := range config.ProviderConcurrency {
+		if limit > 0 {
+			p.slots[provider] = make(chan struct{}, limit)
+		}
+	}
+	return p
+}
+
+// SetProviderConcurrency limits the operations in flight at the same time
+// with a provider, so a burst of downloads cannot exhaust file descriptors
+// or the rate limits of the provider; 0 removes the limit. Operations over
+// the limit wait up to the queue timeout for a slot and then fail with
+// ErrProviderBusy. Operations already in flight are not counted towards a
+// new limit.
+func (f *FileStorageManager) SetProviderConcurrency(provider string, limit int) {
+	f.providerSlots.mu.Lock()
+	defer f.providerSlots.mu.Unlock()
+
+	if limit <= 0 {
+		delete(f.providerSlots.slots, provider)
+		return
+	}
+	f.providerSlots.slots[provider] = make(chan struct{}, limit)
+}
+
+// SetProviderQueueTimeout sets how long operations wait for a slot of a
+// provider at its limit, 0 falls back to DefaultProviderQueueTimeout and a
+// negative timeout fails them right away
+func (f *FileStorageManager) SetProviderQueueTimeout(timeout time.Duration) {
+	f.providerSlots.mu.Lock()
+	defer f.providerSlots.mu.Unlock()
+
+	f.providerSlots.wait = timeout
+}
+
+// acquireProvider takes a slot of a provider for an operation, returning
+// the function giving it back once the operation finished. Waiting for a
+// slot stops when ctx is done.
+func (f *FileStorageManager) acquireProvider(ctx context.Context, provider string) (func(), error) {
+	f.providerSlots.mu.Lock()
+	slots, wait := f.providerSlots.slots[provider], f.providerSlots.wait
+	f.providerSlots.mu.Unlock()
+
+	if slots == nil {
+		return func() {}, nil
+	}
+	if wait == 0 {
+		wait = DefaultProviderQueueTimeout
+	}
+
+	select {
+	case slots <- struct{}{}:
+	default:
+		if wait < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrProviderBusy, provider)
+		}
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case slots <- struct{}{}:
+		case <-timer.C:
+			return nil, fmt.Errorf("%w: %s", ErrProviderBusy, provider)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	var once sync.Once
+	return func() { once.Do(func() { <-slots }) }, nil
+}
@@ -0,0 +1,105 @@
+// pkg/storage/read_ahead.go
+
+package storage
+
+import (
+	"io"
+	"sync"
+)
+
+// SetReadAhead makes downloads and archives read provider streams up to
+// window bytes ahead of the client, so the next bytes are fetched while the
+// previous ones are written, which speeds up transfers over links with high
+// latency; 0 disables it. Every open stream may buffer up to window bytes,
+// rounded up to whole transfer buffers.
+func (f *FileStorageManager) SetReadAhead(window int64) {
+	f.readAheadWindow = window
+}
+
+// readAheadChunk is a part of a stream read ahead
+type readAheadChunk struct {
+	buf *[]byte // Pooled transfer buffer holding the part
+	n   int
+	err error // Error reading past the part, io.EOF at the end of the stream
+}
+
+// readAheadReader reads a stream ahead of its reader on a goroutine
+type readAheadReader struct {
+	src    io.ReadCloser
+	chunks chan readAheadChunk
+	stop   chan struct{}
+	closed sync.Once
+
+	buf     *[]byte // Transfer buffer of the part being read
+	current []byte  // Unread bytes of the part being read
+	err     error
+}
+
+// readAhead returns body read ahead by the configured window, or body itself
+// when reading ahead is disabled
+func (f *FileStorageManager) readAhead(body io.ReadCloser) io.ReadCloser {
+	if f.readAheadWindow <= 0 {
+		return body
+	}
+
+	r := &readAheadReader{
+		src:    body,
+		chunks: make(chan readAheadChunk, (f.readAheadWindow+TransferBufferSize-1)/TransferBufferSize),
+		stop:   make(chan struct{}),
+	}
+	go r.fill()
+	return r
+}
+
+// fill reads the stream into transfer buffers until it ends, fails or the
+// reader is closed, blocking while the window is full. The stream is only
+// used, and closed, by fill.
+func (r *readAheadReader) fill() {
+	defer r.src.Close()
+
+	for {
+		buf := transferBuffers.Get().(*[]byte)
+		n, err := r.src.Read(*buf)
+		if n == 0 && err == nil {
+			transferBuffers.Put(buf)
+			continue
+		}
+
+		select {
+		case r.chunks <- readAheadChunk{buf: buf, n: n, err: err}:
+		case <-r.stop:
+			transferBuffers.Put(buf)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read implements io.Reader
+func (r *readAheadReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		if r.buf != nil {
+			transferBuffers.Put(r.buf)
+			r.buf = nil
+		}
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		chunk := <-r.chunks
+		r.buf, r.current, r.err = chunk.buf, (*chunk.buf)[:chunk.n], chunk.err
+	}
+
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
+
+// Close stops reading ahead. The stream is closed once a read of it in
+// progress returns, so Close does not wait for a stalled connection.
+func (r *readAheadReader) Close() error {
+	r.closed.Do(func() { close(r.stop) })
+	return nil
+}
@@ -0,0 +1,246 @@
+// pkg/storage/read_hedging.go
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// hedgeWindow is the number of recent read latencies a provider's hedge
+	// delay is computed from
+	hedgeWindow = 128
+
+	// minHedgeSamples is the number of reads measured before reads are hedged
+	minHedgeSamples = 20
+)
+
+// HedgeQuantiles is the quantile of recent read latency after which a read
+// is sent a second time, per provider, e.g. {"gcs": 0.95}. Reads of
+// providers without a quantile are not hedged.
+type HedgeQuantiles map[string]float64
+
+// ParseHedgeQuantiles parses comma separated provider=quantile pairs, e.g.
+// "gcs=0.95,s3=0.99"
+func ParseHedgeQuantiles(value string) (HedgeQuantiles, error) {
+	quantiles := HedgeQuantiles{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		provider, quantile, found := strings.Cut(entry, "=")
+		provider = strings.TrimSpace(provider)
+		if !found || !containsString([]string{ProviderREST, ProviderAWS, ProviderGCS}, provider) {
+			return nil, fmt.Errorf("unknown provider in %q", entry)
+		}
+
+		q, err := strconv.ParseFloat(strings.TrimSpace(quantile), 64)
+		if err != nil || q < 0 || q >= 1 {
+			return nil, fmt.Errorf("invalid quantile in %q", entry)
+		}
+		quantiles[provider] = q
+	}
+
+	if len(quantiles) == 0 {
+		return nil, nil
+	}
+	return quantiles, nil
+}
+
+// hedgeState holds the hedge quantiles and recent read latencies per provider
+type hedgeState struct {
+	mu        sync.Mutex
+	quantiles HedgeQuantiles
+	latencies map[string]*latencyWindow // By provider
+}
+
+// latencyWindow is a ring of the most recent read latencies of a provider
+type latencyWindow struct {
+	samples [hedgeWindow]time.Duration
+	count   int
+	next    int
+}
+
+// newHedgeState creates the configured hedging
+func newHedgeState(config *Config) *hedgeState {
+	h := &hedgeState{quantiles: HedgeQuantiles{}, latencies: make(map[string]*latencyWindow)}
+	for provider, quantile := range config.HedgeReads {
+		if quantile > 0 {
+			h.quantiles[provider] = quantile
+		}
+	}
+	return h
+}
+
+// SetReadHedging hedges the reads of a provider, ProviderREST, ProviderAWS
+// or ProviderGCS, to cut their tail latency: a GET or HEAD request still
+// unanswered after the given quantile of recent read latency, e.g. 0.95, is
+// sent a second time, the first response wins and the other request is
+// cancelled. Reads are only hedged once enough of them were measured; 0
+// stops hedging. Hedging costs the extra requests, about 1 - quantile of
+// them.
+func (f *FileStorageManager) SetReadHedging(provider string, quantile float64) {
+	f.hedging.mu.Lock()
+	defer f.hedging.mu.Unlock()
+
+	if quantile <= 0 {
+		delete(f.hedging.quantiles, provider)
+		delete(f.hedging.latencies, provider)
+		return
+	}
+	f.hedging.quantiles[provider] = quantile
+}
+
+// hedgeDelay returns how long a read of a provider is waited for before it
+// is hedged, and whether reads of the provider are hedged at all. The delay
+// is 0 while too few reads were measured.
+func (h *hedgeState) hedgeDelay(provider string) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	quantile, ok := h.quantiles[provider]
+	if !ok {
+		return 0, false
+	}
+	window := h.latencies[provider]
+	if window == nil || window.count < minHedgeSamples {
+		return 0, true
+	}
+
+	samples := append([]time.Duration(nil), window.samples[:window.count]...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[int(quantile*float64(len(samples)))], true
+}
+
+// observe records the latency of a read of a provider whose reads are hedged
+func (h *hedgeState) observe(provider string, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.quantiles[provider]; !ok {
+		return
+	}
+	window := h.latencies[provider]
+	if window == nil {
+		window = &latencyWindow{}
+		h.latencies[provider] = window
+	}
+	window.samples[window.next] = latency
+	window.next = (window.next + 1) % hedgeWindow
+	window.count = min(window.count+1, hedgeWindow)
+}
+
+// hedgeTransport hedges the reads of a provider
+type hedgeTransport struct {
+	provider string
+	base     http.RoundTripper
+	manager  *FileStorageManager
+}
+
+// hedgeAttempt is the outcome of one of the requests of a hedged read
+type hedgeAttempt struct {
+	index int
+	resp  *http.Response
+	err   error
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *hedgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead || (req.Body != nil && req.Body != http.NoBody) {
+		return t.base.RoundTrip(req)
+	}
+
+	hedging := t.manager.hedging
+	delay, ok := hedging.hedgeDelay(t.provider)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	if delay == 0 {
+		start := time.Now()
+		resp, err := t.base.RoundTrip(req)
+		if err == nil {
+			hedging.observe(t.provider, time.Since(start))
+		}
+		return resp, err
+	}
+
+	// Each request has its own context, so the loser can be cancelled
+	// without touching the winner
+	var cancels [2]context.CancelFunc
+	attempts := make(chan hedgeAttempt, len(cancels))
+	send := func(index int) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels[index] = cancel
+		go func() {
+			start := time.Now()
+			resp, err := t.base.RoundTrip(req.Clone(ctx))
+			if err == nil {
+				hedging.observe(t.provider, time.Since(start))
+			}
+			attempts <- hedgeAttempt{index: index, resp: resp, err: err}
+		}()
+	}
+
+	send(0)
+	sent := 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending := 1; pending > 0; {
+		select {
+		case <-timer.C:
+			send(1)
+			sent, pending = 2, pending+1
+
+		case attempt := <-attempts:
+			pending--
+			if attempt.err != nil {
+				cancels[attempt.index]()
+				if firstErr == nil {
+					firstErr = attempt.err
+				}
+				continue
+			}
+
+			if sent > 1 {
+				t.manager.metrics.observeHedge(t.provider, attempt.index == 1)
+				loser := 1 - attempt.index
+				cancels[loser]()
+				if pending > 0 {
+					go discardAttempt(attempts)
+				}
+			}
+			// The winner's context lives until its body is closed
+			body, cancel := attempt.resp.Body, cancels[attempt.index]
+			attempt.resp.Body = &closerFunc{Reader: body, close: func() error {
+				defer cancel()
+				return body.Close()
+			}}
+			return attempt.resp, nil
+		}
+	}
+	return nil, firstErr
+}
+
+// discardAttempt closes the response of the request that lost a hedged read
+func discardAttempt(attempts <-chan hedgeAttempt) {
+	if attempt := <-attempts; attempt.err == nil {
+		attempt.resp.Body.Close()
+	}
+}
+
+// CloseIdleConnections closes the idle connections of the hedged transport
+func (t *hedgeTransport) CloseIdleConnections() {
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
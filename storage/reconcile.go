@@ -0,0 +1,223 @@
+// pkg/storage/reconcile.go
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ReconcileGracePeriod is how old objects and records must be to be
+	// reported, so uploads in progress are not mistaken for orphans
+	ReconcileGracePeriod = time.Hour
+
+	// MaxReconcileItems is the most orphans and dangling records a
+	// reconciliation report lists; all of them are counted and cleaned
+	MaxReconcileItems = 1000
+)
+
+// derivedPrefixes hold objects kept for other files, removed along with them
+var derivedPrefixes = []string{thumbnailPrefix, previewPrefix, renditionPrefix, chunkPrefix, compositePrefix, TrashPrefix, VersionPrefix, InventoryPrefix, TenantReportPrefix}
+
+// ReconcileReport compares the configured buckets with the metadata store
+type ReconcileReport struct {
+	Orphans        []*StoredObject `json:"orphans"`         // Objects without a record
+	OrphanCount    int             `json:"orphan_count"`    // All orphans, of which Orphans lists the first MaxReconcileItems
+	Dangling       []*FileRecord   `json:"dangling"`        // Records of objects that are gone
+	DanglingCount  int             `json:"dangling_count"`  // All dangling records, of which Dangling lists the first MaxReconcileItems
+	Cleaned        bool            `json:"cleaned"`         // Whether orphans were deleted and dangling records removed
+	ObjectsScanned int             `json:"objects_scanned"` // Objects listed in the buckets
+	RecordsScanned int             `json:"records_scanned"` // Records listed in the metadata store
+	StartedAt      time.Time       `json:"started_at"`
+	FinishedAt     time.Time       `json:"finished_at"`
+}
+
+// reconcileState remembers the last reconciliation and keeps two from
+// running at the same time
+type reconcileState struct {
+	running sync.Mutex
+	mu      sync.Mutex
+	last    *ReconcileReport
+}
+
+// Reconcile compares the objects in the configured bucket of each provider
+// with the records of the metadata store, reporting objects without a record
+// (orphans) and records whose object is gone (dangling records). Thumbnails,
+// previews, renditions, chunks, the trash and previous versions go with the
+// files they belong to and are left out, like stored inventories and reports.
+// Reservations of direct uploads not confirmed by their deadline do not count
+// as records.
+// When clean is true, orphans are deleted, to the trash while soft delete is
+// enabled, and dangling records are removed.
+// Every key of the buckets is held in memory while they are compared.
+func (f *FileStorageManager) Reconcile(clean bool) (*ReconcileReport, error) {
+	lister, ok := f.metadataStore.(FileLister)
+	if !ok {
+		return nil, ErrReconcileNotSupported
+	}
+
+	f.reconcile.running.Lock()
+	defer f.reconcile.running.Unlock()
+
+	report := &ReconcileReport{Orphans: []*StoredObject{}, Dangling: []*FileRecord{}, Cleaned: clean, StartedAt: time.Now()}
+	deadline := report.StartedAt.Add(-ReconcileGracePeriod)
+
+	// Keys of the objects in each scanned bucket by provider
+	scanned := map[string]map[string]bool{}
+	for _, provider := range []string{ProviderAWS, ProviderGCS} {
+		bucketname := f.defaultBucket(provider)
+		if bucketname == "" {
+			continue
+		}
+
+		keys := map[string]bool{}
+		var orphans []*StoredObject
+		err := f.walkPrefix(provider, "", bucketname, "", func(object ObjectInfo) error {
+			report.ObjectsScanned++
+			keys[object.Key] = true
+			if derivedObject(object.Key) || !object.ModTime.Before(deadline) {
+				return nil
+			}
+
+			record, err := f.metadataStore.GetFile(object.Key)
+			if err == nil && (record.UploadStatus != UploadPending || !record.abandoned(report.StartedAt)) {
+				return nil
+			}
+			if err != nil && !errors.Is(err, ErrRecordNotFound) {
+				return err
+			}
+			orphans = append(orphans, &StoredObject{
+				Provider:    provider,
+				Bucket:      bucketname,
+				FileID:      object.Key,
+				Size:        object.Size,
+				ContentType: object.ContentType,
+				ModTime:     object.ModTime,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", provider, err)
+		}
+		scanned[provider] = keys
+
+		for _, orphan := range orphans {
+			if clean {
+				if err := f.deleteOrphan(orphan); err != nil {
+					return nil, fmt.Errorf("%s: %s: %w", provider, orphan.FileID, err)
+				}
+			}
+			report.OrphanCount++
+			if len(report.Orphans) < MaxReconcileItems {
+				report.Orphans = append(report.Orphans, orphan)
+			}
+		}
+	}
+
+	records, err := lister.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		report.RecordsScanned++
+
+		keys, ok := scanned[record.Provider]
+		if !ok || (record.Bucket != "" && record.Bucket != f.defaultBucket(record.Provider)) {
+			continue
+		}
+		pending := record.UploadStatus == UploadPending && !record.abandoned(report.StartedAt)
+		if keys[record.FileID] || pending || !record.CreatedAt.Before(deadline) {
+			continue
+		}
+
+		if clean {
+			if err := f.forgetDangling(record.FileID); err != nil {
+				return nil, fmt.Errorf("%s: %w", record.FileID, err)
+			}
+		}
+		report.DanglingCount++
+		if len(report.Dangling) < MaxReconcileItems {
+			report.Dangling = append(report.Dangling, record)
+		}
+	}
+
+	report.FinishedAt = time.Now()
+
+	f.reconcile.mu.Lock()
+	f.reconcile.last = report
+	f.reconcile.mu.Unlock()
+
+	return report, nil
+}
+
+// LastReconcileReport returns the report of the last reconciliation, or nil
+// if none has run since the service started
+func (f *FileStorageManager) LastReconcileReport() *ReconcileReport {
+	f.reconcile.mu.Lock()
+	defer f.reconcile.mu.Unlock()
+
+	return f.reconcile.last
+}
+
+// deleteOrphan deletes an object without a record
+func (f *FileStorageManager) deleteOrphan(orphan *StoredObject) error {
+	response, err := f.deleteFile(orphan.Provider, orphan.FileID, orphan.Bucket, "", f.softDelete(orphan.FileID))
+	if err == nil && response.Status != StatusSuccess {
+		err = errors.New(response.Message)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := f.RecordAudit(AuditEvent{Action: AuditDelete, Provider: orphan.Provider, FileID: orphan.FileID, Via: "reconcile", Status: http.StatusOK}); err != nil {
+		log.Printf("filestorage: recording the deletion of orphan %s in the audit log failed: %v", orphan.FileID, err)
+	}
+	return nil
+}
+
+// forgetDangling removes a record whose object is gone, unless the object
+// was stored again after the bucket was listed
+func (f *FileStorageManager) forgetDangling(fileID string) error {
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if err != nil {
+		return nil
+	}
+	if _, exists, err := f.Exists(record.Provider, fileID, record.Bucket, ""); err != nil || exists {
+		return err
+	}
+	return f.deleteRecord(fileID)
+}
+
+// reconcileJob returns the scheduled job reconciling the buckets with the
+// metadata store
+func (f *FileStorageManager) reconcileJob(clean bool) func() error {
+	return func() error {
+		report, err := f.Reconcile(clean)
+		if err != nil {
+			return err
+		}
+		if report.OrphanCount > 0 || report.DanglingCount > 0 {
+			log.Printf("filestorage: found %d orphaned objects and %d dangling records", report.OrphanCount, report.DanglingCount)
+		}
+		return nil
+	}
+}
+
+// derivedObject reports whether a key is below one of derivedPrefixes
+func derivedObject(key string) bool {
+	for _, prefix := range derivedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
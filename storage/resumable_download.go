@@ -0,0 +1,120 @@
+// pkg/storage/resumable_download.go
+
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// partSuffix marks a local download that has not finished yet
+const partSuffix = ".part"
+
+// downloadSource is a stored object being downloaded to a local file
+type downloadSource struct {
+	version         string // ETag or generation identifying the object's content
+	size            int64
+	contentEncoding string
+	encoded         bool // Content differs from the stored bytes, so ranged reads cannot be resumed
+	// open reads the stored object from offset, failing if it no longer has version
+	open func(offset int64) (io.ReadCloser, error)
+}
+
+// resumableDownload downloads source into saveAsPath through a ".part" file,
+// retrying up to the configured number of times. Each attempt continues where
+// the part file ends as long as the stored object has not changed, so a part
+// file left behind by a failed call is picked up by the next one.
+func (f *FileStorageManager) resumableDownload(source *downloadSource, saveAsPath string) error {
+	partPath := saveAsPath + partSuffix
+
+	attempts := f.maxRetry
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err = f.downloadAttempt(source, partPath); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	os.Remove(partPath + ".version")
+	return os.Rename(partPath, saveAsPath)
+}
+
+// downloadAttempt continues or restarts writing a part file
+func (f *FileStorageManager) downloadAttempt(source *downloadSource, partPath string) error {
+	file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offset, err := resumeOffset(file, partPath, source)
+	if err != nil {
+		return err
+	}
+
+	if offset == 0 {
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(partPath+".version", []byte(source.version), 0644); err != nil {
+			return err
+		}
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// A complete part file only has to be renamed
+	if !source.encoded && offset == source.size {
+		return nil
+	}
+
+	body, err := source.open(offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if source.encoded {
+		_, err = f.copyDecoded(file, f.throttle.Reader(body), source.contentEncoding)
+	} else {
+		_, err = Copy(file, f.throttle.Reader(body))
+	}
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// resumeOffset returns where a part file can be continued, 0 when it has to
+// be restarted because the object changed or cannot be read by range
+func resumeOffset(file *os.File, partPath string, source *downloadSource) (int64, error) {
+	if source.encoded {
+		return 0, nil
+	}
+
+	version, err := ioutil.ReadFile(partPath + ".version")
+	if err != nil || strings.TrimSpace(string(version)) != source.version {
+		return 0, nil
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if stat.Size() > source.size {
+		return 0, nil
+	}
+
+	return stat.Size(), nil
+}
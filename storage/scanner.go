@@ -0,0 +1,168 @@
+// pkg/storage/scanner.go
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Scan statuses recorded in FileInfo
+const (
+	ScanStatusClean    = "clean"
+	ScanStatusInfected = "infected"
+)
+
+// Actions taken on infected uploads
+const (
+	ScanActionReject = "reject"
+	ScanActionFlag   = "flag"
+)
+
+// clamdChunkSize is the size of the chunks streamed to clamd
+const clamdChunkSize = 64 * 1024
+
+// ScanResult is the outcome of scanning an upload
+type ScanResult struct {
+	Status    string `json:"scan_status"`              // ScanStatusClean or ScanStatusInfected
+	Signature string `json:"scan_signature,omitempty"` // Name of the detected malware, if any
+}
+
+// Scanner scans upload content for malware
+type Scanner interface {
+	Scan(r io.Reader) (*ScanResult, error)
+}
+
+// ClamdScanner implements Scanner with the clamd INSTREAM command
+type ClamdScanner struct {
+	Network string // "tcp" or "unix"
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamdScanner creates a clamd scanner from an address such as
+// "tcp://clamd:3310" or "unix:///var/run/clamav/clamd.ctl". Addresses without
+// a scheme are dialed over TCP.
+func NewClamdScanner(address string) *ClamdScanner {
+	network := "tcp"
+	if i := strings.Index(address, "://"); i >= 0 {
+		network, address = address[:i], address[i+3:]
+	}
+
+	return &ClamdScanner{
+		Network: network,
+		Address: address,
+		Timeout: 2 * time.Minute,
+	}
+}
+
+// Scan streams r to clamd and parses its verdict
+func (c *ClamdScanner) Scan(r io.Reader) (*ScanResult, error) {
+	conn, err := net.DialTimeout(c.Network, c.Address, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	defer conn.Close()
+
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+
+	chunk := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrScanFailed, err)
+			}
+			if _, err := conn.Write(chunk[:n]); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrScanFailed, err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+
+	// A zero length chunk ends the stream
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamdReply parses replies such as "stream: OK" and
+// "stream: Eicar-Signature FOUND"
+func parseClamdReply(reply string) (*ScanResult, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case reply == "OK":
+		return &ScanResult{Status: ScanStatusClean}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return &ScanResult{
+			Status:    ScanStatusInfected,
+			Signature: strings.TrimSuffix(reply, " FOUND"),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrScanFailed, reply)
+}
+
+// SetScanner sets the scanner run on every upload, nil disables scanning
+func (f *FileStorageManager) SetScanner(scanner Scanner) {
+	f.scanner = scanner
+}
+
+// SetScanAction sets what happens to infected uploads, ScanActionReject or ScanActionFlag
+func (f *FileStorageManager) SetScanAction(action string) {
+	f.scanAction = action
+}
+
+// scanUpload scans upload content, rejecting infected files unless they are
+// only to be flagged. Scanner failures reject the upload.
+func (f *FileStorageManager) scanUpload(r io.Reader) (*ScanResult, error) {
+	if f.scanner == nil {
+		return nil, nil
+	}
+
+	result, err := f.scanner.Scan(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Status == ScanStatusInfected && f.scanAction != ScanActionFlag {
+		return nil, fmt.Errorf("%w: %s", ErrFileInfected, result.Signature)
+	}
+
+	return result, nil
+}
+
+// applyTo records the scan result in a FileInfo
+func (r *ScanResult) applyTo(info *FileInfo) {
+	if r == nil || info == nil {
+		return
+	}
+	info.ScanStatus = r.Status
+	info.ScanSignature = r.Signature
+}
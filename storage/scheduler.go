@@ -0,0 +1,312 @@
+// pkg/storage/scheduler.go
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the scheduled jobs
+const (
+	JobCleanupUploads = "cleanup_uploads" // Abort chunked uploads and purge direct uploads that were never completed
+	JobExpireFiles    = "expire_files"    // Delete or archive files once their expiry has passed
+	JobTenantUsage    = "tenant_usage"    // Aggregate, and export once a month is over, the tenant report
+	JobReconcile      = "reconcile"       // Reconcile the buckets with the metadata store
+	JobPurgeTrash     = "purge_trash"     // Purge soft deleted files once their retention has passed
+	JobRefreshToken   = "refresh_token"   // Renew the storage API token before it expires
+)
+
+// jobNames are the names of every scheduled job
+var jobNames = []string{JobCleanupUploads, JobExpireFiles, JobTenantUsage, JobReconcile, JobPurgeTrash, JobRefreshToken}
+
+// Statuses of scheduled job runs
+const (
+	RunOK     = "ok"
+	RunFailed = "failed"
+)
+
+// tokenRefreshInterval is how often the storage API token is renewed, ahead
+// of the hour tokens are cached for
+const tokenRefreshInterval = 50 * time.Minute
+
+// JobSchedule is the interval of scheduled jobs by name
+type JobSchedule map[string]time.Duration
+
+// ParseJobSchedule parses comma separated name=interval pairs, e.g.
+// "purge_trash=30m,reconcile=6h"; an interval of 0 runs a job only on request
+func ParseJobSchedule(value string) (JobSchedule, error) {
+	schedule := JobSchedule{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		name, interval, found := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !found || !containsString(jobNames, name) {
+			return nil, fmt.Errorf("%w: unknown job in %q", ErrInvalidSchedule, entry)
+		}
+
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("%w: invalid interval in %q", ErrInvalidSchedule, entry)
+		}
+		schedule[name] = d
+	}
+
+	if len(schedule) == 0 {
+		return nil, nil
+	}
+	return schedule, nil
+}
+
+// JobRun is the outcome of one run of a scheduled job
+type JobRun struct {
+	Status     string    `json:"status"` // RunOK or RunFailed
+	Error      string    `json:"error,omitempty"`
+	Duration   string    `json:"duration"`
+	Manual     bool      `json:"manual"` // Triggered on request rather than by the schedule
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// ScheduledJob is the schedule and the last run of a maintenance job
+type ScheduledJob struct {
+	Name     string     `json:"name"`
+	Interval string     `json:"interval"` // Time between runs, "0s" when the job only runs on request
+	Enabled  bool       `json:"enabled"`
+	Running  bool       `json:"running"`
+	NextRun  *time.Time `json:"next_run,omitempty"` // nil while the job is not enabled
+	LastRun  *JobRun    `json:"last_run"`           // nil when it has not run since the service started
+}
+
+// scheduledJob is a maintenance job run every interval while enabled
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	run      func() error
+
+	enabled bool
+	running bool
+	next    time.Time
+	last    *JobRun
+	wake    chan struct{} // Reschedules the job when it is enabled or disabled
+}
+
+// scheduler runs the maintenance jobs of the manager, such as purging the
+// trash, one run of each job at a time
+type scheduler struct {
+	mu      sync.Mutex
+	jobs    map[string]*scheduledJob
+	stop    chan struct{} // Closed by stopScheduler
+	stopped sync.Once
+	loops   sync.WaitGroup // Goroutines running the schedules
+}
+
+// schedule registers a job run every interval, or only on request when the
+// interval is 0. Intervals configured for the job take precedence.
+func (f *FileStorageManager) schedule(name string, interval time.Duration, run func() error) {
+	if configured, ok := f.config.JobIntervals[name]; ok {
+		interval = configured
+	}
+
+	job := &scheduledJob{
+		name:     name,
+		interval: interval,
+		run:      run,
+		enabled:  interval > 0 && !containsString(f.config.DisabledJobs, name),
+		wake:     make(chan struct{}, 1),
+	}
+	if job.enabled {
+		job.next = time.Now().Add(interval)
+	}
+	f.scheduler.jobs[name] = job
+}
+
+// startScheduler registers the maintenance jobs and starts running them
+func (f *FileStorageManager) startScheduler() {
+	f.schedule(JobCleanupUploads, uploadSessionSweepInterval, func() error {
+		_, err := f.CleanupExpiredUploads()
+		_, purgeErr := f.PurgeAbandonedUploads()
+		return errors.Join(err, purgeErr)
+	})
+	f.schedule(JobExpireFiles, expirySweepInterval, func() error {
+		_, err := f.ExpireFiles()
+		return err
+	})
+	f.schedule(JobTenantUsage, tenantUsageInterval, f.tenantUsageJob(f.config.TenantReportProvider))
+	f.schedule(JobReconcile, f.config.ReconcileInterval, f.reconcileJob(f.config.ReconcileClean))
+
+	var trashInterval time.Duration
+	if f.trashRetention > 0 {
+		trashInterval = trashSweepInterval
+	}
+	f.schedule(JobPurgeTrash, trashInterval, func() error {
+		_, err := f.PurgeTrash()
+		return err
+	})
+
+	var tokenInterval time.Duration
+	if f.tokenManager != nil && f.config.AuthorizationServerURI != "" {
+		tokenInterval = tokenRefreshInterval
+	}
+	f.schedule(JobRefreshToken, tokenInterval, func() error {
+		if f.tokenManager == nil {
+			return nil
+		}
+		_, err := f.tokenManager.GenerateToken()
+		return err
+	})
+
+	for _, job := range f.scheduler.jobs {
+		f.scheduler.loops.Add(1)
+		go f.runSchedule(job)
+	}
+}
+
+// stopScheduler stops running the jobs on their schedule and waits for runs
+// in progress to finish. Jobs can still be run on request.
+func (f *FileStorageManager) stopScheduler() {
+	f.scheduler.stopped.Do(func() { close(f.scheduler.stop) })
+	f.scheduler.loops.Wait()
+}
+
+// runSchedule runs a job every interval while it is enabled, until the
+// scheduler is stopped
+func (f *FileStorageManager) runSchedule(job *scheduledJob) {
+	defer f.scheduler.loops.Done()
+
+	for {
+		f.scheduler.mu.Lock()
+		var timer <-chan time.Time
+		if job.enabled && job.interval > 0 {
+			job.next = time.Now().Add(job.interval)
+			timer = time.After(job.interval)
+		} else {
+			job.next = time.Time{}
+		}
+		f.scheduler.mu.Unlock()
+
+		select {
+		case <-timer:
+			if _, err := f.runJob(job, false); err != nil {
+				log.Printf("filestorage: scheduled job %s failed: %v", job.name, err)
+			}
+		case <-job.wake:
+		case <-f.scheduler.stop:
+			return
+		}
+	}
+}
+
+// runJob runs a job once, unless a run of it is already in progress
+func (f *FileStorageManager) runJob(job *scheduledJob, manual bool) (*JobRun, error) {
+	f.scheduler.mu.Lock()
+	if job.running {
+		f.scheduler.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrScheduledJobRunning, job.name)
+	}
+	job.running = true
+	f.scheduler.mu.Unlock()
+
+	run := &JobRun{Status: RunOK, Manual: manual, StartedAt: time.Now()}
+	err := job.run()
+	run.FinishedAt = time.Now()
+	run.Duration = run.FinishedAt.Sub(run.StartedAt).String()
+	if err != nil {
+		run.Status = RunFailed
+		run.Error = err.Error()
+	}
+
+	f.scheduler.mu.Lock()
+	job.running = false
+	job.last = run
+	f.scheduler.mu.Unlock()
+
+	return run, err
+}
+
+// ScheduledJobs returns the schedule and the last run of every maintenance
+// job, by name
+func (f *FileStorageManager) ScheduledJobs() []*ScheduledJob {
+	f.scheduler.mu.Lock()
+	defer f.scheduler.mu.Unlock()
+
+	jobs := make([]*ScheduledJob, 0, len(f.scheduler.jobs))
+	for _, job := range f.scheduler.jobs {
+		jobs = append(jobs, job.status())
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs
+}
+
+// RunScheduledJob runs a maintenance job now, whether or not it is enabled,
+// and returns the outcome once it finished. The error of a failed run is
+// recorded in the run rather than returned.
+func (f *FileStorageManager) RunScheduledJob(name string) (*JobRun, error) {
+	job, err := f.scheduledJob(name)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := f.runJob(job, true)
+	if run == nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// SetScheduledJobEnabled starts or stops running a maintenance job on its
+// schedule. Jobs with an interval of 0 cannot be enabled.
+func (f *FileStorageManager) SetScheduledJobEnabled(name string, enabled bool) (*ScheduledJob, error) {
+	job, err := f.scheduledJob(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.scheduler.mu.Lock()
+	defer f.scheduler.mu.Unlock()
+
+	if enabled && job.interval <= 0 {
+		return nil, fmt.Errorf("%w: %s has no interval", ErrInvalidSchedule, name)
+	}
+	if job.enabled != enabled {
+		job.enabled = enabled
+		select {
+		case job.wake <- struct{}{}:
+		default:
+		}
+	}
+	return job.status(), nil
+}
+
+// scheduledJob returns the job of a name
+func (f *FileStorageManager) scheduledJob(name string) (*scheduledJob, error) {
+	job, ok := f.scheduler.jobs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrScheduledJobNotFound, name)
+	}
+	return job, nil
+}
+
+// status returns the schedule and the last run of a job, with the scheduler locked
+func (j *scheduledJob) status() *ScheduledJob {
+	status := &ScheduledJob{
+		Name:     j.name,
+		Interval: j.interval.String(),
+		Enabled:  j.enabled,
+		Running:  j.running,
+		LastRun:  j.last,
+	}
+	if !j.next.IsZero() {
+		next := j.next
+		status.NextRun = &next
+	}
+	return status
+}
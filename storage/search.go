@@ -0,0 +1,175 @@
+// pkg/storage/search.go
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Orders of search results, "-" prefixed for descending order
+const (
+	SortCreatedAt = "created_at"
+	SortName      = "name"
+	SortSize      = "size"
+)
+
+const (
+	// DefaultSearchLimit is the number of files a search returns when no limit is given
+	DefaultSearchLimit = 50
+
+	// MaxSearchLimit is the most files a search returns at once
+	MaxSearchLimit = 1000
+)
+
+// FileQuery filters, orders and pages a search of the metadata store.
+// Empty fields do not filter.
+type FileQuery struct {
+	Name     string    // Case-insensitive part of the original filename
+	MimeType string    // Content type such as "application/pdf", or a family such as "image/*"
+	Tag      string    // Tag the files carry
+	Owner    string    // Subject that uploaded the files
+	Provider string    // ProviderAWS or ProviderGCS
+	Folder   string    // ID of the virtual folder holding the files, RootFolder for files outside any folder
+	From     time.Time // Uploaded at or after
+	To       time.Time // Uploaded before
+	Sort     string    // SortCreatedAt (default), SortName or SortSize, e.g. "-size" for largest first
+	Offset   int
+	Limit    int // DefaultSearchLimit when 0
+}
+
+// SearchResult is a page of files found by a search
+type SearchResult struct {
+	Files  []*FileRecord `json:"files"`
+	Total  int           `json:"total"` // Files matching the query on all pages
+	Offset int           `json:"offset"`
+	Limit  int           `json:"limit"`
+}
+
+// FileSearcher is implemented by metadata stores that search their records
+// themselves. Stores that only implement FileLister are searched in memory.
+type FileSearcher interface {
+	// SearchFiles returns a page of the records matching a normalized query
+	SearchFiles(query FileQuery) (*SearchResult, error)
+}
+
+// SearchFiles finds recorded files by name, type, tag, owner, provider and
+// upload time without listing buckets. Files in the trash or the archive and
+// direct uploads that were not confirmed are left out.
+func (f *FileStorageManager) SearchFiles(query FileQuery) (*SearchResult, error) {
+	if err := query.normalize(); err != nil {
+		return nil, err
+	}
+
+	if searcher, ok := f.metadataStore.(FileSearcher); ok {
+		return searcher.SearchFiles(query)
+	}
+
+	lister, ok := f.metadataStore.(FileLister)
+	if !ok {
+		return nil, ErrSearchNotSupported
+	}
+
+	records, err := lister.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	return searchRecords(records, query), nil
+}
+
+// normalize checks a query and fills in its defaults
+func (q *FileQuery) normalize() error {
+	if q.Limit == 0 {
+		q.Limit = DefaultSearchLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxSearchLimit {
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxSearchLimit)
+	}
+	if q.Offset < 0 {
+		return fmt.Errorf("%w: offset must not be negative", ErrInvalidQuery)
+	}
+
+	if q.Sort == "" {
+		q.Sort = SortCreatedAt
+	}
+	switch strings.TrimPrefix(q.Sort, "-") {
+	case SortCreatedAt, SortName, SortSize:
+	default:
+		return fmt.Errorf("%w: unknown sort %q", ErrInvalidQuery, q.Sort)
+	}
+
+	q.MimeType = normalizeMimeType(q.MimeType)
+	return nil
+}
+
+// Matches reports whether a record matches the filters of the query
+func (q *FileQuery) Matches(record *FileRecord) bool {
+	switch {
+	case inTrash(record.FileID), inArchive(record.FileID), inVersions(record.FileID), record.UploadStatus == UploadPending:
+		return false
+	case q.Name != "" && !strings.Contains(strings.ToLower(record.FileName), strings.ToLower(q.Name)):
+		return false
+	case q.MimeType != "" && !matchMimeType([]string{q.MimeType}, normalizeMimeType(record.MimeType)):
+		return false
+	case q.Tag != "" && !containsString(record.Tags, q.Tag):
+		return false
+	case q.Owner != "" && record.Owner != q.Owner:
+		return false
+	case q.Provider != "" && record.Provider != q.Provider:
+		return false
+	case q.Folder == RootFolder && record.Folder != "", q.Folder != "" && q.Folder != RootFolder && record.Folder != q.Folder:
+		return false
+	case !q.From.IsZero() && record.CreatedAt.Before(q.From):
+		return false
+	case !q.To.IsZero() && !record.CreatedAt.Before(q.To):
+		return false
+	}
+	return true
+}
+
+// searchRecords filters, sorts and pages records in memory
+func searchRecords(records []*FileRecord, query FileQuery) *SearchResult {
+	matches := []*FileRecord{}
+	for _, record := range records {
+		if query.Matches(record) {
+			matches = append(matches, record)
+		}
+	}
+
+	descending := strings.HasPrefix(query.Sort, "-")
+	less := func(a, b *FileRecord) bool {
+		switch strings.TrimPrefix(query.Sort, "-") {
+		case SortName:
+			if a.FileName != b.FileName {
+				return a.FileName < b.FileName
+			}
+		case SortSize:
+			if a.FileSize != b.FileSize {
+				return a.FileSize < b.FileSize
+			}
+		default:
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+		}
+		return a.FileID < b.FileID
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if descending {
+			return less(matches[j], matches[i])
+		}
+		return less(matches[i], matches[j])
+	})
+
+	result := &SearchResult{Files: []*FileRecord{}, Total: len(matches), Offset: query.Offset, Limit: query.Limit}
+	if query.Offset < len(matches) {
+		end := query.Offset + query.Limit
+		if end > len(matches) {
+			end = len(matches)
+		}
+		result.Files = matches[query.Offset:end]
+	}
+	return result
+}
@@ -0,0 +1,264 @@
+// pkg/storage/share_link.go
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ShareLink is a service-managed link to a stored file, served through the
+// proxy so every download can be counted
+type ShareLink struct {
+	Token         string    `json:"token"`
+	Provider      string    `json:"provider"`
+	Bucket        string    `json:"bucket,omitempty"`
+	ProjectID     string    `json:"project_id,omitempty"`
+	FileID        string    `json:"file_id"`
+	MaxDownloads  int       `json:"max_downloads,omitempty"` // 0 means unlimited
+	Downloads     int       `json:"downloads"`
+	ExpiresAt     time.Time `json:"expires_at,omitempty"` // Zero means the link does not expire
+	PasswordHash  string    `json:"-"`                    // bcrypt hash, empty when no password is required
+	AllowedEmails []string  `json:"allowed_emails,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ShareLinkOptions limits the use of a share link
+type ShareLinkOptions struct {
+	MaxDownloads  int       // Downloads allowed, 0 means unlimited
+	ExpiresAt     time.Time // Deadline of the link, zero means none
+	Password      string    // Password recipients must give, "" for none
+	AllowedEmails []string  // Recipients allowed to use the link, nil for anyone
+}
+
+// ShareCodeTTL is how long the one-time codes verifying the email of a
+// share link recipient are valid
+const ShareCodeTTL = 10 * time.Minute
+
+// shareCodePrefix keys the one-time codes of share links in the token cache
+const shareCodePrefix = "share-code:"
+
+// ShareAccess is what a recipient presents when opening a share link
+type ShareAccess struct {
+	Password string
+	Email    string // Verified email of the recipient, "" when unknown
+	Code     string // One-time code from IssueShareCode, verifying the email it was issued for
+	Resume   bool   // Ranged request continuing a download past its first byte
+}
+
+// ShareLinkStore persists share links and their download counts
+type ShareLinkStore interface {
+	// SaveShareLink inserts or replaces the link for link.Token
+	SaveShareLink(link *ShareLink) error
+	// GetShareLink returns the link for a token or ErrShareLinkNotFound
+	GetShareLink(token string) (*ShareLink, error)
+	// ConsumeShareLink atomically counts a download of a link, returning
+	// ErrShareLinkExhausted when its downloads are used up
+	ConsumeShareLink(token string) (*ShareLink, error)
+	// DeleteShareLink removes the link for a token, if any
+	DeleteShareLink(token string) error
+	// ListShareLinks returns the links to a file, or all links when fileID is ""
+	ListShareLinks(fileID string) ([]*ShareLink, error)
+}
+
+// SetShareLinkStore sets the store share links are tracked in
+func (f *FileStorageManager) SetShareLinkStore(store ShareLinkStore) {
+	f.shareStore = store
+}
+
+// CreateShareLink creates a share link to a file stored with an S3 or GCS provider
+func (f *FileStorageManager) CreateShareLink(provider, fileID, bucketname, projectID string, opts ShareLinkOptions) (*ShareLink, error) {
+	if provider != ProviderAWS && provider != ProviderGCS {
+		return nil, fmt.Errorf("unknown provider %q", provider)
+	}
+	if opts.MaxDownloads < 0 {
+		return nil, fmt.Errorf("max downloads must not be negative")
+	}
+	if !opts.ExpiresAt.IsZero() && !opts.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("share link expiry must be in the future")
+	}
+
+	// Make sure the file exists before handing out a link to it
+	file, err := f.OpenFile(provider, fileID, bucketname, projectID)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	link := &ShareLink{
+		Token:        uuid.New().String(),
+		Provider:     provider,
+		Bucket:       bucketname,
+		ProjectID:    projectID,
+		FileID:       fileID,
+		MaxDownloads: opts.MaxDownloads,
+		ExpiresAt:    opts.ExpiresAt,
+		CreatedAt:    time.Now(),
+	}
+
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	for _, email := range opts.AllowedEmails {
+		if email = normalizeEmail(email); email != "" {
+			link.AllowedEmails = append(link.AllowedEmails, email)
+		}
+	}
+
+	if err := f.shareStore.SaveShareLink(link); err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// GetShareLink returns a share link without counting a download
+func (f *FileStorageManager) GetShareLink(token string) (*ShareLink, error) {
+	return f.shareStore.GetShareLink(token)
+}
+
+// ListShareLinks returns the share links to a file, or all links when fileID
+// is "", oldest first
+func (f *FileStorageManager) ListShareLinks(fileID string) ([]*ShareLink, error) {
+	links, err := f.shareStore.ListShareLinks(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.Before(links[j].CreatedAt)
+	})
+	return links, nil
+}
+
+// OpenShareLink opens the file behind a share link for a recipient, counting
+// a download. Requests resuming a download do not count again, once the link
+// counted one.
+func (f *FileStorageManager) OpenShareLink(token string, access ShareAccess) (*ObjectFile, error) {
+	link, err := f.shareStore.GetShareLink(token)
+	if err != nil {
+		return nil, err
+	}
+	if link.expired() {
+		return nil, ErrShareLinkExpired
+	}
+	if access.Code != "" {
+		if access.Email, err = f.redeemShareCode(token, access.Code); err != nil {
+			return nil, err
+		}
+	}
+	if err := link.authorize(access); err != nil {
+		return nil, err
+	}
+
+	file, err := f.OpenFile(link.Provider, link.FileID, link.Bucket, link.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if access.Resume && link.Downloads > 0 {
+		return file, nil
+	}
+
+	// Count the download only once the file is known to be readable
+	if _, err := f.shareStore.ConsumeShareLink(token); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// IssueShareCode issues a one-time code verifying that a recipient of a
+// share link restricted to emails holds an allowed email, valid for
+// ShareCodeTTL. The calling service delivers it to that address; the
+// recipient opens the link with it.
+func (f *FileStorageManager) IssueShareCode(token, email string) (string, time.Time, error) {
+	link, err := f.shareStore.GetShareLink(token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if link.expired() {
+		return "", time.Time{}, ErrShareLinkExpired
+	}
+
+	email = normalizeEmail(email)
+	if !containsString(link.AllowedEmails, email) {
+		return "", time.Time{}, fmt.Errorf("%w: recipient not allowed", ErrShareLinkForbidden)
+	}
+
+	code, err := randomCode(shortCodeLength)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	f.tokenCache.Set(shareCodePrefix+token+":"+code, email, ShareCodeTTL)
+
+	return code, time.Now().Add(ShareCodeTTL), nil
+}
+
+// redeemShareCode returns the email a one-time code of a share link was
+// issued for, invalidating the code
+func (f *FileStorageManager) redeemShareCode(token, code string) (string, error) {
+	key := shareCodePrefix + token + ":" + code
+	email, found := f.tokenCache.Get(key)
+	if !found {
+		return "", fmt.Errorf("%w: invalid or expired code", ErrShareLinkForbidden)
+	}
+
+	f.tokenCache.Delete(key)
+	return email, nil
+}
+
+// RevokeShareLink removes a share link, so it can no longer be used
+func (f *FileStorageManager) RevokeShareLink(token string) error {
+	if _, err := f.shareStore.GetShareLink(token); err != nil {
+		return err
+	}
+	return f.shareStore.DeleteShareLink(token)
+}
+
+// expired reports whether the link has passed its deadline
+func (l *ShareLink) expired() bool {
+	return !l.ExpiresAt.IsZero() && time.Now().After(l.ExpiresAt)
+}
+
+// exhausted reports whether the link's downloads are used up
+func (l *ShareLink) exhausted() bool {
+	return l.MaxDownloads > 0 && l.Downloads >= l.MaxDownloads
+}
+
+// authorize checks the password and email a recipient presented
+func (l *ShareLink) authorize(access ShareAccess) error {
+	if l.PasswordHash != "" {
+		if bcrypt.CompareHashAndPassword([]byte(l.PasswordHash), []byte(access.Password)) != nil {
+			return fmt.Errorf("%w: wrong password", ErrShareLinkForbidden)
+		}
+	}
+
+	if len(l.AllowedEmails) > 0 {
+		email := normalizeEmail(access.Email)
+		for _, allowed := range l.AllowedEmails {
+			if email != "" && email == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: recipient not allowed", ErrShareLinkForbidden)
+	}
+
+	return nil
+}
+
+// normalizeEmail lowercases an email address for comparison
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
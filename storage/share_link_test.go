@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestShareLinkResumedDownloads(t *testing.T) {
+	f, _, _ := newFakeS3Manager(t, map[string]int64{"report.pdf": 1})
+	link, err := f.CreateShareLink(ProviderAWS, "report.pdf", "bucket", "", ShareLinkOptions{MaxDownloads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.OpenShareLink(link.Token, ShareAccess{Resume: true}); err != nil {
+		t.Fatal(err)
+	}
+	if link, _ = f.GetShareLink(link.Token); link.Downloads != 1 {
+		t.Fatalf("downloads = %d after resuming a download never started, want 1", link.Downloads)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := f.OpenShareLink(link.Token, ShareAccess{Resume: true}); err != nil {
+			t.Fatalf("resume %d: %v", i, err)
+		}
+	}
+	if link, _ = f.GetShareLink(link.Token); link.Downloads != 1 {
+		t.Errorf("downloads = %d after resuming, want 1", link.Downloads)
+	}
+
+	if _, err := f.OpenShareLink(link.Token, ShareAccess{}); !errors.Is(err, ErrShareLinkExhausted) {
+		t.Errorf("new download: error = %v, want ErrShareLinkExhausted", err)
+	}
+}
@@ -0,0 +1,206 @@
+// pkg/storage/short_link.go
+
+package storage
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	// ShortLinkURLExpiry is how long the signed URLs behind short links are valid
+	ShortLinkURLExpiry = 15 * time.Minute
+
+	// shortLinkRenewMargin is how long before its expiry a cached signed URL is renewed,
+	// so a redirect never hands out a URL about to expire
+	shortLinkRenewMargin = time.Minute
+
+	shortCodeLength   = 8
+	shortCodeAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// ShortLink is a compact code redirecting to a freshly signed URL of a stored
+// file, for places where full signed URLs do not fit
+type ShortLink struct {
+	Code         string      `json:"code"`
+	Provider     string      `json:"provider"`
+	Bucket       string      `json:"bucket,omitempty"`
+	ProjectID    string      `json:"project_id,omitempty"`
+	FileID       string      `json:"file_id"`
+	Options      LinkOptions `json:"-"`
+	ExpiresAt    time.Time   `json:"expires_at,omitempty"` // Zero means the link does not expire
+	CreatedAt    time.Time   `json:"created_at"`
+	URL          string      `json:"-"` // Cached signed URL, "" until first resolved
+	URLExpiresAt time.Time   `json:"-"`
+}
+
+// ShortLinkStore persists short links and their cached signed URLs
+type ShortLinkStore interface {
+	// SaveShortLink inserts or replaces the link for link.Code
+	SaveShortLink(link *ShortLink) error
+	// GetShortLink returns the link for a code or ErrShortLinkNotFound
+	GetShortLink(code string) (*ShortLink, error)
+	// DeleteShortLink removes the link for a code, if any
+	DeleteShortLink(code string) error
+}
+
+// SetShortLinkStore sets the store short links are kept in
+func (f *FileStorageManager) SetShortLinkStore(store ShortLinkStore) {
+	f.shortStore = store
+}
+
+// CreateShortLink creates a short link to a file stored with an S3 or GCS
+// provider. opts overrides the response headers of the signed URLs it
+// redirects to; expiresAt ends the link itself, zero for never.
+func (f *FileStorageManager) CreateShortLink(provider, fileID, bucketname, projectID string, expiresAt time.Time, opts LinkOptions) (*ShortLink, error) {
+	if provider != ProviderAWS && provider != ProviderGCS {
+		return nil, fmt.Errorf("unknown provider %q", provider)
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("short link expiry must be in the future")
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if len(opts.Headers) > 0 {
+		return nil, fmt.Errorf("%w: browsers following a short link cannot send required headers", ErrUnsupportedRestriction)
+	}
+
+	// Make sure the file exists before handing out a link to it
+	if _, exists, err := f.Exists(provider, fileID, bucketname, projectID); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, fileID)
+	}
+
+	link := &ShortLink{
+		Provider:  provider,
+		Bucket:    bucketname,
+		ProjectID: projectID,
+		FileID:    fileID,
+		Options:   opts,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	// Sign the first URL now, so a misconfigured provider fails early
+	if err := f.signShortLink(link); err != nil {
+		return nil, err
+	}
+
+	code, err := f.newShortCode()
+	if err != nil {
+		return nil, err
+	}
+	link.Code = code
+
+	if err := f.shortStore.SaveShortLink(link); err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// GetShortLink returns a short link by code
+func (f *FileStorageManager) GetShortLink(code string) (*ShortLink, error) {
+	return f.shortStore.GetShortLink(code)
+}
+
+// ResolveShortLink returns the signed URL a short link redirects to, reusing
+// the cached URL until shortly before it expires
+func (f *FileStorageManager) ResolveShortLink(code string) (string, error) {
+	link, err := f.shortStore.GetShortLink(code)
+	if err != nil {
+		return "", err
+	}
+	if !link.ExpiresAt.IsZero() && time.Now().After(link.ExpiresAt) {
+		return "", ErrShortLinkExpired
+	}
+
+	if link.URL != "" && time.Now().Add(shortLinkRenewMargin).Before(link.URLExpiresAt) {
+		return link.URL, nil
+	}
+
+	if err := f.signShortLink(link); err != nil {
+		return "", err
+	}
+
+	// A failed save only costs signing again on the next redirect
+	f.shortStore.SaveShortLink(link)
+	return link.URL, nil
+}
+
+// RevokeShortLink removes a short link. Signed URLs it already handed out stay
+// valid until they expire, at most ShortLinkURLExpiry.
+func (f *FileStorageManager) RevokeShortLink(code string) error {
+	if _, err := f.shortStore.GetShortLink(code); err != nil {
+		return err
+	}
+	return f.shortStore.DeleteShortLink(code)
+}
+
+// signShortLink signs a new URL for a short link, valid no longer than the link itself
+func (f *FileStorageManager) signShortLink(link *ShortLink) error {
+	expiry := time.Now().Add(ShortLinkURLExpiry)
+	if !link.ExpiresAt.IsZero() && link.ExpiresAt.Before(expiry) {
+		expiry = link.ExpiresAt
+	}
+
+	var result *FileResponse
+	var err error
+	switch link.Provider {
+	case ProviderAWS:
+		result, err = f.AwsGetTemporaryPublicLinkWithOptions(link.FileID, expiry, link.Bucket, link.Options)
+	case ProviderGCS:
+		result, err = f.GcsGetTemporaryPublicLinkWithOptions(link.FileID, expiry, link.Bucket, link.ProjectID, link.Options)
+	default:
+		err = fmt.Errorf("unknown provider %q", link.Provider)
+	}
+	if err != nil {
+		return err
+	}
+	if result.Status != StatusSuccess {
+		return errors.New(result.Message)
+	}
+
+	link.URL = result.URL
+	link.URLExpiresAt = expiry
+	return nil
+}
+
+// newShortCode generates a random code not used by another short link
+func (f *FileStorageManager) newShortCode() (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := randomCode(shortCodeLength)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = f.shortStore.GetShortLink(code)
+		if errors.Is(err, ErrShortLinkNotFound) {
+			return code, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("failed to generate a unique short link code")
+}
+
+// randomCode returns a random string of base62 characters
+func randomCode(length int) (string, error) {
+	max := big.NewInt(int64(len(shortCodeAlphabet)))
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = shortCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
@@ -0,0 +1,212 @@
+// pkg/storage/tags.go
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// MaxTags is the most tags a file carries
+	MaxTags = 50
+
+	// MaxTagLength is the longest tag in characters, the length of an S3 tag key
+	MaxTagLength = 128
+
+	// gcsTagsMetadata is the custom metadata key GCS objects list their tags
+	// under, separated by commas, as GCS has no object tags
+	gcsTagsMetadata = "tags"
+)
+
+// tagPattern matches the characters S3 allows in tag keys, which excludes
+// the commas separating tags in GCS metadata
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N} _.:/=+\-@]+$`)
+
+// AddTags attaches tags to a recorded file, keeping the tags it already
+// carries. Tags are persisted in the metadata store, where files are found
+// by tag, and mirrored to the object: as S3 object tags with empty values,
+// or as the comma separated "tags" metadata of the GCS object. Mirroring is
+// best effort; S3 refuses more than 10 tags per object and object tagging
+// may not be permitted, which is logged and leaves the record authoritative.
+func (f *FileStorageManager) AddTags(provider, fileID, bucketname, projectID string, tags []string) (*FileRecord, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.updateTags(provider, fileID, bucketname, projectID, func(current []string) ([]string, error) {
+		updated := append([]string{}, current...)
+		for _, tag := range tags {
+			if !containsString(updated, tag) {
+				updated = append(updated, tag)
+			}
+		}
+		if len(updated) > MaxTags {
+			return nil, fmt.Errorf("%w: a file carries at most %d tags", ErrInvalidTag, MaxTags)
+		}
+		return updated, nil
+	})
+}
+
+// RemoveTags detaches tags from a recorded file, ignoring tags it does not
+// carry, and mirrors the remaining tags to the object like AddTags
+func (f *FileStorageManager) RemoveTags(provider, fileID, bucketname, projectID string, tags []string) (*FileRecord, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.updateTags(provider, fileID, bucketname, projectID, func(current []string) ([]string, error) {
+		updated := []string{}
+		for _, tag := range current {
+			if !containsString(tags, tag) {
+				updated = append(updated, tag)
+			}
+		}
+		return updated, nil
+	})
+}
+
+// FilesByTag returns a page of the recorded files carrying a tag, oldest
+// first. It is a search of the metadata store, see SearchFiles.
+func (f *FileStorageManager) FilesByTag(tag string, offset, limit int) (*SearchResult, error) {
+	return f.SearchFiles(FileQuery{Tag: strings.TrimSpace(tag), Offset: offset, Limit: limit})
+}
+
+// updateTags replaces the tags of a file record by what update makes of
+// them, mirroring them to the object before saving the record
+func (f *FileStorageManager) updateTags(provider, fileID, bucketname, projectID string, update func(current []string) ([]string, error)) (*FileRecord, error) {
+	if f.metadataStore == nil {
+		return nil, ErrTagsNotSupported
+	}
+
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if errors.Is(err, ErrRecordNotFound) || (err == nil && (record.Provider != provider || record.UploadStatus == UploadPending)) {
+		return nil, fmt.Errorf("%w: no record of %s", ErrFileNotFound, fileID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bucketname == "" {
+		bucketname = record.Bucket
+	}
+
+	tags, err := update(record.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	switch provider {
+	case ProviderAWS:
+		if bucketname == "" {
+			bucketname = f.config.AWSBucket
+		}
+		err = f.awsMirrorTags(fileID, bucketname, record.Tags, tags)
+	case ProviderGCS:
+		if bucketname == "" {
+			bucketname = f.config.GCSBucket
+		}
+		err = f.gcsMirrorTags(fileID, bucketname, projectID, tags)
+	}
+	if errors.Is(err, ErrFileNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("filestorage: mirroring the tags of %s to the object failed: %v", fileID, err)
+	}
+
+	record.Tags = tags
+	if err := f.saveRecord(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// normalizeTags trims tags and drops duplicates, rejecting empty tags and
+// tags S3 or GCS could not carry
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || len([]rune(tag)) > MaxTagLength || !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+		}
+		if !containsString(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// awsMirrorTags replaces the object tags of an S3 object that were tags of
+// its record with the new tags, keeping object tags set by others
+func (f *FileStorageManager) awsMirrorTags(awsFileID, bucketname string, previous, tags []string) error {
+	s3Client, err := f.GetAwsClient()
+	if err != nil {
+		return err
+	}
+
+	current, err := s3Client.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucketname),
+		Key:    aws.String(awsFileID),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, awsFileID)
+	}
+	if err != nil {
+		return err
+	}
+
+	tagSet := []*s3.Tag{}
+	for _, tag := range current.TagSet {
+		key := aws.StringValue(tag.Key)
+		if !containsString(previous, key) && !containsString(tags, key) {
+			tagSet = append(tagSet, tag)
+		}
+	}
+	for _, tag := range tags {
+		tagSet = append(tagSet, &s3.Tag{Key: aws.String(tag), Value: aws.String("")})
+	}
+
+	_, err = s3Client.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(bucketname),
+		Key:     aws.String(awsFileID),
+		Tagging: &s3.Tagging{TagSet: tagSet},
+	})
+	return err
+}
+
+// gcsMirrorTags lists tags in the custom metadata of a GCS object. The
+// update is merged into the metadata, keeping keys set by others.
+func (f *FileStorageManager) gcsMirrorTags(gcsFileID, bucketname, projectID string, tags []string) error {
+	ctx := context.Background()
+
+	gcsClient, err := f.GetGcsClient(projectID)
+	if err != nil {
+		return err
+	}
+	defer gcsClient.Close()
+
+	_, err = gcsClient.Bucket(bucketname).Object(gcsFileID).Update(ctx, storage.ObjectAttrsToUpdate{
+		Metadata: map[string]string{gcsTagsMetadata: strings.Join(tags, ",")},
+	})
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, gcsFileID)
+	}
+	return err
+}
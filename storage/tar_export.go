@@ -0,0 +1,310 @@
+// pkg/storage/tar_export.go
+
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"google.golang.org/api/iterator"
+)
+
+// ExportFilter selects the files of a prefix to export. Patterns use path.Match
+// syntax and are matched against the path below the prefix; patterns without a
+// slash also match the base name, so "*.pdf" selects PDFs in every folder.
+type ExportFilter struct {
+	Include []string // Files to export, all files when empty
+	Exclude []string // Files to leave out, applied after Include
+}
+
+// ExportEstimate is the expected content of an export
+type ExportEstimate struct {
+	Prefix string `json:"prefix"`
+	Files  int    `json:"files"`
+	Size   int64  `json:"size"` // Stored size in bytes before compression
+}
+
+// ObjectInfo describes a stored object found by listing a prefix
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ModTime      time.Time
+	StorageClass string
+	Checksum     string // "md5:<hex>", or "etag:<etag>" and "crc32c:<hex>" for multipart S3 and composed GCS objects
+}
+
+// validate rejects malformed patterns before anything is listed or written
+func (e *ExportFilter) validate() error {
+	for _, pattern := range append(append([]string{}, e.Include...), e.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidPattern, pattern)
+		}
+	}
+	return nil
+}
+
+// matches reports whether a path below the export prefix is selected
+func (e *ExportFilter) matches(name string) bool {
+	if len(e.Include) > 0 && !matchPattern(e.Include, name) {
+		return false
+	}
+	return !matchPattern(e.Exclude, name)
+}
+
+// matchPattern reports whether name matches any of the patterns
+func matchPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(name)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EstimateExport returns the number and stored size of the files an export
+// of prefix would contain, without reading them
+func (f *FileStorageManager) EstimateExport(provider, prefix string, filter ExportFilter, bucketname, projectID string) (*ExportEstimate, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+
+	estimate := &ExportEstimate{Prefix: prefix}
+	err := f.walkPrefix(provider, prefix, bucketname, projectID, func(object ObjectInfo) error {
+		if filter.matches(exportName(prefix, object.Key)) {
+			estimate.Files++
+			estimate.Size += object.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return estimate, nil
+}
+
+// WriteTarGz streams a gzip-compressed tar archive of the files below prefix
+// to w, one file at a time. Entries are named by their path below the prefix.
+// Nothing is written before the first file has been opened, so a listing
+// error can still be reported as an error response.
+func (f *FileStorageManager) WriteTarGz(w io.Writer, provider, prefix string, filter ExportFilter, bucketname, projectID string) error {
+	if err := filter.validate(); err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	err := f.walkPrefix(provider, prefix, bucketname, projectID, func(object ObjectInfo) error {
+		name := exportName(prefix, object.Key)
+		if !filter.matches(name) {
+			return nil
+		}
+		if err := f.writeTarEntry(tw, provider, name, object, bucketname, projectID); err != nil {
+			return fmt.Errorf("%s: %w", object.Key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// writeTarEntry copies a single file into the tar archive
+func (f *FileStorageManager) writeTarEntry(tw *tar.Writer, provider, name string, object ObjectInfo, bucketname, projectID string) error {
+	file, err := f.OpenFile(provider, object.Key, bucketname, projectID)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var content io.Reader = file
+	size := file.Size()
+
+	// Tar headers need the size up front, so decoded content of unknown
+	// size is spooled to a temporary file first
+	if size < 0 {
+		spool, err := ioutil.TempFile("", "export-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		if size, err = Copy(spool, file); err != nil {
+			return err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		content = spool
+	}
+
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: object.ModTime,
+		Format:  tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err = Copy(tw, content)
+	return err
+}
+
+// exportName returns the path of a key below the export prefix
+func exportName(prefix, key string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return key
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+}
+
+// walkPrefix calls fn for every object below prefix, skipping folder placeholders
+func (f *FileStorageManager) walkPrefix(provider, prefix, bucketname, projectID string, fn func(object ObjectInfo) error) error {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	switch provider {
+	case ProviderAWS:
+		return f.awsWalkPrefix(prefix, bucketname, fn)
+	case ProviderGCS:
+		return f.gcsWalkPrefix(prefix, bucketname, projectID, fn)
+	}
+
+	return fmt.Errorf("unknown provider %q", provider)
+}
+
+// awsWalkPrefix lists an S3 prefix page by page
+func (f *FileStorageManager) awsWalkPrefix(prefix, bucketname string, fn func(object ObjectInfo) error) error {
+	// Use default bucket if not specified
+	if bucketname == "" {
+		bucketname = f.config.AWSBucket
+	}
+
+	s3Client, err := f.GetAwsClient()
+	if err != nil {
+		return err
+	}
+
+	var walkErr error
+	err = s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketname),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, item := range page.Contents {
+			key := aws.StringValue(item.Key)
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			walkErr = fn(ObjectInfo{
+				Key:          key,
+				Size:         aws.Int64Value(item.Size),
+				ModTime:      aws.TimeValue(item.LastModified),
+				StorageClass: aws.StringValue(item.StorageClass),
+				Checksum:     awsChecksum(aws.StringValue(item.ETag)),
+			})
+			if walkErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+
+	return err
+}
+
+// gcsWalkPrefix lists a GCS prefix
+func (f *FileStorageManager) gcsWalkPrefix(prefix, bucketname, projectID string, fn func(object ObjectInfo) error) error {
+	ctx := context.Background()
+
+	// Use default bucket if not specified
+	if bucketname == "" {
+		bucketname = f.config.GCSBucket
+	}
+
+	gcsClient, err := f.GetGcsClient(projectID)
+	if err != nil {
+		return err
+	}
+	defer gcsClient.Close()
+
+	it := gcsClient.Bucket(bucketname).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(attrs.Name, "/") {
+			continue
+		}
+
+		if err := fn(ObjectInfo{
+			Key:          attrs.Name,
+			Size:         attrs.Size,
+			ContentType:  attrs.ContentType,
+			ModTime:      attrs.Updated,
+			StorageClass: attrs.StorageClass,
+			Checksum:     gcsChecksum(attrs),
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// awsChecksum returns the checksum of an S3 object by its ETag, which is
+// the MD5 of the content unless the object was uploaded in parts
+func awsChecksum(etag string) string {
+	etag = strings.Trim(etag, `"`)
+	if etag == "" {
+		return ""
+	}
+	if strings.Contains(etag, "-") {
+		return "etag:" + etag
+	}
+	return "md5:" + etag
+}
+
+// gcsChecksum returns the checksum of a GCS object, the CRC32C for composed
+// objects, which have no MD5
+func gcsChecksum(attrs *storage.ObjectAttrs) string {
+	if len(attrs.MD5) > 0 {
+		return "md5:" + hex.EncodeToString(attrs.MD5)
+	}
+	return fmt.Sprintf("crc32c:%08x", attrs.CRC32C)
+}
@@ -0,0 +1,252 @@
+// pkg/storage/tenant_usage.go
+
+package storage
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TenantReportPrefix is the key prefix monthly tenant reports are exported below
+	TenantReportPrefix = ".reports/tenants/"
+
+	// tenantUsageInterval is how often the tenant report of the current month is aggregated
+	tenantUsageInterval = time.Hour
+
+	// monthFormat is the layout of the months tenant reports cover, e.g. "2024-08"
+	monthFormat = "2006-01"
+)
+
+// TenantUsage is the storage used and the requests made for the files of a
+// tenant, such as a faculty, in a month
+type TenantUsage struct {
+	Tenant   string `json:"tenant"`
+	Files    int64  `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	Requests int64  `json:"requests"`
+}
+
+// TenantReport is the consumption of every tenant in a month, for charging
+// storage costs back to units. Tenants are the first segment of file IDs,
+// "" for files without a prefix, as in UsageStats.
+type TenantReport struct {
+	Month      string        `json:"month"` // e.g. "2024-08"
+	Tenants    []TenantUsage `json:"tenants"`
+	Final      bool          `json:"final"` // Set once the month is over
+	ComputedAt time.Time     `json:"computed_at"`
+}
+
+// tenantCounters counts the requests per tenant of the months that are not
+// reported yet and keeps the reports of the months since the service started
+type tenantCounters struct {
+	mu       sync.Mutex
+	requests map[string]map[string]int64 // By month, then tenant
+	reports  map[string]*TenantReport    // By month
+}
+
+// TenantOf returns the tenant a file belongs to, the first segment of its
+// ID, or "" when the ID has no prefix. Files in the trash or the archive,
+// and previous versions, belong to the tenant of the file they were.
+func TenantOf(fileID string) string {
+	for _, prefix := range []string{TrashPrefix, ArchivePrefix, VersionPrefix} {
+		fileID = strings.TrimPrefix(fileID, prefix)
+	}
+	tenant, _, found := strings.Cut(fileID, "/")
+	if !found {
+		return ""
+	}
+	return tenant
+}
+
+// CountTenantRequest counts a request for a file, or below a prefix ending
+// in "/", towards the tenant it belongs to. Counts are kept in memory, so
+// requests served by other instances or before a restart are not counted.
+func (f *FileStorageManager) CountTenantRequest(fileID string) {
+	month := time.Now().UTC().Format(monthFormat)
+	tenant := TenantOf(fileID)
+
+	f.tenantUsage.mu.Lock()
+	defer f.tenantUsage.mu.Unlock()
+
+	if f.tenantUsage.requests[month] == nil {
+		f.tenantUsage.requests[month] = map[string]int64{}
+	}
+	f.tenantUsage.requests[month][tenant]++
+}
+
+// TenantReport returns the storage used and the requests made per tenant in
+// a month, the current one when month is "". The report of the current month
+// is aggregated from the metadata store every hour; reports of past months
+// are final and kept since the service started.
+func (f *FileStorageManager) TenantReport(month string) (*TenantReport, error) {
+	current := time.Now().UTC().Format(monthFormat)
+	if month == "" {
+		month = current
+	}
+	if _, err := time.Parse(monthFormat, month); err != nil {
+		return nil, fmt.Errorf("%w: month %q is not YYYY-MM", ErrInvalidMonth, month)
+	}
+
+	f.tenantUsage.mu.Lock()
+	report := f.tenantUsage.reports[month]
+	f.tenantUsage.mu.Unlock()
+
+	if report != nil && (report.Final || time.Since(report.ComputedAt) < UsageStatsTTL) {
+		return report, nil
+	}
+	if month != current {
+		return nil, fmt.Errorf("%w: no tenant report for %s", ErrReportNotFound, month)
+	}
+
+	return f.aggregateTenantUsage(month, false)
+}
+
+// aggregateTenantUsage computes the report of a month from the metadata
+// store and the requests counted, keeping it for TenantReport. A final
+// report stops counting the requests of the month.
+func (f *FileStorageManager) aggregateTenantUsage(month string, final bool) (*TenantReport, error) {
+	lister, ok := f.metadataStore.(FileLister)
+	if !ok {
+		return nil, ErrStatsNotSupported
+	}
+
+	records, err := lister.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := map[string]*TenantUsage{}
+	tenant := func(name string) *TenantUsage {
+		if tenants[name] == nil {
+			tenants[name] = &TenantUsage{Tenant: name}
+		}
+		return tenants[name]
+	}
+
+	for _, record := range records {
+		if record.UploadStatus == UploadPending {
+			continue
+		}
+		usage := tenant(TenantOf(record.FileID))
+		usage.Files++
+		usage.Bytes += record.FileSize
+	}
+
+	f.tenantUsage.mu.Lock()
+	defer f.tenantUsage.mu.Unlock()
+
+	for name, requests := range f.tenantUsage.requests[month] {
+		tenant(name).Requests = requests
+	}
+
+	report := &TenantReport{Month: month, Tenants: make([]TenantUsage, 0, len(tenants)), Final: final, ComputedAt: time.Now()}
+	for _, usage := range tenants {
+		report.Tenants = append(report.Tenants, *usage)
+	}
+	sort.Slice(report.Tenants, func(i, j int) bool {
+		if report.Tenants[i].Bytes != report.Tenants[j].Bytes {
+			return report.Tenants[i].Bytes > report.Tenants[j].Bytes
+		}
+		return report.Tenants[i].Tenant < report.Tenants[j].Tenant
+	})
+
+	f.tenantUsage.reports[month] = report
+	if final {
+		delete(f.tenantUsage.requests, month)
+	}
+	return report, nil
+}
+
+// WriteTenantReport writes a report to w as CSV with a header row
+func WriteTenantReport(w io.Writer, report *TenantReport) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"month", "tenant", "files", "bytes", "requests"})
+	for _, usage := range report.Tenants {
+		cw.Write([]string{
+			report.Month,
+			usage.Tenant,
+			strconv.FormatInt(usage.Files, 10),
+			strconv.FormatInt(usage.Bytes, 10),
+			strconv.FormatInt(usage.Requests, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportTenantReport stores a report as CSV in the configured bucket of a
+// provider below TenantReportPrefix, e.g. ".reports/tenants/2024-08.csv",
+// and returns its key
+func (f *FileStorageManager) ExportTenantReport(provider string, report *TenantReport) (string, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := WriteTenantReport(buf, report); err != nil {
+		return "", err
+	}
+
+	data := buf.Bytes()
+	if f.encryptor != nil {
+		var err error
+		if data, err = f.encryptor.Encrypt(data); err != nil {
+			return "", err
+		}
+	}
+
+	key := TenantReportPrefix + report.Month + ".csv"
+	bucketname := f.defaultBucket(provider)
+	switch provider {
+	case ProviderAWS:
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return "", err
+		}
+		return key, putAwsObject(s3Client, bucketname, key, "text/csv", data)
+
+	case ProviderGCS:
+		gcsClient, err := f.GetGcsClient("")
+		if err != nil {
+			return "", err
+		}
+		defer gcsClient.Close()
+		return key, putGcsObject(context.Background(), gcsClient.Bucket(bucketname), key, "text/csv", data)
+	}
+
+	return "", fmt.Errorf("unknown provider %q", provider)
+}
+
+// tenantUsageJob returns the scheduled job aggregating the tenant report of
+// the current month. Once a month is over its report is made final and,
+// when a provider is configured, exported.
+func (f *FileStorageManager) tenantUsageJob(exportProvider string) func() error {
+	month := time.Now().UTC().Format(monthFormat)
+	return func() error {
+		if _, ok := f.metadataStore.(FileLister); !ok {
+			return nil
+		}
+
+		if current := time.Now().UTC().Format(monthFormat); current != month {
+			report, err := f.aggregateTenantUsage(month, true)
+			if err != nil {
+				log.Printf("filestorage: reporting the tenant usage of %s failed: %v", month, err)
+			} else if exportProvider != "" {
+				if _, err := f.ExportTenantReport(exportProvider, report); err != nil {
+					log.Printf("filestorage: exporting the tenant report of %s failed: %v", month, err)
+				}
+			}
+			month = current
+		}
+
+		_, err := f.aggregateTenantUsage(month, false)
+		return err
+	}
+}
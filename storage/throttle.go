@@ -0,0 +1,151 @@
+// pkg/storage/throttle.go
+
+package storage
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// minThrottleBurst is the smallest token bucket size, so that very low limits
+// still let reads make progress in reasonably sized chunks
+const minThrottleBurst = 4 * 1024
+
+// Throttle limits transfer bandwidth with token buckets: one shared by all
+// transfers and one per transfer. Limits are in bytes per second, 0 means
+// unlimited, and can be changed while transfers are running.
+type Throttle struct {
+	global        *rate.Limiter
+	transferLimit atomic.Int64
+}
+
+// NewThrottle creates a throttle with the given global and per-transfer limits
+func NewThrottle(globalLimit, transferLimit int64) *Throttle {
+	t := &Throttle{
+		global: rate.NewLimiter(rate.Inf, minThrottleBurst),
+	}
+	t.SetLimits(globalLimit, transferLimit)
+	return t
+}
+
+// NewThrottleFromConfig creates the configured throttle, returning nil when no
+// bandwidth limit is configured
+func NewThrottleFromConfig(config *Config) *Throttle {
+	if config.BandwidthLimit <= 0 && config.TransferBandwidthLimit <= 0 {
+		return nil
+	}
+	return NewThrottle(config.BandwidthLimit, config.TransferBandwidthLimit)
+}
+
+// SetLimits changes the global and per-transfer limits, e.g. to slow down bulk
+// migrations during business hours. Running transfers pick up the global limit
+// immediately and the per-transfer limit on their next transfer.
+func (t *Throttle) SetLimits(globalLimit, transferLimit int64) {
+	t.global.SetLimit(bandwidthLimit(globalLimit))
+	t.global.SetBurst(throttleBurst(globalLimit))
+	t.transferLimit.Store(transferLimit)
+}
+
+// Reader returns r limited by the throttle. A nil throttle returns r unchanged.
+func (t *Throttle) Reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return t.newReader(r)
+}
+
+// ReadSeeker returns r limited by the throttle, e.g. for S3 request bodies
+// which must be seekable. A nil throttle returns r unchanged.
+func (t *Throttle) ReadSeeker(r io.ReadSeeker) io.ReadSeeker {
+	if t == nil {
+		return r
+	}
+	return t.newReader(r)
+}
+
+// newReader creates a throttled reader with its own per-transfer bucket
+func (t *Throttle) newReader(r io.Reader) *throttledReader {
+	transferLimit := t.transferLimit.Load()
+
+	return &throttledReader{
+		source:   r,
+		global:   t.global,
+		transfer: rate.NewLimiter(bandwidthLimit(transferLimit), throttleBurst(transferLimit)),
+	}
+}
+
+// bandwidthLimit converts bytes per second into a rate limit, 0 meaning unlimited
+func bandwidthLimit(bytesPerSecond int64) rate.Limit {
+	if bytesPerSecond <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(bytesPerSecond)
+}
+
+// throttleBurst returns the bucket size for a limit, allowing one second of transfer
+func throttleBurst(bytesPerSecond int64) int {
+	if bytesPerSecond < minThrottleBurst {
+		return minThrottleBurst
+	}
+	return int(bytesPerSecond)
+}
+
+// throttledReader waits for tokens from both buckets after every read
+type throttledReader struct {
+	source   io.Reader
+	global   *rate.Limiter
+	transfer *rate.Limiter
+}
+
+// Read implements io.Reader
+func (r *throttledReader) Read(p []byte) (int, error) {
+	// Never ask for more tokens than either bucket can hold
+	if burst := r.global.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	if burst := r.transfer.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := r.source.Read(p)
+	if n > 0 {
+		if waitErr := r.transfer.WaitN(context.Background(), n); waitErr != nil {
+			return n, waitErr
+		}
+		if waitErr := r.global.WaitN(context.Background(), n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+// Seek implements io.Seeker when the source supports it
+func (r *throttledReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := r.source.(io.Seeker)
+	if !ok {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return seeker.Seek(offset, whence)
+}
+
+// Close closes the source when it is an io.Closer
+func (r *throttledReader) Close() error {
+	if closer, ok := r.source.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// SetThrottle sets the bandwidth throttle applied to transfers, nil disables throttling
+func (f *FileStorageManager) SetThrottle(throttle *Throttle) {
+	f.throttle = throttle
+}
+
+// Throttle returns the bandwidth throttle, if any, e.g. to adjust its limits
+func (f *FileStorageManager) Throttle() *Throttle {
+	return f.throttle
+}
@@ -0,0 +1,310 @@
+// pkg/storage/thumbnail.go
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// thumbnailPrefix is the key prefix under which thumbnails are stored
+	thumbnailPrefix = "thumbs/"
+
+	// maxThumbnailSourcePixels bounds the images thumbnails are generated from on request
+	maxThumbnailSourcePixels = 50_000_000
+)
+
+// DefaultServedThumbnailSizes are the sizes OpenThumbnail generates when no
+// thumbnail generator is configured
+var DefaultServedThumbnailSizes = []int{100, 200, 400}
+
+// Thumbnail describes a generated thumbnail of an uploaded image
+type Thumbnail struct {
+	Size       int    `json:"size"`
+	FileID     string `json:"file_id"`
+	PublicLink string `json:"public_link"`
+}
+
+// ThumbnailGenerator renders JPEG thumbnails of uploaded images
+type ThumbnailGenerator struct {
+	Sizes   []int // Longest side of each generated thumbnail in pixels
+	Quality int   // JPEG quality
+}
+
+// renderedThumbnail is a thumbnail encoded for storage but not yet uploaded
+type renderedThumbnail struct {
+	size int
+	data []byte
+}
+
+// NewThumbnailGenerator creates a thumbnail generator for the given sizes
+func NewThumbnailGenerator(sizes []int) *ThumbnailGenerator {
+	return &ThumbnailGenerator{
+		Sizes:   sizes,
+		Quality: DefaultJPEGQuality,
+	}
+}
+
+// Generate renders a thumbnail whose longest side is at most size pixels
+func (g *ThumbnailGenerator) Generate(img image.Image, size int) ([]byte, error) {
+	return encodeJPEG(fitImage(img, size, size), g.Quality)
+}
+
+// ThumbnailKey returns the object key of a thumbnail, e.g. "thumbs/<id>_200.jpg"
+func ThumbnailKey(fileID string, size int) string {
+	return fmt.Sprintf("%s%s_%d.jpg", thumbnailPrefix, strings.TrimSuffix(fileID, path.Ext(fileID)), size)
+}
+
+// SetThumbnailGenerator enables thumbnail generation for S3 and GCS image uploads, nil disables it
+func (f *FileStorageManager) SetThumbnailGenerator(generator *ThumbnailGenerator) {
+	f.thumbnails = generator
+}
+
+// renderThumbnails generates the configured thumbnails of an image payload.
+// Images that cannot be decoded simply get no thumbnails.
+func (f *FileStorageManager) renderThumbnails(payload *uploadPayload) {
+	if f.thumbnails == nil || len(f.thumbnails.Sizes) == 0 || !isImageType(payload.mimeType) {
+		return
+	}
+
+	img, _, err := decodeImage(payload.data)
+	if err != nil {
+		return
+	}
+
+	for _, size := range f.thumbnails.Sizes {
+		data, err := f.thumbnails.Generate(img, size)
+		if err != nil {
+			continue
+		}
+
+		// Thumbnails get the same protection as the original
+		if f.encryptor != nil {
+			if data, err = f.encryptor.Encrypt(data); err != nil {
+				continue
+			}
+		}
+
+		payload.thumbnails = append(payload.thumbnails, renderedThumbnail{size: size, data: data})
+	}
+}
+
+// storeAwsThumbnails uploads the rendered thumbnails of a payload next to its S3 object
+func (f *FileStorageManager) storeAwsThumbnails(s3Client *s3.S3, bucketname, fileID string, payload *uploadPayload) []Thumbnail {
+	var thumbnails []Thumbnail
+
+	for _, thumb := range payload.thumbnails {
+		key := ThumbnailKey(fileID, thumb.size)
+		if err := putAwsObject(s3Client, bucketname, key, "image/jpeg", thumb.data); err != nil {
+			continue
+		}
+
+		thumbnails = append(thumbnails, Thumbnail{
+			Size:       thumb.size,
+			FileID:     key,
+			PublicLink: f.awsPublicURL(bucketname, key),
+		})
+	}
+
+	return thumbnails
+}
+
+// storeGcsThumbnails uploads the rendered thumbnails of a payload next to its GCS object
+func (f *FileStorageManager) storeGcsThumbnails(ctx context.Context, bucket *storage.BucketHandle, bucketname, fileID string, payload *uploadPayload) []Thumbnail {
+	var thumbnails []Thumbnail
+
+	for _, thumb := range payload.thumbnails {
+		key := ThumbnailKey(fileID, thumb.size)
+		if err := putGcsObject(ctx, bucket, key, "image/jpeg", thumb.data); err != nil {
+			continue
+		}
+
+		thumbnails = append(thumbnails, Thumbnail{
+			Size:       thumb.size,
+			FileID:     key,
+			PublicLink: gcsPublicURL(bucketname, key),
+		})
+	}
+
+	return thumbnails
+}
+
+// deleteAwsThumbnails removes the thumbnails of an S3 object, ignoring missing ones
+func (f *FileStorageManager) deleteAwsThumbnails(s3Client *s3.S3, bucketname, fileID string) {
+	if f.thumbnails == nil {
+		return
+	}
+
+	for _, size := range f.thumbnails.Sizes {
+		s3Client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(bucketname),
+			Key:    aws.String(ThumbnailKey(fileID, size)),
+		})
+	}
+}
+
+// deleteGcsThumbnails removes the thumbnails of a GCS object, ignoring missing ones
+func (f *FileStorageManager) deleteGcsThumbnails(ctx context.Context, bucket *storage.BucketHandle, fileID string) {
+	if f.thumbnails == nil {
+		return
+	}
+
+	for _, size := range f.thumbnails.Sizes {
+		bucket.Object(ThumbnailKey(fileID, size)).Delete(ctx)
+	}
+}
+
+// putAwsObject stores a derived object such as a thumbnail in an S3 bucket
+func putAwsObject(s3Client *s3.S3, bucketname, key, contentType string, data []byte) error {
+	_, err := s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:        aws.String(bucketname),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentType:   aws.String(contentType),
+	})
+	return err
+}
+
+// putGcsObject stores a derived object such as a thumbnail in a GCS bucket
+func putGcsObject(ctx context.Context, bucket *storage.BucketHandle, key, contentType string, data []byte) error {
+	wc := bucket.Object(key).NewWriter(ctx)
+	wc.ContentType = contentType
+	if _, err := wc.Write(data); err != nil {
+		wc.Close()
+		return err
+	}
+	return wc.Close()
+}
+
+// OpenThumbnail opens the thumbnail of an image stored with an S3 or GCS
+// provider, generating and storing it first if it does not exist yet. Only the
+// configured thumbnail sizes, or DefaultServedThumbnailSizes, can be requested.
+func (f *FileStorageManager) OpenThumbnail(provider, fileID, bucketname, projectID string, size int) (*ObjectFile, error) {
+	sizes := f.servedThumbnailSizes()
+	if !containsSize(sizes, size) {
+		return nil, fmt.Errorf("%w: %d, available sizes are %v", ErrInvalidThumbnailSize, size, sizes)
+	}
+
+	key := ThumbnailKey(fileID, size)
+	thumb, err := f.OpenFile(provider, key, bucketname, projectID)
+	if !errors.Is(err, ErrFileNotFound) {
+		return thumb, err
+	}
+
+	if _, err := f.generateThumbnails(provider, fileID, bucketname, projectID, []int{size}); err != nil {
+		return nil, err
+	}
+
+	return f.OpenFile(provider, key, bucketname, projectID)
+}
+
+// RegenerateThumbnails renders the thumbnails of a stored image again in
+// every served size, replacing the stored ones, e.g. after the thumbnail
+// quality was changed
+func (f *FileStorageManager) RegenerateThumbnails(provider, fileID, bucketname, projectID string) ([]Thumbnail, error) {
+	return f.generateThumbnails(provider, fileID, bucketname, projectID, f.servedThumbnailSizes())
+}
+
+// servedThumbnailSizes returns the configured thumbnail sizes, or
+// DefaultServedThumbnailSizes
+func (f *FileStorageManager) servedThumbnailSizes() []int {
+	if f.thumbnails != nil && len(f.thumbnails.Sizes) > 0 {
+		return f.thumbnails.Sizes
+	}
+	return DefaultServedThumbnailSizes
+}
+
+// generateThumbnails renders thumbnails of a stored image and stores them
+// next to the original, recording them in the metadata store in place of
+// thumbnails of the same size
+func (f *FileStorageManager) generateThumbnails(provider, fileID, bucketname, projectID string, sizes []int) ([]Thumbnail, error) {
+	original, err := f.OpenFile(provider, fileID, bucketname, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer original.Close()
+
+	if !isImageType(original.ContentType()) {
+		return nil, fmt.Errorf("%w: %s is %s", ErrInvalidImage, fileID, original.ContentType())
+	}
+
+	data, err := readAll(original, original.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse to decode images that would take excessive memory
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxThumbnailSourcePixels {
+		return nil, fmt.Errorf("%w: %dx%d is too large", ErrInvalidImage, config.Width, config.Height)
+	}
+
+	img, _, err := decodeImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	generator := f.thumbnails
+	if generator == nil {
+		generator = NewThumbnailGenerator(DefaultServedThumbnailSizes)
+	}
+
+	// Use default bucket if not specified
+	if bucketname == "" {
+		bucketname = f.defaultBucket(provider)
+	}
+
+	thumbnails := make([]Thumbnail, 0, len(sizes))
+	for _, size := range sizes {
+		thumbData, err := generator.Generate(img, size)
+		if err != nil {
+			return nil, err
+		}
+
+		key := ThumbnailKey(fileID, size)
+		publicLink, err := f.StoreDerivedObject(&UploadEvent{Provider: provider, Bucket: bucketname, ProjectID: projectID}, key, "image/jpeg", thumbData)
+		if err != nil {
+			return nil, err
+		}
+		thumbnails = append(thumbnails, Thumbnail{Size: size, FileID: key, PublicLink: publicLink})
+	}
+
+	if f.metadataStore != nil {
+		if record, err := f.metadataStore.GetFile(fileID); err == nil {
+			kept := record.Thumbnails[:0]
+			for _, thumb := range record.Thumbnails {
+				if !containsSize(sizes, thumb.Size) {
+					kept = append(kept, thumb)
+				}
+			}
+			record.Thumbnails = append(kept, thumbnails...)
+			f.saveRecord(record)
+		}
+	}
+
+	return thumbnails, nil
+}
+
+// containsSize reports whether size is one of sizes
+func containsSize(sizes []int, size int) bool {
+	for _, s := range sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+// pkg/storage/transcode.go
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// renditionPrefix is the key prefix under which renditions are stored
+const renditionPrefix = "renditions/"
+
+// VideoTranscodeHook is a post-upload hook producing web friendly renditions
+// of uploaded videos with ffmpeg: an H.264/AAC MP4 and optionally an HLS stream
+type VideoTranscodeHook struct {
+	FFmpegPath string
+	HLS        bool          // Also produce an HLS playlist with 6 second segments
+	Timeout    time.Duration // Upper bound for transcoding a single upload
+}
+
+// NewVideoTranscodeHook creates a transcode hook using the ffmpeg found in PATH
+func NewVideoTranscodeHook(hls bool) *VideoTranscodeHook {
+	ffmpeg, _ := exec.LookPath("ffmpeg")
+
+	return &VideoTranscodeHook{
+		FFmpegPath: ffmpeg,
+		HLS:        hls,
+		Timeout:    time.Hour,
+	}
+}
+
+// RenditionKey returns the object key of a rendition file, e.g. "renditions/<id>/video.mp4"
+func RenditionKey(fileID, name string) string {
+	return renditionPrefix + strings.TrimSuffix(fileID, path.Ext(fileID)) + "/" + name
+}
+
+// Handles reports whether the upload is a video and ffmpeg is available
+func (h *VideoTranscodeHook) Handles(event *UploadEvent) bool {
+	return h.FFmpegPath != "" && strings.HasPrefix(normalizeMimeType(event.Info.FileMimeType), "video/")
+}
+
+// Run transcodes the upload, stores the renditions next to it and records their links
+func (h *VideoTranscodeHook) Run(ctx context.Context, f *FileStorageManager, event *UploadEvent) error {
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	dir, err := os.MkdirTemp("", "filestorage-transcode-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "source")
+	if err := writeUploadTo(f, event, input); err != nil {
+		return err
+	}
+
+	mp4 := filepath.Join(dir, "video.mp4")
+	if err := h.ffmpeg(ctx, "-i", input,
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "128k", "-movflags", "+faststart", mp4,
+	); err != nil {
+		return err
+	}
+
+	var renditions []Rendition
+
+	link, err := storeDerivedFile(f, event, RenditionKey(event.Info.FileID, "video.mp4"), "video/mp4", mp4)
+	if err != nil {
+		return err
+	}
+	renditions = append(renditions, Rendition{
+		Format:     "mp4",
+		FileID:     RenditionKey(event.Info.FileID, "video.mp4"),
+		PublicLink: link,
+	})
+
+	if h.HLS {
+		rendition, err := h.storeHLS(ctx, f, event, dir, mp4)
+		if err != nil {
+			return err
+		}
+		renditions = append(renditions, *rendition)
+	}
+
+	return f.AddRenditions(event.Info.FileID, renditions...)
+}
+
+// storeHLS segments the transcoded MP4 into an HLS stream and stores the
+// segments and playlist, returning the playlist rendition
+func (h *VideoTranscodeHook) storeHLS(ctx context.Context, f *FileStorageManager, event *UploadEvent, dir, mp4 string) (*Rendition, error) {
+	hlsDir := filepath.Join(dir, "hls")
+	if err := os.Mkdir(hlsDir, 0700); err != nil {
+		return nil, err
+	}
+
+	if err := h.ffmpeg(ctx, "-i", mp4, "-c", "copy",
+		"-hls_time", "6", "-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(hlsDir, "segment_%04d.ts"),
+		filepath.Join(hlsDir, "index.m3u8"),
+	); err != nil {
+		return nil, err
+	}
+
+	segments, err := filepath.Glob(filepath.Join(hlsDir, "segment_*.ts"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(segments)
+
+	// Segments first, so the playlist never references missing objects
+	for _, segment := range segments {
+		key := RenditionKey(event.Info.FileID, "hls/"+filepath.Base(segment))
+		if _, err := storeDerivedFile(f, event, key, "video/mp2t", segment); err != nil {
+			return nil, err
+		}
+	}
+
+	key := RenditionKey(event.Info.FileID, "hls/index.m3u8")
+	link, err := storeDerivedFile(f, event, key, "application/vnd.apple.mpegurl", filepath.Join(hlsDir, "index.m3u8"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rendition{
+		Format:     "hls",
+		FileID:     key,
+		PublicLink: link,
+	}, nil
+}
+
+// ffmpeg runs ffmpeg with the given arguments
+func (h *VideoTranscodeHook) ffmpeg(ctx context.Context, args ...string) error {
+	args = append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)
+	out, err := exec.CommandContext(ctx, h.FFmpegPath, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, out)
+	}
+	return nil
+}
+
+// storeDerivedFile stores a local file as a derived object of an upload
+func storeDerivedFile(f *FileStorageManager, event *UploadEvent, key, contentType, filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return f.StoreDerivedObject(event, key, contentType, data)
+}
+
+// writeUploadTo writes the content of an upload to a local file
+func writeUploadTo(f *FileStorageManager, event *UploadEvent, filename string) error {
+	src, err := event.Open(f)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,304 @@
+// pkg/storage/transport_metrics.go
+
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// transportStats accumulates the connection statistics of the HTTP
+// transports of a client
+type transportStats struct {
+	dials      map[string]uint64 // By result, "ok" or "error"
+	requests   map[string]uint64 // By connection, "new", "idle" or "reused"
+	open       int64             // Connections dialed and not closed yet
+	idle       int64             // Open HTTP/1 connections waiting in the pool
+	handshakes []uint64          // TLS handshakes per latency bucket, not cumulative
+	tlsSum     float64           // Seconds spent in TLS handshakes
+	tlsCount   uint64            // Successful TLS handshakes
+	tlsErrors  uint64            // Failed TLS handshakes
+	hedges     map[string]uint64 // Hedged reads by the request that won, "first" or "hedge"
+}
+
+// transportStats returns the statistics of a client, with the metrics locked
+func (m *Metrics) transportStats(client string) *transportStats {
+	stats := m.transports[client]
+	if stats == nil {
+		stats = &transportStats{
+			dials:      make(map[string]uint64),
+			requests:   make(map[string]uint64),
+			hedges:     make(map[string]uint64),
+			handshakes: make([]uint64, len(m.buckets)+1),
+		}
+		m.transports[client] = stats
+	}
+	return stats
+}
+
+// meteredTransport counts the connections and requests of an HTTP transport
+type meteredTransport struct {
+	client  string
+	base    *http.Transport
+	metrics *Metrics
+}
+
+// meteredConn is a connection dialed by a metered transport
+type meteredConn struct {
+	net.Conn
+	transport *meteredTransport
+	idle      atomic.Bool
+	closed    sync.Once
+}
+
+// newTransport returns a clone of http.DefaultTransport whose connections,
+// TLS handshakes and connection reuse are counted under the client label,
+// e.g. a provider
+func (m *Metrics) newTransport(client string) *meteredTransport {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.MaxIdleConnsPerHost = 100
+
+	t := &meteredTransport{client: client, base: base, metrics: m}
+	dial := base.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	base.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		m.observeDial(client, err)
+		if err != nil {
+			return nil, err
+		}
+		return &meteredConn{Conn: conn, transport: t}, nil
+	}
+	return t
+}
+
+// transport returns a new HTTP transport for the requests to a provider,
+// counted in the metrics
+func (f *FileStorageManager) transport(provider string) http.RoundTripper {
+	return f.wrapTransport(provider, f.metrics.newTransport(provider))
+}
+
+// wrapTransport hedges the reads of the metered transport of a provider,
+// with faults injected below the hedging so hedges can be tried out with
+// the faultinject tag
+func (f *FileStorageManager) wrapTransport(provider string, rt *meteredTransport) http.RoundTripper {
+	return &hedgeTransport{provider: provider, base: f.decorateTransport(provider, rt), manager: f}
+}
+
+// RoundTrip implements http.RoundTripper, tracing the connection a request is sent on
+func (t *meteredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		conn           *meteredConn
+		handshakeStart time.Time
+	)
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { handshakeStart = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.metrics.observeHandshake(t.client, time.Since(handshakeStart), err)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.metrics.observeConnUse(t.client, info.Reused, info.WasIdle)
+			if conn = unwrapMeteredConn(info.Conn); conn != nil {
+				conn.setIdle(false)
+			}
+		},
+		PutIdleConn: func(err error) {
+			if err == nil && conn != nil {
+				conn.setIdle(true)
+			}
+		},
+	}
+
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// CloseIdleConnections closes the idle connections of the transport
+func (t *meteredTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
+
+// setIdle records a connection going into or out of the idle pool
+func (c *meteredConn) setIdle(idle bool) {
+	if c.idle.Swap(idle) != idle {
+		c.transport.metrics.observeIdle(c.transport.client, idle)
+	}
+}
+
+// Close implements net.Conn
+func (c *meteredConn) Close() error {
+	c.closed.Do(func() {
+		c.transport.metrics.observeConnClosed(c.transport.client, c.idle.Load())
+	})
+	return c.Conn.Close()
+}
+
+// unwrapMeteredConn returns the metered connection below the TLS connection
+// a request is sent on, nil if the connection is not metered
+func unwrapMeteredConn(conn net.Conn) *meteredConn {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	metered, _ := conn.(*meteredConn)
+	return metered
+}
+
+// observeDial counts a connection dialed by a transport of a client
+func (m *Metrics) observeDial(client string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.transportStats(client)
+	if err != nil {
+		stats.dials["error"]++
+		return
+	}
+	stats.dials["ok"]++
+	stats.open++
+}
+
+// observeConnClosed counts a connection of a client being closed
+func (m *Metrics) observeConnClosed(client string, idle bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.transportStats(client)
+	stats.open--
+	if idle {
+		stats.idle--
+	}
+}
+
+// observeIdle counts a connection of a client going into or out of the idle pool
+func (m *Metrics) observeIdle(client string, idle bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if idle {
+		m.transportStats(client).idle++
+	} else {
+		m.transportStats(client).idle--
+	}
+}
+
+// observeConnUse counts a request of a client by the connection it got
+func (m *Metrics) observeConnUse(client string, reused, wasIdle bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	connection := "new"
+	switch {
+	case reused && wasIdle:
+		connection = "idle"
+	case reused:
+		connection = "reused"
+	}
+	m.transportStats(client).requests[connection]++
+}
+
+// observeHandshake records the latency of a TLS handshake of a client
+func (m *Metrics) observeHandshake(client string, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.transportStats(client)
+	if err != nil {
+		stats.tlsErrors++
+		return
+	}
+	stats.handshakes[sort.SearchFloat64s(m.buckets, elapsed.Seconds())]++
+	stats.tlsSum += elapsed.Seconds()
+	stats.tlsCount++
+}
+
+// observeHedge counts a hedged read of a client by the request that won
+func (m *Metrics) observeHedge(client string, hedgeWon bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	winner := "first"
+	if hedgeWon {
+		winner = "hedge"
+	}
+	m.transportStats(client).hedges[winner]++
+}
+
+// writeTransports writes the connection statistics of the clients, with the
+// metrics locked
+func (m *Metrics) writeTransports(w io.Writer) {
+	clients := make([]string, 0, len(m.transports))
+	for client := range m.transports {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+
+	fmt.Fprintln(w, "# HELP filestorage_transport_dials_total Connections dialed by the HTTP clients of the backends by result.")
+	fmt.Fprintln(w, "# TYPE filestorage_transport_dials_total counter")
+	for _, client := range clients {
+		dials := m.transports[client].dials
+		for _, result := range sortedKeys(dials) {
+			fmt.Fprintf(w, "filestorage_transport_dials_total{client=%q,result=%q} %d\n", client, result, dials[result])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP filestorage_transport_open_connections Open connections of the HTTP clients of the backends.")
+	fmt.Fprintln(w, "# TYPE filestorage_transport_open_connections gauge")
+	for _, client := range clients {
+		fmt.Fprintf(w, "filestorage_transport_open_connections{client=%q} %d\n", client, m.transports[client].open)
+	}
+
+	fmt.Fprintln(w, "# HELP filestorage_transport_idle_connections Open HTTP/1 connections waiting in the idle pool.")
+	fmt.Fprintln(w, "# TYPE filestorage_transport_idle_connections gauge")
+	for _, client := range clients {
+		fmt.Fprintf(w, "filestorage_transport_idle_connections{client=%q} %d\n", client, m.transports[client].idle)
+	}
+
+	fmt.Fprintln(w, "# HELP filestorage_transport_requests_total Requests of the HTTP clients of the backends by the connection they were sent on: new, idle or reused.")
+	fmt.Fprintln(w, "# TYPE filestorage_transport_requests_total counter")
+	for _, client := range clients {
+		requests := m.transports[client].requests
+		for _, connection := range sortedKeys(requests) {
+			fmt.Fprintf(w, "filestorage_transport_requests_total{client=%q,connection=%q} %d\n", client, connection, requests[connection])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP filestorage_transport_tls_handshake_seconds Latency of the TLS handshakes of the HTTP clients of the backends.")
+	fmt.Fprintln(w, "# TYPE filestorage_transport_tls_handshake_seconds histogram")
+	for _, client := range clients {
+		stats := m.transports[client]
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += stats.handshakes[i]
+			fmt.Fprintf(w, "filestorage_transport_tls_handshake_seconds_bucket{client=%q,le=%q} %d\n", client, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "filestorage_transport_tls_handshake_seconds_bucket{client=%q,le=\"+Inf\"} %d\n", client, stats.tlsCount)
+		fmt.Fprintf(w, "filestorage_transport_tls_handshake_seconds_sum{client=%q} %g\n", client, stats.tlsSum)
+		fmt.Fprintf(w, "filestorage_transport_tls_handshake_seconds_count{client=%q} %d\n", client, stats.tlsCount)
+	}
+
+	fmt.Fprintln(w, "# HELP filestorage_transport_tls_errors_total Failed TLS handshakes of the HTTP clients of the backends.")
+	fmt.Fprintln(w, "# TYPE filestorage_transport_tls_errors_total counter")
+	for _, client := range clients {
+		fmt.Fprintf(w, "filestorage_transport_tls_errors_total{client=%q} %d\n", client, m.transports[client].tlsErrors)
+	}
+
+	fmt.Fprintln(w, "# HELP filestorage_transport_hedged_reads_total Reads sent a second time by the HTTP clients of the backends, by the request that won: first or hedge.")
+	fmt.Fprintln(w, "# TYPE filestorage_transport_hedged_reads_total counter")
+	for _, client := range clients {
+		hedges := m.transports[client].hedges
+		for _, winner := range sortedKeys(hedges) {
+			fmt.Fprintf(w, "filestorage_transport_hedged_reads_total{client=%q,winner=%q} %d\n", client, winner, hedges[winner])
+		}
+	}
+}
@@ -0,0 +1,315 @@
+// pkg/storage/trash.go
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// TrashPrefix is the key prefix deleted files are kept below while soft delete is enabled
+	TrashPrefix = ".trash/"
+
+	// trashSweepInterval is how often files past the trash retention are purged
+	trashSweepInterval = time.Hour
+)
+
+// TrashKey returns the key a deleted file is kept under until it is purged
+func TrashKey(fileID string) string {
+	return TrashPrefix + fileID
+}
+
+// RestoreFile moves a soft deleted file out of the trash back to its ID,
+// along with its metadata record. It returns ErrFileNotFound when the file
+// is not in the trash, e.g. because it has been purged, and ErrFileExists
+// when another file has been stored under the ID since.
+func (f *FileStorageManager) RestoreFile(provider, fileID, bucketname, projectID string) (*FileResponse, error) {
+	if bucketname == "" {
+		bucketname = f.defaultBucket(provider)
+	}
+
+	_, exists, err := f.Exists(provider, fileID, bucketname, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("%w: %s", ErrFileExists, fileID)
+	}
+
+	if err := f.moveObject(provider, TrashKey(fileID), fileID, bucketname, projectID); err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			return nil, fmt.Errorf("%w: %s is not in the trash", ErrFileNotFound, fileID)
+		}
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+	f.moveRecord(TrashKey(fileID), fileID)
+
+	return &FileResponse{
+		Status:  StatusSuccess,
+		Message: "RESTORE " + fileID,
+		FileID:  fileID,
+	}, nil
+}
+
+// PurgeTrash permanently deletes the files that have been in the trash of
+// the configured buckets for longer than the trash retention, with their
+// thumbnails, previews and metadata records, and returns how many were purged
+func (f *FileStorageManager) PurgeTrash() (int, error) {
+	if f.trashRetention <= 0 {
+		return 0, nil
+	}
+
+	deadline := time.Now().Add(-f.trashRetention)
+	purged := 0
+
+	for _, provider := range []string{ProviderAWS, ProviderGCS} {
+		bucketname := f.defaultBucket(provider)
+		if bucketname == "" {
+			continue
+		}
+
+		var expired []string
+		err := f.walkPrefix(provider, TrashPrefix, bucketname, "", func(object ObjectInfo) error {
+			if object.ModTime.Before(deadline) {
+				expired = append(expired, object.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			return purged, fmt.Errorf("%s: %w", provider, err)
+		}
+
+		for _, key := range expired {
+			response, err := f.deleteFile(provider, key, bucketname, "", false)
+			if err == nil && response.Status != StatusSuccess {
+				err = errors.New(response.Message)
+			}
+			if err != nil {
+				return purged, fmt.Errorf("%s: %s: %w", provider, key, err)
+			}
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// moveObject renames an object within a bucket, returning ErrFileNotFound
+// when it does not exist
+func (f *FileStorageManager) moveObject(provider, from, to, bucketname, projectID string) error {
+	switch provider {
+	case ProviderAWS:
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return err
+		}
+		return awsMoveObject(s3Client, bucketname, from, to)
+
+	case ProviderGCS:
+		gcsClient, err := f.GetGcsClient(projectID)
+		if err != nil {
+			return err
+		}
+		defer gcsClient.Close()
+
+		f.forgetGcsObject(bucketname, from)
+		return gcsMoveObject(context.Background(), gcsClient.Bucket(bucketname), from, to)
+	}
+
+	return fmt.Errorf("unknown provider %q", provider)
+}
+
+// awsMoveObject copies an S3 object to a new key and deletes the original.
+// The copy is removed again when the original cannot be deleted, so the
+// object is never left under both keys.
+func awsMoveObject(s3Client *s3.S3, bucketname, from, to string) error {
+	if err := awsCopyObject(s3Client, bucketname, from, to); err != nil {
+		return err
+	}
+
+	_, err := s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucketname),
+		Key:    aws.String(from),
+	})
+	if err != nil {
+		s3Client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(bucketname),
+			Key:    aws.String(to),
+		})
+	}
+	return err
+}
+
+const (
+	// maxCopyObjectSize is the largest object S3 copies in a single request
+	maxCopyObjectSize = 5 << 30
+
+	// copyPartSize is the size of the parts larger objects are copied in,
+	// grown for objects that would otherwise need more than maxCopyParts
+	copyPartSize = 512 << 20
+	maxCopyParts = 10000
+)
+
+// awsCopyObject copies an S3 object to a new key, returning ErrFileNotFound
+// when it does not exist. Objects over 5 GB, which S3 does not copy in a
+// single request, are copied part by part in a multipart upload.
+func awsCopyObject(s3Client *s3.S3, bucketname, from, to string) error {
+	head, err := s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucketname),
+		Key:    aws.String(from),
+	})
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound") {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, from)
+	}
+	if err != nil {
+		return err
+	}
+
+	source := (&url.URL{Path: bucketname + "/" + from}).EscapedPath()
+	if aws.Int64Value(head.ContentLength) > maxCopyObjectSize {
+		return awsCopyObjectParts(s3Client, bucketname, source, to, head)
+	}
+
+	_, err = s3Client.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(bucketname),
+		CopySource: aws.String(source),
+		Key:        aws.String(to),
+	})
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound") {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, from)
+	}
+	return err
+}
+
+// awsCopyObjectParts copies an object larger than a single copy request
+// allows with UploadPartCopy, keeping its headers and metadata. The upload is
+// aborted when a part fails, so no partial copy is left behind.
+func awsCopyObjectParts(s3Client *s3.S3, bucketname, source, to string, head *s3.HeadObjectOutput) error {
+	upload, err := s3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(bucketname),
+		Key:                aws.String(to),
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		ContentType:        head.ContentType,
+		Metadata:           head.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	parts, err := awsCopyParts(s3Client, bucketname, source, to, upload.UploadId, head)
+	if err == nil {
+		_, err = s3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(bucketname),
+			Key:             aws.String(to),
+			UploadId:        upload.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if err != nil {
+		s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucketname),
+			Key:      aws.String(to),
+			UploadId: upload.UploadId,
+		})
+		return err
+	}
+	return nil
+}
+
+// awsCopyParts copies an object into the parts of a multipart upload, one
+// range at a time. Parts are only copied from the object as it was when its
+// head was read, so one replaced meanwhile is not copied half old, half new.
+func awsCopyParts(s3Client *s3.S3, bucketname, source, to string, uploadID *string, head *s3.HeadObjectOutput) ([]*s3.CompletedPart, error) {
+	size := aws.Int64Value(head.ContentLength)
+	partSize := max(int64(copyPartSize), (size+maxCopyParts-1)/maxCopyParts)
+
+	var parts []*s3.CompletedPart
+	for start := int64(0); start < size; start += partSize {
+		end := min(start+partSize, size) - 1
+		number := int64(len(parts) + 1)
+
+		result, err := s3Client.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:            aws.String(bucketname),
+			Key:               aws.String(to),
+			UploadId:          uploadID,
+			PartNumber:        aws.Int64(number),
+			CopySource:        aws.String(source),
+			CopySourceIfMatch: head.ETag,
+			CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("copying part %d: %w", number, err)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: result.CopyPartResult.ETag, PartNumber: aws.Int64(number)})
+	}
+	return parts, nil
+}
+
+// gcsMoveObject copies a GCS object to a new name and deletes the original,
+// removing the copy again when the original cannot be deleted
+func gcsMoveObject(ctx context.Context, bucket *storage.BucketHandle, from, to string) error {
+	if err := gcsCopyObject(ctx, bucket, from, to); err != nil {
+		return err
+	}
+
+	err := bucket.Object(from).Delete(ctx)
+	if err != nil {
+		bucket.Object(to).Delete(ctx)
+	}
+	return err
+}
+
+// gcsCopyObject copies a GCS object to a new name, returning ErrFileNotFound
+// when it does not exist
+func gcsCopyObject(ctx context.Context, bucket *storage.BucketHandle, from, to string) error {
+	_, err := bucket.Object(to).CopierFrom(bucket.Object(from)).Run(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, from)
+	}
+	return err
+}
+
+// moveRecord stores the metadata record of a file under a new file ID, so
+// the record of a file in the trash is kept without being found by its ID
+func (f *FileStorageManager) moveRecord(from, to string) {
+	if f.metadataStore == nil {
+		return
+	}
+
+	record, err := f.metadataStore.GetFile(from)
+	if err != nil {
+		return
+	}
+
+	f.deleteRecord(from)
+	record.FileID = to
+	if record.Folder != "" && !inTrash(to) {
+		// Files whose folder was deleted while they were in the trash are
+		// restored to the top level
+		if _, err := f.folderStore.GetFolder(record.Folder); err != nil {
+			record.Folder = ""
+		}
+	}
+	f.saveRecord(record)
+}
+
+// inTrash reports whether a file ID is the key of a file in the trash
+func inTrash(fileID string) bool {
+	return strings.HasPrefix(fileID, TrashPrefix)
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fakeS3 answers the S3 requests moving objects, recording them as
+// "METHOD key operation" lines
+type fakeS3 struct {
+	mu       sync.Mutex
+	sizes    map[string]int64 // Objects by key
+	failPart string           // Part number failing to copy
+	failKey  string           // Key failing to delete
+	requests []string
+	ranges   []string // CopySourceRange of each copied part
+	ifMatch  []string // CopySourceIfMatch of each copied part
+}
+
+func newFakeS3(t *testing.T, sizes map[string]int64) (*fakeS3, *s3.S3) {
+	t.Helper()
+
+	fake := &fakeS3{sizes: sizes}
+	return fake, newS3Client(t, fake)
+}
+
+// newS3Client returns an S3 client sending its requests to handler
+func newS3Client(t *testing.T, handler http.Handler) *s3.S3 {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s3.New(sess)
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	query := r.URL.Query()
+	operation := ""
+	switch {
+	case query.Has("uploads"):
+		operation = "create"
+	case query.Has("partNumber"):
+		operation = "part"
+	case query.Has("uploadId") && r.Method == http.MethodPost:
+		operation = "complete"
+	case query.Has("uploadId"):
+		operation = "abort"
+	case r.Header.Get("X-Amz-Copy-Source") != "":
+		operation = "copy"
+	}
+	s.requests = append(s.requests, strings.TrimSpace(fmt.Sprintf("%s %s %s", r.Method, key, operation)))
+	io.Copy(io.Discard, r.Body)
+
+	switch operation {
+	case "create":
+		fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+	case "part":
+		if query.Get("partNumber") == s.failPart {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `<Error><Code>InternalError</Code></Error>`)
+			return
+		}
+		s.ranges = append(s.ranges, r.Header.Get("X-Amz-Copy-Source-Range"))
+		s.ifMatch = append(s.ifMatch, r.Header.Get("X-Amz-Copy-Source-If-Match"))
+		fmt.Fprintf(w, `<CopyPartResult><ETag>"part-%s"</ETag></CopyPartResult>`, query.Get("partNumber"))
+	case "complete":
+		s.sizes[key] = 1
+		fmt.Fprint(w, `<CompleteMultipartUploadResult><ETag>"done"</ETag></CompleteMultipartUploadResult>`)
+	case "copy":
+		s.sizes[key] = 1
+		fmt.Fprint(w, `<CopyObjectResult><ETag>"copy"</ETag></CopyObjectResult>`)
+	case "abort":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		switch r.Method {
+		case http.MethodHead:
+			size, ok := s.sizes[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", fmt.Sprint(size))
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("ETag", `"source"`)
+		case http.MethodDelete:
+			if key == s.failKey {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `<Error><Code>AccessDenied</Code></Error>`)
+				return
+			}
+			delete(s.sizes, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func TestAwsCopyObject(t *testing.T) {
+	t.Run("single request up to 5 GB", func(t *testing.T) {
+		fake, client := newFakeS3(t, map[string]int64{"report.pdf": maxCopyObjectSize})
+		if err := awsCopyObject(client, "bucket", "report.pdf", TrashKey("report.pdf")); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"HEAD report.pdf", "PUT .trash/report.pdf copy"}
+		if strings.Join(fake.requests, "\n") != strings.Join(want, "\n") {
+			t.Errorf("requests = %q, want %q", fake.requests, want)
+		}
+	})
+
+	t.Run("parts above 5 GB", func(t *testing.T) {
+		size := int64(maxCopyObjectSize + 1<<30 + 1)
+		fake, client := newFakeS3(t, map[string]int64{"thesis.mp4": size})
+		if err := awsCopyObject(client, "bucket", "thesis.mp4", TrashKey("thesis.mp4")); err != nil {
+			t.Fatal(err)
+		}
+
+		parts := int((size + copyPartSize - 1) / copyPartSize)
+		if len(fake.ranges) != parts {
+			t.Fatalf("copied %d parts, want %d", len(fake.ranges), parts)
+		}
+		if fake.ranges[0] != fmt.Sprintf("bytes=0-%d", copyPartSize-1) {
+			t.Errorf("first range = %q", fake.ranges[0])
+		}
+		if last := fmt.Sprintf("bytes=%d-%d", int64(parts-1)*copyPartSize, size-1); fake.ranges[parts-1] != last {
+			t.Errorf("last range = %q, want %q", fake.ranges[parts-1], last)
+		}
+		for _, etag := range fake.ifMatch {
+			if etag != `"source"` {
+				t.Fatalf("part copied if matching %q, want the ETag of the head", etag)
+			}
+		}
+		if got := fake.requests[len(fake.requests)-1]; got != "POST .trash/thesis.mp4 complete" {
+			t.Errorf("last request = %q, want the upload completed", got)
+		}
+	})
+
+	t.Run("failed part aborts the upload", func(t *testing.T) {
+		fake, client := newFakeS3(t, map[string]int64{"thesis.mp4": maxCopyObjectSize + 1})
+		fake.failPart = "2"
+		if err := awsCopyObject(client, "bucket", "thesis.mp4", TrashKey("thesis.mp4")); err == nil {
+			t.Fatal("awsCopyObject() succeeded with a failing part")
+		}
+
+		if got := fake.requests[len(fake.requests)-1]; got != "DELETE .trash/thesis.mp4 abort" {
+			t.Errorf("last request = %q, want the upload aborted", got)
+		}
+		if _, ok := fake.sizes[TrashKey("thesis.mp4")]; ok {
+			t.Error("partial copy left in the trash")
+		}
+	})
+
+	t.Run("missing object", func(t *testing.T) {
+		_, client := newFakeS3(t, map[string]int64{})
+		if err := awsCopyObject(client, "bucket", "missing.pdf", TrashKey("missing.pdf")); !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("awsCopyObject() error = %v, want ErrFileNotFound", err)
+		}
+	})
+}
+
+func TestAwsMoveObjectRemovesCopyWhenDeleteFails(t *testing.T) {
+	fake, client := newFakeS3(t, map[string]int64{"report.pdf": 1})
+	fake.failKey = "report.pdf"
+
+	if err := awsMoveObject(client, "bucket", "report.pdf", TrashKey("report.pdf")); err == nil {
+		t.Fatal("awsMoveObject() succeeded without deleting the original")
+	}
+	if _, ok := fake.sizes[TrashKey("report.pdf")]; ok {
+		t.Error("copy left in the trash")
+	}
+	if _, ok := fake.sizes["report.pdf"]; !ok {
+		t.Error("original removed")
+	}
+}
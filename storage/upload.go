@@ -0,0 +1,230 @@
+// pkg/storage/upload.go
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+)
+
+// uploadPayload holds an uploaded file after it has been validated and read
+// into memory, or staged in a temp file when it is too large for memory
+type uploadPayload struct {
+	data      []byte // Content as stored, i.e. after encryption
+	plain     []byte // Content before compression and encryption
+	size      int64  // Size of the original content
+	filename  string // Original base name without the extension
+	extension string // Extension without the leading dot
+	mimeType  string // Resolved content type, see resolveMimeType
+	md5       string // Hex encoded MD5 of the original content
+	sha256    string // Hex encoded SHA-256 of the original content
+	encrypted bool   // Whether data has been encrypted
+
+	contentEncoding string        // Compression applied to data, "" if none
+	options         UploadOptions // Per-upload object settings
+
+	thumbnails []renderedThumbnail // Thumbnails to store next to the original
+	scan       *ScanResult         // Malware scan result, nil if no scanner is configured
+
+	staged       *os.File // Temp file holding the content as stored in place of data and plain, see stagePayload
+	stagedSize   int64    // Size of the staged content
+	stagedMD5    string   // Hex encoded MD5 of the staged content
+	stagedSHA256 string   // Hex encoded SHA-256 of the staged content
+
+	reserved func() // Gives the memory the content is buffered in back to the budget, see reserveMemory
+}
+
+// readUpload validates a multipart file against the upload limits and filters
+// and reads its content, encoded for storage with the given provider
+func (f *FileStorageManager) readUpload(ctx context.Context, file *multipart.FileHeader, provider string) (*uploadPayload, error) {
+	if err := f.checkUploadSize(file.Size); err != nil {
+		return nil, err
+	}
+
+	claimedType := file.Header.Get("Content-Type")
+	if err := f.fileFilter.Check(file.Filename, claimedType); err != nil {
+		return nil, err
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	// The REST backend takes uploads as a single JSON document, so only S3
+	// and GCS uploads are staged
+	if provider != ProviderREST && f.stagesUpload(file.Size) {
+		return f.stagePayload(file.Filename, claimedType, src)
+	}
+
+	// Content read into memory counts against the memory budget until the
+	// payload is released
+	reserved, err := f.reserveMemory(ctx, file.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readAll(src, file.Size)
+	if err != nil {
+		reserved()
+		return nil, err
+	}
+
+	payload, err := f.preparePayload(file.Filename, claimedType, data, provider)
+	if err != nil {
+		reserved()
+		return nil, err
+	}
+	payload.reserved = reserved
+	return payload, nil
+}
+
+// preparePayload checks already read upload content and encodes it for
+// storage with the given provider
+func (f *FileStorageManager) preparePayload(name, claimedType string, data []byte, provider string) (*uploadPayload, error) {
+	filename, extension := splitFilename(name)
+
+	mimeType, err := f.detectMimeType(name, claimedType, sniffMimeType(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Scan the content exactly as it was received
+	scan, err := f.scanUpload(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &uploadPayload{
+		data:      data,
+		filename:  filename,
+		extension: extension,
+		mimeType:  mimeType,
+		scan:      scan,
+	}
+
+	// Normalize images before anything is derived from the content
+	if err := f.processImagePayload(payload); err != nil {
+		return nil, err
+	}
+	if err := f.stripPayloadMetadata(payload); err != nil {
+		return nil, err
+	}
+
+	payload.plain = payload.data
+	payload.size = int64(len(payload.data))
+	payload.md5 = md5Hex(payload.data)
+	payload.sha256 = sha256Hex(payload.data)
+
+	// The REST backend has no Content-Encoding metadata to record compression in
+	// and no place to store thumbnails next to the original
+	if provider != ProviderREST {
+		f.renderThumbnails(payload)
+
+		if err := f.compressPayload(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := f.encryptPayload(payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// splitFilename returns the base name of an uploaded file without its
+// extension, and the extension without the leading dot
+func splitFilename(name string) (string, string) {
+	filename := filepath.Base(name)
+	extension := filepath.Ext(filename)
+	filename = filename[:len(filename)-len(extension)]
+	if extension != "" {
+		extension = extension[1:] // Remove the dot
+	}
+	return filename, extension
+}
+
+// contentEncoding returns the S3 Content-Encoding header value for a payload
+func contentEncoding(payload *uploadPayload) *string {
+	if payload.contentEncoding == "" {
+		return nil
+	}
+	return &payload.contentEncoding
+}
+
+// optionalString returns nil for an empty string, leaving optional S3 fields unset
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// body returns the content sent to the provider
+func (p *uploadPayload) body() io.ReadSeeker {
+	if p.staged != nil {
+		return io.NewSectionReader(p.staged, 0, p.stagedSize)
+	}
+	return bytes.NewReader(p.data)
+}
+
+// section returns length bytes of the content sent to the provider, from offset
+func (p *uploadPayload) section(offset, length int64) *io.SectionReader {
+	if p.staged != nil {
+		return io.NewSectionReader(p.staged, offset, length)
+	}
+	return io.NewSectionReader(bytes.NewReader(p.data), offset, length)
+}
+
+// storedSize returns the size of the content sent to the provider
+func (p *uploadPayload) storedSize() int64 {
+	if p.staged != nil {
+		return p.stagedSize
+	}
+	return int64(len(p.data))
+}
+
+// storedMD5 returns the hex encoded MD5 of the content sent to the provider
+func (p *uploadPayload) storedMD5() string {
+	if p.staged != nil {
+		return p.stagedMD5
+	}
+	if p.encrypted || p.contentEncoding != "" {
+		return md5Hex(p.data)
+	}
+	return p.md5
+}
+
+// storedSHA256 returns the hex encoded SHA-256 of the content sent to the provider
+func (p *uploadPayload) storedSHA256() string {
+	if p.staged != nil {
+		return p.stagedSHA256
+	}
+	if p.encrypted || p.contentEncoding != "" {
+		return sha256Hex(p.data)
+	}
+	return p.sha256
+}
+
+// detectMimeType reconciles the sniffed content type with the type claimed by
+// the client, re-running the file filter on the result
+func (f *FileStorageManager) detectMimeType(filename, claimedType, sniffedType string) (string, error) {
+	mimeType, compatible := resolveMimeType(claimedType, sniffedType)
+
+	if !compatible && f.config.RejectMimeMismatch {
+		return "", fmt.Errorf("%w: claimed %q, detected %q", ErrMimeTypeMismatch, normalizeMimeType(claimedType), sniffedType)
+	}
+
+	if err := f.fileFilter.Check(filename, mimeType); err != nil {
+		return "", err
+	}
+
+	return mimeType, nil
+}
@@ -0,0 +1,138 @@
+// pkg/storage/upload_hook.go
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+)
+
+// maxConcurrentHooks is the number of post-upload hooks run at the same time
+const maxConcurrentHooks = 2
+
+// Rendition is a derived version of an upload, e.g. a transcoded video
+type Rendition struct {
+	Format     string `json:"format"`
+	FileID     string `json:"file_id"`
+	PublicLink string `json:"public_link"`
+}
+
+// UploadEvent describes a stored S3 or GCS upload passed to post-upload hooks
+type UploadEvent struct {
+	Provider  string
+	Bucket    string
+	ProjectID string
+	Info      *FileInfo
+	data      []byte
+}
+
+// Data returns the uploaded content before compression and encryption, nil
+// for uploads too large to be held in memory, see Open
+func (e *UploadEvent) Data() []byte {
+	return e.data
+}
+
+// Open returns the uploaded content before compression and encryption,
+// reading it back from the provider when the upload was staged on disk
+// rather than held in memory
+func (e *UploadEvent) Open(f *FileStorageManager) (io.ReadCloser, error) {
+	if e.data != nil {
+		return io.NopCloser(bytes.NewReader(e.data)), nil
+	}
+	return f.OpenFile(e.Provider, e.Info.FileID, e.Bucket, e.ProjectID)
+}
+
+// PostUploadHook processes uploads in the background once they have been stored
+type PostUploadHook interface {
+	// Handles reports whether the hook wants to process the upload
+	Handles(event *UploadEvent) bool
+	// Run processes the upload, storing any output through the manager
+	Run(ctx context.Context, f *FileStorageManager, event *UploadEvent) error
+}
+
+// AddPostUploadHook registers a hook run after every S3 and GCS upload
+func (f *FileStorageManager) AddPostUploadHook(hook PostUploadHook) {
+	f.uploadHooks = append(f.uploadHooks, hook)
+}
+
+// runUploadHooks starts the hooks handling an upload in the background
+func (f *FileStorageManager) runUploadHooks(provider, bucketname, projectID string, info *FileInfo, payload *uploadPayload) {
+	event := &UploadEvent{
+		Provider:  provider,
+		Bucket:    bucketname,
+		ProjectID: projectID,
+		Info:      info,
+		data:      payload.plain,
+	}
+
+	for _, hook := range f.uploadHooks {
+		if !hook.Handles(event) {
+			continue
+		}
+
+		go func(hook PostUploadHook) {
+			f.hookSlots <- struct{}{}
+			defer func() { <-f.hookSlots }()
+
+			if err := hook.Run(context.Background(), f, event); err != nil {
+				log.Printf("filestorage: post-upload hook for %s failed: %v", event.Info.FileID, err)
+			}
+		}(hook)
+	}
+}
+
+// StoreDerivedObject stores content derived from an upload, such as a
+// rendition, in the bucket of the original and returns its public link.
+// Derived objects get the same protection as the original.
+func (f *FileStorageManager) StoreDerivedObject(event *UploadEvent, key, contentType string, data []byte) (string, error) {
+	if f.encryptor != nil {
+		var err error
+		if data, err = f.encryptor.Encrypt(data); err != nil {
+			return "", err
+		}
+	}
+
+	switch event.Provider {
+	case ProviderAWS:
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return "", err
+		}
+		if err := putAwsObject(s3Client, event.Bucket, key, contentType, data); err != nil {
+			return "", err
+		}
+		return f.awsPublicURL(event.Bucket, key), nil
+
+	case ProviderGCS:
+		gcsClient, err := f.GetGcsClient(event.ProjectID)
+		if err != nil {
+			return "", err
+		}
+		defer gcsClient.Close()
+
+		if err := putGcsObject(context.Background(), gcsClient.Bucket(event.Bucket), key, contentType, data); err != nil {
+			return "", err
+		}
+		return gcsPublicURL(event.Bucket, key), nil
+	}
+
+	return "", fmt.Errorf("unknown provider %q", event.Provider)
+}
+
+// AddRenditions records renditions of a file in the metadata store
+func (f *FileStorageManager) AddRenditions(fileID string, renditions ...Rendition) error {
+	if f.metadataStore == nil {
+		return fmt.Errorf("metadata store not configured")
+	}
+
+	record, err := f.metadataStore.GetFile(fileID)
+	if err != nil {
+		return err
+	}
+
+	record.Renditions = append(record.Renditions, renditions...)
+	return f.saveRecord(record)
+}
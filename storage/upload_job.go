@@ -0,0 +1,445 @@
+// pkg/storage/upload_job.go
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job statuses
+const (
+	JobPending    = "pending"
+	JobProcessing = "processing"
+	JobDone       = "done"
+	JobFailed     = "failed"
+)
+
+const (
+	// DefaultJobWorkers is the number of jobs processed at the same time
+	DefaultJobWorkers = 2
+
+	// DefaultJobQueueSize is the number of jobs that may wait for a worker
+	DefaultJobQueueSize = 100
+
+	// jobRetention is how long finished jobs can be polled before they are forgotten
+	jobRetention = time.Hour
+
+	// detachMemory is the part of a detached upload kept in memory, the rest goes to a temporary file
+	detachMemory = 1 << 20
+)
+
+// Job is an upload or import processed in the background
+type Job struct {
+	ID        string        `json:"id"`
+	Owner     string        `json:"owner,omitempty"` // Signed in user the job was queued for, "" for services
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Result    *FileResponse `json:"result,omitempty"`
+	Progress  *JobProgress  `json:"progress,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// JobProgress is how much of a job's work is done, in bytes for uploads
+type JobProgress struct {
+	Done  int64 `json:"done"`
+	Total int64 `json:"total"`
+}
+
+// JobFunc is the work done by a job
+type JobFunc func() (*FileResponse, error)
+
+// ProgressJobFunc is the work done by a job reporting its progress
+type ProgressJobFunc func(progress ProgressFunc) (*FileResponse, error)
+
+// ProgressFunc reports that done of total units of a job's work are done
+type ProgressFunc func(done, total int64)
+
+// jobQueue runs jobs on a fixed number of workers, started with the first job
+type jobQueue struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	pending chan queuedJob
+	workers int
+	start   sync.Once
+	closed  bool           // Set by close, after which no jobs are accepted
+	running sync.WaitGroup // Jobs queued or being processed
+
+	changed map[string]chan struct{} // Closed on the next update of a watched job
+}
+
+// queuedJob is a job waiting for a worker
+type queuedJob struct {
+	id   string
+	task ProgressJobFunc
+	done func()
+}
+
+// newJobQueue creates a job queue from the configuration
+func newJobQueue(config *Config) *jobQueue {
+	workers := config.JobWorkers
+	if workers <= 0 {
+		workers = DefaultJobWorkers
+	}
+	queueSize := config.JobQueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultJobQueueSize
+	}
+
+	return &jobQueue{
+		jobs:    make(map[string]*Job),
+		pending: make(chan queuedJob, queueSize),
+		workers: workers,
+		changed: make(map[string]chan struct{}),
+	}
+}
+
+// SubmitJob queues task to run in the background and returns the pending job,
+// or ErrQueueFull when too many jobs are already waiting
+func (f *FileStorageManager) SubmitJob(task JobFunc) (*Job, error) {
+	return f.jobs.submit(func(ProgressFunc) (*FileResponse, error) {
+		return task()
+	}, nil)
+}
+
+// SubmitProgressJob queues task like SubmitJob, passing it a function to
+// report its progress with, e.g. the bytes transcoded so far
+func (f *FileStorageManager) SubmitProgressJob(task ProgressJobFunc) (*Job, error) {
+	return f.jobs.submit(task, nil)
+}
+
+// SubmitUpload queues an upload of file with upload. The file is copied first,
+// since the temporary files of a request are removed once it has been handled.
+func (f *FileStorageManager) SubmitUpload(file *multipart.FileHeader, upload UploadFunc) (*Job, error) {
+	detached, cleanup, err := detachFiles([]*multipart.FileHeader{file})
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := f.jobs.submit(func(progress ProgressFunc) (*FileResponse, error) {
+		progress(0, file.Size)
+		response, err := upload(detached[0])
+		if err == nil && response != nil && response.Status == StatusSuccess {
+			progress(file.Size, file.Size)
+		}
+		return response, err
+	}, cleanup)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// SubmitUploadMany queues an UploadMany of files with upload, copying the files first
+func (f *FileStorageManager) SubmitUploadMany(files []*multipart.FileHeader, upload UploadFunc) (*Job, error) {
+	detached, cleanup, err := detachFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := f.jobs.submit(func(progress ProgressFunc) (*FileResponse, error) {
+		return f.uploadMany(detached, upload, progress), nil
+	}, cleanup)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Shutdown stops accepting background jobs and waits for the queued and
+// running ones, such as ?async=true uploads, to finish or for ctx to be done.
+// Close then releases the clients and background goroutines of the manager.
+func (f *FileStorageManager) Shutdown(ctx context.Context) error {
+	return f.jobs.drain(ctx)
+}
+
+// GetJob returns the current state of a job or ErrJobNotFound
+func (f *FileStorageManager) GetJob(id string) (*Job, error) {
+	return f.jobs.get(id)
+}
+
+// SetJobOwner records the signed in user a job was queued for, so it can
+// be kept from others
+func (f *FileStorageManager) SetJobOwner(id, owner string) {
+	f.jobs.update(id, func(job *Job) {
+		job.Owner = owner
+	})
+}
+
+// WatchJob returns the current state of a job and then every change of its
+// status or progress, closing the channel once the job has finished or ctx
+// is done. Changes in quick succession may be merged into one. It returns
+// ErrJobNotFound for unknown jobs.
+func (f *FileStorageManager) WatchJob(ctx context.Context, id string) (<-chan *Job, error) {
+	job, changed, err := f.jobs.watch(id)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make(chan *Job)
+	go func() {
+		defer close(updates)
+		for {
+			select {
+			case updates <- job:
+			case <-ctx.Done():
+				return
+			}
+			if job.finished() {
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+			if job, changed, err = f.jobs.watch(id); err != nil {
+				return
+			}
+		}
+	}()
+
+	return updates, nil
+}
+
+// submit registers a pending job and hands it to the workers
+func (q *jobQueue) submit(task ProgressJobFunc, done func()) (*Job, error) {
+	q.start.Do(func() {
+		for i := 0; i < q.workers; i++ {
+			go q.work()
+		}
+	})
+
+	now := time.Now()
+	job := &Job{
+		ID:        uuid.New().String(),
+		Status:    JobPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, ErrShuttingDown
+	}
+
+	q.forgetFinished(now)
+
+	select {
+	case q.pending <- queuedJob{id: job.ID, task: task, done: done}:
+	default:
+		return nil, ErrQueueFull
+	}
+	q.running.Add(1)
+
+	q.jobs[job.ID] = job
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// get returns a snapshot of a job
+func (q *jobQueue) get(id string) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// watch returns a snapshot of a job and a channel closed on its next update
+func (q *jobQueue) watch(id string) (*Job, <-chan struct{}, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	changed, ok := q.changed[id]
+	if !ok {
+		changed = make(chan struct{})
+		q.changed[id] = changed
+	}
+
+	snapshot := *job
+	return &snapshot, changed, nil
+}
+
+// drain stops accepting jobs and waits for the queued and running ones to
+// finish or for ctx to be done. The workers exit once the queue is empty.
+func (q *jobQueue) drain(ctx context.Context) error {
+	q.close()
+
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for background jobs: %w", ctx.Err())
+	}
+}
+
+// close stops accepting jobs. The workers exit once the queued ones are done.
+func (q *jobQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.pending)
+	}
+}
+
+// work processes queued jobs until the queue is drained
+func (q *jobQueue) work() {
+	for queued := range q.pending {
+		q.update(queued.id, func(job *Job) {
+			job.Status = JobProcessing
+		})
+
+		result, err := queued.task(func(done, total int64) {
+			q.update(queued.id, func(job *Job) {
+				job.Progress = &JobProgress{Done: done, Total: total}
+			})
+		})
+		if queued.done != nil {
+			queued.done()
+		}
+
+		q.update(queued.id, func(job *Job) {
+			job.Result = result
+			switch {
+			case err != nil:
+				job.Status = JobFailed
+				job.Error = err.Error()
+			case result != nil && result.Status != StatusSuccess:
+				job.Status = JobFailed
+				job.Error = result.Message
+			default:
+				job.Status = JobDone
+			}
+		})
+		q.running.Done()
+	}
+}
+
+// update applies change to a job under the lock
+func (q *jobQueue) update(id string, change func(job *Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// submit holds the lock until the job is registered, so it is always found
+	job, ok := q.jobs[id]
+	if !ok {
+		return
+	}
+
+	change(job)
+	job.UpdatedAt = time.Now()
+
+	if changed, ok := q.changed[id]; ok {
+		close(changed)
+		delete(q.changed, id)
+	}
+}
+
+// forgetFinished removes jobs that finished longer than jobRetention ago.
+// The caller must hold the lock.
+func (q *jobQueue) forgetFinished(now time.Time) {
+	for id, job := range q.jobs {
+		if job.finished() && now.Sub(job.UpdatedAt) > jobRetention {
+			delete(q.jobs, id)
+			delete(q.changed, id)
+		}
+	}
+}
+
+// finished reports whether a job is done or failed
+func (j *Job) finished() bool {
+	return j.Status == JobDone || j.Status == JobFailed
+}
+
+// quoteEscaper escapes a filename for a Content-Disposition header
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// detachFiles copies uploaded files into a form owned by the caller, spilling
+// large files to disk. The returned cleanup removes the copies.
+func detachFiles(files []*multipart.FileHeader) ([]*multipart.FileHeader, func(), error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeFiles(mw, files))
+	}()
+
+	form, err := multipart.NewReader(pr, mw.Boundary()).ReadForm(detachMemory)
+	pr.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	detached := form.File["file"]
+	cleanup := func() { form.RemoveAll() }
+
+	if len(detached) != len(files) {
+		cleanup()
+		return nil, nil, fmt.Errorf("copied %d of %d files", len(detached), len(files))
+	}
+
+	return detached, cleanup, nil
+}
+
+// writeFiles writes files as "file" parts, keeping their headers
+func writeFiles(mw *multipart.Writer, files []*multipart.FileHeader) error {
+	for _, file := range files {
+		header := make(textproto.MIMEHeader, len(file.Header))
+		for key, values := range file.Header {
+			header[key] = values
+		}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(file.Filename)))
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			return err
+		}
+		_, err = Copy(part, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
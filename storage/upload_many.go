@@ -0,0 +1,101 @@
+// pkg/storage/upload_many.go
+
+package storage
+
+import (
+	"fmt"
+	"mime/multipart"
+	"sync"
+)
+
+// DefaultUploadConcurrency is the number of files UploadMany uploads at the same time
+const DefaultUploadConcurrency = 4
+
+// UploadFunc uploads a single file, e.g. a closure around AwsUpload
+type UploadFunc func(file *multipart.FileHeader) (*FileResponse, error)
+
+// FileResult is the outcome for one file of a batch
+type FileResult struct {
+	FileName string    `json:"file_name"`
+	Status   string    `json:"status"`
+	Message  string    `json:"message,omitempty"`
+	FileID   string    `json:"file_id,omitempty"`
+	Provider string    `json:"provider,omitempty"` // Set by batches mixing providers
+	Info     *FileInfo `json:"info,omitempty"`
+}
+
+// SetUploadConcurrency sets the number of files UploadMany uploads at the same time
+func (f *FileStorageManager) SetUploadConcurrency(concurrency int) {
+	f.uploadConcurrency = concurrency
+}
+
+// UploadMany uploads files concurrently with upload, using at most the
+// configured number of workers. Every file gets a result in the order given;
+// the response status is StatusError if any of them failed.
+func (f *FileStorageManager) UploadMany(files []*multipart.FileHeader, upload UploadFunc) *FileResponse {
+	return f.uploadMany(files, upload, nil)
+}
+
+// uploadMany implements UploadMany, reporting the bytes of the files
+// uploaded so far to progress if it is not nil
+func (f *FileStorageManager) uploadMany(files []*multipart.FileHeader, upload UploadFunc, progress ProgressFunc) *FileResponse {
+	concurrency := f.uploadConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultUploadConcurrency
+	}
+
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	if progress != nil {
+		progress(0, total)
+	}
+
+	results := make([]*FileResult, len(files))
+
+	var mu sync.Mutex
+	var done int64
+
+	failed := NewWorkerPool(concurrency).Run(len(files), func(i int) error {
+		results[i] = uploadResult(files[i], upload)
+		if progress != nil {
+			mu.Lock()
+			done += files[i].Size
+			progress(done, total)
+			mu.Unlock()
+		}
+		return resultErr(results[i])
+	})
+
+	response := &FileResponse{
+		Status:  StatusSuccess,
+		Message: fmt.Sprintf("UPLOAD %d of %d files", len(files)-len(failed), len(files)),
+		Results: results,
+	}
+	if len(failed) > 0 {
+		response.Status = StatusError
+	}
+
+	return response
+}
+
+// uploadResult uploads a single file of a batch and converts the outcome to a FileResult
+func uploadResult(file *multipart.FileHeader, upload UploadFunc) *FileResult {
+	result := &FileResult{
+		FileName: file.Filename,
+		Status:   StatusError,
+	}
+
+	response, err := upload(file)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	result.Status = response.Status
+	result.Message = response.Message
+	result.FileID = response.FileID
+	result.Info = response.Info
+	return result
+}
@@ -0,0 +1,114 @@
+// pkg/storage/upload_options.go
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ObjectNamer returns the object key for an upload, below UploadOptions.Prefix.
+// filename is the original base name and extension has no leading dot.
+type ObjectNamer func(filename, extension string) string
+
+// UploadOptions configures a single S3 or GCS upload. The zero value uploads
+// to the configured bucket with a generated key.
+type UploadOptions struct {
+	Bucket       string            // Bucket to upload to, "" for the configured one
+	Prefix       string            // Key prefix, e.g. "submissions/2024"
+	ProjectID    string            // GCS project, "" for the configured one
+	Metadata     map[string]string // Custom object metadata (x-amz-meta-* on S3)
+	ACL          string            // Provider canned ACL, e.g. "public-read" on S3 or "publicRead" on GCS
+	StorageClass string            // Provider storage class, e.g. "STANDARD_IA" on S3 or "NEARLINE" on GCS
+	ContentType  string            // Overrides the detected content type, still subject to the file filter
+	Namer        ObjectNamer       // Generates the object key instead of the default naming
+	Headers      ObjectHeaders     // HTTP headers stored with the object
+	Owner        string            // Subject owning the file, e.g. the student's user ID, "" for none
+	ExpiresAt    time.Time         // When the file is deleted, e.g. for drafts, zero for never
+	Attributes   Attributes        // Custom attributes recorded with the file, validated against the attribute schema
+	Context      context.Context   // Cancels the upload when done, e.g. the request context, nil for none
+
+	replace bool // Set by ReplaceFile, whose upload is stored even when the content is stored elsewhere
+}
+
+// validate rejects options that cannot be written as object metadata or
+// name keys outside the prefixes uploads may write to
+func (o *UploadOptions) validate() error {
+	if err := o.Headers.validate(); err != nil {
+		return err
+	}
+	if err := validatePrefix(o.Prefix); err != nil {
+		return err
+	}
+	if !o.ExpiresAt.IsZero() && !o.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: expiry must be in the future", ErrInvalidExpiry)
+	}
+
+	for key, value := range o.Metadata {
+		if key == "" || strings.ContainsAny(key, " \t\r\n\x00:") {
+			return fmt.Errorf("%w: metadata key %q", ErrInvalidObjectHeader, key)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("%w: metadata %s", ErrInvalidObjectHeader, key)
+		}
+	}
+
+	return nil
+}
+
+// context returns the context of the upload, context.Background() when none is set
+func (o *UploadOptions) context() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+// applyTo records the options in a payload, checking a content type override against the file filter
+func (o *UploadOptions) applyTo(f *FileStorageManager, payload *uploadPayload) error {
+	if o.ContentType != "" {
+		mimeType := normalizeMimeType(o.ContentType)
+		name := payload.filename
+		if payload.extension != "" {
+			name += "." + payload.extension
+		}
+		if err := f.fileFilter.Check(name, mimeType); err != nil {
+			return err
+		}
+		payload.mimeType = mimeType
+	}
+
+	payload.options = *o
+	return nil
+}
+
+// objectKey generates the object key for an upload with the options' namer,
+// falling back to newObjectKey
+func (f *FileStorageManager) objectKey(opts *UploadOptions, payload *uploadPayload, exists func(key string) (bool, error)) (string, error) {
+	if opts.Namer == nil {
+		return f.newObjectKey(opts.Prefix, payload, exists)
+	}
+
+	name := strings.Trim(opts.Namer(payload.filename, payload.extension), "/")
+	if name == "" {
+		return "", fmt.Errorf("object namer returned an empty key")
+	}
+
+	return joinObjectKey(strings.Trim(opts.Prefix, "/"), name), nil
+}
+
+// awsMetadata converts custom metadata into S3 metadata, nil when there is none
+func awsMetadata(metadata map[string]string) map[string]*string {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	converted := make(map[string]*string, len(metadata))
+	for key, value := range metadata {
+		v := value
+		converted[key] = &v
+	}
+	return converted
+}
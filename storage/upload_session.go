@@ -0,0 +1,115 @@
+// pkg/storage/upload_session.go
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// DefaultUploadSessionTTL is how long a chunked upload may take when no TTL is configured
+	DefaultUploadSessionTTL = 24 * time.Hour
+
+	// uploadSessionSweepInterval is how often expired upload sessions are cleaned up
+	uploadSessionSweepInterval = 15 * time.Minute
+)
+
+// UploadSessionStore persists chunked upload sessions, so half-finished
+// uploads survive restarts and can be shared between instances
+type UploadSessionStore interface {
+	// SaveSession inserts or replaces the session for upload.UploadID
+	SaveSession(upload *ChunkedUpload) error
+	// GetSession returns the session for an upload ID or ErrUploadNotFound
+	GetSession(uploadID string) (*ChunkedUpload, error)
+	// AddChunk records a received chunk, replacing one with the same index
+	AddChunk(uploadID string, chunk *Chunk) error
+	// DeleteSession removes the session for an upload ID, if any
+	DeleteSession(uploadID string) error
+	// ListSessions returns all sessions, including expired ones
+	ListSessions() ([]*ChunkedUpload, error)
+}
+
+// SetUploadSessionStore sets the store chunked upload sessions are tracked in
+func (f *FileStorageManager) SetUploadSessionStore(store UploadSessionStore) {
+	f.sessionStore = store
+}
+
+// SetUploadSessionTTL sets how long new chunked uploads may take before they expire
+func (f *FileStorageManager) SetUploadSessionTTL(ttl time.Duration) {
+	f.sessionTTL = ttl
+}
+
+// ListChunkedUploads returns the chunked uploads that have not been completed or aborted yet
+func (f *FileStorageManager) ListChunkedUploads() ([]*ChunkedUpload, error) {
+	return f.sessionStore.ListSessions()
+}
+
+// CleanupExpiredUploads aborts the chunked uploads past their expiry, removing
+// their chunks from the provider, and returns how many were removed
+func (f *FileStorageManager) CleanupExpiredUploads() (int, error) {
+	sessions, err := f.sessionStore.ListSessions()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	now := time.Now()
+
+	for _, upload := range sessions {
+		if !upload.expired(now) {
+			continue
+		}
+
+		if err := f.discardChunks(upload); err != nil {
+			return removed, fmt.Errorf("%s: %w", upload.UploadID, err)
+		}
+		if err := f.sessionStore.DeleteSession(upload.UploadID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// discardChunks removes the chunks of an upload from the provider
+func (f *FileStorageManager) discardChunks(upload *ChunkedUpload) error {
+	switch upload.Provider {
+	case ProviderAWS:
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return err
+		}
+
+		_, err = s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(upload.Bucket),
+			Key:      aws.String(upload.FileID),
+			UploadId: aws.String(upload.MultipartID),
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchUpload {
+			return nil
+		}
+		return err
+
+	case ProviderGCS:
+		gcsClient, err := f.GetGcsClient(upload.ProjectID)
+		if err != nil {
+			return err
+		}
+		defer gcsClient.Close()
+
+		chunks := make([]*Chunk, 0, len(upload.Chunks))
+		for _, chunk := range upload.Chunks {
+			chunks = append(chunks, chunk)
+		}
+		deleteChunks(context.Background(), gcsClient.Bucket(upload.Bucket), upload.UploadID, chunks)
+	}
+
+	return nil
+}
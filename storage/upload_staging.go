@@ -0,0 +1,178 @@
+// pkg/storage/upload_staging.go
+
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// DefaultUploadMemoryLimit is the size above which S3 and GCS uploads are
+// staged in a temp file instead of being read into memory
+const DefaultUploadMemoryLimit = 32 << 20
+
+// SetUploadStaging sets the size in bytes above which S3 and GCS uploads are
+// staged in a temp file in dir instead of being read into memory, so a few
+// large uploads cannot exhaust the memory of the service. A limit of 0 falls
+// back to DefaultUploadMemoryLimit and -1 keeps every upload in memory; dir
+// "" stages in the default temp directory.
+//
+// Staged uploads are not compressed and get no thumbnails or previews.
+// Images the image pipeline or metadata stripping would rewrite must be
+// decoded whole, so they are rejected with ErrFileTooLarge instead.
+func (f *FileStorageManager) SetUploadStaging(limit int64, dir string) {
+	f.uploadMemoryLimit = limit
+	f.stagingDir = dir
+}
+
+// stagesUpload reports whether an upload of the given size is staged
+func (f *FileStorageManager) stagesUpload(size int64) bool {
+	limit := f.stagingLimit()
+	return limit > 0 && size > limit
+}
+
+// stagePayload checks upload content like preparePayload, but writes it to
+// a temp file as it is read, encrypting it into a second one when encryption
+// is enabled. The payload must be released once it has been stored.
+func (f *FileStorageManager) stagePayload(name, claimedType string, src io.Reader) (*uploadPayload, error) {
+	filename, extension := splitFilename(name)
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	mimeType, err := f.detectMimeType(name, claimedType, sniffMimeType(head))
+	if err != nil {
+		return nil, err
+	}
+	if f.imagePipeline.Handles(mimeType) || (f.stripImageMetadata && isImageType(mimeType)) {
+		return nil, fmt.Errorf("%w: images larger than %d bytes cannot be processed", ErrFileTooLarge, f.stagingLimit())
+	}
+	if f.encryptionErr != nil {
+		// Fail closed rather than storing plaintext when encryption is misconfigured
+		return nil, f.encryptionErr
+	}
+
+	payload := &uploadPayload{
+		filename:  filename,
+		extension: extension,
+		mimeType:  mimeType,
+	}
+
+	md5sum, sha256sum := md5.New(), sha256.New()
+	payload.staged, err = f.stageContent(io.MultiReader(bytes.NewReader(head), src), md5sum, sha256sum)
+	if err != nil {
+		return nil, err
+	}
+
+	payload.size, err = payload.staged.Seek(0, io.SeekEnd)
+	if err != nil {
+		payload.release()
+		return nil, err
+	}
+	payload.md5 = hex.EncodeToString(md5sum.Sum(nil))
+	payload.sha256 = hex.EncodeToString(sha256sum.Sum(nil))
+	payload.stagedSize = payload.size
+	payload.stagedMD5 = payload.md5
+	payload.stagedSHA256 = payload.sha256
+
+	// Scan the content exactly as it was received
+	if payload.scan, err = f.scanUpload(io.NewSectionReader(payload.staged, 0, payload.size)); err != nil {
+		payload.release()
+		return nil, err
+	}
+
+	if err := f.encryptStaged(payload); err != nil {
+		payload.release()
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// stageContent copies r to a new temp file, hashing it on the way
+func (f *FileStorageManager) stageContent(r io.Reader, hashes ...hash.Hash) (*os.File, error) {
+	file, err := os.CreateTemp(f.stagingDir, "filestorage-upload-")
+	if err != nil {
+		return nil, err
+	}
+
+	writers := []io.Writer{file}
+	for _, h := range hashes {
+		writers = append(writers, h)
+	}
+
+	if _, err := Copy(io.MultiWriter(writers...), r); err != nil {
+		removeStaged(file)
+		return nil, err
+	}
+	return file, nil
+}
+
+// encryptStaged replaces the staged content of a payload with its
+// encryption when encryption is enabled
+func (f *FileStorageManager) encryptStaged(payload *uploadPayload) error {
+	if f.encryptor == nil {
+		return nil
+	}
+
+	md5sum, sha256sum := md5.New(), sha256.New()
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := f.encryptor.EncryptTo(pw, io.NewSectionReader(payload.staged, 0, payload.size), payload.size)
+		pw.CloseWithError(err)
+	}()
+
+	sealed, err := f.stageContent(pr, md5sum, sha256sum)
+	pr.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+
+	removeStaged(payload.staged)
+	payload.staged = sealed
+	payload.encrypted = true
+
+	if payload.stagedSize, err = sealed.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	payload.stagedMD5 = hex.EncodeToString(md5sum.Sum(nil))
+	payload.stagedSHA256 = hex.EncodeToString(sha256sum.Sum(nil))
+	return nil
+}
+
+// stagingLimit returns the size above which uploads are staged, -1 if none are
+func (f *FileStorageManager) stagingLimit() int64 {
+	if f.uploadMemoryLimit == 0 {
+		return DefaultUploadMemoryLimit
+	}
+	return f.uploadMemoryLimit
+}
+
+// release removes the temp file of a staged payload and gives the memory
+// of a buffered one back to the budget
+func (p *uploadPayload) release() {
+	if p.staged != nil {
+		removeStaged(p.staged)
+		p.staged = nil
+	}
+	if p.reserved != nil {
+		p.reserved()
+		p.reserved = nil
+	}
+}
+
+// removeStaged closes and removes a temp file
+func removeStaged(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
@@ -0,0 +1,200 @@
+// pkg/storage/usage_stats.go
+
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultStatsDays is the number of days UsageStats counts uploads for when none is given
+	DefaultStatsDays = 30
+
+	// TopConsumers is the number of owners UsageStats ranks by bytes stored
+	TopConsumers = 10
+
+	// UsageStatsTTL is how long computed usage statistics are reused, so
+	// dashboards refreshing them do not read every record each time
+	UsageStatsTTL = 5 * time.Minute
+)
+
+// Usage is the number and total size of a group of stored files
+type Usage struct {
+	Name  string `json:"name"`
+	Files int64  `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// BucketUsage is the number and total size of the files in a provider bucket
+type BucketUsage struct {
+	Provider string `json:"provider"`
+	Bucket   string `json:"bucket"`
+	Files    int64  `json:"files"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// DailyUploads counts the files uploaded on a UTC day
+type DailyUploads struct {
+	Date  string `json:"date"` // e.g. "2024-08-17"
+	Files int64  `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// UsageStats summarizes the files recorded in the metadata store. Sizes are
+// content sizes, before compression or encryption.
+type UsageStats struct {
+	Files         int64          `json:"files"`
+	Bytes         int64          `json:"bytes"`
+	Trash         Usage          `json:"trash"` // Soft deleted files, which are part of the totals
+	UploadsPerDay []DailyUploads `json:"uploads_per_day"`
+	Providers     []Usage        `json:"providers"`
+	Buckets       []BucketUsage  `json:"buckets"`
+	Tenants       []Usage        `json:"tenants"`       // By the first segment of the file ID, "" for files without a prefix
+	TopConsumers  []Usage        `json:"top_consumers"` // Owners storing the most bytes
+	ComputedAt    time.Time      `json:"computed_at"`
+}
+
+// usageCache remembers recently computed statistics by number of days
+type usageCache struct {
+	mu    sync.Mutex
+	stats map[int]*UsageStats
+}
+
+// UsageStats reports the uploads of each of the last days (DefaultStatsDays
+// when 0) and the files and bytes stored per provider, bucket, tenant and
+// owner, for capacity planning. It is computed from the metadata store, so
+// files stored without a record are not counted, and reused for
+// UsageStatsTTL. Direct uploads are counted once confirmed.
+func (f *FileStorageManager) UsageStats(days int) (*UsageStats, error) {
+	if days <= 0 {
+		days = DefaultStatsDays
+	}
+
+	lister, ok := f.metadataStore.(FileLister)
+	if !ok {
+		return nil, ErrStatsNotSupported
+	}
+
+	f.usageStats.mu.Lock()
+	cached := f.usageStats.stats[days]
+	f.usageStats.mu.Unlock()
+	if cached != nil && time.Since(cached.ComputedAt) < UsageStatsTTL {
+		return cached, nil
+	}
+
+	records, err := lister.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	stats := computeUsageStats(records, days, time.Now())
+
+	f.usageStats.mu.Lock()
+	f.usageStats.stats[days] = stats
+	f.usageStats.mu.Unlock()
+
+	return stats, nil
+}
+
+// computeUsageStats aggregates records as of now
+func computeUsageStats(records []*FileRecord, days int, now time.Time) *UsageStats {
+	stats := &UsageStats{
+		Trash:         Usage{Name: strings.TrimSuffix(TrashPrefix, "/")},
+		UploadsPerDay: make([]DailyUploads, days),
+		ComputedAt:    now,
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	first := today.AddDate(0, 0, 1-days)
+	for i := range stats.UploadsPerDay {
+		stats.UploadsPerDay[i].Date = first.AddDate(0, 0, i).Format("2006-01-02")
+	}
+
+	providers := map[string]*Usage{}
+	buckets := map[[2]string]*BucketUsage{}
+	tenants := map[string]*Usage{}
+	owners := map[string]*Usage{}
+
+	for _, record := range records {
+		if record.UploadStatus == UploadPending {
+			continue
+		}
+
+		stats.Files++
+		stats.Bytes += record.FileSize
+		if inTrash(record.FileID) {
+			stats.Trash.Files++
+			stats.Trash.Bytes += record.FileSize
+		}
+
+		if created := record.CreatedAt.UTC(); !created.Before(first) {
+			if day := int(created.Sub(first) / (24 * time.Hour)); day < days {
+				stats.UploadsPerDay[day].Files++
+				stats.UploadsPerDay[day].Bytes += record.FileSize
+			}
+		}
+
+		addUsage(providers, record.Provider, record.FileSize)
+
+		key := [2]string{record.Provider, record.Bucket}
+		if buckets[key] == nil {
+			buckets[key] = &BucketUsage{Provider: record.Provider, Bucket: record.Bucket}
+		}
+		buckets[key].Files++
+		buckets[key].Bytes += record.FileSize
+
+		addUsage(tenants, TenantOf(record.FileID), record.FileSize)
+
+		if record.Owner != "" {
+			addUsage(owners, record.Owner, record.FileSize)
+		}
+	}
+
+	stats.Providers = sortedUsage(providers, 0)
+	stats.Tenants = sortedUsage(tenants, 0)
+	stats.TopConsumers = sortedUsage(owners, TopConsumers)
+
+	stats.Buckets = make([]BucketUsage, 0, len(buckets))
+	for _, usage := range buckets {
+		stats.Buckets = append(stats.Buckets, *usage)
+	}
+	sort.Slice(stats.Buckets, func(i, j int) bool {
+		if stats.Buckets[i].Bytes != stats.Buckets[j].Bytes {
+			return stats.Buckets[i].Bytes > stats.Buckets[j].Bytes
+		}
+		return stats.Buckets[i].Provider+"/"+stats.Buckets[i].Bucket < stats.Buckets[j].Provider+"/"+stats.Buckets[j].Bucket
+	})
+
+	return stats
+}
+
+// addUsage counts a file of size bytes towards the group name
+func addUsage(groups map[string]*Usage, name string, size int64) {
+	if groups[name] == nil {
+		groups[name] = &Usage{Name: name}
+	}
+	groups[name].Files++
+	groups[name].Bytes += size
+}
+
+// sortedUsage orders groups by bytes stored, largest first, keeping at most
+// limit of them unless limit is 0
+func sortedUsage(groups map[string]*Usage, limit int) []Usage {
+	sorted := make([]Usage, 0, len(groups))
+	for _, usage := range groups {
+		sorted = append(sorted, *usage)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Bytes != sorted[j].Bytes {
+			return sorted[i].Bytes > sorted[j].Bytes
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
@@ -0,0 +1,249 @@
+// pkg/storage/versions.go
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// VersionPrefix is the key prefix previous versions of replaced files are
+	// kept below while versioning is enabled
+	VersionPrefix = ".versions/"
+
+	// versionIDFormat is the layout of version IDs, the UTC time a version
+	// was replaced, which sort in the order the versions were made
+	versionIDFormat = "20060102T150405.000000000Z"
+)
+
+// FileVersion is a previous version of a file, kept when it was replaced
+type FileVersion struct {
+	VersionID string      `json:"version_id"`
+	FileID    string      `json:"file_id"`
+	Size      int64       `json:"size"` // Stored size, which differs from the file size when encoded
+	ModTime   time.Time   `json:"mod_time"`
+	Record    *FileRecord `json:"record,omitempty"` // Metadata of the version, if recorded
+}
+
+// VersionKey returns the key a previous version of a file is kept under
+func VersionKey(fileID, versionID string) string {
+	return VersionPrefix + fileID + "/" + versionID
+}
+
+// SetVersioning toggles keeping the previous version of files replaced by
+// uploads, below VersionPrefix, so revisions of submissions can be listed,
+// downloaded and restored. Versions are kept when the file is deleted.
+func (f *FileStorageManager) SetVersioning(enabled bool) {
+	f.versioning = enabled
+}
+
+// ReplaceFile uploads a new version of a stored file under its ID, keeping
+// the previous version while versioning is enabled. The owner, ACL, folder,
+// tags and visibility of the file stay as they were.
+func (f *FileStorageManager) ReplaceFile(provider, fileID string, file *multipart.FileHeader, opts UploadOptions) (*FileResponse, error) {
+	opts.Prefix = ""
+	opts.Namer = func(filename, extension string) string { return fileID }
+	opts.replace = true
+
+	switch provider {
+	case ProviderAWS:
+		return f.AwsUploadWithOptions(file, opts)
+	case ProviderGCS:
+		return f.GcsUploadWithOptions(file, opts)
+	}
+
+	return nil, fmt.Errorf("unknown provider %q", provider)
+}
+
+// ListVersions returns the previous versions of a file, newest first
+func (f *FileStorageManager) ListVersions(provider, fileID, bucketname, projectID string) ([]*FileVersion, error) {
+	if bucketname == "" {
+		bucketname = f.defaultBucket(provider)
+	}
+
+	prefix := VersionPrefix + fileID + "/"
+	versions := []*FileVersion{}
+	err := f.walkPrefix(provider, prefix, bucketname, projectID, func(object ObjectInfo) error {
+		versionID := strings.TrimPrefix(object.Key, prefix)
+		if !validVersionID(versionID) {
+			return nil
+		}
+
+		versions = append(versions, &FileVersion{
+			VersionID: versionID,
+			FileID:    fileID,
+			Size:      object.Size,
+			ModTime:   object.ModTime,
+			Record:    f.storedRecord(provider, object.Key),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].VersionID > versions[j].VersionID })
+	return versions, nil
+}
+
+// OpenVersion opens a previous version of a file
+func (f *FileStorageManager) OpenVersion(provider, fileID, versionID, bucketname, projectID string) (*ObjectFile, error) {
+	if !validVersionID(versionID) {
+		return nil, fmt.Errorf("%w: %s of %s", ErrVersionNotFound, versionID, fileID)
+	}
+	if bucketname == "" {
+		bucketname = f.defaultBucket(provider)
+	}
+
+	file, err := f.OpenFile(provider, VersionKey(fileID, versionID), bucketname, projectID)
+	if errors.Is(err, ErrFileNotFound) {
+		return nil, fmt.Errorf("%w: %s of %s", ErrVersionNotFound, versionID, fileID)
+	}
+	return file, err
+}
+
+// RestoreVersion makes a previous version of a file the current one. The
+// version replaced by the restore is kept in turn while versioning is
+// enabled, so restores can be undone.
+func (f *FileStorageManager) RestoreVersion(provider, fileID, versionID, bucketname, projectID string) (*FileResponse, error) {
+	if !validVersionID(versionID) {
+		return nil, fmt.Errorf("%w: %s of %s", ErrVersionNotFound, versionID, fileID)
+	}
+	if bucketname == "" {
+		bucketname = f.defaultBucket(provider)
+	}
+
+	key := VersionKey(fileID, versionID)
+	if _, exists, err := f.Exists(provider, key, bucketname, projectID); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("%w: %s of %s", ErrVersionNotFound, versionID, fileID)
+	}
+
+	if err := f.keepVersion(provider, fileID, bucketname, projectID); err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+	if err := f.copyObject(provider, key, fileID, bucketname, projectID); err != nil {
+		return &FileResponse{
+			Status:  StatusError,
+			Message: err.Error(),
+		}, nil
+	}
+
+	response := &FileResponse{
+		Status:  StatusSuccess,
+		Message: "RESTORE " + fileID + " " + versionID,
+		FileID:  fileID,
+	}
+	if record := f.restoreVersionRecord(key, fileID); record != nil {
+		response.Info = record.FileInfo()
+	}
+	return response, nil
+}
+
+// keepVersion copies the object stored under a file ID, if any, and its
+// record to a new version while versioning is enabled
+func (f *FileStorageManager) keepVersion(provider, fileID, bucketname, projectID string) error {
+	if !f.versioning {
+		return nil
+	}
+
+	key := VersionKey(fileID, time.Now().UTC().Format(versionIDFormat))
+	err := f.copyObject(provider, fileID, key, bucketname, projectID)
+	if errors.Is(err, ErrFileNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("keeping the previous version of %s: %w", fileID, err)
+	}
+
+	if f.metadataStore != nil {
+		if record, err := f.metadataStore.GetFile(fileID); err == nil {
+			// The version is an object of its own, whatever shares the file
+			record.FileID, record.References = key, 0
+			f.saveRecord(record)
+		}
+	}
+	return nil
+}
+
+// restoreVersionRecord records the metadata of a restored version for the
+// file, keeping the owner, ACL, folder, tags and visibility of the file
+func (f *FileStorageManager) restoreVersionRecord(versionKey, fileID string) *FileRecord {
+	if f.metadataStore == nil {
+		return nil
+	}
+
+	f.recordLock.Lock()
+	defer f.recordLock.Unlock()
+
+	record, err := f.metadataStore.GetFile(versionKey)
+	if err != nil {
+		return nil
+	}
+	record.FileID = fileID
+	if current, err := f.metadataStore.GetFile(fileID); err == nil {
+		current.keepAccessOn(record)
+	}
+
+	if err := f.saveRecord(record); err != nil {
+		return nil
+	}
+	return record
+}
+
+// keepAccessOn carries who may access a file, where it is filed and the
+// uploads sharing it over to the record of new content stored under its ID
+func (r *FileRecord) keepAccessOn(record *FileRecord) {
+	if r.Owner != "" {
+		record.Owner = r.Owner
+	}
+	record.ACL = r.ACL
+	record.Folder = r.Folder
+	record.Tags = r.Tags
+	record.Visibility = r.Visibility
+	record.References = r.References
+}
+
+// copyObject copies an object within a bucket, returning ErrFileNotFound
+// when it does not exist
+func (f *FileStorageManager) copyObject(provider, from, to, bucketname, projectID string) error {
+	switch provider {
+	case ProviderAWS:
+		s3Client, err := f.GetAwsClient()
+		if err != nil {
+			return err
+		}
+		return awsCopyObject(s3Client, bucketname, from, to)
+
+	case ProviderGCS:
+		gcsClient, err := f.GetGcsClient(projectID)
+		if err != nil {
+			return err
+		}
+		defer gcsClient.Close()
+		return gcsCopyObject(context.Background(), gcsClient.Bucket(bucketname), from, to)
+	}
+
+	return fmt.Errorf("unknown provider %q", provider)
+}
+
+// validVersionID reports whether a version ID is one made by keepVersion
+func validVersionID(versionID string) bool {
+	_, err := time.Parse(versionIDFormat, versionID)
+	return err == nil
+}
+
+// inVersions reports whether a file ID is the key of a previous version
+func inVersions(fileID string) bool {
+	return strings.HasPrefix(fileID, VersionPrefix)
+}
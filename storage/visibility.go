@@ -0,0 +1,135 @@
+// pkg/storage/visibility.go
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"google.golang.org/api/googleapi"
+)
+
+// Object visibilities
+const (
+	VisibilityPublic  = "public"  // Readable by anyone at its stable public URL
+	VisibilityPrivate = "private" // Only reachable through signed URLs, share links or the proxy
+)
+
+// SetVisibility makes a stored file public or private by updating its object
+// ACL, and records the visibility in the metadata store. Buckets enforcing
+// bucket-level access control reject object ACLs, which is returned as an error.
+func (f *FileStorageManager) SetVisibility(provider, fileID, bucketname, projectID, visibility string) (*FileResponse, error) {
+	if visibility != VisibilityPublic && visibility != VisibilityPrivate {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidVisibility, visibility)
+	}
+
+	var publicURL string
+	switch provider {
+	case ProviderAWS:
+		// Use default bucket if not specified
+		if bucketname == "" {
+			bucketname = f.config.AWSBucket
+		}
+
+		if err := f.awsSetVisibility(fileID, bucketname, visibility); err != nil {
+			return nil, err
+		}
+		publicURL = f.awsPublicURL(bucketname, fileID)
+
+	case ProviderGCS:
+		// Use default bucket if not specified
+		if bucketname == "" {
+			bucketname = f.config.GCSBucket
+		}
+
+		if err := f.gcsSetVisibility(fileID, bucketname, projectID, visibility); err != nil {
+			return nil, err
+		}
+		publicURL = gcsPublicURL(bucketname, fileID)
+
+	default:
+		return nil, fmt.Errorf("unknown provider %q", provider)
+	}
+
+	info := &FileInfo{FileID: fileID, Visibility: visibility}
+	if visibility == VisibilityPublic {
+		info.PublicLink = publicURL
+	}
+
+	if f.metadataStore != nil {
+		if record, err := f.metadataStore.GetFile(fileID); err == nil {
+			record.Visibility = visibility
+			if err := f.saveRecord(record); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &FileResponse{
+		Status:  StatusSuccess,
+		Message: "VISIBILITY " + visibility,
+		FileID:  fileID,
+		Info:    info,
+	}, nil
+}
+
+// awsSetVisibility applies the canned ACL of a visibility to an S3 object
+func (f *FileStorageManager) awsSetVisibility(awsFileID, bucketname, visibility string) error {
+	s3Client, err := f.GetAwsClient()
+	if err != nil {
+		return err
+	}
+
+	acl := s3.ObjectCannedACLPrivate
+	if visibility == VisibilityPublic {
+		acl = s3.ObjectCannedACLPublicRead
+	}
+
+	_, err = s3Client.PutObjectAcl(&s3.PutObjectAclInput{
+		Bucket: aws.String(bucketname),
+		Key:    aws.String(awsFileID),
+		ACL:    aws.String(acl),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, awsFileID)
+	}
+	return err
+}
+
+// gcsSetVisibility grants or revokes public read access to a GCS object
+func (f *FileStorageManager) gcsSetVisibility(gcsFileID, bucketname, projectID, visibility string) error {
+	ctx := context.Background()
+
+	gcsClient, err := f.GetGcsClient(projectID)
+	if err != nil {
+		return err
+	}
+	defer gcsClient.Close()
+
+	obj := gcsClient.Bucket(bucketname).Object(gcsFileID)
+	if _, err := obj.Attrs(ctx); err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return fmt.Errorf("%w: %s", ErrFileNotFound, gcsFileID)
+		}
+		return err
+	}
+
+	acl := obj.ACL()
+	if visibility == VisibilityPublic {
+		return acl.Set(ctx, storage.AllUsers, storage.RoleReader)
+	}
+
+	// Revoking a grant that does not exist is not an error
+	err = acl.Delete(ctx, storage.AllUsers)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
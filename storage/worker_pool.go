@@ -0,0 +1,122 @@
+// pkg/storage/worker_pool.go
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultBatchConcurrency is the number of files batch deletes and lookups
+// process at the same time
+const DefaultBatchConcurrency = 8
+
+// WorkerPool processes the items of a bulk operation with a bounded number
+// of goroutines, however many items there are
+type WorkerPool struct {
+	workers int
+}
+
+// ItemError is the error of one item of a bulk operation
+type ItemError struct {
+	Index int // Position of the item in the batch
+	Err   error
+}
+
+// ItemErrors are the errors of the items of a bulk operation that failed,
+// ordered by their position in the batch
+type ItemErrors []ItemError
+
+// NewWorkerPool creates a pool running at most workers items at the same
+// time, one if workers is not positive
+func NewWorkerPool(workers int) *WorkerPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &WorkerPool{workers: workers}
+}
+
+// Run calls fn with the index of each of n items, on at most the workers of
+// the pool at the same time, and returns once every item was processed. The
+// errors returned by fn are collected per item rather than stopping the
+// others; nil means every item succeeded.
+func (p *WorkerPool) Run(n int, fn func(i int) error) ItemErrors {
+	var (
+		mu     sync.Mutex
+		failed ItemErrors
+		wg     sync.WaitGroup
+	)
+	indexes := make(chan int)
+
+	for w := 0; w < p.workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(i); err != nil {
+					mu.Lock()
+					failed = append(failed, ItemError{Index: i, Err: err})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Index < failed[j].Index })
+	return failed
+}
+
+// Error implements error
+func (e ItemErrors) Error() string {
+	if len(e) == 1 {
+		return fmt.Sprintf("item %d failed: %v", e[0].Index, e[0].Err)
+	}
+	return fmt.Sprintf("%d items failed, the first, item %d: %v", len(e), e[0].Index, e[0].Err)
+}
+
+// Unwrap returns the errors of the items, for errors.Is and errors.As
+func (e ItemErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, item := range e {
+		errs[i] = item.Err
+	}
+	return errs
+}
+
+// Err returns the errors as an error, nil when no item failed
+func (e ItemErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// SetBatchConcurrency sets the number of files batch deletes and lookups
+// process at the same time, 0 falls back to DefaultBatchConcurrency
+func (f *FileStorageManager) SetBatchConcurrency(concurrency int) {
+	f.batchConcurrency = concurrency
+}
+
+// batchPool returns a worker pool for batch deletes and lookups
+func (f *FileStorageManager) batchPool() *WorkerPool {
+	if f.batchConcurrency <= 0 {
+		return NewWorkerPool(DefaultBatchConcurrency)
+	}
+	return NewWorkerPool(f.batchConcurrency)
+}
+
+// resultErr returns the error of a batch result, nil when it succeeded
+func resultErr(result *FileResult) error {
+	if result.Status == StatusSuccess {
+		return nil
+	}
+	return errors.New(result.Message)
+}